@@ -10,12 +10,21 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/savegress/finsight/internal/aml"
 	"github.com/savegress/finsight/internal/api"
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/audit"
 	"github.com/savegress/finsight/internal/config"
 	"github.com/savegress/finsight/internal/fraud"
+	"github.com/savegress/finsight/internal/fx"
 	"github.com/savegress/finsight/internal/reconciliation"
+	"github.com/savegress/finsight/internal/regulatory"
 	"github.com/savegress/finsight/internal/reporting"
 	"github.com/savegress/finsight/internal/transactions"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+
+	_ "time/tzdata" // scheduled report timezones
 )
 
 func main() {
@@ -24,22 +33,94 @@ func main() {
 	// Load configuration
 	cfg := loadConfig()
 
+	// Initialize FX converter
+	fxConverter := fx.NewConverter(&cfg.FX)
+
 	// Initialize transaction engine
 	txnEngine := transactions.NewEngine(&cfg.Transactions)
+	txnEngine.SetConverter(fxConverter)
 
 	// Initialize fraud detector
 	fraudDetector := fraud.NewDetector(&cfg.Fraud)
+	fraudDetector.SetConverter(fxConverter)
+	if cfg.Fraud.MLModelPath != "" {
+		if _, err := fraudDetector.LoadModel(cfg.Fraud.MLModelPath, fraud.ModelRoleChampion); err != nil {
+			log.Fatalf("Failed to load fraud model: %v", err)
+		}
+	}
+	for _, path := range cfg.Fraud.MLChallengerPaths {
+		if _, err := fraudDetector.LoadModel(path, fraud.ModelRoleChallenger); err != nil {
+			log.Fatalf("Failed to load challenger model: %v", err)
+		}
+	}
+	if cfg.Fraud.GeoIPDatabase != "" {
+		if _, err := fraudDetector.LoadGeoIP(cfg.Fraud.GeoIPDatabase); err != nil {
+			log.Fatalf("Failed to load GeoIP database: %v", err)
+		}
+	}
+	if cfg.Fraud.RulesPath != "" {
+		if _, err := fraudDetector.Rules().Reload(); err != nil {
+			log.Fatalf("Failed to load fraud rules: %v", err)
+		}
+	}
+
+	// Initialize AML engine
+	amlEngine := aml.NewEngine(&aml.Config{
+		Enabled:            cfg.Compliance.AMLEnabled,
+		CTRThreshold:       decimal.NewFromFloat(cfg.Compliance.CTRThreshold),
+		WatchlistFeeds:     watchlistFeeds(cfg.Compliance),
+		WatchlistRefresh:   cfg.Compliance.Watchlist.Refresh,
+		WatchlistThreshold: cfg.Compliance.Watchlist.Threshold,
+		WatchlistMaxShrink: cfg.Compliance.Watchlist.MaxShrink,
+		GraphWindow:        cfg.Compliance.Graph.Window,
+		GraphFanThreshold:  cfg.Compliance.Graph.FanThreshold,
+		GraphCycleDepth:    cfg.Compliance.Graph.CycleDepth,
+
+		CaseAssignmentSLA:       cfg.Compliance.Cases.AssignmentSLA,
+		CaseSARSLA:              cfg.Compliance.Cases.SARSLA,
+		CaseQueues:              cfg.Compliance.Cases.Queues,
+		MaxCasesPerInvestigator: cfg.Compliance.Cases.MaxCasesPerInvestigator,
+		EvidenceDir:             cfg.Compliance.Cases.EvidenceDir,
+		BSA:                     bsaConfig(cfg.Compliance.BSA),
+	})
+	amlEngine.SetConverter(fxConverter)
 
 	// Initialize reconciliation engine
 	reconEngine := reconciliation.NewEngine(&cfg.Reconciliation)
 
+	// Initialize maker-checker approvals
+	approvals := approval.NewWorkflow(&cfg.Compliance.Approvals)
+	amlEngine.SetApprovals(approvals)
+	reconEngine.SetApprovals(approvals)
+
+	// Initialize regulatory reporting
+	regEngine := regulatory.NewEngine(cfg.Regulatory.OutputDir)
+	regEngine.SetReportingEntity(cfg.Regulatory.EntityID)
+	regEngine.SetApprovals(approvals)
+
 	// Initialize report generator
 	reportGen := reporting.NewGenerator(&cfg.Reporting)
 
+	// Initialize report scheduler
+	reportScheduler := reporting.NewScheduler(&cfg.Reporting, reportGen, &reportSource{txn: txnEngine, fraud: fraudDetector})
+
+	// Initialize audit trail
+	auditTrail, err := audit.NewTrail(&cfg.Compliance)
+	if err != nil {
+		log.Fatalf("Failed to open audit trail: %v", err)
+	}
+	if result := auditTrail.Verify(); !result.Valid {
+		log.Printf("Audit trail verification failed at sequence %d: %s", result.BrokenAt, result.Error)
+	}
+
 	// Start engines
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if err := fxConverter.Start(ctx); err != nil {
+		log.Fatalf("Failed to start FX converter: %v", err)
+	}
+
 	if err := txnEngine.Start(ctx); err != nil {
 		log.Fatalf("Failed to start transaction engine: %v", err)
 	}
@@ -48,12 +129,30 @@ func main() {
 		log.Fatalf("Failed to start fraud detector: %v", err)
 	}
 
+	if err := amlEngine.Start(ctx); err != nil {
+		log.Fatalf("Failed to start AML engine: %v", err)
+	}
+
 	if err := reconEngine.Start(ctx); err != nil {
 		log.Fatalf("Failed to start reconciliation engine: %v", err)
 	}
 
+	if err := approvals.Start(ctx); err != nil {
+		log.Fatalf("Failed to start approval workflow: %v", err)
+	}
+
+	if err := auditTrail.Start(ctx); err != nil {
+		log.Fatalf("Failed to start audit trail: %v", err)
+	}
+
+	if cfg.Reporting.Enabled {
+		if err := reportScheduler.Start(ctx); err != nil {
+			log.Fatalf("Failed to start report scheduler: %v", err)
+		}
+	}
+
 	// Create API server
-	server := api.NewServer(cfg, txnEngine, fraudDetector, reconEngine, reportGen)
+	server := api.NewServer(cfg, txnEngine, fraudDetector, reconEngine, reportGen, fxConverter, reportScheduler, amlEngine, regEngine, auditTrail, approvals)
 
 	// Start HTTP server
 	httpServer := &http.Server{
@@ -89,11 +188,73 @@ func main() {
 
 	txnEngine.Stop()
 	fraudDetector.Stop()
+	amlEngine.Stop()
 	reconEngine.Stop()
+	reportScheduler.Stop()
+	fxConverter.Stop()
+	approvals.Stop()
+	auditTrail.Stop()
 
 	log.Println("FinSight stopped")
 }
 
+// reportSource feeds scheduled reports from the transaction engine and the
+// fraud detector
+type reportSource struct {
+	txn   *transactions.Engine
+	fraud *fraud.Detector
+}
+
+func (s *reportSource) Transactions(start, end time.Time) []*models.Transaction {
+	return s.txn.GetTransactions(transactions.TransactionFilter{StartDate: &start, EndDate: &end})
+}
+
+func (s *reportSource) FraudAlerts(start, end time.Time) []*models.FraudAlert {
+	return s.fraud.GetAlerts(fraud.AlertFilter{StartDate: &start, EndDate: &end})
+}
+
+// bsaConfig maps the BSA E-Filing transmitter settings to the AML engine's,
+// leaving the address unset when none is configured
+func bsaConfig(c config.BSAConfig) aml.BSAConfig {
+	bsa := aml.BSAConfig{
+		TransmitterControlCode: c.TransmitterControlCode,
+		TransmitterName:        c.TransmitterName,
+		TransmitterTIN:         c.TransmitterTIN,
+		TransmitterPhone:       c.TransmitterPhone,
+		ContactName:            c.ContactName,
+		PrimaryRegulator:       c.PrimaryRegulator,
+	}
+	if a := c.TransmitterAddress; a.Line1 != "" || a.City != "" {
+		bsa.TransmitterAddress = &aml.Address{
+			Line1:      a.Line1,
+			Line2:      a.Line2,
+			City:       a.City,
+			State:      a.State,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		}
+	}
+	return bsa
+}
+
+// watchlistFeeds maps the sanctions list sources to the AML engine's; none
+// are refreshed unless watchlist screening is enabled
+func watchlistFeeds(c config.ComplianceConfig) []aml.WatchlistFeed {
+	if !c.WatchlistEnabled {
+		return nil
+	}
+	var feeds []aml.WatchlistFeed
+	for _, f := range c.Watchlist.Feeds {
+		feeds = append(feeds, aml.WatchlistFeed{
+			Format:      f.Format,
+			URL:         f.URL,
+			AliasURL:    f.AliasURL,
+			AllowShrink: f.AllowShrink,
+		})
+	}
+	return feeds
+}
+
 func loadConfig() *config.Config {
 	configPath := os.Getenv("FINSIGHT_CONFIG")
 	if configPath != "" {
@@ -0,0 +1,1180 @@
+package aml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BSA E-Filing form type codes
+const (
+	BSAFormSAR = "SARX"
+	BSAFormCTR = "CTRX"
+)
+
+const (
+	bsaNamespace      = "www.fincen.gov/base"
+	bsaXSINamespace   = "http://www.w3.org/2001/XMLSchema-instance"
+	bsaNarrativeChunk = 4000
+	bsaNarrativeMax   = 5 * bsaNarrativeChunk
+)
+
+// Activity party type codes
+const (
+	bsaPartyContactOffice      = "8"
+	bsaPartyOnBehalfOf         = "23"
+	bsaPartyConductorForOther  = "17"
+	bsaPartyFilingInstitution  = "30"
+	bsaPartySubject            = "33"
+	bsaPartyActivityLocation   = "34"
+	bsaPartyTransmitter        = "35"
+	bsaPartyTransmitterContact = "37"
+	bsaPartyAccountInstitution = "41"
+	bsaPartyConductorOwn       = "50"
+)
+
+// Party identification type codes
+const (
+	bsaIDSSN        = "1"
+	bsaIDEIN        = "2"
+	bsaIDTIN        = "4"
+	bsaIDDriversLic = "5"
+	bsaIDPassport   = "6"
+	bsaIDAlienReg   = "7"
+	bsaIDTCC        = "28"
+	bsaIDOther      = "999"
+)
+
+// bsaInstitutionIDTypes maps FilingInstitution.IDType to its code
+var bsaInstitutionIDTypes = map[string]string{
+	"crd":  "10",
+	"iard": "11",
+	"nfa":  "12",
+	"naic": "13",
+	"rssd": "14",
+	"sec":  "29",
+}
+
+// bsaPersonIDTypes maps subject and CTR person ID types to their codes
+var bsaPersonIDTypes = map[string]string{
+	"drivers_license": bsaIDDriversLic,
+	"passport":        bsaIDPassport,
+	"alien_id":        bsaIDAlienReg,
+	"other":           bsaIDOther,
+}
+
+// bsaActivityCategories maps SuspiciousActivity.Categories to the SAR
+// activity type and the subtype reported when no finer code is known
+var bsaActivityCategories = map[string]struct{ typeID, subtypeID string }{
+	"structuring":         {"1", "111"},
+	"terrorist_financing": {"2", "999"},
+	"fraud":               {"3", "320"},
+	"gaming":              {"4", "411"},
+	"money_laundering":    {"5", "516"},
+	"identification":      {"6", "607"},
+	"other":               {"7", "999"},
+	"insurance":           {"8", "899"},
+	"securities":          {"9", "999"},
+	"mortgage_fraud":      {"10", "999"},
+	"cyber":               {"11", "999"},
+}
+
+// BSAConfig identifies the transmitter of BSA E-Filing batches
+type BSAConfig struct {
+	TransmitterControlCode string   `json:"transmitter_control_code"` // TCC issued by FinCEN
+	TransmitterName        string   `json:"transmitter_name"`
+	TransmitterTIN         string   `json:"transmitter_tin"`
+	TransmitterAddress     *Address `json:"transmitter_address"`
+	TransmitterPhone       string   `json:"transmitter_phone"`
+	ContactName            string   `json:"contact_name"`
+	PrimaryRegulator       string   `json:"primary_regulator"` // FinCEN regulator code of the filing institution
+}
+
+// BSAFieldError is a validation failure on a single report field
+type BSAFieldError struct {
+	ReportID string `json:"report_id"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+// BSAValidationError lists every field that blocks a filing
+type BSAValidationError struct {
+	Errors []BSAFieldError `json:"errors"`
+}
+
+func (e *BSAValidationError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		parts = append(parts, fmt.Sprintf("%s: %s %s", fe.ReportID, fe.Field, fe.Message))
+	}
+	return fmt.Sprintf("BSA validation failed with %d errors: %s", len(e.Errors), strings.Join(parts, "; "))
+}
+
+// BSABatch is a generated BSA E-Filing batch file
+type BSABatch struct {
+	ID              string              `json:"id"`
+	FormType        string              `json:"form_type"`
+	FileName        string              `json:"file_name"`
+	ReportIDs       []string            `json:"report_ids"`
+	Activities      map[int]string      `json:"activities"` // Activity SeqNum -> report ID
+	TotalAmount     decimal.Decimal     `json:"total_amount"`
+	Content         []byte              `json:"-"`
+	Status          string              `json:"status"` // submitted, accepted, partially_accepted, rejected
+	CreatedAt       time.Time           `json:"created_at"`
+	AcknowledgedAt  *time.Time          `json:"acknowledged_at,omitempty"`
+	Acknowledgement *BSAAcknowledgement `json:"acknowledgement,omitempty"`
+}
+
+// ValidateSAR checks a SAR against the BSA E-Filing field rules
+func ValidateSAR(sar *SuspiciousActivityReport) []BSAFieldError {
+	v := &bsaValidator{reportID: sar.ID}
+
+	switch sar.FilingType {
+	case "", "initial", "joint":
+	case "continuing", "correction":
+		v.required("prior_bsa_identifier", sar.PriorBSAIdentifier)
+	default:
+		v.add("filing_type", "must be initial, continuing, joint or correction")
+	}
+	if sar.PriorBSAIdentifier != "" {
+		v.digits("prior_bsa_identifier", sar.PriorBSAIdentifier, 14)
+	}
+
+	v.institution("filing_institution", sar.FilingInstitution)
+
+	if s := sar.Subject; s == nil {
+		v.add("subject", "is required")
+	} else {
+		v.required("subject.name", s.Name)
+		v.maxLen("subject.name", s.Name, 150)
+		for i, alt := range s.AlternateNames {
+			v.maxLen(fmt.Sprintf("subject.alternate_names[%d]", i), alt, 150)
+		}
+		if s.TIN != "" {
+			v.digits("subject.tin", s.TIN, 9)
+		}
+		if s.SSN != "" {
+			v.digits("subject.ssn", s.SSN, 9)
+		}
+		if s.DateOfBirth != "" {
+			v.date("subject.date_of_birth", s.DateOfBirth)
+		}
+		if s.Address != nil {
+			v.address("subject.address", s.Address)
+		}
+		if s.IDNumber != "" {
+			if _, ok := bsaPersonIDTypes[s.IDType]; !ok {
+				v.add("subject.id_type", "must be drivers_license, passport, alien_id or other")
+			}
+			v.maxLen("subject.id_number", s.IDNumber, 25)
+		}
+		for i, acct := range s.AccountNumbers {
+			v.maxLen(fmt.Sprintf("subject.account_numbers[%d]", i), acct, 40)
+		}
+		for i, phone := range s.PhoneNumbers {
+			v.phone(fmt.Sprintf("subject.phone_numbers[%d]", i), phone)
+		}
+		v.maxLen("subject.occupation", s.Occupation, 50)
+	}
+
+	if a := sar.SuspiciousActivity; a == nil || len(a.Categories) == 0 {
+		v.add("suspicious_activity.categories", "at least one category is required")
+	} else {
+		for i, c := range a.Categories {
+			if _, ok := bsaActivityCategories[c]; !ok {
+				v.add(fmt.Sprintf("suspicious_activity.categories[%d]", i), fmt.Sprintf("unknown category %q", c))
+			}
+		}
+	}
+
+	if sar.DateRange == nil || sar.DateRange.Start.IsZero() || sar.DateRange.End.IsZero() {
+		v.add("date_range", "start and end are required")
+	} else {
+		if sar.DateRange.End.Before(sar.DateRange.Start) {
+			v.add("date_range", "end is before start")
+		}
+		if sar.DateRange.End.After(time.Now()) {
+			v.add("date_range.end", "is in the future")
+		}
+	}
+	if sar.TotalAmount.IsNegative() {
+		v.add("total_amount", "must not be negative")
+	}
+
+	v.required("narrative", sar.Narrative)
+	if n := len([]rune(sar.Narrative)); n > bsaNarrativeMax {
+		v.add("narrative", fmt.Sprintf("is %d characters, the limit is %d", n, bsaNarrativeMax))
+	}
+	return v.errs
+}
+
+// ValidateCTR checks a CTR against the BSA E-Filing field rules
+func ValidateCTR(ctr *CurrencyTransactionReport) []BSAFieldError {
+	v := &bsaValidator{reportID: ctr.ID}
+
+	if ctr.PriorBSAIdentifier != "" {
+		v.digits("prior_bsa_identifier", ctr.PriorBSAIdentifier, 14)
+	}
+	v.institution("filing_institution", ctr.FilingInstitution)
+
+	if ctr.TransactionDate.IsZero() {
+		v.add("transaction_date", "is required")
+	} else if ctr.TransactionDate.After(time.Now()) {
+		v.add("transaction_date", "is in the future")
+	}
+
+	if len(ctr.Transactions) == 0 {
+		v.add("transactions", "at least one transaction is required")
+	}
+	cashIn, cashOut := decimal.Zero, decimal.Zero
+	for i, t := range ctr.Transactions {
+		field := fmt.Sprintf("transactions[%d]", i)
+		switch t.Type {
+		case "cash_in":
+			cashIn = cashIn.Add(t.Amount)
+		case "cash_out":
+			cashOut = cashOut.Add(t.Amount)
+		default:
+			v.add(field+".type", "must be cash_in or cash_out")
+		}
+		if !t.Amount.IsPositive() {
+			v.add(field+".amount", "must be positive")
+		}
+		v.maxLen(field+".account_number", t.AccountNumber, 40)
+	}
+	if !ctr.TotalCashIn.Equal(cashIn) {
+		v.add("total_cash_in", fmt.Sprintf("is %s but cash_in transactions total %s", ctr.TotalCashIn, cashIn))
+	}
+	if !ctr.TotalCashOut.Equal(cashOut) {
+		v.add("total_cash_out", fmt.Sprintf("is %s but cash_out transactions total %s", ctr.TotalCashOut, cashOut))
+	}
+	threshold := decimal.NewFromInt(10000)
+	if !ctr.TotalCashIn.GreaterThan(threshold) && !ctr.TotalCashOut.GreaterThan(threshold) {
+		v.add("total_cash_in", "cash in or cash out must exceed $10,000")
+	}
+
+	if len(ctr.Persons) == 0 {
+		v.add("persons", "at least one person is required")
+	}
+	for i, p := range ctr.Persons {
+		field := fmt.Sprintf("persons[%d]", i)
+		if _, ok := bsaCTRRoles[p.Role]; !ok {
+			v.add(field+".role", "must be conductor, agent or beneficiary")
+		}
+		v.required(field+".name", p.Name)
+		v.maxLen(field+".name", p.Name, 150)
+		if p.DateOfBirth != "" {
+			v.date(field+".date_of_birth", p.DateOfBirth)
+		}
+		if p.SSN != "" {
+			v.digits(field+".ssn", p.SSN, 9)
+		}
+		if p.Address == nil {
+			v.add(field+".address", "is required")
+		} else {
+			v.address(field+".address", p.Address)
+		}
+		if p.Role != "beneficiary" {
+			if _, ok := bsaPersonIDTypes[p.IDType]; !ok {
+				v.add(field+".id_type", "must be drivers_license, passport, alien_id or other")
+			}
+			v.required(field+".id_number", p.IDNumber)
+		}
+		v.maxLen(field+".id_number", p.IDNumber, 25)
+		v.maxLen(field+".occupation", p.Occupation, 50)
+	}
+	return v.errs
+}
+
+// bsaCTRRoles maps CTRPerson.Role to its activity party type code
+var bsaCTRRoles = map[string]string{
+	"conductor":   bsaPartyConductorOwn,
+	"agent":       bsaPartyConductorForOther,
+	"beneficiary": bsaPartyOnBehalfOf,
+}
+
+var bsaStatePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+type bsaValidator struct {
+	reportID string
+	errs     []BSAFieldError
+}
+
+func (v *bsaValidator) add(field, message string) {
+	v.errs = append(v.errs, BSAFieldError{ReportID: v.reportID, Field: field, Message: message})
+}
+
+func (v *bsaValidator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *bsaValidator) maxLen(field, value string, n int) {
+	if len([]rune(value)) > n {
+		v.add(field, fmt.Sprintf("exceeds %d characters", n))
+	}
+}
+
+func (v *bsaValidator) digits(field, value string, lengths ...int) {
+	d := digitsOnly(value)
+	for _, n := range lengths {
+		if len(d) == n && len(d) == len(strings.ReplaceAll(value, "-", "")) {
+			return
+		}
+	}
+	v.add(field, fmt.Sprintf("must be %s digits", joinInts(lengths, " or ")))
+}
+
+func (v *bsaValidator) date(field, value string) {
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		v.add(field, "must be a date in YYYY-MM-DD format")
+	}
+}
+
+func (v *bsaValidator) phone(field, value string) {
+	if n := len(digitsOnly(value)); n < 10 || n > 16 {
+		v.add(field, "must have 10 to 16 digits")
+	}
+}
+
+func (v *bsaValidator) address(field string, a *Address) {
+	v.required(field+".line1", a.Line1)
+	v.maxLen(field+".line1", a.Line1, 100)
+	v.required(field+".city", a.City)
+	v.maxLen(field+".city", a.City, 50)
+	if len(a.Country) != 2 {
+		v.add(field+".country", "must be a 2-letter ISO country code")
+	}
+	if strings.EqualFold(a.Country, "US") {
+		if !bsaStatePattern.MatchString(a.State) {
+			v.add(field+".state", "must be a 2-letter state code")
+		}
+		v.digits(field+".postal_code", a.PostalCode, 5, 9)
+	} else {
+		v.maxLen(field+".postal_code", a.PostalCode, 9)
+	}
+}
+
+func (v *bsaValidator) institution(field string, fi *FilingInstitution) {
+	if fi == nil {
+		v.add(field, "is required")
+		return
+	}
+	v.required(field+".name", fi.Name)
+	v.maxLen(field+".name", fi.Name, 150)
+	v.digits(field+".tin", fi.TIN, 9)
+	if _, ok := bsaInstitutionIDTypes[strings.ToLower(fi.IDType)]; !ok {
+		v.add(field+".id_type", "must be one of crd, iard, naic, nfa, rssd or sec")
+	}
+	v.required(field+".id_number", fi.IDNumber)
+	v.maxLen(field+".id_number", fi.IDNumber, 25)
+	if fi.Address == nil {
+		v.add(field+".address", "is required")
+	} else {
+		v.address(field+".address", fi.Address)
+	}
+	v.required(field+".contact_name", fi.ContactName)
+	v.phone(field+".contact_phone", fi.ContactPhone)
+}
+
+func validateBSAConfig(cfg BSAConfig) []BSAFieldError {
+	v := &bsaValidator{reportID: "transmitter"}
+	if len(cfg.TransmitterControlCode) != 8 {
+		v.add("transmitter_control_code", "must be the 8 character TCC")
+	}
+	v.required("transmitter_name", cfg.TransmitterName)
+	v.digits("transmitter_tin", cfg.TransmitterTIN, 9)
+	v.phone("transmitter_phone", cfg.TransmitterPhone)
+	v.required("contact_name", cfg.ContactName)
+	if cfg.TransmitterAddress == nil {
+		v.add("transmitter_address", "is required")
+	} else {
+		v.address("transmitter_address", cfg.TransmitterAddress)
+	}
+	return v.errs
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func joinInts(values []int, sep string) string {
+	parts := make([]string, len(values))
+	for i, n := range values {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, sep)
+}
+
+// XML document model. Element order follows the SARX and CTRX batch
+// schemas; every element carrying SeqNum is numbered in document order.
+
+type bsaBatchXML struct {
+	XMLName                 xml.Name         `xml:"fc2:EFilingBatchXML"`
+	NS                      string           `xml:"xmlns:fc2,attr"`
+	XSI                     string           `xml:"xmlns:xsi,attr"`
+	SchemaLocation          string           `xml:"xsi:schemaLocation,attr"`
+	ActivityAttachmentCount *int             `xml:"ActivityAttachmentCount,attr"`
+	AttachmentCount         *int             `xml:"AttachmentCount,attr"`
+	ActivityCount           int              `xml:"ActivityCount,attr"`
+	TotalAmount             string           `xml:"TotalAmount,attr"`
+	PartyCount              int              `xml:"PartyCount,attr"`
+	FormTypeCode            string           `xml:"fc2:FormTypeCode"`
+	Activities              []bsaActivityXML `xml:"fc2:Activity"`
+}
+
+type bsaActivityXML struct {
+	SeqNum              int               `xml:"SeqNum,attr"`
+	PriorDocumentNumber string            `xml:"fc2:EFilingPriorDocumentNumber,omitempty"`
+	FilingDateText      string            `xml:"fc2:FilingDateText"`
+	Association         bsaAssociationXML `xml:"fc2:ActivityAssociation"`
+	Parties             []*bsaPartyXML    `xml:"fc2:Party"`
+	SuspiciousActivity  *bsaSuspiciousXML `xml:"fc2:SuspiciousActivity,omitempty"`
+	CurrencyActivity    *bsaCurrencyXML   `xml:"fc2:CurrencyTransactionActivity,omitempty"`
+	Narratives          []bsaNarrativeXML `xml:"fc2:ActivityNarrativeInformation"`
+}
+
+type bsaAssociationXML struct {
+	SeqNum              int    `xml:"SeqNum,attr"`
+	ContinuingActivity  string `xml:"fc2:ContinuingActivityReportIndicator,omitempty"`
+	CorrectsAmendsPrior string `xml:"fc2:CorrectsAmendsPriorReportIndicator,omitempty"`
+	InitialReport       string `xml:"fc2:InitialReportIndicator,omitempty"`
+	JointReport         string `xml:"fc2:JointReportIndicator,omitempty"`
+}
+
+type bsaPartyXML struct {
+	SeqNum                int                    `xml:"SeqNum,attr"`
+	ActivityPartyTypeCode string                 `xml:"fc2:ActivityPartyTypeCode"`
+	BirthDateText         string                 `xml:"fc2:IndividualBirthDateText,omitempty"`
+	EntityIndicator       string                 `xml:"fc2:PartyAsEntityOrganizationIndicator,omitempty"`
+	PrimaryRegulator      string                 `xml:"fc2:PrimaryRegulatorTypeCode,omitempty"`
+	Names                 []bsaPartyNameXML      `xml:"fc2:PartyName"`
+	Address               *bsaAddressXML         `xml:"fc2:Address,omitempty"`
+	Phone                 *bsaPhoneXML           `xml:"fc2:PhoneNumber,omitempty"`
+	Identifications       []bsaIdentificationXML `xml:"fc2:PartyIdentification"`
+	Occupation            *bsaOccupationXML      `xml:"fc2:PartyOccupationBusiness,omitempty"`
+	ElectronicAddresses   []bsaElectronicXML     `xml:"fc2:ElectronicAddress"`
+	AccountAssociation    *bsaAccountAssocXML    `xml:"fc2:PartyAccountAssociation,omitempty"`
+}
+
+type bsaPartyNameXML struct {
+	SeqNum    int    `xml:"SeqNum,attr"`
+	TypeCode  string `xml:"fc2:PartyNameTypeCode"` // L legal, AKA also known as
+	LastName  string `xml:"fc2:RawEntityIndividualLastName,omitempty"`
+	FirstName string `xml:"fc2:RawIndividualFirstName,omitempty"`
+	FullName  string `xml:"fc2:RawPartyFullName,omitempty"`
+}
+
+type bsaAddressXML struct {
+	SeqNum  int    `xml:"SeqNum,attr"`
+	City    string `xml:"fc2:RawCityText"`
+	Country string `xml:"fc2:RawCountryCodeText"`
+	State   string `xml:"fc2:RawStateCodeText,omitempty"`
+	Street  string `xml:"fc2:RawStreetAddress1Text"`
+	ZIP     string `xml:"fc2:RawZIPCode,omitempty"`
+}
+
+type bsaPhoneXML struct {
+	SeqNum int    `xml:"SeqNum,attr"`
+	Number string `xml:"fc2:PhoneNumberText"`
+}
+
+type bsaIdentificationXML struct {
+	SeqNum   int    `xml:"SeqNum,attr"`
+	Country  string `xml:"fc2:OtherIssuerCountryText,omitempty"`
+	State    string `xml:"fc2:OtherIssuerStateText,omitempty"`
+	Number   string `xml:"fc2:PartyIdentificationNumberText"`
+	TypeCode string `xml:"fc2:PartyIdentificationTypeCode"`
+}
+
+type bsaOccupationXML struct {
+	SeqNum int    `xml:"SeqNum,attr"`
+	Text   string `xml:"fc2:OccupationBusinessText"`
+}
+
+type bsaElectronicXML struct {
+	SeqNum   int    `xml:"SeqNum,attr"`
+	Text     string `xml:"fc2:ElectronicAddressText"`
+	TypeCode string `xml:"fc2:ElectronicAddressTypeCode"` // E email
+}
+
+type bsaAccountAssocXML struct {
+	SeqNum   int                `xml:"SeqNum,attr"`
+	TypeCode string             `xml:"fc2:PartyAccountAssociationTypeCode"`
+	Party    bsaAccountPartyXML `xml:"fc2:Party"`
+}
+
+type bsaAccountPartyXML struct {
+	SeqNum          int                    `xml:"SeqNum,attr"`
+	TypeCode        string                 `xml:"fc2:ActivityPartyTypeCode"`
+	Identifications []bsaIdentificationXML `xml:"fc2:PartyIdentification"`
+	Accounts        []bsaAccountXML        `xml:"fc2:Account"`
+}
+
+type bsaAccountXML struct {
+	SeqNum int    `xml:"SeqNum,attr"`
+	Number string `xml:"fc2:AccountNumberText"`
+}
+
+type bsaSuspiciousXML struct {
+	SeqNum          int                    `xml:"SeqNum,attr"`
+	AmountUnknown   string                 `xml:"fc2:AmountUnknownIndicator,omitempty"`
+	FromDate        string                 `xml:"fc2:SuspiciousActivityFromDateText"`
+	ToDate          string                 `xml:"fc2:SuspiciousActivityToDateText"`
+	TotalAmount     string                 `xml:"fc2:TotalSuspiciousAmountText,omitempty"`
+	Classifications []bsaClassificationXML `xml:"fc2:SuspiciousActivityClassification"`
+}
+
+type bsaClassificationXML struct {
+	SeqNum    int    `xml:"SeqNum,attr"`
+	OtherText string `xml:"fc2:OtherSuspiciousActivityTypeText,omitempty"`
+	SubtypeID string `xml:"fc2:SuspiciousActivitySubtypeID"`
+	TypeID    string `xml:"fc2:SuspiciousActivityTypeID"`
+}
+
+type bsaNarrativeXML struct {
+	SeqNum   int    `xml:"SeqNum,attr"`
+	Sequence int    `xml:"fc2:ActivityNarrativeSequenceNumber"`
+	Text     string `xml:"fc2:NarrativeText"`
+}
+
+type bsaCurrencyXML struct {
+	SeqNum          int                 `xml:"SeqNum,attr"`
+	Aggregate       string              `xml:"fc2:AggregateTransactionIndicator,omitempty"`
+	TotalCashIn     string              `xml:"fc2:TotalCashInReceiveAmountText,omitempty"`
+	TotalCashOut    string              `xml:"fc2:TotalCashOutAmountText,omitempty"`
+	TransactionDate string              `xml:"fc2:TransactionDateText"`
+	Details         []bsaCurrencyDetail `xml:"fc2:CurrencyTransactionActivityDetail"`
+}
+
+type bsaCurrencyDetail struct {
+	SeqNum   int    `xml:"SeqNum,attr"`
+	TypeCode string `xml:"fc2:CurrencyTransactionActivityDetailTypeCode"`
+	Amount   string `xml:"fc2:DetailTransactionAmountText"`
+}
+
+// CTR activity detail codes
+const (
+	bsaCashInDeposit     = "55"
+	bsaCashOutWithdrawal = "56"
+)
+
+// bsaBuilder numbers elements in document order
+type bsaBuilder struct {
+	cfg     BSAConfig
+	seq     int
+	parties int
+	now     time.Time
+}
+
+func (b *bsaBuilder) next() int {
+	b.seq++
+	return b.seq
+}
+
+func (b *bsaBuilder) party(typeCode string) *bsaPartyXML {
+	b.parties++
+	return &bsaPartyXML{SeqNum: b.next(), ActivityPartyTypeCode: typeCode}
+}
+
+func (b *bsaBuilder) address(a *Address) *bsaAddressXML {
+	if a == nil {
+		return nil
+	}
+	zip := a.PostalCode
+	if strings.EqualFold(a.Country, "US") {
+		zip = digitsOnly(zip)
+	}
+	return &bsaAddressXML{
+		SeqNum:  b.next(),
+		City:    a.City,
+		Country: strings.ToUpper(a.Country),
+		State:   strings.ToUpper(a.State),
+		Street:  strings.TrimSpace(a.Line1 + " " + a.Line2),
+		ZIP:     zip,
+	}
+}
+
+func (b *bsaBuilder) phone(number string) *bsaPhoneXML {
+	if number == "" {
+		return nil
+	}
+	return &bsaPhoneXML{SeqNum: b.next(), Number: digitsOnly(number)}
+}
+
+func (b *bsaBuilder) fullName(name string) []bsaPartyNameXML {
+	return []bsaPartyNameXML{{SeqNum: b.next(), TypeCode: "L", FullName: name}}
+}
+
+// header parties identify the transmitter and filing institution
+func (b *bsaBuilder) header(fi *FilingInstitution) []*bsaPartyXML {
+	transmitter := b.party(bsaPartyTransmitter)
+	transmitter.Names = b.fullName(b.cfg.TransmitterName)
+	transmitter.Address = b.address(b.cfg.TransmitterAddress)
+	transmitter.Phone = b.phone(b.cfg.TransmitterPhone)
+	transmitter.Identifications = []bsaIdentificationXML{
+		{SeqNum: b.next(), Number: digitsOnly(b.cfg.TransmitterTIN), TypeCode: bsaIDTIN},
+		{SeqNum: b.next(), Number: b.cfg.TransmitterControlCode, TypeCode: bsaIDTCC},
+	}
+
+	contact := b.party(bsaPartyTransmitterContact)
+	contact.Names = b.fullName(b.cfg.ContactName)
+
+	filer := b.party(bsaPartyFilingInstitution)
+	filer.PrimaryRegulator = b.cfg.PrimaryRegulator
+	filer.Names = b.fullName(fi.Name)
+	filer.Address = b.address(fi.Address)
+	filer.Identifications = []bsaIdentificationXML{
+		{SeqNum: b.next(), Number: digitsOnly(fi.TIN), TypeCode: bsaIDEIN},
+		{SeqNum: b.next(), Number: fi.IDNumber, TypeCode: bsaInstitutionIDTypes[strings.ToLower(fi.IDType)]},
+	}
+
+	office := b.party(bsaPartyContactOffice)
+	office.Names = b.fullName(fi.ContactName)
+	office.Phone = b.phone(fi.ContactPhone)
+
+	location := b.party(bsaPartyActivityLocation)
+	location.Names = b.fullName(fi.Name)
+	location.Address = b.address(fi.Address)
+	location.Identifications = []bsaIdentificationXML{
+		{SeqNum: b.next(), Number: digitsOnly(fi.TIN), TypeCode: bsaIDEIN},
+	}
+
+	return []*bsaPartyXML{transmitter, contact, filer, office, location}
+}
+
+// individualName splits "Last, First" or "First Middle Last"
+func (b *bsaBuilder) individualName(typeCode, name string) bsaPartyNameXML {
+	n := bsaPartyNameXML{SeqNum: b.next(), TypeCode: typeCode}
+	if last, first, ok := strings.Cut(name, ","); ok {
+		n.LastName, n.FirstName = strings.TrimSpace(last), strings.TrimSpace(first)
+		return n
+	}
+	fields := strings.Fields(name)
+	if len(fields) < 2 {
+		n.LastName = name
+		return n
+	}
+	n.LastName = fields[len(fields)-1]
+	n.FirstName = strings.Join(fields[:len(fields)-1], " ")
+	return n
+}
+
+func (b *bsaBuilder) sarActivity(sar *SuspiciousActivityReport) bsaActivityXML {
+	act := bsaActivityXML{
+		SeqNum:              b.next(),
+		PriorDocumentNumber: sar.PriorBSAIdentifier,
+		FilingDateText:      b.now.Format("20060102"),
+	}
+	act.Association.SeqNum = b.next()
+	switch sar.FilingType {
+	case "continuing":
+		act.Association.ContinuingActivity = "Y"
+	case "correction":
+		act.Association.CorrectsAmendsPrior = "Y"
+	case "joint":
+		act.Association.InitialReport = "Y"
+		act.Association.JointReport = "Y"
+	default:
+		act.Association.InitialReport = "Y"
+	}
+
+	act.Parties = b.header(sar.FilingInstitution)
+
+	s := sar.Subject
+	subject := b.party(bsaPartySubject)
+	entity := s.Type == "entity" || sar.SubjectType == "entity"
+	if entity {
+		subject.EntityIndicator = "Y"
+		subject.Names = []bsaPartyNameXML{{SeqNum: b.next(), TypeCode: "L", LastName: s.Name}}
+	} else {
+		subject.Names = []bsaPartyNameXML{b.individualName("L", s.Name)}
+		subject.BirthDateText = strings.ReplaceAll(s.DateOfBirth, "-", "")
+	}
+	for _, alt := range s.AlternateNames {
+		subject.Names = append(subject.Names, bsaPartyNameXML{SeqNum: b.next(), TypeCode: "AKA", LastName: alt})
+	}
+	subject.Address = b.address(s.Address)
+	if len(s.PhoneNumbers) > 0 {
+		subject.Phone = b.phone(s.PhoneNumbers[0])
+	}
+	switch {
+	case s.SSN != "":
+		subject.Identifications = append(subject.Identifications, bsaIdentificationXML{SeqNum: b.next(), Number: digitsOnly(s.SSN), TypeCode: bsaIDSSN})
+	case s.TIN != "" && entity:
+		subject.Identifications = append(subject.Identifications, bsaIdentificationXML{SeqNum: b.next(), Number: digitsOnly(s.TIN), TypeCode: bsaIDEIN})
+	case s.TIN != "":
+		subject.Identifications = append(subject.Identifications, bsaIdentificationXML{SeqNum: b.next(), Number: digitsOnly(s.TIN), TypeCode: bsaIDSSN})
+	}
+	if s.IDNumber != "" {
+		subject.Identifications = append(subject.Identifications, bsaIdentificationXML{
+			SeqNum: b.next(), Country: strings.ToUpper(s.IDCountry), Number: s.IDNumber, TypeCode: bsaPersonIDTypes[s.IDType],
+		})
+	}
+	if s.Occupation != "" {
+		subject.Occupation = &bsaOccupationXML{SeqNum: b.next(), Text: s.Occupation}
+	}
+	for _, email := range s.EmailAddresses {
+		subject.ElectronicAddresses = append(subject.ElectronicAddresses, bsaElectronicXML{SeqNum: b.next(), Text: email, TypeCode: "E"})
+	}
+	if len(s.AccountNumbers) > 0 {
+		subject.AccountAssociation = b.accounts("7", sar.FilingInstitution, s.AccountNumbers)
+	}
+	act.Parties = append(act.Parties, subject)
+
+	sa := &bsaSuspiciousXML{
+		SeqNum:   b.next(),
+		FromDate: sar.DateRange.Start.Format("20060102"),
+		ToDate:   sar.DateRange.End.Format("20060102"),
+	}
+	if sar.TotalAmount.IsZero() {
+		sa.AmountUnknown = "Y"
+	} else {
+		sa.TotalAmount = bsaAmount(sar.TotalAmount)
+	}
+	for _, c := range sar.SuspiciousActivity.Categories {
+		code := bsaActivityCategories[c]
+		cl := bsaClassificationXML{SeqNum: b.next(), SubtypeID: code.subtypeID, TypeID: code.typeID}
+		if c == "other" || code.subtypeID == "999" {
+			cl.OtherText = strings.ReplaceAll(c, "_", " ")
+		}
+		sa.Classifications = append(sa.Classifications, cl)
+	}
+	act.SuspiciousActivity = sa
+
+	for i, chunk := range chunkRunes(sar.Narrative, bsaNarrativeChunk) {
+		act.Narratives = append(act.Narratives, bsaNarrativeXML{SeqNum: b.next(), Sequence: i + 1, Text: chunk})
+	}
+	return act
+}
+
+func (b *bsaBuilder) ctrActivity(ctr *CurrencyTransactionReport) bsaActivityXML {
+	act := bsaActivityXML{
+		SeqNum:              b.next(),
+		PriorDocumentNumber: ctr.PriorBSAIdentifier,
+		FilingDateText:      b.now.Format("20060102"),
+	}
+	act.Association.SeqNum = b.next()
+	if ctr.PriorBSAIdentifier != "" {
+		act.Association.CorrectsAmendsPrior = "Y"
+	} else {
+		act.Association.InitialReport = "Y"
+	}
+
+	act.Parties = b.header(ctr.FilingInstitution)
+	for _, p := range ctr.Persons {
+		party := b.party(bsaCTRRoles[p.Role])
+		party.Names = []bsaPartyNameXML{b.individualName("L", p.Name)}
+		party.BirthDateText = strings.ReplaceAll(p.DateOfBirth, "-", "")
+		party.Address = b.address(p.Address)
+		if p.SSN != "" {
+			party.Identifications = append(party.Identifications, bsaIdentificationXML{SeqNum: b.next(), Number: digitsOnly(p.SSN), TypeCode: bsaIDSSN})
+		}
+		if p.IDNumber != "" {
+			party.Identifications = append(party.Identifications, bsaIdentificationXML{
+				SeqNum: b.next(), Country: strings.ToUpper(p.IDCountry), State: strings.ToUpper(p.IDState), Number: p.IDNumber, TypeCode: bsaPersonIDTypes[p.IDType],
+			})
+		}
+		if p.Occupation != "" {
+			party.Occupation = &bsaOccupationXML{SeqNum: b.next(), Text: p.Occupation}
+		}
+		if len(p.AccountNumbers) > 0 {
+			party.AccountAssociation = b.accounts("8", ctr.FilingInstitution, p.AccountNumbers)
+		}
+		act.Parties = append(act.Parties, party)
+	}
+
+	ca := &bsaCurrencyXML{SeqNum: b.next(), TransactionDate: ctr.TransactionDate.Format("20060102")}
+	if len(ctr.Transactions) > 1 {
+		ca.Aggregate = "Y"
+	}
+	if ctr.TotalCashIn.IsPositive() {
+		ca.TotalCashIn = bsaAmount(ctr.TotalCashIn)
+	}
+	if ctr.TotalCashOut.IsPositive() {
+		ca.TotalCashOut = bsaAmount(ctr.TotalCashOut)
+	}
+	for _, t := range ctr.Transactions {
+		code := bsaCashInDeposit
+		if t.Type == "cash_out" {
+			code = bsaCashOutWithdrawal
+		}
+		ca.Details = append(ca.Details, bsaCurrencyDetail{SeqNum: b.next(), TypeCode: code, Amount: bsaAmount(t.Amount)})
+	}
+	act.CurrencyActivity = ca
+	return act
+}
+
+func (b *bsaBuilder) accounts(typeCode string, fi *FilingInstitution, numbers []string) *bsaAccountAssocXML {
+	assoc := &bsaAccountAssocXML{SeqNum: b.next(), TypeCode: typeCode}
+	assoc.Party = bsaAccountPartyXML{SeqNum: b.next(), TypeCode: bsaPartyAccountInstitution}
+	assoc.Party.Identifications = []bsaIdentificationXML{{SeqNum: b.next(), Number: digitsOnly(fi.TIN), TypeCode: bsaIDEIN}}
+	for _, n := range numbers {
+		assoc.Party.Accounts = append(assoc.Party.Accounts, bsaAccountXML{SeqNum: b.next(), Number: n})
+	}
+	return assoc
+}
+
+// bsaAmount formats an amount as whole US dollars
+func bsaAmount(d decimal.Decimal) string {
+	return d.Round(0).StringFixed(0)
+}
+
+func chunkRunes(s string, n int) []string {
+	runes := []rune(s)
+	var chunks []string
+	for len(runes) > n {
+		chunks = append(chunks, string(runes[:n]))
+		runes = runes[n:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// BuildSARBatch validates the SARs and serialises them into one SARX batch
+// file. All field errors across the reports are returned together.
+func BuildSARBatch(cfg BSAConfig, sars []*SuspiciousActivityReport, now time.Time) (*BSABatch, error) {
+	errs := validateBSAConfig(cfg)
+	for _, sar := range sars {
+		errs = append(errs, ValidateSAR(sar)...)
+	}
+	if len(errs) > 0 {
+		return nil, &BSAValidationError{Errors: errs}
+	}
+
+	b := &bsaBuilder{cfg: cfg, now: now}
+	batch := &BSABatch{FormType: BSAFormSAR, Activities: make(map[int]string), TotalAmount: decimal.Zero}
+	doc := bsaBatchXML{FormTypeCode: BSAFormSAR}
+	zero := 0
+	doc.ActivityAttachmentCount, doc.AttachmentCount = &zero, &zero
+	for _, sar := range sars {
+		act := b.sarActivity(sar)
+		doc.Activities = append(doc.Activities, act)
+		batch.Activities[act.SeqNum] = sar.ID
+		batch.ReportIDs = append(batch.ReportIDs, sar.ID)
+		batch.TotalAmount = batch.TotalAmount.Add(sar.TotalAmount.Round(0))
+	}
+	return finishBSABatch(batch, &doc, b, "EFL_SARXBatchSchema.xsd")
+}
+
+// BuildCTRBatch validates the CTRs and serialises them into one CTRX batch
+// file
+func BuildCTRBatch(cfg BSAConfig, ctrs []*CurrencyTransactionReport, now time.Time) (*BSABatch, error) {
+	errs := validateBSAConfig(cfg)
+	for _, ctr := range ctrs {
+		errs = append(errs, ValidateCTR(ctr)...)
+	}
+	if len(errs) > 0 {
+		return nil, &BSAValidationError{Errors: errs}
+	}
+
+	b := &bsaBuilder{cfg: cfg, now: now}
+	batch := &BSABatch{FormType: BSAFormCTR, Activities: make(map[int]string), TotalAmount: decimal.Zero}
+	doc := bsaBatchXML{FormTypeCode: BSAFormCTR}
+	for _, ctr := range ctrs {
+		act := b.ctrActivity(ctr)
+		doc.Activities = append(doc.Activities, act)
+		batch.Activities[act.SeqNum] = ctr.ID
+		batch.ReportIDs = append(batch.ReportIDs, ctr.ID)
+		batch.TotalAmount = batch.TotalAmount.Add(ctr.TotalCashIn.Round(0)).Add(ctr.TotalCashOut.Round(0))
+	}
+	return finishBSABatch(batch, &doc, b, "EFL_CTRXBatchSchema.xsd")
+}
+
+func finishBSABatch(batch *BSABatch, doc *bsaBatchXML, b *bsaBuilder, schema string) (*BSABatch, error) {
+	doc.NS = bsaNamespace
+	doc.XSI = bsaXSINamespace
+	doc.SchemaLocation = bsaNamespace + " https://www.fincen.gov/base/" + schema
+	doc.ActivityCount = len(doc.Activities)
+	doc.TotalAmount = bsaAmount(batch.TotalAmount)
+	doc.PartyCount = b.parties
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("encode %s batch: %w", batch.FormType, err)
+	}
+	buf.WriteByte('\n')
+
+	batch.ID = generateID("bsa")
+	batch.Content = buf.Bytes()
+	batch.FileName = fmt.Sprintf("%sST.%s.%s.xml", batch.FormType, b.now.Format("20060102150405"), b.cfg.TransmitterControlCode)
+	batch.Status = "submitted"
+	batch.CreatedAt = b.now
+	return batch, nil
+}
+
+// BSAAcknowledgement is a parsed FinCEN acknowledgement file
+type BSAAcknowledgement struct {
+	StatusCode string           `json:"status_code"` // A accepted, A* accepted with warnings, R rejected
+	Activities []BSAActivityAck `json:"activities"`
+}
+
+// BSAActivityAck is the acknowledgement of one filed report
+type BSAActivityAck struct {
+	SeqNum int           `json:"seq_num"`
+	BSAID  string        `json:"bsa_id,omitempty"`
+	Errors []BSAAckError `json:"errors,omitempty"`
+}
+
+// BSAAckError is an error or warning FinCEN reported for a report
+type BSAAckError struct {
+	Level   string `json:"level"` // FATAL, WARN
+	Element string `json:"element,omitempty"`
+	Context string `json:"context,omitempty"`
+	Text    string `json:"text"`
+	Code    string `json:"code,omitempty"`
+}
+
+// Accepted reports whether FinCEN assigned a BSA ID without fatal errors
+func (a BSAActivityAck) Accepted() bool {
+	if a.BSAID == "" {
+		return false
+	}
+	for _, e := range a.Errors {
+		if strings.EqualFold(e.Level, "FATAL") {
+			return false
+		}
+	}
+	return true
+}
+
+type bsaAckXML struct {
+	XMLName    xml.Name `xml:"EFilingSubmissionXML"`
+	StatusCode string   `xml:"StatusCode,attr"`
+	Activities []struct {
+		SeqNum int    `xml:"SeqNum,attr"`
+		BSAID  string `xml:"BSAID"`
+		Errors []struct {
+			Context string `xml:"ErrorContextText"`
+			Element string `xml:"ErrorElementNameText"`
+			Level   string `xml:"ErrorLevelText"`
+			Text    string `xml:"ErrorText"`
+			Code    string `xml:"ErrorTypeCode"`
+		} `xml:"EFilingActivityErrorXML"`
+	} `xml:"EFilingActivityXML"`
+}
+
+// ParseBSAAcknowledgement parses a FinCEN E-Filing acknowledgement file
+func ParseBSAAcknowledgement(r io.Reader) (*BSAAcknowledgement, error) {
+	var doc bsaAckXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode BSA acknowledgement: %w", err)
+	}
+
+	ack := &BSAAcknowledgement{StatusCode: strings.TrimSpace(doc.StatusCode)}
+	for _, a := range doc.Activities {
+		activity := BSAActivityAck{SeqNum: a.SeqNum, BSAID: strings.TrimSpace(a.BSAID)}
+		for _, e := range a.Errors {
+			activity.Errors = append(activity.Errors, BSAAckError{
+				Level:   strings.TrimSpace(e.Level),
+				Element: strings.TrimSpace(e.Element),
+				Context: strings.TrimSpace(e.Context),
+				Text:    strings.TrimSpace(e.Text),
+				Code:    strings.TrimSpace(e.Code),
+			})
+		}
+		ack.Activities = append(ack.Activities, activity)
+	}
+	return ack, nil
+}
+
+// FileSARs validates approved SARs, or SARs FinCEN rejected and that have
+// since been corrected, and writes them to a SARX batch. The reports move
+// to submitted until the acknowledgement is applied.
+func (e *Engine) FileSARs(ctx context.Context, ids []string) (*BSABatch, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sars := make([]*SuspiciousActivityReport, 0, len(ids))
+	for _, id := range ids {
+		sar, ok := e.sars[id]
+		if !ok {
+			return nil, fmt.Errorf("SAR not found: %s", id)
+		}
+		if sar.Status != SARStatusApproved && sar.Status != SARStatusRejected {
+			return nil, fmt.Errorf("SAR must be approved or rejected by FinCEN to file: %s", id)
+		}
+		sars = append(sars, sar)
+	}
+
+	batch, err := BuildSARBatch(e.config.BSA, sars, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, sar := range sars {
+		sar.Status = SARStatusSubmitted
+		sar.BatchID = batch.ID
+		sar.FilingErrors = nil
+		sar.UpdatedAt = batch.CreatedAt
+		if c, ok := e.cases[sar.CaseID]; ok {
+			stopClock(c, ClockSARFiling, batch.CreatedAt)
+		}
+	}
+	e.bsaBatches[batch.ID] = batch
+	return batch, nil
+}
+
+// FileCTRs validates pending CTRs and writes them to a CTRX batch
+func (e *Engine) FileCTRs(ctx context.Context, ids []string) (*BSABatch, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctrs := make([]*CurrencyTransactionReport, 0, len(ids))
+	for _, id := range ids {
+		ctr, ok := e.ctrs[id]
+		if !ok {
+			return nil, fmt.Errorf("CTR not found: %s", id)
+		}
+		if ctr.Status != CTRStatusPending && ctr.Status != CTRStatusRejected {
+			return nil, fmt.Errorf("CTR must be pending to file: %s", id)
+		}
+		ctrs = append(ctrs, ctr)
+	}
+
+	batch, err := BuildCTRBatch(e.config.BSA, ctrs, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, ctr := range ctrs {
+		ctr.Status = CTRStatusSubmitted
+		ctr.BatchID = batch.ID
+		ctr.FilingErrors = nil
+	}
+	e.bsaBatches[batch.ID] = batch
+	return batch, nil
+}
+
+// ErrBSABatchAcknowledged is returned when an acknowledgement is applied to
+// a batch that already has one
+var ErrBSABatchAcknowledged = errors.New("BSA batch already acknowledged")
+
+// ApplyBSAAcknowledgement reads FinCEN's acknowledgement for a batch and
+// marks each report filed with its BSA ID or rejected with the errors.
+// Reports refiled in a later batch since are left to that batch.
+func (e *Engine) ApplyBSAAcknowledgement(batchID string, r io.Reader) (*BSABatch, error) {
+	ack, err := ParseBSAAcknowledgement(r)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	batch, ok := e.bsaBatches[batchID]
+	if !ok {
+		return nil, fmt.Errorf("BSA batch not found: %s", batchID)
+	}
+	if batch.AcknowledgedAt != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBSABatchAcknowledged, batchID)
+	}
+
+	now := time.Now()
+	results := make(map[string]BSAActivityAck)
+	for _, a := range ack.Activities {
+		if id, ok := batch.Activities[a.SeqNum]; ok {
+			results[id] = a
+		}
+	}
+
+	accepted := 0
+	for _, id := range batch.ReportIDs {
+		a, ok := results[id]
+		fileRejected := strings.HasPrefix(ack.StatusCode, "R")
+		var errs []string
+		for _, ae := range a.Errors {
+			errs = append(errs, fmt.Sprintf("%s %s: %s", ae.Level, ae.Element, ae.Text))
+		}
+		if !ok {
+			errs = append(errs, "no acknowledgement for activity")
+		}
+		filed := ok && a.Accepted() && !fileRejected
+		if filed {
+			accepted++
+		}
+
+		switch batch.FormType {
+		case BSAFormSAR:
+			sar := e.sars[id]
+			if sar == nil || sar.BatchID != batch.ID {
+				continue
+			}
+			sar.FilingErrors = errs
+			sar.UpdatedAt = now
+			if filed {
+				sar.Status = SARStatusFiled
+				sar.BSAIdentifier = a.BSAID
+				sar.FiledAt = &now
+			} else {
+				sar.Status = SARStatusRejected
+			}
+		case BSAFormCTR:
+			ctr := e.ctrs[id]
+			if ctr == nil || ctr.BatchID != batch.ID {
+				continue
+			}
+			ctr.FilingErrors = errs
+			if filed {
+				ctr.Status = CTRStatusFiled
+				ctr.BSAIdentifier = a.BSAID
+				ctr.FiledAt = &now
+			} else {
+				ctr.Status = CTRStatusRejected
+			}
+		}
+	}
+
+	switch {
+	case accepted == len(batch.ReportIDs):
+		batch.Status = "accepted"
+	case accepted > 0:
+		batch.Status = "partially_accepted"
+	default:
+		batch.Status = "rejected"
+	}
+	batch.Acknowledgement = ack
+	batch.AcknowledgedAt = &now
+	return batch, nil
+}
+
+// GetBSABatch retrieves a generated batch file
+func (e *Engine) GetBSABatch(id string) (*BSABatch, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	batch, ok := e.bsaBatches[id]
+	return batch, ok
+}
+
+// ListBSABatches returns generated batches, newest first
+func (e *Engine) ListBSABatches() []*BSABatch {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	batches := make([]*BSABatch, 0, len(e.bsaBatches))
+	for _, b := range e.bsaBatches {
+		batches = append(batches, b)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].CreatedAt.After(batches[j].CreatedAt) })
+	return batches
+}
@@ -0,0 +1,263 @@
+package aml
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testBSAConfig() BSAConfig {
+	return BSAConfig{
+		TransmitterControlCode: "PBSA1234",
+		TransmitterName:        "Example Bank",
+		TransmitterTIN:         "123456789",
+		TransmitterPhone:       "2025550100",
+		ContactName:            "Jane Compliance",
+		TransmitterAddress:     &Address{Line1: "1 Main St", City: "Washington", State: "DC", PostalCode: "20001", Country: "US"},
+	}
+}
+
+func testInstitution() *FilingInstitution {
+	return &FilingInstitution{
+		Name:         "Example Bank",
+		TIN:          "12-3456789",
+		IDType:       "rssd",
+		IDNumber:     "987654",
+		Address:      &Address{Line1: "1 Main St", City: "Washington", State: "DC", PostalCode: "20001", Country: "US"},
+		ContactName:  "Jane Compliance",
+		ContactPhone: "(202) 555-0100",
+	}
+}
+
+func testSAR(id string) *SuspiciousActivityReport {
+	return &SuspiciousActivityReport{
+		ID:          id,
+		Status:      SARStatusApproved,
+		FilingType:  "initial",
+		SubjectType: "individual",
+		Subject: &SARSubject{
+			Name:           "John Q Doe",
+			AlternateNames: []string{"Johnny Doe"},
+			SSN:            "123-45-6789",
+			DateOfBirth:    "1980-05-01",
+			Address:        &Address{Line1: "9 Elm St", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"},
+			AccountNumbers: []string{"000123"},
+		},
+		SuspiciousActivity: &SuspiciousActivity{Categories: []string{"structuring"}},
+		Narrative:          strings.Repeat("x", 4500),
+		TotalAmount:        decimal.RequireFromString("27500.40"),
+		DateRange:          &DateRange{Start: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)},
+		FilingInstitution:  testInstitution(),
+	}
+}
+
+func testCTR(id string) *CurrencyTransactionReport {
+	return &CurrencyTransactionReport{
+		ID:              id,
+		Status:          CTRStatusPending,
+		TransactionDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Transactions: []CTRTransaction{
+			{TransactionID: "t1", Type: "cash_in", Amount: decimal.NewFromInt(8000)},
+			{TransactionID: "t2", Type: "cash_in", Amount: decimal.NewFromInt(4000)},
+		},
+		TotalCashIn:  decimal.NewFromInt(12000),
+		TotalCashOut: decimal.Zero,
+		Persons: []CTRPerson{{
+			Role: "conductor", Name: "Doe, Jane", SSN: "987654321", DateOfBirth: "1975-07-04",
+			Address: &Address{Line1: "5 Oak Ave", City: "Austin", State: "TX", PostalCode: "78701-1234", Country: "US"},
+			IDType:  "drivers_license", IDNumber: "D1234567", IDState: "TX", IDCountry: "US",
+		}},
+		FilingInstitution: testInstitution(),
+	}
+}
+
+func TestValidateSARFieldErrors(t *testing.T) {
+	sar := testSAR("sar-1")
+	sar.FilingType = "continuing"
+	sar.Subject.SSN = "12345"
+	sar.Subject.Address.State = "Illinois"
+	sar.SuspiciousActivity.Categories = nil
+	sar.Narrative = ""
+
+	fields := make(map[string]bool)
+	for _, fe := range ValidateSAR(sar) {
+		if fe.ReportID != "sar-1" {
+			t.Errorf("field error without report ID: %+v", fe)
+		}
+		fields[fe.Field] = true
+	}
+	for _, want := range []string{"prior_bsa_identifier", "subject.ssn", "subject.address.state", "suspicious_activity.categories", "narrative"} {
+		if !fields[want] {
+			t.Errorf("expected a validation error on %s, got %v", want, fields)
+		}
+	}
+
+	if errs := ValidateSAR(testSAR("sar-2")); len(errs) != 0 {
+		t.Errorf("valid SAR reported errors: %+v", errs)
+	}
+}
+
+func TestValidateCTRTotals(t *testing.T) {
+	ctr := testCTR("ctr-1")
+	ctr.TotalCashIn = decimal.NewFromInt(9000)
+	ctr.Transactions = ctr.Transactions[:1]
+	ctr.Transactions[0].Amount = decimal.NewFromInt(9000)
+
+	errs := ValidateCTR(ctr)
+	if len(errs) != 1 || errs[0].Field != "total_cash_in" {
+		t.Errorf("expected the $10,000 threshold error, got %+v", errs)
+	}
+
+	ctr = testCTR("ctr-2")
+	ctr.TotalCashIn = decimal.NewFromInt(13000)
+	if errs := ValidateCTR(ctr); len(errs) != 1 || !strings.Contains(errs[0].Message, "transactions total 12000") {
+		t.Errorf("expected a totals mismatch, got %+v", errs)
+	}
+}
+
+func TestBuildSARBatch(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	batch, err := BuildSARBatch(testBSAConfig(), []*SuspiciousActivityReport{testSAR("sar-1"), testSAR("sar-2")}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.FileName != "SARXST.20240301120000.PBSA1234.xml" {
+		t.Errorf("unexpected file name %s", batch.FileName)
+	}
+
+	var doc struct {
+		XMLName       xml.Name `xml:"EFilingBatchXML"`
+		ActivityCount int      `xml:"ActivityCount,attr"`
+		TotalAmount   string   `xml:"TotalAmount,attr"`
+		PartyCount    int      `xml:"PartyCount,attr"`
+		FormTypeCode  string   `xml:"FormTypeCode"`
+		Activities    []struct {
+			SeqNum  int `xml:"SeqNum,attr"`
+			Parties []struct {
+				TypeCode string `xml:"ActivityPartyTypeCode"`
+			} `xml:"Party"`
+			Narratives []string `xml:"ActivityNarrativeInformation>NarrativeText"`
+			Amount     string   `xml:"SuspiciousActivity>TotalSuspiciousAmountText"`
+		} `xml:"Activity"`
+	}
+	if err := xml.Unmarshal(batch.Content, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(batch.Content), `<fc2:EFilingBatchXML xmlns:fc2="www.fincen.gov/base"`) {
+		t.Errorf("root element is missing the fc2 namespace:\n%s", batch.Content[:200])
+	}
+	if doc.FormTypeCode != "SARX" || doc.ActivityCount != 2 || doc.TotalAmount != "55000" || doc.PartyCount != 12 {
+		t.Errorf("unexpected batch header %+v", doc)
+	}
+
+	act := doc.Activities[0]
+	if act.SeqNum != 1 || batch.Activities[doc.Activities[1].SeqNum] != "sar-2" {
+		t.Errorf("unexpected activity numbering %d, %v", act.SeqNum, batch.Activities)
+	}
+	var codes []string
+	for _, p := range act.Parties {
+		codes = append(codes, p.TypeCode)
+	}
+	if strings.Join(codes, ",") != "35,37,30,8,34,33" {
+		t.Errorf("unexpected party order %v", codes)
+	}
+	if len(act.Narratives) != 2 || len(act.Narratives[0]) != 4000 || act.Amount != "27500" {
+		t.Errorf("unexpected narrative chunks %d or amount %s", len(act.Narratives), act.Amount)
+	}
+}
+
+func TestBuildCTRBatchValidation(t *testing.T) {
+	cfg := testBSAConfig()
+	cfg.TransmitterControlCode = "short"
+	bad := testCTR("ctr-2")
+	bad.Persons = nil
+
+	_, err := BuildCTRBatch(cfg, []*CurrencyTransactionReport{testCTR("ctr-1"), bad}, time.Now())
+	var verr *BSAValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Fatalf("expected transmitter and person errors, got %v", err)
+	}
+	if verr.Errors[0].ReportID != "transmitter" || verr.Errors[1].ReportID != "ctr-2" {
+		t.Errorf("unexpected errors %+v", verr.Errors)
+	}
+}
+
+func TestApplyBSAAcknowledgement(t *testing.T) {
+	e := NewEngine(&Config{Enabled: true, BSA: testBSAConfig()})
+	for _, id := range []string{"sar-1", "sar-2"} {
+		e.sars[id] = testSAR(id)
+	}
+
+	batch, err := e.FileSARs(context.Background(), []string{"sar-1", "sar-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sar, _ := e.GetSAR("sar-1"); sar.Status != SARStatusSubmitted || sar.BatchID != batch.ID {
+		t.Fatalf("expected submitted SAR, got %s", sar.Status)
+	}
+
+	var seq []int
+	for s, id := range batch.Activities {
+		if id == "sar-1" {
+			seq = append([]int{s}, seq...)
+		} else {
+			seq = append(seq, s)
+		}
+	}
+	ack := `<?xml version="1.0" encoding="UTF-8"?>
+<fc2:EFilingSubmissionXML xmlns:fc2="www.fincen.gov/base" SeqNum="1" StatusCode="A">
+  <fc2:EFilingActivityXML SeqNum="` + strconv.Itoa(seq[0]) + `">
+    <fc2:BSAID>31000123456789</fc2:BSAID>
+    <fc2:EFilingActivityErrorXML SeqNum="1">
+      <fc2:ErrorElementNameText>PhoneNumberText</fc2:ErrorElementNameText>
+      <fc2:ErrorLevelText>WARN</fc2:ErrorLevelText>
+      <fc2:ErrorText>The phone number is missing</fc2:ErrorText>
+      <fc2:ErrorTypeCode>C21</fc2:ErrorTypeCode>
+    </fc2:EFilingActivityErrorXML>
+  </fc2:EFilingActivityXML>
+  <fc2:EFilingActivityXML SeqNum="` + strconv.Itoa(seq[1]) + `">
+    <fc2:EFilingActivityErrorXML SeqNum="2">
+      <fc2:ErrorElementNameText>SuspiciousActivityFromDateText</fc2:ErrorElementNameText>
+      <fc2:ErrorLevelText>FATAL</fc2:ErrorLevelText>
+      <fc2:ErrorText>The date is invalid</fc2:ErrorText>
+    </fc2:EFilingActivityErrorXML>
+  </fc2:EFilingActivityXML>
+</fc2:EFilingSubmissionXML>`
+
+	batch, err = e.ApplyBSAAcknowledgement(batch.ID, strings.NewReader(ack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Status != "partially_accepted" || batch.AcknowledgedAt == nil {
+		t.Errorf("unexpected batch status %s", batch.Status)
+	}
+
+	filed, _ := e.GetSAR("sar-1")
+	if filed.Status != SARStatusFiled || filed.BSAIdentifier != "31000123456789" || filed.FiledAt == nil || len(filed.FilingErrors) != 1 {
+		t.Errorf("unexpected filed SAR %s %s %v", filed.Status, filed.BSAIdentifier, filed.FilingErrors)
+	}
+	rejected, _ := e.GetSAR("sar-2")
+	if rejected.Status != SARStatusRejected || rejected.BSAIdentifier != "" || !strings.Contains(rejected.FilingErrors[0], "FATAL") {
+		t.Errorf("unexpected rejected SAR %s %v", rejected.Status, rejected.FilingErrors)
+	}
+
+	if _, err := e.ApplyBSAAcknowledgement(batch.ID, strings.NewReader(ack)); !errors.Is(err, ErrBSABatchAcknowledged) {
+		t.Errorf("expected a second acknowledgement to be refused, got %v", err)
+	}
+	if _, err := e.FileSARs(context.Background(), []string{"sar-1"}); err == nil {
+		t.Error("expected a filed SAR to be refused")
+	}
+	refiled, err := e.FileSARs(context.Background(), []string{"sar-2"})
+	if err != nil {
+		t.Fatalf("expected the rejected SAR to be refiled: %v", err)
+	}
+	if rejected.Status != SARStatusSubmitted || rejected.BatchID != refiled.ID || rejected.FilingErrors != nil {
+		t.Errorf("unexpected refiled SAR %s %s %v", rejected.Status, rejected.BatchID, rejected.FilingErrors)
+	}
+}
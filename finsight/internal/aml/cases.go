@@ -0,0 +1,743 @@
+package aml
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// SLA clocks of a case
+const (
+	// ClockAssignment runs from case creation until an investigator is
+	// assigned
+	ClockAssignment = "assignment"
+	// ClockSARFiling runs from initial detection until a SAR is filed, or
+	// the case is closed without one
+	ClockSARFiling = "sar_filing"
+)
+
+// Default case SLAs
+const (
+	defaultCaseAssignmentSLA = 2 * 24 * time.Hour
+	defaultCaseSARSLA        = 30 * 24 * time.Hour
+)
+
+// SLAClock measures a case against a deadline
+type SLAClock struct {
+	Name       string     `json:"name"`
+	StartedAt  time.Time  `json:"started_at"`
+	DueAt      time.Time  `json:"due_at"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"`
+	Breached   bool       `json:"breached"`
+	BreachedAt *time.Time `json:"breached_at,omitempty"`
+}
+
+// Running reports whether the clock has not been stopped
+func (c *SLAClock) Running() bool {
+	return c.StoppedAt == nil
+}
+
+// EvidenceType is the kind of evidence attached to a case
+type EvidenceType string
+
+const (
+	EvidenceDocument      EvidenceType = "document"
+	EvidenceTransaction   EvidenceType = "transaction"
+	EvidenceGraphSnapshot EvidenceType = "graph_snapshot"
+)
+
+// CaseEvidence is evidence attached to a case. The content is held in the
+// evidence store under its digest.
+type CaseEvidence struct {
+	ID          string       `json:"id"`
+	Type        EvidenceType `json:"type"`
+	Name        string       `json:"name"`
+	ContentType string       `json:"content_type"`
+	Digest      string       `json:"digest"`
+	Size        int64        `json:"size"`
+	Reference   string       `json:"reference,omitempty"` // transaction ID or graph seed account
+	Description string       `json:"description,omitempty"`
+	AddedBy     string       `json:"added_by,omitempty"`
+	AddedAt     time.Time    `json:"added_at"`
+}
+
+// CaseQueue summarizes a queue of cases of one risk level
+type CaseQueue struct {
+	Name          string     `json:"name"`
+	Investigators []string   `json:"investigators"`
+	Open          int        `json:"open"`
+	Unassigned    int        `json:"unassigned"`
+	Breached      int        `json:"breached"`
+	NextDue       *time.Time `json:"next_due,omitempty"`
+}
+
+// InvestigatorWorkload is the open caseload of an investigator
+type InvestigatorWorkload struct {
+	Investigator string   `json:"investigator"`
+	Queues       []string `json:"queues"`
+	OpenCases    int      `json:"open_cases"`
+	Breached     int      `json:"breached"`
+}
+
+var riskRank = map[string]int{
+	string(RiskLevelLow):      1,
+	string(RiskLevelMedium):   2,
+	string(RiskLevelHigh):     3,
+	string(RiskLevelCritical): 4,
+}
+
+// initCase sets the detection time, queue and SLA clocks of a new case and
+// assigns it when no investigator was given. The caller holds e.mu.
+func (e *Engine) initCase(c *AMLCase, now time.Time) {
+	if c.DetectedAt == nil {
+		detected := now
+		for _, alertID := range c.Alerts {
+			if alert, ok := e.alerts[alertID]; ok && alert.CreatedAt.Before(detected) {
+				detected = alert.CreatedAt
+			}
+		}
+		c.DetectedAt = &detected
+	}
+	if c.Queue == "" {
+		c.Queue = e.caseQueue(c)
+	}
+
+	assignmentSLA := e.config.CaseAssignmentSLA
+	if assignmentSLA <= 0 {
+		assignmentSLA = defaultCaseAssignmentSLA
+	}
+	sarSLA := e.config.CaseSARSLA
+	if sarSLA <= 0 {
+		sarSLA = defaultCaseSARSLA
+	}
+	c.SLAs = []SLAClock{
+		{Name: ClockAssignment, StartedAt: now, DueAt: now.Add(assignmentSLA)},
+		{Name: ClockSARFiling, StartedAt: *c.DetectedAt, DueAt: c.DetectedAt.Add(sarSLA)},
+	}
+	if c.DueDate == nil {
+		due := c.DetectedAt.Add(sarSLA)
+		c.DueDate = &due
+	}
+
+	if c.AssignedTo != "" {
+		c.Status = CaseStatusInProgress
+		stopClock(c, ClockAssignment, now)
+	} else {
+		e.autoAssign(c, now)
+	}
+}
+
+// caseQueue returns the risk level queue of a case: the higher of the
+// customer's risk level and the case priority
+func (e *Engine) caseQueue(c *AMLCase) string {
+	queue := string(RiskLevelMedium)
+	rank := 0
+	if profile, ok := e.customerProfiles[c.CustomerID]; ok && riskRank[string(profile.RiskLevel)] > rank {
+		queue, rank = string(profile.RiskLevel), riskRank[string(profile.RiskLevel)]
+	}
+	if r := riskRank[c.Priority]; r > rank {
+		queue = c.Priority
+	}
+	return queue
+}
+
+// autoAssign assigns a case to the investigator of its queue with the
+// fewest open cases, if one has capacity. The caller holds e.mu.
+func (e *Engine) autoAssign(c *AMLCase, now time.Time) bool {
+	investigators := e.config.CaseQueues[c.Queue]
+	if len(investigators) == 0 {
+		return false
+	}
+	workloads := e.openCaseCounts()
+	best := ""
+	for _, inv := range investigators {
+		if e.config.MaxCasesPerInvestigator > 0 && workloads[inv] >= e.config.MaxCasesPerInvestigator {
+			continue
+		}
+		if best == "" || workloads[inv] < workloads[best] {
+			best = inv
+		}
+	}
+	if best == "" {
+		return false
+	}
+
+	c.AssignedTo = best
+	c.Status = CaseStatusInProgress
+	c.UpdatedAt = now
+	stopClock(c, ClockAssignment, now)
+	c.Timeline = append(c.Timeline, CaseEvent{
+		ID:          generateID("event"),
+		Type:        "case_assigned",
+		Description: fmt.Sprintf("Case assigned to %s from the %s queue", best, c.Queue),
+		Timestamp:   now,
+		Data:        map[string]interface{}{"automatic": true, "open_cases": workloads[best]},
+	})
+	return true
+}
+
+// assignNext gives an investigator the unassigned case due soonest in the
+// queues they serve. The caller holds e.mu.
+func (e *Engine) assignNext(investigator string, now time.Time) {
+	if investigator == "" {
+		return
+	}
+	var candidates []*AMLCase
+	for queue, investigators := range e.config.CaseQueues {
+		if !containsString(investigators, investigator) {
+			continue
+		}
+		candidates = append(candidates, e.unassignedCases(queue)...)
+	}
+	sortCasesByDue(candidates)
+	for _, c := range candidates {
+		if e.autoAssign(c, now) {
+			return
+		}
+	}
+}
+
+// openCaseCounts counts the open cases of each investigator. The caller
+// holds e.mu.
+func (e *Engine) openCaseCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, c := range e.cases {
+		if c.Status != CaseStatusClosed && c.AssignedTo != "" {
+			counts[c.AssignedTo]++
+		}
+	}
+	return counts
+}
+
+// unassignedCases returns the open unassigned cases of a queue. The caller
+// holds e.mu.
+func (e *Engine) unassignedCases(queue string) []*AMLCase {
+	var cases []*AMLCase
+	for _, c := range e.cases {
+		if c.Queue == queue && c.AssignedTo == "" && c.Status != CaseStatusClosed {
+			cases = append(cases, c)
+		}
+	}
+	return cases
+}
+
+// AutoAssignCase assigns an unassigned case from its queue. It reports
+// whether an investigator with capacity was found.
+func (e *Engine) AutoAssignCase(id string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, ok := e.cases[id]
+	if !ok {
+		return false, fmt.Errorf("case not found: %s", id)
+	}
+	if c.Status == CaseStatusClosed {
+		return false, fmt.Errorf("case is closed: %s", id)
+	}
+	if c.AssignedTo != "" {
+		return false, fmt.Errorf("case is already assigned to %s", c.AssignedTo)
+	}
+	return e.autoAssign(c, time.Now()), nil
+}
+
+// CaseQueues summarizes the configured queues and any other queue with open
+// cases, from the highest risk level down
+func (e *Engine) CaseQueues() []CaseQueue {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	queues := make(map[string]*CaseQueue)
+	queue := func(name string) *CaseQueue {
+		q, ok := queues[name]
+		if !ok {
+			q = &CaseQueue{Name: name, Investigators: append([]string{}, e.config.CaseQueues[name]...)}
+			queues[name] = q
+		}
+		return q
+	}
+	for name := range e.config.CaseQueues {
+		queue(name)
+	}
+	for _, c := range e.cases {
+		if c.Status == CaseStatusClosed {
+			continue
+		}
+		q := queue(c.Queue)
+		q.Open++
+		if c.AssignedTo == "" {
+			q.Unassigned++
+		}
+		if slaBreached(c) {
+			q.Breached++
+		}
+		if c.DueDate != nil && (q.NextDue == nil || c.DueDate.Before(*q.NextDue)) {
+			due := *c.DueDate
+			q.NextDue = &due
+		}
+	}
+
+	results := make([]CaseQueue, 0, len(queues))
+	for _, q := range queues {
+		results = append(results, *q)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if riskRank[results[i].Name] != riskRank[results[j].Name] {
+			return riskRank[results[i].Name] > riskRank[results[j].Name]
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// QueueCases returns the unassigned cases of a queue, due soonest first
+func (e *Engine) QueueCases(queue string) []*AMLCase {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	cases := e.unassignedCases(queue)
+	sortCasesByDue(cases)
+	return cases
+}
+
+// Workloads returns the open caseload of each investigator, busiest first
+func (e *Engine) Workloads() []InvestigatorWorkload {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	workloads := make(map[string]*InvestigatorWorkload)
+	workload := func(inv string) *InvestigatorWorkload {
+		w, ok := workloads[inv]
+		if !ok {
+			w = &InvestigatorWorkload{Investigator: inv, Queues: []string{}}
+			workloads[inv] = w
+		}
+		return w
+	}
+	for queue, investigators := range e.config.CaseQueues {
+		for _, inv := range investigators {
+			w := workload(inv)
+			w.Queues = append(w.Queues, queue)
+		}
+	}
+	for _, c := range e.cases {
+		if c.Status == CaseStatusClosed || c.AssignedTo == "" {
+			continue
+		}
+		w := workload(c.AssignedTo)
+		w.OpenCases++
+		if slaBreached(c) {
+			w.Breached++
+		}
+	}
+
+	results := make([]InvestigatorWorkload, 0, len(workloads))
+	for _, w := range workloads {
+		sort.Strings(w.Queues)
+		results = append(results, *w)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].OpenCases != results[j].OpenCases {
+			return results[i].OpenCases > results[j].OpenCases
+		}
+		return results[i].Investigator < results[j].Investigator
+	})
+	return results
+}
+
+// CheckCaseSLAs marks the running SLA clocks past their deadline as
+// breached and returns the cases with new breaches
+func (e *Engine) CheckCaseSLAs(now time.Time) []*AMLCase {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var breached []*AMLCase
+	for _, c := range e.cases {
+		found := false
+		for i := range c.SLAs {
+			clock := &c.SLAs[i]
+			if !clock.Running() || clock.Breached || now.Before(clock.DueAt) {
+				continue
+			}
+			clock.Breached = true
+			at := now
+			clock.BreachedAt = &at
+			c.Timeline = append(c.Timeline, CaseEvent{
+				ID:          generateID("event"),
+				Type:        "sla_breached",
+				Description: fmt.Sprintf("%s SLA breached: due %s", clock.Name, clock.DueAt.Format(time.RFC3339)),
+				Timestamp:   now,
+				Data:        map[string]interface{}{"sla": clock.Name},
+			})
+			found = true
+		}
+		if found {
+			c.UpdatedAt = now
+			breached = append(breached, c)
+		}
+	}
+	sortCasesByDue(breached)
+	return breached
+}
+
+func stopClock(c *AMLCase, name string, now time.Time) {
+	for i := range c.SLAs {
+		if c.SLAs[i].Name == name && c.SLAs[i].Running() {
+			stopped := now
+			c.SLAs[i].StoppedAt = &stopped
+			if stopped.After(c.SLAs[i].DueAt) && !c.SLAs[i].Breached {
+				c.SLAs[i].Breached = true
+				c.SLAs[i].BreachedAt = &stopped
+			}
+		}
+	}
+}
+
+func slaBreached(c *AMLCase) bool {
+	for _, clock := range c.SLAs {
+		if clock.Breached {
+			return true
+		}
+	}
+	return false
+}
+
+func sortCasesByDue(cases []*AMLCase) {
+	sort.Slice(cases, func(i, j int) bool {
+		di, dj := cases[i].DueDate, cases[j].DueDate
+		switch {
+		case di != nil && dj != nil && !di.Equal(*dj):
+			return di.Before(*dj)
+		case (di == nil) != (dj == nil):
+			return di != nil
+		}
+		return cases[i].CreatedAt.Before(cases[j].CreatedAt)
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AttachEvidence stores evidence content and attaches it to a case
+func (e *Engine) AttachEvidence(caseID string, evidence *CaseEvidence, data []byte) (*CaseEvidence, error) {
+	if _, ok := e.GetCase(caseID); !ok {
+		return nil, fmt.Errorf("case not found: %s", caseID)
+	}
+	digest, err := e.evidence.Put(data)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, ok := e.cases[caseID]
+	if !ok {
+		return nil, fmt.Errorf("case not found: %s", caseID)
+	}
+	now := time.Now()
+	ev := *evidence
+	ev.ID = generateID("evidence")
+	ev.Digest = digest
+	ev.Size = int64(len(data))
+	ev.AddedAt = now
+	if ev.Type == "" {
+		ev.Type = EvidenceDocument
+	}
+	if ev.ContentType == "" {
+		ev.ContentType = "application/octet-stream"
+	}
+	if ev.Name == "" {
+		ev.Name = ev.ID
+	}
+	c.Evidence = append(c.Evidence, ev)
+	if ev.Type == EvidenceTransaction && ev.Reference != "" && !containsString(c.Transactions, ev.Reference) {
+		c.Transactions = append(c.Transactions, ev.Reference)
+	}
+	c.UpdatedAt = now
+	c.Timeline = append(c.Timeline, CaseEvent{
+		ID:          generateID("event"),
+		Type:        "evidence_added",
+		Description: fmt.Sprintf("%s attached: %s", ev.Type, ev.Name),
+		Actor:       ev.AddedBy,
+		Timestamp:   now,
+		Data:        map[string]interface{}{"evidence_id": ev.ID, "digest": ev.Digest},
+	})
+	return &ev, nil
+}
+
+// LinkTransaction attaches a transaction to a case as evidence, keeping the
+// transaction as it was when linked
+func (e *Engine) LinkTransaction(caseID, actor string, txn *models.Transaction, note string) (*CaseEvidence, error) {
+	data, err := json.MarshalIndent(txn, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return e.AttachEvidence(caseID, &CaseEvidence{
+		Type:        EvidenceTransaction,
+		Name:        "transaction-" + txn.ID + ".json",
+		ContentType: "application/json",
+		Reference:   txn.ID,
+		Description: note,
+		AddedBy:     actor,
+	}, data)
+}
+
+// AttachGraphSnapshot attaches the transaction graph around an account, or
+// around the case's customer, as it is now
+func (e *Engine) AttachGraphSnapshot(caseID, actor, account string, depth int) (*CaseEvidence, error) {
+	c, ok := e.GetCase(caseID)
+	if !ok {
+		return nil, fmt.Errorf("case not found: %s", caseID)
+	}
+	e.mu.RLock()
+	if account == "" {
+		account = c.CustomerID
+	}
+	e.mu.RUnlock()
+	if account == "" {
+		return nil, fmt.Errorf("account is required for a case without a customer")
+	}
+
+	now := time.Now()
+	data, err := json.MarshalIndent(struct {
+		CapturedAt time.Time  `json:"captured_at"`
+		View       *GraphView `json:"graph"`
+	}{now.UTC(), e.AccountSubgraph(account, depth)}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return e.AttachEvidence(caseID, &CaseEvidence{
+		Type:        EvidenceGraphSnapshot,
+		Name:        fmt.Sprintf("graph-%s-%s.json", account, now.UTC().Format("20060102T150405Z")),
+		ContentType: "application/json",
+		Reference:   account,
+		AddedBy:     actor,
+	}, data)
+}
+
+// GetEvidence returns evidence attached to a case and its content
+func (e *Engine) GetEvidence(caseID, evidenceID string) (*CaseEvidence, []byte, error) {
+	e.mu.RLock()
+	c, ok := e.cases[caseID]
+	if !ok {
+		e.mu.RUnlock()
+		return nil, nil, fmt.Errorf("case not found: %s", caseID)
+	}
+	var found *CaseEvidence
+	for i := range c.Evidence {
+		if c.Evidence[i].ID == evidenceID {
+			ev := c.Evidence[i]
+			found = &ev
+			break
+		}
+	}
+	e.mu.RUnlock()
+	if found == nil {
+		return nil, nil, ErrEvidenceNotFound
+	}
+
+	data, err := e.evidence.Get(found.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return found, data, nil
+}
+
+// CaseExportFile is a file of a case export and its digest
+type CaseExportFile struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// CaseExportManifest lists the files of a case export
+type CaseExportManifest struct {
+	CaseID     string           `json:"case_id"`
+	CaseNumber string           `json:"case_number"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Files      []CaseExportFile `json:"files"`
+}
+
+var exportNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ExportCase writes a ZIP bundle of a case for the SAR narrative: the case
+// with its timeline, its alerts and SAR, a narrative draft, each piece of
+// evidence and a manifest of the files and their digests
+func (e *Engine) ExportCase(caseID string, w io.Writer) (*CaseExportManifest, error) {
+	e.mu.RLock()
+	c, ok := e.cases[caseID]
+	if !ok {
+		e.mu.RUnlock()
+		return nil, fmt.Errorf("case not found: %s", caseID)
+	}
+	caseJSON, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		e.mu.RUnlock()
+		return nil, err
+	}
+	var snapshot AMLCase
+	json.Unmarshal(caseJSON, &snapshot)
+	var alerts []*AMLAlert
+	for _, id := range c.Alerts {
+		if alert, ok := e.alerts[id]; ok {
+			a := *alert
+			alerts = append(alerts, &a)
+		}
+	}
+	var sar *SuspiciousActivityReport
+	if s, ok := e.sars[c.SARID]; ok {
+		copied := *s
+		sar = &copied
+	}
+	e.mu.RUnlock()
+
+	manifest := &CaseExportManifest{
+		CaseID:     snapshot.ID,
+		CaseNumber: snapshot.CaseNumber,
+		ExportedAt: time.Now().UTC(),
+	}
+	zw := zip.NewWriter(w)
+	add := func(path string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate, Modified: manifest.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, CaseExportFile{Path: path, Digest: EvidenceDigest(data), Size: int64(len(data))})
+		return nil
+	}
+	addJSON := func(path string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(path, data)
+	}
+
+	if err := add("case.json", caseJSON); err != nil {
+		return nil, err
+	}
+	if err := addJSON("alerts.json", alerts); err != nil {
+		return nil, err
+	}
+	if sar != nil {
+		if err := addJSON("sar.json", sar); err != nil {
+			return nil, err
+		}
+	}
+	if err := add("narrative.md", []byte(CaseNarrative(&snapshot, alerts, sar))); err != nil {
+		return nil, err
+	}
+	for _, ev := range snapshot.Evidence {
+		data, err := e.evidence.Get(ev.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("evidence %s: %w", ev.ID, err)
+		}
+		if err := add("evidence/"+ev.ID+"-"+exportNamePattern.ReplaceAllString(ev.Name, "_"), data); err != nil {
+			return nil, err
+		}
+	}
+	if err := addJSON("manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	// The manifest does not list itself
+	manifest.Files = manifest.Files[:len(manifest.Files)-1]
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// CaseNarrative drafts a SAR narrative from a case: who was involved, what
+// was detected and when, how it was investigated and on what evidence
+func CaseNarrative(c *AMLCase, alerts []*AMLAlert, sar *SuspiciousActivityReport) string {
+	var b strings.Builder
+	date := func(t time.Time) string { return t.UTC().Format("January 2, 2006") }
+
+	fmt.Fprintf(&b, "# SAR narrative draft: case %s\n\n", c.CaseNumber)
+
+	b.WriteString("## Subject\n\n")
+	subject := c.CustomerID
+	if sar != nil && sar.Subject != nil && sar.Subject.Name != "" {
+		subject = fmt.Sprintf("%s (customer %s)", sar.Subject.Name, c.CustomerID)
+	}
+	fmt.Fprintf(&b, "%s\n\n", subject)
+
+	b.WriteString("## Detection\n\n")
+	if c.DetectedAt != nil {
+		fmt.Fprintf(&b, "The activity was first detected on %s.", date(*c.DetectedAt))
+	}
+	if c.DueDate != nil {
+		fmt.Fprintf(&b, " A SAR, if warranted, is due by %s.", date(*c.DueDate))
+	}
+	b.WriteString("\n\n")
+	for _, alert := range alerts {
+		fmt.Fprintf(&b, "- %s: %s alert \"%s\" (risk score %.2f)", date(alert.CreatedAt), alert.AlertType, alert.Title, alert.RiskScore)
+		if alert.Description != "" {
+			fmt.Fprintf(&b, ": %s", alert.Description)
+		}
+		b.WriteString("\n")
+	}
+	if len(alerts) > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Activity\n\n")
+	if sar != nil && sar.DateRange != nil {
+		fmt.Fprintf(&b, "Suspicious activity totalling %s between %s and %s.\n", sar.TotalAmount.StringFixed(2), date(sar.DateRange.Start), date(sar.DateRange.End))
+	}
+	fmt.Fprintf(&b, "%d transaction(s) linked to the case", len(c.Transactions))
+	if len(c.Transactions) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(c.Transactions, ", "))
+	}
+	b.WriteString(".\n\n")
+
+	b.WriteString("## Investigation\n\n")
+	if c.AssignedTo != "" {
+		fmt.Fprintf(&b, "Investigated by %s.\n\n", c.AssignedTo)
+	}
+	for _, event := range c.Timeline {
+		if event.Type != "note_added" {
+			continue
+		}
+		actor := event.Actor
+		if actor == "" {
+			actor = "unknown"
+		}
+		fmt.Fprintf(&b, "- %s, %s: %s\n", date(event.Timestamp), actor, event.Description)
+	}
+	if c.Findings != "" {
+		fmt.Fprintf(&b, "\nFindings: %s\n", c.Findings)
+	}
+	if c.Recommendation != "" {
+		fmt.Fprintf(&b, "\nRecommendation: %s\n", c.Recommendation)
+	}
+	b.WriteString("\n")
+
+	b.WriteString("## Evidence\n\n")
+	if len(c.Evidence) == 0 {
+		b.WriteString("No evidence attached.\n")
+	}
+	for _, ev := range c.Evidence {
+		fmt.Fprintf(&b, "- %s (%s, %s)", ev.Name, ev.Type, ev.Digest)
+		if ev.Description != "" {
+			fmt.Fprintf(&b, ": %s", ev.Description)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+package aml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestEngine_CaseSLAs(t *testing.T) {
+	e := NewEngine(&Config{CaseSARSLA: 30 * 24 * time.Hour})
+	detected := time.Now().Add(-10 * 24 * time.Hour)
+	e.alerts["alert-1"] = &AMLAlert{ID: "alert-1", CustomerID: "cust-1", CreatedAt: detected}
+
+	c := &AMLCase{CustomerID: "cust-1", Alerts: []string{"alert-1"}}
+	if err := e.CreateCase(c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.DetectedAt.Equal(detected) || !c.DueDate.Equal(detected.Add(30*24*time.Hour)) {
+		t.Errorf("SAR clock should start at detection: detected %v, due %v", c.DetectedAt, c.DueDate)
+	}
+	if len(c.SLAs) != 2 || !c.SLAs[0].Running() || !c.SLAs[1].Running() {
+		t.Fatalf("expected two running clocks, got %+v", c.SLAs)
+	}
+
+	if breached := e.CheckCaseSLAs(time.Now()); len(breached) != 0 {
+		t.Errorf("nothing should be breached yet: %+v", breached)
+	}
+	breached := e.CheckCaseSLAs(detected.Add(31 * 24 * time.Hour))
+	if len(breached) != 1 || !c.SLAs[1].Breached || c.Timeline[len(c.Timeline)-1].Type != "sla_breached" {
+		t.Fatalf("expected SAR filing breach, got %+v", c.SLAs)
+	}
+	if again := e.CheckCaseSLAs(detected.Add(32 * 24 * time.Hour)); len(again) != 0 {
+		t.Errorf("a breach should be reported once: %+v", again)
+	}
+	yes := true
+	if cases := e.ListCases(CaseFilter{SLABreached: &yes}); len(cases) != 1 {
+		t.Errorf("expected 1 breached case, got %d", len(cases))
+	}
+
+	if err := e.AssignCase(c.ID, "inv-1"); err != nil {
+		t.Fatal(err)
+	}
+	if c.SLAs[0].Running() {
+		t.Error("assignment should stop the assignment clock")
+	}
+	e.CloseCase(c.ID, "inv-1", "no suspicious activity", false)
+	if c.SLAs[1].Running() {
+		t.Error("closing without a SAR should stop the SAR clock")
+	}
+}
+
+func TestEngine_CaseQueuesAndAutoAssignment(t *testing.T) {
+	e := NewEngine(&Config{
+		CaseQueues: map[string][]string{
+			"high":   {"inv-1", "inv-2"},
+			"medium": {"inv-2"},
+		},
+		MaxCasesPerInvestigator: 2,
+	})
+	e.customerProfiles["risky"] = &CustomerRiskProfile{CustomerID: "risky", RiskLevel: RiskLevelHigh}
+
+	var cases []*AMLCase
+	for i := 0; i < 5; i++ {
+		c := &AMLCase{CustomerID: "risky", Priority: "low"}
+		e.CreateCase(c)
+		cases = append(cases, c)
+	}
+	if cases[0].Queue != "high" {
+		t.Errorf("customer risk should outrank a lower priority, got queue %s", cases[0].Queue)
+	}
+	if cases[0].AssignedTo != "inv-1" || cases[1].AssignedTo != "inv-2" || cases[2].AssignedTo != "inv-1" || cases[3].AssignedTo != "inv-2" {
+		t.Errorf("expected round-robin by workload, got %s %s %s %s",
+			cases[0].AssignedTo, cases[1].AssignedTo, cases[2].AssignedTo, cases[3].AssignedTo)
+	}
+	if cases[4].AssignedTo != "" || cases[4].Status != CaseStatusOpen {
+		t.Errorf("investigators at capacity should leave the case queued: %+v", cases[4])
+	}
+	if queued := e.QueueCases("high"); len(queued) != 1 || queued[0].ID != cases[4].ID {
+		t.Errorf("unexpected queue: %+v", queued)
+	}
+
+	// Closing a case frees its investigator for the next queued case
+	e.CloseCase(cases[0].ID, "inv-1", "false positive", false)
+	if cases[4].AssignedTo != "inv-1" || cases[4].SLAs[0].Running() {
+		t.Errorf("expected the queued case to go to inv-1, got %q", cases[4].AssignedTo)
+	}
+
+	critical := &AMLCase{CustomerID: "cust-2", Priority: "critical"}
+	e.CreateCase(critical)
+	if critical.Queue != "critical" || critical.AssignedTo != "" {
+		t.Errorf("a queue without investigators should not assign: %+v", critical)
+	}
+	if ok, err := e.AutoAssignCase(critical.ID); ok || err != nil {
+		t.Errorf("expected no assignment, got %v, %v", ok, err)
+	}
+
+	queues := e.CaseQueues()
+	if len(queues) != 3 || queues[0].Name != "critical" || queues[0].Unassigned != 1 || queues[1].Open != 4 {
+		t.Errorf("unexpected queues: %+v", queues)
+	}
+	workloads := e.Workloads()
+	if len(workloads) != 2 || workloads[0].OpenCases != 2 || workloads[1].OpenCases != 2 {
+		t.Errorf("unexpected workloads: %+v", workloads)
+	}
+}
+
+func TestEngine_CaseEvidenceAndExport(t *testing.T) {
+	e := NewEngine(&Config{})
+	e.alerts["alert-1"] = &AMLAlert{
+		ID: "alert-1", CustomerID: "cust-1", AlertType: "structuring", Title: "Structured deposits",
+		RiskScore: 0.9, CreatedAt: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC),
+	}
+	c := &AMLCase{CustomerID: "cust-1", Alerts: []string{"alert-1"}}
+	e.CreateCase(c)
+	e.AddCaseNote(c.ID, "inv-1", "Deposits just under $10,000 on consecutive days")
+
+	doc, err := e.AttachEvidence(c.ID, &CaseEvidence{Name: "statement.pdf", ContentType: "application/pdf", AddedBy: "inv-1"}, []byte("%PDF"))
+	if err != nil {
+		t.Fatalf("AttachEvidence failed: %v", err)
+	}
+	if doc.Type != EvidenceDocument || doc.Digest != EvidenceDigest([]byte("%PDF")) || doc.Size != 4 {
+		t.Errorf("unexpected evidence: %+v", doc)
+	}
+	txn := &models.Transaction{ID: "txn-1", Amount: decimal.NewFromInt(9500), Currency: "USD"}
+	if _, err := e.LinkTransaction(c.ID, "inv-1", txn, "third deposit"); err != nil {
+		t.Fatalf("LinkTransaction failed: %v", err)
+	}
+	if len(c.Transactions) != 1 || c.Transactions[0] != "txn-1" {
+		t.Errorf("linked transaction should be added to the case: %v", c.Transactions)
+	}
+	if _, err := e.AttachGraphSnapshot(c.ID, "inv-1", "", 2); err != nil {
+		t.Fatalf("AttachGraphSnapshot failed: %v", err)
+	}
+
+	got, data, err := e.GetEvidence(c.ID, doc.ID)
+	if err != nil || got.Name != "statement.pdf" || string(data) != "%PDF" {
+		t.Errorf("GetEvidence returned %+v, %q, %v", got, data, err)
+	}
+	if _, _, err := e.GetEvidence(c.ID, "missing"); err != ErrEvidenceNotFound {
+		t.Errorf("expected ErrEvidenceNotFound, got %v", err)
+	}
+
+	sar := &SuspiciousActivityReport{CaseID: c.ID, PreparedBy: "inv-1", Subject: &SARSubject{Name: "Jane Roe"}}
+	if err := e.CreateSAR(sar); err != nil {
+		t.Fatal(err)
+	}
+	if c.SARID != sar.ID {
+		t.Error("SAR should be linked to its case")
+	}
+
+	var buf bytes.Buffer
+	manifest, err := e.ExportCase(c.ID, &buf)
+	if err != nil {
+		t.Fatalf("ExportCase failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	for _, name := range []string{"case.json", "alerts.json", "sar.json", "narrative.md", "manifest.json", "evidence/" + doc.ID + "-statement.pdf"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export is missing %s", name)
+		}
+	}
+	if len(manifest.Files) != len(files)-1 {
+		t.Errorf("manifest should list every file but itself: %d of %d", len(manifest.Files), len(files))
+	}
+	for _, f := range manifest.Files {
+		if EvidenceDigest(files[f.Path]) != f.Digest {
+			t.Errorf("digest mismatch for %s", f.Path)
+		}
+	}
+	var exported AMLCase
+	if err := json.Unmarshal(files["case.json"], &exported); err != nil || len(exported.Evidence) != 3 {
+		t.Errorf("unexpected exported case: %v", err)
+	}
+	narrative := string(files["narrative.md"])
+	for _, want := range []string{"Jane Roe", "March 2, 2026", "Structured deposits", "consecutive days", "statement.pdf", "txn-1"} {
+		if !strings.Contains(narrative, want) {
+			t.Errorf("narrative is missing %q:\n%s", want, narrative)
+		}
+	}
+}
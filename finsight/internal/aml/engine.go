@@ -2,12 +2,17 @@ package aml
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/fx"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
 )
@@ -19,9 +24,15 @@ type Engine struct {
 	cases            map[string]*AMLCase
 	sars             map[string]*SuspiciousActivityReport
 	ctrs             map[string]*CurrencyTransactionReport
+	bsaBatches       map[string]*BSABatch
 	customerProfiles map[string]*CustomerRiskProfile
 	watchlistMgr     *WatchlistManager
+	screened         map[string]*screeningSubject
 	scenarioMgr      *ScenarioManager
+	graph            *TransactionGraph
+	converter        *fx.Converter
+	approvals        *approval.Workflow
+	evidence         EvidenceStore
 	mu               sync.RWMutex
 	running          bool
 	stopCh           chan struct{}
@@ -37,7 +48,21 @@ type Config struct {
 	RiskScoreThreshold   float64         `json:"risk_score_threshold"`   // Default 0.7
 	HighRiskCountries    []string        `json:"high_risk_countries"`
 	WatchlistSources     []string        `json:"watchlist_sources"`
+	WatchlistThreshold   float64         `json:"watchlist_threshold"`    // Default 0.85
+	WatchlistFeeds       []WatchlistFeed `json:"watchlist_feeds"`
+	WatchlistRefresh     time.Duration   `json:"watchlist_refresh"`      // Default 24 hours
+	WatchlistMaxShrink   float64         `json:"watchlist_max_shrink"`   // Default 0.2 of the entries
 	AlertRetentionDays   int             `json:"alert_retention_days"`
+	GraphWindow          time.Duration   `json:"graph_window"`           // Default 30 days
+	GraphFanThreshold    int             `json:"graph_fan_threshold"`    // Default 5 counterparties
+	GraphCycleDepth      int             `json:"graph_cycle_depth"`      // Default 5 accounts
+	BSA                  BSAConfig       `json:"bsa"`
+
+	CaseAssignmentSLA       time.Duration       `json:"case_assignment_sla"`        // Default 48 hours
+	CaseSARSLA              time.Duration       `json:"case_sar_sla"`               // Default 30 days from detection
+	CaseQueues              map[string][]string `json:"case_queues"`                // Risk level to investigators
+	MaxCasesPerInvestigator int                 `json:"max_cases_per_investigator"` // 0 for no limit
+	EvidenceDir             string              `json:"evidence_dir"`               // Empty keeps evidence in memory
 }
 
 // NewEngine creates a new AML engine
@@ -55,20 +80,72 @@ func NewEngine(config *Config) *Engine {
 		config.RiskScoreThreshold = 0.7
 	}
 
+	if config.GraphFanThreshold == 0 {
+		config.GraphFanThreshold = defaultGraphFanOut
+	}
+	if config.GraphCycleDepth == 0 {
+		config.GraphCycleDepth = defaultGraphCycleDepth
+	}
+
+	watchlistMgr := NewWatchlistManager()
+	if config.WatchlistThreshold > 0 {
+		watchlistMgr.SetThreshold(config.WatchlistThreshold)
+	}
+	if config.WatchlistMaxShrink > 0 {
+		watchlistMgr.SetMaxShrink(config.WatchlistMaxShrink)
+	}
+
+	scenarioMgr := NewScenarioManager(config)
+
+	var evidence EvidenceStore = NewMemoryEvidenceStore()
+	if config.EvidenceDir != "" {
+		store, err := NewFileEvidenceStore(config.EvidenceDir)
+		if err != nil {
+			log.Printf("aml: %v; keeping evidence in memory", err)
+		} else {
+			evidence = store
+		}
+	}
+
 	return &Engine{
 		config:           config,
 		alerts:           make(map[string]*AMLAlert),
 		cases:            make(map[string]*AMLCase),
 		sars:             make(map[string]*SuspiciousActivityReport),
 		ctrs:             make(map[string]*CurrencyTransactionReport),
+		bsaBatches:       make(map[string]*BSABatch),
 		customerProfiles: make(map[string]*CustomerRiskProfile),
-		watchlistMgr:     NewWatchlistManager(),
-		scenarioMgr:      NewScenarioManager(config),
+		watchlistMgr:     watchlistMgr,
+		screened:         make(map[string]*screeningSubject),
+		scenarioMgr:      scenarioMgr,
+		graph:            scenarioMgr.graph,
+		evidence:         evidence,
 		stopCh:           make(chan struct{}),
 		alertCh:          make(chan *AMLAlert, 100),
 	}
 }
 
+// SetConverter sets the FX converter used to express amounts in the base
+// currency before thresholds are applied
+func (e *Engine) SetConverter(c *fx.Converter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.converter = c
+}
+
+// ApprovalActionSAR is the approval action of submitted SARs
+const ApprovalActionSAR = "sar_approval"
+
+// SetApprovals routes SAR approval through a maker-checker workflow: a
+// submitted SAR is approved once its policy's approvers have approved it,
+// and returns to draft when rejected
+func (e *Engine) SetApprovals(w *approval.Workflow) {
+	e.mu.Lock()
+	e.approvals = w
+	e.mu.Unlock()
+	w.Register(ApprovalActionSAR, e.applySARApproval, e.applySARRejection)
+}
+
 // Start starts the AML engine
 func (e *Engine) Start(ctx context.Context) error {
 	e.mu.Lock()
@@ -81,6 +158,9 @@ func (e *Engine) Start(ctx context.Context) error {
 
 	go e.processAlerts(ctx)
 	go e.periodicReview(ctx)
+	if len(e.config.WatchlistFeeds) > 0 {
+		go e.refreshWatchlists(ctx)
+	}
 
 	return nil
 }
@@ -103,13 +183,22 @@ func (e *Engine) processAlerts(ctx context.Context) {
 		case <-e.stopCh:
 			return
 		case alert := <-e.alertCh:
-			e.mu.Lock()
-			e.alerts[alert.ID] = alert
-			e.mu.Unlock()
+			e.storeAlert(alert)
 		}
 	}
 }
 
+// storeAlert records an alert and flags critical alerts in the transaction
+// graph
+func (e *Engine) storeAlert(alert *AMLAlert) {
+	e.mu.Lock()
+	e.alerts[alert.ID] = alert
+	e.mu.Unlock()
+	if alert.Severity == models.AlertSeverityCritical && alert.CustomerID != "" {
+		e.graph.Flag(alert.CustomerID, string(alert.AlertType))
+	}
+}
+
 func (e *Engine) periodicReview(ctx context.Context) {
 	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
@@ -123,6 +212,8 @@ func (e *Engine) periodicReview(ctx context.Context) {
 		case <-ticker.C:
 			e.reviewExpiringKYC()
 			e.cleanupOldAlerts()
+			e.graph.Prune(time.Now())
+			e.CheckCaseSLAs(time.Now())
 		}
 	}
 }
@@ -138,6 +229,23 @@ func (e *Engine) AnalyzeTransaction(ctx context.Context, txn *models.Transaction
 		Timestamp:     time.Now(),
 	}
 
+	// Express the amount in the base currency before applying thresholds. A
+	// transaction in a currency without a rate is still screened, and sent
+	// for review since its amount cannot be held against the thresholds.
+	e.mu.RLock()
+	converter := e.converter
+	e.mu.RUnlock()
+	unconverted := false
+	if converter != nil {
+		if err := converter.Apply(txn); err != nil {
+			if !errors.Is(err, fx.ErrRateNotFound) {
+				return nil, fmt.Errorf("convert transaction %s: %w", txn.ID, err)
+			}
+			log.Printf("aml: %s left unconverted, flagging for review: %v", txn.ID, err)
+			unconverted = true
+		}
+	}
+
 	// Get customer profile
 	profile := e.getOrCreateProfile(txn.SourceAccount)
 
@@ -161,11 +269,18 @@ func (e *Engine) AnalyzeTransaction(ctx context.Context, txn *models.Transaction
 	}
 
 	result.RiskScore = normalizeScore(totalScore)
+	if unconverted {
+		indicators = append(indicators, AlertIndicator{
+			Type:        string(AlertTypeUnconverted),
+			Description: fmt.Sprintf("No rate to convert %s into the base currency", txn.Currency),
+			Evidence:    map[string]interface{}{"amount": txn.Amount.String(), "currency": txn.Currency},
+		})
+	}
 	result.Indicators = indicators
 
 	// Check for CTR requirement
-	if txn.Type == models.TransactionTypeDebit || txn.Type == models.TransactionTypeCredit {
-		if txn.Amount.GreaterThanOrEqual(e.config.CTRThreshold) {
+	if !unconverted && (txn.Type == models.TransactionTypeDebit || txn.Type == models.TransactionTypeCredit) {
+		if txn.ReportingAmount().GreaterThanOrEqual(e.config.CTRThreshold) {
 			result.CTRRequired = true
 		}
 	}
@@ -185,12 +300,22 @@ func (e *Engine) AnalyzeTransaction(ctx context.Context, txn *models.Transaction
 		alert := e.createAlert(txn, profile, result, indicators)
 		alert.Severity = models.AlertSeverityMedium
 		e.alertCh <- alert
+	} else if unconverted {
+		result.Decision = "review"
+		result.Reason = "Amount not convertible to the base currency - requires review"
+
+		alert := e.createAlert(txn, profile, result, indicators)
+		alert.AlertType = AlertTypeUnconverted
+		alert.Severity = models.AlertSeverityMedium
+		e.alertCh <- alert
 	} else {
 		result.Decision = "allow"
 	}
 
-	// Update customer profile
-	e.updateProfileFromTransaction(profile, txn)
+	// Update customer profile; an amount in another currency would skew it
+	if !unconverted {
+		e.updateProfileFromTransaction(profile, txn)
+	}
 
 	return result, nil
 }
@@ -237,7 +362,7 @@ func (e *Engine) updateProfileFromTransaction(profile *CustomerRiskProfile, txn
 
 	// Update average transaction size
 	profile.TransactionProfile.AverageTransactionSize = profile.TransactionProfile.AverageTransactionSize.
-		Add(txn.Amount).
+		Add(txn.ReportingAmount()).
 		Div(decimal.NewFromInt(2))
 
 	profile.UpdatedAt = time.Now()
@@ -273,36 +398,15 @@ func (e *Engine) createAlert(txn *models.Transaction, profile *CustomerRiskProfi
 	}
 }
 
-// ScreenCustomer screens a customer against watchlists
+// ScreenCustomer screens a customer against watchlists. The customer is
+// remembered and re-screened whenever a list is updated; alerts are only
+// raised for matches not already reported.
 func (e *Engine) ScreenCustomer(ctx context.Context, customerID string, name string, dob string, country string) ([]WatchlistMatch, error) {
 	matches := e.watchlistMgr.Screen(name, dob, country)
 
-	// Update customer profile with matches
-	e.mu.Lock()
-	profile, ok := e.customerProfiles[customerID]
-	if ok {
-		profile.WatchlistMatches = append(profile.WatchlistMatches, matches...)
-		if len(matches) > 0 {
-			profile.RiskLevel = RiskLevelHigh
-			profile.RiskScore = 0.9
-		}
-	}
-	e.mu.Unlock()
-
-	// Generate alerts for matches
-	for _, match := range matches {
-		alert := &AMLAlert{
-			ID:          generateID("alert"),
-			CustomerID:  customerID,
-			AlertType:   AlertTypeWatchlistMatch,
-			Severity:    models.AlertSeverityCritical,
-			Status:      models.AlertStatusOpen,
-			Title:       fmt.Sprintf("Watchlist Match: %s", match.WatchlistType),
-			Description: fmt.Sprintf("Customer matched against %s watchlist: %s (score: %.2f)", match.WatchlistType, match.MatchedName, match.MatchScore),
-			RiskScore:   match.MatchScore,
-			CreatedAt:   time.Now(),
-		}
-		e.alertCh <- alert
+	// Generate alerts for new matches
+	for _, match := range e.recordMatches(customerID, name, dob, country, matches) {
+		e.alertCh <- e.watchlistAlert(customerID, match, "")
 	}
 
 	return matches, nil
@@ -316,6 +420,14 @@ func (e *Engine) CreateSAR(sar *SuspiciousActivityReport) error {
 	if sar.ID == "" {
 		sar.ID = generateID("sar")
 	}
+	if sar.CaseID != "" {
+		c, ok := e.cases[sar.CaseID]
+		if !ok {
+			return fmt.Errorf("case not found: %s", sar.CaseID)
+		}
+		c.SARID = sar.ID
+		c.SARRequired = true
+	}
 	sar.Status = SARStatusDraft
 	sar.CreatedAt = time.Now()
 	sar.UpdatedAt = time.Now()
@@ -373,69 +485,123 @@ func matchesSARFilter(sar *SuspiciousActivityReport, filter SARFilter) bool {
 	return true
 }
 
-// SubmitSAR submits a SAR for review
+// SubmitSAR submits a SAR for review, opening its approval request when an
+// approval workflow is set
 func (e *Engine) SubmitSAR(id string) error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	sar, ok := e.sars[id]
 	if !ok {
+		e.mu.Unlock()
 		return fmt.Errorf("SAR not found: %s", id)
 	}
 
 	if sar.Status != SARStatusDraft {
+		e.mu.Unlock()
 		return fmt.Errorf("SAR must be in draft status to submit")
 	}
+	if sar.PreparedBy == "" {
+		e.mu.Unlock()
+		return fmt.Errorf("SAR must have a preparer to submit")
+	}
 
 	sar.Status = SARStatusPending
 	sar.UpdatedAt = time.Now()
+	approvals := e.approvals
+	preparedBy := sar.PreparedBy
+	summary := "SAR " + sar.ID
+	if sar.Subject != nil && sar.Subject.Name != "" {
+		summary += " on " + sar.Subject.Name
+	}
+	details := map[string]interface{}{"filing_type": sar.FilingType, "total_amount": sar.TotalAmount.String()}
+	e.mu.Unlock()
+
+	if approvals == nil {
+		return nil
+	}
+	// The workflow calls back into the engine, so it is opened unlocked
+	if _, err := approvals.Open(ApprovalActionSAR, id, preparedBy, summary, details); err != nil {
+		e.mu.Lock()
+		sar.Status = SARStatusDraft
+		e.mu.Unlock()
+		return err
+	}
 	return nil
 }
 
-// ApproveSAR approves a SAR
+// ApproveSAR records an approver's approval of a SAR pending review. The
+// preparer cannot approve their own SAR. With an approval workflow set,
+// the SAR is approved once its policy is satisfied.
 func (e *Engine) ApproveSAR(id, approver string) error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	sar, ok := e.sars[id]
 	if !ok {
+		e.mu.Unlock()
 		return fmt.Errorf("SAR not found: %s", id)
 	}
-
 	if sar.Status != SARStatusPending {
+		e.mu.Unlock()
 		return fmt.Errorf("SAR must be pending review to approve")
 	}
+	approvals := e.approvals
+	if approvals == nil {
+		defer e.mu.Unlock()
+		if approver == "" {
+			return approval.ErrApproverRequired
+		}
+		if approver == sar.PreparedBy {
+			return approval.ErrSelfApproval
+		}
+		sar.Status = SARStatusApproved
+		sar.ApprovedBy = approver
+		sar.UpdatedAt = time.Now()
+		return nil
+	}
+	e.mu.Unlock()
+
+	_, err := approvals.ApproveEntity(ApprovalActionSAR, id, approver, "")
+	return err
+}
 
+func (e *Engine) applySARApproval(req *approval.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sar, ok := e.sars[req.EntityID]
+	if !ok {
+		return fmt.Errorf("SAR not found: %s", req.EntityID)
+	}
+	if sar.Status != SARStatusPending {
+		return fmt.Errorf("SAR must be pending review to approve")
+	}
 	sar.Status = SARStatusApproved
-	sar.ApprovedBy = approver
+	sar.ApprovedBy = strings.Join(req.Approvers(), ", ")
 	sar.UpdatedAt = time.Now()
 	return nil
 }
 
-// FileSAR files an approved SAR with FinCEN
-func (e *Engine) FileSAR(ctx context.Context, id string) error {
+// applySARRejection returns a rejected SAR to its preparer as a draft
+func (e *Engine) applySARRejection(req *approval.Request) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	sar, ok := e.sars[id]
+	sar, ok := e.sars[req.EntityID]
 	if !ok {
-		return fmt.Errorf("SAR not found: %s", id)
+		return fmt.Errorf("SAR not found: %s", req.EntityID)
 	}
-
-	if sar.Status != SARStatusApproved {
-		return fmt.Errorf("SAR must be approved to file")
+	if sar.Status == SARStatusPending {
+		sar.Status = SARStatusDraft
+		sar.ReviewedBy = req.Rejection.Actor
+		sar.UpdatedAt = time.Now()
 	}
-
-	// In production, this would submit to FinCEN BSA E-Filing System
-	sar.Status = SARStatusFiled
-	now := time.Now()
-	sar.FiledAt = &now
-	sar.BSAIdentifier = generateBSAID()
-	sar.UpdatedAt = time.Now()
-
 	return nil
 }
 
+// FileSAR files an approved or FinCEN-rejected SAR in a single-report batch
+func (e *Engine) FileSAR(ctx context.Context, id string) error {
+	_, err := e.FileSARs(ctx, []string{id})
+	return err
+}
+
 // CreateCTR creates a new Currency Transaction Report
 func (e *Engine) CreateCTR(ctr *CurrencyTransactionReport) error {
 	e.mu.Lock()
@@ -459,23 +625,28 @@ func (e *Engine) GetCTR(id string) (*CurrencyTransactionReport, bool) {
 	return ctr, ok
 }
 
-// FileCTR files a CTR with FinCEN
-func (e *Engine) FileCTR(ctx context.Context, id string) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// ListCTRs returns CTRs with a status, or all CTRs when status is empty,
+// newest first
+func (e *Engine) ListCTRs(status CTRStatus) []*CurrencyTransactionReport {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-	ctr, ok := e.ctrs[id]
-	if !ok {
-		return fmt.Errorf("CTR not found: %s", id)
+	var results []*CurrencyTransactionReport
+	for _, ctr := range e.ctrs {
+		if status == "" || ctr.Status == status {
+			results = append(results, ctr)
+		}
 	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	return results
+}
 
-	// In production, this would submit to FinCEN
-	ctr.Status = CTRStatusFiled
-	now := time.Now()
-	ctr.FiledAt = &now
-	ctr.BSAIdentifier = generateBSAID()
-
-	return nil
+// FileCTR files a CTR with FinCEN in a single-report batch
+func (e *Engine) FileCTR(ctx context.Context, id string) error {
+	_, err := e.FileCTRs(ctx, []string{id})
+	return err
 }
 
 // CreateCase creates a new AML investigation case
@@ -501,6 +672,7 @@ func (e *Engine) CreateCase(amlCase *AMLCase) error {
 		Timestamp:   time.Now(),
 	})
 
+	e.initCase(amlCase, amlCase.CreatedAt)
 	e.cases[amlCase.ID] = amlCase
 	return nil
 }
@@ -529,13 +701,15 @@ func (e *Engine) ListCases(filter CaseFilter) []*AMLCase {
 
 // CaseFilter defines filters for case queries
 type CaseFilter struct {
-	Status     CaseStatus
-	AssignedTo string
-	CustomerID string
-	Priority   string
-	StartDate  *time.Time
-	EndDate    *time.Time
-	Limit      int
+	Status      CaseStatus
+	AssignedTo  string
+	CustomerID  string
+	Priority    string
+	Queue       string
+	SLABreached *bool
+	StartDate   *time.Time
+	EndDate     *time.Time
+	Limit       int
 }
 
 func matchesCaseFilter(c *AMLCase, filter CaseFilter) bool {
@@ -551,6 +725,12 @@ func matchesCaseFilter(c *AMLCase, filter CaseFilter) bool {
 	if filter.Priority != "" && c.Priority != filter.Priority {
 		return false
 	}
+	if filter.Queue != "" && c.Queue != filter.Queue {
+		return false
+	}
+	if filter.SLABreached != nil && slaBreached(c) != *filter.SLABreached {
+		return false
+	}
 	return true
 }
 
@@ -567,6 +747,7 @@ func (e *Engine) AssignCase(id, assignee string) error {
 	c.AssignedTo = assignee
 	c.Status = CaseStatusInProgress
 	c.UpdatedAt = time.Now()
+	stopClock(c, ClockAssignment, c.UpdatedAt)
 
 	c.Timeline = append(c.Timeline, CaseEvent{
 		ID:          generateID("event"),
@@ -626,6 +807,13 @@ func (e *Engine) CloseCase(id, actor, reason string, sarRequired bool) error {
 		Timestamp:   now,
 	})
 
+	// The SAR clock keeps running until the SAR is filed
+	stopClock(c, ClockAssignment, now)
+	if !sarRequired {
+		stopClock(c, ClockSARFiling, now)
+	}
+	e.assignNext(c.AssignedTo, now)
+
 	return nil
 }
 
@@ -695,12 +883,14 @@ func (e *Engine) ResolveAlert(id, resolution string, createCase bool) error {
 			ID:         generateID("case"),
 			CaseNumber: generateCaseNumber(),
 			Status:     CaseStatusOpen,
+			Priority:   string(alert.Severity),
 			CustomerID: alert.CustomerID,
 			Type:       "alert_driven",
 			Alerts:     []string{alert.ID},
 			CreatedAt:  time.Now(),
 			UpdatedAt:  time.Now(),
 		}
+		e.initCase(amlCase, amlCase.CreatedAt)
 		e.cases[amlCase.ID] = amlCase
 		alert.CaseID = amlCase.ID
 	}
@@ -868,14 +1058,14 @@ func generateCaseNumber() string {
 	return fmt.Sprintf("AML-%s-%04d", time.Now().Format("200601"), time.Now().UnixNano()%10000)
 }
 
-func generateBSAID() string {
-	return fmt.Sprintf("BSA%d", time.Now().UnixNano())
-}
-
 // WatchlistManager manages watchlist screening
 type WatchlistManager struct {
-	entries map[WatchlistType][]WatchlistEntry
-	mu      sync.RWMutex
+	entries      map[WatchlistType][]WatchlistEntry
+	fingerprints map[WatchlistType]map[string]string
+	versions     map[WatchlistType][]WatchlistVersion
+	threshold    float64
+	maxShrink    float64 // share of a list's entries one update may remove
+	mu           sync.RWMutex
 }
 
 // WatchlistEntry represents an entry in a watchlist
@@ -889,85 +1079,168 @@ type WatchlistEntry struct {
 	Programs []string      `json:"programs,omitempty"`
 }
 
+// Date of birth and country adjust the name score so that namesakes with
+// conflicting identifiers fall below the threshold
+const (
+	dobExactBoost    = 0.05
+	dobYearBoost     = 0.02
+	dobConflict      = -0.15
+	countryBoost     = 0.03
+	countryConflict  = -0.05
+	aliasWeight      = 0.97
+	defaultThreshold = 0.85
+	defaultMaxShrink = 0.2
+)
+
 // NewWatchlistManager creates a new watchlist manager
 func NewWatchlistManager() *WatchlistManager {
 	return &WatchlistManager{
-		entries: make(map[WatchlistType][]WatchlistEntry),
+		entries:      make(map[WatchlistType][]WatchlistEntry),
+		fingerprints: make(map[WatchlistType]map[string]string),
+		versions:     make(map[WatchlistType][]WatchlistVersion),
+		threshold:    defaultThreshold,
+		maxShrink:    defaultMaxShrink,
 	}
 }
 
-// Screen screens a name against watchlists
+// SetThreshold sets the minimum score reported as a match
+func (m *WatchlistManager) SetThreshold(threshold float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.threshold = threshold
+}
+
+// SetMaxShrink sets the largest share of a list's entries that one update
+// may remove without an explicit override
+func (m *WatchlistManager) SetMaxShrink(share float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxShrink = share
+}
+
+// Screen screens a name against watchlists. Each entry's primary name and
+// aliases are compared with CompareNames; the date of birth and country are
+// then used as tie-breakers.
 func (m *WatchlistManager) Screen(name, dob, country string) []WatchlistMatch {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var matches []WatchlistMatch
-	nameLower := strings.ToLower(name)
-
 	for listType, entries := range m.entries {
-		for _, entry := range entries {
-			score := m.calculateMatchScore(nameLower, entry)
-			if score >= 0.8 {
-				matches = append(matches, WatchlistMatch{
-					ID:            generateID("match"),
-					WatchlistType: listType,
-					MatchedName:   entry.Name,
-					MatchScore:    score,
-					MatchType:     m.getMatchType(score),
-					ListEntryID:   entry.ID,
-					Status:        "pending_review",
-					MatchedAt:     time.Now(),
-				})
-			}
-		}
+		matches = append(matches, m.screenEntries(name, dob, country, listType, entries)...)
 	}
 
+	sort.Slice(matches, func(i, j int) bool { return matches[i].MatchScore > matches[j].MatchScore })
 	return matches
 }
 
-func (m *WatchlistManager) calculateMatchScore(name string, entry WatchlistEntry) float64 {
-	entryNameLower := strings.ToLower(entry.Name)
-
-	// Exact match
-	if name == entryNameLower {
-		return 1.0
+func (m *WatchlistManager) screenEntries(name, dob, country string, listType WatchlistType, entries []WatchlistEntry) []WatchlistMatch {
+	var matches []WatchlistMatch
+	for _, entry := range entries {
+		match, ok := m.scoreEntry(name, dob, country, entry)
+		if !ok {
+			continue
+		}
+		match.WatchlistType = listType
+		matches = append(matches, match)
 	}
+	return matches
+}
 
-	// Check aliases
+func (m *WatchlistManager) scoreEntry(name, dob, country string, entry WatchlistEntry) (WatchlistMatch, bool) {
+	best := CompareNames(name, entry.Name)
+	matchedOn, matchedAlias := entry.Name, ""
 	for _, alias := range entry.Aliases {
-		if name == strings.ToLower(alias) {
-			return 0.95
+		candidate := CompareNames(name, alias)
+		candidate.Score *= aliasWeight
+		if candidate.Score > best.Score {
+			best, matchedOn, matchedAlias = candidate, alias, alias
 		}
 	}
-
-	// Simple fuzzy match (Levenshtein would be better)
-	if strings.Contains(name, entryNameLower) || strings.Contains(entryNameLower, name) {
-		return 0.85
-	}
-
-	return 0
-}
-
-func (m *WatchlistManager) getMatchType(score float64) string {
-	if score >= 0.99 {
+	// Identifiers can lift a borderline name match by at most the DOB and
+	// country boosts, so skip anything that cannot reach the threshold
+	if best.Score+dobExactBoost+countryBoost < m.threshold {
+		return WatchlistMatch{}, false
+	}
+
+	score := best.Score
+	var tieBreakers []string
+	switch exact, matched, conflict := compareDOB(dob, entry.DOB); {
+	case exact:
+		score += dobExactBoost
+		tieBreakers = append(tieBreakers, "dob_exact")
+	case matched:
+		score += dobYearBoost
+		tieBreakers = append(tieBreakers, "dob_year")
+	case conflict:
+		score += dobConflict
+		tieBreakers = append(tieBreakers, "dob_mismatch")
+	}
+	switch matched, conflict := compareCountry(country, entry.Country); {
+	case matched:
+		score += countryBoost
+		tieBreakers = append(tieBreakers, "country_match")
+	case conflict:
+		score += countryConflict
+		tieBreakers = append(tieBreakers, "country_mismatch")
+	}
+	score = math.Max(0, math.Min(1, score))
+	if score < m.threshold {
+		return WatchlistMatch{}, false
+	}
+
+	matchType := matchTypeFor(best.Algorithm)
+	if matchedAlias != "" && matchType == "exact" {
+		matchType = "alias"
+	}
+
+	explanation := fmt.Sprintf("%s matched %q with %s (name score %.2f)", NormalizeName(name), matchedOn, best.Algorithm, best.Score)
+	if matchedAlias != "" {
+		explanation += fmt.Sprintf(", alias of %q", entry.Name)
+	}
+	if len(tieBreakers) > 0 {
+		explanation += "; " + strings.Join(tieBreakers, ", ")
+	}
+
+	return WatchlistMatch{
+		ID:              generateID("match"),
+		MatchedName:     entry.Name,
+		MatchedAlias:    matchedAlias,
+		MatchScore:      score,
+		NameScore:       best.Score,
+		MatchType:       matchType,
+		Algorithm:       best.Algorithm,
+		AlgorithmScores: best.Scores,
+		TieBreakers:     tieBreakers,
+		Explanation:     explanation,
+		ListEntryID:     entry.ID,
+		Status:          "pending_review",
+		MatchedAt:       time.Now(),
+	}, true
+}
+
+func matchTypeFor(algorithm string) string {
+	switch algorithm {
+	case AlgorithmExact, AlgorithmTokenOrder:
 		return "exact"
-	} else if score >= 0.95 {
-		return "alias"
+	case AlgorithmDoubleMetaphone:
+		return "phonetic"
 	}
 	return "fuzzy"
 }
 
-// LoadWatchlist loads entries into a watchlist
+// LoadWatchlist replaces the entries of a watchlist, recording a new version
+// if anything changed. The entries are taken as given, even if they remove
+// most of the list.
 func (m *WatchlistManager) LoadWatchlist(listType WatchlistType, entries []WatchlistEntry) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.entries[listType] = entries
+	m.Update(&SanctionsList{Type: listType, Source: "manual", Entries: entries}, true)
 }
 
 // ScenarioManager manages AML detection scenarios
 type ScenarioManager struct {
 	config    *Config
 	scenarios []Scenario
+	graph     *TransactionGraph
 }
 
 // Scenario represents an AML detection scenario
@@ -987,7 +1260,7 @@ type ScenarioResult struct {
 
 // NewScenarioManager creates a new scenario manager
 func NewScenarioManager(config *Config) *ScenarioManager {
-	mgr := &ScenarioManager{config: config}
+	mgr := &ScenarioManager{config: config, graph: NewTransactionGraph(config.GraphWindow)}
 	mgr.initializeScenarios()
 	return mgr
 }
@@ -999,6 +1272,7 @@ func (m *ScenarioManager) initializeScenarios() {
 		&RoundAmountScenario{},
 		&RapidMovementScenario{},
 		&UnusualVolumeScenario{},
+		&NetworkScenario{graph: m.graph, fanOut: m.config.GraphFanThreshold, cycleDepth: m.config.GraphCycleDepth},
 	}
 }
 
@@ -1025,12 +1299,13 @@ func (s *StructuringScenario) Evaluate(txn *models.Transaction, profile *Custome
 
 	// Check if amount is just below CTR threshold
 	ctrThreshold := decimal.NewFromInt(10000)
-	if txn.Amount.GreaterThanOrEqual(s.threshold) && txn.Amount.LessThan(ctrThreshold) {
+	amount := txn.ReportingAmount()
+	if amount.GreaterThanOrEqual(s.threshold) && amount.LessThan(ctrThreshold) {
 		result.Triggered = true
 		result.Score = 3.0
 		result.Description = "Transaction amount just below CTR reporting threshold"
 		result.Evidence = map[string]interface{}{
-			"amount":    txn.Amount.String(),
+			"amount":    amount.String(),
 			"threshold": ctrThreshold.String(),
 		}
 	}
@@ -1108,12 +1383,13 @@ func (s *UnusualVolumeScenario) Evaluate(txn *models.Transaction, profile *Custo
 
 	if profile.TransactionProfile != nil {
 		expected := profile.TransactionProfile.ExpectedMonthlyVolume
-		if !expected.IsZero() && txn.Amount.GreaterThan(expected.Mul(decimal.NewFromFloat(0.5))) {
+		amount := txn.ReportingAmount()
+		if !expected.IsZero() && amount.GreaterThan(expected.Mul(decimal.NewFromFloat(0.5))) {
 			result.Triggered = true
 			result.Score = 2.5
 			result.Description = "Transaction significantly exceeds expected volume"
 			result.Evidence = map[string]interface{}{
-				"amount":   txn.Amount.String(),
+				"amount":   amount.String(),
 				"expected": expected.String(),
 			}
 		}
@@ -0,0 +1,106 @@
+package aml
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/internal/fx"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestEngine_ApproveSAR(t *testing.T) {
+	e := NewEngine(&Config{})
+	sar := &SuspiciousActivityReport{PreparedBy: "analyst"}
+	if err := e.CreateSAR(sar); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ApproveSAR(sar.ID, "officer"); err == nil {
+		t.Error("expected error approving a draft SAR")
+	}
+	if err := e.SubmitSAR(sar.ID); err != nil {
+		t.Fatalf("SubmitSAR failed: %v", err)
+	}
+	if err := e.ApproveSAR(sar.ID, "analyst"); err != approval.ErrSelfApproval {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+	if err := e.ApproveSAR(sar.ID, "officer"); err != nil {
+		t.Fatalf("ApproveSAR failed: %v", err)
+	}
+	if sar.Status != SARStatusApproved || sar.ApprovedBy != "officer" {
+		t.Errorf("unexpected SAR: status %s, approved by %q", sar.Status, sar.ApprovedBy)
+	}
+
+	unprepared := &SuspiciousActivityReport{}
+	e.CreateSAR(unprepared)
+	if err := e.SubmitSAR(unprepared.ID); err == nil {
+		t.Error("expected error submitting a SAR without a preparer")
+	}
+}
+
+func TestEngine_ApproveSAR_Workflow(t *testing.T) {
+	e := NewEngine(&Config{})
+	w := approval.NewWorkflow(&config.ApprovalConfig{
+		Policies: map[string]config.ApprovalPolicy{
+			ApprovalActionSAR: {Roles: []string{"bsa_officer"}, Approvers: 1},
+		},
+		Roles: map[string][]string{"officer": {"bsa_officer"}, "analyst": {"bsa_officer"}},
+	})
+	e.SetApprovals(w)
+
+	sar := &SuspiciousActivityReport{PreparedBy: "analyst", Subject: &SARSubject{Name: "Acme Ltd"}}
+	e.CreateSAR(sar)
+	if err := e.SubmitSAR(sar.ID); err != nil {
+		t.Fatalf("SubmitSAR failed: %v", err)
+	}
+	inbox := w.Inbox("officer")
+	if len(inbox) != 1 || inbox[0].EntityID != sar.ID || inbox[0].Summary != "SAR "+sar.ID+" on Acme Ltd" {
+		t.Fatalf("unexpected inbox: %+v", inbox)
+	}
+	if err := e.ApproveSAR(sar.ID, "analyst"); err != approval.ErrSelfApproval {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+
+	// Approving from the inbox applies the approval to the SAR
+	if _, err := w.Approve(inbox[0].ID, "officer", "narrative complete"); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if sar.Status != SARStatusApproved || sar.ApprovedBy != "officer" {
+		t.Errorf("unexpected SAR: status %s, approved by %q", sar.Status, sar.ApprovedBy)
+	}
+}
+
+func TestEngine_AnalyzeTransaction_Unconverted(t *testing.T) {
+	e := NewEngine(&Config{Enabled: true, RiskScoreThreshold: 0.8, CTRThreshold: decimal.NewFromInt(10000)})
+	e.SetConverter(fx.NewConverter(&config.FXConfig{BaseCurrency: "USD"}))
+
+	txn := &models.Transaction{
+		ID:            "TXN-001",
+		Type:          models.TransactionTypeDebit,
+		SourceAccount: "ACC-001",
+		Amount:        decimal.NewFromInt(50),
+		Currency:      "JPY",
+		CreatedAt:     time.Now(),
+	}
+	result, err := e.AnalyzeTransaction(context.Background(), txn)
+	if err != nil {
+		t.Fatalf("AnalyzeTransaction failed: %v", err)
+	}
+	if result.Decision != "review" {
+		t.Errorf("Decision = %s, want review", result.Decision)
+	}
+	if len(result.Indicators) == 0 || result.Indicators[len(result.Indicators)-1].Type != string(AlertTypeUnconverted) {
+		t.Errorf("Indicators = %+v, want unconverted amount", result.Indicators)
+	}
+	select {
+	case alert := <-e.alertCh:
+		if alert.AlertType != AlertTypeUnconverted {
+			t.Errorf("alert type = %s", alert.AlertType)
+		}
+	default:
+		t.Error("no alert raised")
+	}
+}
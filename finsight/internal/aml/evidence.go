@@ -0,0 +1,143 @@
+package aml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// EvidenceStore stores case evidence by content. Identical content is
+// stored once, and the digest both names and verifies it.
+type EvidenceStore interface {
+	Put(data []byte) (string, error)
+	Get(digest string) ([]byte, error)
+}
+
+// ErrEvidenceNotFound is returned for a digest the store does not hold
+var ErrEvidenceNotFound = errors.New("evidence not found")
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// EvidenceDigest returns the content address of evidence, "sha256:" and
+// the hex SHA-256 of the content
+func EvidenceDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// verifyEvidence checks content read back against its digest
+func verifyEvidence(digest string, data []byte) error {
+	if EvidenceDigest(data) != digest {
+		return fmt.Errorf("evidence %s does not match its digest", digest)
+	}
+	return nil
+}
+
+// MemoryEvidenceStore keeps evidence in memory
+type MemoryEvidenceStore struct {
+	blobs map[string][]byte
+	mu    sync.RWMutex
+}
+
+// NewMemoryEvidenceStore creates an empty in-memory evidence store
+func NewMemoryEvidenceStore() *MemoryEvidenceStore {
+	return &MemoryEvidenceStore{blobs: make(map[string][]byte)}
+}
+
+// Put stores content and returns its digest
+func (s *MemoryEvidenceStore) Put(data []byte) (string, error) {
+	digest := EvidenceDigest(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[digest]; !ok {
+		s.blobs[digest] = append([]byte(nil), data...)
+	}
+	return digest, nil
+}
+
+// Get returns the content with a digest
+func (s *MemoryEvidenceStore) Get(digest string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.blobs[digest]
+	if !ok {
+		return nil, ErrEvidenceNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// FileEvidenceStore keeps evidence in a directory, one file per digest
+// under sha256/<first two hex digits>/
+type FileEvidenceStore struct {
+	dir string
+}
+
+// NewFileEvidenceStore creates an evidence store in dir, creating the
+// directory if needed
+func NewFileEvidenceStore(dir string) (*FileEvidenceStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create evidence directory: %w", err)
+	}
+	return &FileEvidenceStore{dir: dir}, nil
+}
+
+func (s *FileEvidenceStore) path(digest string) string {
+	hexDigest := digest[len("sha256:"):]
+	return filepath.Join(s.dir, "sha256", hexDigest[:2], hexDigest)
+}
+
+// Put writes content unless a file with its digest already exists
+func (s *FileEvidenceStore) Put(data []byte) (string, error) {
+	digest := EvidenceDigest(data)
+	path := s.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return digest, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".evidence-*")
+	if err != nil {
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", fmt.Errorf("store evidence: %w", err)
+	}
+	return digest, nil
+}
+
+// Get reads the content with a digest, failing if the file was altered
+func (s *FileEvidenceStore) Get(digest string) ([]byte, error) {
+	if !digestPattern.MatchString(digest) {
+		return nil, ErrEvidenceNotFound
+	}
+	data, err := os.ReadFile(s.path(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrEvidenceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read evidence: %w", err)
+	}
+	if err := verifyEvidence(digest, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,59 @@
+package aml
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvidenceStores(t *testing.T) {
+	fileStore, err := NewFileEvidenceStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]EvidenceStore{
+		"memory": NewMemoryEvidenceStore(),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			data := []byte("bank statement")
+			digest, err := store.Put(data)
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if digest != EvidenceDigest(data) {
+				t.Errorf("unexpected digest %s", digest)
+			}
+			if again, _ := store.Put(data); again != digest {
+				t.Errorf("identical content should have the same digest, got %s", again)
+			}
+			got, err := store.Get(digest)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Get returned %q, %v", got, err)
+			}
+			if _, err := store.Get(EvidenceDigest([]byte("other"))); err != ErrEvidenceNotFound {
+				t.Errorf("expected ErrEvidenceNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileEvidenceStore_DetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileEvidenceStore(dir)
+	digest, _ := store.Put([]byte("original"))
+
+	hexDigest := digest[len("sha256:"):]
+	path := filepath.Join(dir, "sha256", hexDigest[:2], hexDigest)
+	if err := os.WriteFile(path, []byte("altered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(digest); err == nil {
+		t.Error("expected altered evidence to fail verification")
+	}
+	if _, err := store.Get("sha256:../../etc/passwd"); err != ErrEvidenceNotFound {
+		t.Errorf("expected ErrEvidenceNotFound for a malformed digest, got %v", err)
+	}
+}
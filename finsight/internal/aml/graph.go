@@ -0,0 +1,722 @@
+package aml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultGraphWindow     = 30 * 24 * time.Hour
+	defaultGraphFanOut     = 5
+	defaultGraphCycleDepth = 5
+	graphSearchBudget      = 10000 // edges expanded per cycle search
+	graphMaxCycles         = 10
+	graphProximityDepth    = 3
+	graphMaxSubgraphEdges  = 500
+)
+
+// GraphEdge is a transfer between two accounts
+type GraphEdge struct {
+	TransactionID string          `json:"transaction_id"`
+	Source        string          `json:"source"`
+	Dest          string          `json:"dest"`
+	Amount        decimal.Decimal `json:"amount"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+// GraphPath is a sequence of transfers between accounts
+type GraphPath struct {
+	Accounts []string        `json:"accounts"`
+	Edges    []GraphEdge     `json:"edges"`
+	Amount   decimal.Decimal `json:"amount"` // Smallest transfer along the path
+}
+
+// GraphNode is an account in a subgraph view
+type GraphNode struct {
+	Account    string          `json:"account"`
+	Depth      int             `json:"depth"`
+	Flagged    bool            `json:"flagged"`
+	FlagReason string          `json:"flag_reason,omitempty"`
+	InDegree   int             `json:"in_degree"`
+	OutDegree  int             `json:"out_degree"`
+	InAmount   decimal.Decimal `json:"in_amount"`
+	OutAmount  decimal.Decimal `json:"out_amount"`
+}
+
+// GraphView is the neighbourhood of one or more accounts
+type GraphView struct {
+	Seeds     []string    `json:"seeds"`
+	Depth     int         `json:"depth"`
+	Nodes     []GraphNode `json:"nodes"`
+	Edges     []GraphEdge `json:"edges"`
+	Truncated bool        `json:"truncated"`
+}
+
+// Community is a densely connected group of accounts
+type Community struct {
+	ID      string          `json:"id"`
+	Members []string        `json:"members"`
+	Flagged []string        `json:"flagged,omitempty"`
+	Volume  decimal.Decimal `json:"volume"`
+}
+
+// TransactionGraph is a directed multigraph of account-to-account transfers
+// within a sliding window
+type TransactionGraph struct {
+	window  time.Duration
+	out     map[string][]*GraphEdge
+	in      map[string][]*GraphEdge
+	byTxn   map[string]*GraphEdge
+	flagged map[string]string // account -> reason
+	mu      sync.RWMutex
+}
+
+// NewTransactionGraph creates a graph keeping transfers for the window
+func NewTransactionGraph(window time.Duration) *TransactionGraph {
+	if window <= 0 {
+		window = defaultGraphWindow
+	}
+	return &TransactionGraph{
+		window:  window,
+		out:     make(map[string][]*GraphEdge),
+		in:      make(map[string][]*GraphEdge),
+		byTxn:   make(map[string]*GraphEdge),
+		flagged: make(map[string]string),
+	}
+}
+
+// AddTransaction records a transfer. Transactions without a destination
+// account or already recorded are ignored.
+func (g *TransactionGraph) AddTransaction(txn *models.Transaction) (*GraphEdge, bool) {
+	if txn.SourceAccount == "" || txn.DestAccount == "" || txn.SourceAccount == txn.DestAccount {
+		return nil, false
+	}
+	ts := txn.CreatedAt
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if edge, ok := g.byTxn[txn.ID]; ok {
+		return edge, false
+	}
+	edge := &GraphEdge{
+		TransactionID: txn.ID,
+		Source:        txn.SourceAccount,
+		Dest:          txn.DestAccount,
+		Amount:        txn.ReportingAmount(),
+		Timestamp:     ts,
+	}
+	g.out[edge.Source] = insertByTime(g.out[edge.Source], edge)
+	g.in[edge.Dest] = insertByTime(g.in[edge.Dest], edge)
+	g.byTxn[edge.TransactionID] = edge
+	return edge, true
+}
+
+func insertByTime(edges []*GraphEdge, edge *GraphEdge) []*GraphEdge {
+	i := sort.Search(len(edges), func(i int) bool { return edges[i].Timestamp.After(edge.Timestamp) })
+	edges = append(edges, nil)
+	copy(edges[i+1:], edges[i:])
+	edges[i] = edge
+	return edges
+}
+
+// Prune drops transfers older than the window
+func (g *TransactionGraph) Prune(now time.Time) int {
+	cutoff := now.Add(-g.window)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	removed := 0
+	for id, edge := range g.byTxn {
+		if edge.Timestamp.Before(cutoff) {
+			delete(g.byTxn, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	for _, index := range []map[string][]*GraphEdge{g.out, g.in} {
+		for account, edges := range index {
+			i := sort.Search(len(edges), func(i int) bool { return !edges[i].Timestamp.Before(cutoff) })
+			if i == len(edges) {
+				delete(index, account)
+			} else {
+				index[account] = edges[i:]
+			}
+		}
+	}
+	return removed
+}
+
+// Flag marks an account as suspicious so paths to it can be traced
+func (g *TransactionGraph) Flag(account, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.flagged[account] = reason
+}
+
+// Unflag clears an account's flag
+func (g *TransactionGraph) Unflag(account string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.flagged, account)
+}
+
+// FlaggedAccounts returns flagged accounts and their reasons
+func (g *TransactionGraph) FlaggedAccounts() map[string]string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	flagged := make(map[string]string, len(g.flagged))
+	for account, reason := range g.flagged {
+		flagged[account] = reason
+	}
+	return flagged
+}
+
+// Edge returns the transfer recorded for a transaction
+func (g *TransactionGraph) Edge(transactionID string) (GraphEdge, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	edge, ok := g.byTxn[transactionID]
+	if !ok {
+		return GraphEdge{}, false
+	}
+	return *edge, true
+}
+
+// CyclesClosedBy finds round trips completed by a transfer: time-ordered
+// paths that left the transfer's destination within the window and reached
+// its source before the transfer was made
+func (g *TransactionGraph) CyclesClosedBy(edge GraphEdge, maxLen int) []GraphPath {
+	if maxLen < 2 {
+		maxLen = defaultGraphCycleDepth
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	search := &cycleSearch{
+		g:        g,
+		target:   edge.Source,
+		notAfter: edge.Timestamp,
+		maxEdges: maxLen - 1,
+		budget:   graphSearchBudget,
+		visited:  map[string]bool{edge.Dest: true},
+	}
+	search.walk(edge.Dest, edge.Timestamp.Add(-g.window), nil)
+
+	cycles := make([]GraphPath, 0, len(search.found))
+	for _, path := range search.found {
+		cycles = append(cycles, newGraphPath(append(path, edge)))
+	}
+	return cycles
+}
+
+// FindCycles finds time-ordered round trips that start and end at an
+// account within the window
+func (g *TransactionGraph) FindCycles(account string, maxLen int) []GraphPath {
+	if maxLen < 2 {
+		maxLen = defaultGraphCycleDepth
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var cycles []GraphPath
+	for _, first := range g.out[account] {
+		if len(cycles) >= graphMaxCycles {
+			break
+		}
+		search := &cycleSearch{
+			g:        g,
+			target:   account,
+			notAfter: first.Timestamp.Add(g.window),
+			maxEdges: maxLen - 1,
+			budget:   graphSearchBudget,
+			visited:  map[string]bool{account: true, first.Dest: true},
+		}
+		search.walk(first.Dest, first.Timestamp, nil)
+		for _, path := range search.found {
+			cycles = append(cycles, newGraphPath(append([]GraphEdge{*first}, path...)))
+		}
+	}
+	if len(cycles) > graphMaxCycles {
+		cycles = cycles[:graphMaxCycles]
+	}
+	return cycles
+}
+
+type cycleSearch struct {
+	g        *TransactionGraph
+	target   string
+	notAfter time.Time
+	maxEdges int
+	budget   int
+	visited  map[string]bool
+	found    [][]GraphEdge
+}
+
+func (s *cycleSearch) walk(account string, notBefore time.Time, path []GraphEdge) {
+	if len(path) >= s.maxEdges || len(s.found) >= graphMaxCycles {
+		return
+	}
+	edges := s.g.out[account]
+	start := sort.Search(len(edges), func(i int) bool { return !edges[i].Timestamp.Before(notBefore) })
+	for _, next := range edges[start:] {
+		if next.Timestamp.After(s.notAfter) || s.budget <= 0 || len(s.found) >= graphMaxCycles {
+			return
+		}
+		s.budget--
+		if next.Dest == s.target {
+			s.found = append(s.found, append(append([]GraphEdge(nil), path...), *next))
+			continue
+		}
+		if s.visited[next.Dest] {
+			continue
+		}
+		s.visited[next.Dest] = true
+		s.walk(next.Dest, next.Timestamp, append(path, *next))
+		delete(s.visited, next.Dest)
+	}
+}
+
+func newGraphPath(edges []GraphEdge) GraphPath {
+	path := GraphPath{Edges: edges}
+	for i, edge := range edges {
+		if i == 0 {
+			path.Accounts = append(path.Accounts, edge.Source)
+			path.Amount = edge.Amount
+		}
+		path.Accounts = append(path.Accounts, edge.Dest)
+		path.Amount = decimal.Min(path.Amount, edge.Amount)
+	}
+	return path
+}
+
+// FanIn counts the distinct accounts that sent money to an account since a
+// point in time, and the total they sent
+func (g *TransactionGraph) FanIn(account string, since time.Time) (int, decimal.Decimal) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return fanCount(g.in[account], since, func(e *GraphEdge) string { return e.Source })
+}
+
+// FanOut counts the distinct accounts an account sent money to since a
+// point in time, and the total it sent
+func (g *TransactionGraph) FanOut(account string, since time.Time) (int, decimal.Decimal) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return fanCount(g.out[account], since, func(e *GraphEdge) string { return e.Dest })
+}
+
+func fanCount(edges []*GraphEdge, since time.Time, counterparty func(*GraphEdge) string) (int, decimal.Decimal) {
+	seen := make(map[string]bool)
+	total := decimal.Zero
+	for _, edge := range edges {
+		if edge.Timestamp.Before(since) {
+			continue
+		}
+		seen[counterparty(edge)] = true
+		total = total.Add(edge.Amount)
+	}
+	return len(seen), total
+}
+
+// ShortestPath finds the path with the fewest transfers between two
+// accounts. When directed is false transfers can be followed backwards,
+// which links accounts that share a counterparty.
+func (g *TransactionGraph) ShortestPath(from, to string, directed bool, maxLen int) (*GraphPath, bool) {
+	if from == to {
+		return nil, false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.shortestPath(from, map[string]bool{to: true}, directed, maxLen)
+}
+
+func (g *TransactionGraph) shortestPath(from string, targets map[string]bool, directed bool, maxLen int) (*GraphPath, bool) {
+	type step struct {
+		prev string
+		edge *GraphEdge
+	}
+	parents := map[string]step{from: {}}
+	frontier := []string{from}
+
+	for depth := 0; len(frontier) > 0 && (maxLen <= 0 || depth < maxLen); depth++ {
+		var next []string
+		for _, account := range frontier {
+			for _, n := range g.neighbours(account, directed) {
+				if _, seen := parents[n.account]; seen {
+					continue
+				}
+				parents[n.account] = step{prev: account, edge: n.edge}
+				if targets[n.account] {
+					var edges []GraphEdge
+					for at := n.account; at != from; at = parents[at].prev {
+						edges = append([]GraphEdge{*parents[at].edge}, edges...)
+					}
+					path := newGraphPath(edges)
+					path.Accounts = []string{from}
+					at := from
+					for _, edge := range edges {
+						if edge.Source == at {
+							at = edge.Dest
+						} else {
+							at = edge.Source
+						}
+						path.Accounts = append(path.Accounts, at)
+					}
+					return &path, true
+				}
+				next = append(next, n.account)
+			}
+		}
+		frontier = next
+	}
+	return nil, false
+}
+
+type graphNeighbour struct {
+	account string
+	edge    *GraphEdge
+}
+
+// neighbours lists each adjacent account once, via its largest transfer,
+// in a stable order
+func (g *TransactionGraph) neighbours(account string, directed bool) []graphNeighbour {
+	best := make(map[string]*GraphEdge)
+	consider := func(other string, edge *GraphEdge) {
+		if cur, ok := best[other]; !ok || edge.Amount.GreaterThan(cur.Amount) {
+			best[other] = edge
+		}
+	}
+	for _, edge := range g.out[account] {
+		consider(edge.Dest, edge)
+	}
+	if !directed {
+		for _, edge := range g.in[account] {
+			consider(edge.Source, edge)
+		}
+	}
+
+	result := make([]graphNeighbour, 0, len(best))
+	for other, edge := range best {
+		result = append(result, graphNeighbour{account: other, edge: edge})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].account < result[j].account })
+	return result
+}
+
+// NearestFlagged finds the closest flagged account to an account, following
+// transfers in either direction
+func (g *TransactionGraph) NearestFlagged(account string, maxLen int) (*GraphPath, string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	targets := make(map[string]bool, len(g.flagged))
+	for flagged := range g.flagged {
+		if flagged != account {
+			targets[flagged] = true
+		}
+	}
+	if len(targets) == 0 {
+		return nil, "", false
+	}
+	path, ok := g.shortestPath(account, targets, false, maxLen)
+	if !ok {
+		return nil, "", false
+	}
+	return path, g.flagged[path.Accounts[len(path.Accounts)-1]], true
+}
+
+// TraceFlagged finds the shortest money-flow path between every ordered pair
+// of flagged accounts that are connected
+func (g *TransactionGraph) TraceFlagged(maxLen int) []GraphPath {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	accounts := make([]string, 0, len(g.flagged))
+	for account := range g.flagged {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	var paths []GraphPath
+	for _, from := range accounts {
+		for _, to := range accounts {
+			if from == to {
+				continue
+			}
+			if path, ok := g.shortestPath(from, map[string]bool{to: true}, true, maxLen); ok {
+				paths = append(paths, *path)
+			}
+		}
+	}
+	return paths
+}
+
+// Subgraph returns every account within depth transfers of the seeds, in
+// either direction, and the transfers between them
+func (g *TransactionGraph) Subgraph(seeds []string, depth int) *GraphView {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	view := &GraphView{Seeds: seeds, Depth: depth}
+	depths := make(map[string]int)
+	var frontier []string
+	for _, seed := range seeds {
+		if _, ok := depths[seed]; !ok {
+			depths[seed] = 0
+			frontier = append(frontier, seed)
+		}
+	}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, account := range frontier {
+			for _, n := range g.neighbours(account, false) {
+				if _, ok := depths[n.account]; !ok {
+					depths[n.account] = d
+					next = append(next, n.account)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	accounts := make([]string, 0, len(depths))
+	for account := range depths {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if depths[accounts[i]] != depths[accounts[j]] {
+			return depths[accounts[i]] < depths[accounts[j]]
+		}
+		return accounts[i] < accounts[j]
+	})
+
+	for _, account := range accounts {
+		node := GraphNode{
+			Account:   account,
+			Depth:     depths[account],
+			InDegree:  len(g.in[account]),
+			OutDegree: len(g.out[account]),
+			InAmount:  decimal.Zero,
+			OutAmount: decimal.Zero,
+		}
+		node.FlagReason, node.Flagged = g.flagged[account]
+		for _, edge := range g.in[account] {
+			node.InAmount = node.InAmount.Add(edge.Amount)
+		}
+		for _, edge := range g.out[account] {
+			node.OutAmount = node.OutAmount.Add(edge.Amount)
+			if _, ok := depths[edge.Dest]; !ok {
+				continue
+			}
+			if len(view.Edges) >= graphMaxSubgraphEdges {
+				view.Truncated = true
+				continue
+			}
+			view.Edges = append(view.Edges, *edge)
+		}
+		view.Nodes = append(view.Nodes, node)
+	}
+	sort.SliceStable(view.Edges, func(i, j int) bool { return view.Edges[i].Timestamp.Before(view.Edges[j].Timestamp) })
+	return view
+}
+
+// Communities groups accounts by label propagation over the undirected
+// graph, weighting each link by the amount transferred. Only groups with at
+// least minSize accounts are returned, largest first.
+func (g *TransactionGraph) Communities(minSize int) []Community {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	weights := make(map[string]map[string]decimal.Decimal)
+	link := func(a, b string, amount decimal.Decimal) {
+		if weights[a] == nil {
+			weights[a] = make(map[string]decimal.Decimal)
+		}
+		weights[a][b] = weights[a][b].Add(amount)
+	}
+	for _, edges := range g.out {
+		for _, edge := range edges {
+			link(edge.Source, edge.Dest, edge.Amount)
+			link(edge.Dest, edge.Source, edge.Amount)
+		}
+	}
+
+	accounts := make([]string, 0, len(weights))
+	labels := make(map[string]string, len(weights))
+	for account := range weights {
+		accounts = append(accounts, account)
+		labels[account] = account
+	}
+	sort.Strings(accounts)
+
+	for round := 0; round < 20; round++ {
+		changed := false
+		for _, account := range accounts {
+			scores := make(map[string]decimal.Decimal)
+			for other, w := range weights[account] {
+				scores[labels[other]] = scores[labels[other]].Add(w)
+			}
+			best := labels[account]
+			for label, score := range scores {
+				cur := scores[best]
+				if score.GreaterThan(cur) || (score.Equal(cur) && label < best) {
+					best = label
+				}
+			}
+			if best != labels[account] {
+				labels[account] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	groups := make(map[string]*Community)
+	for _, account := range accounts {
+		label := labels[account]
+		c, ok := groups[label]
+		if !ok {
+			c = &Community{ID: label, Volume: decimal.Zero}
+			groups[label] = c
+		}
+		c.Members = append(c.Members, account)
+		if _, flagged := g.flagged[account]; flagged {
+			c.Flagged = append(c.Flagged, account)
+		}
+		for _, edge := range g.out[account] {
+			if labels[edge.Dest] == label {
+				c.Volume = c.Volume.Add(edge.Amount)
+			}
+		}
+	}
+
+	var communities []Community
+	for _, c := range groups {
+		if len(c.Members) >= max(minSize, 2) {
+			c.ID = "community-" + c.Members[0]
+			communities = append(communities, *c)
+		}
+	}
+	sort.Slice(communities, func(i, j int) bool {
+		if len(communities[i].Members) != len(communities[j].Members) {
+			return len(communities[i].Members) > len(communities[j].Members)
+		}
+		return communities[i].ID < communities[j].ID
+	})
+	return communities
+}
+
+// NetworkScenario records each transfer in the transaction graph and looks
+// for round-tripping, money-mule fan-in and fan-out, and proximity to
+// flagged accounts
+type NetworkScenario struct {
+	graph      *TransactionGraph
+	fanOut     int
+	cycleDepth int
+}
+
+func (s *NetworkScenario) Name() string { return "network_pattern" }
+
+func (s *NetworkScenario) Evaluate(txn *models.Transaction, profile *CustomerRiskProfile) *ScenarioResult {
+	result := &ScenarioResult{ScenarioType: "network_pattern"}
+
+	edge, added := s.graph.AddTransaction(txn)
+	if edge == nil {
+		return result
+	}
+	since := edge.Timestamp.Add(-s.graph.window)
+	evidence := make(map[string]interface{})
+	var findings []string
+
+	if added {
+		if cycles := s.graph.CyclesClosedBy(*edge, s.cycleDepth); len(cycles) > 0 {
+			result.Score += 5.0
+			findings = append(findings, fmt.Sprintf("funds returned to %s through %d accounts", edge.Source, len(cycles[0].Accounts)-2))
+			evidence["cycles"] = cycles
+		}
+	}
+	if n, total := s.graph.FanIn(edge.Dest, since); n >= s.fanOut {
+		result.Score += 3.0
+		findings = append(findings, fmt.Sprintf("%s received from %d accounts", edge.Dest, n))
+		evidence["fan_in"] = map[string]interface{}{"account": edge.Dest, "counterparties": n, "amount": total.String()}
+	}
+	if n, total := s.graph.FanOut(edge.Source, since); n >= s.fanOut {
+		result.Score += 3.0
+		findings = append(findings, fmt.Sprintf("%s sent to %d accounts", edge.Source, n))
+		evidence["fan_out"] = map[string]interface{}{"account": edge.Source, "counterparties": n, "amount": total.String()}
+	}
+	if path, reason, ok := s.graph.NearestFlagged(edge.Source, graphProximityDepth); ok {
+		result.Score += 2.5
+		flagged := path.Accounts[len(path.Accounts)-1]
+		findings = append(findings, fmt.Sprintf("%d hops from flagged account %s (%s)", len(path.Edges), flagged, reason))
+		evidence["flagged_path"] = path
+	}
+
+	if len(findings) > 0 {
+		result.Triggered = true
+		result.Description = "Network pattern: " + strings.Join(findings, "; ")
+		result.Evidence = evidence
+	}
+	return result
+}
+
+// FlagAccount marks an account as suspicious in the transaction graph.
+// Accounts are also flagged automatically when they raise a critical alert.
+func (e *Engine) FlagAccount(account, reason string) {
+	e.graph.Flag(account, reason)
+}
+
+// AccountSubgraph returns the transaction graph around an account
+func (e *Engine) AccountSubgraph(account string, depth int) *GraphView {
+	return e.graph.Subgraph([]string{account}, depth)
+}
+
+// AlertSubgraph returns the transaction graph around an alert's customer
+// and the counterparties of the transactions that raised it
+func (e *Engine) AlertSubgraph(alertID string, depth int) (*GraphView, error) {
+	alert, ok := e.GetAlert(alertID)
+	if !ok {
+		return nil, fmt.Errorf("alert not found: %s", alertID)
+	}
+
+	seeds := []string{alert.CustomerID}
+	for _, txnID := range alert.Transactions {
+		if edge, ok := e.graph.Edge(txnID); ok {
+			seeds = appendUnique(seeds, edge.Source)
+			seeds = appendUnique(seeds, edge.Dest)
+		}
+	}
+	return e.graph.Subgraph(seeds, depth), nil
+}
+
+// TraceFunds finds the shortest money-flow path between two accounts
+func (e *Engine) TraceFunds(from, to string, maxLen int) (*GraphPath, bool) {
+	return e.graph.ShortestPath(from, to, true, maxLen)
+}
+
+// TraceFlaggedAccounts finds the paths connecting flagged accounts
+func (e *Engine) TraceFlaggedAccounts(maxLen int) []GraphPath {
+	return e.graph.TraceFlagged(maxLen)
+}
+
+// GraphCommunities returns groups of closely connected accounts
+func (e *Engine) GraphCommunities(minSize int) []Community {
+	return e.graph.Communities(minSize)
+}
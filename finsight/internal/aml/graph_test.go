@@ -0,0 +1,170 @@
+package aml
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+var graphStart = time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+func transfer(id, from, to string, amount int64, minutes int) *models.Transaction {
+	return &models.Transaction{
+		ID:            id,
+		Type:          models.TransactionTypeTransfer,
+		Amount:        decimal.NewFromInt(amount),
+		SourceAccount: from,
+		DestAccount:   to,
+		CreatedAt:     graphStart.Add(time.Duration(minutes) * time.Minute),
+	}
+}
+
+func TestGraphCyclesClosedBy(t *testing.T) {
+	g := NewTransactionGraph(0)
+	g.AddTransaction(transfer("t1", "A", "B", 5000, 0))
+	g.AddTransaction(transfer("t2", "B", "C", 4900, 10))
+	// Earlier than t2, so it cannot carry the funds onward
+	g.AddTransaction(transfer("t0", "C", "A", 100, 5))
+	edge, _ := g.AddTransaction(transfer("t3", "C", "A", 4800, 20))
+
+	cycles := g.CyclesClosedBy(*edge, 5)
+	if len(cycles) != 1 {
+		t.Fatalf("expected one round trip, got %d", len(cycles))
+	}
+	if got := strings.Join(cycles[0].Accounts, ">"); got != "A>B>C>A" {
+		t.Errorf("unexpected cycle %s", got)
+	}
+	if !cycles[0].Amount.Equal(decimal.NewFromInt(4800)) {
+		t.Errorf("expected the smallest leg as the cycle amount, got %s", cycles[0].Amount)
+	}
+
+	early, _ := g.Edge("t0")
+	if cycles := g.CyclesClosedBy(early, 5); len(cycles) != 0 {
+		t.Errorf("a transfer before the outbound legs cannot close a cycle: %+v", cycles)
+	}
+	if cycles := g.FindCycles("A", 5); len(cycles) != 1 {
+		t.Errorf("expected FindCycles to find the same round trip, got %d", len(cycles))
+	}
+}
+
+func TestGraphFanInAndShortestPath(t *testing.T) {
+	g := NewTransactionGraph(0)
+	for i := 0; i < 6; i++ {
+		g.AddTransaction(transfer(fmt.Sprintf("in%d", i), fmt.Sprintf("payer%d", i), "mule", 900, i))
+	}
+	g.AddTransaction(transfer("out", "mule", "boss", 5000, 30))
+	g.AddTransaction(transfer("side", "boss", "offshore", 4000, 40))
+
+	if n, total := g.FanIn("mule", graphStart); n != 6 || !total.Equal(decimal.NewFromInt(5400)) {
+		t.Errorf("unexpected fan-in %d %s", n, total)
+	}
+
+	path, ok := g.ShortestPath("payer3", "offshore", true, 5)
+	if !ok || strings.Join(path.Accounts, ">") != "payer3>mule>boss>offshore" {
+		t.Fatalf("unexpected path %+v", path)
+	}
+	if _, ok := g.ShortestPath("offshore", "payer3", true, 5); ok {
+		t.Error("directed path should not follow transfers backwards")
+	}
+	if path, ok := g.ShortestPath("payer1", "payer2", false, 5); !ok || len(path.Edges) != 2 {
+		t.Errorf("undirected path should link payers through the mule: %+v", path)
+	}
+
+	g.Flag("payer0", "watchlist_match")
+	g.Flag("offshore", "manual")
+	if paths := g.TraceFlagged(6); len(paths) != 1 || len(paths[0].Edges) != 3 {
+		t.Errorf("expected one path between flagged accounts, got %+v", paths)
+	}
+}
+
+func TestGraphCommunities(t *testing.T) {
+	g := NewTransactionGraph(0)
+	ring := [][2]string{{"a1", "a2"}, {"a2", "a3"}, {"a3", "a1"}, {"a1", "a4"}}
+	other := [][2]string{{"b1", "b2"}, {"b2", "b3"}, {"b3", "b1"}}
+	for i, e := range append(ring, other...) {
+		g.AddTransaction(transfer(fmt.Sprintf("t%d", i), e[0], e[1], 1000, i))
+	}
+	// A weak link between the groups should not merge them
+	g.AddTransaction(transfer("bridge", "a4", "b1", 10, 20))
+	g.Flag("a2", "manual")
+
+	communities := g.Communities(3)
+	if len(communities) != 2 {
+		t.Fatalf("expected two communities, got %+v", communities)
+	}
+	if got := strings.Join(communities[0].Members, ","); got != "a1,a2,a3,a4" {
+		t.Errorf("unexpected first community %s", got)
+	}
+	if len(communities[0].Flagged) != 1 || len(communities[1].Flagged) != 0 {
+		t.Errorf("unexpected flagged members %v / %v", communities[0].Flagged, communities[1].Flagged)
+	}
+}
+
+func TestGraphSubgraphAndPrune(t *testing.T) {
+	g := NewTransactionGraph(24 * time.Hour)
+	g.AddTransaction(transfer("t1", "A", "B", 100, 0))
+	g.AddTransaction(transfer("t2", "B", "C", 100, 1))
+	g.AddTransaction(transfer("t3", "C", "D", 100, 2))
+
+	view := g.Subgraph([]string{"A"}, 2)
+	if len(view.Nodes) != 3 || len(view.Edges) != 2 || view.Nodes[2].Account != "C" || view.Nodes[2].Depth != 2 {
+		t.Errorf("unexpected subgraph %+v", view)
+	}
+
+	if removed := g.Prune(graphStart.Add(24*time.Hour + 90*time.Second)); removed != 2 {
+		t.Errorf("expected two transfers pruned, got %d", removed)
+	}
+	if _, ok := g.Edge("t1"); ok {
+		t.Error("pruned transfer is still indexed")
+	}
+	if view := g.Subgraph([]string{"C"}, 1); len(view.Edges) != 1 {
+		t.Errorf("expected only the remaining transfer, got %+v", view.Edges)
+	}
+}
+
+func TestNetworkScenarioAlert(t *testing.T) {
+	e := NewEngine(&Config{Enabled: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Start(ctx)
+	defer e.Stop()
+
+	for _, txn := range []*models.Transaction{
+		transfer("t1", "A", "B", 4000, 0),
+		transfer("t2", "B", "C", 3900, 10),
+	} {
+		if _, err := e.AnalyzeTransaction(ctx, txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := e.AnalyzeTransaction(ctx, transfer("t3", "C", "A", 3800, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Decision != "review" || len(result.Indicators) != 1 || result.Indicators[0].Type != string(AlertTypeNetworkPattern) {
+		t.Fatalf("expected a network pattern review, got %+v", result)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var alerts []*AMLAlert
+	for len(alerts) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		alerts = e.ListAlerts(AlertFilter{AlertType: AlertTypeNetworkPattern})
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected one network alert, got %d", len(alerts))
+	}
+
+	view, err := e.AlertSubgraph(alerts[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Nodes) != 3 || len(view.Edges) != 3 {
+		t.Errorf("expected the whole ring around the alert, got %d nodes and %d edges", len(view.Nodes), len(view.Edges))
+	}
+}
@@ -0,0 +1,489 @@
+package aml
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Name matching algorithms reported on a WatchlistMatch
+const (
+	AlgorithmExact              = "exact"
+	AlgorithmTokenOrder         = "token_order"
+	AlgorithmJaroWinkler        = "jaro_winkler"
+	AlgorithmDamerauLevenshtein = "damerau_levenshtein"
+	AlgorithmDoubleMetaphone    = "double_metaphone"
+)
+
+// NameMatch is the result of comparing two names
+type NameMatch struct {
+	Score     float64            `json:"score"`
+	Algorithm string             `json:"algorithm"`
+	Scores    map[string]float64 `json:"scores"`
+}
+
+// Token matching thresholds for multi-token names
+const (
+	// tokenMatchThreshold is the spelling similarity at which two tokens are
+	// taken to be the same name
+	tokenMatchThreshold = 0.85
+	// unmatchedTokenPenalty is deducted for each token of either name that
+	// has no counterpart in the other
+	unmatchedTokenPenalty = 0.07
+)
+
+// CompareNames scores two personal or entity names between 0 and 1. Both
+// names are transliterated to ASCII and tokenised, and token order is
+// ignored. The best of the exact, token-order, Jaro-Winkler,
+// Damerau-Levenshtein and Double Metaphone scores is returned together
+// with the algorithm that produced it.
+//
+// Names with more than one token are compared token by token, so a shared
+// given name or a long common prefix cannot carry the whole name. Each
+// unmatched token lowers the score, and unless the surname or most tokens
+// of each name match, the score is scaled down to the share of tokens that
+// do.
+func CompareNames(a, b string) NameMatch {
+	ta, tb := nameTokens(a), nameTokens(b)
+	result := NameMatch{Scores: make(map[string]float64)}
+	if len(ta) == 0 || len(tb) == 0 {
+		return result
+	}
+
+	na, nb := strings.Join(ta, " "), strings.Join(tb, " ")
+	sa, sb := sortedJoin(ta), sortedJoin(tb)
+
+	if na == nb {
+		result.Scores[AlgorithmExact] = 1
+	} else if sa == sb {
+		result.Scores[AlgorithmTokenOrder] = 1
+	}
+
+	if len(ta) == 1 && len(tb) == 1 {
+		result.Scores[AlgorithmJaroWinkler] = JaroWinkler(na, nb)
+		result.Scores[AlgorithmDamerauLevenshtein] = DamerauLevenshteinSimilarity(na, nb)
+		// A phonetic match is weaker evidence than a spelling match
+		result.Scores[AlgorithmDoubleMetaphone] = 0.9 * phoneticSimilarity(na, nb)
+	} else {
+		agreement := tokenAgreement(ta, tb)
+		jw := alignTokens(ta, tb, JaroWinkler)
+		if agreement == 1 {
+			// Every token has a counterpart, so the whole-name score can only
+			// refine a match, not create one
+			jw = maxFloat(jw, JaroWinkler(na, nb), JaroWinkler(sa, sb))
+		}
+		dl := alignTokens(ta, tb, DamerauLevenshteinSimilarity)
+		if len(ta) != len(tb) {
+			// "Abdulrahman" against "Abdul Rahman"
+			dl = maxFloat(dl, DamerauLevenshteinSimilarity(strings.Join(ta, ""), strings.Join(tb, "")))
+		}
+		result.Scores[AlgorithmJaroWinkler] = agreement * jw
+		result.Scores[AlgorithmDamerauLevenshtein] = agreement * dl
+		result.Scores[AlgorithmDoubleMetaphone] = agreement * 0.9 * alignTokens(ta, tb, phoneticSimilarity)
+	}
+
+	// Ties go to the simplest explanation
+	for _, algorithm := range []string{AlgorithmExact, AlgorithmTokenOrder, AlgorithmJaroWinkler, AlgorithmDamerauLevenshtein, AlgorithmDoubleMetaphone} {
+		if score, ok := result.Scores[algorithm]; ok && score > result.Score {
+			result.Score = score
+			result.Algorithm = algorithm
+		}
+	}
+	return result
+}
+
+// JaroWinkler returns the Jaro-Winkler similarity of two strings
+func JaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	window := max(len(ra), len(rb))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+
+	matches := 0
+	for i := range ra {
+		lo, hi := max(0, i-window), min(len(rb), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// DamerauLevenshtein returns the optimal string alignment distance between
+// two strings: insertions, deletions, substitutions and transpositions of
+// adjacent characters each cost one
+func DamerauLevenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// DamerauLevenshteinSimilarity normalises the distance to a 0-1 similarity
+func DamerauLevenshteinSimilarity(a, b string) float64 {
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(DamerauLevenshtein(a, b))/float64(longest)
+}
+
+// phoneticSimilarity is 1 when any Double Metaphone code of one token
+// equals any code of the other
+func phoneticSimilarity(a, b string) float64 {
+	pa, aa := DoubleMetaphone(a)
+	pb, ab := DoubleMetaphone(b)
+	for _, x := range []string{pa, aa} {
+		if x == "" {
+			continue
+		}
+		if x == pb || x == ab {
+			return 1
+		}
+	}
+	return 0
+}
+
+// tokenPair is a token of one name paired with a token of the other
+type tokenPair struct {
+	i, j  int
+	score float64
+}
+
+// pairTokens greedily pairs each token of ta with its most similar unused
+// token of tb, best pairs first
+func pairTokens(ta, tb []string, similarity func(a, b string) float64) []tokenPair {
+	candidates := make([]tokenPair, 0, len(ta)*len(tb))
+	for i, a := range ta {
+		for j, b := range tb {
+			candidates = append(candidates, tokenPair{i, j, similarity(a, b)})
+		}
+	}
+	sort.SliceStable(candidates, func(x, y int) bool { return candidates[x].score > candidates[y].score })
+
+	usedA := make([]bool, len(ta))
+	usedB := make([]bool, len(tb))
+	var pairs []tokenPair
+	for _, p := range candidates {
+		if usedA[p.i] || usedB[p.j] {
+			continue
+		}
+		usedA[p.i], usedB[p.j] = true, true
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+// alignTokens pairs the tokens of two names and returns the length-weighted
+// mean similarity of the pairs. Tokens left unpaired are accounted for by
+// tokenAgreement.
+func alignTokens(ta, tb []string, similarity func(a, b string) float64) float64 {
+	var total, weight float64
+	for _, p := range pairTokens(ta, tb, similarity) {
+		w := float64(max(len(ta[p.i]), len(tb[p.j])))
+		total += p.score * w
+		weight += w
+	}
+	if weight == 0 {
+		return 0
+	}
+	return total / weight
+}
+
+// tokenAgreement returns the factor applied to the token scores of two
+// names. Each token without a counterpart costs unmatchedTokenPenalty, so a
+// missing middle name still matches but ranks below a full match. A name of
+// several tokens must have its surname (the last token) or more than half
+// its tokens matched; otherwise the factor is the share of tokens that
+// matched, which keeps a shared given name such as "Robert" or "Ali" from
+// matching a different person.
+func tokenAgreement(ta, tb []string) float64 {
+	matchedA := make([]bool, len(ta))
+	matchedB := make([]bool, len(tb))
+	matchJoined(ta, tb, matchedA, matchedB)
+	matchJoined(tb, ta, matchedB, matchedA)
+
+	// Pair the remaining tokens by spelling
+	restA, indexA := unmatchedTokens(ta, matchedA)
+	restB, indexB := unmatchedTokens(tb, matchedB)
+	spelling := func(a, b string) float64 {
+		return max(JaroWinkler(a, b), DamerauLevenshteinSimilarity(a, b))
+	}
+	for _, p := range pairTokens(restA, restB, spelling) {
+		if p.score >= tokenMatchThreshold {
+			matchedA[indexA[p.i]], matchedB[indexB[p.j]] = true, true
+		}
+	}
+
+	unmatched, total := 0, len(ta)+len(tb)
+	supported := true
+	for _, matched := range [][]bool{matchedA, matchedB} {
+		count := 0
+		for _, m := range matched {
+			if m {
+				count++
+			}
+		}
+		unmatched += len(matched) - count
+		if len(matched) > 1 && !matched[len(matched)-1] && count*2 <= len(matched) {
+			supported = false
+		}
+	}
+
+	if !supported {
+		return float64(total-unmatched) / float64(total)
+	}
+	return max(0, 1-unmatchedTokenPenalty*float64(unmatched))
+}
+
+// unmatchedTokens returns the tokens not yet matched and their positions
+func unmatchedTokens(tokens []string, matched []bool) ([]string, []int) {
+	var rest []string
+	var index []int
+	for i, t := range tokens {
+		if !matched[i] {
+			rest = append(rest, t)
+			index = append(index, i)
+		}
+	}
+	return rest, index
+}
+
+// matchJoined marks an unmatched token of ta that is written as several
+// adjacent unmatched tokens of tb, such as "abdulrahman" and "abdul rahman"
+func matchJoined(ta, tb []string, matchedA, matchedB []bool) {
+	for i, a := range ta {
+		if matchedA[i] {
+			continue
+		}
+		for start := range tb {
+			joined := ""
+			for end := start; end < len(tb) && !matchedB[end] && len(joined) < len(a); end++ {
+				joined += tb[end]
+				if end > start && joined == a {
+					matchedA[i] = true
+					for k := start; k <= end; k++ {
+						matchedB[k] = true
+					}
+				}
+			}
+			if matchedA[i] {
+				break
+			}
+		}
+	}
+}
+
+// NormalizeName transliterates a name to lowercase ASCII, replaces
+// punctuation with spaces and collapses whitespace
+func NormalizeName(name string) string {
+	return strings.Join(nameTokens(name), " ")
+}
+
+func nameTokens(name string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		case r == '\'' || r == '’' || r == '`':
+			// "O'Brien" and "Qa'ida" keep a single token
+		case unicode.Is(unicode.Mn, r):
+			// combining marks left over from decomposed input
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Fields(b.String())
+}
+
+func sortedJoin(tokens []string) string {
+	sorted := append([]string(nil), tokens...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+func maxFloat(values ...float64) float64 {
+	var m float64
+	for _, v := range values {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// transliterations folds Latin diacritics and romanises Cyrillic and Greek
+var transliterations = buildTransliterations(map[string]string{
+	"a": "àáâãäåāăą", "ae": "æ", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě",
+	"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "ij": "ĳ", "j": "ĵ", "k": "ķ",
+	"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏő", "oe": "œ", "r": "ŕŗř",
+	"s": "śŝşšș", "ss": "ß", "t": "ţťŧț", "th": "þ", "u": "ùúûüũūŭůűų",
+	"w": "ŵ", "y": "ýÿŷ", "z": "źżž",
+
+	// Cyrillic (BGN/PCGN) and Greek letters with shared romanisations
+	"b": "б", "v": "вβ", "f": "фφ", "kh": "х", "ts": "ц", "ch": "чχ",
+	"sh": "ш", "shch": "щ", "zh": "ж", "yu": "ю", "ya": "я", "yi": "ї",
+	"ye": "є", "": "ъь", "ps": "ψ", "x": "ξ",
+}, map[rune]string{
+	// Cyrillic
+	'а': "a", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "e", 'э': "e",
+	'з': "z", 'и': "i", 'і': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ў': "u", 'ы': "y",
+
+	// Greek
+	'α': "a", 'ά': "a", 'γ': "g", 'δ': "d", 'ε': "e", 'έ': "e", 'ζ': "z",
+	'η': "i", 'ή': "i", 'θ': "th", 'ι': "i", 'ί': "i", 'ϊ': "i", 'ΐ': "i",
+	'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ο': "o", 'ό': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'ύ': "y", 'ϋ': "y",
+	'ΰ': "y", 'ω': "o", 'ώ': "o",
+})
+
+// buildTransliterations expands a table of Latin target -> source
+// characters and merges in the single-character mappings
+func buildTransliterations(table map[string]string, single map[rune]string) map[rune]string {
+	out := make(map[rune]string, len(single))
+	for r, latin := range single {
+		out[r] = latin
+	}
+	for latin, sources := range table {
+		for _, r := range sources {
+			out[r] = latin
+		}
+	}
+	return out
+}
+
+var yearPattern = regexp.MustCompile(`\b(1[89]\d\d|20\d\d)\b`)
+
+var dobLayouts = []string{"2006-01-02", "02 Jan 2006", "2 Jan 2006", "02/01/2006", "20060102", "January 2, 2006"}
+
+// compareDOB reports whether two dates of birth agree. exact is set when
+// both parse to the same full date; matched when the years overlap, which
+// tolerates list entries such as "circa 1960" or "1958 to 1962".
+func compareDOB(query, listed string) (exact, matched, conflict bool) {
+	if query == "" || listed == "" {
+		return false, false, false
+	}
+
+	qd, qok := parseDOB(query)
+	ld, lok := parseDOB(listed)
+	if qok && lok {
+		if qd.Equal(ld) {
+			return true, true, false
+		}
+		return false, false, true
+	}
+
+	queryYears := yearPattern.FindAllString(query, -1)
+	listedYears := yearPattern.FindAllString(listed, -1)
+	if len(queryYears) == 0 || len(listedYears) == 0 {
+		return false, false, false
+	}
+	if strings.Contains(strings.ToLower(listed), " to ") && len(listedYears) == 2 {
+		for _, y := range queryYears {
+			if y >= listedYears[0] && y <= listedYears[1] {
+				return false, true, false
+			}
+		}
+		return false, false, true
+	}
+	for _, q := range queryYears {
+		for _, l := range listedYears {
+			if q == l {
+				return false, true, false
+			}
+		}
+	}
+	return false, false, true
+}
+
+func parseDOB(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dobLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// compareCountry reports whether two country values agree. Lists mix ISO
+// codes and country names, so a code is never held to conflict with a name.
+func compareCountry(query, listed string) (matched, conflict bool) {
+	q, l := NormalizeName(query), NormalizeName(listed)
+	if q == "" || l == "" {
+		return false, false
+	}
+	if q == l {
+		return true, false
+	}
+	isCode := func(s string) bool { return len(s) <= 3 }
+	return false, isCode(q) == isCode(l)
+}
@@ -0,0 +1,167 @@
+package aml
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"abc", "abc", 1},
+		{"abc", "xyz", 0},
+	}
+	for _, tt := range tests {
+		if got := JaroWinkler(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("JaroWinkler(%q, %q) = %.3f, want %.3f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDamerauLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"ca", "ac", 1},
+		{"hussein", "husein", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := DamerauLevenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("DamerauLevenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDoubleMetaphone(t *testing.T) {
+	tests := []struct {
+		word, primary, alternate string
+	}{
+		{"Smith", "SM0", "XMT"},
+		{"Schmidt", "XMT", "SMT"},
+		{"Thomas", "TMS", "TMS"},
+		{"Mohammed", "MHMT", "MHMT"},
+		{"Muhammad", "MHMT", "MHMT"},
+		{"Jose", "HS", "HS"},
+		{"Knight", "NT", "NT"},
+	}
+	for _, tt := range tests {
+		p, a := DoubleMetaphone(tt.word)
+		if p != tt.primary || a != tt.alternate {
+			t.Errorf("DoubleMetaphone(%q) = %q, %q, want %q, %q", tt.word, p, a, tt.primary, tt.alternate)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"José  Álvarez-Núñez": "jose alvarez nunez",
+		"Владимир Путин":      "vladimir putin",
+		"O'Brien, Seán":       "obrien sean",
+		"Müller Straße":       "muller strasse",
+	}
+	for in, want := range tests {
+		if got := NormalizeName(in); got != want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		algorithm string
+		min       float64
+	}{
+		{"John Smith", "john smith", AlgorithmExact, 1},
+		{"Smith, John", "John Smith", AlgorithmTokenOrder, 1},
+		{"Mohammed Ali", "Muhammad Ali", AlgorithmJaroWinkler, 0.9},
+		{"Jon Smyth", "John Smith", AlgorithmJaroWinkler, 0.88},
+		{"Schmidt", "Smith", AlgorithmDoubleMetaphone, 0.9},
+		{"Владимир Путин", "Vladimir Putin", AlgorithmExact, 1},
+	}
+	for _, tt := range tests {
+		got := CompareNames(tt.a, tt.b)
+		if got.Algorithm != tt.algorithm || got.Score < tt.min {
+			t.Errorf("CompareNames(%q, %q) = %.3f via %s, want >= %.2f via %s (%v)",
+				tt.a, tt.b, got.Score, got.Algorithm, tt.min, tt.algorithm, got.Scores)
+		}
+	}
+
+	if got := CompareNames("John Smith", "Maria Garcia"); got.Score > 0.6 {
+		t.Errorf("unrelated names scored %.3f via %s", got.Score, got.Algorithm)
+	}
+
+	partial := []struct {
+		a, b string
+		min  float64
+	}{
+		{"Abdulrahman Hassan", "Abdul Rahman Hassan", 0.95},
+		{"John Smith", "John Robert Smith", 0.9},
+		{"Hassan", "Ali Hassan", 0.9},
+	}
+	for _, tt := range partial {
+		if got := CompareNames(tt.a, tt.b); got.Score < tt.min {
+			t.Errorf("CompareNames(%q, %q) = %.3f via %s, want >= %.2f (%v)",
+				tt.a, tt.b, got.Score, got.Algorithm, tt.min, got.Scores)
+		}
+	}
+}
+
+func TestCompareNames_PartialNames(t *testing.T) {
+	// A shared given name or common prefix must not match a different person
+	tests := []struct{ a, b string }{
+		{"Robert Mugabe", "Robert Smith"},
+		{"Ali", "Ali Hassan Al-Majid"},
+		{"Ali Hassan", "Ali Hassan Al-Majid Khan"},
+		{"Mohammed Abdulrahman Ali", "Mohammed Abdul Rahman Omar"},
+		{"Maria", "Maria Garcia Lopez"},
+		{"Abdullah Ahmed", "Abdullah Omar"},
+	}
+	for _, tt := range tests {
+		if got := CompareNames(tt.a, tt.b); got.Score >= defaultThreshold {
+			t.Errorf("CompareNames(%q, %q) = %.3f via %s, want < %.2f (%v)",
+				tt.a, tt.b, got.Score, got.Algorithm, defaultThreshold, got.Scores)
+		}
+	}
+}
+
+func TestWatchlistScreen(t *testing.T) {
+	m := NewWatchlistManager()
+	m.LoadWatchlist(WatchlistTypeSDN, []WatchlistEntry{
+		{ID: "sdn-1", Name: "Muhammad Al-Rashid", Aliases: []string{"Abu Khalid"}, DOB: "12 Mar 1965", Country: "SY"},
+		{ID: "sdn-2", Name: "Muhammad Al Rashid", DOB: "1980", Country: "IQ"},
+	})
+
+	matches := m.Screen("Rashid Mohammed", "1965-03-12", "SY")
+	if len(matches) == 0 {
+		t.Fatal("expected a match")
+	}
+	top := matches[0]
+	if top.ListEntryID != "sdn-1" {
+		t.Errorf("expected the DOB and country to rank sdn-1 first, got %s", top.ListEntryID)
+	}
+	if top.Algorithm == "" || top.Explanation == "" {
+		t.Errorf("match is missing its explanation: %+v", top)
+	}
+	if len(top.TieBreakers) != 2 || top.TieBreakers[0] != "dob_exact" || top.TieBreakers[1] != "country_match" {
+		t.Errorf("unexpected tie breakers %v", top.TieBreakers)
+	}
+	for _, match := range matches {
+		if match.ListEntryID == "sdn-2" {
+			t.Errorf("conflicting DOB and country should drop sdn-2, scored %.3f", match.MatchScore)
+		}
+	}
+
+	alias := m.Screen("Abu Khaled", "", "")
+	if len(alias) != 1 || alias[0].MatchedAlias != "Abu Khalid" || alias[0].MatchedName != "Muhammad Al-Rashid" {
+		t.Fatalf("expected alias match, got %+v", alias)
+	}
+}
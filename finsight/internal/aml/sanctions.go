@@ -0,0 +1,562 @@
+package aml
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Sanctions list formats accepted by ParseSanctionsList
+const (
+	FormatOFACSDNXML = "ofac_sdn_xml"
+	FormatOFACSDNCSV = "ofac_sdn_csv"
+	FormatEUXML      = "eu_xml"
+	FormatUNXML      = "un_xml"
+)
+
+// SanctionsList is a parsed sanctions publication
+type SanctionsList struct {
+	Type        WatchlistType    `json:"type"`
+	Source      string           `json:"source"`
+	PublishedAt time.Time        `json:"published_at"`
+	Entries     []WatchlistEntry `json:"entries"`
+}
+
+// WatchlistFeed configures a sanctions list to download and import
+type WatchlistFeed struct {
+	Format   string `json:"format"`
+	URL      string `json:"url"`                 // file path or http(s) URL
+	AliasURL string `json:"alias_url,omitempty"` // OFAC alt.csv for the CSV format
+
+	// AllowShrink accepts an update that empties the list or removes more
+	// than the configured share of its entries
+	AllowShrink bool `json:"allow_shrink,omitempty"`
+}
+
+// ParseSanctionsList parses a list in one of the supported formats. alt is
+// only used by the OFAC CSV format and may be nil.
+func ParseSanctionsList(format string, r, alt io.Reader) (*SanctionsList, error) {
+	switch format {
+	case FormatOFACSDNXML:
+		return ParseOFACSDNXML(r)
+	case FormatOFACSDNCSV:
+		return ParseOFACSDNCSV(r, alt)
+	case FormatEUXML:
+		return ParseEUXML(r)
+	case FormatUNXML:
+		return ParseUNXML(r)
+	}
+	return nil, fmt.Errorf("unsupported sanctions list format %q", format)
+}
+
+// FetchSanctionsList downloads and parses a configured feed
+func FetchSanctionsList(ctx context.Context, client *http.Client, feed WatchlistFeed) (*SanctionsList, error) {
+	body, err := openFeed(ctx, client, feed.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var alt io.Reader
+	if feed.AliasURL != "" {
+		altBody, err := openFeed(ctx, client, feed.AliasURL)
+		if err != nil {
+			return nil, err
+		}
+		defer altBody.Close()
+		alt = altBody
+	}
+
+	list, err := ParseSanctionsList(feed.Format, body, alt)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", feed.URL, err)
+	}
+	return list, nil
+}
+
+func openFeed(ctx context.Context, client *http.Client, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetch %s: unexpected status %d", source, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// OFAC SDN XML (sdn.xml)
+
+type ofacSDNList struct {
+	XMLName     xml.Name `xml:"sdnList"`
+	PublishDate string   `xml:"publshInformation>Publish_Date"`
+	Entries     []struct {
+		UID       string   `xml:"uid"`
+		FirstName string   `xml:"firstName"`
+		LastName  string   `xml:"lastName"`
+		SDNType   string   `xml:"sdnType"`
+		Programs  []string `xml:"programList>program"`
+		AKAs      []struct {
+			Category  string `xml:"category"`
+			FirstName string `xml:"firstName"`
+			LastName  string `xml:"lastName"`
+		} `xml:"akaList>aka"`
+		Addresses []struct {
+			Country string `xml:"country"`
+		} `xml:"addressList>address"`
+		DOBs []struct {
+			DateOfBirth string `xml:"dateOfBirth"`
+			MainEntry   bool   `xml:"mainEntry"`
+		} `xml:"dateOfBirthList>dateOfBirthItem"`
+		Nationalities []ofacCountry `xml:"nationalityList>nationality"`
+		Citizenships  []ofacCountry `xml:"citizenshipList>citizenship"`
+	} `xml:"sdnEntry"`
+}
+
+type ofacCountry struct {
+	Country   string `xml:"country"`
+	MainEntry bool   `xml:"mainEntry"`
+}
+
+// ParseOFACSDNXML parses the OFAC Specially Designated Nationals XML file.
+// Weak a.k.a.s are skipped as OFAC does not expect them to be screened.
+func ParseOFACSDNXML(r io.Reader) (*SanctionsList, error) {
+	var doc ofacSDNList
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode OFAC SDN XML: %w", err)
+	}
+
+	list := &SanctionsList{Type: WatchlistTypeSDN, Source: FormatOFACSDNXML}
+	list.PublishedAt, _ = time.Parse("01/02/2006", strings.TrimSpace(doc.PublishDate))
+
+	for _, e := range doc.Entries {
+		entry := WatchlistEntry{
+			ID:       strings.TrimSpace(e.UID),
+			Type:     WatchlistTypeSDN,
+			Name:     joinName(e.FirstName, e.LastName),
+			Programs: trimAll(e.Programs),
+		}
+		for _, aka := range e.AKAs {
+			if strings.EqualFold(aka.Category, "weak") {
+				continue
+			}
+			entry.Aliases = appendUnique(entry.Aliases, joinName(aka.FirstName, aka.LastName))
+		}
+
+		var dobs []string
+		for _, d := range e.DOBs {
+			if d.MainEntry {
+				dobs = append([]string{d.DateOfBirth}, dobs...)
+			} else {
+				dobs = append(dobs, d.DateOfBirth)
+			}
+		}
+		entry.DOB = strings.Join(trimAll(dobs), "; ")
+
+		entry.Country = ofacMainCountry(e.Nationalities)
+		if entry.Country == "" {
+			entry.Country = ofacMainCountry(e.Citizenships)
+		}
+		if entry.Country == "" && len(e.Addresses) > 0 {
+			entry.Country = strings.TrimSpace(e.Addresses[0].Country)
+		}
+
+		if entry.ID != "" && entry.Name != "" {
+			list.Entries = append(list.Entries, entry)
+		}
+	}
+	return list, nil
+}
+
+func ofacMainCountry(countries []ofacCountry) string {
+	for _, c := range countries {
+		if c.MainEntry {
+			return strings.TrimSpace(c.Country)
+		}
+	}
+	if len(countries) > 0 {
+		return strings.TrimSpace(countries[0].Country)
+	}
+	return ""
+}
+
+// OFAC SDN CSV (sdn.csv and alt.csv)
+
+const ofacNull = "-0-"
+
+var (
+	ofacDOBPattern         = regexp.MustCompile(`(?i)\bDOB ([^;]+)`)
+	ofacNationalityPattern = regexp.MustCompile(`(?i)\b(?:nationality|citizen) ([^;(]+)`)
+)
+
+// ParseOFACSDNCSV parses the legacy OFAC sdn.csv file, with aliases from
+// alt.csv when alt is not nil. Dates of birth and nationality are taken
+// from the remarks column.
+func ParseOFACSDNCSV(sdn, alt io.Reader) (*SanctionsList, error) {
+	records, err := readOFACCSV(sdn)
+	if err != nil {
+		return nil, fmt.Errorf("read OFAC sdn.csv: %w", err)
+	}
+
+	list := &SanctionsList{Type: WatchlistTypeSDN, Source: FormatOFACSDNCSV}
+	index := make(map[string]int)
+	individuals := make(map[string]bool)
+	for _, rec := range records {
+		if len(rec) < 4 {
+			continue
+		}
+		individual := strings.EqualFold(ofacField(rec, 2), "individual")
+		entry := WatchlistEntry{
+			ID:   ofacField(rec, 0),
+			Type: WatchlistTypeSDN,
+			Name: ofacName(ofacField(rec, 1), individual),
+		}
+		for _, p := range strings.Split(ofacField(rec, 3), "] [") {
+			if p = strings.Trim(p, "[] "); p != "" {
+				entry.Programs = append(entry.Programs, p)
+			}
+		}
+		remarks := ofacField(rec, 11)
+		if m := ofacDOBPattern.FindStringSubmatch(remarks); m != nil {
+			entry.DOB = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(m[1]), "."))
+		}
+		if m := ofacNationalityPattern.FindStringSubmatch(remarks); m != nil {
+			entry.Country = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(m[1]), "."))
+		}
+
+		if entry.ID == "" || entry.Name == "" {
+			continue
+		}
+		index[entry.ID] = len(list.Entries)
+		individuals[entry.ID] = individual
+		list.Entries = append(list.Entries, entry)
+	}
+
+	if alt != nil {
+		aliases, err := readOFACCSV(alt)
+		if err != nil {
+			return nil, fmt.Errorf("read OFAC alt.csv: %w", err)
+		}
+		for _, rec := range aliases {
+			id := ofacField(rec, 0)
+			i, ok := index[id]
+			if !ok || strings.Contains(strings.ToLower(ofacField(rec, 4)), "weak") {
+				continue
+			}
+			list.Entries[i].Aliases = appendUnique(list.Entries[i].Aliases, ofacName(ofacField(rec, 3), individuals[id]))
+		}
+	}
+	return list, nil
+}
+
+func readOFACCSV(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var records [][]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the files end with a DOS end-of-file marker
+		if len(rec) == 1 && strings.Trim(rec[0], "\x1a \t") == "" {
+			continue
+		}
+		records = append(records, rec)
+	}
+}
+
+func ofacField(rec []string, i int) string {
+	if i >= len(rec) {
+		return ""
+	}
+	v := strings.TrimSpace(rec[i])
+	if v == ofacNull {
+		return ""
+	}
+	return v
+}
+
+// ofacName turns "LAST, First" into "First LAST" for individuals
+func ofacName(name string, individual bool) string {
+	if !individual {
+		return name
+	}
+	last, first, ok := strings.Cut(name, ", ")
+	if !ok {
+		return name
+	}
+	return joinName(first, last)
+}
+
+// EU consolidated financial sanctions list (FSF XML export)
+
+type euExport struct {
+	XMLName        xml.Name `xml:"export"`
+	GenerationDate string   `xml:"generationDate,attr"`
+	Entities       []struct {
+		LogicalID   string `xml:"logicalId,attr"`
+		Regulations []struct {
+			Programme string `xml:"programme,attr"`
+		} `xml:"regulation"`
+		Names []struct {
+			WholeName  string `xml:"wholeName,attr"`
+			FirstName  string `xml:"firstName,attr"`
+			MiddleName string `xml:"middleName,attr"`
+			LastName   string `xml:"lastName,attr"`
+		} `xml:"nameAlias"`
+		Citizenships []euCountry `xml:"citizenship"`
+		Birthdates   []struct {
+			Birthdate string `xml:"birthdate,attr"`
+			Year      string `xml:"year,attr"`
+		} `xml:"birthdate"`
+		Addresses []euCountry `xml:"address"`
+	} `xml:"sanctionEntity"`
+}
+
+type euCountry struct {
+	ISO2        string `xml:"countryIso2Code,attr"`
+	Description string `xml:"countryDescription,attr"`
+}
+
+// ParseEUXML parses the EU consolidated financial sanctions XML export.
+// The first name alias is used as the primary name.
+func ParseEUXML(r io.Reader) (*SanctionsList, error) {
+	var doc euExport
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode EU sanctions XML: %w", err)
+	}
+
+	list := &SanctionsList{Type: WatchlistTypeEU, Source: FormatEUXML, PublishedAt: parseListTime(doc.GenerationDate)}
+	for _, e := range doc.Entities {
+		entry := WatchlistEntry{ID: strings.TrimSpace(e.LogicalID), Type: WatchlistTypeEU}
+		for _, reg := range e.Regulations {
+			if reg.Programme != "" {
+				entry.Programs = appendUnique(entry.Programs, reg.Programme)
+			}
+		}
+		for _, n := range e.Names {
+			name := strings.TrimSpace(n.WholeName)
+			if name == "" {
+				name = joinName(n.FirstName, n.MiddleName, n.LastName)
+			}
+			if entry.Name == "" {
+				entry.Name = name
+			} else if name != entry.Name {
+				entry.Aliases = appendUnique(entry.Aliases, name)
+			}
+		}
+
+		var dobs []string
+		for _, b := range e.Birthdates {
+			if b.Birthdate != "" {
+				dobs = append(dobs, b.Birthdate)
+			} else if b.Year != "" {
+				dobs = append(dobs, b.Year)
+			}
+		}
+		entry.DOB = strings.Join(dobs, "; ")
+
+		entry.Country = euMainCountry(e.Citizenships)
+		if entry.Country == "" {
+			entry.Country = euMainCountry(e.Addresses)
+		}
+
+		if entry.ID != "" && entry.Name != "" {
+			list.Entries = append(list.Entries, entry)
+		}
+	}
+	return list, nil
+}
+
+func euMainCountry(countries []euCountry) string {
+	for _, c := range countries {
+		// "00" marks an unknown country
+		if c.ISO2 != "" && c.ISO2 != "00" {
+			return c.ISO2
+		}
+		if c.Description != "" && c.Description != "UNKNOWN" {
+			return c.Description
+		}
+	}
+	return ""
+}
+
+// UN Security Council consolidated list
+
+type unConsolidatedList struct {
+	XMLName       xml.Name `xml:"CONSOLIDATED_LIST"`
+	DateGenerated string   `xml:"dateGenerated,attr"`
+	Individuals   []struct {
+		DataID      string      `xml:"DATAID"`
+		FirstName   string      `xml:"FIRST_NAME"`
+		SecondName  string      `xml:"SECOND_NAME"`
+		ThirdName   string      `xml:"THIRD_NAME"`
+		FourthName  string      `xml:"FOURTH_NAME"`
+		ListType    string      `xml:"UN_LIST_TYPE"`
+		Reference   string      `xml:"REFERENCE_NUMBER"`
+		Nationality []string    `xml:"NATIONALITY>VALUE"`
+		Aliases     []unAlias   `xml:"INDIVIDUAL_ALIAS"`
+		DOBs        []unDOB     `xml:"INDIVIDUAL_DATE_OF_BIRTH"`
+		Addresses   []unAddress `xml:"INDIVIDUAL_ADDRESS"`
+	} `xml:"INDIVIDUALS>INDIVIDUAL"`
+	Entities []struct {
+		DataID    string      `xml:"DATAID"`
+		FirstName string      `xml:"FIRST_NAME"`
+		ListType  string      `xml:"UN_LIST_TYPE"`
+		Reference string      `xml:"REFERENCE_NUMBER"`
+		Aliases   []unAlias   `xml:"ENTITY_ALIAS"`
+		Addresses []unAddress `xml:"ENTITY_ADDRESS"`
+	} `xml:"ENTITIES>ENTITY"`
+}
+
+type unAlias struct {
+	Quality string `xml:"QUALITY"`
+	Name    string `xml:"ALIAS_NAME"`
+}
+
+type unDOB struct {
+	Type     string `xml:"TYPE_OF_DATE"`
+	Date     string `xml:"DATE"`
+	Year     string `xml:"YEAR"`
+	FromYear string `xml:"FROM_YEAR"`
+	ToYear   string `xml:"TO_YEAR"`
+}
+
+type unAddress struct {
+	Country string `xml:"COUNTRY"`
+}
+
+// ParseUNXML parses the UN Security Council consolidated sanctions list.
+// Low quality aliases are skipped.
+func ParseUNXML(r io.Reader) (*SanctionsList, error) {
+	var doc unConsolidatedList
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode UN sanctions XML: %w", err)
+	}
+
+	list := &SanctionsList{Type: WatchlistTypeUN, Source: FormatUNXML, PublishedAt: parseListTime(doc.DateGenerated)}
+	for _, ind := range doc.Individuals {
+		entry := WatchlistEntry{
+			ID:       strings.TrimSpace(ind.DataID),
+			Type:     WatchlistTypeUN,
+			Name:     joinName(ind.FirstName, ind.SecondName, ind.ThirdName, ind.FourthName),
+			Aliases:  unAliases(ind.Aliases),
+			Programs: unPrograms(ind.ListType, ind.Reference),
+		}
+
+		var dobs []string
+		for _, d := range ind.DOBs {
+			switch {
+			case d.Date != "":
+				dobs = append(dobs, strings.TrimSpace(d.Date))
+			case d.FromYear != "" && d.ToYear != "":
+				dobs = append(dobs, strings.TrimSpace(d.FromYear)+" to "+strings.TrimSpace(d.ToYear))
+			case d.Year != "":
+				dobs = append(dobs, strings.TrimSpace(d.Year))
+			}
+		}
+		entry.DOB = strings.Join(dobs, "; ")
+
+		if len(ind.Nationality) > 0 {
+			entry.Country = strings.TrimSpace(ind.Nationality[0])
+		} else if len(ind.Addresses) > 0 {
+			entry.Country = strings.TrimSpace(ind.Addresses[0].Country)
+		}
+
+		if entry.ID != "" && entry.Name != "" {
+			list.Entries = append(list.Entries, entry)
+		}
+	}
+
+	for _, ent := range doc.Entities {
+		entry := WatchlistEntry{
+			ID:       strings.TrimSpace(ent.DataID),
+			Type:     WatchlistTypeUN,
+			Name:     strings.TrimSpace(ent.FirstName),
+			Aliases:  unAliases(ent.Aliases),
+			Programs: unPrograms(ent.ListType, ent.Reference),
+		}
+		if len(ent.Addresses) > 0 {
+			entry.Country = strings.TrimSpace(ent.Addresses[0].Country)
+		}
+		if entry.ID != "" && entry.Name != "" {
+			list.Entries = append(list.Entries, entry)
+		}
+	}
+	return list, nil
+}
+
+func unAliases(aliases []unAlias) []string {
+	var out []string
+	for _, a := range aliases {
+		if strings.EqualFold(a.Quality, "low") {
+			continue
+		}
+		out = appendUnique(out, strings.TrimSpace(a.Name))
+	}
+	return out
+}
+
+func unPrograms(listType, reference string) []string {
+	return trimAll([]string{listType, reference})
+}
+
+func parseListTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func joinName(parts ...string) string {
+	return strings.Join(trimAll(parts), " ")
+}
+
+// trimAll trims each value and drops empty ones
+func trimAll(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func appendUnique(values []string, v string) []string {
+	if v == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == v {
+			return values
+		}
+	}
+	return append(values, v)
+}
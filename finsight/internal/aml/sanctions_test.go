@@ -0,0 +1,278 @@
+package aml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOFACXML = `<?xml version="1.0" standalone="yes"?>
+<sdnList xmlns="http://tempuri.org/sdnList.xsd">
+  <publshInformation><Publish_Date>03/15/2024</Publish_Date><Record_Count>2</Record_Count></publshInformation>
+  <sdnEntry>
+    <uid>1001</uid><firstName>Ivan</firstName><lastName>PETROV</lastName><sdnType>Individual</sdnType>
+    <programList><program>RUSSIA-EO14024</program></programList>
+    <akaList>
+      <aka><uid>2001</uid><type>a.k.a.</type><category>strong</category><firstName>Ivan</firstName><lastName>PETROFF</lastName></aka>
+      <aka><uid>2002</uid><type>a.k.a.</type><category>weak</category><lastName>VANYA</lastName></aka>
+    </akaList>
+    <dateOfBirthList>
+      <dateOfBirthItem><uid>3001</uid><dateOfBirth>1970</dateOfBirth><mainEntry>false</mainEntry></dateOfBirthItem>
+      <dateOfBirthItem><uid>3002</uid><dateOfBirth>12 Mar 1970</dateOfBirth><mainEntry>true</mainEntry></dateOfBirthItem>
+    </dateOfBirthList>
+    <nationalityList><nationality><uid>4001</uid><country>Russia</country><mainEntry>true</mainEntry></nationality></nationalityList>
+  </sdnEntry>
+  <sdnEntry>
+    <uid>1002</uid><lastName>ACME TRADING LLC</lastName><sdnType>Entity</sdnType>
+    <programList><program>IRAN</program><program>SDGT</program></programList>
+    <addressList><address><uid>5001</uid><city>Dubai</city><country>United Arab Emirates</country></address></addressList>
+  </sdnEntry>
+</sdnList>`
+
+func TestParseOFACSDNXML(t *testing.T) {
+	list, err := ParseOFACSDNXML(strings.NewReader(testOFACXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Type != WatchlistTypeSDN || !list.PublishedAt.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected list header %s %v", list.Type, list.PublishedAt)
+	}
+	if len(list.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(list.Entries))
+	}
+
+	ivan := list.Entries[0]
+	if ivan.ID != "1001" || ivan.Name != "Ivan PETROV" || ivan.Country != "Russia" {
+		t.Errorf("unexpected entry %+v", ivan)
+	}
+	if len(ivan.Aliases) != 1 || ivan.Aliases[0] != "Ivan PETROFF" {
+		t.Errorf("expected the weak alias to be skipped, got %v", ivan.Aliases)
+	}
+	if ivan.DOB != "12 Mar 1970; 1970" {
+		t.Errorf("expected main DOB first, got %q", ivan.DOB)
+	}
+
+	acme := list.Entries[1]
+	if acme.Country != "United Arab Emirates" || len(acme.Programs) != 2 {
+		t.Errorf("unexpected entity %+v", acme)
+	}
+}
+
+func TestParseOFACSDNCSV(t *testing.T) {
+	sdn := `1001,"PETROV, Ivan","individual","RUSSIA-EO14024",-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,"DOB 12 Mar 1970; POB Moscow, Russia; nationality Russia."
+1002,"ACME TRADING LLC",-0- ,"IRAN] [SDGT",-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0-
+` + "\x1a\n"
+	alt := `1001,2001,"aka","PETROFF, Ivan",-0-
+1001,2002,"aka","VANYA","weak"
+1002,2003,"fka","ACME GENERAL TRADING",-0-
+`
+	list, err := ParseOFACSDNCSV(strings.NewReader(sdn), strings.NewReader(alt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(list.Entries))
+	}
+
+	ivan := list.Entries[0]
+	if ivan.Name != "Ivan PETROV" || ivan.DOB != "12 Mar 1970" || ivan.Country != "Russia" {
+		t.Errorf("unexpected entry %+v", ivan)
+	}
+	if len(ivan.Aliases) != 1 || ivan.Aliases[0] != "Ivan PETROFF" {
+		t.Errorf("unexpected aliases %v", ivan.Aliases)
+	}
+
+	acme := list.Entries[1]
+	if strings.Join(acme.Programs, ",") != "IRAN,SDGT" || len(acme.Aliases) != 1 {
+		t.Errorf("unexpected entity %+v", acme)
+	}
+}
+
+func TestParseEUXML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<export xmlns="http://eu.europa.eu/fpi/fsd/export" generationDate="2024-03-15T10:00:00.000+01:00">
+  <sanctionEntity logicalId="13" euReferenceNumber="EU.27.28">
+    <regulation programme="SYR"/>
+    <subjectType code="person"/>
+    <nameAlias firstName="Bashar" lastName="Al-Assad" wholeName="Bashar Al-Assad" strong="true"/>
+    <nameAlias wholeName="Башар Асад" strong="true"/>
+    <citizenship countryIso2Code="SY" countryDescription="SYRIAN ARAB REPUBLIC"/>
+    <birthdate birthdate="1965-09-11" year="1965"/>
+  </sanctionEntity>
+</export>`
+	list, err := ParseEUXML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Type != WatchlistTypeEU || list.PublishedAt.IsZero() || len(list.Entries) != 1 {
+		t.Fatalf("unexpected list %+v", list)
+	}
+	e := list.Entries[0]
+	if e.Name != "Bashar Al-Assad" || e.Country != "SY" || e.DOB != "1965-09-11" || len(e.Aliases) != 1 || e.Programs[0] != "SYR" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestParseUNXML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<CONSOLIDATED_LIST dateGenerated="2024-03-15T12:00:00.000Z">
+  <INDIVIDUALS>
+    <INDIVIDUAL>
+      <DATAID>6908555</DATAID><FIRST_NAME>KIM</FIRST_NAME><SECOND_NAME>CHOL</SECOND_NAME>
+      <UN_LIST_TYPE>DPRK</UN_LIST_TYPE><REFERENCE_NUMBER>KPi.033</REFERENCE_NUMBER>
+      <NATIONALITY><VALUE>Democratic People's Republic of Korea</VALUE></NATIONALITY>
+      <INDIVIDUAL_ALIAS><QUALITY>Good</QUALITY><ALIAS_NAME>Kim Chol-su</ALIAS_NAME></INDIVIDUAL_ALIAS>
+      <INDIVIDUAL_ALIAS><QUALITY>Low</QUALITY><ALIAS_NAME>Chol</ALIAS_NAME></INDIVIDUAL_ALIAS>
+      <INDIVIDUAL_DATE_OF_BIRTH><TYPE_OF_DATE>BETWEEN</TYPE_OF_DATE><FROM_YEAR>1958</FROM_YEAR><TO_YEAR>1962</TO_YEAR></INDIVIDUAL_DATE_OF_BIRTH>
+    </INDIVIDUAL>
+  </INDIVIDUALS>
+  <ENTITIES>
+    <ENTITY>
+      <DATAID>110404</DATAID><FIRST_NAME>KOREA MINING DEVELOPMENT TRADING CORPORATION</FIRST_NAME>
+      <UN_LIST_TYPE>DPRK</UN_LIST_TYPE><REFERENCE_NUMBER>KPe.001</REFERENCE_NUMBER>
+      <ENTITY_ALIAS><QUALITY>a.k.a.</QUALITY><ALIAS_NAME>KOMID</ALIAS_NAME></ENTITY_ALIAS>
+      <ENTITY_ADDRESS><CITY>Pyongyang</CITY><COUNTRY>Democratic People's Republic of Korea</COUNTRY></ENTITY_ADDRESS>
+    </ENTITY>
+  </ENTITIES>
+</CONSOLIDATED_LIST>`
+	list, err := ParseUNXML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(list.Entries))
+	}
+	kim := list.Entries[0]
+	if kim.Name != "KIM CHOL" || kim.DOB != "1958 to 1962" || len(kim.Aliases) != 1 {
+		t.Errorf("unexpected individual %+v", kim)
+	}
+	if komid := list.Entries[1]; komid.Aliases[0] != "KOMID" || komid.Programs[1] != "KPe.001" {
+		t.Errorf("unexpected entity %+v", komid)
+	}
+}
+
+func TestWatchlistUpdateVersions(t *testing.T) {
+	m := NewWatchlistManager()
+	v1 := []WatchlistEntry{{ID: "1", Name: "Ivan Petrov"}, {ID: "2", Name: "Acme Trading"}}
+
+	d, _ := m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: v1}, false)
+	if !d.Changed || d.Version.Version != 1 || len(d.Added) != 2 {
+		t.Fatalf("unexpected first delta %+v", d)
+	}
+
+	if d, _ = m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: v1}, false); d.Changed || d.Version.Version != 1 {
+		t.Errorf("identical list should not create a version: %+v", d)
+	}
+
+	v2 := []WatchlistEntry{{ID: "1", Name: "Ivan Petrov", Aliases: []string{"Ivan Petroff"}}, {ID: "3", Name: "New Person"}}
+	// v2 delists half of a two-entry list, which needs the override
+	d, _ = m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: v2}, true)
+	if !d.Changed || d.Version.Version != 2 || len(d.Added) != 1 || len(d.Updated) != 1 || len(d.Removed) != 1 {
+		t.Errorf("unexpected second delta: added %d updated %d removed %d", len(d.Added), len(d.Updated), len(d.Removed))
+	}
+	if len(m.Versions(WatchlistTypeSDN)) != 2 {
+		t.Errorf("expected 2 versions")
+	}
+}
+
+func TestWatchlistUpdateRejectsShrink(t *testing.T) {
+	m := NewWatchlistManager()
+	var full []WatchlistEntry
+	for i := 0; i < 10; i++ {
+		full = append(full, WatchlistEntry{ID: fmt.Sprint(i), Name: fmt.Sprintf("Person %d", i)})
+	}
+	if _, err := m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: full}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Update(&SanctionsList{Type: WatchlistTypeSDN}, false); !errors.Is(err, ErrWatchlistShrunk) {
+		t.Errorf("empty list err = %v, want ErrWatchlistShrunk", err)
+	}
+	if _, err := m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: full[:5]}, false); !errors.Is(err, ErrWatchlistShrunk) {
+		t.Errorf("truncated list err = %v, want ErrWatchlistShrunk", err)
+	}
+	if versions := m.Versions(WatchlistTypeSDN); len(versions) != 1 || versions[0].EntryCount != 10 {
+		t.Fatalf("rejected updates must keep the current list, got %+v", versions)
+	}
+
+	// A small delisting is accepted, and a larger one with the override
+	if d, err := m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: full[:9]}, false); err != nil || d.Version.Removed != 1 {
+		t.Errorf("expected one delisting, got %+v, %v", d, err)
+	}
+	if d, err := m.Update(&SanctionsList{Type: WatchlistTypeSDN, Entries: full[:3]}, true); err != nil || d.Version.Removed != 6 {
+		t.Errorf("override should apply the update, got %+v, %v", d, err)
+	}
+}
+
+func TestImportWatchlistWithoutAlertConsumer(t *testing.T) {
+	// The engine is not started, so nothing reads the alert channel, and the
+	// re-screen raises more alerts than the channel buffers
+	e := NewEngine(&Config{Enabled: true})
+	ctx := context.Background()
+	for i := 0; i < 150; i++ {
+		e.ScreenCustomer(ctx, fmt.Sprintf("cust-%d", i), "Ivan Petrov", "", "")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		list := &SanctionsList{Type: WatchlistTypeSDN, Entries: []WatchlistEntry{{ID: "1", Name: "Ivan Petrov"}}}
+		if _, err := e.ImportWatchlist(ctx, list, false); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ImportWatchlist blocked on alert delivery")
+	}
+	if alerts := e.ListAlerts(AlertFilter{Limit: 200}); len(alerts) != 150 {
+		t.Errorf("expected 150 re-screening alerts, got %d", len(alerts))
+	}
+}
+
+func TestImportWatchlistRescreens(t *testing.T) {
+	e := NewEngine(&Config{Enabled: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Start(ctx)
+	defer e.Stop()
+
+	if _, err := e.ImportWatchlist(ctx, &SanctionsList{Type: WatchlistTypeSDN, Entries: []WatchlistEntry{{ID: "1", Name: "Acme Trading"}}}, false); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := e.ScreenCustomer(ctx, "cust-1", "Ivan Petrov", "1970-03-12", "RU")
+	if len(matches) != 0 {
+		t.Fatalf("expected no matches before the update, got %d", len(matches))
+	}
+
+	list, err := ParseOFACSDNXML(strings.NewReader(testOFACXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The publication replaces the whole placeholder list
+	delta, err := e.ImportWatchlist(ctx, list, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta.CustomersRescreened != 1 || delta.NewMatches != 1 {
+		t.Fatalf("expected one new match from re-screening, got %+v", delta)
+	}
+
+	// Re-importing the same publication is a no-op
+	if delta, _ = e.ImportWatchlist(ctx, list, false); delta.Changed || delta.NewMatches != 0 {
+		t.Errorf("unchanged list should not re-screen: %+v", delta)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(e.ListAlerts(AlertFilter{CustomerID: "cust-1"})) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	alerts := e.ListAlerts(AlertFilter{CustomerID: "cust-1"})
+	if len(alerts) != 1 || alerts[0].AlertType != AlertTypeWatchlistMatch || !strings.Contains(alerts[0].Description, "sdn list version 2") {
+		t.Fatalf("expected a watchlist alert from re-screening, got %+v", alerts)
+	}
+}
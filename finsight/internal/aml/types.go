@@ -14,6 +14,7 @@ const (
 	SARStatusDraft     SARStatus = "draft"
 	SARStatusPending   SARStatus = "pending_review"
 	SARStatusApproved  SARStatus = "approved"
+	SARStatusSubmitted SARStatus = "submitted"
 	SARStatusFiled     SARStatus = "filed"
 	SARStatusRejected  SARStatus = "rejected"
 )
@@ -22,9 +23,11 @@ const (
 type CTRStatus string
 
 const (
-	CTRStatusPending  CTRStatus = "pending"
-	CTRStatusFiled    CTRStatus = "filed"
-	CTRStatusExempt   CTRStatus = "exempt"
+	CTRStatusPending   CTRStatus = "pending"
+	CTRStatusSubmitted CTRStatus = "submitted"
+	CTRStatusFiled     CTRStatus = "filed"
+	CTRStatusRejected  CTRStatus = "rejected"
+	CTRStatusExempt    CTRStatus = "exempt"
 )
 
 // RiskLevel represents a customer risk level
@@ -53,7 +56,7 @@ const (
 type SuspiciousActivityReport struct {
 	ID                 string                 `json:"id"`
 	Status             SARStatus              `json:"status"`
-	FilingType         string                 `json:"filing_type"` // initial, continuing, joint
+	FilingType         string                 `json:"filing_type"` // initial, continuing, joint, correction
 	SubjectType        string                 `json:"subject_type"` // individual, entity
 	Subject            *SARSubject            `json:"subject"`
 	SuspiciousActivity *SuspiciousActivity    `json:"suspicious_activity"`
@@ -62,10 +65,14 @@ type SuspiciousActivityReport struct {
 	TotalAmount        decimal.Decimal        `json:"total_amount"`
 	DateRange          *DateRange             `json:"date_range"`
 	FilingInstitution  *FilingInstitution     `json:"filing_institution"`
+	CaseID             string                 `json:"case_id,omitempty"`
 	PreparedBy         string                 `json:"prepared_by"`
 	ReviewedBy         string                 `json:"reviewed_by,omitempty"`
 	ApprovedBy         string                 `json:"approved_by,omitempty"`
 	BSAIdentifier      string                 `json:"bsa_identifier,omitempty"`
+	PriorBSAIdentifier string                 `json:"prior_bsa_identifier,omitempty"` // Required for continuing and correction filings
+	BatchID            string                 `json:"batch_id,omitempty"`
+	FilingErrors       []string               `json:"filing_errors,omitempty"`
 	CreatedAt          time.Time              `json:"created_at"`
 	UpdatedAt          time.Time              `json:"updated_at"`
 	FiledAt            *time.Time             `json:"filed_at,omitempty"`
@@ -133,19 +140,22 @@ type FilingInstitution struct {
 
 // CurrencyTransactionReport represents a CTR filing
 type CurrencyTransactionReport struct {
-	ID                string                 `json:"id"`
-	Status            CTRStatus              `json:"status"`
-	TransactionDate   time.Time              `json:"transaction_date"`
-	Transactions      []CTRTransaction       `json:"transactions"`
-	TotalCashIn       decimal.Decimal        `json:"total_cash_in"`
-	TotalCashOut      decimal.Decimal        `json:"total_cash_out"`
-	Persons           []CTRPerson            `json:"persons"`
-	FilingInstitution *FilingInstitution     `json:"filing_institution"`
-	PreparedBy        string                 `json:"prepared_by"`
-	BSAIdentifier     string                 `json:"bsa_identifier,omitempty"`
-	CreatedAt         time.Time              `json:"created_at"`
-	FiledAt           *time.Time             `json:"filed_at,omitempty"`
-	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	ID                 string                 `json:"id"`
+	Status             CTRStatus              `json:"status"`
+	TransactionDate    time.Time              `json:"transaction_date"`
+	Transactions       []CTRTransaction       `json:"transactions"`
+	TotalCashIn        decimal.Decimal        `json:"total_cash_in"`
+	TotalCashOut       decimal.Decimal        `json:"total_cash_out"`
+	Persons            []CTRPerson            `json:"persons"`
+	FilingInstitution  *FilingInstitution     `json:"filing_institution"`
+	PreparedBy         string                 `json:"prepared_by"`
+	BSAIdentifier      string                 `json:"bsa_identifier,omitempty"`
+	PriorBSAIdentifier string                 `json:"prior_bsa_identifier,omitempty"` // Set when correcting a filed CTR
+	BatchID            string                 `json:"batch_id,omitempty"`
+	FilingErrors       []string               `json:"filing_errors,omitempty"`
+	CreatedAt          time.Time              `json:"created_at"`
+	FiledAt            *time.Time             `json:"filed_at,omitempty"`
+	Metadata           map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // CTRTransaction represents a transaction in a CTR
@@ -160,7 +170,7 @@ type CTRTransaction struct {
 
 // CTRPerson represents a person involved in a CTR
 type CTRPerson struct {
-	Role           string   `json:"role"` // conductor, beneficiary
+	Role           string   `json:"role"` // conductor, agent, beneficiary
 	Name           string   `json:"name"`
 	DateOfBirth    string   `json:"date_of_birth,omitempty"`
 	SSN            string   `json:"ssn,omitempty"`
@@ -205,16 +215,22 @@ type RiskFactor struct {
 
 // WatchlistMatch represents a match against a watchlist
 type WatchlistMatch struct {
-	ID             string        `json:"id"`
-	WatchlistType  WatchlistType `json:"watchlist_type"`
-	MatchedName    string        `json:"matched_name"`
-	MatchScore     float64       `json:"match_score"`
-	MatchType      string        `json:"match_type"` // exact, fuzzy, alias
-	ListEntryID    string        `json:"list_entry_id,omitempty"`
-	Status         string        `json:"status"` // pending_review, confirmed, false_positive
-	ReviewedBy     string        `json:"reviewed_by,omitempty"`
-	ReviewedAt     *time.Time    `json:"reviewed_at,omitempty"`
-	MatchedAt      time.Time     `json:"matched_at"`
+	ID              string             `json:"id"`
+	WatchlistType   WatchlistType      `json:"watchlist_type"`
+	MatchedName     string             `json:"matched_name"`
+	MatchedAlias    string             `json:"matched_alias,omitempty"`
+	MatchScore      float64            `json:"match_score"`
+	NameScore       float64            `json:"name_score"`
+	MatchType       string             `json:"match_type"` // exact, alias, fuzzy, phonetic
+	Algorithm       string             `json:"algorithm,omitempty"`
+	AlgorithmScores map[string]float64 `json:"algorithm_scores,omitempty"`
+	TieBreakers     []string           `json:"tie_breakers,omitempty"` // dob_exact, dob_year, dob_mismatch, country_match, country_mismatch
+	Explanation     string             `json:"explanation,omitempty"`
+	ListEntryID     string             `json:"list_entry_id,omitempty"`
+	Status          string             `json:"status"` // pending_review, confirmed, false_positive, delisted
+	ReviewedBy      string             `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time         `json:"reviewed_at,omitempty"`
+	MatchedAt       time.Time          `json:"matched_at"`
 }
 
 // TransactionProfile represents a customer's typical transaction pattern
@@ -250,6 +266,10 @@ type AMLCase struct {
 	UpdatedAt       time.Time              `json:"updated_at"`
 	ClosedAt        *time.Time             `json:"closed_at,omitempty"`
 	DueDate         *time.Time             `json:"due_date,omitempty"`
+	Queue           string                 `json:"queue"`
+	DetectedAt      *time.Time             `json:"detected_at,omitempty"`
+	SLAs            []SLAClock             `json:"slas"`
+	Evidence        []CaseEvidence         `json:"evidence"`
 	Metadata        map[string]interface{} `json:"metadata,omitempty"`
 }
 
@@ -308,6 +328,8 @@ const (
 	AlertTypeUnusualPattern    AMLAlertType = "unusual_pattern"
 	AlertTypeCTRThreshold      AMLAlertType = "ctr_threshold"
 	AlertTypeKYCExpiring       AMLAlertType = "kyc_expiring"
+	AlertTypeNetworkPattern    AMLAlertType = "network_pattern"
+	AlertTypeUnconverted       AMLAlertType = "unconverted_amount"
 )
 
 // AlertIndicator represents a specific indicator that triggered an alert
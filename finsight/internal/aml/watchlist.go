@@ -0,0 +1,380 @@
+package aml
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// WatchlistVersion records one revision of a watchlist
+type WatchlistVersion struct {
+	ListType    WatchlistType `json:"list_type"`
+	Version     int           `json:"version"`
+	Source      string        `json:"source"`
+	PublishedAt time.Time     `json:"published_at"`
+	LoadedAt    time.Time     `json:"loaded_at"`
+	Checksum    string        `json:"checksum"`
+	EntryCount  int           `json:"entry_count"`
+	Added       int           `json:"added"`
+	Updated     int           `json:"updated"`
+	Removed     int           `json:"removed"`
+}
+
+// WatchlistDelta describes the entries changed by a list update
+type WatchlistDelta struct {
+	Version             WatchlistVersion `json:"version"`
+	Changed             bool             `json:"changed"`
+	Added               []WatchlistEntry `json:"added,omitempty"`
+	Updated             []WatchlistEntry `json:"updated,omitempty"`
+	Removed             []WatchlistEntry `json:"removed,omitempty"`
+	CustomersRescreened int              `json:"customers_rescreened"`
+	NewMatches          int              `json:"new_matches"`
+}
+
+// screeningSubject is what a customer was last screened with, kept so the
+// customer can be re-screened when a list changes
+type screeningSubject struct {
+	name    string
+	dob     string
+	country string
+	matched map[string]bool // watchlist type + entry ID
+}
+
+// ErrWatchlistShrunk is returned for a list update that is empty or removes
+// more entries than the manager allows, which usually means a truncated or
+// broken feed rather than a real delisting
+var ErrWatchlistShrunk = errors.New("watchlist update removes too many entries")
+
+// Update replaces a watchlist with a new publication. Entries are compared
+// by ID and content fingerprint; a new version is recorded only when
+// something was added, changed or removed. Unless allowShrink is set, an
+// empty list or one that removes more than the allowed share of the current
+// entries is rejected with ErrWatchlistShrunk and the current list is kept.
+func (m *WatchlistManager) Update(list *SanctionsList, allowShrink bool) (*WatchlistDelta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.fingerprints[list.Type]
+	next := make(map[string]string, len(list.Entries))
+	entries := make([]WatchlistEntry, 0, len(list.Entries))
+	delta := &WatchlistDelta{}
+
+	for _, entry := range list.Entries {
+		if entry.Type == "" {
+			entry.Type = list.Type
+		}
+		fp := entryFingerprint(entry)
+		if _, dup := next[entry.ID]; dup {
+			continue
+		}
+		next[entry.ID] = fp
+		entries = append(entries, entry)
+
+		old, ok := prev[entry.ID]
+		switch {
+		case !ok:
+			delta.Added = append(delta.Added, entry)
+		case old != fp:
+			delta.Updated = append(delta.Updated, entry)
+		}
+	}
+	for _, entry := range m.entries[list.Type] {
+		if _, ok := next[entry.ID]; !ok {
+			delta.Removed = append(delta.Removed, entry)
+		}
+	}
+
+	if !allowShrink {
+		current := len(m.entries[list.Type])
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("%w: %s list has no entries", ErrWatchlistShrunk, list.Type)
+		}
+		if current > 0 && float64(len(delta.Removed)) > m.maxShrink*float64(current) {
+			return nil, fmt.Errorf("%w: %s list removes %d of %d entries", ErrWatchlistShrunk, list.Type, len(delta.Removed), current)
+		}
+	}
+
+	checksum := listChecksum(next)
+	versions := m.versions[list.Type]
+	if n := len(versions); n > 0 && versions[n-1].Checksum == checksum {
+		delta.Version = versions[n-1]
+		return delta, nil
+	}
+
+	delta.Changed = true
+	delta.Version = WatchlistVersion{
+		ListType:    list.Type,
+		Version:     len(versions) + 1,
+		Source:      list.Source,
+		PublishedAt: list.PublishedAt,
+		LoadedAt:    time.Now(),
+		Checksum:    checksum,
+		EntryCount:  len(entries),
+		Added:       len(delta.Added),
+		Updated:     len(delta.Updated),
+		Removed:     len(delta.Removed),
+	}
+
+	m.entries[list.Type] = entries
+	m.fingerprints[list.Type] = next
+	m.versions[list.Type] = append(versions, delta.Version)
+	return delta, nil
+}
+
+// Versions returns the version history of a watchlist, oldest first
+func (m *WatchlistManager) Versions(listType WatchlistType) []WatchlistVersion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]WatchlistVersion(nil), m.versions[listType]...)
+}
+
+// CurrentVersions returns the latest version of every loaded watchlist
+func (m *WatchlistManager) CurrentVersions() []WatchlistVersion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var current []WatchlistVersion
+	for _, versions := range m.versions {
+		if len(versions) > 0 {
+			current = append(current, versions[len(versions)-1])
+		}
+	}
+	sort.Slice(current, func(i, j int) bool { return current[i].ListType < current[j].ListType })
+	return current
+}
+
+// screenChanged screens a name against a subset of one list's entries
+func (m *WatchlistManager) screenChanged(name, dob, country string, listType WatchlistType, entries []WatchlistEntry) []WatchlistMatch {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.screenEntries(name, dob, country, listType, entries)
+}
+
+func entryFingerprint(entry WatchlistEntry) string {
+	entry.Aliases = sortedCopy(entry.Aliases)
+	entry.Programs = sortedCopy(entry.Programs)
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func listChecksum(fingerprints map[string]string) string {
+	ids := make([]string, 0, len(fingerprints))
+	for id := range fingerprints {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s:%s\n", id, fingerprints[id])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	out := append([]string(nil), values...)
+	sort.Strings(out)
+	return out
+}
+
+func matchKey(listType WatchlistType, entryID string) string {
+	return string(listType) + ":" + entryID
+}
+
+// ImportWatchlist applies a parsed sanctions list. When the list changed,
+// every previously screened customer is re-screened against the added and
+// updated entries and an alert is raised for each new hit. Matches against
+// removed entries that are still pending review are marked delisted. An
+// empty or sharply shrunken list is rejected unless allowShrink is set.
+func (e *Engine) ImportWatchlist(ctx context.Context, list *SanctionsList, allowShrink bool) (*WatchlistDelta, error) {
+	if list == nil || list.Type == "" {
+		return nil, fmt.Errorf("watchlist type is required")
+	}
+
+	delta, err := e.watchlistMgr.Update(list, allowShrink)
+	if err != nil {
+		return nil, err
+	}
+	if !delta.Changed {
+		return delta, nil
+	}
+	e.markDelisted(list.Type, delta.Removed)
+
+	changed := append(append([]WatchlistEntry(nil), delta.Added...), delta.Updated...)
+	if len(changed) == 0 {
+		return delta, nil
+	}
+
+	e.mu.RLock()
+	subjects := make(map[string]screeningSubject, len(e.screened))
+	for id, s := range e.screened {
+		subjects[id] = *s
+	}
+	e.mu.RUnlock()
+
+	reason := fmt.Sprintf("%s list version %d", list.Type, delta.Version.Version)
+	for customerID, s := range subjects {
+		if err := ctx.Err(); err != nil {
+			return delta, err
+		}
+		delta.CustomersRescreened++
+
+		matches := e.watchlistMgr.screenChanged(s.name, s.dob, s.country, list.Type, changed)
+		for _, match := range e.recordMatches(customerID, s.name, s.dob, s.country, matches) {
+			// Stored directly: a large re-screen must not wait on the alert
+			// channel
+			e.storeAlert(e.watchlistAlert(customerID, match, reason))
+			delta.NewMatches++
+		}
+	}
+	return delta, nil
+}
+
+// RefreshWatchlists downloads and imports every configured feed
+func (e *Engine) RefreshWatchlists(ctx context.Context) ([]*WatchlistDelta, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	var deltas []*WatchlistDelta
+	var errs []error
+	for _, feed := range e.config.WatchlistFeeds {
+		list, err := FetchSanctionsList(ctx, client, feed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delta, err := e.ImportWatchlist(ctx, list, feed.AllowShrink)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, errors.Join(errs...)
+}
+
+// WatchlistVersions returns the current version of each loaded watchlist
+func (e *Engine) WatchlistVersions() []WatchlistVersion {
+	return e.watchlistMgr.CurrentVersions()
+}
+
+func (e *Engine) refreshWatchlists(ctx context.Context) {
+	interval := e.config.WatchlistRefresh
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deltas, err := e.RefreshWatchlists(ctx)
+		if err != nil {
+			log.Printf("aml: watchlist refresh: %v", err)
+		}
+		for _, d := range deltas {
+			if d.Changed {
+				log.Printf("aml: %s watchlist version %d: %d added, %d updated, %d removed, %d new matches",
+					d.Version.ListType, d.Version.Version, d.Version.Added, d.Version.Updated, d.Version.Removed, d.NewMatches)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordMatches remembers what a customer was screened with and returns the
+// matches not already reported for them
+func (e *Engine) recordMatches(customerID, name, dob, country string, matches []WatchlistMatch) []WatchlistMatch {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	subject, ok := e.screened[customerID]
+	if !ok || subject.name != name || subject.dob != dob || subject.country != country {
+		subject = &screeningSubject{name: name, dob: dob, country: country, matched: make(map[string]bool)}
+		e.screened[customerID] = subject
+	}
+
+	var fresh []WatchlistMatch
+	for _, match := range matches {
+		key := matchKey(match.WatchlistType, match.ListEntryID)
+		if subject.matched[key] {
+			continue
+		}
+		subject.matched[key] = true
+		fresh = append(fresh, match)
+	}
+
+	if profile, ok := e.customerProfiles[customerID]; ok && len(fresh) > 0 {
+		profile.WatchlistMatches = append(profile.WatchlistMatches, fresh...)
+		profile.RiskLevel = RiskLevelHigh
+		profile.RiskScore = 0.9
+		profile.UpdatedAt = time.Now()
+	}
+	return fresh
+}
+
+func (e *Engine) markDelisted(listType WatchlistType, removed []WatchlistEntry) {
+	if len(removed) == 0 {
+		return
+	}
+	gone := make(map[string]bool, len(removed))
+	for _, entry := range removed {
+		gone[entry.ID] = true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, subject := range e.screened {
+		for id := range gone {
+			delete(subject.matched, matchKey(listType, id))
+		}
+	}
+	for _, profile := range e.customerProfiles {
+		for i := range profile.WatchlistMatches {
+			match := &profile.WatchlistMatches[i]
+			if match.WatchlistType == listType && gone[match.ListEntryID] && match.Status == "pending_review" {
+				match.Status = "delisted"
+			}
+		}
+	}
+}
+
+func (e *Engine) watchlistAlert(customerID string, match WatchlistMatch, reason string) *AMLAlert {
+	description := fmt.Sprintf("Customer matched against %s watchlist: %s (score: %.2f, %s match via %s). %s",
+		match.WatchlistType, match.MatchedName, match.MatchScore, match.MatchType, match.Algorithm, match.Explanation)
+	if reason != "" {
+		description += ". Raised by re-screening after " + reason
+	}
+
+	return &AMLAlert{
+		ID:          generateID("alert"),
+		CustomerID:  customerID,
+		AlertType:   AlertTypeWatchlistMatch,
+		Severity:    models.AlertSeverityCritical,
+		Status:      models.AlertStatusOpen,
+		Title:       fmt.Sprintf("Watchlist Match: %s", match.WatchlistType),
+		Description: strings.TrimSpace(description),
+		RiskScore:   match.MatchScore,
+		CreatedAt:   time.Now(),
+	}
+}
@@ -1,13 +1,29 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/savegress/finsight/internal/aml"
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/audit"
 	"github.com/savegress/finsight/internal/fraud"
+	"github.com/savegress/finsight/internal/fx"
+	"github.com/savegress/finsight/internal/ingest"
 	"github.com/savegress/finsight/internal/reconciliation"
+	"github.com/savegress/finsight/internal/regulatory"
 	"github.com/savegress/finsight/internal/reporting"
 	"github.com/savegress/finsight/internal/transactions"
 	"github.com/savegress/finsight/pkg/models"
@@ -20,15 +36,30 @@ type Handlers struct {
 	fraud        *fraud.Detector
 	reconcile    *reconciliation.Engine
 	reports      *reporting.Generator
+	fx           *fx.Converter
+	scheduler    *reporting.Scheduler
+	aml          *aml.Engine
+	regulatory   *regulatory.Engine
+	ingest       *ingest.Ingestor
+	audit        *audit.Trail
+	approvals    *approval.Workflow
+	auditLocks   entityLocks
 }
 
 // NewHandlers creates new handlers
-func NewHandlers(txn *transactions.Engine, fr *fraud.Detector, recon *reconciliation.Engine, rpt *reporting.Generator) *Handlers {
+func NewHandlers(txn *transactions.Engine, fr *fraud.Detector, recon *reconciliation.Engine, rpt *reporting.Generator, conv *fx.Converter, sched *reporting.Scheduler, amlEngine *aml.Engine, reg *regulatory.Engine, trail *audit.Trail, approvals *approval.Workflow) *Handlers {
 	return &Handlers{
 		transactions: txn,
 		fraud:        fr,
 		reconcile:    recon,
 		reports:      rpt,
+		fx:           conv,
+		scheduler:    sched,
+		aml:          amlEngine,
+		regulatory:   reg,
+		ingest:       ingest.NewIngestor(txn.GetTransaction),
+		audit:        trail,
+		approvals:    approvals,
 	}
 }
 
@@ -76,6 +107,7 @@ func (h *Handlers) CreateTransaction(w http.ResponseWriter, r *http.Request) {
 	}
 	txn.CreatedAt = time.Now()
 	txn.Status = models.TransactionStatusPending
+	clearConversion(&txn)
 
 	if err := h.transactions.ProcessTransaction(r.Context(), &txn); err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
@@ -123,6 +155,85 @@ func (h *Handlers) UpdateTransaction(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, update)
 }
 
+// IngestISO20022 ingests an ISO 20022 pain.001, pacs.008 or pacs.002
+// message
+func (h *Handlers) IngestISO20022(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.ingest.IngestISO20022(data)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.processIngested(w, r, result)
+}
+
+// IngestISO8583 ingests ISO 8583 card messages, each with a two-byte
+// length header
+func (h *Handlers) IngestISO8583(w http.ResponseWriter, r *http.Request) {
+	enc := ingest.ISO8583Binary
+	switch v := r.URL.Query().Get("encoding"); v {
+	case "", string(ingest.ISO8583Binary):
+	case string(ingest.ISO8583Hex):
+		enc = ingest.ISO8583Hex
+	default:
+		respondError(w, http.StatusBadRequest, "encoding must be binary or hex")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.ingest.IngestISO8583(data, enc)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.processIngested(w, r, result)
+}
+
+// processIngested scores new transactions for fraud and processes new and
+// updated ones. A transaction that fails to process is reported without
+// stopping the rest.
+func (h *Handlers) processIngested(w http.ResponseWriter, r *http.Request, result *ingest.Result) {
+	evaluations := make(map[string]*fraud.EvaluationResult, len(result.Created))
+	var failures []string
+	for _, txn := range result.Created {
+		evaluation := h.fraud.Evaluate(txn, nil)
+		txn.RiskScore = evaluation.RiskScore
+		evaluations[txn.ID] = evaluation
+	}
+	for _, txn := range append(result.Created, result.Updated...) {
+		if err := h.transactions.ProcessTransaction(r.Context(), txn); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", txn.ID, err))
+		}
+	}
+
+	respond(w, http.StatusCreated, map[string]interface{}{
+		"result":      result,
+		"evaluations": evaluations,
+		"errors":      failures,
+	})
+}
+
+// GetTransactionJournals gets the ledger journals posted for a transaction
+func (h *Handlers) GetTransactionJournals(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, ok := h.transactions.GetTransaction(id); !ok {
+		respondError(w, http.StatusNotFound, "Transaction not found")
+		return
+	}
+
+	respond(w, http.StatusOK, h.transactions.GetTransactionJournals(id))
+}
+
 // GetTransactionStats gets transaction statistics
 func (h *Handlers) GetTransactionStats(w http.ResponseWriter, r *http.Request) {
 	stats := h.transactions.GetStats()
@@ -192,6 +303,16 @@ func (h *Handlers) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("as_of") != "" {
+		asOf, err := parseTimeParam(r, "as_of", time.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respond(w, http.StatusOK, h.transactions.GetAccountBalanceAt(id, asOf))
+		return
+	}
+
 	respond(w, http.StatusOK, map[string]interface{}{
 		"account_id":        acc.ID,
 		"balance":           acc.Balance,
@@ -201,6 +322,42 @@ func (h *Handlers) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetAccountStatement gets the ledger statement of an account for a period
+func (h *Handlers) GetAccountStatement(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, ok := h.transactions.GetAccount(id); !ok {
+		respondError(w, http.StatusNotFound, "Account not found")
+		return
+	}
+
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, err := parseTimeParam(r, "from", to.AddDate(0, -1, 0))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, h.transactions.GetAccountStatement(id, from, to))
+}
+
+// Ledger handlers
+
+// GetTrialBalance gets the ledger trial balance
+func (h *Handlers) GetTrialBalance(w http.ResponseWriter, r *http.Request) {
+	asOf, err := parseTimeParam(r, "as_of", time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, h.transactions.GetTrialBalance(asOf))
+}
+
 // Fraud handlers
 
 // ListFraudAlerts lists fraud alerts
@@ -265,6 +422,7 @@ func (h *Handlers) EvaluateTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clearConversion(&req.Transaction)
 	result := h.fraud.Evaluate(&req.Transaction, req.Context)
 	respond(w, http.StatusOK, result)
 }
@@ -275,197 +433,1286 @@ func (h *Handlers) GetFraudStats(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, stats)
 }
 
-// Reconciliation handlers
+// GetFraudModels lists the champion and challenger models with their
+// shadow-scoring statistics
+func (h *Handlers) GetFraudModels(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.fraud.ModelScorer().Status())
+}
 
-// ListReconcileBatches lists reconciliation batches
-func (h *Handlers) ListReconcileBatches(w http.ResponseWriter, r *http.Request) {
-	filter := reconciliation.BatchFilter{
-		Limit: 100,
+// UploadFraudModel loads a gradient-boosted tree model from the request body
+func (h *Handlers) UploadFraudModel(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		role = fraud.ModelRoleChallenger
 	}
 
-	if status := r.URL.Query().Get("status"); status != "" {
-		filter.Status = models.BatchStatus(status)
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	model, err := fraud.ParseGBDTModel(name, data)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.fraud.InstallModel(model, role); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	batches := h.reconcile.GetBatches(filter)
-	respond(w, http.StatusOK, batches)
+	respond(w, http.StatusCreated, map[string]interface{}{
+		"name":     model.Name(),
+		"version":  model.Version(),
+		"format":   model.Format(),
+		"trees":    model.Trees(),
+		"features": model.Features(),
+		"role":     role,
+	})
 }
 
-// CreateReconcileBatch creates a reconciliation batch
-func (h *Handlers) CreateReconcileBatch(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Source string `json:"source"`
-		Target string `json:"target"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+// PromoteFraudModel makes a challenger model the champion
+func (h *Handlers) PromoteFraudModel(w http.ResponseWriter, r *http.Request) {
+	if err := h.fraud.ModelScorer().Promote(chi.URLParam(r, "name")); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
 		return
 	}
+	respond(w, http.StatusOK, h.fraud.ModelScorer().Status())
+}
 
-	batch := h.reconcile.CreateBatch(req.Source, req.Target)
-	respond(w, http.StatusCreated, batch)
+// DeleteFraudModel unloads a model
+func (h *Handlers) DeleteFraudModel(w http.ResponseWriter, r *http.Request) {
+	if err := h.fraud.ModelScorer().RemoveModel(chi.URLParam(r, "name")); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "removed"})
 }
 
-// GetReconcileBatch gets a reconciliation batch
-func (h *Handlers) GetReconcileBatch(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// ListFraudRules lists the rules authored as data
+func (h *Handlers) ListFraudRules(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.fraud.Rules().List())
+}
 
-	batch, ok := h.reconcile.GetBatch(id)
+// GetFraudRule gets the current version of a rule
+func (h *Handlers) GetFraudRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.fraud.Rules().Get(chi.URLParam(r, "id"))
 	if !ok {
-		respondError(w, http.StatusNotFound, "Batch not found")
+		respondError(w, http.StatusNotFound, "Rule not found")
 		return
 	}
+	respond(w, http.StatusOK, rule)
+}
 
-	respond(w, http.StatusOK, batch)
+// PutFraudRule creates a rule or stores a new version of it. The rule takes
+// effect for the next evaluation.
+func (h *Handlers) PutFraudRule(w http.ResponseWriter, r *http.Request) {
+	var rule models.ComplianceRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if id := chi.URLParam(r, "id"); id != "" {
+		rule.ID = id
+	}
+
+	stored, err := h.fraud.Rules().Put(rule, rule.UpdatedBy)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, stored)
 }
 
-// RunReconciliation runs reconciliation for a batch
-func (h *Handlers) RunReconciliation(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// DeleteFraudRule removes a rule from evaluation
+func (h *Handlers) DeleteFraudRule(w http.ResponseWriter, r *http.Request) {
+	if err := h.fraud.Rules().Delete(chi.URLParam(r, "id")); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListFraudRuleVersions lists every version of a rule
+func (h *Handlers) ListFraudRuleVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := h.fraud.Rules().Versions(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, versions)
+}
 
+// RollbackFraudRule restores an earlier version of a rule
+func (h *Handlers) RollbackFraudRule(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		SourceTransactions []*models.Transaction `json:"source_transactions"`
-		TargetTransactions []*models.Transaction `json:"target_transactions"`
+		Version int    `json:"version"`
+		User    string `json:"user"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	if err := h.reconcile.Reconcile(r.Context(), id, req.SourceTransactions, req.TargetTransactions); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	rule, err := h.fraud.Rules().Rollback(chi.URLParam(r, "id"), req.Version, req.User)
+	if errors.Is(err, fraud.ErrRuleNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	respond(w, http.StatusOK, rule)
+}
 
-	batch, _ := h.reconcile.GetBatch(id)
-	respond(w, http.StatusOK, batch)
+// GetFraudRuleHits lists what a dry-run rule would have flagged
+func (h *Handlers) GetFraudRuleHits(w http.ResponseWriter, r *http.Request) {
+	hits, err := h.fraud.Rules().Hits(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, hits)
 }
 
-// GetBatchExceptions gets exceptions for a batch
-func (h *Handlers) GetBatchExceptions(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// ListDevices lists the devices an account has used, or without an account
+// the devices shared by at least min_accounts accounts
+func (h *Handlers) ListDevices(w http.ResponseWriter, r *http.Request) {
+	if accountID := r.URL.Query().Get("account_id"); accountID != "" {
+		respond(w, http.StatusOK, h.fraud.Devices().AccountDevices(accountID))
+		return
+	}
 
-	exceptions := h.reconcile.GetExceptions(id)
-	respond(w, http.StatusOK, exceptions)
+	minAccounts := 2
+	if v := r.URL.Query().Get("min_accounts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondError(w, http.StatusBadRequest, "min_accounts must be a positive integer")
+			return
+		}
+		minAccounts = n
+	}
+	respond(w, http.StatusOK, h.fraud.Devices().SharedDevices(minAccounts))
 }
 
-// ResolveException resolves a reconciliation exception
-func (h *Handlers) ResolveException(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// GetDevice gets a device and the accounts that have used it
+func (h *Handlers) GetDevice(w http.ResponseWriter, r *http.Request) {
+	device, ok := h.fraud.Devices().GetDevice(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	respond(w, http.StatusOK, device)
+}
 
+// SetDeviceTrust marks a device trusted or untrusted for an account, or
+// returns it to automatic trust
+func (h *Handlers) SetDeviceTrust(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Resolution string `json:"resolution"`
-		WriteOff   bool   `json:"write_off"`
+		AccountID string            `json:"account_id"`
+		Trust     fraud.DeviceTrust `json:"trust"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-
-	if err := h.reconcile.ResolveException(id, req.Resolution, req.WriteOff); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	switch req.Trust {
+	case fraud.DeviceTrustAuto, fraud.DeviceTrustTrusted, fraud.DeviceTrustUntrusted:
+	default:
+		respondError(w, http.StatusBadRequest, "trust must be trusted, untrusted or empty")
 		return
 	}
 
-	respond(w, http.StatusOK, map[string]string{"status": "resolved"})
+	id := chi.URLParam(r, "id")
+	if err := h.fraud.Devices().SetTrust(id, req.AccountID, req.Trust); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	device, _ := h.fraud.Devices().GetDevice(id)
+	respond(w, http.StatusOK, device)
 }
 
-// GetReconcileStats gets reconciliation statistics
-func (h *Handlers) GetReconcileStats(w http.ResponseWriter, r *http.Request) {
-	stats := h.reconcile.GetStats()
-	respond(w, http.StatusOK, stats)
+// LookupGeoIP locates an IP address in the GeoIP database
+func (h *Handlers) LookupGeoIP(w http.ResponseWriter, r *http.Request) {
+	db := h.fraud.GeoIP()
+	if db == nil {
+		respondError(w, http.StatusNotFound, "No GeoIP database loaded")
+		return
+	}
+	geo, err := db.LookupString(chi.URLParam(r, "ip"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if geo == nil {
+		respondError(w, http.StatusNotFound, "IP address not found")
+		return
+	}
+	respond(w, http.StatusOK, geo)
 }
 
-// Report handlers
+// ReloadFraudRules re-reads the configured rule file
+func (h *Handlers) ReloadFraudRules(w http.ResponseWriter, r *http.Request) {
+	result, err := h.fraud.Rules().Reload()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, result)
+}
 
-// ListReports lists all reports
-func (h *Handlers) ListReports(w http.ResponseWriter, r *http.Request) {
-	filter := reporting.ReportFilter{
-		Limit: 100,
+// DryRunFraudRule reports what a rule would flag. Without transactions in
+// the body it replays stored transactions between start and end.
+func (h *Handlers) DryRunFraudRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rule         models.ComplianceRule `json:"rule"`
+		Transactions []fraud.ReplayItem    `json:"transactions,omitempty"`
+		AccountID    string                `json:"account_id,omitempty"`
+		Start        *time.Time            `json:"start,omitempty"`
+		End          *time.Time            `json:"end,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	if reportType := r.URL.Query().Get("type"); reportType != "" {
-		filter.Type = models.ReportType(reportType)
+	items := req.Transactions
+	if items == nil {
+		for _, txn := range h.transactions.GetTransactions(transactions.TransactionFilter{
+			AccountID: req.AccountID,
+			StartDate: req.Start,
+			EndDate:   req.End,
+		}) {
+			items = append(items, fraud.ReplayItem{Transaction: txn})
+		}
 	}
-	if status := r.URL.Query().Get("status"); status != "" {
-		filter.Status = models.ReportStatus(status)
+	for _, item := range items {
+		if item.Transaction == nil {
+			respondError(w, http.StatusBadRequest, "transaction is required")
+			return
+		}
 	}
 
-	reports := h.reports.GetReports(filter)
-	respond(w, http.StatusOK, reports)
+	report, err := h.fraud.Rules().DryRun(req.Rule, items)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, report)
 }
 
-// CreateReport creates a new report
-func (h *Handlers) CreateReport(w http.ResponseWriter, r *http.Request) {
+// RunFraudBacktest replays a window of transactions through a candidate
+// configuration and compares it with the live one against recorded alert
+// dispositions. Without transactions in the body it replays stored
+// transactions between start and end.
+func (h *Handlers) RunFraudBacktest(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Type      models.ReportType   `json:"type"`
-		Period    models.ReportPeriod `json:"period"`
-		StartDate time.Time           `json:"start_date"`
-		EndDate   time.Time           `json:"end_date"`
+		fraud.BacktestConfig
+		Transactions []fraud.ReplayItem `json:"transactions,omitempty"`
+		Labels       map[string]bool    `json:"labels,omitempty"`
+		AccountID    string             `json:"account_id,omitempty"`
+		Start        *time.Time         `json:"start,omitempty"`
+		End          *time.Time         `json:"end,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	report := h.reports.CreateReport(req.Type, req.Period, req.StartDate, req.EndDate)
+	items := req.Transactions
+	if items == nil {
+		for _, txn := range h.transactions.GetTransactions(transactions.TransactionFilter{
+			AccountID: req.AccountID,
+			StartDate: req.Start,
+			EndDate:   req.End,
+		}) {
+			items = append(items, fraud.ReplayItem{Transaction: txn})
+		}
+	}
+
+	report, err := h.fraud.Backtest(req.BacktestConfig, items, req.Labels)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	respond(w, http.StatusCreated, report)
 }
 
-// GetReport gets a report by ID
-func (h *Handlers) GetReport(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// ListFraudBacktests lists stored back-test reports, newest first
+func (h *Handlers) ListFraudBacktests(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.fraud.ListBacktests())
+}
 
-	report, ok := h.reports.GetReport(id)
+// GetFraudBacktest gets a back-test report
+func (h *Handlers) GetFraudBacktest(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.fraud.GetBacktest(chi.URLParam(r, "id"))
 	if !ok {
-		respondError(w, http.StatusNotFound, "Report not found")
+		respondError(w, http.StatusNotFound, "Backtest not found")
 		return
 	}
-
 	respond(w, http.StatusOK, report)
 }
 
-// GenerateReport generates a report
-func (h *Handlers) GenerateReport(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// AML handlers
 
-	report, ok := h.reports.GetReport(id)
-	if !ok {
-		respondError(w, http.StatusNotFound, "Report not found")
+// AnalyzeAMLTransaction runs the AML scenarios against a transaction
+func (h *Handlers) AnalyzeAMLTransaction(w http.ResponseWriter, r *http.Request) {
+	var txn models.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	// Get transactions for the report period
-	filter := transactions.TransactionFilter{
-		StartDate: &report.StartDate,
-		EndDate:   &report.EndDate,
+	clearConversion(&txn)
+	result, err := h.aml.AnalyzeTransaction(r.Context(), &txn)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
 	}
-	txns := h.transactions.GetTransactions(filter)
+	respond(w, http.StatusOK, result)
+}
 
-	var err error
-	switch report.Type {
-	case models.ReportTypeTransaction:
-		err = h.reports.GenerateTransactionReport(r.Context(), id, txns)
-	case models.ReportTypeCashFlow:
-		err = h.reports.GenerateCashFlowReport(r.Context(), id, txns)
-	case models.ReportTypeFraud:
-		alerts := h.fraud.GetAlerts(fraud.AlertFilter{})
-		err = h.reports.GenerateFraudReport(r.Context(), id, alerts, txns)
-	default:
-		err = h.reports.GenerateTransactionReport(r.Context(), id, txns)
+// ListAMLAlerts lists AML alerts
+func (h *Handlers) ListAMLAlerts(w http.ResponseWriter, r *http.Request) {
+	filter := aml.AlertFilter{
+		Limit: 100,
 	}
 
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter.Status = models.AlertStatus(status)
+	}
+	if alertType := r.URL.Query().Get("type"); alertType != "" {
+		filter.AlertType = aml.AMLAlertType(alertType)
+	}
+	if customer := r.URL.Query().Get("customer"); customer != "" {
+		filter.CustomerID = customer
 	}
 
-	report, _ = h.reports.GetReport(id)
-	respond(w, http.StatusOK, report)
+	respond(w, http.StatusOK, h.aml.ListAlerts(filter))
 }
 
-// DeleteReport deletes a report
-func (h *Handlers) DeleteReport(w http.ResponseWriter, r *http.Request) {
+// GetAMLAlert gets an AML alert by ID
+func (h *Handlers) GetAMLAlert(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	alert, ok := h.aml.GetAlert(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Alert not found")
+		return
+	}
+
+	respond(w, http.StatusOK, alert)
+}
+
+// GetAMLAlertGraph returns the transaction graph around an AML alert
+func (h *Handlers) GetAMLAlertGraph(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	view, err := h.aml.AlertSubgraph(id, intParam(r, "depth", 2))
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, view)
+}
+
+// GetAccountGraph returns the transaction graph around an account
+func (h *Handlers) GetAccountGraph(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	respond(w, http.StatusOK, h.aml.AccountSubgraph(id, intParam(r, "depth", 2)))
+}
+
+// TraceFunds finds the shortest money-flow path between two accounts
+func (h *Handlers) TraceFunds(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		respondError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	path, ok := h.aml.TraceFunds(from, to, intParam(r, "max_len", 6))
+	if !ok {
+		respondError(w, http.StatusNotFound, "No path between the accounts")
+		return
+	}
+	respond(w, http.StatusOK, path)
+}
+
+// TraceFlaggedAccounts lists the paths connecting flagged accounts
+func (h *Handlers) TraceFlaggedAccounts(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.TraceFlaggedAccounts(intParam(r, "max_len", 6)))
+}
+
+// FlagAccount marks an account as suspicious in the transaction graph
+func (h *Handlers) FlagAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Account string `json:"account"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Account == "" {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	h.aml.FlagAccount(req.Account, req.Reason)
+	respond(w, http.StatusOK, map[string]string{"status": "flagged"})
+}
+
+// ListGraphCommunities lists groups of closely connected accounts
+func (h *Handlers) ListGraphCommunities(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.GraphCommunities(intParam(r, "min_size", 3)))
+}
+
+// ResolveAMLAlert resolves an AML alert, optionally opening a case for it
+func (h *Handlers) ResolveAMLAlert(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Resolution string `json:"resolution"`
+		CreateCase bool   `json:"create_case"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.ResolveAlert(id, req.Resolution, req.CreateCase); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	alert, _ := h.aml.GetAlert(id)
+	respond(w, http.StatusOK, alert)
+}
+
+// ListSARs lists Suspicious Activity Reports
+func (h *Handlers) ListSARs(w http.ResponseWriter, r *http.Request) {
+	filter := aml.SARFilter{Status: aml.SARStatus(r.URL.Query().Get("status"))}
+	respond(w, http.StatusOK, h.aml.ListSARs(filter))
+}
+
+// CreateSAR creates a draft Suspicious Activity Report
+func (h *Handlers) CreateSAR(w http.ResponseWriter, r *http.Request) {
+	var sar aml.SuspiciousActivityReport
+	if err := json.NewDecoder(r.Body).Decode(&sar); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.aml.CreateSAR(&sar); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, sar)
+}
+
+// GetSAR gets a Suspicious Activity Report by ID
+func (h *Handlers) GetSAR(w http.ResponseWriter, r *http.Request) {
+	sar, ok := h.aml.GetSAR(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "SAR not found")
+		return
+	}
+	respond(w, http.StatusOK, sar)
+}
+
+// SubmitSAR submits a draft SAR for review
+func (h *Handlers) SubmitSAR(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.aml.SubmitSAR(id); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	sar, _ := h.aml.GetSAR(id)
+	respond(w, http.StatusOK, sar)
+}
+
+// ApproveSAR approves a SAR pending review
+func (h *Handlers) ApproveSAR(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Approver string `json:"approver"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approver == "" {
+		respondError(w, http.StatusBadRequest, "approver is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.ApproveSAR(id, req.Approver); err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	sar, _ := h.aml.GetSAR(id)
+	respond(w, http.StatusOK, sar)
+}
+
+// FileSAR files an approved SAR with FinCEN
+func (h *Handlers) FileSAR(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.aml.FileSAR(r.Context(), id); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	sar, _ := h.aml.GetSAR(id)
+	respond(w, http.StatusOK, sar)
+}
+
+// ListCTRs lists Currency Transaction Reports
+func (h *Handlers) ListCTRs(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.ListCTRs(aml.CTRStatus(r.URL.Query().Get("status"))))
+}
+
+// CreateCTR creates a pending Currency Transaction Report
+func (h *Handlers) CreateCTR(w http.ResponseWriter, r *http.Request) {
+	var ctr aml.CurrencyTransactionReport
+	if err := json.NewDecoder(r.Body).Decode(&ctr); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.aml.CreateCTR(&ctr); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, ctr)
+}
+
+// GetCTR gets a Currency Transaction Report by ID
+func (h *Handlers) GetCTR(w http.ResponseWriter, r *http.Request) {
+	ctr, ok := h.aml.GetCTR(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "CTR not found")
+		return
+	}
+	respond(w, http.StatusOK, ctr)
+}
+
+// FileCTR files a pending CTR with FinCEN
+func (h *Handlers) FileCTR(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.aml.FileCTR(r.Context(), id); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	ctr, _ := h.aml.GetCTR(id)
+	respond(w, http.StatusOK, ctr)
+}
+
+// ListBSABatches lists generated BSA E-Filing batches
+func (h *Handlers) ListBSABatches(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.ListBSABatches())
+}
+
+// GetBSABatch gets a BSA E-Filing batch and its acknowledgement
+func (h *Handlers) GetBSABatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := h.aml.GetBSABatch(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "BSA batch not found")
+		return
+	}
+	respond(w, http.StatusOK, batch)
+}
+
+// DownloadBSABatch downloads the XML of a BSA E-Filing batch for upload to
+// FinCEN
+func (h *Handlers) DownloadBSABatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := h.aml.GetBSABatch(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "BSA batch not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", batch.FileName))
+	w.Header().Set("Content-Length", strconv.Itoa(len(batch.Content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(batch.Content)
+}
+
+// ApplyBSAAcknowledgement applies FinCEN's acknowledgement XML, sent as the
+// raw body, to a batch
+func (h *Handlers) ApplyBSAAcknowledgement(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.aml.GetBSABatch(id); !ok {
+		respondError(w, http.StatusNotFound, "BSA batch not found")
+		return
+	}
+
+	batch, err := h.aml.ApplyBSAAcknowledgement(id, r.Body)
+	if errors.Is(err, aml.ErrBSABatchAcknowledged) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, batch)
+}
+
+// ListAMLCases lists AML investigation cases
+func (h *Handlers) ListAMLCases(w http.ResponseWriter, r *http.Request) {
+	filter := aml.CaseFilter{
+		Status:     aml.CaseStatus(r.URL.Query().Get("status")),
+		AssignedTo: r.URL.Query().Get("assigned_to"),
+		CustomerID: r.URL.Query().Get("customer"),
+		Priority:   r.URL.Query().Get("priority"),
+		Queue:      r.URL.Query().Get("queue"),
+	}
+	if v := r.URL.Query().Get("sla_breached"); v != "" {
+		breached, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid sla_breached")
+			return
+		}
+		filter.SLABreached = &breached
+	}
+	respond(w, http.StatusOK, h.aml.ListCases(filter))
+}
+
+// CreateAMLCase opens an AML investigation case
+func (h *Handlers) CreateAMLCase(w http.ResponseWriter, r *http.Request) {
+	var c aml.AMLCase
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.aml.CreateCase(&c); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, c)
+}
+
+// GetAMLCase gets an AML case by ID
+func (h *Handlers) GetAMLCase(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.aml.GetCase(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "Case not found")
+		return
+	}
+	respond(w, http.StatusOK, c)
+}
+
+// AssignAMLCase assigns a case to an investigator
+func (h *Handlers) AssignAMLCase(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Assignee string `json:"assignee"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Assignee == "" {
+		respondError(w, http.StatusBadRequest, "assignee is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.AssignCase(id, req.Assignee); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	c, _ := h.aml.GetCase(id)
+	respond(w, http.StatusOK, c)
+}
+
+// AddAMLCaseNote adds a note to a case timeline
+func (h *Handlers) AddAMLCaseNote(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Actor string `json:"actor"`
+		Note  string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Note == "" {
+		respondError(w, http.StatusBadRequest, "note is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.AddCaseNote(id, req.Actor, req.Note); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	c, _ := h.aml.GetCase(id)
+	respond(w, http.StatusOK, c)
+}
+
+// CloseAMLCase closes a case
+func (h *Handlers) CloseAMLCase(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Actor       string `json:"actor"`
+		Reason      string `json:"reason"`
+		SARRequired bool   `json:"sar_required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+		respondError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.CloseCase(id, req.Actor, req.Reason, req.SARRequired); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	c, _ := h.aml.GetCase(id)
+	respond(w, http.StatusOK, c)
+}
+
+// AutoAssignAMLCase assigns an unassigned case to the least loaded
+// investigator of its queue
+func (h *Handlers) AutoAssignAMLCase(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	assigned, err := h.aml.AutoAssignCase(id)
+	if err != nil {
+		if _, ok := h.aml.GetCase(id); !ok {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if !assigned {
+		respondError(w, http.StatusConflict, "No investigator in the queue has capacity")
+		return
+	}
+	c, _ := h.aml.GetCase(id)
+	respond(w, http.StatusOK, c)
+}
+
+// ListAMLCaseQueues summarizes the case queues by risk level
+func (h *Handlers) ListAMLCaseQueues(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.CaseQueues())
+}
+
+// GetAMLCaseQueue lists the unassigned cases of a queue, due soonest first
+func (h *Handlers) GetAMLCaseQueue(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.QueueCases(chi.URLParam(r, "name")))
+}
+
+// ListInvestigatorWorkloads lists the open caseload of each investigator
+func (h *Handlers) ListInvestigatorWorkloads(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.aml.Workloads())
+}
+
+// maxEvidenceSize limits uploaded case evidence
+const maxEvidenceSize = 32 << 20
+
+// defaultMaxStatementSize limits imported bank statements when no limit is
+// configured
+const defaultMaxStatementSize = 32 << 20
+
+// AttachAMLCaseDocument attaches the request body to a case as a document.
+// The name, description and actor are query parameters.
+func (h *Handlers) AttachAMLCaseDocument(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEvidenceSize))
+	if err != nil {
+		respondError(w, http.StatusRequestEntityTooLarge, "Evidence exceeds 32 MiB")
+		return
+	}
+	if len(data) == 0 {
+		respondError(w, http.StatusBadRequest, "Evidence content is required")
+		return
+	}
+
+	q := r.URL.Query()
+	evidence, err := h.aml.AttachEvidence(chi.URLParam(r, "id"), &aml.CaseEvidence{
+		Type:        aml.EvidenceDocument,
+		Name:        q.Get("name"),
+		ContentType: r.Header.Get("Content-Type"),
+		Description: q.Get("description"),
+		AddedBy:     firstNonEmpty(r.Header.Get("X-Actor-ID"), q.Get("actor")),
+	}, data)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, evidence)
+}
+
+// LinkAMLCaseTransaction attaches a transaction to a case as evidence
+func (h *Handlers) LinkAMLCaseTransaction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TransactionID string `json:"transaction_id"`
+		Actor         string `json:"actor"`
+		Note          string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TransactionID == "" {
+		respondError(w, http.StatusBadRequest, "transaction_id is required")
+		return
+	}
+
+	txn, ok := h.transactions.GetTransaction(req.TransactionID)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Transaction not found")
+		return
+	}
+	evidence, err := h.aml.LinkTransaction(chi.URLParam(r, "id"), req.Actor, txn, req.Note)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, evidence)
+}
+
+// AttachAMLCaseGraph attaches a snapshot of the transaction graph around an
+// account, or the case's customer, to a case
+func (h *Handlers) AttachAMLCaseGraph(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Account string `json:"account"`
+		Depth   int    `json:"depth"`
+		Actor   string `json:"actor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Depth <= 0 {
+		req.Depth = 2
+	}
+
+	id := chi.URLParam(r, "id")
+	evidence, err := h.aml.AttachGraphSnapshot(id, req.Actor, req.Account, req.Depth)
+	if err != nil {
+		if _, ok := h.aml.GetCase(id); !ok {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, evidence)
+}
+
+// GetAMLCaseEvidence downloads evidence attached to a case
+func (h *Handlers) GetAMLCaseEvidence(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.aml.GetCase(id); !ok {
+		respondError(w, http.StatusNotFound, "Case not found")
+		return
+	}
+
+	evidence, data, err := h.aml.GetEvidence(id, chi.URLParam(r, "evidenceId"))
+	if err != nil {
+		if errors.Is(err, aml.ErrEvidenceNotFound) {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", evidence.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", evidence.Name))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Digest", "sha-256="+evidence.Digest[len("sha256:"):])
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// ExportAMLCase downloads a ZIP bundle of a case, its alerts, SAR,
+// evidence and a SAR narrative draft
+func (h *Handlers) ExportAMLCase(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, ok := h.aml.GetCase(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Case not found")
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := h.aml.ExportCase(id, &buf); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "case-"+c.CaseNumber+".zip"))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// GetCustomerRiskProfile gets a customer's AML risk profile
+func (h *Handlers) GetCustomerRiskProfile(w http.ResponseWriter, r *http.Request) {
+	profile, ok := h.aml.GetCustomerProfile(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "Customer profile not found")
+		return
+	}
+	respond(w, http.StatusOK, profile)
+}
+
+// OverrideCustomerRisk sets a customer's risk level and factors
+func (h *Handlers) OverrideCustomerRisk(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RiskLevel   aml.RiskLevel    `json:"risk_level"`
+		RiskFactors []aml.RiskFactor `json:"risk_factors"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	switch req.RiskLevel {
+	case aml.RiskLevelLow, aml.RiskLevelMedium, aml.RiskLevelHigh, aml.RiskLevelCritical:
+	default:
+		respondError(w, http.StatusBadRequest, "risk_level must be low, medium, high or critical")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.aml.UpdateCustomerRisk(id, req.RiskLevel, req.RiskFactors); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	profile, _ := h.aml.GetCustomerProfile(id)
+	respond(w, http.StatusOK, profile)
+}
+
+// Reconciliation handlers
+
+// ListReconcileBatches lists reconciliation batches
+func (h *Handlers) ListReconcileBatches(w http.ResponseWriter, r *http.Request) {
+	filter := reconciliation.BatchFilter{
+		Limit: 100,
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter.Status = models.BatchStatus(status)
+	}
+
+	batches := h.reconcile.GetBatches(filter)
+	respond(w, http.StatusOK, batches)
+}
+
+// CreateReconcileBatch creates a reconciliation batch
+func (h *Handlers) CreateReconcileBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	batch := h.reconcile.CreateBatch(req.Source, req.Target)
+	respond(w, http.StatusCreated, batch)
+}
+
+// GetReconcileBatch gets a reconciliation batch
+func (h *Handlers) GetReconcileBatch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	batch, ok := h.reconcile.GetBatch(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Batch not found")
+		return
+	}
+
+	respond(w, http.StatusOK, batch)
+}
+
+// RunReconciliation runs reconciliation for a batch
+func (h *Handlers) RunReconciliation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		SourceTransactions []*models.Transaction `json:"source_transactions"`
+		TargetTransactions []*models.Transaction `json:"target_transactions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.reconcile.Reconcile(r.Context(), id, req.SourceTransactions, req.TargetTransactions); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	batch, _ := h.reconcile.GetBatch(id)
+	respond(w, http.StatusOK, batch)
+}
+
+// ImportStatement reconciles an uploaded bank statement against the ledger.
+// The router bounds the body by the configured statement size.
+func (h *Handlers) ImportStatement(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = reconciliation.DetectStatementFormat(data)
+	}
+	if format == "" {
+		respondError(w, http.StatusBadRequest, "Unrecognized statement format; set format")
+		return
+	}
+	statements, err := reconciliation.ParseStatements(format, bytes.NewReader(data))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The ledger may know the account under a different ID than the bank
+	accountID := r.URL.Query().Get("account_id")
+	lookup := func(account string, start, end time.Time) []*models.Transaction {
+		if accountID != "" {
+			account = accountID
+		}
+		return h.transactions.GetTransactions(transactions.TransactionFilter{
+			AccountID: account,
+			StartDate: &start,
+			EndDate:   &end,
+		})
+	}
+
+	batch, err := h.reconcile.ReconcileStatements(r.Context(), statements, "ledger", lookup)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	summaries := make([]map[string]interface{}, 0, len(statements))
+	var warnings []string
+	for _, s := range statements {
+		summaries = append(summaries, map[string]interface{}{
+			"format":          s.Format,
+			"id":              s.ID,
+			"account":         s.Account,
+			"currency":        s.Currency,
+			"opening_balance": s.OpeningBalance,
+			"closing_balance": s.ClosingBalance,
+			"start_date":      s.StartDate,
+			"end_date":        s.EndDate,
+			"transactions":    len(s.Transactions),
+		})
+		if err := s.Verify(); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	respond(w, http.StatusCreated, map[string]interface{}{
+		"batch":      batch,
+		"statements": summaries,
+		"warnings":   warnings,
+	})
+}
+
+// GetBatchExceptions gets exceptions for a batch
+func (h *Handlers) GetBatchExceptions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	exceptions := h.reconcile.GetExceptions(id)
+	respond(w, http.StatusOK, exceptions)
+}
+
+// GetBatchGroups gets the group matches of a batch
+func (h *Handlers) GetBatchGroups(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, ok := h.reconcile.GetBatch(id); !ok {
+		respondError(w, http.StatusNotFound, "Batch not found")
+		return
+	}
+	respond(w, http.StatusOK, h.reconcile.GetGroupMatches(id))
+}
+
+// ResolveException resolves a reconciliation exception. A write-off is
+// requested for approval by the actor instead.
+func (h *Handlers) ResolveException(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Resolution string `json:"resolution"`
+		WriteOff   bool   `json:"write_off"`
+		Actor      string `json:"actor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.WriteOff {
+		actor := firstNonEmpty(req.Actor, r.Header.Get("X-Actor-ID"))
+		if err := h.reconcile.RequestWriteOff(id, req.Resolution, actor); err != nil {
+			respondError(w, approvalErrorStatus(err), err.Error())
+			return
+		}
+		exc, _ := h.reconcile.GetException(id)
+		approvalRequest, _ := h.approvals.OpenRequest(reconciliation.ApprovalActionWriteOff, id)
+		respond(w, http.StatusAccepted, map[string]interface{}{
+			"status":    exc.Status,
+			"exception": exc,
+			"approval":  approvalRequest,
+		})
+		return
+	}
+
+	if err := h.reconcile.ResolveException(id, req.Resolution, req.WriteOff); err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"status": "resolved"})
+}
+
+// ApproveWriteOff approves the requested write-off of an exception
+func (h *Handlers) ApproveWriteOff(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Approver string `json:"approver"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approver == "" {
+		respondError(w, http.StatusBadRequest, "approver is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.reconcile.ApproveWriteOff(id, req.Approver); err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	exc, _ := h.reconcile.GetException(id)
+	respond(w, http.StatusOK, exc)
+}
+
+// GetReconcileStats gets reconciliation statistics
+func (h *Handlers) GetReconcileStats(w http.ResponseWriter, r *http.Request) {
+	stats := h.reconcile.GetStats()
+	respond(w, http.StatusOK, stats)
+}
+
+// Report handlers
+
+// ListReports lists all reports
+func (h *Handlers) ListReports(w http.ResponseWriter, r *http.Request) {
+	filter := reporting.ReportFilter{
+		Limit: 100,
+	}
+
+	if reportType := r.URL.Query().Get("type"); reportType != "" {
+		filter.Type = models.ReportType(reportType)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter.Status = models.ReportStatus(status)
+	}
+
+	reports := h.reports.GetReports(filter)
+	respond(w, http.StatusOK, reports)
+}
+
+// CreateReport creates a new report
+func (h *Handlers) CreateReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type      models.ReportType   `json:"type"`
+		Period    models.ReportPeriod `json:"period"`
+		StartDate time.Time           `json:"start_date"`
+		EndDate   time.Time           `json:"end_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	report := h.reports.CreateReport(req.Type, req.Period, req.StartDate, req.EndDate)
+	respond(w, http.StatusCreated, report)
+}
+
+// GetReport gets a report by ID
+func (h *Handlers) GetReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	report, ok := h.reports.GetReport(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+
+	respond(w, http.StatusOK, report)
+}
+
+// GenerateReport generates a report
+func (h *Handlers) GenerateReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	report, ok := h.reports.GetReport(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+
+	// Get transactions for the report period
+	filter := transactions.TransactionFilter{
+		StartDate: &report.StartDate,
+		EndDate:   &report.EndDate,
+	}
+	txns := h.transactions.GetTransactions(filter)
+
+	var err error
+	switch report.Type {
+	case models.ReportTypeTransaction:
+		err = h.reports.GenerateTransactionReport(r.Context(), id, txns)
+	case models.ReportTypeCashFlow:
+		err = h.reports.GenerateCashFlowReport(r.Context(), id, txns)
+	case models.ReportTypeFraud:
+		alerts := h.fraud.GetAlerts(fraud.AlertFilter{})
+		err = h.reports.GenerateFraudReport(r.Context(), id, alerts, txns)
+	default:
+		err = h.reports.GenerateTransactionReport(r.Context(), id, txns)
+	}
+
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	report, _ = h.reports.GetReport(id)
+	respond(w, http.StatusOK, report)
+}
+
+// DownloadReport renders a generated report in the requested format
+func (h *Handlers) DownloadReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	report, ok := h.reports.GetReport(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+
+	format := reporting.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = h.reports.DefaultFormat()
+	}
+	exporter, err := h.reports.Exporter(format)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.reports.Export(&buf, id, exporter.Format()); err != nil {
+		if err == reporting.ErrReportNotReady {
+			respondError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", exporter.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reporting.FileName(report, exporter.Format())))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// DeleteReport deletes a report
+func (h *Handlers) DeleteReport(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	if err := h.reports.DeleteReport(id); err != nil {
@@ -473,23 +1720,764 @@ func (h *Handlers) DeleteReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListReportSchedules lists the scheduled reports
+func (h *Handlers) ListReportSchedules(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.scheduler.GetJobs())
+}
+
+// RunReportSchedule runs a scheduled report immediately
+func (h *Handlers) RunReportSchedule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	run, err := h.scheduler.RunNow(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respond(w, http.StatusAccepted, run)
+}
+
+// ListReportRuns lists the scheduled report run history
+func (h *Handlers) ListReportRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	jobID := r.URL.Query().Get("schedule")
+	if id := chi.URLParam(r, "id"); id != "" {
+		job, ok := h.scheduler.GetJob(id)
+		if !ok {
+			respondError(w, http.StatusNotFound, "Scheduled report not found")
+			return
+		}
+		jobID = job.ID
+	}
+
+	respond(w, http.StatusOK, h.scheduler.GetRuns(jobID, limit))
+}
+
+// GetReportRun gets a scheduled report run by ID
+func (h *Handlers) GetReportRun(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	run, ok := h.scheduler.GetRun(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Run not found")
+		return
+	}
+
+	respond(w, http.StatusOK, run)
+}
+
+// GetOverallStats gets overall system statistics
+func (h *Handlers) GetOverallStats(w http.ResponseWriter, r *http.Request) {
+	txnStats := h.transactions.GetStats()
+	fraudStats := h.fraud.GetStats()
+	reconStats := h.reconcile.GetStats()
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"transactions":   txnStats,
+		"fraud":          fraudStats,
+		"reconciliation": reconStats,
+	})
+}
+
+// FX handlers
+
+// GetFXRate gets the rate between two currencies on a date
+func (h *Handlers) GetFXRate(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = h.fx.BaseCurrency()
+	}
+	if from == "" {
+		respondError(w, http.StatusBadRequest, "from is required")
+		return
+	}
+
+	date, err := parseTimeParam(r, "date", time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rate, err := h.fx.GetRate(from, to, date)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, rate)
+}
+
+// ListFXRates lists the stored rates for a currency pair
+func (h *Handlers) ListFXRates(w http.ResponseWriter, r *http.Request) {
+	base := r.URL.Query().Get("base")
+	quote := r.URL.Query().Get("quote")
+	if base == "" || quote == "" {
+		respondError(w, http.StatusBadRequest, "base and quote are required")
+		return
+	}
+
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, err := parseTimeParam(r, "from", to.AddDate(0, 0, -30))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, h.fx.GetRates(base, quote, from, to))
+}
+
+// ConvertAmount converts an amount between currencies
+func (h *Handlers) ConvertAmount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount decimal.Decimal `json:"amount"`
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Date   *time.Time      `json:"date,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.To == "" {
+		req.To = h.fx.BaseCurrency()
+	}
+	date := time.Now()
+	if req.Date != nil {
+		date = *req.Date
+	}
+
+	converted, rate, err := h.fx.Convert(req.Amount, req.From, req.To, date)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"amount":           req.Amount,
+		"converted_amount": converted,
+		"rate":             rate,
+	})
+}
+
+// ImportFXRates stores rates supplied in the request body
+func (h *Handlers) ImportFXRates(w http.ResponseWriter, r *http.Request) {
+	var rates []fx.Rate
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	for i := range rates {
+		rates[i].Source = "api"
+	}
+	h.fx.AddRates(rates)
+
+	respond(w, http.StatusOK, map[string]int{"imported": len(rates)})
+}
+
+// RefreshFXRates reloads rates from the configured providers
+func (h *Handlers) RefreshFXRates(w http.ResponseWriter, r *http.Request) {
+	if err := h.fx.Refresh(r.Context()); err != nil {
+		respondError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"status": "refreshed"})
+}
+
+// Regulatory report handlers
+
+// ListRegulatoryReports lists regulatory reports
+func (h *Handlers) ListRegulatoryReports(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := regulatory.ReportFilter{
+		Type:   regulatory.ReportType(q.Get("type")),
+		Status: regulatory.ReportStatus(q.Get("status")),
+	}
+	if v := q.Get("year"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid year")
+			return
+		}
+		filter.Year = year
+	}
+	respond(w, http.StatusOK, h.regulatory.ListReports(filter))
+}
+
+// CreateRegulatoryReport creates a draft regulatory report from its template
+func (h *Handlers) CreateRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type       regulatory.ReportType    `json:"type"`
+		Period     *regulatory.ReportPeriod `json:"period"`
+		PreparedBy string                   `json:"prepared_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Type == "" || req.Period == nil {
+		respondError(w, http.StatusBadRequest, "type and period are required")
+		return
+	}
+
+	report, err := h.regulatory.CreateReport(req.Type, req.Period, firstNonEmpty(req.PreparedBy, r.Header.Get("X-Actor-ID")))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respond(w, http.StatusCreated, report)
+}
+
+// GetRegulatoryReport gets a regulatory report by ID
+func (h *Handlers) GetRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.regulatory.GetReport(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	respond(w, http.StatusOK, report)
+}
+
+// UpdateRegulatoryReportItem sets the amount of a line item in a draft
+// report
+func (h *Handlers) UpdateRegulatoryReportItem(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount decimal.Decimal `json:"amount"`
+		Notes  string          `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if _, ok := h.regulatory.GetReport(id); !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err := h.regulatory.UpdateReportItem(id, chi.URLParam(r, "section"), chi.URLParam(r, "item"), req.Amount, req.Notes); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	report, _ := h.regulatory.GetReport(id)
+	respond(w, http.StatusOK, report)
+}
+
+// ValidateRegulatoryReport runs a report's validation rules
+func (h *Handlers) ValidateRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	results, err := h.regulatory.ValidateReport(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respond(w, http.StatusOK, results)
+}
+
+// SubmitRegulatoryReport submits a validated report for approval
+func (h *Handlers) SubmitRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.regulatory.GetReport(id); !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err := h.regulatory.SubmitForReview(id); err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	report, _ := h.regulatory.GetReport(id)
+	respond(w, http.StatusOK, report)
+}
+
+// ApproveRegulatoryReport records an approval of a report in review
+func (h *Handlers) ApproveRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Approver string `json:"approver"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approver == "" {
+		respondError(w, http.StatusBadRequest, "approver is required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if _, ok := h.regulatory.GetReport(id); !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err := h.regulatory.ApproveReport(id, req.Approver); err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	report, _ := h.regulatory.GetReport(id)
+	respond(w, http.StatusOK, report)
+}
+
+// FileRegulatoryReport files an approved report
+func (h *Handlers) FileRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.regulatory.GetReport(id); !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err := h.regulatory.FileReport(r.Context(), id); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	report, _ := h.regulatory.GetReport(id)
+	respond(w, http.StatusOK, report)
+}
+
+// regulatoryContentTypes are the media types of regulatory export formats
+var regulatoryContentTypes = map[regulatory.ExportFormat]string{
+	regulatory.ExportFormatJSON:       "application/json",
+	regulatory.ExportFormatCSV:        "text/csv",
+	regulatory.ExportFormatXML:        "application/xml",
+	regulatory.ExportFormatXBRL:       "application/xml",
+	regulatory.ExportFormatInlineXBRL: "application/xhtml+xml",
+}
+
+// ExportRegulatoryReport exports a report (?format=json|csv|xml|xbrl|ixbrl,
+// xbrl by default) and downloads the file
+func (h *Handlers) ExportRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.regulatory.GetReport(id); !ok {
+		respondError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	format := regulatory.ExportFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = regulatory.ExportFormatXBRL
+	}
+	contentType, ok := regulatoryContentTypes[format]
+	if !ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported export format: %s", format))
+		return
+	}
+
+	path, err := h.regulatory.ExportReport(r.Context(), id, format)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
 }
 
-// GetOverallStats gets overall system statistics
-func (h *Handlers) GetOverallStats(w http.ResponseWriter, r *http.Request) {
-	txnStats := h.transactions.GetStats()
-	fraudStats := h.fraud.GetStats()
-	reconStats := h.reconcile.GetStats()
+// Helper functions
+
+// Approval handlers
+
+// ListApprovals lists approval requests
+func (h *Handlers) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	respond(w, http.StatusOK, h.approvals.List(approval.Filter{
+		Action:     q.Get("action"),
+		EntityID:   q.Get("entity_id"),
+		PreparedBy: q.Get("prepared_by"),
+		Status:     approval.Status(q.Get("status")),
+	}))
+}
+
+// GetApprovalInbox lists the open requests an actor may approve
+func (h *Handlers) GetApprovalInbox(w http.ResponseWriter, r *http.Request) {
+	actor := firstNonEmpty(r.URL.Query().Get("actor"), r.Header.Get("X-Actor-ID"))
+	if actor == "" {
+		respondError(w, http.StatusBadRequest, "actor is required")
+		return
+	}
+	respond(w, http.StatusOK, h.approvals.Inbox(actor))
+}
+
+// ListApprovalPolicies lists the approval policy of each action
+func (h *Handlers) ListApprovalPolicies(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.approvals.Policies())
+}
+
+// GetApproval gets an approval request by ID
+func (h *Handlers) GetApproval(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.approvals.Get(chi.URLParam(r, "id"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "Approval request not found")
+		return
+	}
+	respond(w, http.StatusOK, req)
+}
+
+// ApproveRequest approves an approval request
+func (h *Handlers) ApproveRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Approver string `json:"approver"`
+		Comment  string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approver == "" {
+		respondError(w, http.StatusBadRequest, "approver is required")
+		return
+	}
+
+	result, err := h.approvals.Approve(chi.URLParam(r, "id"), req.Approver, req.Comment)
+	if err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, result)
+}
+
+// RejectRequest rejects an approval request
+func (h *Handlers) RejectRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Approver string `json:"approver"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approver == "" {
+		respondError(w, http.StatusBadRequest, "approver is required")
+		return
+	}
+	if req.Reason == "" {
+		respondError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	result, err := h.approvals.Reject(chi.URLParam(r, "id"), req.Approver, req.Reason)
+	if err != nil {
+		respondError(w, approvalErrorStatus(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, result)
+}
+
+// approvalErrorStatus maps approval and resolution errors to HTTP statuses
+func approvalErrorStatus(err error) int {
+	switch err {
+	case approval.ErrRequestNotFound, reconciliation.ErrExceptionNotFound:
+		return http.StatusNotFound
+	case approval.ErrSelfApproval, approval.ErrRoleRequired, approval.ErrAlreadyApproved:
+		return http.StatusForbidden
+	case approval.ErrApproverRequired, approval.ErrPreparerRequired:
+		return http.StatusBadRequest
+	}
+	return http.StatusConflict
+}
+
+// Audit handlers
+
+// ListAuditEntries lists audit entries, most recent last
+func (h *Handlers) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit = intParam(r, "limit", 1000)
+	respond(w, http.StatusOK, h.audit.Query(filter))
+}
+
+// VerifyAuditTrail recomputes the audit hash chain
+func (h *Handlers) VerifyAuditTrail(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, h.audit.Verify())
+}
+
+// ExportAuditTrail downloads audit entries as JSON lines or CSV for
+// examiners. The export manifest, including the chain verification, is
+// returned in the X-Audit-Manifest header.
+func (h *Handlers) ExportAuditTrail(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = audit.FormatJSONL
+	}
+
+	var buf bytes.Buffer
+	manifest, err := h.audit.Export(&buf, filter, format)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	header, _ := json.Marshal(manifest)
+
+	contentType := "application/x-ndjson"
+	if manifest.Format == audit.FormatCSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "audit-"+manifest.ExportedAt.Format("20060102T150405Z")+"."+manifest.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("X-Audit-Manifest", string(header))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
 
+// EnforceAuditRetention removes audit entries past the retention period
+func (h *Handlers) EnforceAuditRetention(w http.ResponseWriter, r *http.Request) {
+	removed, err := h.audit.EnforceRetention()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	respond(w, http.StatusOK, map[string]interface{}{
-		"transactions":   txnStats,
-		"fraud":          fraudStats,
-		"reconciliation": reconStats,
+		"removed": removed,
+		"chain":   h.audit.Verify(),
 	})
 }
 
-// Helper functions
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+		ActorID:    q.Get("actor"),
+		Action:     q.Get("action"),
+	}
+	if q.Get("from") != "" {
+		from, err := parseTimeParam(r, "from", time.Time{})
+		if err != nil {
+			return filter, err
+		}
+		filter.From = &from
+	}
+	if q.Get("to") != "" {
+		to, err := parseTimeParam(r, "to", time.Time{})
+		if err != nil {
+			return filter, err
+		}
+		filter.To = &to
+	}
+	return filter, nil
+}
+
+// auditSnapshot captures the current state of an audited entity
+func (h *Handlers) auditSnapshot(entityType, id string) interface{} {
+	var v interface{}
+	var ok bool
+	switch entityType {
+	case "transaction":
+		v, ok = h.transactions.GetTransaction(id)
+	case "account":
+		v, ok = h.transactions.GetAccount(id)
+	case "fraud_alert":
+		v, ok = h.fraud.GetAlert(id)
+	case "fraud_rule":
+		v, ok = h.fraud.Rules().Get(id)
+	case "device":
+		v, ok = h.fraud.Devices().GetDevice(id)
+	case "aml_alert":
+		v, ok = h.aml.GetAlert(id)
+	case "sar":
+		v, ok = h.aml.GetSAR(id)
+	case "ctr":
+		v, ok = h.aml.GetCTR(id)
+	case "bsa_batch":
+		v, ok = h.aml.GetBSABatch(id)
+	case "aml_case":
+		v, ok = h.aml.GetCase(id)
+	case "customer_risk":
+		v, ok = h.aml.GetCustomerProfile(id)
+	case "reconcile_batch":
+		v, ok = h.reconcile.GetBatch(id)
+	case "reconcile_exception":
+		v, ok = h.reconcile.GetException(id)
+	case "report":
+		v, ok = h.reports.GetReport(id)
+	case "regulatory_report":
+		v, ok = h.regulatory.GetReport(id)
+	case "report_schedule":
+		v, ok = h.scheduler.GetJob(id)
+	case "approval_request":
+		v, ok = h.approvals.Get(id)
+	}
+	if !ok {
+		return nil
+	}
+	return audit.Snapshot(v)
+}
+
+// auditRecorder holds back the status and body of a response until the
+// call has been recorded in the audit trail
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+// flush sends the held response
+func (rec *auditRecorder) flush() {
+	rec.ResponseWriter.WriteHeader(rec.status)
+	rec.ResponseWriter.Write(rec.body.Bytes())
+}
+
+// entityLocks serializes audited calls on the same entity
+type entityLocks struct {
+	mu    sync.Mutex
+	locks map[string]*entityLock
+}
+
+type entityLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks an entity and returns its unlock function
+func (l *entityLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*entityLock)
+	}
+	el, ok := l.locks[key]
+	if !ok {
+		el = &entityLock{}
+		l.locks[key] = el
+	}
+	el.refs++
+	l.mu.Unlock()
+
+	el.Lock()
+	return func() {
+		el.Unlock()
+		l.mu.Lock()
+		if el.refs--; el.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// audited serves a state-changing call and records it in the audit trail
+// when it succeeds. The actor and reason come from the X-Actor-ID,
+// X-Actor-Type and X-Audit-Reason headers, falling back to the actor and
+// reason fields of a JSON body. The changes are the difference between the
+// entity before and after the call, with other audited calls on the entity
+// held off in between; for creations, the created entity is taken from the
+// response. The response is sent only once the entry is recorded, and the
+// call fails when it cannot be.
+func (h *Handlers) audited(entityType, action string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var fields map[string]interface{}
+	if len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '{' {
+		json.Unmarshal(body, &fields)
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		id = chi.URLParam(r, "name")
+	}
+	if id != "" {
+		unlock := h.auditLocks.lock(entityType + "/" + id)
+		defer unlock()
+	}
+	before := h.auditSnapshot(entityType, id)
+
+	rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+	if rec.status >= http.StatusBadRequest {
+		rec.flush()
+		return
+	}
+
+	var after interface{}
+	if id != "" {
+		after = h.auditSnapshot(entityType, id)
+	}
+	if before == nil && after == nil {
+		// Identify a created entity from the response
+		var created map[string]interface{}
+		if json.Unmarshal(rec.body.Bytes(), &created) == nil {
+			if createdID, ok := created["id"].(string); ok && createdID != "" {
+				id = createdID
+				after = created
+			}
+		}
+	}
+	if before == nil && after == nil && action != "delete" {
+		// Without a state to compare, record what was requested
+		after = audit.Snapshot(fields)
+	}
+
+	entry := &models.AuditLog{
+		EntityType: entityType,
+		EntityID:   id,
+		Action:     action,
+		ActorID:    firstNonEmpty(r.Header.Get("X-Actor-ID"), stringField(fields, "actor", "user", "approver", "updated_by")),
+		ActorType:  r.Header.Get("X-Actor-Type"),
+		Reason:     firstNonEmpty(r.Header.Get("X-Audit-Reason"), stringField(fields, "reason", "resolution", "note")),
+		Changes:    audit.Diff(before, after),
+		IPAddress:  r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		RequestID:  middleware.GetReqID(r.Context()),
+	}
+	if _, err := h.audit.Record(entry); err != nil {
+		log.Printf("audit: ALERT %s %s %s applied but not recorded: %v", entityType, id, action, err)
+		for _, header := range []string{"Content-Disposition", "Content-Length", "Location"} {
+			w.Header().Del(header)
+		}
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("%s %s was applied but could not be recorded in the audit trail", entityType, action))
+		return
+	}
+	rec.flush()
+}
+
+// stringField returns the first non-empty string among the named fields
+func stringField(fields map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if v, ok := fields[name].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clearConversion discards a base-currency conversion sent by the client;
+// only the FX converter records one
+func clearConversion(txn *models.Transaction) {
+	txn.BaseAmount = decimal.Zero
+	txn.BaseCurrency = ""
+	txn.FXRate = decimal.Zero
+	txn.FXRateDate = nil
+}
 
 func respond(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -501,6 +2489,47 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respond(w, status, map[string]string{"error": message})
 }
 
+// respondBodyError reports a failure to read the request body, a body over
+// its size limit included
+func respondBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	respondError(w, http.StatusBadRequest, "Invalid request body")
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or a YYYY-MM-DD date from the
+// query string. Date-only values for "to" and "as_of" cover the whole day.
+func parseTimeParam(r *http.Request, name string, fallback time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	if name == "to" || name == "as_of" {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// intParam parses a positive integer from the query string
+func intParam(r *http.Request, name string, fallback int) int {
+	if v := r.URL.Query().Get(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
 func generateID(prefix string) string {
 	return prefix + "-" + time.Now().Format("20060102150405")
 }
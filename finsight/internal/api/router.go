@@ -6,9 +6,14 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/savegress/finsight/internal/aml"
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/audit"
 	"github.com/savegress/finsight/internal/config"
 	"github.com/savegress/finsight/internal/fraud"
+	"github.com/savegress/finsight/internal/fx"
 	"github.com/savegress/finsight/internal/reconciliation"
+	"github.com/savegress/finsight/internal/regulatory"
 	"github.com/savegress/finsight/internal/reporting"
 	"github.com/savegress/finsight/internal/transactions"
 )
@@ -21,11 +26,11 @@ type Server struct {
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, txn *transactions.Engine, fraud *fraud.Detector, recon *reconciliation.Engine, report *reporting.Generator) *Server {
+func NewServer(cfg *config.Config, txn *transactions.Engine, fraud *fraud.Detector, recon *reconciliation.Engine, report *reporting.Generator, conv *fx.Converter, sched *reporting.Scheduler, amlEngine *aml.Engine, reg *regulatory.Engine, trail *audit.Trail, approvals *approval.Workflow) *Server {
 	s := &Server{
 		config:   cfg,
 		router:   chi.NewRouter(),
-		handlers: NewHandlers(txn, fraud, recon, report),
+		handlers: NewHandlers(txn, fraud, recon, report, conv, sched, amlEngine, reg, trail, approvals),
 	}
 
 	s.setupMiddleware()
@@ -44,8 +49,8 @@ func (s *Server) setupMiddleware() {
 	s.router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Actor-ID", "X-Actor-Type", "X-Audit-Reason"},
+		ExposedHeaders:   []string{"Link", "X-Audit-Manifest"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -58,48 +63,176 @@ func (s *Server) setupRoutes() {
 		// Transactions
 		r.Route("/transactions", func(r chi.Router) {
 			r.Get("/", s.handlers.ListTransactions)
-			r.Post("/", s.handlers.CreateTransaction)
+			r.With(s.audited("transaction", "create")).Post("/", s.handlers.CreateTransaction)
 			r.Get("/stats", s.handlers.GetTransactionStats)
 			r.Get("/{id}", s.handlers.GetTransaction)
-			r.Put("/{id}", s.handlers.UpdateTransaction)
+			r.With(s.audited("transaction", "update")).Put("/{id}", s.handlers.UpdateTransaction)
+			r.Get("/{id}/journals", s.handlers.GetTransactionJournals)
+		})
+
+		// Payment and card message ingestion
+		r.Route("/ingest", func(r chi.Router) {
+			r.With(s.audited("transaction", "ingest")).Post("/iso20022", s.handlers.IngestISO20022)
+			r.With(s.audited("transaction", "ingest")).Post("/iso8583", s.handlers.IngestISO8583)
 		})
 
 		// Accounts
 		r.Route("/accounts", func(r chi.Router) {
 			r.Get("/", s.handlers.ListAccounts)
-			r.Post("/", s.handlers.CreateAccount)
+			r.With(s.audited("account", "create")).Post("/", s.handlers.CreateAccount)
 			r.Get("/{id}", s.handlers.GetAccount)
 			r.Get("/{id}/transactions", s.handlers.GetAccountTransactions)
 			r.Get("/{id}/balance", s.handlers.GetAccountBalance)
+			r.Get("/{id}/statement", s.handlers.GetAccountStatement)
+		})
+
+		// Ledger
+		r.Route("/ledger", func(r chi.Router) {
+			r.Get("/trial-balance", s.handlers.GetTrialBalance)
+		})
+
+		// FX
+		r.Route("/fx", func(r chi.Router) {
+			r.Get("/rate", s.handlers.GetFXRate)
+			r.Get("/rates", s.handlers.ListFXRates)
+			r.With(s.audited("fx_rate", "import")).Post("/rates", s.handlers.ImportFXRates)
+			r.With(s.audited("fx_rate", "refresh")).Post("/rates/refresh", s.handlers.RefreshFXRates)
+			r.Post("/convert", s.handlers.ConvertAmount)
 		})
 
 		// Fraud Detection
 		r.Route("/fraud", func(r chi.Router) {
 			r.Get("/alerts", s.handlers.ListFraudAlerts)
 			r.Get("/alerts/{id}", s.handlers.GetFraudAlert)
-			r.Post("/alerts/{id}/resolve", s.handlers.ResolveFraudAlert)
+			r.With(s.audited("fraud_alert", "resolve")).Post("/alerts/{id}/resolve", s.handlers.ResolveFraudAlert)
 			r.Post("/evaluate", s.handlers.EvaluateTransaction)
 			r.Get("/stats", s.handlers.GetFraudStats)
+			r.Get("/models", s.handlers.GetFraudModels)
+			r.With(s.audited("fraud_model", "upload")).Post("/models", s.handlers.UploadFraudModel)
+			r.With(s.audited("fraud_model", "promote")).Post("/models/{name}/promote", s.handlers.PromoteFraudModel)
+			r.With(s.audited("fraud_model", "delete")).Delete("/models/{name}", s.handlers.DeleteFraudModel)
+			r.Get("/rules", s.handlers.ListFraudRules)
+			r.With(s.audited("fraud_rule", "create")).Post("/rules", s.handlers.PutFraudRule)
+			r.With(s.audited("fraud_rule", "reload")).Post("/rules/reload", s.handlers.ReloadFraudRules)
+			r.Post("/rules/dry-run", s.handlers.DryRunFraudRule)
+			r.Get("/rules/{id}", s.handlers.GetFraudRule)
+			r.With(s.audited("fraud_rule", "update")).Put("/rules/{id}", s.handlers.PutFraudRule)
+			r.With(s.audited("fraud_rule", "delete")).Delete("/rules/{id}", s.handlers.DeleteFraudRule)
+			r.Get("/rules/{id}/versions", s.handlers.ListFraudRuleVersions)
+			r.With(s.audited("fraud_rule", "rollback")).Post("/rules/{id}/rollback", s.handlers.RollbackFraudRule)
+			r.Get("/rules/{id}/hits", s.handlers.GetFraudRuleHits)
+			r.Get("/devices", s.handlers.ListDevices)
+			r.Get("/devices/{id}", s.handlers.GetDevice)
+			r.With(s.audited("device", "trust")).Post("/devices/{id}/trust", s.handlers.SetDeviceTrust)
+			r.Get("/geoip/{ip}", s.handlers.LookupGeoIP)
+			r.Get("/backtests", s.handlers.ListFraudBacktests)
+			r.Post("/backtests", s.handlers.RunFraudBacktest)
+			r.Get("/backtests/{id}", s.handlers.GetFraudBacktest)
+		})
+
+		// AML
+		r.Route("/aml", func(r chi.Router) {
+			r.Post("/analyze", s.handlers.AnalyzeAMLTransaction)
+			r.Get("/alerts", s.handlers.ListAMLAlerts)
+			r.Get("/alerts/{id}", s.handlers.GetAMLAlert)
+			r.Get("/alerts/{id}/graph", s.handlers.GetAMLAlertGraph)
+			r.Get("/graph/accounts/{id}", s.handlers.GetAccountGraph)
+			r.Get("/graph/path", s.handlers.TraceFunds)
+			r.Get("/graph/flagged-paths", s.handlers.TraceFlaggedAccounts)
+			r.With(s.audited("account", "flag")).Post("/graph/flags", s.handlers.FlagAccount)
+			r.Get("/graph/communities", s.handlers.ListGraphCommunities)
+			r.With(s.audited("aml_alert", "resolve")).Post("/alerts/{id}/resolve", s.handlers.ResolveAMLAlert)
+			r.Get("/sars", s.handlers.ListSARs)
+			r.With(s.audited("sar", "create")).Post("/sars", s.handlers.CreateSAR)
+			r.Get("/sars/{id}", s.handlers.GetSAR)
+			r.With(s.audited("sar", "submit")).Post("/sars/{id}/submit", s.handlers.SubmitSAR)
+			r.With(s.audited("sar", "approve")).Post("/sars/{id}/approve", s.handlers.ApproveSAR)
+			r.With(s.audited("sar", "file")).Post("/sars/{id}/file", s.handlers.FileSAR)
+			r.Get("/ctrs", s.handlers.ListCTRs)
+			r.With(s.audited("ctr", "create")).Post("/ctrs", s.handlers.CreateCTR)
+			r.Get("/ctrs/{id}", s.handlers.GetCTR)
+			r.With(s.audited("ctr", "file")).Post("/ctrs/{id}/file", s.handlers.FileCTR)
+			r.Get("/bsa/batches", s.handlers.ListBSABatches)
+			r.Get("/bsa/batches/{id}", s.handlers.GetBSABatch)
+			r.Get("/bsa/batches/{id}/download", s.handlers.DownloadBSABatch)
+			r.With(s.audited("bsa_batch", "acknowledge")).Post("/bsa/batches/{id}/acknowledgement", s.handlers.ApplyBSAAcknowledgement)
+			r.Get("/cases", s.handlers.ListAMLCases)
+			r.With(s.audited("aml_case", "create")).Post("/cases", s.handlers.CreateAMLCase)
+			r.Get("/cases/{id}", s.handlers.GetAMLCase)
+			r.With(s.audited("aml_case", "assign")).Post("/cases/{id}/assign", s.handlers.AssignAMLCase)
+			r.With(s.audited("aml_case", "add_note")).Post("/cases/{id}/notes", s.handlers.AddAMLCaseNote)
+			r.With(s.audited("aml_case", "close")).Post("/cases/{id}/close", s.handlers.CloseAMLCase)
+			r.With(s.audited("aml_case", "auto_assign")).Post("/cases/{id}/auto-assign", s.handlers.AutoAssignAMLCase)
+			r.With(s.audited("aml_case", "attach_evidence")).Post("/cases/{id}/evidence", s.handlers.AttachAMLCaseDocument)
+			r.With(s.audited("aml_case", "link_transaction")).Post("/cases/{id}/evidence/transactions", s.handlers.LinkAMLCaseTransaction)
+			r.With(s.audited("aml_case", "attach_graph")).Post("/cases/{id}/evidence/graph", s.handlers.AttachAMLCaseGraph)
+			r.Get("/cases/{id}/evidence/{evidenceId}", s.handlers.GetAMLCaseEvidence)
+			r.Get("/cases/{id}/export", s.handlers.ExportAMLCase)
+			r.Get("/queues", s.handlers.ListAMLCaseQueues)
+			r.Get("/queues/{name}", s.handlers.GetAMLCaseQueue)
+			r.Get("/workloads", s.handlers.ListInvestigatorWorkloads)
+			r.Get("/customers/{id}", s.handlers.GetCustomerRiskProfile)
+			r.With(s.audited("customer_risk", "override")).Put("/customers/{id}/risk", s.handlers.OverrideCustomerRisk)
 		})
 
 		// Reconciliation
 		r.Route("/reconciliation", func(r chi.Router) {
 			r.Get("/batches", s.handlers.ListReconcileBatches)
-			r.Post("/batches", s.handlers.CreateReconcileBatch)
+			r.With(s.audited("reconcile_batch", "create")).Post("/batches", s.handlers.CreateReconcileBatch)
 			r.Get("/batches/{id}", s.handlers.GetReconcileBatch)
-			r.Post("/batches/{id}/run", s.handlers.RunReconciliation)
+			r.With(s.audited("reconcile_batch", "run")).Post("/batches/{id}/run", s.handlers.RunReconciliation)
+			r.With(middleware.RequestSize(s.maxStatementSize()), s.audited("reconcile_batch", "import_statement")).Post("/statements", s.handlers.ImportStatement)
 			r.Get("/batches/{id}/exceptions", s.handlers.GetBatchExceptions)
-			r.Post("/exceptions/{id}/resolve", s.handlers.ResolveException)
+			r.Get("/batches/{id}/groups", s.handlers.GetBatchGroups)
+			r.With(s.audited("reconcile_exception", "resolve")).Post("/exceptions/{id}/resolve", s.handlers.ResolveException)
+			r.With(s.audited("reconcile_exception", "approve_write_off")).Post("/exceptions/{id}/approve", s.handlers.ApproveWriteOff)
 			r.Get("/stats", s.handlers.GetReconcileStats)
 		})
 
 		// Reports
 		r.Route("/reports", func(r chi.Router) {
 			r.Get("/", s.handlers.ListReports)
-			r.Post("/", s.handlers.CreateReport)
+			r.With(s.audited("report", "create")).Post("/", s.handlers.CreateReport)
+			r.Get("/schedules", s.handlers.ListReportSchedules)
+			r.With(s.audited("report_schedule", "run")).Post("/schedules/{id}/run", s.handlers.RunReportSchedule)
+			r.Get("/schedules/{id}/runs", s.handlers.ListReportRuns)
+			r.Get("/runs", s.handlers.ListReportRuns)
+			r.Get("/runs/{id}", s.handlers.GetReportRun)
 			r.Get("/{id}", s.handlers.GetReport)
-			r.Post("/{id}/generate", s.handlers.GenerateReport)
-			r.Delete("/{id}", s.handlers.DeleteReport)
+			r.With(s.audited("report", "generate")).Post("/{id}/generate", s.handlers.GenerateReport)
+			r.Get("/{id}/download", s.handlers.DownloadReport)
+			r.With(s.audited("report", "delete")).Delete("/{id}", s.handlers.DeleteReport)
+		})
+
+		// Regulatory reports
+		r.Route("/regulatory/reports", func(r chi.Router) {
+			r.Get("/", s.handlers.ListRegulatoryReports)
+			r.With(s.audited("regulatory_report", "create")).Post("/", s.handlers.CreateRegulatoryReport)
+			r.Get("/{id}", s.handlers.GetRegulatoryReport)
+			r.With(s.audited("regulatory_report", "update_item")).Put("/{id}/sections/{section}/items/{item}", s.handlers.UpdateRegulatoryReportItem)
+			r.With(s.audited("regulatory_report", "validate")).Post("/{id}/validate", s.handlers.ValidateRegulatoryReport)
+			r.With(s.audited("regulatory_report", "submit")).Post("/{id}/submit", s.handlers.SubmitRegulatoryReport)
+			r.With(s.audited("regulatory_report", "approve")).Post("/{id}/approve", s.handlers.ApproveRegulatoryReport)
+			r.With(s.audited("regulatory_report", "file")).Post("/{id}/file", s.handlers.FileRegulatoryReport)
+			r.Get("/{id}/export", s.handlers.ExportRegulatoryReport)
+		})
+
+		// Approvals
+		r.Route("/approvals", func(r chi.Router) {
+			r.Get("/", s.handlers.ListApprovals)
+			r.Get("/inbox", s.handlers.GetApprovalInbox)
+			r.Get("/policies", s.handlers.ListApprovalPolicies)
+			r.Get("/{id}", s.handlers.GetApproval)
+			r.With(s.audited("approval_request", "approve")).Post("/{id}/approve", s.handlers.ApproveRequest)
+			r.With(s.audited("approval_request", "reject")).Post("/{id}/reject", s.handlers.RejectRequest)
+		})
+
+		// Audit trail
+		r.Route("/audit", func(r chi.Router) {
+			r.Get("/entries", s.handlers.ListAuditEntries)
+			r.Get("/verify", s.handlers.VerifyAuditTrail)
+			r.Get("/export", s.handlers.ExportAuditTrail)
+			r.Post("/retention", s.handlers.EnforceAuditRetention)
 		})
 
 		// Stats
@@ -107,6 +240,25 @@ func (s *Server) setupRoutes() {
 	})
 }
 
+// audited records successful calls of a state-changing route in the audit
+// trail
+func (s *Server) audited(entityType, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.handlers.audited(entityType, action, next, w, r)
+		})
+	}
+}
+
+// maxStatementSize is the configured limit of imported bank statements,
+// or defaultMaxStatementSize when none is set
+func (s *Server) maxStatementSize() int64 {
+	if s.config.Reconciliation.MaxStatementSize > 0 {
+		return s.config.Reconciliation.MaxStatementSize
+	}
+	return defaultMaxStatementSize
+}
+
 // Router returns the chi router
 func (s *Server) Router() http.Handler {
 	return s.router
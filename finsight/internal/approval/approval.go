@@ -0,0 +1,543 @@
+// Package approval implements maker-checker approval of sensitive actions.
+// An action prepared by one person, such as a SAR or a write-off, is opened
+// as a request that other people approve under the policy of the action:
+// the roles they must hold, how many of them must approve, and whether the
+// preparer may approve their own work. Requests left pending are escalated
+// to further roles.
+package approval
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+)
+
+// Status is the state of an approval request
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusEscalated Status = "escalated"
+	StatusApproved  Status = "approved"
+	StatusRejected  Status = "rejected"
+)
+
+// Request is an action awaiting approval
+type Request struct {
+	ID                string                 `json:"id"`
+	Action            string                 `json:"action"`
+	EntityID          string                 `json:"entity_id"`
+	Summary           string                 `json:"summary,omitempty"`
+	Details           map[string]interface{} `json:"details,omitempty"`
+	PreparedBy        string                 `json:"prepared_by"`
+	Status            Status                 `json:"status"`
+	RequiredApprovers int                    `json:"required_approvers"`
+	Roles             []string               `json:"roles,omitempty"` // roles that may approve; any when empty
+	Approvals         []Decision             `json:"approvals,omitempty"`
+	Rejection         *Decision              `json:"rejection,omitempty"`
+	EscalationLevel   int                    `json:"escalation_level,omitempty"`
+	EscalatedAt       *time.Time             `json:"escalated_at,omitempty"`
+	DueAt             *time.Time             `json:"due_at,omitempty"` // escalated when still open
+	CreatedAt         time.Time              `json:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at"`
+	CompletedAt       *time.Time             `json:"completed_at,omitempty"`
+}
+
+// Decision is an approval or rejection of a request
+type Decision struct {
+	Actor   string    `json:"actor"`
+	Roles   []string  `json:"roles,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Open reports whether the request still awaits decisions
+func (r *Request) Open() bool {
+	return r.Status == StatusPending || r.Status == StatusEscalated
+}
+
+// Approvers lists the actors who approved the request
+func (r *Request) Approvers() []string {
+	approvers := make([]string, len(r.Approvals))
+	for i, d := range r.Approvals {
+		approvers[i] = d.Actor
+	}
+	return approvers
+}
+
+// Handler applies the outcome of a request to the entity it is about
+type Handler func(req *Request) error
+
+type actionHandlers struct {
+	approved Handler
+	rejected Handler
+}
+
+// Filter selects approval requests
+type Filter struct {
+	Action     string
+	EntityID   string
+	PreparedBy string
+	Status     Status
+}
+
+// Workflow holds approval requests and the policies they are decided under
+type Workflow struct {
+	policies   map[string]config.ApprovalPolicy
+	roles      map[string][]string
+	interval   time.Duration
+	handlers   map[string]actionHandlers
+	requests   map[string]*Request
+	open       map[string]string // action and entity to open request
+	onEscalate func(req *Request)
+	seq        int
+	mu         sync.Mutex
+	running    bool
+	stopCh     chan struct{}
+	now        func() time.Time
+}
+
+// NewWorkflow creates a workflow with the configured policies and roles
+func NewWorkflow(cfg *config.ApprovalConfig) *Workflow {
+	w := &Workflow{
+		policies: make(map[string]config.ApprovalPolicy),
+		roles:    make(map[string][]string),
+		interval: cfg.CheckInterval,
+		handlers: make(map[string]actionHandlers),
+		requests: make(map[string]*Request),
+		open:     make(map[string]string),
+		stopCh:   make(chan struct{}),
+		now:      time.Now,
+	}
+	for action, policy := range cfg.Policies {
+		w.policies[action] = policy
+	}
+	for actor, roles := range cfg.Roles {
+		w.roles[actor] = append([]string(nil), roles...)
+	}
+	if w.interval <= 0 {
+		w.interval = time.Minute
+	}
+	return w
+}
+
+// Start escalates overdue requests periodically
+func (w *Workflow) Start(ctx context.Context) error {
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		return nil
+	}
+	w.running = true
+	w.mu.Unlock()
+
+	go w.escalationLoop(ctx)
+	return nil
+}
+
+// Stop stops escalation
+func (w *Workflow) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		close(w.stopCh)
+		w.running = false
+	}
+}
+
+func (w *Workflow) escalationLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			for _, req := range w.Escalate() {
+				log.Printf("approval: %s request %s for %s escalated to level %d", req.Action, req.ID, req.EntityID, req.EscalationLevel)
+			}
+		}
+	}
+}
+
+// Register sets the handlers applying approved and rejected requests of an
+// action. The approved handler runs when the last required approval is
+// given; if it fails, the approval is not recorded.
+func (w *Workflow) Register(action string, approved, rejected Handler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers[action] = actionHandlers{approved: approved, rejected: rejected}
+}
+
+// OnEscalate sets a function called with each escalated request
+func (w *Workflow) OnEscalate(fn func(req *Request)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onEscalate = fn
+}
+
+// Policy returns the policy of an action. Actions without a configured
+// policy need one approver other than the preparer.
+func (w *Workflow) Policy(action string) config.ApprovalPolicy {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.policy(action)
+}
+
+func (w *Workflow) policy(action string) config.ApprovalPolicy {
+	policy := w.policies[action]
+	if policy.Approvers < 1 {
+		policy.Approvers = 1
+	}
+	return policy
+}
+
+// Policies returns the configured policies by action
+func (w *Workflow) Policies() map[string]config.ApprovalPolicy {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	policies := make(map[string]config.ApprovalPolicy, len(w.policies))
+	for action := range w.policies {
+		policies[action] = w.policy(action)
+	}
+	return policies
+}
+
+// SetRoles sets the roles an actor holds
+func (w *Workflow) SetRoles(actor string, roles []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(roles) == 0 {
+		delete(w.roles, actor)
+		return
+	}
+	w.roles[actor] = append([]string(nil), roles...)
+}
+
+// Roles returns the roles an actor holds
+func (w *Workflow) Roles(actor string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.roles[actor]...)
+}
+
+// Open opens a request to approve an action on an entity. An entity has at
+// most one open request per action; opening it again returns that request.
+func (w *Workflow) Open(action, entityID, preparedBy, summary string, details map[string]interface{}) (*Request, error) {
+	if preparedBy == "" {
+		return nil, ErrPreparerRequired
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if id, ok := w.open[openKey(action, entityID)]; ok {
+		return copyRequest(w.requests[id]), nil
+	}
+
+	policy := w.policy(action)
+	now := w.now()
+	w.seq++
+	req := &Request{
+		ID:                fmt.Sprintf("apr-%d", w.seq),
+		Action:            action,
+		EntityID:          entityID,
+		Summary:           summary,
+		Details:           details,
+		PreparedBy:        preparedBy,
+		Status:            StatusPending,
+		RequiredApprovers: policy.Approvers,
+		Roles:             append([]string(nil), policy.Roles...),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if policy.EscalationTimeout > 0 {
+		due := now.Add(policy.EscalationTimeout)
+		req.DueAt = &due
+	}
+	w.requests[req.ID] = req
+	w.open[openKey(action, entityID)] = req.ID
+	return copyRequest(req), nil
+}
+
+// Get returns a request by ID
+func (w *Workflow) Get(id string) (*Request, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	req, ok := w.requests[id]
+	if !ok {
+		return nil, false
+	}
+	return copyRequest(req), true
+}
+
+// OpenRequest returns the open request of an action on an entity
+func (w *Workflow) OpenRequest(action, entityID string) (*Request, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id, ok := w.open[openKey(action, entityID)]
+	if !ok {
+		return nil, false
+	}
+	return copyRequest(w.requests[id]), true
+}
+
+// List returns the requests matching the filter, oldest first
+func (w *Workflow) List(filter Filter) []*Request {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var results []*Request
+	for _, req := range w.requests {
+		if filter.Action != "" && req.Action != filter.Action {
+			continue
+		}
+		if filter.EntityID != "" && req.EntityID != filter.EntityID {
+			continue
+		}
+		if filter.PreparedBy != "" && req.PreparedBy != filter.PreparedBy {
+			continue
+		}
+		if filter.Status != "" && req.Status != filter.Status {
+			continue
+		}
+		results = append(results, copyRequest(req))
+	}
+	sortRequests(results)
+	return results
+}
+
+// Inbox returns the open requests an actor may decide, oldest first
+func (w *Workflow) Inbox(actor string) []*Request {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var results []*Request
+	for _, req := range w.requests {
+		if req.Open() && w.eligible(req, actor) == nil {
+			results = append(results, copyRequest(req))
+		}
+	}
+	sortRequests(results)
+	return results
+}
+
+// Approve records an approval. When the request reaches the approvals its
+// policy requires, the action's approved handler is applied and the
+// request is approved.
+func (w *Workflow) Approve(id, approver, comment string) (*Request, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	req, ok := w.requests[id]
+	if !ok {
+		return nil, ErrRequestNotFound
+	}
+	return w.approve(req, approver, comment)
+}
+
+// ApproveEntity records an approval of the open request of an action on an
+// entity
+func (w *Workflow) ApproveEntity(action, entityID, approver, comment string) (*Request, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id, ok := w.open[openKey(action, entityID)]
+	if !ok {
+		return nil, ErrRequestNotFound
+	}
+	return w.approve(w.requests[id], approver, comment)
+}
+
+func (w *Workflow) approve(req *Request, approver, comment string) (*Request, error) {
+	if err := w.eligible(req, approver); err != nil {
+		return nil, err
+	}
+
+	now := w.now()
+	decision := Decision{Actor: approver, Roles: w.roles[approver], Comment: comment, At: now}
+	if len(req.Approvals)+1 >= req.RequiredApprovers {
+		// The handler sees the request as approved, including this approval
+		approved := copyRequest(req)
+		approved.Approvals = append(approved.Approvals, decision)
+		approved.Status = StatusApproved
+		approved.UpdatedAt = now
+		approved.CompletedAt = &now
+		if h := w.handlers[req.Action].approved; h != nil {
+			if err := h(copyRequest(approved)); err != nil {
+				return nil, err
+			}
+		}
+		*req = *approved
+		delete(w.open, openKey(req.Action, req.EntityID))
+		return copyRequest(req), nil
+	}
+
+	req.Approvals = append(req.Approvals, decision)
+	req.UpdatedAt = now
+	return copyRequest(req), nil
+}
+
+// Reject rejects a request on behalf of an actor who could approve it, and
+// applies the action's rejected handler
+func (w *Workflow) Reject(id, approver, reason string) (*Request, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	req, ok := w.requests[id]
+	if !ok {
+		return nil, ErrRequestNotFound
+	}
+	if err := w.eligible(req, approver); err != nil {
+		return nil, err
+	}
+
+	now := w.now()
+	rejected := copyRequest(req)
+	rejected.Status = StatusRejected
+	rejected.Rejection = &Decision{Actor: approver, Roles: w.roles[approver], Comment: reason, At: now}
+	rejected.UpdatedAt = now
+	rejected.CompletedAt = &now
+	if h := w.handlers[req.Action].rejected; h != nil {
+		if err := h(copyRequest(rejected)); err != nil {
+			return nil, err
+		}
+	}
+	*req = *rejected
+	delete(w.open, openKey(req.Action, req.EntityID))
+	return copyRequest(req), nil
+}
+
+// eligible checks that an actor may decide a request
+func (w *Workflow) eligible(req *Request, actor string) error {
+	if actor == "" {
+		return ErrApproverRequired
+	}
+	if !req.Open() {
+		return ErrRequestClosed
+	}
+	if actor == req.PreparedBy && !w.policy(req.Action).AllowSelfApproval {
+		return ErrSelfApproval
+	}
+	for _, d := range req.Approvals {
+		if d.Actor == actor {
+			return ErrAlreadyApproved
+		}
+	}
+	if len(req.Roles) == 0 {
+		return nil
+	}
+	for _, held := range w.roles[actor] {
+		for _, role := range req.Roles {
+			if held == role {
+				return nil
+			}
+		}
+	}
+	return ErrRoleRequired
+}
+
+// Escalate escalates the open requests past their due time. Holders of the
+// policy's escalation roles may then approve them, and the request is due
+// again after another escalation timeout.
+func (w *Workflow) Escalate() []*Request {
+	w.mu.Lock()
+	now := w.now()
+	var escalated []*Request
+	for _, req := range w.requests {
+		if !req.Open() || req.DueAt == nil || now.Before(*req.DueAt) {
+			continue
+		}
+		policy := w.policy(req.Action)
+		req.Status = StatusEscalated
+		req.EscalationLevel++
+		req.EscalatedAt = &now
+		req.UpdatedAt = now
+		due := now.Add(policy.EscalationTimeout)
+		req.DueAt = &due
+		if len(req.Roles) > 0 {
+			req.Roles = mergeRoles(req.Roles, policy.EscalationRoles)
+		}
+		escalated = append(escalated, copyRequest(req))
+	}
+	onEscalate := w.onEscalate
+	w.mu.Unlock()
+
+	sortRequests(escalated)
+	if onEscalate != nil {
+		for _, req := range escalated {
+			onEscalate(req)
+		}
+	}
+	return escalated
+}
+
+func mergeRoles(roles, extra []string) []string {
+	merged := append([]string(nil), roles...)
+	for _, role := range extra {
+		found := false
+		for _, r := range merged {
+			if r == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, role)
+		}
+	}
+	return merged
+}
+
+func openKey(action, entityID string) string {
+	return action + "/" + entityID
+}
+
+func sortRequests(requests []*Request) {
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].CreatedAt.Equal(requests[j].CreatedAt) {
+			return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+}
+
+func copyRequest(req *Request) *Request {
+	c := *req
+	c.Roles = append([]string(nil), req.Roles...)
+	c.Approvals = append([]Decision(nil), req.Approvals...)
+	if req.Rejection != nil {
+		rejection := *req.Rejection
+		c.Rejection = &rejection
+	}
+	return &c
+}
+
+// Errors
+var (
+	ErrRequestNotFound  = &Error{Code: "REQUEST_NOT_FOUND", Message: "Approval request not found"}
+	ErrRequestClosed    = &Error{Code: "REQUEST_CLOSED", Message: "Approval request is already decided"}
+	ErrPreparerRequired = &Error{Code: "PREPARER_REQUIRED", Message: "The preparer of an action awaiting approval is required"}
+	ErrApproverRequired = &Error{Code: "APPROVER_REQUIRED", Message: "Approver is required"}
+	ErrSelfApproval     = &Error{Code: "SELF_APPROVAL", Message: "The preparer cannot approve their own work"}
+	ErrAlreadyApproved  = &Error{Code: "ALREADY_APPROVED", Message: "Approver has already approved this request"}
+	ErrRoleRequired     = &Error{Code: "ROLE_REQUIRED", Message: "Approver does not hold a role allowed to approve this request"}
+)
+
+// Error represents an approval error
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
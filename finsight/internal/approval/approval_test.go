@@ -0,0 +1,190 @@
+package approval
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+)
+
+func newTestWorkflow() *Workflow {
+	return NewWorkflow(&config.ApprovalConfig{
+		Policies: map[string]config.ApprovalPolicy{
+			"write_off": {
+				Roles:             []string{"finance_manager"},
+				Approvers:         2,
+				EscalationTimeout: time.Hour,
+				EscalationRoles:   []string{"cfo"},
+			},
+			"self_service": {AllowSelfApproval: true},
+		},
+		Roles: map[string][]string{
+			"alice": {"finance_manager"},
+			"bob":   {"finance_manager"},
+			"carol": {"analyst"},
+			"dave":  {"cfo"},
+		},
+	})
+}
+
+func TestWorkflow_ApproveWithPolicy(t *testing.T) {
+	w := newTestWorkflow()
+	var applied *Request
+	w.Register("write_off", func(req *Request) error {
+		applied = req
+		return nil
+	}, nil)
+
+	req, err := w.Open("write_off", "exc-1", "alice", "Write off exc-1", nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if req.RequiredApprovers != 2 || req.Status != StatusPending || req.DueAt == nil {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if again, _ := w.Open("write_off", "exc-1", "alice", "", nil); again.ID != req.ID {
+		t.Error("expected the open request to be returned")
+	}
+
+	tests := []struct {
+		approver string
+		err      error
+	}{
+		{"alice", ErrSelfApproval},
+		{"carol", ErrRoleRequired},
+		{"", ErrApproverRequired},
+	}
+	for _, tt := range tests {
+		if _, err := w.Approve(req.ID, tt.approver, ""); err != tt.err {
+			t.Errorf("approver %q: expected %v, got %v", tt.approver, tt.err, err)
+		}
+	}
+
+	req, err = w.Approve(req.ID, "bob", "checked the statement")
+	if err != nil || req.Status != StatusPending || len(req.Approvals) != 1 {
+		t.Fatalf("expected one of two approvals, got %+v, %v", req, err)
+	}
+	if applied != nil {
+		t.Error("handler applied before the policy was satisfied")
+	}
+	if _, err := w.Approve(req.ID, "bob", ""); err != ErrAlreadyApproved {
+		t.Errorf("expected ErrAlreadyApproved, got %v", err)
+	}
+
+	w.SetRoles("erin", []string{"finance_manager"})
+	req, err = w.ApproveEntity("write_off", "exc-1", "erin", "")
+	if err != nil || req.Status != StatusApproved || req.CompletedAt == nil {
+		t.Fatalf("expected approved request, got %+v, %v", req, err)
+	}
+	if applied == nil || len(applied.Approvers()) != 2 || applied.Status != StatusApproved {
+		t.Errorf("unexpected request passed to the handler: %+v", applied)
+	}
+	if _, ok := w.OpenRequest("write_off", "exc-1"); ok {
+		t.Error("approved request should no longer be open")
+	}
+	if _, err := w.Approve(req.ID, "dave", ""); err != ErrRequestClosed {
+		t.Errorf("expected ErrRequestClosed, got %v", err)
+	}
+}
+
+func TestWorkflow_HandlerFailureKeepsRequestOpen(t *testing.T) {
+	w := NewWorkflow(&config.ApprovalConfig{})
+	failure := errors.New("entity changed")
+	w.Register("sar_approval", func(req *Request) error { return failure }, nil)
+
+	req, _ := w.Open("sar_approval", "sar-1", "alice", "", nil)
+	if _, err := w.Approve(req.ID, "bob", ""); err != failure {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	req, _ = w.Get(req.ID)
+	if req.Status != StatusPending || len(req.Approvals) != 0 {
+		t.Errorf("failed approval should not be recorded: %+v", req)
+	}
+}
+
+func TestWorkflow_Reject(t *testing.T) {
+	w := newTestWorkflow()
+	var rejected *Request
+	w.Register("write_off", nil, func(req *Request) error {
+		rejected = req
+		return nil
+	})
+
+	req, _ := w.Open("write_off", "exc-1", "alice", "", nil)
+	if _, err := w.Reject(req.ID, "carol", "no"); err != ErrRoleRequired {
+		t.Errorf("expected ErrRoleRequired, got %v", err)
+	}
+	req, err := w.Reject(req.ID, "bob", "amount is not a timing difference")
+	if err != nil || req.Status != StatusRejected || req.Rejection.Actor != "bob" {
+		t.Fatalf("unexpected rejection: %+v, %v", req, err)
+	}
+	if rejected == nil || rejected.Rejection.Comment != "amount is not a timing difference" {
+		t.Errorf("unexpected request passed to the handler: %+v", rejected)
+	}
+
+	// A new request can be opened once the previous one is decided
+	again, _ := w.Open("write_off", "exc-1", "alice", "", nil)
+	if again.ID == req.ID {
+		t.Error("expected a new request after rejection")
+	}
+}
+
+func TestWorkflow_SelfApprovalAllowedByPolicy(t *testing.T) {
+	w := newTestWorkflow()
+	req, _ := w.Open("self_service", "x-1", "carol", "", nil)
+	if req, err := w.Approve(req.ID, "carol", ""); err != nil || req.Status != StatusApproved {
+		t.Errorf("expected self-approval to be allowed, got %+v, %v", req, err)
+	}
+	if _, err := w.Open("self_service", "x-2", "", "", nil); err != ErrPreparerRequired {
+		t.Errorf("expected ErrPreparerRequired, got %v", err)
+	}
+}
+
+func TestWorkflow_InboxAndEscalation(t *testing.T) {
+	w := newTestWorkflow()
+	now := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	first, _ := w.Open("write_off", "exc-1", "alice", "", nil)
+	w.Open("write_off", "exc-2", "bob", "", nil)
+	w.Open("sar_approval", "sar-1", "carol", "", nil)
+
+	if inbox := w.Inbox("alice"); len(inbox) != 2 || inbox[0].EntityID != "exc-2" || inbox[1].EntityID != "sar-1" {
+		t.Errorf("unexpected inbox for alice: %+v", inbox)
+	}
+	if inbox := w.Inbox("dave"); len(inbox) != 1 || inbox[0].EntityID != "sar-1" {
+		t.Errorf("escalation role should not see requests before escalation: %+v", inbox)
+	}
+
+	var notified []string
+	w.OnEscalate(func(req *Request) { notified = append(notified, req.EntityID) })
+
+	now = now.Add(30 * time.Minute)
+	if escalated := w.Escalate(); len(escalated) != 0 {
+		t.Errorf("nothing should be escalated before the timeout: %+v", escalated)
+	}
+
+	now = now.Add(time.Hour)
+	escalated := w.Escalate()
+	if len(escalated) != 2 || len(notified) != 2 {
+		t.Fatalf("expected both write-offs escalated, got %+v", escalated)
+	}
+	req, _ := w.Get(first.ID)
+	if req.Status != StatusEscalated || req.EscalationLevel != 1 || !req.DueAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected escalated request: %+v", req)
+	}
+	if inbox := w.Inbox("dave"); len(inbox) != 3 {
+		t.Errorf("escalation role should see escalated requests: %+v", inbox)
+	}
+
+	now = now.Add(time.Hour)
+	w.Escalate()
+	if req, _ := w.Get(first.ID); req.EscalationLevel != 2 || len(req.Roles) != 2 {
+		t.Errorf("expected second escalation without duplicate roles, got %+v", req)
+	}
+
+	if pending := w.List(Filter{Status: StatusEscalated}); len(pending) != 2 {
+		t.Errorf("expected 2 escalated requests, got %d", len(pending))
+	}
+}
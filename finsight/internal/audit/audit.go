@@ -0,0 +1,454 @@
+// Package audit keeps a tamper-evident trail of state-changing calls. Each
+// entry records the actor, the reason and the fields that changed, and is
+// hash-chained to the entry before it, so editing, removing or reordering
+// entries breaks the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// GenesisHash is the previous hash of the first entry of a trail
+var GenesisHash = strings.Repeat("0", 64)
+
+// Actor types
+const (
+	ActorUser      = "user"
+	ActorSystem    = "system"
+	ActorAnonymous = "anonymous"
+)
+
+// Checkpoint anchors the chain after older entries were removed by
+// retention: the first retained entry follows Sequence and chains to Hash
+type Checkpoint struct {
+	Sequence int64     `json:"sequence"`
+	Hash     string    `json:"hash"`
+	PrunedAt time.Time `json:"pruned_at"`
+}
+
+// Trail is an append-only, hash-chained audit log, optionally persisted as
+// JSON lines
+type Trail struct {
+	retention time.Duration
+	path      string
+	file      *os.File
+	entries   []*models.AuditLog
+	anchor    Checkpoint
+	mu        sync.RWMutex
+	running   bool
+	stopCh    chan struct{}
+	now       func() time.Time
+}
+
+// fileLine is a line of the trail file: a checkpoint or an entry
+type fileLine struct {
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	*models.AuditLog
+}
+
+// NewTrail creates a trail, loading the entries already in the configured
+// file. A broken chain is not an error; Verify reports it.
+func NewTrail(cfg *config.ComplianceConfig) (*Trail, error) {
+	t := &Trail{
+		retention: time.Duration(cfg.AuditLogRetention) * 24 * time.Hour,
+		path:      cfg.AuditLogPath,
+		anchor:    Checkpoint{Hash: GenesisHash},
+		stopCh:    make(chan struct{}),
+		now:       time.Now,
+	}
+	if t.path == "" {
+		return t, nil
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	t.file = f
+	return t, nil
+}
+
+func (t *Trail) load() error {
+	f, err := os.Open(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line fileLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("audit: %s line %d: %w", t.path, n, err)
+		}
+		switch {
+		case line.Checkpoint != nil:
+			t.anchor = *line.Checkpoint
+		case line.AuditLog != nil:
+			t.entries = append(t.entries, line.AuditLog)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// Start enforces retention daily
+func (t *Trail) Start(ctx context.Context) error {
+	t.mu.Lock()
+	if t.running {
+		t.mu.Unlock()
+		return nil
+	}
+	t.running = true
+	t.mu.Unlock()
+
+	if t.retention > 0 {
+		go t.retentionLoop(ctx)
+	}
+	return nil
+}
+
+// Stop stops retention enforcement and closes the trail file
+func (t *Trail) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running {
+		close(t.stopCh)
+		t.running = false
+	}
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+func (t *Trail) retentionLoop(ctx context.Context) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if _, err := t.EnforceRetention(); err != nil {
+			log.Printf("audit: retention failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record appends an entry to the trail, assigning its ID, sequence,
+// timestamp and hashes. The stored entry is returned.
+func (t *Trail) Record(entry *models.AuditLog) (*models.AuditLog, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.append(entry)
+}
+
+func (t *Trail) append(entry *models.AuditLog) (*models.AuditLog, error) {
+	e := copyEntry(entry)
+	seq, prev := t.head()
+	e.Sequence = seq + 1
+	e.ID = fmt.Sprintf("audit-%d", e.Sequence)
+	if e.Timestamp.IsZero() {
+		e.Timestamp = t.now()
+	}
+	e.Timestamp = e.Timestamp.UTC()
+	if e.ActorID == "" {
+		e.ActorType = ActorAnonymous
+	} else if e.ActorType == "" {
+		e.ActorType = ActorUser
+	}
+	e.PrevHash = prev
+	e.Hash = ""
+	hash, err := hashEntry(e)
+	if err != nil {
+		return nil, err
+	}
+	e.Hash = hash
+
+	if t.file != nil {
+		line, err := json.Marshal(fileLine{AuditLog: e})
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		if _, err := t.file.Write(append(line, '\n')); err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		if err := t.file.Sync(); err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+	}
+	t.entries = append(t.entries, e)
+	return copyEntry(e), nil
+}
+
+// head returns the sequence and hash of the last entry
+func (t *Trail) head() (int64, string) {
+	if len(t.entries) == 0 {
+		return t.anchor.Sequence, t.anchor.Hash
+	}
+	last := t.entries[len(t.entries)-1]
+	return last.Sequence, last.Hash
+}
+
+// Filter selects audit entries
+type Filter struct {
+	EntityType string
+	EntityID   string
+	ActorID    string
+	Action     string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+}
+
+func (f Filter) matches(e *models.AuditLog) bool {
+	if f.EntityType != "" && e.EntityType != f.EntityType {
+		return false
+	}
+	if f.EntityID != "" && e.EntityID != f.EntityID {
+		return false
+	}
+	if f.ActorID != "" && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.From != nil && e.Timestamp.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && e.Timestamp.After(*f.To) {
+		return false
+	}
+	return true
+}
+
+// Query returns copies of the matching entries in sequence order. With a
+// limit, the most recent entries are returned.
+func (t *Trail) Query(filter Filter) []*models.AuditLog {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var results []*models.AuditLog
+	for _, e := range t.entries {
+		if filter.matches(e) {
+			results = append(results, copyEntry(e))
+		}
+	}
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[len(results)-filter.Limit:]
+	}
+	return results
+}
+
+// VerifyResult is the outcome of checking the hash chain
+type VerifyResult struct {
+	Valid         bool      `json:"valid"`
+	Entries       int       `json:"entries"`
+	FirstSequence int64     `json:"first_sequence,omitempty"`
+	LastSequence  int64     `json:"last_sequence,omitempty"`
+	Anchor        string    `json:"anchor"`
+	Head          string    `json:"head"`
+	BrokenAt      int64     `json:"broken_at,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	VerifiedAt    time.Time `json:"verified_at"`
+}
+
+// Verify recomputes the hash chain from the retention checkpoint
+func (t *Trail) Verify() *VerifyResult {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := VerifyChain(t.entries, t.anchor.Sequence, t.anchor.Hash)
+	result.VerifiedAt = t.now()
+	return result
+}
+
+// VerifyChain checks that entries follow sequence afterSeq and chain to
+// prevHash, and that each hash matches its entry
+func VerifyChain(entries []*models.AuditLog, afterSeq int64, prevHash string) *VerifyResult {
+	result := &VerifyResult{Valid: true, Entries: len(entries), Anchor: prevHash, Head: prevHash}
+	seq, prev := afterSeq, prevHash
+	for _, e := range entries {
+		if result.FirstSequence == 0 {
+			result.FirstSequence = e.Sequence
+		}
+		var problem string
+		switch {
+		case e.Sequence != seq+1:
+			problem = fmt.Sprintf("sequence %d follows %d", e.Sequence, seq)
+		case e.PrevHash != prev:
+			problem = "previous hash does not match the preceding entry"
+		default:
+			c := copyEntry(e)
+			c.Hash = ""
+			if hash, err := hashEntry(c); err != nil || hash != e.Hash {
+				problem = "hash does not match the entry"
+			}
+		}
+		if problem != "" {
+			result.Valid = false
+			result.BrokenAt = e.Sequence
+			result.Error = problem
+			return result
+		}
+		seq, prev = e.Sequence, e.Hash
+		result.LastSequence = seq
+		result.Head = prev
+	}
+	return result
+}
+
+// EnforceRetention removes entries older than the retention period. The
+// hash of the last removed entry becomes the checkpoint the chain is
+// verified from, and the removal itself is recorded.
+func (t *Trail) EnforceRetention() (int, error) {
+	if t.retention <= 0 {
+		return 0, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := t.now().Add(-t.retention)
+	n := 0
+	for n < len(t.entries) && t.entries[n].Timestamp.Before(cutoff) {
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	last := t.entries[n-1]
+	anchor := Checkpoint{Sequence: last.Sequence, Hash: last.Hash, PrunedAt: t.now().UTC()}
+	kept := t.entries[n:]
+	if t.file != nil {
+		if err := t.rewrite(anchor, kept); err != nil {
+			return 0, err
+		}
+	}
+	previous := t.anchor
+	t.anchor = anchor
+	t.entries = append([]*models.AuditLog(nil), kept...)
+
+	_, err := t.append(&models.AuditLog{
+		EntityType: "audit_log",
+		Action:     "retention_prune",
+		ActorID:    "retention",
+		ActorType:  ActorSystem,
+		Reason:     fmt.Sprintf("entries older than %s removed", cutoff.UTC().Format(time.RFC3339)),
+		Changes: map[string]interface{}{
+			"entries_removed": Change{After: n},
+			"anchor_sequence": Change{Before: previous.Sequence, After: anchor.Sequence},
+		},
+	})
+	return n, err
+}
+
+// rewrite replaces the trail file with the checkpoint and kept entries
+func (t *Trail) rewrite(anchor Checkpoint, kept []*models.AuditLog) error {
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(fileLine{Checkpoint: &anchor}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("audit: %w", err)
+	}
+	for _, e := range kept {
+		if err := enc.Encode(fileLine{AuditLog: e}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("audit: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("audit: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	t.file.Close()
+	t.file = f
+	return nil
+}
+
+// hashEntry hashes the canonical JSON of an entry. Values are normalized
+// through a JSON round trip so an entry hashes the same before and after it
+// is stored.
+func hashEntry(e *models.AuditLog) (string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("audit: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("audit: %w", err)
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("audit: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func copyEntry(e *models.AuditLog) *models.AuditLog {
+	c := *e
+	if e.Changes != nil {
+		c.Changes = make(map[string]interface{}, len(e.Changes))
+		for k, v := range e.Changes {
+			c.Changes[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,253 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+)
+
+func newTestTrail(t *testing.T, cfg *config.ComplianceConfig) *Trail {
+	t.Helper()
+	trail, err := NewTrail(cfg)
+	if err != nil {
+		t.Fatalf("NewTrail failed: %v", err)
+	}
+	t.Cleanup(trail.Stop)
+	return trail
+}
+
+func record(t *testing.T, trail *Trail, entityID, action string) *models.AuditLog {
+	t.Helper()
+	entry, err := trail.Record(&models.AuditLog{
+		EntityType: "sar",
+		EntityID:   entityID,
+		Action:     action,
+		ActorID:    "analyst-1",
+		Changes:    map[string]interface{}{"status": Change{Before: "draft", After: "pending"}},
+	})
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	return entry
+}
+
+func TestTrail_RecordAndVerify(t *testing.T) {
+	trail := newTestTrail(t, &config.ComplianceConfig{})
+
+	first := record(t, trail, "sar-1", "create")
+	second := record(t, trail, "sar-1", "submit")
+	if first.Sequence != 1 || second.Sequence != 2 {
+		t.Errorf("unexpected sequences %d, %d", first.Sequence, second.Sequence)
+	}
+	if first.PrevHash != GenesisHash || second.PrevHash != first.Hash {
+		t.Error("entries are not chained")
+	}
+	if first.ActorType != ActorUser {
+		t.Errorf("expected actor type %q, got %q", ActorUser, first.ActorType)
+	}
+
+	anonymous, _ := trail.Record(&models.AuditLog{EntityType: "sar", EntityID: "sar-2", Action: "create"})
+	if anonymous.ActorType != ActorAnonymous {
+		t.Errorf("expected anonymous actor, got %q", anonymous.ActorType)
+	}
+
+	result := trail.Verify()
+	if !result.Valid || result.Entries != 3 || result.Head != anonymous.Hash {
+		t.Errorf("unexpected verification: %+v", result)
+	}
+}
+
+func TestTrail_DetectsTampering(t *testing.T) {
+	trail := newTestTrail(t, &config.ComplianceConfig{})
+	record(t, trail, "sar-1", "create")
+	record(t, trail, "sar-1", "approve")
+	record(t, trail, "sar-1", "file")
+
+	// Returned entries are copies
+	trail.Query(Filter{})[1].ActorID = "someone-else"
+	if !trail.Verify().Valid {
+		t.Fatal("modifying a query result should not affect the trail")
+	}
+
+	trail.entries[1].ActorID = "someone-else"
+	result := trail.Verify()
+	if result.Valid || result.BrokenAt != 2 {
+		t.Errorf("expected chain broken at 2, got %+v", result)
+	}
+
+	trail.entries[1].ActorID = "analyst-1"
+	trail.entries = append(trail.entries[:1], trail.entries[2:]...)
+	result = trail.Verify()
+	if result.Valid || result.BrokenAt != 3 {
+		t.Errorf("expected removed entry detected at 3, got %+v", result)
+	}
+}
+
+func TestTrail_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := &config.ComplianceConfig{AuditLogPath: path}
+
+	trail, err := NewTrail(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, trail, "sar-1", "create")
+	last := record(t, trail, "sar-1", "submit")
+	trail.Stop()
+
+	reopened := newTestTrail(t, cfg)
+	result := reopened.Verify()
+	if !result.Valid || result.Entries != 2 || result.Head != last.Hash {
+		t.Fatalf("unexpected verification after reload: %+v", result)
+	}
+	next := record(t, reopened, "sar-1", "approve")
+	if next.Sequence != 3 || next.PrevHash != last.Hash {
+		t.Errorf("chain not continued after reload: %+v", next)
+	}
+	reopened.Stop()
+
+	// Editing the file is detected on the next load
+	data, _ := os.ReadFile(path)
+	data = bytes.Replace(data, []byte(`"action":"submit"`), []byte(`"action":"reject"`), 1)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tampered := newTestTrail(t, cfg)
+	if result := tampered.Verify(); result.Valid || result.BrokenAt != 2 {
+		t.Errorf("expected tampered file detected at 2, got %+v", result)
+	}
+}
+
+func TestTrail_EnforceRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := &config.ComplianceConfig{AuditLogPath: path, AuditLogRetention: 30}
+	trail := newTestTrail(t, cfg)
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	trail.now = func() time.Time { return now.Add(-60 * 24 * time.Hour) }
+	record(t, trail, "sar-1", "create")
+	old := record(t, trail, "sar-1", "submit")
+	trail.now = func() time.Time { return now }
+	recent := record(t, trail, "sar-1", "approve")
+
+	removed, err := trail.EnforceRetention()
+	if err != nil || removed != 2 {
+		t.Fatalf("expected 2 entries removed, got %d, %v", removed, err)
+	}
+	result := trail.Verify()
+	if !result.Valid || result.Anchor != old.Hash || result.FirstSequence != recent.Sequence {
+		t.Errorf("unexpected verification after pruning: %+v", result)
+	}
+	entries := trail.Query(Filter{})
+	if len(entries) != 2 || entries[1].Action != "retention_prune" || entries[1].ActorType != ActorSystem {
+		t.Fatalf("expected prune to be recorded, got %+v", entries)
+	}
+	trail.Stop()
+
+	reopened := newTestTrail(t, cfg)
+	if result := reopened.Verify(); !result.Valid || result.Anchor != old.Hash || result.Entries != 2 {
+		t.Errorf("unexpected verification after reload: %+v", result)
+	}
+}
+
+func TestTrail_Query(t *testing.T) {
+	trail := newTestTrail(t, &config.ComplianceConfig{})
+	record(t, trail, "sar-1", "create")
+	record(t, trail, "sar-2", "create")
+	record(t, trail, "sar-1", "submit")
+
+	if got := trail.Query(Filter{EntityID: "sar-1"}); len(got) != 2 {
+		t.Errorf("expected 2 entries for sar-1, got %d", len(got))
+	}
+	got := trail.Query(Filter{Action: "create", Limit: 1})
+	if len(got) != 1 || got[0].EntityID != "sar-2" {
+		t.Errorf("expected the most recent create, got %+v", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := Snapshot(map[string]interface{}{
+		"status":   "open",
+		"assignee": "a",
+		"details":  map[string]interface{}{"score": 1},
+		"timeline": []string{"created"},
+	})
+	after := Snapshot(map[string]interface{}{
+		"status":   "closed",
+		"assignee": "a",
+		"details":  map[string]interface{}{"score": 2},
+		"timeline": []string{"created", "closed"},
+	})
+
+	changes := Diff(before, after)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changes)
+	}
+	if c := changes["status"].(Change); c.Before != "open" || c.After != "closed" {
+		t.Errorf("unexpected status change: %+v", c)
+	}
+	if c := changes["details.score"].(Change); c.Before != float64(1) || c.After != float64(2) {
+		t.Errorf("unexpected nested change: %+v", c)
+	}
+	if c := changes["timeline"].(Change); len(c.Added) != 1 || c.Added[0] != "closed" {
+		t.Errorf("expected appended timeline item, got %+v", c)
+	}
+
+	if Diff(before, before) != nil {
+		t.Error("expected no changes for identical snapshots")
+	}
+	if c := Diff(nil, "x")["value"].(Change); c.After != "x" {
+		t.Errorf("unexpected root change: %+v", c)
+	}
+}
+
+func TestTrail_Export(t *testing.T) {
+	trail := newTestTrail(t, &config.ComplianceConfig{})
+	record(t, trail, "sar-1", "create")
+	record(t, trail, "sar-1", "submit")
+
+	var buf bytes.Buffer
+	manifest, err := trail.Export(&buf, Filter{}, FormatJSONL)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if manifest.Entries != 2 || manifest.FirstSequence != 1 || manifest.LastSequence != 2 || !manifest.Chain.Valid {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var exported []*models.AuditLog
+	for _, line := range lines {
+		var e models.AuditLog
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		exported = append(exported, &e)
+	}
+	if result := VerifyChain(exported, 0, GenesisHash); !result.Valid {
+		t.Errorf("exported entries do not verify: %+v", result)
+	}
+
+	buf.Reset()
+	if _, err := trail.Export(&buf, Filter{}, FormatCSV); err != nil {
+		t.Fatalf("CSV export failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "sequence" || rows[2][5] != "submit" {
+		t.Errorf("unexpected CSV rows: %v", rows)
+	}
+
+	if _, err := trail.Export(&buf, Filter{}, "xml"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Change is the before and after value of a changed field. Items appended
+// to a list are recorded as Added rather than as the whole list.
+type Change struct {
+	Before interface{}   `json:"before,omitempty"`
+	After  interface{}   `json:"after,omitempty"`
+	Added  []interface{} `json:"added,omitempty"`
+}
+
+// Snapshot captures the JSON form of a value, so later changes to the value
+// do not affect it. It returns nil for nil values and values that cannot be
+// encoded.
+func Snapshot(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// Diff compares two snapshots field by field. Nested objects are compared
+// by dotted path; lists are compared whole. A nil before describes a
+// creation and a nil after a deletion.
+func Diff(before, after interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+	diffValue("", before, after, changes)
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func diffValue(path string, before, after interface{}, changes map[string]interface{}) {
+	bm, bIsMap := before.(map[string]interface{})
+	am, aIsMap := after.(map[string]interface{})
+	if (bIsMap || before == nil) && (aIsMap || after == nil) && (bIsMap || aIsMap) {
+		for k, bv := range bm {
+			diffValue(join(path, k), bv, am[k], changes)
+		}
+		for k, av := range am {
+			if _, ok := bm[k]; !ok {
+				diffValue(join(path, k), nil, av, changes)
+			}
+		}
+		return
+	}
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	key := path
+	if key == "" {
+		key = "value"
+	}
+
+	// Appending to a list, such as a case timeline, records the new items
+	bl, bIsList := before.([]interface{})
+	al, aIsList := after.([]interface{})
+	if bIsList && aIsList && len(al) > len(bl) && reflect.DeepEqual(bl, al[:len(bl)]) {
+		changes[key] = Change{Added: al[len(bl):]}
+		return
+	}
+	changes[key] = Change{Before: before, After: after}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
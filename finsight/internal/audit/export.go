@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Export formats
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// ExportManifest describes an export: the range it covers and the state of
+// the chain when it was taken. An examiner can check a contiguous export by
+// recomputing each hash from its entry and PrevHash.
+type ExportManifest struct {
+	Format        string        `json:"format"`
+	Entries       int           `json:"entries"`
+	FirstSequence int64         `json:"first_sequence,omitempty"`
+	LastSequence  int64         `json:"last_sequence,omitempty"`
+	From          *time.Time    `json:"from,omitempty"`
+	To            *time.Time    `json:"to,omitempty"`
+	Chain         *VerifyResult `json:"chain"`
+	ExportedAt    time.Time     `json:"exported_at"`
+}
+
+// Export writes the matching entries as JSON lines or CSV and returns the
+// manifest of the export
+func (t *Trail) Export(w io.Writer, filter Filter, format string) (*ExportManifest, error) {
+	entries := t.Query(filter)
+	manifest := &ExportManifest{
+		Format:     format,
+		Entries:    len(entries),
+		From:       filter.From,
+		To:         filter.To,
+		Chain:      t.Verify(),
+		ExportedAt: t.now().UTC(),
+	}
+	if len(entries) > 0 {
+		manifest.FirstSequence = entries[0].Sequence
+		manifest.LastSequence = entries[len(entries)-1].Sequence
+	}
+
+	switch format {
+	case FormatJSONL, "":
+		manifest.Format = FormatJSONL
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return nil, err
+			}
+		}
+	case FormatCSV:
+		if err := writeCSV(w, entries); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported audit export format %q", format)
+	}
+	return manifest, nil
+}
+
+var csvHeader = []string{
+	"sequence", "id", "timestamp", "actor_id", "actor_type", "action",
+	"entity_type", "entity_id", "reason", "changes", "ip_address",
+	"user_agent", "request_id", "prev_hash", "hash",
+}
+
+func writeCSV(w io.Writer, entries []*models.AuditLog) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		changes := ""
+		if len(e.Changes) > 0 {
+			data, err := json.Marshal(e.Changes)
+			if err != nil {
+				return err
+			}
+			changes = string(data)
+		}
+		err := cw.Write([]string{
+			strconv.FormatInt(e.Sequence, 10),
+			e.ID,
+			e.Timestamp.Format(time.RFC3339Nano),
+			e.ActorID,
+			e.ActorType,
+			e.Action,
+			e.EntityType,
+			e.EntityID,
+			e.Reason,
+			changes,
+			e.IPAddress,
+			e.UserAgent,
+			e.RequestID,
+			e.PrevHash,
+			e.Hash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -17,7 +17,9 @@ type Config struct {
 	Fraud         FraudConfig         `yaml:"fraud"`
 	Reconciliation ReconciliationConfig `yaml:"reconciliation"`
 	Reporting     ReportingConfig     `yaml:"reporting"`
+	Regulatory    RegulatoryConfig    `yaml:"regulatory"`
 	Compliance    ComplianceConfig    `yaml:"compliance"`
+	FX            FXConfig            `yaml:"fx"`
 	Alerts        AlertsConfig        `yaml:"alerts"`
 }
 
@@ -58,6 +60,16 @@ type FraudConfig struct {
 	MaxSingleAmount   float64       `yaml:"max_single_amount"`
 	GeofencingEnabled bool          `yaml:"geofencing_enabled"`
 	MLModelPath       string        `yaml:"ml_model_path"`
+	MLModelWeight     float64       `yaml:"ml_model_weight"`
+	MLChallengerPaths []string      `yaml:"ml_challenger_paths"`
+	RulesPath         string        `yaml:"rules_path"`
+	GeoIPDatabase     string        `yaml:"geoip_database"`
+	// A device is new to an account for NewDeviceWindow; transactions of
+	// at least NewDeviceAmount from a new device raise the risk score
+	NewDeviceAmount      float64       `yaml:"new_device_amount"`
+	NewDeviceWindow      time.Duration `yaml:"new_device_window"`
+	DeviceTrustAge       time.Duration `yaml:"device_trust_age"`
+	MaxAccountsPerDevice int           `yaml:"max_accounts_per_device"`
 }
 
 // ReconciliationConfig holds reconciliation configuration
@@ -67,6 +79,33 @@ type ReconciliationConfig struct {
 	DateTolerance    time.Duration `yaml:"date_tolerance"`
 	BatchSize        int           `yaml:"batch_size"`
 	ScheduleCron     string        `yaml:"schedule_cron"`
+	MaxCandidates    int           `yaml:"max_candidates"`
+	Workers          int           `yaml:"workers"`
+	CheckpointDir    string        `yaml:"checkpoint_dir"`
+	CheckpointEvery  int           `yaml:"checkpoint_every"`
+	GroupMatching    GroupMatchConfig `yaml:"group_matching"`
+	MaxStatementSize int64            `yaml:"max_statement_size"` // bytes of an imported bank statement
+}
+
+// GroupMatchConfig holds many-to-one matching configuration. Records left
+// unmatched one-to-one are matched against groups of records on the other
+// side, such as card transactions settled as a single deposit.
+type GroupMatchConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	DateWindow    time.Duration `yaml:"date_window"`
+	MaxGroupSize  int           `yaml:"max_group_size"`
+	MaxCandidates int           `yaml:"max_candidates"`
+	FeeRules      []FeeRule     `yaml:"fee_rules"`
+}
+
+// FeeRule bounds the fee a group may have deducted before settlement: up to
+// Rate of the gross amount plus PerItem for each record plus Fixed. An empty
+// currency applies to every currency.
+type FeeRule struct {
+	Currency string  `yaml:"currency"`
+	Rate     float64 `yaml:"rate"`
+	PerItem  float64 `yaml:"per_item"`
+	Fixed    float64 `yaml:"fixed"`
 }
 
 // ReportingConfig holds reporting configuration
@@ -76,6 +115,12 @@ type ReportingConfig struct {
 	RetentionDays    int      `yaml:"retention_days"`
 	DefaultFormats   []string `yaml:"default_formats"`
 	ScheduledReports []ScheduledReport `yaml:"scheduled_reports"`
+	Timezone         string        `yaml:"timezone"`
+	MaxRetries       int           `yaml:"max_retries"`
+	RetryDelay       time.Duration `yaml:"retry_delay"`
+	HistorySize      int           `yaml:"history_size"`
+	SMTP             SMTPConfig    `yaml:"smtp"`
+	S3               S3Config      `yaml:"s3"`
 }
 
 // ScheduledReport represents a scheduled report configuration
@@ -86,6 +131,34 @@ type ScheduledReport struct {
 	Schedule string `yaml:"schedule"`
 	Format   string `yaml:"format"`
 	Recipients []string `yaml:"recipients"`
+	Timezone   string   `yaml:"timezone"`    // overrides reporting.timezone
+	WebhookURL string   `yaml:"webhook_url"` // POST the exported file
+	S3Upload   bool     `yaml:"s3_upload"`   // upload to reporting.s3
+}
+
+// SMTPConfig holds the mail server used to deliver reports
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// S3Config holds an S3-compatible bucket used to store delivered reports
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. https://s3.eu-west-1.amazonaws.com or a MinIO URL
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// RegulatoryConfig holds regulatory reporting configuration
+type RegulatoryConfig struct {
+	OutputDir string `yaml:"output_dir"`
+	EntityID  string `yaml:"entity_id"` // RSSD ID of the reporting institution
 }
 
 // ComplianceConfig holds compliance configuration
@@ -95,7 +168,105 @@ type ComplianceConfig struct {
 	SARThreshold     float64  `yaml:"sar_threshold"`
 	CTRThreshold     float64  `yaml:"ctr_threshold"`
 	WatchlistEnabled bool     `yaml:"watchlist_enabled"`
-	AuditLogRetention int     `yaml:"audit_log_retention"`
+	AuditLogRetention int     `yaml:"audit_log_retention"` // days; 0 keeps entries forever
+	AuditLogPath      string  `yaml:"audit_log_path"`
+	Approvals         ApprovalConfig  `yaml:"approvals"`
+	Cases             CaseConfig      `yaml:"cases"`
+	BSA               BSAConfig       `yaml:"bsa"`
+	Watchlist         WatchlistConfig `yaml:"watchlist"`
+	Graph             GraphConfig     `yaml:"graph"`
+}
+
+// WatchlistConfig holds the sanctions lists the AML engine downloads and
+// refreshes, used when watchlist_enabled is set
+type WatchlistConfig struct {
+	Feeds     []WatchlistFeedConfig `yaml:"feeds"`
+	Refresh   time.Duration         `yaml:"refresh"`
+	Threshold float64               `yaml:"threshold"`  // name match score that counts as a hit
+	MaxShrink float64               `yaml:"max_shrink"` // share of a list's entries an update may remove
+}
+
+// WatchlistFeedConfig holds one sanctions list source
+type WatchlistFeedConfig struct {
+	Format      string `yaml:"format"`    // ofac_sdn_xml, ofac_sdn_csv, eu_xml, un_xml
+	URL         string `yaml:"url"`       // file path or http(s) URL
+	AliasURL    string `yaml:"alias_url"` // OFAC alt.csv for ofac_sdn_csv
+	AllowShrink bool   `yaml:"allow_shrink"`
+}
+
+// GraphConfig holds the transaction graph analytics settings
+type GraphConfig struct {
+	Window       time.Duration `yaml:"window"`        // how long transfers stay in the graph
+	FanThreshold int           `yaml:"fan_threshold"` // counterparties that make a fan-in or fan-out
+	CycleDepth   int           `yaml:"cycle_depth"`   // longest round-tripping cycle searched
+}
+
+// BSAConfig holds the transmitter details written to FinCEN BSA E-Filing
+// batches. Filing SARs and CTRs fails until they are set.
+type BSAConfig struct {
+	TransmitterControlCode string        `yaml:"transmitter_control_code"` // TCC issued by FinCEN
+	TransmitterName        string        `yaml:"transmitter_name"`
+	TransmitterTIN         string        `yaml:"transmitter_tin"`
+	TransmitterAddress     AddressConfig `yaml:"transmitter_address"`
+	TransmitterPhone       string        `yaml:"transmitter_phone"`
+	ContactName            string        `yaml:"contact_name"`
+	PrimaryRegulator       string        `yaml:"primary_regulator"` // FinCEN regulator code of the filing institution
+}
+
+// AddressConfig holds a postal address
+type AddressConfig struct {
+	Line1      string `yaml:"line1"`
+	Line2      string `yaml:"line2"`
+	City       string `yaml:"city"`
+	State      string `yaml:"state"`
+	PostalCode string `yaml:"postal_code"`
+	Country    string `yaml:"country"`
+}
+
+// CaseConfig holds AML case management settings. Queues maps a risk level
+// to the investigators who work its cases.
+type CaseConfig struct {
+	AssignmentSLA           time.Duration       `yaml:"assignment_sla"`
+	SARSLA                  time.Duration       `yaml:"sar_sla"` // from detection to SAR filing
+	Queues                  map[string][]string `yaml:"queues"`
+	MaxCasesPerInvestigator int                 `yaml:"max_cases_per_investigator"` // 0 for no limit
+	EvidenceDir             string              `yaml:"evidence_dir"`               // empty keeps evidence in memory
+}
+
+// ApprovalConfig holds the maker-checker policies of actions that need a
+// second person's approval, keyed by action (sar_approval,
+// regulatory_report_approval, reconciliation_write_off), and the roles of
+// each approver
+type ApprovalConfig struct {
+	Policies      map[string]ApprovalPolicy `yaml:"policies"`
+	Roles         map[string][]string       `yaml:"roles"` // actor ID to roles
+	CheckInterval time.Duration             `yaml:"check_interval"`
+}
+
+// ApprovalPolicy sets who may approve an action and how many must. An
+// approver must hold one of Roles, or any role when Roles is empty. A
+// request still pending after EscalationTimeout is escalated, and holders
+// of EscalationRoles may then approve it too.
+type ApprovalPolicy struct {
+	Roles             []string      `yaml:"roles"`
+	Approvers         int           `yaml:"approvers"`
+	AllowSelfApproval bool          `yaml:"allow_self_approval"`
+	EscalationTimeout time.Duration `yaml:"escalation_timeout"`
+	EscalationRoles   []string      `yaml:"escalation_roles"`
+}
+
+// FXConfig holds foreign exchange configuration
+type FXConfig struct {
+	BaseCurrency    string             `yaml:"base_currency"`
+	RefreshInterval time.Duration      `yaml:"refresh_interval"`
+	MaxRateAge      time.Duration      `yaml:"max_rate_age"`
+	Providers       []FXProviderConfig `yaml:"providers"`
+}
+
+// FXProviderConfig configures a single FX rate provider
+type FXProviderConfig struct {
+	Type   string `yaml:"type"`   // csv, ecb
+	Source string `yaml:"source"` // file path or URL
 }
 
 // AlertsConfig holds alerting configuration
@@ -178,6 +349,14 @@ func LoadFromEnv() *Config {
 			MaxDailyAmount:    getEnvFloat("FRAUD_MAX_DAILY", 10000),
 			MaxSingleAmount:   getEnvFloat("FRAUD_MAX_SINGLE", 5000),
 			GeofencingEnabled: getEnvBool("FRAUD_GEOFENCING", true),
+			MLModelPath:       getEnv("FRAUD_ML_MODEL", ""),
+			MLModelWeight:     getEnvFloat("FRAUD_ML_WEIGHT", 0.5),
+			RulesPath:         getEnv("FRAUD_RULES_PATH", ""),
+			GeoIPDatabase:     getEnv("FRAUD_GEOIP_DB", ""),
+			NewDeviceAmount:      getEnvFloat("FRAUD_NEW_DEVICE_AMOUNT", 1000),
+			NewDeviceWindow:      getEnvDuration("FRAUD_NEW_DEVICE_WINDOW", 24*time.Hour),
+			DeviceTrustAge:       getEnvDuration("FRAUD_DEVICE_TRUST_AGE", 30*24*time.Hour),
+			MaxAccountsPerDevice: getEnvInt("FRAUD_MAX_ACCOUNTS_PER_DEVICE", 3),
 		},
 		Reconciliation: ReconciliationConfig{
 			AutoReconcile:  getEnvBool("RECON_AUTO", true),
@@ -185,12 +364,50 @@ func LoadFromEnv() *Config {
 			DateTolerance:  getEnvDuration("RECON_DATE_TOLERANCE", 24*time.Hour),
 			BatchSize:      getEnvInt("RECON_BATCH_SIZE", 5000),
 			ScheduleCron:   getEnv("RECON_SCHEDULE", "0 2 * * *"),
+			MaxCandidates:  getEnvInt("RECON_MAX_CANDIDATES", 64),
+			Workers:        getEnvInt("RECON_WORKERS", 4),
+			CheckpointDir:  getEnv("RECON_CHECKPOINT_DIR", ""),
+			CheckpointEvery: getEnvInt("RECON_CHECKPOINT_EVERY", 100000),
+			MaxStatementSize: int64(getEnvInt("RECON_MAX_STATEMENT_SIZE", 32<<20)),
+			GroupMatching: GroupMatchConfig{
+				Enabled:       getEnvBool("RECON_GROUP_MATCHING", true),
+				DateWindow:    getEnvDuration("RECON_GROUP_WINDOW", 72*time.Hour),
+				MaxGroupSize:  getEnvInt("RECON_GROUP_MAX_SIZE", 20),
+				MaxCandidates: getEnvInt("RECON_GROUP_MAX_CANDIDATES", 40),
+				FeeRules: []FeeRule{{
+					Rate:    getEnvFloat("RECON_GROUP_FEE_RATE", 0.03),
+					PerItem: getEnvFloat("RECON_GROUP_FEE_PER_ITEM", 0.30),
+				}},
+			},
 		},
 		Reporting: ReportingConfig{
 			Enabled:        getEnvBool("REPORTING_ENABLED", true),
 			StoragePath:    getEnv("REPORTING_PATH", "/var/lib/finsight/reports"),
 			RetentionDays:  getEnvInt("REPORTING_RETENTION", 90),
 			DefaultFormats: []string{"pdf", "csv", "xlsx"},
+			Timezone:       getEnv("REPORTING_TIMEZONE", "UTC"),
+			MaxRetries:     getEnvInt("REPORTING_MAX_RETRIES", 3),
+			RetryDelay:     getEnvDuration("REPORTING_RETRY_DELAY", 30*time.Second),
+			HistorySize:    getEnvInt("REPORTING_HISTORY_SIZE", 500),
+			SMTP: SMTPConfig{
+				Host:     getEnv("SMTP_HOST", ""),
+				Port:     getEnvInt("SMTP_PORT", 587),
+				Username: getEnv("SMTP_USERNAME", ""),
+				Password: getEnv("SMTP_PASSWORD", ""),
+				From:     getEnv("SMTP_FROM", "reports@finsight.local"),
+			},
+			S3: S3Config{
+				Endpoint:  getEnv("REPORTING_S3_ENDPOINT", ""),
+				Region:    getEnv("REPORTING_S3_REGION", "us-east-1"),
+				Bucket:    getEnv("REPORTING_S3_BUCKET", ""),
+				Prefix:    getEnv("REPORTING_S3_PREFIX", "reports/"),
+				AccessKey: getEnv("REPORTING_S3_ACCESS_KEY", ""),
+				SecretKey: getEnv("REPORTING_S3_SECRET_KEY", ""),
+			},
+		},
+		Regulatory: RegulatoryConfig{
+			OutputDir: getEnv("REGULATORY_OUTPUT_DIR", "/var/lib/finsight/regulatory"),
+			EntityID:  getEnv("REGULATORY_ENTITY_ID", ""),
 		},
 		Compliance: ComplianceConfig{
 			AMLEnabled:        getEnvBool("COMPLIANCE_AML", true),
@@ -199,10 +416,85 @@ func LoadFromEnv() *Config {
 			CTRThreshold:      getEnvFloat("COMPLIANCE_CTR_THRESHOLD", 10000),
 			WatchlistEnabled:  getEnvBool("COMPLIANCE_WATCHLIST", true),
 			AuditLogRetention: getEnvInt("COMPLIANCE_AUDIT_RETENTION", 730),
+			AuditLogPath:      getEnv("COMPLIANCE_AUDIT_LOG", ""),
+			Approvals: ApprovalConfig{
+				Policies: map[string]ApprovalPolicy{
+					"sar_approval":               {Approvers: 1, EscalationTimeout: 48 * time.Hour},
+					"regulatory_report_approval": {Approvers: 2, EscalationTimeout: 72 * time.Hour},
+					"reconciliation_write_off":   {Approvers: 1, EscalationTimeout: 24 * time.Hour},
+				},
+				CheckInterval: getEnvDuration("COMPLIANCE_APPROVAL_CHECK_INTERVAL", time.Minute),
+			},
+			Cases: CaseConfig{
+				AssignmentSLA: getEnvDuration("COMPLIANCE_CASE_ASSIGNMENT_SLA", 48*time.Hour),
+				SARSLA:        getEnvDuration("COMPLIANCE_CASE_SAR_SLA", 30*24*time.Hour),
+				EvidenceDir:   getEnv("COMPLIANCE_EVIDENCE_DIR", ""),
+			},
+			BSA: BSAConfig{
+				TransmitterControlCode: getEnv("COMPLIANCE_BSA_TCC", ""),
+				TransmitterName:        getEnv("COMPLIANCE_BSA_TRANSMITTER_NAME", ""),
+				TransmitterTIN:         getEnv("COMPLIANCE_BSA_TRANSMITTER_TIN", ""),
+				TransmitterAddress: AddressConfig{
+					Line1:      getEnv("COMPLIANCE_BSA_ADDRESS_LINE1", ""),
+					Line2:      getEnv("COMPLIANCE_BSA_ADDRESS_LINE2", ""),
+					City:       getEnv("COMPLIANCE_BSA_ADDRESS_CITY", ""),
+					State:      getEnv("COMPLIANCE_BSA_ADDRESS_STATE", ""),
+					PostalCode: getEnv("COMPLIANCE_BSA_ADDRESS_POSTAL_CODE", ""),
+					Country:    getEnv("COMPLIANCE_BSA_ADDRESS_COUNTRY", "US"),
+				},
+				TransmitterPhone: getEnv("COMPLIANCE_BSA_TRANSMITTER_PHONE", ""),
+				ContactName:      getEnv("COMPLIANCE_BSA_CONTACT_NAME", ""),
+				PrimaryRegulator: getEnv("COMPLIANCE_BSA_PRIMARY_REGULATOR", ""),
+			},
+			Watchlist: WatchlistConfig{
+				Feeds:     watchlistFeedsFromEnv(),
+				Refresh:   getEnvDuration("COMPLIANCE_WATCHLIST_REFRESH", 24*time.Hour),
+				Threshold: getEnvFloat("COMPLIANCE_WATCHLIST_THRESHOLD", 0.85),
+				MaxShrink: getEnvFloat("COMPLIANCE_WATCHLIST_MAX_SHRINK", 0.2),
+			},
+			Graph: GraphConfig{
+				Window:       getEnvDuration("COMPLIANCE_GRAPH_WINDOW", 30*24*time.Hour),
+				FanThreshold: getEnvInt("COMPLIANCE_GRAPH_FAN_THRESHOLD", 5),
+				CycleDepth:   getEnvInt("COMPLIANCE_GRAPH_CYCLE_DEPTH", 5),
+			},
+		},
+		FX: FXConfig{
+			BaseCurrency:    getEnv("FX_BASE_CURRENCY", "USD"),
+			RefreshInterval: getEnvDuration("FX_REFRESH_INTERVAL", 6*time.Hour),
+			MaxRateAge:      getEnvDuration("FX_MAX_RATE_AGE", 7*24*time.Hour),
+			Providers:       fxProvidersFromEnv(),
 		},
 	}
 }
 
+func fxProvidersFromEnv() []FXProviderConfig {
+	var providers []FXProviderConfig
+	if source := os.Getenv("FX_RATES_FILE"); source != "" {
+		providers = append(providers, FXProviderConfig{Type: "csv", Source: source})
+	}
+	if source := os.Getenv("FX_ECB_SOURCE"); source != "" {
+		providers = append(providers, FXProviderConfig{Type: "ecb", Source: source})
+	}
+	return providers
+}
+
+func watchlistFeedsFromEnv() []WatchlistFeedConfig {
+	var feeds []WatchlistFeedConfig
+	if url := os.Getenv("COMPLIANCE_WATCHLIST_OFAC_SDN"); url != "" {
+		feeds = append(feeds, WatchlistFeedConfig{Format: "ofac_sdn_xml", URL: url})
+	}
+	if url := os.Getenv("COMPLIANCE_WATCHLIST_OFAC_SDN_CSV"); url != "" {
+		feeds = append(feeds, WatchlistFeedConfig{Format: "ofac_sdn_csv", URL: url, AliasURL: os.Getenv("COMPLIANCE_WATCHLIST_OFAC_ALT_CSV")})
+	}
+	if url := os.Getenv("COMPLIANCE_WATCHLIST_EU"); url != "" {
+		feeds = append(feeds, WatchlistFeedConfig{Format: "eu_xml", URL: url})
+	}
+	if url := os.Getenv("COMPLIANCE_WATCHLIST_UN"); url != "" {
+		feeds = append(feeds, WatchlistFeedConfig{Format: "un_xml", URL: url})
+	}
+	return feeds
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
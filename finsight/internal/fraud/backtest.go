@@ -0,0 +1,676 @@
+package fraud
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// minBacktestSupport is the number of labeled transactions a rule must
+	// fire on before the back-test suggests changing it
+	minBacktestSupport = 5
+	// lowRulePrecision is the precision below which a rule that catches no
+	// fraud on its own is suggested for removal
+	lowRulePrecision = 0.1
+	// maxCutoffCandidates bounds the values tried when tuning a threshold
+	maxCutoffCandidates = 200
+	maxBacktests        = 50
+	thresholdStep       = 0.05
+)
+
+// BacktestConfig is a candidate configuration to replay history through.
+// Zero values fall back to the live configuration.
+type BacktestConfig struct {
+	Name            string  `json:"name,omitempty"`
+	ScoreThreshold  float64 `json:"score_threshold,omitempty"`
+	MaxSingleAmount float64 `json:"max_single_amount,omitempty"`
+	MaxDailyAmount  float64 `json:"max_daily_amount,omitempty"`
+	// Rules replaces the live data rules when set. Rules in dry-run mode
+	// are evaluated as if they were live.
+	Rules         []models.ComplianceRule `json:"rules,omitempty"`
+	DisabledRules []string                `json:"disabled_rules,omitempty"`
+	// UseModel blends the champion model into both runs
+	UseModel bool `json:"use_model,omitempty"`
+	// TargetPrecision makes the suggested score threshold the one with the
+	// best recall at or above this precision rather than the best F1
+	TargetPrecision float64 `json:"target_precision,omitempty"`
+}
+
+// BacktestReport compares a candidate configuration with the live one over
+// a window of historical transactions labeled by alert dispositions
+type BacktestReport struct {
+	ID           string               `json:"id"`
+	Name         string               `json:"name,omitempty"`
+	Config       BacktestConfig       `json:"config"`
+	WindowStart  time.Time            `json:"window_start"`
+	WindowEnd    time.Time            `json:"window_end"`
+	Transactions int                  `json:"transactions"`
+	Labeled      int                  `json:"labeled"`
+	Fraud        int                  `json:"fraud"`
+	FraudAmount  decimal.Decimal      `json:"fraud_amount"`
+	Baseline     BacktestMetrics      `json:"baseline"`
+	Candidate    BacktestMetrics      `json:"candidate"`
+	Rules        []RuleBacktest       `json:"rules"`
+	Thresholds   []ThresholdPoint     `json:"thresholds"`
+	Suggestions  []BacktestSuggestion `json:"suggestions"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// BacktestMetrics measures the alerts a configuration raised. Alerts on
+// transactions without a disposition count as unlabeled and are left out
+// of precision.
+type BacktestMetrics struct {
+	ScoreThreshold float64         `json:"score_threshold"`
+	Alerts         int             `json:"alerts"`
+	AlertRate      float64         `json:"alert_rate"`
+	TruePositives  int             `json:"true_positives"`
+	FalsePositives int             `json:"false_positives"`
+	FalseNegatives int             `json:"false_negatives"`
+	Unlabeled      int             `json:"unlabeled"`
+	Precision      float64         `json:"precision"`
+	Recall         float64         `json:"recall"`
+	F1             float64         `json:"f1"`
+	CaughtAmount   decimal.Decimal `json:"caught_amount"`
+	MissedAmount   decimal.Decimal `json:"missed_amount"`
+	CatchRate      float64         `json:"catch_rate"`
+}
+
+// RuleBacktest measures a single rule of the candidate configuration.
+// UniqueCatches counts fraud no other rule triggered on.
+type RuleBacktest struct {
+	Rule           string          `json:"rule"`
+	Source         string          `json:"source"`
+	Triggered      int             `json:"triggered"`
+	TruePositives  int             `json:"true_positives"`
+	FalsePositives int             `json:"false_positives"`
+	Unlabeled      int             `json:"unlabeled"`
+	Precision      float64         `json:"precision"`
+	Recall         float64         `json:"recall"`
+	CaughtAmount   decimal.Decimal `json:"caught_amount"`
+	CatchRate      float64         `json:"catch_rate"`
+	UniqueCatches  int             `json:"unique_catches"`
+}
+
+// ThresholdPoint is the candidate's performance at one score threshold
+type ThresholdPoint struct {
+	ScoreThreshold float64 `json:"score_threshold"`
+	Alerts         int     `json:"alerts"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1             float64 `json:"f1"`
+	CatchRate      float64 `json:"catch_rate"`
+}
+
+// BacktestSuggestion proposes a setting change with its projected effect
+type BacktestSuggestion struct {
+	Rule      string      `json:"rule,omitempty"`
+	Setting   string      `json:"setting"`
+	Current   interface{} `json:"current"`
+	Suggested interface{} `json:"suggested"`
+	Precision float64     `json:"precision"`
+	Recall    float64     `json:"recall"`
+	Reason    string      `json:"reason"`
+}
+
+// Rule sources in a back-test
+const (
+	RuleSourceBuiltin = "builtin"
+	RuleSourceData    = "data"
+)
+
+// backtestOutcome is one replayed transaction
+type backtestOutcome struct {
+	item      ReplayItem
+	amount    decimal.Decimal
+	labeled   bool
+	fraud     bool
+	score     float64
+	forced    bool
+	triggered []string
+}
+
+func (o *backtestOutcome) alerted(threshold float64) bool {
+	return o.forced || o.score >= threshold*0.7
+}
+
+// Dispositions returns the recorded outcome of resolved alerts by
+// transaction: true when confirmed as fraud, false when closed as a false
+// positive. A confirmed alert outweighs a false positive on the same
+// transaction.
+func (d *Detector) Dispositions() map[string]bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	labels := make(map[string]bool)
+	for _, alert := range d.alerts {
+		switch alert.Status {
+		case models.AlertStatusResolved:
+			labels[alert.TransactionID] = true
+		case models.AlertStatusFalsePos:
+			if _, ok := labels[alert.TransactionID]; !ok {
+				labels[alert.TransactionID] = false
+			}
+		}
+	}
+	return labels
+}
+
+// Backtest replays transactions through a candidate configuration and the
+// live one and compares both against recorded alert dispositions. Labels
+// supplied by the caller override the dispositions. Nothing is recorded
+// against the live detector apart from the report itself.
+func (d *Detector) Backtest(cfg BacktestConfig, items []ReplayItem, labels map[string]bool) (*BacktestReport, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no transactions to replay")
+	}
+	if cfg.ScoreThreshold < 0 || cfg.ScoreThreshold > 1 {
+		return nil, fmt.Errorf("score threshold must be between 0 and 1")
+	}
+	if cfg.TargetPrecision < 0 || cfg.TargetPrecision > 1 {
+		return nil, fmt.Errorf("target precision must be between 0 and 1")
+	}
+	if cfg.ScoreThreshold == 0 {
+		cfg.ScoreThreshold = d.config.ScoreThreshold
+	}
+	if cfg.MaxSingleAmount == 0 {
+		cfg.MaxSingleAmount = d.config.MaxSingleAmount
+	}
+	if cfg.MaxDailyAmount == 0 {
+		cfg.MaxDailyAmount = d.config.MaxDailyAmount
+	}
+
+	liveData, err := d.dataRules(nil)
+	if err != nil {
+		return nil, err
+	}
+	candidateData, err := d.dataRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	builtin := d.builtinRules(cfg.MaxSingleAmount, cfg.MaxDailyAmount)
+	candidate, err := withoutRules(append(builtin, candidateData...), cfg.DisabledRules)
+	if err != nil {
+		return nil, err
+	}
+	baseline := append(d.rules[:len(d.rules):len(d.rules)], liveData...)
+
+	disposition := d.Dispositions()
+	for id, fraud := range labels {
+		disposition[id] = fraud
+	}
+
+	d.mu.RLock()
+	converter := d.converter
+	d.mu.RUnlock()
+	copies := make([]ReplayItem, len(items))
+	for i, item := range items {
+		if item.Transaction == nil {
+			return nil, fmt.Errorf("transaction %d is missing", i+1)
+		}
+		txn := *item.Transaction
+		if converter != nil {
+			if err := converter.Apply(&txn); err != nil {
+				log.Printf("fraud: FX conversion failed for %s: %v", txn.ID, err)
+			}
+		}
+		copies[i] = ReplayItem{Transaction: &txn, Context: item.Context}
+	}
+	replay := ReplayContexts(copies, d.velocity.window)
+
+	report := &BacktestReport{
+		ID:           "bt-" + generateAlertID(),
+		Name:         cfg.Name,
+		Config:       cfg,
+		WindowStart:  replay[0].Transaction.CreatedAt,
+		WindowEnd:    replay[len(replay)-1].Transaction.CreatedAt,
+		Transactions: len(replay),
+		Rules:        []RuleBacktest{},
+		Thresholds:   []ThresholdPoint{},
+		Suggestions:  []BacktestSuggestion{},
+		CreatedAt:    time.Now(),
+	}
+
+	live := make([]*backtestOutcome, len(replay))
+	outcomes := make([]*backtestOutcome, len(replay))
+	for i, item := range replay {
+		fraud, labeled := disposition[item.Transaction.ID]
+		if labeled {
+			report.Labeled++
+			if fraud {
+				report.Fraud++
+				report.FraudAmount = report.FraudAmount.Add(item.Transaction.ReportingAmount())
+			}
+		}
+		live[i] = d.replayOne(item, baseline, cfg.UseModel)
+		outcomes[i] = d.replayOne(item, candidate, cfg.UseModel)
+		for _, o := range []*backtestOutcome{live[i], outcomes[i]} {
+			o.labeled, o.fraud = labeled, fraud
+		}
+	}
+
+	report.Baseline = report.metrics(live, d.config.ScoreThreshold)
+	report.Candidate = report.metrics(outcomes, cfg.ScoreThreshold)
+	report.ruleMetrics(candidate, outcomes)
+	report.suggestThreshold(outcomes, cfg)
+	report.suggestRuleChanges(candidate, outcomes, cfg)
+
+	d.mu.Lock()
+	d.backtests = append(d.backtests, report)
+	if len(d.backtests) > maxBacktests {
+		d.backtests = d.backtests[len(d.backtests)-maxBacktests:]
+	}
+	d.mu.Unlock()
+
+	return report, nil
+}
+
+// dataRules compiles rule definitions for a replay as if they were live, or
+// the live data rules in their current mode when defs is nil. The compiled
+// rules never report hits to the rule store.
+func (d *Detector) dataRules(defs []models.ComplianceRule) ([]Rule, error) {
+	forceLive := defs != nil
+	if defs == nil {
+		for _, def := range d.dsl.List() {
+			defs = append(defs, *def)
+		}
+	}
+	var compiled []*DSLRule
+	for _, def := range defs {
+		if !def.Enabled {
+			continue
+		}
+		if forceLive {
+			def.DryRun = false
+		}
+		r, err := CompileRule(def)
+		if err != nil {
+			return nil, err
+		}
+		r.geofence = d.geofence
+		compiled = append(compiled, r)
+	}
+	sortRules(compiled)
+
+	rules := make([]Rule, len(compiled))
+	for i, r := range compiled {
+		rules[i] = r
+	}
+	return rules, nil
+}
+
+// withoutRules removes disabled rules, rejecting names that match nothing
+func withoutRules(rules []Rule, disabled []string) ([]Rule, error) {
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		skip[name] = true
+	}
+	var kept []Rule
+	for _, r := range rules {
+		if skip[r.Name()] {
+			delete(skip, r.Name())
+			continue
+		}
+		kept = append(kept, r)
+	}
+	for name := range skip {
+		return nil, fmt.Errorf("unknown rule %q", name)
+	}
+	return kept, nil
+}
+
+// replayOne scores a transaction without touching detector state
+func (d *Detector) replayOne(item ReplayItem, rules []Rule, useModel bool) *backtestOutcome {
+	run := runRules(rules, item.Transaction, item.Context)
+	score := normalizeScore(run.score)
+	if useModel {
+		score, _ = d.scorer.blend(item.Transaction, item.Context, score)
+	}
+	return &backtestOutcome{
+		item:      item,
+		amount:    item.Transaction.ReportingAmount(),
+		score:     score,
+		forced:    raisesAlert(run.actions),
+		triggered: run.triggered,
+	}
+}
+
+// raisesAlert reports whether rule actions alert regardless of the score,
+// matching applyRuleActions
+func raisesAlert(actions []ruleAction) bool {
+	for _, ra := range actions {
+		if ra.action.Type == RuleActionHold || ra.action.Type == RuleActionAlert {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *BacktestReport) metrics(outcomes []*backtestOutcome, threshold float64) BacktestMetrics {
+	m := BacktestMetrics{ScoreThreshold: threshold}
+	for _, o := range outcomes {
+		alerted := o.alerted(threshold)
+		if alerted {
+			m.Alerts++
+		}
+		switch {
+		case !o.labeled:
+			if alerted {
+				m.Unlabeled++
+			}
+		case o.fraud && alerted:
+			m.TruePositives++
+			m.CaughtAmount = m.CaughtAmount.Add(o.amount)
+		case o.fraud:
+			m.FalseNegatives++
+			m.MissedAmount = m.MissedAmount.Add(o.amount)
+		case alerted:
+			m.FalsePositives++
+		}
+	}
+	m.AlertRate = ratio(m.Alerts, len(outcomes))
+	m.Precision = ratio(m.TruePositives, m.TruePositives+m.FalsePositives)
+	m.Recall = ratio(m.TruePositives, r.Fraud)
+	m.F1 = f1(m.Precision, m.Recall)
+	m.CatchRate = r.catchRate(m.CaughtAmount)
+	return m
+}
+
+func (r *BacktestReport) ruleMetrics(rules []Rule, outcomes []*backtestOutcome) {
+	stats := make(map[string]*RuleBacktest)
+	for _, rule := range rules {
+		source := RuleSourceBuiltin
+		if _, ok := rule.(*DSLRule); ok {
+			source = RuleSourceData
+		}
+		r.Rules = append(r.Rules, RuleBacktest{Rule: rule.Name(), Source: source})
+	}
+	for i := range r.Rules {
+		stats[r.Rules[i].Rule] = &r.Rules[i]
+	}
+
+	for _, o := range outcomes {
+		for _, name := range o.triggered {
+			s := stats[name]
+			s.Triggered++
+			switch {
+			case !o.labeled:
+				s.Unlabeled++
+			case o.fraud:
+				s.TruePositives++
+				s.CaughtAmount = s.CaughtAmount.Add(o.amount)
+				if len(o.triggered) == 1 {
+					s.UniqueCatches++
+				}
+			default:
+				s.FalsePositives++
+			}
+		}
+	}
+	for i := range r.Rules {
+		s := &r.Rules[i]
+		s.Precision = ratio(s.TruePositives, s.TruePositives+s.FalsePositives)
+		s.Recall = ratio(s.TruePositives, r.Fraud)
+		s.CatchRate = r.catchRate(s.CaughtAmount)
+	}
+}
+
+// suggestThreshold sweeps the score threshold and suggests the one with
+// the best F1, or the best recall at the target precision. Ties go to the
+// higher threshold, which raises fewer alerts.
+func (r *BacktestReport) suggestThreshold(outcomes []*backtestOutcome, cfg BacktestConfig) {
+	var best *ThresholdPoint
+	for i := 1; float64(i)*thresholdStep <= 1+1e-9; i++ {
+		m := r.metrics(outcomes, float64(i)*thresholdStep)
+		p := ThresholdPoint{
+			ScoreThreshold: m.ScoreThreshold,
+			Alerts:         m.Alerts,
+			Precision:      m.Precision,
+			Recall:         m.Recall,
+			F1:             m.F1,
+			CatchRate:      m.CatchRate,
+		}
+		r.Thresholds = append(r.Thresholds, p)
+
+		if cfg.TargetPrecision > 0 {
+			if p.Precision < cfg.TargetPrecision || p.Recall == 0 {
+				continue
+			}
+			if best == nil || p.Recall >= best.Recall {
+				best = &r.Thresholds[len(r.Thresholds)-1]
+			}
+		} else if p.F1 > 0 && (best == nil || p.F1 >= best.F1) {
+			best = &r.Thresholds[len(r.Thresholds)-1]
+		}
+	}
+
+	if best == nil || math.Abs(best.ScoreThreshold-cfg.ScoreThreshold) < thresholdStep/2 {
+		return
+	}
+	current := r.Candidate
+	if cfg.TargetPrecision == 0 && best.F1 <= current.F1 {
+		return
+	}
+	reason := fmt.Sprintf("F1 %.2f at threshold %.2f against %.2f at %.2f", best.F1, best.ScoreThreshold, current.F1, cfg.ScoreThreshold)
+	if cfg.TargetPrecision > 0 {
+		reason = fmt.Sprintf("best recall with precision at least %.2f", cfg.TargetPrecision)
+	}
+	r.Suggestions = append(r.Suggestions, BacktestSuggestion{
+		Setting:   "score_threshold",
+		Current:   cfg.ScoreThreshold,
+		Suggested: best.ScoreThreshold,
+		Precision: best.Precision,
+		Recall:    best.Recall,
+		Reason:    reason,
+	})
+}
+
+// suggestRuleChanges proposes removing noisy rules and retuning the amount
+// limit and the numeric thresholds of data rule conditions
+func (r *BacktestReport) suggestRuleChanges(rules []Rule, outcomes []*backtestOutcome, cfg BacktestConfig) {
+	if r.Fraud == 0 {
+		return
+	}
+
+	for _, s := range r.Rules {
+		labeled := s.TruePositives + s.FalsePositives
+		if labeled >= minBacktestSupport && s.Precision < lowRulePrecision && s.UniqueCatches == 0 {
+			r.Suggestions = append(r.Suggestions, BacktestSuggestion{
+				Rule:      s.Rule,
+				Setting:   "enabled",
+				Current:   true,
+				Suggested: false,
+				Precision: s.Precision,
+				Recall:    s.Recall,
+				Reason:    fmt.Sprintf("%d of %d labeled hits were false positives and every fraud it caught was caught by another rule", s.FalsePositives, labeled),
+			})
+		}
+	}
+
+	for _, rule := range rules {
+		switch rule := rule.(type) {
+		case *AmountRule:
+			var rows []cutoffRow
+			for _, o := range outcomes {
+				if o.labeled {
+					rows = append(rows, cutoffRow{o.amount.InexactFloat64(), o.fraud})
+				}
+			}
+			r.suggestCutoff(rule.Name(), "max_single_amount", rows, cfg.MaxSingleAmount, func(v, c float64) bool { return v > c })
+		case *DSLRule:
+			for i, cc := range rule.conditions {
+				fires := cc.cutoff()
+				if fires == nil {
+					continue
+				}
+				var rows []cutoffRow
+				for _, o := range outcomes {
+					if !o.labeled {
+						continue
+					}
+					if v, ok := rule.conditionValue(i, o.item); ok {
+						rows = append(rows, cutoffRow{v, o.fraud})
+					}
+				}
+				setting := fmt.Sprintf("conditions[%d].value (%s %s)", i, cc.label, cc.op)
+				r.suggestCutoff(rule.Name(), setting, rows, cc.number, fires)
+			}
+		}
+	}
+}
+
+// cutoffRow is a labeled value a threshold is compared against
+type cutoffRow struct {
+	value float64
+	fraud bool
+}
+
+type cutoffScore struct {
+	value, precision, recall, f1 float64
+}
+
+// suggestCutoff tries each observed value as the threshold and suggests the
+// one with the best F1 when it clearly beats the current one
+func (r *BacktestReport) suggestCutoff(rule, setting string, rows []cutoffRow, current float64, fires func(v, c float64) bool) {
+	fraud := 0
+	for _, row := range rows {
+		if row.fraud {
+			fraud++
+		}
+	}
+	if len(rows) < minBacktestSupport || fraud == 0 {
+		return
+	}
+
+	score := func(c float64) cutoffScore {
+		tp, fp := 0, 0
+		for _, row := range rows {
+			if fires(row.value, c) {
+				if row.fraud {
+					tp++
+				} else {
+					fp++
+				}
+			}
+		}
+		s := cutoffScore{value: c, precision: ratio(tp, tp+fp), recall: ratio(tp, r.Fraud)}
+		s.f1 = f1(s.precision, s.recall)
+		return s
+	}
+
+	values := make([]float64, len(rows))
+	for i, row := range rows {
+		values[i] = row.value
+	}
+	sort.Float64s(values)
+	step := max(1, len(values)/maxCutoffCandidates)
+
+	now := score(current)
+	best := now
+	for i := 0; i < len(values); i += step {
+		if i > 0 && values[i] == values[i-1] {
+			continue
+		}
+		if s := score(values[i]); s.f1 > best.f1 {
+			best = s
+		}
+	}
+	if best.f1 < now.f1+thresholdStep {
+		return
+	}
+	r.Suggestions = append(r.Suggestions, BacktestSuggestion{
+		Rule:      rule,
+		Setting:   setting,
+		Current:   current,
+		Suggested: best.value,
+		Precision: best.precision,
+		Recall:    best.recall,
+		Reason:    fmt.Sprintf("F1 %.2f against %.2f on %d labeled transactions", best.f1, now.f1, len(rows)),
+	})
+}
+
+// cutoff returns how a numeric threshold condition fires for a value and a
+// candidate threshold, or nil when the condition has no threshold to tune
+func (cc *compiledCondition) cutoff() func(v, c float64) bool {
+	if cc.kind != kindNumber {
+		return nil
+	}
+	switch cc.op {
+	case "gt":
+		return func(v, c float64) bool { return v > c }
+	case "gte":
+		return func(v, c float64) bool { return v >= c }
+	case "lt":
+		return func(v, c float64) bool { return v < c }
+	case "lte":
+		return func(v, c float64) bool { return v <= c }
+	}
+	return nil
+}
+
+// conditionValue returns the value a condition sees for a transaction when
+// every other condition of the rule holds
+func (r *DSLRule) conditionValue(i int, item ReplayItem) (float64, bool) {
+	env := &ruleEnv{txn: item.Transaction, ctx: item.Context, geofence: r.geofence}
+	values := make(map[string]interface{})
+	for j, c := range r.conditions {
+		if j != i && !c.match(env, values) {
+			return 0, false
+		}
+	}
+	v, ok := r.conditions[i].get(env)
+	if !ok {
+		return 0, false
+	}
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func (r *BacktestReport) catchRate(caught decimal.Decimal) float64 {
+	if r.FraudAmount.IsZero() {
+		return 0
+	}
+	return caught.Div(r.FraudAmount).InexactFloat64()
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+func f1(precision, recall float64) float64 {
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+// GetBacktest returns a stored back-test report
+func (d *Detector) GetBacktest(id string) (*BacktestReport, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, r := range d.backtests {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// ListBacktests returns the stored back-test reports, newest first
+func (d *Detector) ListBacktests() []*BacktestReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	reports := make([]*BacktestReport, len(d.backtests))
+	for i, r := range d.backtests {
+		reports[len(d.backtests)-1-i] = r
+	}
+	return reports
+}
@@ -0,0 +1,208 @@
+package fraud
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestBacktest(t *testing.T) {
+	detector := NewDetector(&config.FraudConfig{
+		Enabled:         true,
+		ScoreThreshold:  0.7,
+		VelocityWindow:  time.Hour,
+		MaxDailyAmount:  100000,
+		MaxSingleAmount: 5000,
+	})
+
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	amounts := map[string]string{
+		"f1": "6100.25", "f2": "7200.5", "f3": "8300.75", "f4": "9400.1",
+		"l1": "1500.3", "l2": "2000.4", "l3": "120.7", "l4": "80.2", "l5": "45.9", "u1": "300.6",
+	}
+	var items []ReplayItem
+	i := 0
+	for id, amount := range amounts {
+		items = append(items, ReplayItem{Transaction: &models.Transaction{
+			ID:            id,
+			SourceAccount: "acc-" + id,
+			Amount:        decimal.RequireFromString(amount),
+			CreatedAt:     start.Add(time.Duration(i) * time.Minute),
+		}})
+		i++
+	}
+
+	// Two dispositions come from resolved alerts, the rest from the caller
+	detector.alerts["a1"] = &models.FraudAlert{ID: "a1", TransactionID: "f1", Status: models.AlertStatusResolved}
+	detector.alerts["a2"] = &models.FraudAlert{ID: "a2", TransactionID: "l1", Status: models.AlertStatusFalsePos}
+	labels := map[string]bool{"f2": true, "f3": true, "f4": true, "l2": false, "l3": false, "l4": false, "l5": false}
+
+	report, err := detector.Backtest(BacktestConfig{
+		Rules: []models.ComplianceRule{{
+			ID:         "large",
+			Enabled:    true,
+			DryRun:     true,
+			Conditions: []models.RuleCondition{{Field: "amount", Operator: "gt", Value: 1000}},
+			Actions:    []models.RuleAction{{Type: RuleActionScoreAdjust, Parameters: map[string]interface{}{"score": 1}}},
+		}},
+	}, items, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Transactions != 10 || report.Labeled != 9 || report.Fraud != 4 || !report.FraudAmount.Equal(decimal.RequireFromString("31001.6")) {
+		t.Fatalf("unexpected totals %+v", report)
+	}
+	if report.Baseline.Alerts != 0 || report.Baseline.Recall != 0 || report.Baseline.FalseNegatives != 4 {
+		t.Errorf("unexpected baseline %+v", report.Baseline)
+	}
+	if report.Candidate.Alerts != 0 {
+		t.Errorf("expected no alerts at the live threshold, got %+v", report.Candidate)
+	}
+
+	var large *RuleBacktest
+	for i := range report.Rules {
+		if report.Rules[i].Rule == "large" {
+			large = &report.Rules[i]
+		}
+	}
+	if large == nil || large.Source != RuleSourceData || large.Triggered != 6 || large.TruePositives != 4 || large.FalsePositives != 2 {
+		t.Fatalf("unexpected rule metrics %+v", large)
+	}
+	if math.Abs(large.Precision-2.0/3) > 1e-9 || large.Recall != 1 || large.CatchRate != 1 {
+		t.Errorf("unexpected rule rates %+v", large)
+	}
+
+	suggested := map[string]BacktestSuggestion{}
+	for _, s := range report.Suggestions {
+		suggested[s.Setting] = s
+	}
+	threshold, ok := suggested["score_threshold"]
+	if !ok || math.Abs(threshold.Suggested.(float64)-0.55) > 1e-9 || threshold.Precision != 1 || threshold.Recall != 1 {
+		t.Errorf("expected the highest threshold with perfect F1, got %+v", threshold)
+	}
+	condition, ok := suggested["conditions[0].value (amount gt)"]
+	if !ok || condition.Rule != "large" || condition.Suggested.(float64) != 2000.4 {
+		t.Errorf("expected the amount condition to be raised, got %+v", report.Suggestions)
+	}
+	if _, ok := suggested["max_single_amount"]; ok {
+		t.Error("the amount limit already separates the labels")
+	}
+
+	for _, p := range report.Thresholds {
+		if math.Abs(p.ScoreThreshold-0.5) < 1e-9 && (p.Alerts != 4 || p.Precision != 1 || p.CatchRate != 1) {
+			t.Errorf("unexpected sweep point %+v", p)
+		}
+	}
+
+	if got, ok := detector.GetBacktest(report.ID); !ok || got != report || len(detector.ListBacktests()) != 1 {
+		t.Error("expected the report to be stored")
+	}
+	if _, err := detector.Backtest(BacktestConfig{DisabledRules: []string{"nope"}}, items, nil); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected an unknown rule error, got %v", err)
+	}
+}
+
+func TestBacktestReplaysVelocity(t *testing.T) {
+	detector := NewDetector(&config.FraudConfig{
+		Enabled:         true,
+		ScoreThreshold:  0.7,
+		VelocityWindow:  time.Hour,
+		MaxDailyAmount:  100000,
+		MaxSingleAmount: 5000,
+	})
+
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	var items []ReplayItem
+	for i, offset := range []time.Duration{0, 10 * time.Minute, 20 * time.Minute, 30 * time.Minute, 3 * time.Hour} {
+		items = append(items, ReplayItem{Transaction: &models.Transaction{
+			ID:            fmt.Sprintf("t%d", i),
+			SourceAccount: "acc-1",
+			Amount:        decimal.NewFromInt(3000),
+			CreatedAt:     start.Add(offset),
+		}})
+	}
+
+	velocityHits := func(maxDaily float64) int {
+		report, err := detector.Backtest(BacktestConfig{MaxDailyAmount: maxDaily}, items, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range report.Rules {
+			if r.Rule == "velocity" {
+				return r.Triggered
+			}
+		}
+		return 0
+	}
+
+	// The third and fourth transactions follow 6000 and 9000 within the
+	// hour; the last follows nothing recent
+	if got := velocityHits(5000); got != 2 {
+		t.Errorf("expected the lower daily limit to trigger twice, got %d", got)
+	}
+	if got := velocityHits(100000); got != 0 {
+		t.Errorf("expected no velocity hits at the live limit, got %d", got)
+	}
+}
+
+func TestBacktestSuggestsDisablingNoisyRules(t *testing.T) {
+	detector := NewDetector(&config.FraudConfig{
+		Enabled:         true,
+		ScoreThreshold:  0.7,
+		VelocityWindow:  time.Hour,
+		MaxDailyAmount:  100000,
+		MaxSingleAmount: 5000,
+	})
+
+	// One fraud among twenty transactions, caught by the amount limit too
+	var items []ReplayItem
+	labels := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("t%d", i)
+		amount := decimal.NewFromFloat(10.5 + float64(i))
+		if i == 0 {
+			amount = decimal.RequireFromString("6001.1")
+		}
+		items = append(items, ReplayItem{Transaction: &models.Transaction{
+			ID:            id,
+			SourceAccount: id,
+			Amount:        amount,
+			Currency:      "USD",
+			CreatedAt:     time.Date(2024, 3, 4, 12, i, 0, 0, time.UTC),
+		}})
+		labels[id] = i == 0
+	}
+
+	report, err := detector.Backtest(BacktestConfig{
+		Rules: []models.ComplianceRule{{
+			ID:         "usd",
+			Enabled:    true,
+			Conditions: []models.RuleCondition{{Field: "currency", Operator: "eq", Value: "USD"}},
+			Actions:    []models.RuleAction{{Type: RuleActionHold}},
+		}},
+	}, items, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hold raises an alert regardless of the score
+	if report.Candidate.Alerts != 20 || report.Candidate.Precision != 0.05 || report.Candidate.Recall != 1 {
+		t.Errorf("unexpected candidate metrics %+v", report.Candidate)
+	}
+	found := false
+	for _, s := range report.Suggestions {
+		if s.Rule == "usd" && s.Setting == "enabled" && s.Suggested == false {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the noisy rule to be suggested for removal, got %+v", report.Suggestions)
+	}
+}
@@ -2,10 +2,12 @@ package fraud
 
 import (
 	"context"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/internal/fx"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
 )
@@ -18,6 +20,12 @@ type Detector struct {
 	velocity   *VelocityTracker
 	patterns   *PatternAnalyzer
 	geofence   *GeofenceChecker
+	converter  *fx.Converter
+	scorer     *ModelScorer
+	dsl        *RuleStore
+	devices    *DeviceTracker
+	geoip      *GeoIPDatabase
+	backtests  []*BacktestReport
 	mu         sync.RWMutex
 	running    bool
 	stopCh     chan struct{}
@@ -37,6 +45,7 @@ type RuleResult struct {
 	Score       float64
 	Indicators  []models.FraudIndicator
 	Description string
+	Actions     []models.RuleAction
 }
 
 // EvaluationContext provides context for rule evaluation
@@ -76,6 +85,9 @@ type DeviceInfo struct {
 	IsKnown     bool
 	IsTrusted   bool
 	FirstSeen   time.Time
+	LastSeen    time.Time
+	// AccountCount is the number of accounts that have used the device
+	AccountCount int
 }
 
 // GeoLocation contains geolocation data
@@ -95,24 +107,42 @@ func NewDetector(cfg *config.FraudConfig) *Detector {
 		velocity: NewVelocityTracker(cfg.VelocityWindow),
 		patterns: NewPatternAnalyzer(),
 		geofence: NewGeofenceChecker(),
+		devices:  NewDeviceTracker(cfg.DeviceTrustAge),
 		stopCh:   make(chan struct{}),
 		alertCh:  make(chan *models.FraudAlert, 100),
 	}
+	d.scorer = NewModelScorer(DefaultFeaturePipeline(d.geofence), cfg.MLModelWeight)
+	d.dsl = NewRuleStore(cfg.RulesPath, d.geofence, d.velocity)
 	d.initializeRules()
 	return d
 }
 
 func (d *Detector) initializeRules() {
-	d.rules = []Rule{
-		NewAmountRule(d.config.MaxSingleAmount),
-		NewVelocityRule(d.config.VelocityWindow, d.config.MaxDailyAmount),
+	d.rules = d.builtinRules(d.config.MaxSingleAmount, d.config.MaxDailyAmount)
+}
+
+// builtinRules builds the coded rules with the given amount limits
+func (d *Detector) builtinRules(maxSingleAmount, maxDailyAmount float64) []Rule {
+	return []Rule{
+		NewAmountRule(maxSingleAmount),
+		NewVelocityRule(d.config.VelocityWindow, maxDailyAmount),
 		NewGeolocationRule(d.geofence),
 		NewPatternRule(d.patterns),
 		NewTimeRule(),
 		NewMerchantRule(),
+		NewDeviceRule(d.config.NewDeviceAmount, d.config.NewDeviceWindow),
+		NewDeviceSharingRule(d.config.MaxAccountsPerDevice),
 	}
 }
 
+// SetConverter sets the FX converter used to express amounts in the base
+// currency before amount and velocity limits are checked
+func (d *Detector) SetConverter(c *fx.Converter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.converter = c
+}
+
 // Start starts the fraud detector
 func (d *Detector) Start(ctx context.Context) error {
 	d.mu.Lock()
@@ -166,23 +196,36 @@ func (d *Detector) Evaluate(txn *models.Transaction, evalCtx *EvaluationContext)
 		Timestamp:     time.Now(),
 	}
 
-	var totalScore float64
-	var indicators []models.FraudIndicator
-
-	// Evaluate all rules
-	for _, rule := range d.rules {
-		ruleResult := rule.Evaluate(txn, evalCtx)
-		if ruleResult.Triggered {
-			totalScore += ruleResult.Score
-			indicators = append(indicators, ruleResult.Indicators...)
+	d.mu.RLock()
+	converter := d.converter
+	d.mu.RUnlock()
+	if converter != nil {
+		if err := converter.Apply(txn); err != nil {
+			log.Printf("fraud: FX conversion failed for %s: %v", txn.ID, err)
 		}
 	}
 
+	// Fill device history and IP geolocation
+	evalCtx = d.enrichContext(txn, evalCtx)
+
+	// Evaluate all rules, then the rules authored as data
+	run := runRules(append(d.rules[:len(d.rules):len(d.rules)], d.dsl.Active()...), txn, evalCtx)
+	indicators := run.indicators
+	actions := run.actions
+
 	// Normalize score to 0-1 range
-	result.RiskScore = normalizeScore(totalScore)
+	result.RiskScore = normalizeScore(run.score)
+
+	// Blend in the champion model; challengers are scored in shadow
+	if score := d.scorer.Score(txn, evalCtx, result.RiskScore, d.decide); score != nil {
+		indicators = append(indicators, d.scorer.Indicator(score, result.RiskScore))
+		result.RiskScore = score.RiskScore
+		result.Model = score
+	}
 	result.Indicators = indicators
 
 	// Make decision based on score
+	alerted := true
 	if result.RiskScore >= d.config.ScoreThreshold {
 		result.Decision = DecisionBlock
 		result.Reason = "High risk score exceeds threshold"
@@ -200,14 +243,47 @@ func (d *Detector) Evaluate(txn *models.Transaction, evalCtx *EvaluationContext)
 		d.alertCh <- alert
 	} else {
 		result.Decision = DecisionAllow
+		alerted = false
 	}
 
-	// Update velocity tracker
+	d.applyRuleActions(txn, result, actions, alerted)
+
+	// Update velocity and device history
 	d.velocity.Record(txn)
+	if device := deviceOf(txn, evalCtx); device != nil {
+		d.devices.Record(txn.SourceAccount, device, deviceTime(txn))
+	}
 
 	return result
 }
 
+// ruleRun is the outcome of evaluating a rule set against a transaction
+type ruleRun struct {
+	score      float64
+	indicators []models.FraudIndicator
+	actions    []ruleAction
+	triggered  []string
+}
+
+// runRules evaluates rules in order and collects the actions of those that
+// trigger; the caller decides whether to apply them. Live data rules in dry
+// run still report their hits to the rule store.
+func runRules(rules []Rule, txn *models.Transaction, evalCtx *EvaluationContext) *ruleRun {
+	run := &ruleRun{}
+	for _, rule := range rules {
+		ruleResult := rule.Evaluate(txn, evalCtx)
+		if ruleResult.Triggered {
+			run.score += ruleResult.Score
+			run.indicators = append(run.indicators, ruleResult.Indicators...)
+			run.triggered = append(run.triggered, rule.Name())
+			for _, a := range ruleResult.Actions {
+				run.actions = append(run.actions, ruleAction{rule: rule.Name(), action: a})
+			}
+		}
+	}
+	return run
+}
+
 // EvaluationResult contains the fraud evaluation result
 type EvaluationResult struct {
 	TransactionID string                   `json:"transaction_id"`
@@ -215,6 +291,8 @@ type EvaluationResult struct {
 	Decision      Decision                 `json:"decision"`
 	Reason        string                   `json:"reason,omitempty"`
 	Indicators    []models.FraudIndicator  `json:"indicators,omitempty"`
+	Model         *ModelScore              `json:"model,omitempty"`
+	Flags         []string                 `json:"flags,omitempty"`
 	Timestamp     time.Time                `json:"timestamp"`
 }
 
@@ -225,6 +303,7 @@ const (
 	DecisionAllow  Decision = "allow"
 	DecisionBlock  Decision = "block"
 	DecisionReview Decision = "review"
+	DecisionHold   Decision = "hold"
 )
 
 func (d *Detector) createAlert(txn *models.Transaction, result *EvaluationResult) *models.FraudAlert {
@@ -399,7 +478,10 @@ func randomString(n int) string {
 
 // Errors
 var (
-	ErrAlertNotFound = &Error{Code: "ALERT_NOT_FOUND", Message: "Alert not found"}
+	ErrAlertNotFound  = &Error{Code: "ALERT_NOT_FOUND", Message: "Alert not found"}
+	ErrModelNotFound  = &Error{Code: "MODEL_NOT_FOUND", Message: "Model not found"}
+	ErrRuleNotFound   = &Error{Code: "RULE_NOT_FOUND", Message: "Rule not found"}
+	ErrDeviceNotFound = &Error{Code: "DEVICE_NOT_FOUND", Message: "Device not found"}
 )
 
 // Error represents a fraud detection error
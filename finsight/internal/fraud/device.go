@@ -0,0 +1,341 @@
+package fraud
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Transaction metadata keys read when the evaluation context carries no
+// device or IP address, such as for ingested transactions
+const (
+	MetaDeviceID  = "device_id"
+	MetaIPAddress = "ip_address"
+)
+
+// DeviceTrust is an explicit trust decision for a device on an account
+type DeviceTrust string
+
+const (
+	// DeviceTrustAuto trusts a device once it has been used for the trust age
+	DeviceTrustAuto      DeviceTrust = ""
+	DeviceTrustTrusted   DeviceTrust = "trusted"
+	DeviceTrustUntrusted DeviceTrust = "untrusted"
+)
+
+// DeviceTracker maintains the history of devices per account: when each
+// account first used a device, whether it is trusted and how many accounts
+// share it
+type DeviceTracker struct {
+	trustAge time.Duration
+	devices  map[string]*deviceRecord
+	accounts map[string]map[string]bool // account ID -> device IDs
+	mu       sync.RWMutex
+}
+
+type deviceRecord struct {
+	info      DeviceInfo
+	firstSeen time.Time
+	lastSeen  time.Time
+	accounts  map[string]*DeviceUsage
+}
+
+// DeviceUsage is the use of a device by one account
+type DeviceUsage struct {
+	AccountID    string      `json:"account_id"`
+	FirstSeen    time.Time   `json:"first_seen"`
+	LastSeen     time.Time   `json:"last_seen"`
+	Transactions int         `json:"transactions"`
+	Trust        DeviceTrust `json:"trust,omitempty"`
+	IsTrusted    bool        `json:"is_trusted"`
+}
+
+// DeviceSummary describes a device and the accounts using it
+type DeviceSummary struct {
+	DeviceID   string         `json:"device_id"`
+	DeviceType string         `json:"device_type,omitempty"`
+	OS         string         `json:"os,omitempty"`
+	Browser    string         `json:"browser,omitempty"`
+	FirstSeen  time.Time      `json:"first_seen"`
+	LastSeen   time.Time      `json:"last_seen"`
+	Accounts   []*DeviceUsage `json:"accounts"`
+}
+
+// NewDeviceTracker creates a device tracker. Devices used by an account for
+// at least trustAge are trusted unless marked otherwise; zero disables
+// automatic trust.
+func NewDeviceTracker(trustAge time.Duration) *DeviceTracker {
+	return &DeviceTracker{
+		trustAge: trustAge,
+		devices:  make(map[string]*deviceRecord),
+		accounts: make(map[string]map[string]bool),
+	}
+}
+
+// Describe returns the device as seen by an account at the given time,
+// without recording it. The reported attributes are kept; history fills
+// IsKnown, IsTrusted, FirstSeen, LastSeen and AccountCount, which counts
+// this account.
+func (t *DeviceTracker) Describe(accountID string, device *DeviceInfo, at time.Time) *DeviceInfo {
+	info := *device
+	info.IsKnown = false
+	info.IsTrusted = false
+	info.FirstSeen = at
+	info.LastSeen = time.Time{}
+	info.AccountCount = 1
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rec, ok := t.devices[device.DeviceID]
+	if !ok {
+		return &info
+	}
+	usage, used := rec.accounts[accountID]
+	info.AccountCount = len(rec.accounts)
+	if !used {
+		info.AccountCount++
+		return &info
+	}
+	info.IsKnown = true
+	info.FirstSeen = usage.FirstSeen
+	info.LastSeen = usage.LastSeen
+	info.IsTrusted = t.trusted(usage, at)
+	return &info
+}
+
+// Record records the use of a device by an account
+func (t *DeviceTracker) Record(accountID string, device *DeviceInfo, at time.Time) {
+	if device == nil || device.DeviceID == "" || accountID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, ok := t.devices[device.DeviceID]
+	if !ok {
+		rec = &deviceRecord{
+			info:      DeviceInfo{DeviceID: device.DeviceID},
+			firstSeen: at,
+			accounts:  make(map[string]*DeviceUsage),
+		}
+		t.devices[device.DeviceID] = rec
+	}
+	// Keep the latest reported attributes
+	if device.DeviceType != "" {
+		rec.info.DeviceType = device.DeviceType
+	}
+	if device.OS != "" {
+		rec.info.OS = device.OS
+	}
+	if device.Browser != "" {
+		rec.info.Browser = device.Browser
+	}
+	if at.Before(rec.firstSeen) {
+		rec.firstSeen = at
+	}
+	if at.After(rec.lastSeen) {
+		rec.lastSeen = at
+	}
+
+	usage, ok := rec.accounts[accountID]
+	if !ok {
+		usage = &DeviceUsage{AccountID: accountID, FirstSeen: at}
+		rec.accounts[accountID] = usage
+		if t.accounts[accountID] == nil {
+			t.accounts[accountID] = make(map[string]bool)
+		}
+		t.accounts[accountID][device.DeviceID] = true
+	}
+	if at.Before(usage.FirstSeen) {
+		usage.FirstSeen = at
+	}
+	if at.After(usage.LastSeen) {
+		usage.LastSeen = at
+	}
+	usage.Transactions++
+}
+
+// SetTrust records an explicit trust decision for a device on an account
+func (t *DeviceTracker) SetTrust(deviceID, accountID string, trust DeviceTrust) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, ok := t.devices[deviceID]
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	usage, ok := rec.accounts[accountID]
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	usage.Trust = trust
+	return nil
+}
+
+// GetDevice returns a device and the accounts using it
+func (t *DeviceTracker) GetDevice(deviceID string) (*DeviceSummary, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rec, ok := t.devices[deviceID]
+	if !ok {
+		return nil, false
+	}
+	return t.summary(rec, time.Now()), true
+}
+
+// AccountDevices returns the devices an account has used, most recently
+// used first
+func (t *DeviceTracker) AccountDevices(accountID string) []*DeviceSummary {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	var results []*DeviceSummary
+	for id := range t.accounts[accountID] {
+		results = append(results, t.summary(t.devices[id], now))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].LastSeen.Equal(results[j].LastSeen) {
+			return results[i].LastSeen.After(results[j].LastSeen)
+		}
+		return results[i].DeviceID < results[j].DeviceID
+	})
+	return results
+}
+
+// SharedDevices returns the devices used by at least minAccounts accounts,
+// most shared first
+func (t *DeviceTracker) SharedDevices(minAccounts int) []*DeviceSummary {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	var results []*DeviceSummary
+	for _, rec := range t.devices {
+		if len(rec.accounts) >= minAccounts {
+			results = append(results, t.summary(rec, now))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if len(results[i].Accounts) != len(results[j].Accounts) {
+			return len(results[i].Accounts) > len(results[j].Accounts)
+		}
+		return results[i].DeviceID < results[j].DeviceID
+	})
+	return results
+}
+
+func (t *DeviceTracker) summary(rec *deviceRecord, now time.Time) *DeviceSummary {
+	s := &DeviceSummary{
+		DeviceID:   rec.info.DeviceID,
+		DeviceType: rec.info.DeviceType,
+		OS:         rec.info.OS,
+		Browser:    rec.info.Browser,
+		FirstSeen:  rec.firstSeen,
+		LastSeen:   rec.lastSeen,
+	}
+	for _, usage := range rec.accounts {
+		u := *usage
+		u.IsTrusted = t.trusted(usage, now)
+		s.Accounts = append(s.Accounts, &u)
+	}
+	sort.Slice(s.Accounts, func(i, j int) bool {
+		return s.Accounts[i].FirstSeen.Before(s.Accounts[j].FirstSeen)
+	})
+	return s
+}
+
+func (t *DeviceTracker) trusted(usage *DeviceUsage, at time.Time) bool {
+	switch usage.Trust {
+	case DeviceTrustTrusted:
+		return true
+	case DeviceTrustUntrusted:
+		return false
+	}
+	return t.trustAge > 0 && at.Sub(usage.FirstSeen) >= t.trustAge
+}
+
+// enrichContext fills the device history and IP geolocation of an
+// evaluation context, falling back to the device ID and IP address in the
+// transaction metadata. The caller's context is not modified.
+func (d *Detector) enrichContext(txn *models.Transaction, evalCtx *EvaluationContext) *EvaluationContext {
+	device := deviceOf(txn, evalCtx)
+	ip := ""
+	if evalCtx != nil && evalCtx.GeoLocation != nil {
+		ip = evalCtx.GeoLocation.IPAddress
+	}
+	if ip == "" {
+		ip = txn.Metadata[MetaIPAddress]
+	}
+
+	d.mu.RLock()
+	geoip := d.geoip
+	d.mu.RUnlock()
+	needsGeo := ip != "" && geoip != nil && (evalCtx == nil || evalCtx.GeoLocation == nil || evalCtx.GeoLocation.Country == "")
+	if device == nil && !needsGeo {
+		return evalCtx
+	}
+
+	enriched := &EvaluationContext{}
+	if evalCtx != nil {
+		*enriched = *evalCtx
+	}
+	if device != nil {
+		enriched.DeviceInfo = d.devices.Describe(txn.SourceAccount, device, deviceTime(txn))
+	}
+	if needsGeo {
+		geo, err := geoip.LookupString(ip)
+		if err == nil && geo != nil {
+			enriched.GeoLocation = geo
+		}
+	}
+	return enriched
+}
+
+// deviceOf returns the device a transaction was made from, if known
+func deviceOf(txn *models.Transaction, evalCtx *EvaluationContext) *DeviceInfo {
+	if evalCtx != nil && evalCtx.DeviceInfo != nil && evalCtx.DeviceInfo.DeviceID != "" {
+		return evalCtx.DeviceInfo
+	}
+	if id := txn.Metadata[MetaDeviceID]; id != "" {
+		return &DeviceInfo{DeviceID: id}
+	}
+	return nil
+}
+
+// deviceTime is the time a device was used for a transaction
+func deviceTime(txn *models.Transaction) time.Time {
+	if txn.CreatedAt.IsZero() {
+		return time.Now()
+	}
+	return txn.CreatedAt
+}
+
+// Devices returns the detector's device tracker
+func (d *Detector) Devices() *DeviceTracker {
+	return d.devices
+}
+
+// LoadGeoIP loads a MaxMind DB file used to locate IP addresses
+func (d *Detector) LoadGeoIP(path string) (*GeoIPDatabase, error) {
+	db, err := OpenGeoIPDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.geoip = db
+	d.mu.Unlock()
+	return db, nil
+}
+
+// GeoIP returns the loaded GeoIP database, or nil
+func (d *Detector) GeoIP() *GeoIPDatabase {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.geoip
+}
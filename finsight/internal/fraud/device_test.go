@@ -0,0 +1,165 @@
+package fraud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestDeviceTracker_DescribeAndRecord(t *testing.T) {
+	tracker := NewDeviceTracker(7 * 24 * time.Hour)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	device := &DeviceInfo{DeviceID: "dev-1", OS: "iOS"}
+
+	info := tracker.Describe("acc-1", device, start)
+	if info.IsKnown || info.IsTrusted || info.AccountCount != 1 || !info.FirstSeen.Equal(start) {
+		t.Errorf("unexpected new device: %+v", info)
+	}
+	if info.OS != "iOS" {
+		t.Errorf("reported attributes not kept: %+v", info)
+	}
+
+	tracker.Record("acc-1", device, start)
+	info = tracker.Describe("acc-1", device, start.Add(time.Hour))
+	if !info.IsKnown || info.IsTrusted || !info.FirstSeen.Equal(start) || !info.LastSeen.Equal(start) {
+		t.Errorf("unexpected known device: %+v", info)
+	}
+
+	// Trusted automatically after the trust age
+	info = tracker.Describe("acc-1", device, start.Add(8*24*time.Hour))
+	if !info.IsTrusted {
+		t.Error("device should be trusted after the trust age")
+	}
+
+	// Another account sees the device as new, but shared
+	info = tracker.Describe("acc-2", device, start.Add(time.Hour))
+	if info.IsKnown || info.AccountCount != 2 {
+		t.Errorf("unexpected device for second account: %+v", info)
+	}
+	tracker.Record("acc-2", device, start.Add(time.Hour))
+
+	shared := tracker.SharedDevices(2)
+	if len(shared) != 1 || len(shared[0].Accounts) != 2 || shared[0].OS != "iOS" {
+		t.Fatalf("unexpected shared devices: %+v", shared)
+	}
+	if devices := tracker.AccountDevices("acc-2"); len(devices) != 1 || devices[0].DeviceID != "dev-1" {
+		t.Errorf("unexpected account devices: %+v", devices)
+	}
+}
+
+func TestDeviceTracker_SetTrust(t *testing.T) {
+	tracker := NewDeviceTracker(time.Hour)
+	now := time.Now()
+	device := &DeviceInfo{DeviceID: "dev-1"}
+	tracker.Record("acc-1", device, now.Add(-2*time.Hour))
+
+	if err := tracker.SetTrust("dev-1", "acc-1", DeviceTrustUntrusted); err != nil {
+		t.Fatalf("SetTrust failed: %v", err)
+	}
+	if tracker.Describe("acc-1", device, now).IsTrusted {
+		t.Error("untrusted device should not be trusted automatically")
+	}
+	if err := tracker.SetTrust("dev-1", "acc-2", DeviceTrustTrusted); err != ErrDeviceNotFound {
+		t.Errorf("expected ErrDeviceNotFound for unused account, got %v", err)
+	}
+	if err := tracker.SetTrust("dev-2", "acc-1", DeviceTrustTrusted); err != ErrDeviceNotFound {
+		t.Errorf("expected ErrDeviceNotFound for unknown device, got %v", err)
+	}
+}
+
+func TestDeviceRule(t *testing.T) {
+	rule := NewDeviceRule(1000, 24*time.Hour)
+	now := time.Now()
+	txn := &models.Transaction{Amount: decimal.NewFromInt(1500), CreatedAt: now}
+
+	tests := []struct {
+		name      string
+		device    *DeviceInfo
+		triggered bool
+	}{
+		{"no device", nil, false},
+		{"new device", &DeviceInfo{DeviceID: "d", FirstSeen: now}, true},
+		{"recently seen", &DeviceInfo{DeviceID: "d", IsKnown: true, FirstSeen: now.Add(-time.Hour)}, true},
+		{"established", &DeviceInfo{DeviceID: "d", IsKnown: true, FirstSeen: now.Add(-48 * time.Hour)}, false},
+		{"trusted", &DeviceInfo{DeviceID: "d", IsTrusted: true, FirstSeen: now}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := rule.Evaluate(txn, &EvaluationContext{DeviceInfo: tt.device})
+			if result.Triggered != tt.triggered {
+				t.Errorf("triggered = %v, want %v", result.Triggered, tt.triggered)
+			}
+		})
+	}
+
+	small := &models.Transaction{Amount: decimal.NewFromInt(50), CreatedAt: now}
+	if rule.Evaluate(small, &EvaluationContext{DeviceInfo: &DeviceInfo{DeviceID: "d", FirstSeen: now}}).Triggered {
+		t.Error("low-value transaction should not trigger")
+	}
+}
+
+func TestDeviceSharingRule(t *testing.T) {
+	rule := NewDeviceSharingRule(3)
+	txn := &models.Transaction{Amount: decimal.NewFromInt(10)}
+
+	if rule.Evaluate(txn, &EvaluationContext{DeviceInfo: &DeviceInfo{DeviceID: "d", AccountCount: 3}}).Triggered {
+		t.Error("device within the limit should not trigger")
+	}
+	result := rule.Evaluate(txn, &EvaluationContext{DeviceInfo: &DeviceInfo{DeviceID: "d", AccountCount: 5}})
+	if !result.Triggered || result.Score != 2.5 {
+		t.Errorf("expected score 2.5 for two accounts over the limit, got %+v", result)
+	}
+	if result.Indicators[0].Type != "device" {
+		t.Errorf("unexpected indicator type %q", result.Indicators[0].Type)
+	}
+}
+
+func TestDetector_EvaluateTracksDevices(t *testing.T) {
+	detector := NewDetector(&config.FraudConfig{
+		Enabled:              true,
+		ScoreThreshold:       0.7,
+		VelocityWindow:       time.Hour,
+		MaxDailyAmount:       100000,
+		MaxSingleAmount:      50000,
+		NewDeviceAmount:      1000,
+		NewDeviceWindow:      24 * time.Hour,
+		MaxAccountsPerDevice: 2,
+	})
+
+	now := time.Now()
+	evaluate := func(id, account string, amount int64) *EvaluationResult {
+		txn := &models.Transaction{
+			ID:            id,
+			SourceAccount: account,
+			Amount:        decimal.NewFromInt(amount),
+			Currency:      "USD",
+			CreatedAt:     now,
+			Metadata:      map[string]string{MetaDeviceID: "dev-1"},
+		}
+		return detector.Evaluate(txn, nil)
+	}
+	hasIndicator := func(result *EvaluationResult, description string) bool {
+		for _, ind := range result.Indicators {
+			if ind.Description == description {
+				return true
+			}
+		}
+		return false
+	}
+
+	if r := evaluate("t1", "acc-1", 2000); !hasIndicator(r, "High-value transaction from a new device") {
+		t.Errorf("expected new device indicator, got %+v", r.Indicators)
+	}
+	evaluate("t2", "acc-2", 10)
+	if r := evaluate("t3", "acc-3", 10); !hasIndicator(r, "Device used by multiple accounts") {
+		t.Errorf("expected device sharing indicator, got %+v", r.Indicators)
+	}
+
+	device, ok := detector.Devices().GetDevice("dev-1")
+	if !ok || len(device.Accounts) != 3 {
+		t.Fatalf("expected device used by 3 accounts, got %+v", device)
+	}
+}
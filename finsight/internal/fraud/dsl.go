@@ -0,0 +1,838 @@
+package fraud
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Rule action types
+const (
+	RuleActionHold        = "hold"
+	RuleActionFlag        = "flag"
+	RuleActionAlert       = "alert"
+	RuleActionScoreAdjust = "score_adjust"
+)
+
+type fieldKind int
+
+const (
+	kindNumber fieldKind = iota
+	kindString
+	kindBool
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindNumber:
+		return "number"
+	case kindString:
+		return "string"
+	default:
+		return "bool"
+	}
+}
+
+// ruleEnv is what a compiled rule evaluates against
+type ruleEnv struct {
+	txn      *models.Transaction
+	ctx      *EvaluationContext
+	geofence *GeofenceChecker
+	tracker  *VelocityTracker
+	records  []*TransactionRecord
+	loaded   bool
+}
+
+// history returns the account's transactions, including the current one,
+// from the evaluation context or, failing that, the velocity tracker
+func (e *ruleEnv) history() []*TransactionRecord {
+	if e.loaded {
+		return e.records
+	}
+	e.loaded = true
+
+	seen := map[string]bool{}
+	add := func(r *TransactionRecord) {
+		if r.ID != "" {
+			if seen[r.ID] {
+				return
+			}
+			seen[r.ID] = true
+		}
+		e.records = append(e.records, r)
+	}
+	add(transactionRecord(e.txn))
+	if e.ctx != nil && e.ctx.AccountHistory != nil {
+		for _, h := range e.ctx.AccountHistory {
+			add(transactionRecord(h))
+		}
+	} else if e.tracker != nil {
+		for _, r := range e.tracker.Records(e.txn.SourceAccount, time.Time{}) {
+			add(r)
+		}
+	}
+	return e.records
+}
+
+func transactionRecord(txn *models.Transaction) *TransactionRecord {
+	r := &TransactionRecord{ID: txn.ID, Amount: txn.ReportingAmount(), Timestamp: txn.CreatedAt}
+	if txn.Merchant != nil {
+		r.Location = txn.Merchant.Country
+		r.Merchant = txn.Merchant.ID
+	}
+	return r
+}
+
+type ruleField struct {
+	kind fieldKind
+	get  func(e *ruleEnv) (interface{}, bool)
+}
+
+func numberField(get func(e *ruleEnv) (float64, bool)) ruleField {
+	return ruleField{kindNumber, func(e *ruleEnv) (interface{}, bool) { return get(e) }}
+}
+
+func stringField(get func(e *ruleEnv) (string, bool)) ruleField {
+	return ruleField{kindString, func(e *ruleEnv) (interface{}, bool) {
+		v, ok := get(e)
+		return v, ok && v != ""
+	}}
+}
+
+func boolField(get func(e *ruleEnv) (bool, bool)) ruleField {
+	return ruleField{kindBool, func(e *ruleEnv) (interface{}, bool) { return get(e) }}
+}
+
+func merchantField(get func(m *models.Merchant) string) ruleField {
+	return stringField(func(e *ruleEnv) (string, bool) {
+		if e.txn.Merchant == nil {
+			return "", false
+		}
+		return get(e.txn.Merchant), true
+	})
+}
+
+func activityField(get func(a *ActivitySummary) float64) ruleField {
+	return numberField(func(e *ruleEnv) (float64, bool) {
+		if e.ctx == nil || e.ctx.RecentActivity == nil {
+			return 0, false
+		}
+		return get(e.ctx.RecentActivity), true
+	})
+}
+
+func profileField(kind fieldKind, get func(txn *models.Transaction, p *AccountProfile) (interface{}, bool)) ruleField {
+	return ruleField{kind, func(e *ruleEnv) (interface{}, bool) {
+		if e.ctx == nil || e.ctx.AccountProfile == nil {
+			return nil, false
+		}
+		return get(e.txn, e.ctx.AccountProfile)
+	}}
+}
+
+func deviceField(kind fieldKind, get func(d *DeviceInfo) interface{}) ruleField {
+	return ruleField{kind, func(e *ruleEnv) (interface{}, bool) {
+		if e.ctx == nil || e.ctx.DeviceInfo == nil {
+			return nil, false
+		}
+		return get(e.ctx.DeviceInfo), true
+	}}
+}
+
+func geoField(kind fieldKind, get func(e *ruleEnv, g *GeoLocation) (interface{}, bool)) ruleField {
+	return ruleField{kind, func(e *ruleEnv) (interface{}, bool) {
+		if e.ctx == nil || e.ctx.GeoLocation == nil {
+			return nil, false
+		}
+		return get(e, e.ctx.GeoLocation)
+	}}
+}
+
+func containsString(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, v := range values {
+			if v != "" && item == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleFields lists the fields rule conditions can reference. Fields of the
+// form metadata.<key> read transaction metadata.
+var ruleFields = map[string]ruleField{
+	"amount": numberField(func(e *ruleEnv) (float64, bool) {
+		return e.txn.ReportingAmount().InexactFloat64(), true
+	}),
+	"original_amount": numberField(func(e *ruleEnv) (float64, bool) {
+		return e.txn.Amount.InexactFloat64(), true
+	}),
+	"currency":       stringField(func(e *ruleEnv) (string, bool) { return e.txn.Currency, true }),
+	"type":           stringField(func(e *ruleEnv) (string, bool) { return string(e.txn.Type), true }),
+	"status":         stringField(func(e *ruleEnv) (string, bool) { return string(e.txn.Status), true }),
+	"category":       stringField(func(e *ruleEnv) (string, bool) { return e.txn.Category, true }),
+	"description":    stringField(func(e *ruleEnv) (string, bool) { return e.txn.Description, true }),
+	"source_account": stringField(func(e *ruleEnv) (string, bool) { return e.txn.SourceAccount, true }),
+	"dest_account":   stringField(func(e *ruleEnv) (string, bool) { return e.txn.DestAccount, true }),
+	"hour": numberField(func(e *ruleEnv) (float64, bool) {
+		return float64(e.txn.CreatedAt.Hour()), !e.txn.CreatedAt.IsZero()
+	}),
+	"day_of_week": numberField(func(e *ruleEnv) (float64, bool) {
+		return float64(e.txn.CreatedAt.Weekday()), !e.txn.CreatedAt.IsZero()
+	}),
+
+	"merchant.id":       merchantField(func(m *models.Merchant) string { return m.ID }),
+	"merchant.name":     merchantField(func(m *models.Merchant) string { return m.Name }),
+	"merchant.category": merchantField(func(m *models.Merchant) string { return m.Category }),
+	"merchant.mcc":      merchantField(func(m *models.Merchant) string { return m.MCC }),
+	"merchant.country":  merchantField(func(m *models.Merchant) string { return m.Country }),
+	"merchant.city":     merchantField(func(m *models.Merchant) string { return m.City }),
+	"merchant.high_risk": boolField(func(e *ruleEnv) (bool, bool) {
+		if e.txn.Merchant == nil {
+			return false, false
+		}
+		return highRiskMCCs[e.txn.Merchant.MCC], true
+	}),
+
+	"velocity.count":     activityField(func(a *ActivitySummary) float64 { return float64(a.TransactionCount) }),
+	"velocity.amount":    activityField(func(a *ActivitySummary) float64 { return a.TotalAmount.InexactFloat64() }),
+	"velocity.locations": activityField(func(a *ActivitySummary) float64 { return float64(a.UniqueLocations) }),
+	"velocity.merchants": activityField(func(a *ActivitySummary) float64 { return float64(a.UniqueMerchants) }),
+
+	"profile.avg_amount": profileField(kindNumber, func(_ *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		return p.AvgTransactionAmount.InexactFloat64(), true
+	}),
+	"profile.amount_ratio": profileField(kindNumber, func(txn *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		if !p.AvgTransactionAmount.IsPositive() {
+			return nil, false
+		}
+		return txn.ReportingAmount().Div(p.AvgTransactionAmount).InexactFloat64(), true
+	}),
+	"profile.avg_daily_transactions": profileField(kindNumber, func(_ *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		return p.AvgDailyTransactions, true
+	}),
+	"profile.risk_level": profileField(kindString, func(_ *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		return p.RiskLevel, p.RiskLevel != ""
+	}),
+	"profile.known_merchant": profileField(kindBool, func(txn *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		if txn.Merchant == nil {
+			return nil, false
+		}
+		return containsString(p.TypicalMerchants, txn.Merchant.ID, txn.Merchant.Name), true
+	}),
+	"profile.typical_hour": profileField(kindBool, func(txn *models.Transaction, p *AccountProfile) (interface{}, bool) {
+		if len(p.TypicalHours) == 0 {
+			return nil, false
+		}
+		for _, h := range p.TypicalHours {
+			if h == txn.CreatedAt.Hour() {
+				return true, true
+			}
+		}
+		return false, true
+	}),
+
+	"device.id":       deviceField(kindString, func(d *DeviceInfo) interface{} { return d.DeviceID }),
+	"device.type":     deviceField(kindString, func(d *DeviceInfo) interface{} { return d.DeviceType }),
+	"device.os":       deviceField(kindString, func(d *DeviceInfo) interface{} { return d.OS }),
+	"device.known":    deviceField(kindBool, func(d *DeviceInfo) interface{} { return d.IsKnown }),
+	"device.trusted":  deviceField(kindBool, func(d *DeviceInfo) interface{} { return d.IsTrusted }),
+	"device.accounts": deviceField(kindNumber, func(d *DeviceInfo) interface{} { return float64(d.AccountCount) }),
+
+	"geo.country": geoField(kindString, func(_ *ruleEnv, g *GeoLocation) (interface{}, bool) {
+		return g.Country, g.Country != ""
+	}),
+	"geo.city": geoField(kindString, func(_ *ruleEnv, g *GeoLocation) (interface{}, bool) {
+		return g.City, g.City != ""
+	}),
+	"geo.high_risk": geoField(kindBool, func(e *ruleEnv, g *GeoLocation) (interface{}, bool) {
+		if g.Country == "" || e.geofence == nil {
+			return nil, false
+		}
+		return e.geofence.IsHighRiskCountry(g.Country), true
+	}),
+	"geo.typical": geoField(kindBool, func(e *ruleEnv, g *GeoLocation) (interface{}, bool) {
+		if e.ctx.AccountProfile == nil || len(e.ctx.AccountProfile.TypicalLocations) == 0 {
+			return nil, false
+		}
+		return containsString(e.ctx.AccountProfile.TypicalLocations, g.Country, g.City), true
+	}),
+}
+
+// Aggregates over an account's transactions in a window. Count works with
+// any field; the distinct aggregate needs a field of the velocity record.
+var ruleAggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true, "distinct": true}
+
+var distinctFields = map[string]func(r *TransactionRecord) string{
+	"merchant.id":      func(r *TransactionRecord) string { return r.Merchant },
+	"merchant.country": func(r *TransactionRecord) string { return r.Location },
+}
+
+var ruleOperators = map[string]string{
+	"eq": "eq", "==": "eq", "ne": "ne", "!=": "ne",
+	"gt": "gt", ">": "gt", "gte": "gte", ">=": "gte",
+	"lt": "lt", "<": "lt", "lte": "lte", "<=": "lte",
+	"in": "in", "not_in": "not_in",
+	"contains": "contains", "starts_with": "starts_with", "ends_with": "ends_with", "matches": "matches",
+	"exists": "exists", "not_exists": "not_exists",
+}
+
+var operatorKinds = map[string][]fieldKind{
+	"eq":          {kindNumber, kindString, kindBool},
+	"ne":          {kindNumber, kindString, kindBool},
+	"gt":          {kindNumber},
+	"gte":         {kindNumber},
+	"lt":          {kindNumber},
+	"lte":         {kindNumber},
+	"in":          {kindNumber, kindString},
+	"not_in":      {kindNumber, kindString},
+	"contains":    {kindString},
+	"starts_with": {kindString},
+	"ends_with":   {kindString},
+	"matches":     {kindString},
+	"exists":      {kindNumber, kindString, kindBool},
+	"not_exists":  {kindNumber, kindString, kindBool},
+}
+
+// DSLRule is a ComplianceRule compiled into a fraud Rule
+type DSLRule struct {
+	def        models.ComplianceRule
+	conditions []*compiledCondition
+	score      float64
+	geofence   *GeofenceChecker
+	tracker    *VelocityTracker
+	onDryRun   func(hit RuleHit)
+}
+
+type compiledCondition struct {
+	field     string
+	label     string
+	kind      fieldKind
+	get       func(e *ruleEnv) (interface{}, bool)
+	op        string
+	number    float64
+	text      string
+	flag      bool
+	numbers   []float64
+	texts     []string
+	pattern   *regexp.Regexp
+	aggregate string
+	window    time.Duration
+	any       []*compiledCondition
+}
+
+// CompileRule validates a rule definition and compiles it for evaluation
+func CompileRule(def models.ComplianceRule) (*DSLRule, error) {
+	if def.ID == "" {
+		return nil, fmt.Errorf("rule id is required")
+	}
+	if len(def.Conditions) == 0 {
+		return nil, fmt.Errorf("rule %s: at least one condition is required", def.ID)
+	}
+
+	r := &DSLRule{def: def}
+	for i, c := range def.Conditions {
+		cc, err := compileCondition(c)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: condition %d: %w", def.ID, i+1, err)
+		}
+		r.conditions = append(r.conditions, cc)
+	}
+	for i, a := range def.Actions {
+		switch a.Type {
+		case RuleActionHold, RuleActionFlag:
+		case RuleActionAlert:
+			if sev, ok := a.Parameters["severity"]; ok {
+				switch models.AlertSeverity(fmt.Sprint(sev)) {
+				case models.AlertSeverityLow, models.AlertSeverityMedium, models.AlertSeverityHigh, models.AlertSeverityCritical:
+				default:
+					return nil, fmt.Errorf("rule %s: action %d: invalid severity %v", def.ID, i+1, sev)
+				}
+			}
+		case RuleActionScoreAdjust:
+			score, err := toFloat(a.Parameters["score"])
+			if err == nil && !isFinite(score) {
+				err = fmt.Errorf("score must be finite")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: action %d: score_adjust needs a numeric score: %w", def.ID, i+1, err)
+			}
+			r.score += score
+		default:
+			return nil, fmt.Errorf("rule %s: action %d: unknown action %q", def.ID, i+1, a.Type)
+		}
+	}
+	return r, nil
+}
+
+func compileCondition(c models.RuleCondition) (*compiledCondition, error) {
+	if len(c.Any) > 0 {
+		if c.Field != "" || c.Aggregate != "" {
+			return nil, fmt.Errorf("a condition with any cannot also set a field")
+		}
+		cc := &compiledCondition{op: "any", label: "any"}
+		for i, sub := range c.Any {
+			s, err := compileCondition(sub)
+			if err != nil {
+				return nil, fmt.Errorf("any %d: %w", i+1, err)
+			}
+			cc.any = append(cc.any, s)
+		}
+		return cc, nil
+	}
+
+	op, ok := ruleOperators[c.Operator]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %q", c.Operator)
+	}
+	cc := &compiledCondition{field: c.Field, label: c.Field, op: op}
+
+	if c.Aggregate != "" {
+		if !ruleAggregates[c.Aggregate] {
+			return nil, fmt.Errorf("unknown aggregate %q", c.Aggregate)
+		}
+		window, err := parseRuleWindow(c.Window)
+		if err != nil {
+			return nil, err
+		}
+		switch c.Aggregate {
+		case "count":
+		case "distinct":
+			if distinctFields[c.Field] == nil {
+				return nil, fmt.Errorf("distinct is not supported for field %q", c.Field)
+			}
+		default:
+			if c.Field != "amount" {
+				return nil, fmt.Errorf("%s is only supported for amount", c.Aggregate)
+			}
+		}
+		cc.aggregate = c.Aggregate
+		cc.window = window
+		cc.kind = kindNumber
+		cc.label = fmt.Sprintf("%s(%s,%s)", c.Aggregate, c.Field, c.Window)
+		cc.get = cc.aggregateValue
+	} else if key, ok := strings.CutPrefix(c.Field, "metadata."); ok && key != "" {
+		cc.kind = kindString
+		cc.get = func(e *ruleEnv) (interface{}, bool) {
+			v, ok := e.txn.Metadata[key]
+			return v, ok
+		}
+	} else {
+		f, ok := ruleFields[c.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", c.Field)
+		}
+		cc.kind = f.kind
+		cc.get = f.get
+	}
+
+	supported := false
+	for _, k := range operatorKinds[op] {
+		supported = supported || k == cc.kind
+	}
+	if !supported {
+		return nil, fmt.Errorf("operator %s does not apply to %s field %s", c.Operator, cc.kind, c.Field)
+	}
+	return cc, cc.compileValue(c.Value)
+}
+
+// parseRuleWindow parses a Go duration, also accepting whole days as "7d"
+func parseRuleWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("aggregates need a window")
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+func (cc *compiledCondition) compileValue(v interface{}) error {
+	switch cc.op {
+	case "exists", "not_exists":
+		return nil
+	case "in", "not_in":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s needs a list value", cc.op)
+		}
+		for _, item := range list {
+			if cc.kind == kindNumber {
+				f, err := toFloat(item)
+				if err != nil {
+					return err
+				}
+				cc.numbers = append(cc.numbers, f)
+			} else {
+				cc.texts = append(cc.texts, fmt.Sprint(item))
+			}
+		}
+		return nil
+	case "matches":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("matches needs a string pattern")
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		cc.pattern = re
+		return nil
+	}
+
+	switch cc.kind {
+	case kindNumber:
+		f, err := toFloat(v)
+		if err != nil {
+			return err
+		}
+		cc.number = f
+	case kindBool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("field %s needs a boolean value", cc.field)
+		}
+		cc.flag = b
+	default:
+		if v == nil {
+			return fmt.Errorf("field %s needs a value", cc.field)
+		}
+		cc.text = fmt.Sprint(v)
+	}
+	return nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(n, 64)
+	default:
+		return 0, fmt.Errorf("expected a number, got %v", v)
+	}
+}
+
+func (cc *compiledCondition) aggregateValue(e *ruleEnv) (interface{}, bool) {
+	end := e.txn.CreatedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	start := end.Add(-cc.window)
+
+	var count int
+	var sum, lo, hi decimal.Decimal
+	distinct := map[string]bool{}
+	for _, r := range e.history() {
+		if r.Timestamp.Before(start) || r.Timestamp.After(end) {
+			continue
+		}
+		if count == 0 || r.Amount.LessThan(lo) {
+			lo = r.Amount
+		}
+		if count == 0 || r.Amount.GreaterThan(hi) {
+			hi = r.Amount
+		}
+		count++
+		sum = sum.Add(r.Amount)
+		if key := distinctFields[cc.field]; key != nil && key(r) != "" {
+			distinct[key(r)] = true
+		}
+	}
+
+	switch cc.aggregate {
+	case "count":
+		return float64(count), true
+	case "distinct":
+		return float64(len(distinct)), true
+	case "sum":
+		return sum.InexactFloat64(), true
+	}
+	if count == 0 {
+		return nil, false
+	}
+	switch cc.aggregate {
+	case "avg":
+		return sum.Div(decimal.NewFromInt(int64(count))).InexactFloat64(), true
+	case "min":
+		return lo.InexactFloat64(), true
+	default:
+		return hi.InexactFloat64(), true
+	}
+}
+
+// match evaluates the condition, recording the values it looked at
+func (cc *compiledCondition) match(e *ruleEnv, values map[string]interface{}) bool {
+	if cc.op == "any" {
+		for _, s := range cc.any {
+			if s.match(e, values) {
+				return true
+			}
+		}
+		return false
+	}
+
+	v, ok := cc.get(e)
+	if ok {
+		values[cc.label] = v
+	}
+	switch cc.op {
+	case "exists":
+		return ok
+	case "not_exists":
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+
+	switch x := v.(type) {
+	case float64:
+		switch cc.op {
+		case "eq":
+			return x == cc.number
+		case "ne":
+			return x != cc.number
+		case "gt":
+			return x > cc.number
+		case "gte":
+			return x >= cc.number
+		case "lt":
+			return x < cc.number
+		case "lte":
+			return x <= cc.number
+		case "in", "not_in":
+			found := false
+			for _, n := range cc.numbers {
+				found = found || n == x
+			}
+			return found == (cc.op == "in")
+		}
+	case string:
+		switch cc.op {
+		case "eq":
+			return strings.EqualFold(x, cc.text)
+		case "ne":
+			return !strings.EqualFold(x, cc.text)
+		case "in", "not_in":
+			found := false
+			for _, t := range cc.texts {
+				found = found || strings.EqualFold(t, x)
+			}
+			return found == (cc.op == "in")
+		case "contains":
+			return strings.Contains(strings.ToLower(x), strings.ToLower(cc.text))
+		case "starts_with":
+			return strings.HasPrefix(strings.ToLower(x), strings.ToLower(cc.text))
+		case "ends_with":
+			return strings.HasSuffix(strings.ToLower(x), strings.ToLower(cc.text))
+		case "matches":
+			return cc.pattern.MatchString(x)
+		}
+	case bool:
+		switch cc.op {
+		case "eq":
+			return x == cc.flag
+		case "ne":
+			return x != cc.flag
+		}
+	}
+	return false
+}
+
+// Definition returns the rule definition the rule was compiled from
+func (r *DSLRule) Definition() models.ComplianceRule { return r.def }
+
+func (r *DSLRule) Name() string  { return r.def.ID }
+func (r *DSLRule) Priority() int { return r.def.Priority }
+
+// Evaluate implements Rule. Rules in dry-run mode report their matches to
+// the rule store and never trigger.
+func (r *DSLRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext) *RuleResult {
+	values, ok := r.Match(txn, ctx)
+	if !ok {
+		return &RuleResult{}
+	}
+	if r.def.DryRun {
+		if r.onDryRun != nil {
+			r.onDryRun(r.hit(txn, values))
+		}
+		return &RuleResult{}
+	}
+
+	description := r.def.Description
+	if description == "" {
+		description = r.def.Name
+	}
+	indicatorType := r.def.RuleType
+	if indicatorType == "" {
+		indicatorType = string(models.FraudAlertTypeRule)
+	}
+	return &RuleResult{
+		Triggered:   true,
+		Score:       r.score,
+		Description: description,
+		Actions:     r.def.Actions,
+		Indicators: []models.FraudIndicator{{
+			Type:        indicatorType,
+			Description: description,
+			Score:       r.score,
+			Details: map[string]interface{}{
+				"rule_id": r.def.ID,
+				"version": r.def.Version,
+				"actions": r.actionTypes(),
+				"values":  values,
+			},
+		}},
+	}
+}
+
+// Match reports whether every condition holds and the values examined
+func (r *DSLRule) Match(txn *models.Transaction, ctx *EvaluationContext) (map[string]interface{}, bool) {
+	env := &ruleEnv{txn: txn, ctx: ctx, geofence: r.geofence, tracker: r.tracker}
+	values := make(map[string]interface{})
+	for _, c := range r.conditions {
+		if !c.match(env, values) {
+			return values, false
+		}
+	}
+	return values, true
+}
+
+func (r *DSLRule) actionTypes() []string {
+	types := make([]string, len(r.def.Actions))
+	for i, a := range r.def.Actions {
+		types[i] = a.Type
+	}
+	return types
+}
+
+func (r *DSLRule) hit(txn *models.Transaction, values map[string]interface{}) RuleHit {
+	return RuleHit{
+		RuleID:        r.def.ID,
+		Version:       r.def.Version,
+		TransactionID: txn.ID,
+		AccountID:     txn.SourceAccount,
+		Amount:        txn.ReportingAmount(),
+		Timestamp:     txn.CreatedAt,
+		Score:         r.score,
+		Actions:       r.actionTypes(),
+		Values:        values,
+	}
+}
+
+// RuleHit records a transaction a rule matched
+type RuleHit struct {
+	RuleID        string                 `json:"rule_id"`
+	Version       int                    `json:"version"`
+	TransactionID string                 `json:"transaction_id"`
+	AccountID     string                 `json:"account_id"`
+	Amount        decimal.Decimal        `json:"amount"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Score         float64                `json:"score"`
+	Actions       []string               `json:"actions,omitempty"`
+	Values        map[string]interface{} `json:"values,omitempty"`
+}
+
+// sortRules orders rules by descending priority, then ID
+func sortRules(rules []*DSLRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].def.Priority != rules[j].def.Priority {
+			return rules[i].def.Priority > rules[j].def.Priority
+		}
+		return rules[i].def.ID < rules[j].def.ID
+	})
+}
+
+// isFinite reports whether a rule score is usable
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// ruleAction is an action requested by a triggered rule
+type ruleAction struct {
+	rule   string
+	action models.RuleAction
+}
+
+// applyRuleActions applies hold, flag and alert actions after the score
+// based decision. A hold never downgrades a block, and at most one alert is
+// raised per transaction.
+func (d *Detector) applyRuleActions(txn *models.Transaction, result *EvaluationResult, actions []ruleAction, alerted bool) {
+	var severity models.AlertSeverity
+	var holdRule string
+	for _, ra := range actions {
+		switch ra.action.Type {
+		case RuleActionFlag:
+			flag := ra.rule
+			if v, ok := ra.action.Parameters["flag"]; ok {
+				flag = fmt.Sprint(v)
+			}
+			if !containsString(result.Flags, flag) {
+				result.Flags = append(result.Flags, flag)
+				txn.FraudFlags = append(txn.FraudFlags, flag)
+			}
+		case RuleActionHold:
+			if holdRule == "" {
+				holdRule = ra.rule
+			}
+			if severity == "" {
+				severity = models.AlertSeverityMedium
+			}
+		case RuleActionAlert:
+			s := models.AlertSeverityMedium
+			if v, ok := ra.action.Parameters["severity"]; ok {
+				s = models.AlertSeverity(fmt.Sprint(v))
+			}
+			if severityRank[s] > severityRank[severity] {
+				severity = s
+			}
+		}
+	}
+
+	if holdRule != "" && result.Decision != DecisionBlock {
+		result.Decision = DecisionHold
+		result.Reason = "Held by rule " + holdRule
+	}
+	if severity != "" && !alerted {
+		alert := d.createAlert(txn, result)
+		alert.Severity = severity
+		d.alertCh <- alert
+	}
+}
+
+var severityRank = map[models.AlertSeverity]int{
+	models.AlertSeverityLow:      1,
+	models.AlertSeverityMedium:   2,
+	models.AlertSeverityHigh:     3,
+	models.AlertSeverityCritical: 4,
+}
+
+// Rules returns the store of rules authored as data
+func (d *Detector) Rules() *RuleStore {
+	return d.dsl
+}
@@ -0,0 +1,304 @@
+package fraud
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+var dslStart = time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+
+func dslTxn(id string, amount int64, minutes int, merchant string) *models.Transaction {
+	return &models.Transaction{
+		ID:            id,
+		Type:          models.TransactionTypeDebit,
+		Amount:        decimal.NewFromInt(amount),
+		Currency:      "USD",
+		SourceAccount: "acct-1",
+		Merchant:      &models.Merchant{ID: merchant, MCC: "5411", Country: "US"},
+		CreatedAt:     dslStart.Add(time.Duration(minutes) * time.Minute),
+	}
+}
+
+func newDSLDetector() *Detector {
+	return NewDetector(&config.FraudConfig{
+		Enabled:         true,
+		ScoreThreshold:  0.7,
+		VelocityWindow:  time.Hour,
+		MaxDailyAmount:  100000,
+		MaxSingleAmount: 50000,
+	})
+}
+
+func TestCompileRuleErrors(t *testing.T) {
+	tests := map[string]models.ComplianceRule{
+		"unknown field": {ID: "r", Conditions: []models.RuleCondition{{Field: "nope", Operator: "eq", Value: "x"}}},
+		"operator kind": {ID: "r", Conditions: []models.RuleCondition{{Field: "currency", Operator: "gt", Value: 1}}},
+		"number value":  {ID: "r", Conditions: []models.RuleCondition{{Field: "amount", Operator: "gt", Value: "lots"}}},
+		"window":        {ID: "r", Conditions: []models.RuleCondition{{Field: "amount", Aggregate: "sum", Operator: "gt", Value: 1}}},
+		"aggregate":     {ID: "r", Conditions: []models.RuleCondition{{Field: "currency", Aggregate: "sum", Window: "1h", Operator: "gt", Value: 1}}},
+		"pattern":       {ID: "r", Conditions: []models.RuleCondition{{Field: "description", Operator: "matches", Value: "("}}},
+		"action":        {ID: "r", Conditions: []models.RuleCondition{{Field: "amount", Operator: "gt", Value: 1}}, Actions: []models.RuleAction{{Type: "explode"}}},
+		"score":         {ID: "r", Conditions: []models.RuleCondition{{Field: "amount", Operator: "gt", Value: 1}}, Actions: []models.RuleAction{{Type: "score_adjust"}}},
+		"no conditions": {ID: "r"},
+	}
+	for name, def := range tests {
+		if _, err := CompileRule(def); err == nil {
+			t.Errorf("%s: expected a compile error", name)
+		}
+	}
+}
+
+func TestDSLRuleConditions(t *testing.T) {
+	rule, err := CompileRule(models.ComplianceRule{
+		ID: "grocery-burst",
+		Conditions: []models.RuleCondition{
+			{Field: "merchant.mcc", Operator: "in", Value: []interface{}{"5411", "5499"}},
+			{Field: "amount", Aggregate: "count", Window: "30m", Operator: ">=", Value: 3},
+			{Field: "merchant.id", Aggregate: "distinct", Window: "1h", Operator: "gte", Value: 2},
+			{Any: []models.RuleCondition{
+				{Field: "amount", Aggregate: "sum", Window: "1d", Operator: "gt", Value: 250},
+				{Field: "metadata.channel", Operator: "eq", Value: "ATM"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []*models.Transaction{
+		dslTxn("t2", 100, -10, "m2"),
+		dslTxn("t1", 100, -20, "m1"),
+		dslTxn("t0", 500, -90, "m1"),
+	}
+	txn := dslTxn("t3", 40, 0, "m1")
+
+	values, ok := rule.Match(txn, &EvaluationContext{AccountHistory: history})
+	if !ok {
+		t.Fatalf("expected a match, got values %v", values)
+	}
+	if values["count(amount,30m)"] != 3.0 || values["sum(amount,1d)"] != 740.0 {
+		t.Errorf("unexpected aggregate values %v", values)
+	}
+
+	// Outside the 30 minute window only the current transaction counts
+	if _, ok := rule.Match(txn, &EvaluationContext{AccountHistory: history[2:]}); ok {
+		t.Error("expected no match without recent transactions")
+	}
+
+	// The any group also matches on metadata
+	small := dslTxn("t3", 1, 0, "m1")
+	small.Metadata = map[string]string{"channel": "atm"}
+	lowHistory := []*models.Transaction{dslTxn("t2", 1, -10, "m2"), dslTxn("t1", 1, -20, "m1")}
+	if _, ok := rule.Match(small, &EvaluationContext{AccountHistory: lowHistory}); !ok {
+		t.Error("expected the metadata branch of any to match")
+	}
+	small.Metadata = nil
+	if _, ok := rule.Match(small, &EvaluationContext{AccountHistory: lowHistory}); ok {
+		t.Error("expected no match when neither any branch holds")
+	}
+}
+
+func TestDetectorAppliesRuleActions(t *testing.T) {
+	d := newDSLDetector()
+	_, err := d.Rules().Put(models.ComplianceRule{
+		Name:     "Crypto purchase",
+		RuleType: string(models.FraudAlertTypeMerchant),
+		Enabled:  true,
+		Priority: 10,
+		Conditions: []models.RuleCondition{
+			{Field: "merchant.mcc", Operator: "eq", Value: "6051"},
+			{Field: "amount", Operator: "gte", Value: 1000},
+		},
+		Actions: []models.RuleAction{
+			{Type: RuleActionScoreAdjust, Parameters: map[string]interface{}{"score": 1.5}},
+			{Type: RuleActionFlag, Parameters: map[string]interface{}{"flag": "crypto"}},
+			{Type: RuleActionHold},
+			{Type: RuleActionAlert, Parameters: map[string]interface{}{"severity": "high"}},
+		},
+	}, "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := dslTxn("c1", 1200, 0, "exchange")
+	txn.Merchant.MCC = "6051"
+	result := d.Evaluate(txn, nil)
+
+	// The merchant rule scores 2.0 for the MCC and the DSL rule adds 1.5
+	if result.RiskScore != 0.35 {
+		t.Errorf("expected a risk score of 0.35, got %v", result.RiskScore)
+	}
+	if result.Decision != DecisionHold || !strings.Contains(result.Reason, "crypto-purchase") {
+		t.Errorf("expected a hold by crypto-purchase, got %s (%s)", result.Decision, result.Reason)
+	}
+	if len(result.Flags) != 1 || result.Flags[0] != "crypto" || len(txn.FraudFlags) != 1 {
+		t.Errorf("unexpected flags %v / %v", result.Flags, txn.FraudFlags)
+	}
+	var found bool
+	for _, ind := range result.Indicators {
+		if ind.Details["rule_id"] == "crypto-purchase" {
+			found = ind.Type == "merchant" && ind.Score == 1.5 && ind.Details["version"] == 1
+		}
+	}
+	if !found {
+		t.Errorf("expected a rule indicator, got %+v", result.Indicators)
+	}
+
+	select {
+	case alert := <-d.alertCh:
+		if alert.Severity != models.AlertSeverityHigh {
+			t.Errorf("expected a high severity alert, got %s", alert.Severity)
+		}
+	default:
+		t.Error("expected the alert action to raise an alert")
+	}
+
+	// A disabled version stops the rule
+	def, _ := d.Rules().Get("crypto-purchase")
+	def.Enabled = false
+	if _, err := d.Rules().Put(*def, "analyst"); err != nil {
+		t.Fatal(err)
+	}
+	if result := d.Evaluate(dslTxn("c2", 1200, 1, "exchange"), nil); result.Decision != DecisionAllow || len(result.Flags) != 0 {
+		t.Errorf("disabled rule still applied: %+v", result)
+	}
+}
+
+func TestRuleStoreVersioningAndDryRunMode(t *testing.T) {
+	d := newDSLDetector()
+	store := d.Rules()
+	big := models.ComplianceRule{
+		ID:         "big",
+		Enabled:    true,
+		DryRun:     true,
+		Conditions: []models.RuleCondition{{Field: "amount", Operator: ">", Value: 500}},
+		Actions:    []models.RuleAction{{Type: RuleActionHold}},
+	}
+	if _, err := store.Put(big, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := d.Evaluate(dslTxn("d1", 900, 0, "m1"), nil)
+	if result.Decision != DecisionAllow {
+		t.Errorf("dry-run rule changed the decision to %s", result.Decision)
+	}
+	d.Evaluate(dslTxn("d2", 100, 1, "m1"), nil)
+	hits, _ := store.Hits("big")
+	if len(hits) != 1 || hits[0].TransactionID != "d1" || hits[0].Actions[0] != RuleActionHold {
+		t.Errorf("unexpected dry-run hits %+v", hits)
+	}
+
+	big.Conditions[0].Value = 50
+	if _, err := store.Put(big, "b"); err != nil {
+		t.Fatal(err)
+	}
+	bad := big
+	bad.Conditions = []models.RuleCondition{{Field: "amount", Operator: "~", Value: 1}}
+	if _, err := store.Put(bad, "c"); err == nil {
+		t.Fatal("expected an invalid update to be rejected")
+	}
+	rolled, err := store.Rollback("big", 1, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, _ := store.Versions("big")
+	if rolled.Version != 3 || len(versions) != 3 || rolled.Conditions[0].Value != 500.0 || versions[1].UpdatedBy != "b" {
+		t.Errorf("unexpected versions %+v", versions)
+	}
+	if err := store.Delete("big"); err != nil || len(store.Active()) != 0 || len(store.List()) != 0 {
+		t.Errorf("expected the rule to be removed, err %v", err)
+	}
+}
+
+func TestRuleStoreReloadFromYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`rules:
+  - id: night-cash
+    name: Night cash
+    rule_type: time
+    enabled: true
+    conditions:
+      - {field: hour, operator: in, value: [1, 2, 3]}
+    actions:
+      - {type: score_adjust, parameters: {score: 2}}
+  - id: foreign
+    enabled: true
+    conditions:
+      - {field: currency, operator: ne, value: USD}
+`)
+	store := NewRuleStore(path, NewGeofenceChecker(), nil)
+	result, err := store.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 2 || len(store.Active()) != 2 {
+		t.Fatalf("unexpected load result %+v", result)
+	}
+
+	write(`rules:
+  - id: night-cash
+    name: Night cash
+    rule_type: time
+    enabled: true
+    conditions:
+      - {field: hour, operator: in, value: [1, 2, 3]}
+    actions:
+      - {type: score_adjust, parameters: {score: 2}}
+`)
+	if result, err = store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unchanged) != 1 || len(result.Removed) != 1 || result.Removed[0] != "foreign" {
+		t.Errorf("unexpected reload result %+v", result)
+	}
+
+	write(`[{"id": "broken", "conditions": [{"field": "amount", "operator": "between"}]}]`)
+	if _, err := store.Reload(); err == nil {
+		t.Error("expected an invalid file to be rejected")
+	}
+	if def, ok := store.Get("night-cash"); !ok || def.Version != 1 {
+		t.Error("a rejected reload must leave the rule set untouched")
+	}
+}
+
+func TestRuleStoreDryRun(t *testing.T) {
+	store := NewRuleStore("", NewGeofenceChecker(), nil)
+	var items []ReplayItem
+	for i, amount := range []int64{20, 30, 25, 400, 35} {
+		txn := dslTxn("r"+string(rune('0'+i)), amount, i*5, "m1")
+		items = append(items, ReplayItem{Transaction: txn})
+	}
+	// Out of order on purpose; the replay sorts by time
+	items[0], items[4] = items[4], items[0]
+
+	report, err := store.DryRun(models.ComplianceRule{
+		ID: "third-in-window",
+		Conditions: []models.RuleCondition{
+			{Field: "amount", Aggregate: "count", Window: "12m", Operator: "gte", Value: 3},
+		},
+	}, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evaluated != 5 || report.Matched != 3 || !report.Amount.Equal(decimal.NewFromInt(460)) || report.Accounts != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.Hits[0].TransactionID != "r2" {
+		t.Errorf("expected the first hit on r2, got %s", report.Hits[0].TransactionID)
+	}
+	if len(store.List()) != 0 {
+		t.Error("a dry run must not store the rule")
+	}
+}
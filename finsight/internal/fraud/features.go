@@ -0,0 +1,261 @@
+package fraud
+
+import (
+	"math"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Features holds named model inputs for one transaction. A feature that
+// cannot be computed from the available context is left out so that models
+// can apply their own missing-value handling.
+type Features map[string]float64
+
+// Get returns a feature value and whether it was present
+func (f Features) Get(name string) (float64, bool) {
+	v, ok := f[name]
+	return v, ok
+}
+
+// Feature computes a single model input
+type Feature struct {
+	Name    string
+	Compute func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool)
+}
+
+// FeaturePipeline computes the features a scoring model is trained on
+type FeaturePipeline struct {
+	features []Feature
+}
+
+// NewFeaturePipeline creates a pipeline from the given features
+func NewFeaturePipeline(features ...Feature) *FeaturePipeline {
+	return &FeaturePipeline{features: features}
+}
+
+// Add appends features to the pipeline, replacing any with the same name
+func (p *FeaturePipeline) Add(features ...Feature) {
+	for _, f := range features {
+		replaced := false
+		for i := range p.features {
+			if p.features[i].Name == f.Name {
+				p.features[i] = f
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			p.features = append(p.features, f)
+		}
+	}
+}
+
+// Names returns the feature names in pipeline order
+func (p *FeaturePipeline) Names() []string {
+	names := make([]string, len(p.features))
+	for i, f := range p.features {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Compute evaluates every feature for a transaction. NaN and infinite
+// results are treated as missing.
+func (p *FeaturePipeline) Compute(txn *models.Transaction, ctx *EvaluationContext) Features {
+	out := make(Features, len(p.features))
+	for _, f := range p.features {
+		v, ok := f.Compute(txn, ctx)
+		if ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			out[f.Name] = v
+		}
+	}
+	return out
+}
+
+// DefaultFeaturePipeline returns the standard transaction, merchant,
+// velocity, profile, device and location features
+func DefaultFeaturePipeline(geofence *GeofenceChecker) *FeaturePipeline {
+	return NewFeaturePipeline(
+		Feature{"amount", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			return txn.ReportingAmount().InexactFloat64(), true
+		}},
+		Feature{"log_amount", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			return math.Log1p(math.Abs(txn.ReportingAmount().InexactFloat64())), true
+		}},
+		Feature{"is_round_amount", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			return boolFeature(isRoundAmount(txn.Amount.InexactFloat64())), true
+		}},
+		Feature{"hour", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			return float64(txn.CreatedAt.Hour()), !txn.CreatedAt.IsZero()
+		}},
+		Feature{"day_of_week", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			return float64(txn.CreatedAt.Weekday()), !txn.CreatedAt.IsZero()
+		}},
+		Feature{"is_night", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			hour := txn.CreatedAt.Hour()
+			return boolFeature(hour >= 2 && hour <= 5), !txn.CreatedAt.IsZero()
+		}},
+		Feature{"is_foreign_currency", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			if txn.BaseCurrency == "" || txn.Currency == "" {
+				return 0, false
+			}
+			return boolFeature(txn.Currency != txn.BaseCurrency), true
+		}},
+		Feature{"mcc_high_risk", func(txn *models.Transaction, _ *EvaluationContext) (float64, bool) {
+			if txn.Merchant == nil {
+				return 0, false
+			}
+			return boolFeature(highRiskMCCs[txn.Merchant.MCC]), true
+		}},
+		Feature{"merchant_known", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if txn.Merchant == nil || ctx == nil || ctx.AccountProfile == nil || len(ctx.AccountProfile.TypicalMerchants) == 0 {
+				return 0, false
+			}
+			for _, m := range ctx.AccountProfile.TypicalMerchants {
+				if m == txn.Merchant.ID || m == txn.Merchant.Name {
+					return 1, true
+				}
+			}
+			return 0, true
+		}},
+		Feature{"amount_to_avg", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountProfile == nil || !ctx.AccountProfile.AvgTransactionAmount.IsPositive() {
+				return 0, false
+			}
+			return txn.ReportingAmount().Div(ctx.AccountProfile.AvgTransactionAmount).InexactFloat64(), true
+		}},
+		Feature{"avg_daily_transactions", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountProfile == nil {
+				return 0, false
+			}
+			return ctx.AccountProfile.AvgDailyTransactions, true
+		}},
+		Feature{"hour_typical", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountProfile == nil || len(ctx.AccountProfile.TypicalHours) == 0 {
+				return 0, false
+			}
+			for _, h := range ctx.AccountProfile.TypicalHours {
+				if h == txn.CreatedAt.Hour() {
+					return 1, true
+				}
+			}
+			return 0, true
+		}},
+		Feature{"recent_count", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.RecentActivity == nil {
+				return 0, false
+			}
+			return float64(ctx.RecentActivity.TransactionCount), true
+		}},
+		Feature{"recent_amount", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.RecentActivity == nil {
+				return 0, false
+			}
+			return ctx.RecentActivity.TotalAmount.InexactFloat64(), true
+		}},
+		Feature{"recent_locations", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.RecentActivity == nil {
+				return 0, false
+			}
+			return float64(ctx.RecentActivity.UniqueLocations), true
+		}},
+		Feature{"recent_merchants", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.RecentActivity == nil {
+				return 0, false
+			}
+			return float64(ctx.RecentActivity.UniqueMerchants), true
+		}},
+		Feature{"history_count", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountHistory == nil {
+				return 0, false
+			}
+			return float64(len(ctx.AccountHistory)), true
+		}},
+		Feature{"seconds_since_last", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || len(ctx.AccountHistory) == 0 {
+				return 0, false
+			}
+			latest := ctx.AccountHistory[0].CreatedAt
+			for _, h := range ctx.AccountHistory[1:] {
+				if h.CreatedAt.After(latest) {
+					latest = h.CreatedAt
+				}
+			}
+			return math.Max(txn.CreatedAt.Sub(latest).Seconds(), 0), true
+		}},
+		Feature{"repeated_amount_count", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountHistory == nil {
+				return 0, false
+			}
+			n := 0
+			for _, h := range ctx.AccountHistory {
+				if h.Amount.Equal(txn.Amount) {
+					n++
+				}
+			}
+			return float64(n), true
+		}},
+		Feature{"small_txn_count", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.AccountHistory == nil {
+				return 0, false
+			}
+			small := decimal.NewFromInt(5)
+			n := 0
+			for _, h := range ctx.AccountHistory {
+				if h.Amount.LessThan(small) {
+					n++
+				}
+			}
+			return float64(n), true
+		}},
+		Feature{"device_known", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.DeviceInfo == nil {
+				return 0, false
+			}
+			return boolFeature(ctx.DeviceInfo.IsKnown), true
+		}},
+		Feature{"device_trusted", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.DeviceInfo == nil {
+				return 0, false
+			}
+			return boolFeature(ctx.DeviceInfo.IsTrusted), true
+		}},
+		Feature{"device_age_hours", func(txn *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.DeviceInfo == nil || ctx.DeviceInfo.FirstSeen.IsZero() {
+				return 0, false
+			}
+			return math.Max(txn.CreatedAt.Sub(ctx.DeviceInfo.FirstSeen).Hours(), 0), true
+		}},
+		Feature{"device_accounts", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.DeviceInfo == nil || ctx.DeviceInfo.AccountCount == 0 {
+				return 0, false
+			}
+			return float64(ctx.DeviceInfo.AccountCount), true
+		}},
+		Feature{"geo_high_risk", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.GeoLocation == nil || ctx.GeoLocation.Country == "" || geofence == nil {
+				return 0, false
+			}
+			return boolFeature(geofence.IsHighRiskCountry(ctx.GeoLocation.Country)), true
+		}},
+		Feature{"geo_typical", func(_ *models.Transaction, ctx *EvaluationContext) (float64, bool) {
+			if ctx == nil || ctx.GeoLocation == nil || ctx.AccountProfile == nil || len(ctx.AccountProfile.TypicalLocations) == 0 {
+				return 0, false
+			}
+			for _, loc := range ctx.AccountProfile.TypicalLocations {
+				if loc == ctx.GeoLocation.Country || loc == ctx.GeoLocation.City {
+					return 1, true
+				}
+			}
+			return 0, true
+		}},
+	)
+}
+
+func boolFeature(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,577 @@
+package fraud
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Supported gradient-boosted tree formats
+const (
+	GBDTFormatXGBoostDump = "xgboost_dump"
+	GBDTFormatXGBoostJSON = "xgboost_json"
+	GBDTFormatLightGBM    = "lightgbm"
+)
+
+// GBDTModel is a gradient-boosted tree ensemble scored natively. It loads
+// XGBoost JSON dumps (Booster.get_dump with dump_format="json", optionally
+// wrapped in an envelope carrying the objective and feature names), XGBoost
+// JSON models (Booster.save_model) and LightGBM JSON dumps
+// (Booster.dump_model).
+type GBDTModel struct {
+	name          string
+	version       string
+	format        string
+	objective     string
+	baseMargin    float64
+	sigmoid       float64
+	averageOutput bool
+	features      []string
+	trees         []gbdtTree
+}
+
+type gbdtTree []gbdtNode
+
+type gbdtNode struct {
+	leaf        bool
+	value       float64
+	feature     int
+	threshold   float64
+	categories  map[int]bool
+	lessEqual   bool
+	defaultLeft bool
+	missing     string
+	left        int
+	right       int
+}
+
+// Name returns the model name
+func (m *GBDTModel) Name() string { return m.name }
+
+// Version returns the model version. Unless the file declares one it is
+// derived from the file contents, so retrained models get a new version.
+func (m *GBDTModel) Version() string { return m.version }
+
+// Features returns the input features in model order
+func (m *GBDTModel) Features() []string { return m.features }
+
+// Format returns the format the model was loaded from
+func (m *GBDTModel) Format() string { return m.format }
+
+// Trees returns the number of trees in the ensemble
+func (m *GBDTModel) Trees() int { return len(m.trees) }
+
+// Predict returns the fraud probability for a feature set
+func (m *GBDTModel) Predict(f Features) (float64, error) {
+	x := make([]float64, len(m.features))
+	for i, name := range m.features {
+		if v, ok := f.Get(name); ok {
+			x[i] = v
+		} else {
+			x[i] = math.NaN()
+		}
+	}
+
+	var sum float64
+	for i := range m.trees {
+		sum += m.trees[i].predict(x)
+	}
+	if m.averageOutput && len(m.trees) > 0 {
+		sum /= float64(len(m.trees))
+	}
+	return m.transform(m.baseMargin + sum), nil
+}
+
+func (m *GBDTModel) transform(margin float64) float64 {
+	switch m.objective {
+	case "binary:logistic", "reg:logistic", "binary", "cross_entropy", "xentropy":
+		sigma := m.sigmoid
+		if sigma == 0 {
+			sigma = 1
+		}
+		return 1 / (1 + math.Exp(-sigma*margin))
+	default:
+		// Raw margins and regression outputs are clamped to a probability
+		return math.Max(0, math.Min(1, margin))
+	}
+}
+
+func (t gbdtTree) predict(x []float64) float64 {
+	i := 0
+	for steps := 0; steps <= len(t); steps++ {
+		n := &t[i]
+		if n.leaf {
+			return n.value
+		}
+		if n.goLeft(x[n.feature]) {
+			i = n.left
+		} else {
+			i = n.right
+		}
+	}
+	// Parsing rejects cycles, so this is unreachable
+	return 0
+}
+
+func (n *gbdtNode) goLeft(v float64) bool {
+	if n.categories != nil {
+		if math.IsNaN(v) || v < 0 {
+			return false
+		}
+		return n.categories[int(v)]
+	}
+	switch n.missing {
+	case "None":
+		// LightGBM without missing handling treats NaN as zero
+		if math.IsNaN(v) {
+			v = 0
+		}
+	case "Zero":
+		if math.IsNaN(v) || math.Abs(v) <= 1e-35 {
+			return n.defaultLeft
+		}
+	default:
+		if math.IsNaN(v) {
+			return n.defaultLeft
+		}
+	}
+	if n.lessEqual {
+		return v <= n.threshold
+	}
+	return v < n.threshold
+}
+
+// LoadGBDTModel reads a model file, naming the model after the file
+func LoadGBDTModel(path string) (*GBDTModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return ParseGBDTModel(name, data)
+}
+
+// ParseGBDTModel parses a model in any supported format
+func ParseGBDTModel(name string, data []byte) (*GBDTModel, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("empty model")
+	}
+
+	sum := sha256.Sum256(trimmed)
+	m := &GBDTModel{name: name, version: hex.EncodeToString(sum[:])[:12]}
+
+	var err error
+	if trimmed[0] == '[' {
+		err = m.parseXGBoostDump(xgboostEnvelope{Trees: trimmed})
+	} else {
+		var probe struct {
+			Learner  json.RawMessage `json:"learner"`
+			TreeInfo json.RawMessage `json:"tree_info"`
+		}
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return nil, fmt.Errorf("invalid model JSON: %w", err)
+		}
+		switch {
+		case probe.TreeInfo != nil:
+			err = m.parseLightGBM(trimmed)
+		case probe.Learner != nil:
+			err = m.parseXGBoostJSON(probe.Learner)
+		default:
+			var env xgboostEnvelope
+			if err := json.Unmarshal(trimmed, &env); err != nil {
+				return nil, fmt.Errorf("invalid model envelope: %w", err)
+			}
+			if env.Trees == nil {
+				return nil, fmt.Errorf("unrecognised model format")
+			}
+			err = m.parseXGBoostDump(env)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.trees) == 0 {
+		return nil, fmt.Errorf("model %s has no trees", name)
+	}
+	return m, nil
+}
+
+// xgboostEnvelope wraps a plain XGBoost dump with the metadata the dump
+// itself does not carry
+type xgboostEnvelope struct {
+	Name         string          `json:"name"`
+	Version      string          `json:"version"`
+	Objective    string          `json:"objective"`
+	BaseScore    *float64        `json:"base_score"`
+	FeatureNames []string        `json:"feature_names"`
+	Trees        json.RawMessage `json:"trees"`
+}
+
+type xgboostDumpNode struct {
+	NodeID         int               `json:"nodeid"`
+	Split          string            `json:"split"`
+	SplitCondition float64           `json:"split_condition"`
+	Yes            int               `json:"yes"`
+	No             int               `json:"no"`
+	Missing        int               `json:"missing"`
+	Leaf           *float64          `json:"leaf"`
+	Children       []xgboostDumpNode `json:"children"`
+}
+
+func (m *GBDTModel) parseXGBoostDump(env xgboostEnvelope) error {
+	var roots []xgboostDumpNode
+	if err := json.Unmarshal(env.Trees, &roots); err != nil {
+		return fmt.Errorf("invalid XGBoost dump: %w", err)
+	}
+
+	m.format = GBDTFormatXGBoostDump
+	if env.Name != "" {
+		m.name = env.Name
+	}
+	if env.Version != "" {
+		m.version = env.Version
+	}
+	m.objective = env.Objective
+	if m.objective == "" {
+		m.objective = "binary:logistic"
+	}
+	base := 0.5
+	if env.BaseScore != nil {
+		base = *env.BaseScore
+	}
+	m.baseMargin = xgboostBaseMargin(m.objective, base)
+
+	features := newFeatureIndex(env.FeatureNames)
+	for t, root := range roots {
+		byID := make(map[int]xgboostDumpNode)
+		var walk func(n xgboostDumpNode)
+		walk = func(n xgboostDumpNode) {
+			byID[n.NodeID] = n
+			for _, c := range n.Children {
+				walk(c)
+			}
+		}
+		walk(root)
+
+		index := make(map[int]int)
+		var order []int
+		var assign func(id int) error
+		assign = func(id int) error {
+			if _, ok := index[id]; ok {
+				return fmt.Errorf("tree %d: node %d is reachable twice", t, id)
+			}
+			n, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("tree %d: missing node %d", t, id)
+			}
+			index[id] = len(order)
+			order = append(order, id)
+			if n.Leaf == nil {
+				if err := assign(n.Yes); err != nil {
+					return err
+				}
+				return assign(n.No)
+			}
+			return nil
+		}
+		if err := assign(root.NodeID); err != nil {
+			return err
+		}
+
+		tree := make(gbdtTree, len(order))
+		for i, id := range order {
+			n := byID[id]
+			if n.Leaf != nil {
+				tree[i] = gbdtNode{leaf: true, value: *n.Leaf}
+				continue
+			}
+			f, err := features.resolve(n.Split)
+			if err != nil {
+				return fmt.Errorf("tree %d: %w", t, err)
+			}
+			tree[i] = gbdtNode{
+				feature:     f,
+				threshold:   n.SplitCondition,
+				defaultLeft: n.Missing == n.Yes,
+				left:        index[n.Yes],
+				right:       index[n.No],
+			}
+		}
+		m.trees = append(m.trees, tree)
+	}
+	m.features = features.names
+	return nil
+}
+
+func (m *GBDTModel) parseXGBoostJSON(raw json.RawMessage) error {
+	var learner struct {
+		FeatureNames    []string `json:"feature_names"`
+		GradientBooster struct {
+			Name  string `json:"name"`
+			Model struct {
+				Trees []struct {
+					LeftChildren    []int      `json:"left_children"`
+					RightChildren   []int      `json:"right_children"`
+					SplitIndices    []int      `json:"split_indices"`
+					SplitConditions []float64  `json:"split_conditions"`
+					DefaultLeft     []flexBool `json:"default_left"`
+					SplitType       []int      `json:"split_type"`
+				} `json:"trees"`
+			} `json:"model"`
+		} `json:"gradient_booster"`
+		LearnerModelParam struct {
+			BaseScore  string `json:"base_score"`
+			NumFeature string `json:"num_feature"`
+		} `json:"learner_model_param"`
+		Objective struct {
+			Name string `json:"name"`
+		} `json:"objective"`
+	}
+	if err := json.Unmarshal(raw, &learner); err != nil {
+		return fmt.Errorf("invalid XGBoost model: %w", err)
+	}
+	if learner.GradientBooster.Name != "" && learner.GradientBooster.Name != "gbtree" {
+		return fmt.Errorf("unsupported XGBoost booster %q", learner.GradientBooster.Name)
+	}
+
+	m.format = GBDTFormatXGBoostJSON
+	m.objective = learner.Objective.Name
+	// XGBoost 2 writes the base score as a one-element vector
+	base, err := strconv.ParseFloat(strings.Trim(learner.LearnerModelParam.BaseScore, "[]"), 64)
+	if err != nil {
+		base = 0.5
+	}
+	m.baseMargin = xgboostBaseMargin(m.objective, base)
+
+	numFeature, _ := strconv.Atoi(learner.LearnerModelParam.NumFeature)
+	features := newFeatureIndex(learner.FeatureNames)
+	for t, src := range learner.GradientBooster.Model.Trees {
+		n := len(src.LeftChildren)
+		if len(src.RightChildren) != n || len(src.SplitIndices) != n || len(src.SplitConditions) != n {
+			return fmt.Errorf("tree %d: node arrays have different lengths", t)
+		}
+		tree := make(gbdtTree, n)
+		for i := 0; i < n; i++ {
+			if src.LeftChildren[i] == -1 {
+				tree[i] = gbdtNode{leaf: true, value: src.SplitConditions[i]}
+				continue
+			}
+			if i < len(src.SplitType) && src.SplitType[i] != 0 {
+				return fmt.Errorf("tree %d: categorical splits are not supported in XGBoost models", t)
+			}
+			left, right := src.LeftChildren[i], src.RightChildren[i]
+			if left <= i || right <= i || left >= n || right >= n {
+				return fmt.Errorf("tree %d: node %d has invalid children", t, i)
+			}
+			f, err := features.resolveIndex(src.SplitIndices[i])
+			if err != nil {
+				return fmt.Errorf("tree %d: %w", t, err)
+			}
+			tree[i] = gbdtNode{
+				feature:     f,
+				threshold:   src.SplitConditions[i],
+				defaultLeft: i < len(src.DefaultLeft) && bool(src.DefaultLeft[i]),
+				left:        left,
+				right:       right,
+			}
+		}
+		m.trees = append(m.trees, tree)
+	}
+	features.pad(numFeature)
+	m.features = features.names
+	return nil
+}
+
+type lightGBMNode struct {
+	SplitFeature *int            `json:"split_feature"`
+	Threshold    json.RawMessage `json:"threshold"`
+	DecisionType string          `json:"decision_type"`
+	DefaultLeft  bool            `json:"default_left"`
+	MissingType  string          `json:"missing_type"`
+	LeftChild    *lightGBMNode   `json:"left_child"`
+	RightChild   *lightGBMNode   `json:"right_child"`
+	LeafValue    float64         `json:"leaf_value"`
+}
+
+func (m *GBDTModel) parseLightGBM(data []byte) error {
+	var dump struct {
+		NumClass      int      `json:"num_class"`
+		Objective     string   `json:"objective"`
+		AverageOutput bool     `json:"average_output"`
+		FeatureNames  []string `json:"feature_names"`
+		TreeInfo      []struct {
+			TreeStructure lightGBMNode `json:"tree_structure"`
+		} `json:"tree_info"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return fmt.Errorf("invalid LightGBM dump: %w", err)
+	}
+	if dump.NumClass > 1 {
+		return fmt.Errorf("multiclass LightGBM models are not supported")
+	}
+
+	m.format = GBDTFormatLightGBM
+	m.averageOutput = dump.AverageOutput
+	// The objective is written as e.g. "binary sigmoid:1"
+	parts := strings.Fields(dump.Objective)
+	if len(parts) > 0 {
+		m.objective = parts[0]
+	}
+	for _, p := range parts[1:] {
+		if v, ok := strings.CutPrefix(p, "sigmoid:"); ok {
+			m.sigmoid, _ = strconv.ParseFloat(v, 64)
+		}
+	}
+
+	features := newFeatureIndex(dump.FeatureNames)
+	for t, info := range dump.TreeInfo {
+		var tree gbdtTree
+		var add func(n *lightGBMNode) (int, error)
+		add = func(n *lightGBMNode) (int, error) {
+			i := len(tree)
+			tree = append(tree, gbdtNode{})
+			if n.SplitFeature == nil {
+				tree[i] = gbdtNode{leaf: true, value: n.LeafValue}
+				return i, nil
+			}
+			if n.LeftChild == nil || n.RightChild == nil {
+				return 0, fmt.Errorf("split node is missing a child")
+			}
+			f, err := features.resolveIndex(*n.SplitFeature)
+			if err != nil {
+				return 0, err
+			}
+			node := gbdtNode{feature: f, defaultLeft: n.DefaultLeft, missing: n.MissingType}
+			switch n.DecisionType {
+			case "==":
+				// Categorical thresholds list the categories that go left
+				var s string
+				if err := json.Unmarshal(n.Threshold, &s); err != nil {
+					return 0, fmt.Errorf("invalid categorical threshold %s", n.Threshold)
+				}
+				node.categories = make(map[int]bool)
+				for _, c := range strings.Split(s, "||") {
+					v, err := strconv.Atoi(c)
+					if err != nil {
+						return 0, fmt.Errorf("invalid category %q", c)
+					}
+					node.categories[v] = true
+				}
+			case "<=", "":
+				if err := json.Unmarshal(n.Threshold, &node.threshold); err != nil {
+					return 0, fmt.Errorf("invalid threshold %s", n.Threshold)
+				}
+				node.lessEqual = true
+			default:
+				return 0, fmt.Errorf("unsupported decision type %q", n.DecisionType)
+			}
+			if node.left, err = add(n.LeftChild); err != nil {
+				return 0, err
+			}
+			if node.right, err = add(n.RightChild); err != nil {
+				return 0, err
+			}
+			tree[i] = node
+			return i, nil
+		}
+		if _, err := add(&info.TreeStructure); err != nil {
+			return fmt.Errorf("tree %d: %w", t, err)
+		}
+		m.trees = append(m.trees, tree)
+	}
+	m.features = features.names
+	return nil
+}
+
+// xgboostBaseMargin converts XGBoost's base score, which is a probability
+// for logistic objectives, into a margin
+func xgboostBaseMargin(objective string, base float64) float64 {
+	switch objective {
+	case "binary:logistic", "reg:logistic":
+		if base <= 0 || base >= 1 {
+			return 0
+		}
+		return math.Log(base / (1 - base))
+	default:
+		return base
+	}
+}
+
+// featureIndex maps split references to feature positions. Without declared
+// names XGBoost refers to features as f0, f1 and so on.
+type featureIndex struct {
+	names    []string
+	declared bool
+	pos      map[string]int
+}
+
+func newFeatureIndex(names []string) *featureIndex {
+	fi := &featureIndex{names: append([]string(nil), names...), declared: len(names) > 0, pos: make(map[string]int)}
+	for i, n := range fi.names {
+		fi.pos[n] = i
+	}
+	return fi
+}
+
+func (fi *featureIndex) resolve(split string) (int, error) {
+	if i, ok := fi.pos[split]; ok {
+		return i, nil
+	}
+	if fi.declared {
+		return 0, fmt.Errorf("split on undeclared feature %q", split)
+	}
+	if v, ok := strings.CutPrefix(split, "f"); ok {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 {
+			return fi.resolveIndex(i)
+		}
+	}
+	// Dumps taken with feature names set refer to features by name
+	fi.pos[split] = len(fi.names)
+	fi.names = append(fi.names, split)
+	return len(fi.names) - 1, nil
+}
+
+func (fi *featureIndex) resolveIndex(i int) (int, error) {
+	if i < 0 {
+		return 0, fmt.Errorf("invalid feature index %d", i)
+	}
+	if i < len(fi.names) {
+		return i, nil
+	}
+	if fi.declared {
+		return 0, fmt.Errorf("feature index %d is out of range", i)
+	}
+	fi.pad(i + 1)
+	return i, nil
+}
+
+func (fi *featureIndex) pad(n int) {
+	for len(fi.names) < n {
+		name := "f" + strconv.Itoa(len(fi.names))
+		fi.pos[name] = len(fi.names)
+		fi.names = append(fi.names, name)
+	}
+}
+
+// flexBool decodes booleans written either as true/false or as 0/1
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
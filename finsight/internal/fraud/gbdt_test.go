@@ -0,0 +1,289 @@
+package fraud
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// The three fixtures encode the same two-tree ensemble:
+//
+//	tree 0: amount < 1000 ? -1.0 : (mcc_high_risk < 0.5 ? 0.5 : 1.5)
+//	tree 1: 0.2
+//
+// Missing amounts go right and missing MCC flags go left.
+const xgboostDump = `{
+	"objective": "binary:logistic",
+	"base_score": 0.5,
+	"feature_names": ["amount", "mcc_high_risk"],
+	"trees": [
+		{"nodeid": 0, "depth": 0, "split": "amount", "split_condition": 1000, "yes": 1, "no": 2, "missing": 2, "children": [
+			{"nodeid": 1, "leaf": -1.0},
+			{"nodeid": 2, "depth": 1, "split": "mcc_high_risk", "split_condition": 0.5, "yes": 3, "no": 4, "missing": 3, "children": [
+				{"nodeid": 3, "leaf": 0.5},
+				{"nodeid": 4, "leaf": 1.5}
+			]}
+		]},
+		{"nodeid": 0, "leaf": 0.2}
+	]
+}`
+
+const xgboostModel = `{
+	"learner": {
+		"feature_names": ["amount", "mcc_high_risk"],
+		"gradient_booster": {
+			"name": "gbtree",
+			"model": {
+				"trees": [
+					{
+						"left_children": [1, -1, 3, -1, -1],
+						"right_children": [2, -1, 4, -1, -1],
+						"split_indices": [0, 0, 1, 0, 0],
+						"split_conditions": [1000, -1.0, 0.5, 0.5, 1.5],
+						"default_left": [0, 0, 1, 0, 0],
+						"split_type": [0, 0, 0, 0, 0]
+					},
+					{
+						"left_children": [-1],
+						"right_children": [-1],
+						"split_indices": [0],
+						"split_conditions": [0.2],
+						"default_left": [0],
+						"split_type": [0]
+					}
+				]
+			}
+		},
+		"learner_model_param": {"base_score": "[5E-1]", "num_feature": "2"},
+		"objective": {"name": "binary:logistic"}
+	},
+	"version": [2, 0, 3]
+}`
+
+const lightGBMDump = `{
+	"name": "tree",
+	"version": "v3",
+	"num_class": 1,
+	"objective": "binary sigmoid:1",
+	"average_output": false,
+	"feature_names": ["amount", "mcc_high_risk"],
+	"tree_info": [
+		{"tree_index": 0, "tree_structure": {
+			"split_index": 0, "split_feature": 0, "threshold": 999.999, "decision_type": "<=",
+			"default_left": false, "missing_type": "NaN",
+			"left_child": {"leaf_index": 0, "leaf_value": -1.0},
+			"right_child": {
+				"split_index": 1, "split_feature": 1, "threshold": 0.5, "decision_type": "<=",
+				"default_left": true, "missing_type": "NaN",
+				"left_child": {"leaf_index": 1, "leaf_value": 0.5},
+				"right_child": {"leaf_index": 2, "leaf_value": 1.5}
+			}
+		}},
+		{"tree_index": 1, "tree_structure": {"leaf_value": 0.2}}
+	]
+}`
+
+func sigmoid(x float64) float64 { return 1 / (1 + math.Exp(-x)) }
+
+func TestParseGBDTModelFormats(t *testing.T) {
+	cases := []struct {
+		features Features
+		margin   float64
+	}{
+		{Features{"amount": 500, "mcc_high_risk": 1}, -0.8},
+		{Features{"amount": 2000, "mcc_high_risk": 0}, 0.7},
+		{Features{"amount": 2000, "mcc_high_risk": 1}, 1.7},
+		{Features{}, 0.7},
+	}
+
+	for format, data := range map[string]string{
+		GBDTFormatXGBoostDump: xgboostDump,
+		GBDTFormatXGBoostJSON: xgboostModel,
+		GBDTFormatLightGBM:    lightGBMDump,
+	} {
+		m, err := ParseGBDTModel("test", []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if m.Format() != format || m.Trees() != 2 || len(m.Features()) != 2 {
+			t.Fatalf("%s: unexpected model %s with %d trees and features %v", format, m.Format(), m.Trees(), m.Features())
+		}
+		for _, c := range cases {
+			got, err := m.Predict(c.features)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := sigmoid(c.margin); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: Predict(%v) = %f, want %f", format, c.features, got, want)
+			}
+		}
+	}
+}
+
+func TestParseGBDTModelFeatureNames(t *testing.T) {
+	// Plain dumps without feature names refer to features by index
+	m, err := ParseGBDTModel("plain", []byte(`[
+		{"nodeid": 0, "split": "f2", "split_condition": 1, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": -0.5}, {"nodeid": 2, "leaf": 0.5}
+		]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Features(); len(got) != 3 || got[2] != "f2" {
+		t.Errorf("unexpected features %v", got)
+	}
+	if m.Version() == "" {
+		t.Error("expected a content-derived version")
+	}
+
+	if _, err := ParseGBDTModel("bad", []byte(`{"feature_names": ["a"], "trees": [
+		{"nodeid": 0, "split": "b", "split_condition": 1, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": 0}, {"nodeid": 2, "leaf": 0}
+		]}
+	]}`)); err == nil {
+		t.Error("expected an error for a split on an undeclared feature")
+	}
+	if _, err := ParseGBDTModel("cyclic", []byte(`[
+		{"nodeid": 0, "split": "f0", "split_condition": 1, "yes": 0, "no": 0, "missing": 0}
+	]`)); err == nil {
+		t.Error("expected an error for a cyclic tree")
+	}
+}
+
+func TestLightGBMCategoricalSplit(t *testing.T) {
+	m, err := ParseGBDTModel("cat", []byte(`{
+		"objective": "regression",
+		"feature_names": ["day_of_week"],
+		"tree_info": [{"tree_structure": {
+			"split_feature": 0, "threshold": "0||6", "decision_type": "==",
+			"left_child": {"leaf_value": 0.9}, "right_child": {"leaf_value": 0.1}
+		}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range map[float64]float64{0: 0.9, 3: 0.1, 6: 0.9} {
+		if got, _ := m.Predict(Features{"day_of_week": day}); got != want {
+			t.Errorf("day %v scored %f, want %f", day, got, want)
+		}
+	}
+}
+
+func constantModel(t *testing.T, name string, margin float64) *GBDTModel {
+	t.Helper()
+	m, err := ParseGBDTModel(name, []byte(`[{"nodeid": 0, "leaf": `+decimal.NewFromFloat(margin).String()+`}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDetectorChampionChallenger(t *testing.T) {
+	detector := NewDetector(&config.FraudConfig{
+		Enabled:         true,
+		ScoreThreshold:  0.7,
+		VelocityWindow:  time.Hour,
+		MaxDailyAmount:  10000,
+		MaxSingleAmount: 5000,
+		MLModelWeight:   0.5,
+	})
+
+	if err := detector.InstallModel(constantModel(t, "champion", 5), ModelRoleChampion); err != nil {
+		t.Fatal(err)
+	}
+	if err := detector.InstallModel(constantModel(t, "challenger", -3), ModelRoleChallenger); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := &models.Transaction{
+		ID:        "txn-1",
+		Amount:    decimal.NewFromInt(20),
+		CreatedAt: time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC),
+	}
+	result := detector.Evaluate(txn, nil)
+
+	want := 0.5 * sigmoid(5)
+	if math.Abs(result.RiskScore-want) > 1e-9 || result.Decision != DecisionReview {
+		t.Fatalf("expected the champion to lift the score to review, got %f %s", result.RiskScore, result.Decision)
+	}
+	if result.Model == nil || result.Model.Model != "champion" {
+		t.Fatalf("expected the champion score on the result, got %+v", result.Model)
+	}
+	last := result.Indicators[len(result.Indicators)-1]
+	if last.Type != string(models.FraudAlertTypeModel) || last.Details["model"] != "champion" || math.Abs(last.Score-want) > 1e-9 {
+		t.Errorf("unexpected model indicator %+v", last)
+	}
+
+	status := detector.ModelScorer().Status()
+	if status.Champion.Scored != 1 || len(status.Challengers) != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	challenger := status.Challengers[0]
+	if challenger.Scored != 1 || challenger.Agreements != 0 || challenger.Decisions[DecisionAllow] != 1 {
+		t.Errorf("unexpected challenger stats %+v", challenger)
+	}
+	if len(status.Recent) != 1 || status.Recent[0].ChampionDecision != DecisionReview || status.Recent[0].Challenger.Decision != DecisionAllow {
+		t.Errorf("unexpected shadow results %+v", status.Recent)
+	}
+
+	if err := detector.ModelScorer().Promote("challenger"); err != nil {
+		t.Fatal(err)
+	}
+	result = detector.Evaluate(txn, nil)
+	if result.Decision != DecisionAllow || result.Model.Model != "challenger" {
+		t.Errorf("expected the promoted model to decide, got %s from %+v", result.Decision, result.Model)
+	}
+	if status := detector.ModelScorer().Status(); status.Challengers[0].Model != "champion" {
+		t.Errorf("expected the old champion to become a challenger, got %+v", status.Challengers)
+	}
+
+	if err := detector.InstallModel(constantModel(t, "unknown", 0), "canary"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+	plain, _ := ParseGBDTModel("plain", []byte(`[{"nodeid": 0, "split": "f0", "split_condition": 1, "yes": 1, "no": 2, "missing": 1, "children": [{"nodeid": 1, "leaf": 0}, {"nodeid": 2, "leaf": 1}]}]`))
+	if err := detector.InstallModel(plain, ModelRoleChallenger); err == nil {
+		t.Error("expected an error for features the pipeline does not produce")
+	}
+}
+
+func TestDefaultFeaturePipeline(t *testing.T) {
+	pipeline := DefaultFeaturePipeline(NewGeofenceChecker())
+	txn := &models.Transaction{
+		Amount:    decimal.NewFromInt(300),
+		CreatedAt: time.Date(2024, 3, 4, 3, 0, 0, 0, time.UTC),
+		Merchant:  &models.Merchant{ID: "m1", MCC: "7995"},
+	}
+	ctx := &EvaluationContext{
+		AccountHistory: []*models.Transaction{
+			{Amount: decimal.NewFromInt(300), CreatedAt: txn.CreatedAt.Add(-10 * time.Minute)},
+			{Amount: decimal.NewFromInt(2), CreatedAt: txn.CreatedAt.Add(-time.Hour)},
+		},
+		AccountProfile: &AccountProfile{AvgTransactionAmount: decimal.NewFromInt(100), TypicalMerchants: []string{"m2"}},
+	}
+
+	f := pipeline.Compute(txn, ctx)
+	for name, want := range map[string]float64{
+		"amount":                300,
+		"amount_to_avg":         3,
+		"is_night":              1,
+		"mcc_high_risk":         1,
+		"merchant_known":        0,
+		"seconds_since_last":    600,
+		"repeated_amount_count": 1,
+		"small_txn_count":       1,
+	} {
+		if got, ok := f.Get(name); !ok || got != want {
+			t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"device_known", "recent_count", "geo_high_risk"} {
+		if _, ok := f.Get(name); ok {
+			t.Errorf("%s should be missing without context", name)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package fraud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of a MaxMind DB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// GeoIPDatabase resolves IP addresses to locations from a MaxMind DB file,
+// such as GeoLite2-City.mmdb or GeoIP2-Country.mmdb
+type GeoIPDatabase struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize int
+	ipVersion  int
+	ipv4Start  uint32
+	Type       string
+	BuildEpoch uint64
+}
+
+// OpenGeoIPDatabase reads a MaxMind DB file
+func OpenGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGeoIPDatabase(data)
+}
+
+// ParseGeoIPDatabase parses the contents of a MaxMind DB file
+func ParseGeoIPDatabase(data []byte) (*GeoIPDatabase, error) {
+	at := bytes.LastIndex(data, metadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("geoip: metadata marker not found")
+	}
+	metaSection := data[at+len(metadataMarker):]
+	raw, _, err := (&mmdbDecoder{data: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: metadata: %w", err)
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("geoip: metadata is not a map")
+	}
+
+	db := &GeoIPDatabase{
+		nodeCount:  uint32(mmdbUint(meta["node_count"])),
+		recordSize: int(mmdbUint(meta["record_size"])),
+		ipVersion:  int(mmdbUint(meta["ip_version"])),
+		BuildEpoch: mmdbUint(meta["build_epoch"]),
+	}
+	db.Type, _ = meta["database_type"].(string)
+	if major := mmdbUint(meta["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("geoip: unsupported binary format version %d", major)
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("geoip: unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("geoip: unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := int(db.nodeCount) * db.recordSize / 4
+	// The search tree is followed by 16 zero bytes, then the data section
+	if treeSize+16 > at {
+		return nil, fmt.Errorf("geoip: search tree exceeds file size")
+	}
+	db.tree = data[:treeSize]
+	db.data = data[treeSize+16 : at]
+
+	// IPv4 addresses are found under ::/96 of an IPv6 tree
+	if db.ipVersion == 6 {
+		node := uint32(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Lookup returns the location of an IP address, or nil when the database
+// has no entry for it
+func (db *GeoIPDatabase) Lookup(ip net.IP) (*GeoLocation, error) {
+	record, err := db.lookupRecord(ip)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	geo := &GeoLocation{IPAddress: ip.String()}
+	// The country of the registration is the fallback for networks without
+	// a physical location
+	for _, key := range []string{"country", "registered_country"} {
+		if code, ok := mmdbPath(record, key, "iso_code").(string); ok && code != "" {
+			geo.Country = code
+			break
+		}
+	}
+	geo.City, _ = mmdbPath(record, "city", "names", "en").(string)
+	if lat, ok := mmdbPath(record, "location", "latitude").(float64); ok {
+		geo.Latitude = lat
+	}
+	if lon, ok := mmdbPath(record, "location", "longitude").(float64); ok {
+		geo.Longitude = lon
+	}
+	return geo, nil
+}
+
+// LookupString parses and looks up an IP address
+func (db *GeoIPDatabase) LookupString(addr string) (*GeoLocation, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("geoip: invalid IP address %q", addr)
+	}
+	return db.Lookup(ip)
+}
+
+func (db *GeoIPDatabase) lookupRecord(ip net.IP) (interface{}, error) {
+	node := uint32(0)
+	bits := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, fmt.Errorf("geoip: IPv6 lookup in an IPv4 database")
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, int(bit))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, fmt.Errorf("geoip: invalid search tree")
+	}
+
+	offset := int(node-db.nodeCount) - 16
+	if offset < 0 || offset >= len(db.data) {
+		return nil, fmt.Errorf("geoip: invalid data pointer")
+	}
+	value, _, err := (&mmdbDecoder{data: db.data}).decode(offset)
+	return value, err
+}
+
+// record returns the left (0) or right (1) record of a search tree node
+func (db *GeoIPDatabase) record(node uint32, side int) uint32 {
+	b := db.tree[int(node)*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[side*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		// The middle byte holds the high nibble of each record
+		if side == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(b[side*4:])
+	}
+}
+
+// MaxMind DB data section types
+const (
+	mmdbExtended  = 0
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEndMarker = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+// mmdbDecoder decodes values of a MaxMind DB data section. Maps decode to
+// map[string]interface{}, arrays to []interface{}, unsigned integers to
+// uint64 (uint128 to *big.Int) and floats to float64.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// decode decodes the value at offset, returning the offset after it
+func (d *mmdbDecoder) decode(offset int) (interface{}, int, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *mmdbDecoder) decodeDepth(offset, depth int) (interface{}, int, error) {
+	if depth > 64 {
+		return nil, 0, fmt.Errorf("data nested too deeply")
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == mmdbPointer {
+		// A pointer resolves to the value it points at; decoding continues
+		// after the pointer itself
+		value, _, err := d.decodeDepth(size, depth+1)
+		return value, offset, err
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			if value, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.data) {
+		return nil, 0, fmt.Errorf("value exceeds data section")
+	}
+	b := d.data[offset : offset+size]
+	end := offset + size
+	switch typ {
+	case mmdbString:
+		return string(b), end, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), end, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, end, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		// Negative values always use all four bytes
+		return int64(int32(v)), end, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(b), end, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// control reads a control byte and its extensions, returning the type, the
+// size (or pointer target) and the offset of the payload
+func (d *mmdbDecoder) control(offset int) (typ, size, next int, err error) {
+	if offset >= len(d.data) {
+		return 0, 0, 0, fmt.Errorf("offset %d exceeds data section", offset)
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ = int(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		n := int(ctrl>>3) & 0x3
+		if offset+n+1 > len(d.data) {
+			return 0, 0, 0, fmt.Errorf("pointer exceeds data section")
+		}
+		b := d.data[offset : offset+n+1]
+		var p int
+		if n < 3 {
+			p = int(ctrl & 0x7)
+		}
+		for _, c := range b {
+			p = p<<8 | int(c)
+		}
+		p += [4]int{0, 2048, 526336, 0}[n]
+		return typ, p, offset + n + 1, nil
+	}
+
+	if typ == mmdbExtended {
+		if offset >= len(d.data) {
+			return 0, 0, 0, fmt.Errorf("extended type exceeds data section")
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+		if typ < mmdbInt32 {
+			return 0, 0, 0, fmt.Errorf("invalid extended type %d", typ)
+		}
+	}
+
+	size = int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.data) {
+			return 0, 0, 0, fmt.Errorf("size exceeds data section")
+		}
+		ext := 0
+		for _, c := range d.data[offset : offset+n] {
+			ext = ext<<8 | int(c)
+		}
+		size = [4]int{0, 29, 285, 65821}[n] + ext
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+// mmdbPath follows map keys through a decoded record
+func mmdbPath(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func mmdbUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,232 @@
+package fraud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbWriter encodes values in the MaxMind DB data format
+type mmdbWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *mmdbWriter) control(typ, size int) {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	default:
+		ext = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	}
+	if typ > 7 {
+		w.buf.WriteByte(byte(size))
+		w.buf.WriteByte(byte(typ - 7))
+	} else {
+		w.buf.WriteByte(byte(typ<<5 | size))
+	}
+	w.buf.Write(ext)
+}
+
+func (w *mmdbWriter) write(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		w.control(mmdbString, len(v))
+		w.buf.WriteString(v)
+	case float64:
+		w.control(mmdbDouble, 8)
+		binary.Write(&w.buf, binary.BigEndian, math.Float64bits(v))
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		b = bytes.TrimLeft(b, "\x00")
+		w.control(mmdbUint32, len(b))
+		w.buf.Write(b)
+	case uint16:
+		w.control(mmdbUint16, 2)
+		binary.Write(&w.buf, binary.BigEndian, v)
+	case uint64:
+		w.control(mmdbUint64, 8)
+		binary.Write(&w.buf, binary.BigEndian, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.control(mmdbMap, len(v))
+		for _, k := range keys {
+			w.write(k)
+			w.write(v[k])
+		}
+	}
+}
+
+// buildMMDB builds an IPv6 database with 24-bit records mapping IPv4
+// networks to records
+func buildMMDB(t *testing.T, networks map[string]map[string]interface{}) []byte {
+	t.Helper()
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	var data mmdbWriter
+	// Data records are stored as negative offsets - 2 until the node count
+	// is known
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+		offset := data.buf.Len()
+		data.write(record)
+
+		ip := network.IP.To16()
+		ip[10], ip[11] = 0, 0 // ::a.b.c.d rather than ::ffff:a.b.c.d
+		node := 0
+		for i := 0; i < 96+ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == 96+ones-1 {
+				nodes[node][bit] = -offset - 2
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	count := len(nodes)
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, r := range n {
+			v := r
+			switch {
+			case r == empty:
+				v = count
+			case r < empty:
+				v = count + 16 + (-r - 2)
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.buf.Bytes())
+	out.Write(metadataMarker)
+	var meta mmdbWriter
+	meta.write(map[string]interface{}{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(6),
+		"database_type":               "Test-City",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1767225600),
+	})
+	out.Write(meta.buf.Bytes())
+	return out.Bytes()
+}
+
+func TestGeoIPDatabase_Lookup(t *testing.T) {
+	data := buildMMDB(t, map[string]map[string]interface{}{
+		"81.2.69.0/24": {
+			"country":  map[string]interface{}{"iso_code": "GB"},
+			"city":     map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+			"location": map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931},
+		},
+		"175.16.199.0/24": {
+			"registered_country": map[string]interface{}{"iso_code": "CN"},
+		},
+	})
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenGeoIPDatabase(path)
+	if err != nil {
+		t.Fatalf("OpenGeoIPDatabase failed: %v", err)
+	}
+	if db.Type != "Test-City" || db.BuildEpoch != 1767225600 {
+		t.Errorf("unexpected metadata: type %q, epoch %d", db.Type, db.BuildEpoch)
+	}
+
+	geo, err := db.LookupString("81.2.69.160")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if geo == nil || geo.Country != "GB" || geo.City != "London" || geo.Latitude != 51.5142 || geo.Longitude != -0.0931 {
+		t.Errorf("unexpected location: %+v", geo)
+	}
+
+	geo, err = db.LookupString("175.16.199.1")
+	if err != nil || geo == nil || geo.Country != "CN" {
+		t.Errorf("expected registered country fallback, got %+v, %v", geo, err)
+	}
+
+	geo, err = db.LookupString("10.0.0.1")
+	if err != nil || geo != nil {
+		t.Errorf("expected no entry, got %+v, %v", geo, err)
+	}
+
+	if _, err := db.LookupString("not-an-ip"); err == nil {
+		t.Error("expected error for invalid IP")
+	}
+}
+
+func TestParseGeoIPDatabase_Invalid(t *testing.T) {
+	if _, err := ParseGeoIPDatabase([]byte("not a database")); err == nil {
+		t.Error("expected error for missing metadata")
+	}
+}
+
+func TestMMDBDecoder_Pointer(t *testing.T) {
+	var w mmdbWriter
+	w.write("shared")
+	// A map whose value is a pointer to offset 0
+	w.control(mmdbMap, 1)
+	w.write("key")
+	w.buf.WriteByte(byte(mmdbPointer << 5))
+	w.buf.WriteByte(0)
+
+	value, _, err := (&mmdbDecoder{data: w.buf.Bytes()}).decode(7)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if m, ok := value.(map[string]interface{}); !ok || m["key"] != "shared" {
+		t.Errorf("unexpected value: %#v", value)
+	}
+}
+
+func TestDetector_EvaluateLocatesIP(t *testing.T) {
+	data := buildMMDB(t, map[string]map[string]interface{}{
+		"5.8.0.0/16": {"country": map[string]interface{}{"iso_code": "RU"}},
+	})
+	db, err := ParseGeoIPDatabase(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detector := newDSLDetector()
+	detector.geoip = db
+	txn := dslTxn("t1", 100, 0, "m1")
+	txn.Metadata = map[string]string{MetaIPAddress: "5.8.1.2"}
+
+	evalCtx := detector.enrichContext(txn, nil)
+	if evalCtx == nil || evalCtx.GeoLocation == nil || evalCtx.GeoLocation.Country != "RU" {
+		t.Fatalf("expected location from IP, got %+v", evalCtx)
+	}
+	result := NewGeolocationRule(detector.geofence).Evaluate(txn, evalCtx)
+	if !result.Triggered {
+		t.Error("expected high-risk country from IP to trigger the geolocation rule")
+	}
+}
@@ -0,0 +1,372 @@
+package fraud
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Model scores transactions from pipeline features
+type Model interface {
+	Name() string
+	Version() string
+	// Features lists the pipeline features the model reads
+	Features() []string
+	// Predict returns a fraud probability between 0 and 1
+	Predict(f Features) (float64, error)
+}
+
+// Model roles
+const (
+	ModelRoleChampion   = "champion"
+	ModelRoleChallenger = "challenger"
+)
+
+const (
+	defaultModelWeight = 0.5
+	maxShadowResults   = 200
+)
+
+// ModelScore is one model's score for a transaction
+type ModelScore struct {
+	Model       string   `json:"model"`
+	Version     string   `json:"version"`
+	Role        string   `json:"role"`
+	Probability float64  `json:"probability"`
+	RiskScore   float64  `json:"risk_score"`
+	Decision    Decision `json:"decision"`
+}
+
+// ShadowResult compares a challenger against the champion on one
+// transaction. Challenger scores never affect the decision returned.
+type ShadowResult struct {
+	TransactionID    string     `json:"transaction_id"`
+	Challenger       ModelScore `json:"challenger"`
+	ChampionScore    float64    `json:"champion_score"`
+	ChampionDecision Decision   `json:"champion_decision"`
+	Timestamp        time.Time  `json:"timestamp"`
+}
+
+// ModelStats accumulates scoring statistics for a model
+type ModelStats struct {
+	Model          string           `json:"model"`
+	Version        string           `json:"version"`
+	Role           string           `json:"role"`
+	Scored         int              `json:"scored"`
+	Errors         int              `json:"errors"`
+	MeanScore      float64          `json:"mean_score"`
+	Decisions      map[Decision]int `json:"decisions"`
+	Agreements     int              `json:"agreements,omitempty"`
+	AgreementRate  float64          `json:"agreement_rate,omitempty"`
+	sumProbability float64
+}
+
+// ModelStatus describes the loaded models
+type ModelStatus struct {
+	Weight      float64        `json:"weight"`
+	Features    []string       `json:"features"`
+	Champion    *ModelStats    `json:"champion,omitempty"`
+	Challengers []*ModelStats  `json:"challengers"`
+	Recent      []ShadowResult `json:"recent_shadow_results"`
+}
+
+// ModelScorer blends a champion model into the rule score and scores
+// challengers in shadow mode
+type ModelScorer struct {
+	pipeline    *FeaturePipeline
+	weight      float64
+	champion    Model
+	challengers map[string]Model
+	stats       map[string]*ModelStats
+	recent      []ShadowResult
+	mu          sync.RWMutex
+}
+
+// NewModelScorer creates a scorer. The weight is the share of the final
+// risk score taken by the champion model.
+func NewModelScorer(pipeline *FeaturePipeline, weight float64) *ModelScorer {
+	if weight <= 0 || weight > 1 {
+		weight = defaultModelWeight
+	}
+	return &ModelScorer{
+		pipeline:    pipeline,
+		weight:      weight,
+		challengers: make(map[string]Model),
+		stats:       make(map[string]*ModelStats),
+	}
+}
+
+func (s *ModelScorer) checkFeatures(m Model) error {
+	known := make(map[string]bool)
+	for _, name := range s.pipeline.Names() {
+		known[name] = true
+	}
+	for _, name := range m.Features() {
+		if !known[name] {
+			return fmt.Errorf("model %s reads feature %q which the pipeline does not produce", m.Name(), name)
+		}
+	}
+	return nil
+}
+
+// SetChampion installs the model that contributes to decisions
+func (s *ModelScorer) SetChampion(m Model) error {
+	if err := s.checkFeatures(m); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.challengers, m.Name())
+	s.champion = m
+	s.stats[m.Name()] = newModelStats(m, ModelRoleChampion)
+	return nil
+}
+
+// AddChallenger adds or replaces a shadow model
+func (s *ModelScorer) AddChallenger(m Model) error {
+	if err := s.checkFeatures(m); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.champion != nil && s.champion.Name() == m.Name() {
+		return fmt.Errorf("model %s is the champion", m.Name())
+	}
+	s.challengers[m.Name()] = m
+	s.stats[m.Name()] = newModelStats(m, ModelRoleChallenger)
+	return nil
+}
+
+// RemoveModel unloads a model by name
+func (s *ModelScorer) RemoveModel(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.champion != nil && s.champion.Name() == name:
+		s.champion = nil
+	case s.challengers[name] != nil:
+		delete(s.challengers, name)
+	default:
+		return ErrModelNotFound
+	}
+	delete(s.stats, name)
+	return nil
+}
+
+// Promote makes a challenger the champion. The previous champion becomes a
+// challenger so it keeps being compared.
+func (s *ModelScorer) Promote(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.challengers[name]
+	if !ok {
+		return ErrModelNotFound
+	}
+	delete(s.challengers, name)
+	if old := s.champion; old != nil {
+		s.challengers[old.Name()] = old
+		s.stats[old.Name()] = newModelStats(old, ModelRoleChallenger)
+	}
+	s.champion = m
+	s.stats[name] = newModelStats(m, ModelRoleChampion)
+	return nil
+}
+
+// Score computes features once, scores the champion and every challenger,
+// and returns the champion's score blended with the rule score. The result
+// is nil when no champion is loaded or it fails to score.
+func (s *ModelScorer) Score(txn *models.Transaction, ctx *EvaluationContext, ruleScore float64, decide func(float64) Decision) *ModelScore {
+	s.mu.RLock()
+	champion := s.champion
+	challengers := make([]Model, 0, len(s.challengers))
+	for _, m := range s.challengers {
+		challengers = append(challengers, m)
+	}
+	s.mu.RUnlock()
+
+	if champion == nil && len(challengers) == 0 {
+		return nil
+	}
+
+	features := s.pipeline.Compute(txn, ctx)
+
+	var championScore *ModelScore
+	if champion != nil {
+		championScore = s.score(champion, ModelRoleChampion, features, ruleScore, decide)
+	}
+	// Without a champion the challengers are compared against the rules
+	baseline := ModelScore{RiskScore: ruleScore, Decision: decide(ruleScore)}
+	if championScore != nil {
+		baseline = *championScore
+	}
+
+	sort.Slice(challengers, func(i, j int) bool { return challengers[i].Name() < challengers[j].Name() })
+	now := time.Now()
+	for _, m := range challengers {
+		score := s.score(m, ModelRoleChallenger, features, ruleScore, decide)
+		if score == nil {
+			continue
+		}
+		s.mu.Lock()
+		if st, ok := s.stats[m.Name()]; ok && st.Version == m.Version() {
+			if score.Decision == baseline.Decision {
+				st.Agreements++
+			}
+			st.AgreementRate = float64(st.Agreements) / float64(st.Scored)
+		}
+		s.recent = append(s.recent, ShadowResult{
+			TransactionID:    txn.ID,
+			Challenger:       *score,
+			ChampionScore:    baseline.RiskScore,
+			ChampionDecision: baseline.Decision,
+			Timestamp:        now,
+		})
+		if len(s.recent) > maxShadowResults {
+			s.recent = s.recent[len(s.recent)-maxShadowResults:]
+		}
+		s.mu.Unlock()
+	}
+	return championScore
+}
+
+func (s *ModelScorer) score(m Model, role string, features Features, ruleScore float64, decide func(float64) Decision) *ModelScore {
+	p, err := m.Predict(features)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, tracked := s.stats[m.Name()]
+	tracked = tracked && st.Version == m.Version()
+	if err != nil {
+		log.Printf("fraud: model %s failed to score: %v", m.Name(), err)
+		if tracked {
+			st.Errors++
+		}
+		return nil
+	}
+
+	risk := (1-s.weight)*ruleScore + s.weight*p
+	score := &ModelScore{
+		Model:       m.Name(),
+		Version:     m.Version(),
+		Role:        role,
+		Probability: p,
+		RiskScore:   risk,
+		Decision:    decide(risk),
+	}
+	if tracked {
+		st.Scored++
+		st.sumProbability += p
+		st.MeanScore = st.sumProbability / float64(st.Scored)
+		st.Decisions[score.Decision]++
+	}
+	return score
+}
+
+// blend scores the champion without recording stats or shadow results, for
+// replays. It reports false when no champion is loaded or it fails to score.
+func (s *ModelScorer) blend(txn *models.Transaction, ctx *EvaluationContext, ruleScore float64) (float64, bool) {
+	s.mu.RLock()
+	champion := s.champion
+	s.mu.RUnlock()
+	if champion == nil {
+		return ruleScore, false
+	}
+	p, err := champion.Predict(s.pipeline.Compute(txn, ctx))
+	if err != nil {
+		return ruleScore, false
+	}
+	return (1-s.weight)*ruleScore + s.weight*p, true
+}
+
+// Indicator records a champion score's contribution to the risk score
+func (s *ModelScorer) Indicator(score *ModelScore, ruleScore float64) models.FraudIndicator {
+	return models.FraudIndicator{
+		Type:        string(models.FraudAlertTypeModel),
+		Description: "Machine learning model score",
+		Score:       score.RiskScore - ruleScore,
+		Details: map[string]interface{}{
+			"model":       score.Model,
+			"version":     score.Version,
+			"probability": score.Probability,
+			"weight":      s.weight,
+			"rule_score":  ruleScore,
+		},
+	}
+}
+
+// Status returns the loaded models and their statistics
+func (s *ModelScorer) Status() *ModelStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := &ModelStatus{
+		Weight:      s.weight,
+		Features:    s.pipeline.Names(),
+		Challengers: []*ModelStats{},
+		Recent:      append([]ShadowResult{}, s.recent...),
+	}
+	if s.champion != nil {
+		status.Champion = s.stats[s.champion.Name()].snapshot()
+	}
+	for name := range s.challengers {
+		status.Challengers = append(status.Challengers, s.stats[name].snapshot())
+	}
+	sort.Slice(status.Challengers, func(i, j int) bool { return status.Challengers[i].Model < status.Challengers[j].Model })
+	return status
+}
+
+func newModelStats(m Model, role string) *ModelStats {
+	return &ModelStats{Model: m.Name(), Version: m.Version(), Role: role, Decisions: make(map[Decision]int)}
+}
+
+func (st *ModelStats) snapshot() *ModelStats {
+	c := *st
+	c.Decisions = make(map[Decision]int, len(st.Decisions))
+	for k, v := range st.Decisions {
+		c.Decisions[k] = v
+	}
+	return &c
+}
+
+// decide maps a risk score to a decision using the same thresholds as
+// Evaluate
+func (d *Detector) decide(score float64) Decision {
+	switch {
+	case score >= d.config.ScoreThreshold:
+		return DecisionBlock
+	case score >= d.config.ScoreThreshold*0.7:
+		return DecisionReview
+	default:
+		return DecisionAllow
+	}
+}
+
+// ModelScorer returns the detector's model scorer
+func (d *Detector) ModelScorer() *ModelScorer {
+	return d.scorer
+}
+
+// LoadModel loads a GBDT model file as the champion or a challenger
+func (d *Detector) LoadModel(path, role string) (Model, error) {
+	m, err := LoadGBDTModel(path)
+	if err != nil {
+		return nil, err
+	}
+	return m, d.InstallModel(m, role)
+}
+
+// InstallModel installs a model in the given role
+func (d *Detector) InstallModel(m Model, role string) error {
+	switch role {
+	case ModelRoleChampion, "":
+		return d.scorer.SetChampion(m)
+	case ModelRoleChallenger:
+		return d.scorer.AddChallenger(m)
+	default:
+		return fmt.Errorf("unknown model role %q", role)
+	}
+}
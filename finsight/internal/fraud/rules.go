@@ -24,7 +24,7 @@ func (r *AmountRule) Priority() int { return 100 }
 func (r *AmountRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext) *RuleResult {
 	result := &RuleResult{}
 
-	amount := txn.Amount.InexactFloat64()
+	amount := txn.ReportingAmount().InexactFloat64()
 
 	// Check against absolute max
 	if amount > r.maxAmount {
@@ -379,6 +379,16 @@ func (r *TimeRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext) *Ru
 	return result
 }
 
+// highRiskMCCs lists merchant category codes with elevated fraud rates
+var highRiskMCCs = map[string]bool{
+	"5967": true, // Direct Marketing - Inbound Teleservices Merchant
+	"5966": true, // Direct Marketing - Outbound Telemarketing
+	"7995": true, // Betting/Casino Gambling
+	"5962": true, // Direct Marketing - Travel
+	"4829": true, // Money Transfer
+	"6051": true, // Quasi Cash - Cryptocurrency
+}
+
 // MerchantRule detects suspicious merchant activity
 type MerchantRule struct{}
 
@@ -398,15 +408,6 @@ func (r *MerchantRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext)
 	}
 
 	// Check for high-risk MCC codes
-	highRiskMCCs := map[string]bool{
-		"5967": true, // Direct Marketing - Inbound Teleservices Merchant
-		"5966": true, // Direct Marketing - Outbound Telemarketing
-		"7995": true, // Betting/Casino Gambling
-		"5962": true, // Direct Marketing - Travel
-		"4829": true, // Money Transfer
-		"6051": true, // Quasi Cash - Cryptocurrency
-	}
-
 	if highRiskMCCs[txn.Merchant.MCC] {
 		result.Triggered = true
 		result.Score = 2.0
@@ -449,3 +450,100 @@ func (r *MerchantRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext)
 
 	return result
 }
+
+// DeviceRule detects high-value transactions from devices new to the account
+type DeviceRule struct {
+	highValue float64
+	window    time.Duration
+}
+
+// NewDeviceRule creates a new device rule. A device is new until the
+// account has used it for window; transactions of at least highValue from
+// a new, untrusted device trigger the rule.
+func NewDeviceRule(highValue float64, window time.Duration) *DeviceRule {
+	return &DeviceRule{highValue: highValue, window: window}
+}
+
+func (r *DeviceRule) Name() string { return "new_device" }
+func (r *DeviceRule) Priority() int { return 45 }
+
+func (r *DeviceRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext) *RuleResult {
+	result := &RuleResult{}
+
+	if ctx == nil || ctx.DeviceInfo == nil || ctx.DeviceInfo.DeviceID == "" || r.highValue <= 0 {
+		return result
+	}
+
+	device := ctx.DeviceInfo
+	if device.IsTrusted {
+		return result
+	}
+	age := deviceTime(txn).Sub(device.FirstSeen)
+	if device.IsKnown && age >= r.window {
+		return result
+	}
+
+	amount := txn.ReportingAmount().InexactFloat64()
+	if amount >= r.highValue {
+		result.Triggered = true
+		result.Score = 2.5
+		result.Description = "High-value transaction from a new device"
+		result.Indicators = append(result.Indicators, models.FraudIndicator{
+			Type:        "device",
+			Description: "High-value transaction from a new device",
+			Score:       2.5,
+			Details: map[string]interface{}{
+				"device_id": device.DeviceID,
+				"known":     device.IsKnown,
+				"age_hours": math.Max(age.Hours(), 0),
+				"amount":    amount,
+				"threshold": r.highValue,
+			},
+		})
+	}
+
+	return result
+}
+
+// DeviceSharingRule detects devices used by many accounts, a sign of
+// account takeover or mule networks
+type DeviceSharingRule struct {
+	maxAccounts int
+}
+
+// NewDeviceSharingRule creates a new device sharing rule
+func NewDeviceSharingRule(maxAccounts int) *DeviceSharingRule {
+	return &DeviceSharingRule{maxAccounts: maxAccounts}
+}
+
+func (r *DeviceSharingRule) Name() string { return "device_sharing" }
+func (r *DeviceSharingRule) Priority() int { return 44 }
+
+func (r *DeviceSharingRule) Evaluate(txn *models.Transaction, ctx *EvaluationContext) *RuleResult {
+	result := &RuleResult{}
+
+	if ctx == nil || ctx.DeviceInfo == nil || r.maxAccounts <= 0 {
+		return result
+	}
+
+	device := ctx.DeviceInfo
+	if device.AccountCount > r.maxAccounts {
+		// Each account beyond the limit adds to the score
+		score := math.Min(2.0+0.5*float64(device.AccountCount-r.maxAccounts-1), 4.0)
+		result.Triggered = true
+		result.Score = score
+		result.Description = "Device shared across accounts"
+		result.Indicators = append(result.Indicators, models.FraudIndicator{
+			Type:        "device",
+			Description: "Device used by multiple accounts",
+			Score:       score,
+			Details: map[string]interface{}{
+				"device_id": device.DeviceID,
+				"accounts":  device.AccountCount,
+				"limit":     r.maxAccounts,
+			},
+		})
+	}
+
+	return result
+}
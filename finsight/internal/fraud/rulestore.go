@@ -0,0 +1,458 @@
+package fraud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+)
+
+const maxRuleHits = 500
+
+// RuleStore holds versioned rule definitions and the compiled set the
+// detector evaluates. Updates compile before they are applied, so a bad
+// definition never replaces a working rule.
+type RuleStore struct {
+	path     string
+	geofence *GeofenceChecker
+	tracker  *VelocityTracker
+	rules    map[string]*ruleEntry
+	active   []*DSLRule
+	mu       sync.RWMutex
+}
+
+type ruleEntry struct {
+	versions []models.ComplianceRule
+	deleted  bool
+	hits     []RuleHit
+}
+
+func (e *ruleEntry) current() models.ComplianceRule {
+	return e.versions[len(e.versions)-1]
+}
+
+// NewRuleStore creates a rule store. The path, when set, is the rule file
+// Reload reads.
+func NewRuleStore(path string, geofence *GeofenceChecker, tracker *VelocityTracker) *RuleStore {
+	return &RuleStore{
+		path:     path,
+		geofence: geofence,
+		tracker:  tracker,
+		rules:    make(map[string]*ruleEntry),
+	}
+}
+
+var ruleIDPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Put validates a rule and stores it as a new version. Rules without an ID
+// are identified by their slugified name.
+func (s *RuleStore) Put(def models.ComplianceRule, user string) (*models.ComplianceRule, error) {
+	if def.ID == "" {
+		def.ID = strings.Trim(ruleIDPattern.ReplaceAllString(strings.ToLower(def.Name), "-"), "-")
+	}
+	if _, err := CompileRule(def); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.put(def, user, time.Now())
+	s.rebuild()
+	return &stored, nil
+}
+
+func (s *RuleStore) put(def models.ComplianceRule, user string, now time.Time) models.ComplianceRule {
+	entry, ok := s.rules[def.ID]
+	if !ok {
+		entry = &ruleEntry{}
+		s.rules[def.ID] = entry
+	}
+	def = cloneRule(def)
+	def.Version = len(entry.versions) + 1
+	def.UpdatedBy = user
+	def.UpdatedAt = now
+	entry.versions = append(entry.versions, def)
+	entry.deleted = false
+	return cloneRule(def)
+}
+
+// cloneRule deep-copies a definition so stored versions never share
+// condition or action slices with callers
+func cloneRule(def models.ComplianceRule) models.ComplianceRule {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return def
+	}
+	var c models.ComplianceRule
+	if err := json.Unmarshal(data, &c); err != nil {
+		return def
+	}
+	return c
+}
+
+// rebuild recompiles the active rule set. Callers hold the write lock.
+func (s *RuleStore) rebuild() {
+	active := make([]*DSLRule, 0, len(s.rules))
+	for id, entry := range s.rules {
+		def := entry.current()
+		if entry.deleted || !def.Enabled {
+			continue
+		}
+		// Stored definitions have already compiled once
+		r, err := CompileRule(def)
+		if err != nil {
+			continue
+		}
+		r.geofence = s.geofence
+		r.tracker = s.tracker
+		id := id
+		r.onDryRun = func(hit RuleHit) { s.recordHit(id, hit) }
+		active = append(active, r)
+	}
+	sortRules(active)
+	s.active = active
+}
+
+func (s *RuleStore) recordHit(id string, hit RuleHit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.rules[id]
+	if !ok {
+		return
+	}
+	entry.hits = append(entry.hits, hit)
+	if len(entry.hits) > maxRuleHits {
+		entry.hits = entry.hits[len(entry.hits)-maxRuleHits:]
+	}
+}
+
+// Active returns the enabled rules in evaluation order
+func (s *RuleStore) Active() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := make([]Rule, len(s.active))
+	for i, r := range s.active {
+		rules[i] = r
+	}
+	return rules
+}
+
+// Get returns the current version of a rule
+func (s *RuleStore) Get(id string) (*models.ComplianceRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.rules[id]
+	if !ok || entry.deleted {
+		return nil, false
+	}
+	def := cloneRule(entry.current())
+	return &def, true
+}
+
+// List returns the current version of every rule ordered by priority
+func (s *RuleStore) List() []*models.ComplianceRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var rules []*models.ComplianceRule
+	for _, entry := range s.rules {
+		if !entry.deleted {
+			def := cloneRule(entry.current())
+			rules = append(rules, &def)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// Versions returns every stored version of a rule, oldest first
+func (s *RuleStore) Versions(id string) ([]models.ComplianceRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.rules[id]
+	if !ok {
+		return nil, ErrRuleNotFound
+	}
+	versions := make([]models.ComplianceRule, len(entry.versions))
+	for i, v := range entry.versions {
+		versions[i] = cloneRule(v)
+	}
+	return versions, nil
+}
+
+// Rollback stores a copy of an earlier version as the newest version
+func (s *RuleStore) Rollback(id string, version int, user string) (*models.ComplianceRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.rules[id]
+	if !ok {
+		return nil, ErrRuleNotFound
+	}
+	if version < 1 || version > len(entry.versions) {
+		return nil, fmt.Errorf("rule %s has no version %d", id, version)
+	}
+	stored := s.put(entry.versions[version-1], user, time.Now())
+	s.rebuild()
+	return &stored, nil
+}
+
+// Delete removes a rule from evaluation. Its history is kept.
+func (s *RuleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.rules[id]
+	if !ok || entry.deleted {
+		return ErrRuleNotFound
+	}
+	entry.deleted = true
+	s.rebuild()
+	return nil
+}
+
+// Hits returns the matches recorded for a rule running in dry-run mode
+func (s *RuleStore) Hits(id string) ([]RuleHit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.rules[id]
+	if !ok {
+		return nil, ErrRuleNotFound
+	}
+	return append([]RuleHit{}, entry.hits...), nil
+}
+
+// RuleLoadResult summarises a bulk load
+type RuleLoadResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Removed   []string `json:"removed"`
+}
+
+// Load replaces the rule set with the given definitions. Changed rules get
+// a new version and rules missing from the set are removed. Nothing is
+// applied unless every definition compiles.
+func (s *RuleStore) Load(defs []models.ComplianceRule, user string) (*RuleLoadResult, error) {
+	seen := make(map[string]bool)
+	for i := range defs {
+		if _, err := CompileRule(defs[i]); err != nil {
+			return nil, err
+		}
+		if seen[defs[i].ID] {
+			return nil, fmt.Errorf("duplicate rule id %s", defs[i].ID)
+		}
+		seen[defs[i].ID] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &RuleLoadResult{}
+	now := time.Now()
+	for _, def := range defs {
+		entry, ok := s.rules[def.ID]
+		switch {
+		case !ok || entry.deleted:
+			result.Created = append(result.Created, def.ID)
+		case sameRule(entry.current(), def):
+			result.Unchanged = append(result.Unchanged, def.ID)
+			continue
+		default:
+			result.Updated = append(result.Updated, def.ID)
+		}
+		s.put(def, user, now)
+	}
+	for id, entry := range s.rules {
+		if !seen[id] && !entry.deleted {
+			entry.deleted = true
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	sort.Strings(result.Removed)
+	s.rebuild()
+	return result, nil
+}
+
+// sameRule compares the authored parts of two definitions
+func sameRule(a, b models.ComplianceRule) bool {
+	a.Version, a.UpdatedAt, a.UpdatedBy = 0, time.Time{}, ""
+	b.Version, b.UpdatedAt, b.UpdatedBy = 0, time.Time{}, ""
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// Reload re-reads the rule file the store was created with
+func (s *RuleStore) Reload() (*RuleLoadResult, error) {
+	if s.path == "" {
+		return nil, fmt.Errorf("no rule file configured")
+	}
+	defs, err := ReadRuleFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Load(defs, "file:"+filepath.Base(s.path))
+}
+
+// ReadRuleFile reads rule definitions from a JSON or YAML file holding a
+// list of rules or an object with a rules key
+func ReadRuleFile(path string) ([]models.ComplianceRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	// YAML is converted to JSON so the models' json tags apply
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid rule file: %w", err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("invalid rule file: %w", err)
+		}
+	}
+
+	var defs []models.ComplianceRule
+	if err := json.Unmarshal(data, &defs); err == nil {
+		return defs, nil
+	}
+	var wrapped struct {
+		Rules []models.ComplianceRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, fmt.Errorf("invalid rule file: %w", err)
+	}
+	return wrapped.Rules, nil
+}
+
+// ReplayItem is a transaction with the context it was evaluated in
+type ReplayItem struct {
+	Transaction *models.Transaction `json:"transaction"`
+	Context     *EvaluationContext  `json:"context,omitempty"`
+}
+
+// ReplayContexts orders transactions by time and gives each one without a
+// context an account history built from the earlier transactions in the
+// set, newest first, and their recent activity within window
+func ReplayContexts(items []ReplayItem, window time.Duration) []ReplayItem {
+	sorted := append([]ReplayItem(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Transaction.CreatedAt.Before(sorted[j].Transaction.CreatedAt)
+	})
+
+	history := make(map[string][]*models.Transaction)
+	for i, item := range sorted {
+		account := item.Transaction.SourceAccount
+		if item.Context == nil {
+			prior := history[account]
+			h := make([]*models.Transaction, len(prior))
+			for j, t := range prior {
+				h[len(prior)-1-j] = t
+			}
+			sorted[i].Context = &EvaluationContext{
+				AccountHistory: h,
+				RecentActivity: replayActivity(prior, item.Transaction.CreatedAt, window),
+			}
+		}
+		history[account] = append(history[account], item.Transaction)
+	}
+	return sorted
+}
+
+// replayActivity summarizes the transactions in prior, oldest first, made
+// within window before at, as VelocityTracker.GetActivity would have
+func replayActivity(prior []*models.Transaction, at time.Time, window time.Duration) *ActivitySummary {
+	summary := &ActivitySummary{TimeWindow: window}
+	cutoff := at.Add(-window)
+	locations := make(map[string]bool)
+	merchants := make(map[string]bool)
+	for i := len(prior) - 1; i >= 0 && prior[i].CreatedAt.After(cutoff); i-- {
+		t := prior[i]
+		summary.TransactionCount++
+		summary.TotalAmount = summary.TotalAmount.Add(t.ReportingAmount())
+		if t.Merchant != nil {
+			if t.Merchant.Country != "" {
+				locations[t.Merchant.Country] = true
+			}
+			if t.Merchant.ID != "" {
+				merchants[t.Merchant.ID] = true
+			}
+		}
+	}
+	summary.UniqueLocations = len(locations)
+	summary.UniqueMerchants = len(merchants)
+	return summary
+}
+
+// DryRunReport describes what a rule would have matched
+type DryRunReport struct {
+	RuleID    string          `json:"rule_id"`
+	Evaluated int             `json:"evaluated"`
+	Matched   int             `json:"matched"`
+	MatchRate float64         `json:"match_rate"`
+	Amount    decimal.Decimal `json:"matched_amount"`
+	Accounts  int             `json:"accounts"`
+	Hits      []RuleHit       `json:"hits"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// defaultVelocityWindow is the recent activity window of stores without a
+// velocity tracker
+const defaultVelocityWindow = time.Hour
+
+// velocityWindow is the window of the recent activity replayed to rules
+func (s *RuleStore) velocityWindow() time.Duration {
+	if s.tracker == nil {
+		return defaultVelocityWindow
+	}
+	return s.tracker.window
+}
+
+// DryRun evaluates a rule definition against transactions without storing
+// it or applying its actions
+func (s *RuleStore) DryRun(def models.ComplianceRule, items []ReplayItem) (*DryRunReport, error) {
+	if def.ID == "" {
+		def.ID = "dry-run"
+	}
+	r, err := CompileRule(def)
+	if err != nil {
+		return nil, err
+	}
+	r.geofence = s.geofence
+
+	report := &DryRunReport{RuleID: def.ID, Hits: []RuleHit{}}
+	accounts := make(map[string]bool)
+	for _, item := range ReplayContexts(items, s.velocityWindow()) {
+		report.Evaluated++
+		values, ok := r.Match(item.Transaction, item.Context)
+		if !ok {
+			continue
+		}
+		report.Matched++
+		report.Amount = report.Amount.Add(item.Transaction.ReportingAmount())
+		accounts[item.Transaction.SourceAccount] = true
+		if len(report.Hits) < maxRuleHits {
+			report.Hits = append(report.Hits, r.hit(item.Transaction, values))
+		} else {
+			report.Truncated = true
+		}
+	}
+	report.Accounts = len(accounts)
+	if report.Evaluated > 0 {
+		report.MatchRate = float64(report.Matched) / float64(report.Evaluated)
+	}
+	return report, nil
+}
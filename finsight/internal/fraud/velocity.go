@@ -70,7 +70,7 @@ func (v *VelocityTracker) Record(txn *models.Transaction) {
 
 	acc.Transactions = append(acc.Transactions, &TransactionRecord{
 		ID:        txn.ID,
-		Amount:    txn.Amount,
+		Amount:    txn.ReportingAmount(),
 		Timestamp: txn.CreatedAt,
 		Location:  location,
 		Merchant:  merchant,
@@ -140,6 +140,25 @@ func (v *VelocityTracker) GetRecentTransactions(accountID string, limit int) []*
 	return recent
 }
 
+// Records returns an account's recorded transactions at or after since
+func (v *VelocityTracker) Records(accountID string, since time.Time) []*TransactionRecord {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	acc, ok := v.accounts[accountID]
+	if !ok {
+		return nil
+	}
+
+	var records []*TransactionRecord
+	for _, txn := range acc.Transactions {
+		if !txn.Timestamp.Before(since) {
+			records = append(records, txn)
+		}
+	}
+	return records
+}
+
 func (v *VelocityTracker) cleanOldRecords(acc *AccountVelocity) {
 	cutoff := time.Now().Add(-v.window)
 	var valid []*TransactionRecord
@@ -0,0 +1,343 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Rate is the price of one unit of Base expressed in Quote on a given date
+type Rate struct {
+	Base   string          `json:"base"`
+	Quote  string          `json:"quote"`
+	Date   time.Time       `json:"date"`
+	Rate   decimal.Decimal `json:"rate"`
+	Source string          `json:"source,omitempty"`
+}
+
+// Provider supplies FX rates from an external feed
+type Provider interface {
+	Name() string
+	Load(ctx context.Context) ([]Rate, error)
+}
+
+// Converter stores historic FX rates and converts amounts into the base
+// currency
+type Converter struct {
+	config    *config.FXConfig
+	providers []Provider
+	rates     map[string][]Rate // "BASE/QUOTE" -> rates sorted by date
+	pivots    map[string]bool
+	mu        sync.RWMutex
+	running   bool
+	stopCh    chan struct{}
+}
+
+// NewConverter creates a new converter with providers built from config
+func NewConverter(cfg *config.FXConfig) *Converter {
+	if cfg.BaseCurrency == "" {
+		cfg.BaseCurrency = "USD"
+	}
+	c := &Converter{
+		config: cfg,
+		rates:  make(map[string][]Rate),
+		pivots: make(map[string]bool),
+		stopCh: make(chan struct{}),
+	}
+	for _, p := range cfg.Providers {
+		switch strings.ToLower(p.Type) {
+		case "csv", "file":
+			c.providers = append(c.providers, NewFileProvider(p.Source))
+		case "ecb":
+			c.providers = append(c.providers, NewECBProvider(p.Source))
+		default:
+			log.Printf("fx: unknown rate provider type %q", p.Type)
+		}
+	}
+	return c
+}
+
+// BaseCurrency returns the configured reporting currency
+func (c *Converter) BaseCurrency() string {
+	return c.config.BaseCurrency
+}
+
+// AddProvider registers an additional rate provider
+func (c *Converter) AddProvider(p Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.providers = append(c.providers, p)
+}
+
+// Start loads rates from all providers and refreshes them periodically
+func (c *Converter) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return nil
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	if err := c.Refresh(ctx); err != nil {
+		log.Printf("fx: initial rate load failed: %v", err)
+	}
+
+	if c.config.RefreshInterval > 0 {
+		go c.refreshLoop(ctx)
+	}
+	return nil
+}
+
+// Stop stops the refresh loop
+func (c *Converter) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		close(c.stopCh)
+		c.running = false
+	}
+}
+
+func (c *Converter) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				log.Printf("fx: rate refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh loads rates from every provider. A failing provider does not
+// prevent the others from loading.
+func (c *Converter) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	providers := append([]Provider(nil), c.providers...)
+	c.mu.RUnlock()
+
+	var errs []string
+	for _, p := range providers {
+		rates, err := p.Load(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Name(), err))
+			continue
+		}
+		c.AddRates(rates)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// AddRates stores rates, replacing any existing rate for the same pair and date
+func (c *Converter) AddRates(rates []Rate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	touched := make(map[string]bool)
+	for _, r := range rates {
+		r.Base = strings.ToUpper(r.Base)
+		r.Quote = strings.ToUpper(r.Quote)
+		r.Date = truncateDay(r.Date)
+		if r.Base == "" || r.Quote == "" || !r.Rate.IsPositive() {
+			continue
+		}
+
+		key := pairKey(r.Base, r.Quote)
+		replaced := false
+		for i, existing := range c.rates[key] {
+			if existing.Date.Equal(r.Date) {
+				c.rates[key][i] = r
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.rates[key] = append(c.rates[key], r)
+		}
+		c.pivots[r.Base] = true
+		touched[key] = true
+	}
+
+	for key := range touched {
+		series := c.rates[key]
+		sort.Slice(series, func(i, j int) bool {
+			return series[i].Date.Before(series[j].Date)
+		})
+	}
+}
+
+// GetRate returns the rate to convert from one currency to another on the
+// given date. The most recent rate published on or before the date is used;
+// inverse and cross rates through a common base are derived when no direct
+// quote exists.
+func (c *Converter) GetRate(from, to string, date time.Time) (*Rate, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+	day := truncateDay(date)
+
+	if from == to {
+		return &Rate{Base: from, Quote: to, Date: day, Rate: decimal.NewFromInt(1), Source: "identity"}, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if r, ok := c.lookup(from, to, day); ok {
+		return &r, nil
+	}
+
+	if r, ok := c.lookup(to, from, day); ok {
+		return &Rate{
+			Base:   from,
+			Quote:  to,
+			Date:   r.Date,
+			Rate:   decimal.NewFromInt(1).DivRound(r.Rate, 10),
+			Source: r.Source,
+		}, nil
+	}
+
+	pivots := make([]string, 0, len(c.pivots))
+	for p := range c.pivots {
+		pivots = append(pivots, p)
+	}
+	sort.Strings(pivots)
+
+	for _, pivot := range pivots {
+		pivotFrom, ok1 := c.lookup(pivot, from, day)
+		pivotTo, ok2 := c.lookup(pivot, to, day)
+		if !ok1 || !ok2 {
+			continue
+		}
+		rateDate := pivotFrom.Date
+		if pivotTo.Date.Before(rateDate) {
+			rateDate = pivotTo.Date
+		}
+		return &Rate{
+			Base:   from,
+			Quote:  to,
+			Date:   rateDate,
+			Rate:   pivotTo.Rate.DivRound(pivotFrom.Rate, 10),
+			Source: pivotTo.Source + " via " + pivot,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s/%s on %s", ErrRateNotFound, from, to, day.Format("2006-01-02"))
+}
+
+func (c *Converter) lookup(base, quote string, day time.Time) (Rate, bool) {
+	series := c.rates[pairKey(base, quote)]
+	idx := sort.Search(len(series), func(i int) bool {
+		return series[i].Date.After(day)
+	})
+	if idx == 0 {
+		return Rate{}, false
+	}
+
+	r := series[idx-1]
+	if c.config.MaxRateAge > 0 && day.Sub(r.Date) > c.config.MaxRateAge {
+		return Rate{}, false
+	}
+	return r, true
+}
+
+// GetRates returns the stored direct quotes for a pair within a date range
+func (c *Converter) GetRates(base, quote string, from, to time.Time) []Rate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var results []Rate
+	for _, r := range c.rates[pairKey(strings.ToUpper(base), strings.ToUpper(quote))] {
+		if !r.Date.Before(truncateDay(from)) && !r.Date.After(to) {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// Convert converts an amount between currencies using the rate for the date
+func (c *Converter) Convert(amount decimal.Decimal, from, to string, date time.Time) (decimal.Decimal, *Rate, error) {
+	rate, err := c.GetRate(from, to, date)
+	if err != nil {
+		return decimal.Zero, nil, err
+	}
+	return amount.Mul(rate.Rate).Round(2), rate, nil
+}
+
+// Apply converts a transaction into the base currency and records the
+// converted amount and the rate used. Any conversion already on the
+// transaction is discarded and recomputed, so callers cannot supply their own
+// base amount. Without a rate the transaction is left unconverted and
+// ErrRateNotFound is returned. A missing currency is treated as the base
+// currency.
+func (c *Converter) Apply(txn *models.Transaction) error {
+	txn.BaseAmount = decimal.Zero
+	txn.BaseCurrency = ""
+	txn.FXRate = decimal.Zero
+	txn.FXRateDate = nil
+
+	base := c.config.BaseCurrency
+	currency := txn.Currency
+	if currency == "" {
+		currency = base
+	}
+
+	date := txn.CreatedAt
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	converted, rate, err := c.Convert(txn.Amount, currency, base, date)
+	if err != nil {
+		return err
+	}
+
+	rateDate := rate.Date
+	txn.BaseAmount = converted
+	txn.BaseCurrency = base
+	txn.FXRate = rate.Rate
+	txn.FXRateDate = &rateDate
+	return nil
+}
+
+func pairKey(base, quote string) string {
+	return base + "/" + quote
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Errors
+var (
+	ErrRateNotFound = &Error{Code: "RATE_NOT_FOUND", Message: "FX rate not found"}
+)
+
+// Error represents an FX error
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
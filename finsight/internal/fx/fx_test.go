@@ -0,0 +1,198 @@
+package fx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestParseCSV(t *testing.T) {
+	feed := `date,base,quote,rate
+2024-01-02,USD,EUR,0.91
+# comment
+2024-01-03, USD, EUR, 0.92
+`
+	rates, err := ParseCSV(strings.NewReader(feed), "test")
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(rates) != 2 {
+		t.Fatalf("rates = %d, want 2", len(rates))
+	}
+	if rates[1].Quote != "EUR" || !rates[1].Rate.Equal(decimal.RequireFromString("0.92")) {
+		t.Errorf("rates[1] = %+v", rates[1])
+	}
+
+	if _, err := ParseCSV(strings.NewReader("2024-01-02,USD,EUR,abc\n"), "test"); err == nil {
+		t.Error("expected error for invalid rate")
+	}
+}
+
+func TestParseECB(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-01-03">
+			<Cube currency="USD" rate="1.0919"/>
+			<Cube currency="GBP" rate="0.86408"/>
+		</Cube>
+		<Cube time="2024-01-02">
+			<Cube currency="USD" rate="1.0956"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+	rates, err := ParseECB(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("ParseECB failed: %v", err)
+	}
+	if len(rates) != 3 {
+		t.Fatalf("rates = %d, want 3", len(rates))
+	}
+	if rates[0].Base != "EUR" || rates[0].Quote != "USD" || !rates[0].Date.Equal(date("2024-01-03")) {
+		t.Errorf("rates[0] = %+v", rates[0])
+	}
+}
+
+func newTestConverter() *Converter {
+	c := NewConverter(&config.FXConfig{BaseCurrency: "USD", MaxRateAge: 7 * 24 * time.Hour})
+	c.AddRates([]Rate{
+		{Base: "EUR", Quote: "USD", Date: date("2024-01-02"), Rate: decimal.RequireFromString("1.10")},
+		{Base: "EUR", Quote: "USD", Date: date("2024-01-05"), Rate: decimal.RequireFromString("1.20")},
+		{Base: "EUR", Quote: "GBP", Date: date("2024-01-05"), Rate: decimal.RequireFromString("0.80")},
+	})
+	return c
+}
+
+func TestConverter_HistoricRate(t *testing.T) {
+	c := newTestConverter()
+
+	tests := []struct {
+		day  string
+		want string
+	}{
+		{"2024-01-02", "1.1"},
+		{"2024-01-04", "1.1"}, // latest on or before
+		{"2024-01-05", "1.2"},
+		{"2024-01-10", "1.2"},
+	}
+	for _, tt := range tests {
+		rate, err := c.GetRate("EUR", "USD", date(tt.day).Add(15*time.Hour))
+		if err != nil {
+			t.Fatalf("%s: GetRate failed: %v", tt.day, err)
+		}
+		if !rate.Rate.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("%s: rate = %s, want %s", tt.day, rate.Rate, tt.want)
+		}
+	}
+
+	if _, err := c.GetRate("EUR", "USD", date("2024-01-01")); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("before first rate err = %v, want ErrRateNotFound", err)
+	}
+	if _, err := c.GetRate("EUR", "USD", date("2024-02-01")); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("stale rate err = %v, want ErrRateNotFound", err)
+	}
+}
+
+func TestConverter_InverseAndCross(t *testing.T) {
+	c := newTestConverter()
+	day := date("2024-01-05")
+
+	amount, _, err := c.Convert(decimal.NewFromInt(120), "USD", "EUR", day)
+	if err != nil {
+		t.Fatalf("inverse Convert failed: %v", err)
+	}
+	if !amount.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("USD->EUR = %s, want 100", amount)
+	}
+
+	amount, rate, err := c.Convert(decimal.NewFromInt(80), "GBP", "USD", day)
+	if err != nil {
+		t.Fatalf("cross Convert failed: %v", err)
+	}
+	if !amount.Equal(decimal.NewFromInt(120)) {
+		t.Errorf("GBP->USD = %s (rate %s), want 120", amount, rate.Rate)
+	}
+}
+
+func TestConverter_Apply(t *testing.T) {
+	c := newTestConverter()
+
+	txn := &models.Transaction{
+		ID:        "TXN-001",
+		Amount:    decimal.NewFromInt(50),
+		Currency:  "EUR",
+		CreatedAt: date("2024-01-03").Add(10 * time.Hour),
+	}
+	if err := c.Apply(txn); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if txn.BaseCurrency != "USD" || !txn.BaseAmount.Equal(decimal.NewFromInt(55)) {
+		t.Errorf("base = %s %s, want 55 USD", txn.BaseAmount, txn.BaseCurrency)
+	}
+	if txn.FXRateDate == nil || !txn.FXRateDate.Equal(date("2024-01-02")) {
+		t.Errorf("FXRateDate = %v, want 2024-01-02", txn.FXRateDate)
+	}
+	if !txn.ReportingAmount().Equal(txn.BaseAmount) {
+		t.Errorf("ReportingAmount = %s, want %s", txn.ReportingAmount(), txn.BaseAmount)
+	}
+
+	usd := &models.Transaction{Amount: decimal.NewFromInt(10), Currency: "USD"}
+	if err := c.Apply(usd); err != nil {
+		t.Fatalf("Apply base currency failed: %v", err)
+	}
+	if !usd.FXRate.Equal(decimal.NewFromInt(1)) || !usd.BaseAmount.Equal(usd.Amount) {
+		t.Errorf("base currency txn = %s @ %s", usd.BaseAmount, usd.FXRate)
+	}
+
+	jpy := &models.Transaction{Amount: decimal.NewFromInt(10), Currency: "JPY"}
+	if err := c.Apply(jpy); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("unknown currency err = %v, want ErrRateNotFound", err)
+	}
+}
+
+func TestConverter_Apply_IgnoresSuppliedConversion(t *testing.T) {
+	c := newTestConverter()
+
+	// A client-supplied base amount must not hide the real converted amount
+	txn := &models.Transaction{
+		ID:           "TXN-001",
+		Amount:       decimal.NewFromInt(50000),
+		Currency:     "EUR",
+		BaseAmount:   decimal.NewFromInt(1),
+		BaseCurrency: "USD",
+		FXRate:       decimal.RequireFromString("0.00002"),
+		CreatedAt:    date("2024-01-03"),
+	}
+	if err := c.Apply(txn); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !txn.BaseAmount.Equal(decimal.NewFromInt(55000)) || !txn.FXRate.Equal(decimal.RequireFromString("1.10")) {
+		t.Errorf("base = %s @ %s, want 55000 @ 1.10", txn.BaseAmount, txn.FXRate)
+	}
+
+	// Without a rate the supplied conversion is discarded, not kept
+	jpy := &models.Transaction{
+		Amount:       decimal.NewFromInt(10),
+		Currency:     "JPY",
+		BaseAmount:   decimal.NewFromInt(1),
+		BaseCurrency: "USD",
+	}
+	if err := c.Apply(jpy); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("unknown currency err = %v, want ErrRateNotFound", err)
+	}
+	if jpy.BaseCurrency != "" || !jpy.BaseAmount.IsZero() || !jpy.ReportingAmount().Equal(jpy.Amount) {
+		t.Errorf("JPY txn should be unconverted, got %s %s", jpy.BaseAmount, jpy.BaseCurrency)
+	}
+}
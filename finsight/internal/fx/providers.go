@@ -0,0 +1,187 @@
+package fx
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// FileProvider loads rates from a CSV feed with the columns
+// date,base,quote,rate. A header row is optional.
+type FileProvider struct {
+	source string
+	client *http.Client
+}
+
+// NewFileProvider creates a CSV rate provider reading from a path or URL
+func NewFileProvider(source string) *FileProvider {
+	return &FileProvider{
+		source: source,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *FileProvider) Name() string { return "csv:" + p.source }
+
+// Load reads all rates from the feed
+func (p *FileProvider) Load(ctx context.Context) ([]Rate, error) {
+	rc, err := openSource(ctx, p.client, p.source)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ParseCSV(rc, p.Name())
+}
+
+// ParseCSV parses a date,base,quote,rate CSV feed
+func ParseCSV(r io.Reader, source string) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	var rates []Rate
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line++
+
+		if len(record) == 0 || strings.HasPrefix(strings.TrimSpace(record[0]), "#") {
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			continue
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("line %d: expected 4 columns, got %d", line, len(record))
+		}
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[0])
+		}
+		value, err := decimal.NewFromString(strings.TrimSpace(record[3]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, record[3])
+		}
+
+		rates = append(rates, Rate{
+			Base:   strings.TrimSpace(record[1]),
+			Quote:  strings.TrimSpace(record[2]),
+			Date:   date,
+			Rate:   value,
+			Source: source,
+		})
+	}
+
+	return rates, nil
+}
+
+// ECBProvider loads euro foreign exchange reference rates published by the
+// European Central Bank (eurofxref-daily.xml, eurofxref-hist.xml)
+type ECBProvider struct {
+	source string
+	client *http.Client
+}
+
+// DefaultECBSource is the ECB 90-day history feed
+const DefaultECBSource = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+
+// NewECBProvider creates an ECB rate provider reading from a path or URL
+func NewECBProvider(source string) *ECBProvider {
+	if source == "" {
+		source = DefaultECBSource
+	}
+	return &ECBProvider{
+		source: source,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *ECBProvider) Name() string { return "ecb" }
+
+// Load reads all rates from the feed
+func (p *ECBProvider) Load(ctx context.Context) ([]Rate, error) {
+	rc, err := openSource(ctx, p.client, p.source)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ParseECB(rc)
+}
+
+type ecbEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Days    []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseECB parses an ECB reference rate document. All rates are quoted
+// against EUR.
+func ParseECB(r io.Reader) ([]Rate, error) {
+	var env ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&env); err != nil {
+		return nil, fmt.Errorf("parse ECB feed: %w", err)
+	}
+
+	var rates []Rate
+	for _, day := range env.Days {
+		date, err := time.Parse("2006-01-02", day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECB date %q", day.Time)
+		}
+		for _, cube := range day.Rates {
+			value, err := decimal.NewFromString(cube.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ECB rate %q for %s", cube.Rate, cube.Currency)
+			}
+			rates = append(rates, Rate{
+				Base:   "EUR",
+				Quote:  cube.Currency,
+				Date:   date,
+				Rate:   value,
+				Source: "ecb",
+			})
+		}
+	}
+
+	return rates, nil
+}
+
+func openSource(ctx context.Context, client *http.Client, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetch %s: unexpected status %d", source, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,283 @@
+package ingest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Card message classes and functions, the second and third digits of the
+// message type indicator. The 1987 (0xxx) and 1993 (1xxx) versions share
+// them.
+const (
+	classAuthorization = '1'
+	classFinancial     = '2'
+	classFileAction    = '3'
+	classReversal      = '4'
+
+	functionRequest  = '0'
+	functionResponse = '1'
+	functionAdvice   = '2'
+	functionNotify   = '4'
+)
+
+// approvalCodes are the response codes of approved messages
+var approvalCodes = map[string]bool{"00": true, "08": true, "10": true, "11": true, "85": true}
+
+// currencies maps ISO 4217 numeric codes to alphabetic codes and minor
+// unit exponents
+var currencies = map[string]struct {
+	code     string
+	exponent int32
+}{
+	"032": {"ARS", 2}, "036": {"AUD", 2}, "048": {"BHD", 3}, "124": {"CAD", 2},
+	"152": {"CLP", 0}, "156": {"CNY", 2}, "170": {"COP", 2}, "203": {"CZK", 2},
+	"208": {"DKK", 2}, "344": {"HKD", 2}, "348": {"HUF", 2}, "356": {"INR", 2},
+	"360": {"IDR", 2}, "376": {"ILS", 2}, "392": {"JPY", 0}, "404": {"KES", 2},
+	"410": {"KRW", 0}, "414": {"KWD", 3}, "458": {"MYR", 2}, "484": {"MXN", 2},
+	"512": {"OMR", 3}, "554": {"NZD", 2}, "566": {"NGN", 2}, "578": {"NOK", 2},
+	"604": {"PEN", 2}, "608": {"PHP", 2}, "643": {"RUB", 2}, "682": {"SAR", 2},
+	"702": {"SGD", 2}, "704": {"VND", 0}, "710": {"ZAR", 2}, "752": {"SEK", 2},
+	"756": {"CHF", 2}, "764": {"THB", 2}, "784": {"AED", 2}, "818": {"EGP", 2},
+	"826": {"GBP", 2}, "840": {"USD", 2}, "901": {"TWD", 2}, "946": {"RON", 2},
+	"949": {"TRY", 2}, "978": {"EUR", 2}, "985": {"PLN", 2}, "986": {"BRL", 2},
+}
+
+// cardEvent maps a card message onto the transaction lifecycle:
+//   - an authorization request holds the amount; a declined response fails
+//     it and an approved one keeps it held
+//   - a financial request is held until its approved response completes it
+//   - a financial advice or presentment, the clearing, completes the
+//     authorization it refers to, or records a completed transaction when
+//     there is none
+//   - a reversal releases or reverses the original
+func cardEvent(msg *ISO8583Message, now time.Time) (*event, error) {
+	f := msg.Fields
+	class, function := msg.MTI[1], msg.MTI[2]
+
+	pan := maskPAN(f[2])
+	stan := strings.TrimSpace(f[11])
+	rrn := strings.TrimSpace(f[37])
+	approval := strings.TrimSpace(f[38])
+	response := strings.TrimSpace(f[39])
+
+	at, err := cardTime(f, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var stanKey, rrnKey, authKey, originalKey string
+	if pan != "" {
+		if stan != "" && f[7] != "" {
+			stanKey = "stan:" + pan + ":" + stan + ":" + f[7]
+		}
+		if rrn != "" {
+			rrnKey = "rrn:" + pan + ":" + rrn
+		}
+		if approval != "" {
+			authKey = "auth:" + pan + ":" + approval
+		}
+		if orig := f[90]; len(orig) >= 20 {
+			// Original message type, STAN and transmission time
+			originalKey = "stan:" + pan + ":" + orig[4:10] + ":" + orig[10:20]
+		}
+	}
+
+	e := &event{kind: "iso8583 " + msg.MTI, keys: nonEmpty(stanKey, rrnKey, authKey)}
+	approved := response == "" || approvalCodes[response]
+	switch class {
+	case classAuthorization, classFinancial:
+		switch function {
+		case functionRequest:
+			// Only a repeated request refers to an earlier message
+			e.refs = nonEmpty(stanKey)
+			e.status = models.TransactionStatusHeld
+		case functionResponse:
+			// A response echoes the STAN and transmission time of its
+			// request
+			e.refs = nonEmpty(stanKey, rrnKey)
+			e.status = models.TransactionStatusHeld
+			if class == classFinancial {
+				e.status = models.TransactionStatusCompleted
+			}
+		case functionAdvice, functionNotify:
+			// A financial advice or presentment clears the authorization
+			e.refs = nonEmpty(originalKey, rrnKey, authKey)
+			e.status = models.TransactionStatusHeld
+			if class == classFinancial {
+				e.status = models.TransactionStatusCompleted
+				e.settledAt = &at
+			}
+		default:
+			return nil, fmt.Errorf("unsupported message function")
+		}
+		if !approved {
+			e.status = models.TransactionStatusFailed
+			e.settledAt = nil
+		}
+	case classReversal:
+		e.refs = nonEmpty(originalKey, rrnKey, authKey)
+		e.metadata = map[string]string{MetaMTI: msg.MTI}
+		setMetadata(e.metadata, MetaResponseCode, response)
+		if function == functionRequest || function == functionAdvice {
+			// Responses to reversals only acknowledge them
+			e.status = models.TransactionStatusReversed
+		}
+		// A reversal is not a transaction of its own, so one without an
+		// original is reported as unmatched
+		return e, nil
+	case classFileAction:
+		return nil, fmt.Errorf("file action messages are not transactions")
+	default:
+		return nil, fmt.Errorf("unsupported message class")
+	}
+
+	txn, err := cardTransaction(msg, pan, at)
+	if err != nil {
+		return nil, err
+	}
+	txn.Status = e.status
+	if txn.Status == models.TransactionStatusCompleted {
+		txn.SettledAt = &at
+	}
+	e.txn = txn
+	e.metadata = map[string]string{MetaMTI: msg.MTI}
+	setMetadata(e.metadata,
+		MetaResponseCode, response,
+		MetaApprovalCode, approval,
+		MetaRRN, rrn,
+	)
+	if e.settledAt != nil {
+		e.metadata[MetaClearingAmount] = txn.Amount.String()
+	}
+	return e, nil
+}
+
+func cardTransaction(msg *ISO8583Message, pan string, at time.Time) (*models.Transaction, error) {
+	f := msg.Fields
+	currency, ok := currencies[f[49]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported currency code %q", f[49])
+	}
+	minor, err := strconv.ParseInt(f[4], 10, 64)
+	if err != nil || minor <= 0 {
+		return nil, fmt.Errorf("invalid amount %q", f[4])
+	}
+
+	txn := &models.Transaction{
+		ExternalID:    strings.TrimSpace(f[37]),
+		Type:          cardTransactionType(f[3]),
+		Amount:        decimal.New(minor, -currency.exponent),
+		Currency:      currency.code,
+		SourceAccount: firstNonEmpty(f[102], pan),
+		Metadata:      make(map[string]string),
+		CreatedAt:     at,
+	}
+	if acceptor := f[43]; acceptor != "" || f[42] != "" || f[18] != "" {
+		// Name (25), city (13) and country (2) of the card acceptor
+		acceptor = fmt.Sprintf("%-40s", acceptor)
+		txn.Merchant = &models.Merchant{
+			ID:   strings.TrimSpace(f[42]),
+			Name: strings.TrimSpace(acceptor[:25]),
+			City: strings.TrimSpace(acceptor[25:38]),
+			MCC:  f[18],
+		}
+		if country := strings.TrimSpace(acceptor[38:40]); isAlpha(country) {
+			txn.Merchant.Country = strings.ToUpper(country)
+		}
+		txn.Description = txn.Merchant.Name
+	}
+	if txn.Description == "" {
+		txn.Description = "Card transaction"
+	}
+	setMetadata(txn.Metadata,
+		MetaMessageFormat, FormatISO8583,
+		MetaMTI, msg.MTI,
+		MetaCardPAN, pan,
+		MetaSTAN, strings.TrimSpace(f[11]),
+		MetaRRN, strings.TrimSpace(f[37]),
+		MetaReferenceID, strings.TrimSpace(f[37]),
+		MetaApprovalCode, strings.TrimSpace(f[38]),
+		MetaResponseCode, strings.TrimSpace(f[39]),
+		MetaProcessingCode, f[3],
+		MetaTerminalID, strings.TrimSpace(f[41]),
+		MetaPOSEntryMode, f[22],
+		MetaAcquirerID, f[32],
+		MetaAcquirerCountry, f[19],
+	)
+	return txn, nil
+}
+
+// cardTransactionType maps the transaction type of the processing code
+func cardTransactionType(processingCode string) models.TransactionType {
+	if len(processingCode) < 2 {
+		return models.TransactionTypeDebit
+	}
+	switch processingCode[:2] {
+	case "20":
+		return models.TransactionTypeRefund
+	case "21", "22", "26", "28":
+		return models.TransactionTypeCredit
+	}
+	return models.TransactionTypeDebit
+}
+
+// cardTime returns the transmission time, which is in UTC without a year,
+// falling back to the local transaction date and time. The year is the one
+// putting the time closest to now.
+func cardTime(f map[int]string, now time.Time) (time.Time, error) {
+	value := f[7]
+	if value == "" && f[13] != "" {
+		value = f[13] + firstNonEmpty(f[12], "000000")
+	}
+	if value == "" {
+		return now, nil
+	}
+	t, err := time.Parse("0102150405", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid transmission time %q", value)
+	}
+	best := t.AddDate(now.Year()-t.Year(), 0, 0)
+	for _, years := range []int{-1, 1} {
+		candidate := t.AddDate(now.Year()-t.Year()+years, 0, 0)
+		if absDuration(candidate.Sub(now)) < absDuration(best.Sub(now)) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+// maskPAN keeps the BIN and the last four digits of a card number
+func maskPAN(pan string) string {
+	pan = strings.TrimSpace(pan)
+	if len(pan) < 13 {
+		if len(pan) <= 4 {
+			return pan
+		}
+		return strings.Repeat("*", len(pan)-4) + pan[len(pan)-4:]
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
+
+func isAlpha(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
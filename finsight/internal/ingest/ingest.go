@@ -0,0 +1,271 @@
+package ingest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Message formats accepted by the ingestor
+const (
+	FormatPain001 = "pain.001"
+	FormatPacs008 = "pacs.008"
+	FormatPacs002 = "pacs.002"
+	FormatISO8583 = "iso8583"
+)
+
+// Metadata keys set on ingested transactions
+const (
+	MetaMessageFormat       = "message_format"
+	MetaMessageID           = "message_id"
+	MetaReferenceID         = "reference_id"
+	MetaEndToEndID          = "end_to_end_id"
+	MetaUETR                = "uetr"
+	MetaPaymentStatus       = "payment_status"
+	MetaStatusReason        = "status_reason"
+	MetaDebtorAgent         = "debtor_agent"
+	MetaCreditorAgent       = "creditor_agent"
+	MetaCounterparty        = "counterparty"
+	MetaCounterpartyAccount = "counterparty_account"
+	MetaPurpose             = "purpose"
+	MetaMTI                 = "mti"
+	MetaCardPAN             = "card_pan"
+	MetaSTAN                = "stan"
+	MetaRRN                 = "rrn"
+	MetaApprovalCode        = "approval_code"
+	MetaResponseCode        = "response_code"
+	MetaProcessingCode      = "processing_code"
+	MetaTerminalID          = "terminal_id"
+	MetaPOSEntryMode        = "pos_entry_mode"
+	MetaAcquirerID          = "acquirer_id"
+	MetaAcquirerCountry     = "acquirer_country"
+	MetaClearingAmount      = "clearing_amount"
+)
+
+// event is a message normalized into a new transaction or a change to
+// the transactions an earlier message created
+type event struct {
+	kind string
+	// txn describes a transaction; nil for status reports
+	txn *models.Transaction
+	// keys identify the transaction to later messages
+	keys []string
+	// refs are keys of earlier messages this one refers to, most specific
+	// first. When one resolves the earlier transactions are updated;
+	// otherwise txn, if any, is created.
+	refs []string
+	// status is the status the transactions move to; empty keeps it
+	status models.TransactionStatus
+	// metadata is merged into updated transactions
+	metadata map[string]string
+	// settledAt is set on transactions that complete
+	settledAt *time.Time
+}
+
+// Result is the outcome of ingesting messages. Created and Updated
+// transactions still need processing; updates are copies of the stored
+// transactions with the new status.
+type Result struct {
+	Format    string                `json:"format"`
+	Messages  int                   `json:"messages"`
+	Created   []*models.Transaction `json:"created"`
+	Updated   []*models.Transaction `json:"updated"`
+	Unmatched []string              `json:"unmatched,omitempty"`
+	Ignored   []string              `json:"ignored,omitempty"`
+}
+
+// Ingestor turns payment and card messages into transactions. It keeps the
+// references of the messages it has seen, so a status report, clearing or
+// reversal updates the transaction created by the original message.
+type Ingestor struct {
+	lookup func(id string) (*models.Transaction, bool)
+	keys   map[string][]string // message reference -> transaction IDs
+	seq    int
+	mu     sync.Mutex
+}
+
+// NewIngestor creates an ingestor that finds earlier transactions with
+// lookup
+func NewIngestor(lookup func(id string) (*models.Transaction, bool)) *Ingestor {
+	return &Ingestor{
+		lookup: lookup,
+		keys:   make(map[string][]string),
+	}
+}
+
+// IngestISO20022 ingests a pain.001, pacs.008 or pacs.002 message
+func (g *Ingestor) IngestISO20022(data []byte) (*Result, error) {
+	format := DetectISO20022Message(data)
+	var events []*event
+	var err error
+	switch format {
+	case FormatPain001:
+		events, err = parsePain001(data)
+	case FormatPacs008:
+		events, err = parsePacs008(data)
+	case FormatPacs002:
+		events, err = parsePacs002(data)
+	default:
+		return nil, fmt.Errorf("unsupported ISO 20022 message; expected pain.001, pacs.008 or pacs.002")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.apply(format, 1, events), nil
+}
+
+// IngestISO8583 ingests a capture of length-framed ISO 8583 messages
+func (g *Ingestor) IngestISO8583(data []byte, enc ISO8583Encoding) (*Result, error) {
+	frames, err := SplitISO8583Frames(data)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	events := make([]*event, 0, len(frames))
+	for i, frame := range frames {
+		msg, err := ParseISO8583(frame, enc)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		e, err := cardEvent(msg, now)
+		if err != nil {
+			return nil, fmt.Errorf("message %d (%s): %w", i+1, msg.MTI, err)
+		}
+		events = append(events, e)
+	}
+	return g.apply(FormatISO8583, len(frames), events), nil
+}
+
+// apply resolves events in order. Messages are parsed before any is
+// applied, so a malformed file changes nothing.
+func (g *Ingestor) apply(format string, messages int, events []*event) *Result {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := &Result{Format: format, Messages: messages}
+	// Transactions changed by this call are updated in place rather than
+	// looked up again
+	changed := make(map[string]*models.Transaction)
+	for _, e := range events {
+		ids := g.resolve(e.refs)
+		if len(ids) == 0 {
+			if e.txn == nil {
+				result.Unmatched = append(result.Unmatched, fmt.Sprintf("%s: no transaction for %v", e.kind, e.refs))
+				continue
+			}
+			g.seq++
+			e.txn.ID = fmt.Sprintf("%s-%s-%d", idPrefix(format), time.Now().Format("20060102150405"), g.seq)
+			g.register(e.keys, e.txn.ID)
+			changed[e.txn.ID] = e.txn
+			result.Created = append(result.Created, e.txn)
+			continue
+		}
+
+		for _, id := range ids {
+			txn, ok := changed[id]
+			if !ok {
+				stored, found := g.lookup(id)
+				if !found {
+					continue
+				}
+				txn = copyTransaction(stored)
+			}
+			if e.status != "" && !canTransition(txn.Status, e.status) {
+				result.Ignored = append(result.Ignored, fmt.Sprintf("%s: transaction %s is already %s", e.kind, id, txn.Status))
+				continue
+			}
+			if !ok {
+				changed[id] = txn
+				result.Updated = append(result.Updated, txn)
+			}
+			if e.status != "" {
+				txn.Status = e.status
+			}
+			for k, v := range e.metadata {
+				txn.Metadata[k] = v
+			}
+			if e.settledAt != nil && txn.Status == models.TransactionStatusCompleted {
+				txn.SettledAt = e.settledAt
+			}
+			g.register(e.keys, id)
+		}
+	}
+	return result
+}
+
+func (g *Ingestor) resolve(refs []string) []string {
+	for _, ref := range refs {
+		if ids := g.keys[ref]; len(ids) > 0 {
+			return ids
+		}
+	}
+	return nil
+}
+
+func (g *Ingestor) register(keys []string, id string) {
+	for _, key := range keys {
+		if !contains(g.keys[key], id) {
+			g.keys[key] = append(g.keys[key], id)
+		}
+	}
+}
+
+// canTransition reports whether a later message may move a transaction
+// from one status to another. Held and pending transactions may move
+// anywhere; completed ones may only be reversed.
+func canTransition(from, to models.TransactionStatus) bool {
+	switch from {
+	case models.TransactionStatusHeld, models.TransactionStatusPending, to:
+		return true
+	case models.TransactionStatusCompleted:
+		return to == models.TransactionStatusReversed
+	}
+	return false
+}
+
+func copyTransaction(txn *models.Transaction) *models.Transaction {
+	c := *txn
+	c.Metadata = make(map[string]string, len(txn.Metadata))
+	for k, v := range txn.Metadata {
+		c.Metadata[k] = v
+	}
+	return &c
+}
+
+func idPrefix(format string) string {
+	if format == FormatISO8583 {
+		return "card"
+	}
+	return "pay"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// nonEmpty returns the values that are set, in order
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// setMetadata sets the non-empty values
+func setMetadata(m map[string]string, kv ...string) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			m[kv[i]] = kv[i+1]
+		}
+	}
+}
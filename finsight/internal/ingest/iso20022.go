@@ -0,0 +1,403 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// ISO 20022 pain.001 customer credit transfer initiations, pacs.008
+// interbank credit transfers and pacs.002 payment status reports. As with
+// the camt statements, elements are matched without namespaces so every
+// message version decodes with the same structures.
+
+type isoAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// isoDate holds a date written either directly or, in later versions,
+// as a Dt or DtTm choice
+type isoDate struct {
+	Value    string `xml:",chardata"`
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type isoParty struct {
+	Name    string `xml:"Nm"`
+	Country string `xml:"PstlAdr>Ctry"`
+}
+
+type isoAccount struct {
+	IBAN     string `xml:"Id>IBAN"`
+	Other    string `xml:"Id>Othr>Id"`
+	Currency string `xml:"Ccy"`
+}
+
+func (a isoAccount) id() string {
+	if a.IBAN != "" {
+		return strings.TrimSpace(a.IBAN)
+	}
+	return strings.TrimSpace(a.Other)
+}
+
+type isoAgent struct {
+	BIC   string `xml:"FinInstnId>BIC"`
+	BICFI string `xml:"FinInstnId>BICFI"`
+}
+
+func (a isoAgent) bic() string {
+	if a.BICFI != "" {
+		return a.BICFI
+	}
+	return a.BIC
+}
+
+type isoPaymentID struct {
+	InstructionID string `xml:"InstrId"`
+	EndToEndID    string `xml:"EndToEndId"`
+	TxID          string `xml:"TxId"`
+	UETR          string `xml:"UETR"`
+}
+
+type isoGroupHeader struct {
+	MessageID string `xml:"MsgId"`
+	Created   string `xml:"CreDtTm"`
+}
+
+type isoCreditTransfer struct {
+	PaymentID       isoPaymentID `xml:"PmtId"`
+	InstructedAmt   *isoAmount   `xml:"Amt>InstdAmt"`
+	SettlementAmt   *isoAmount   `xml:"IntrBkSttlmAmt"`
+	SettlementDate  string       `xml:"IntrBkSttlmDt"`
+	Debtor          isoParty     `xml:"Dbtr"`
+	DebtorAccount   isoAccount   `xml:"DbtrAcct"`
+	DebtorAgent     isoAgent     `xml:"DbtrAgt"`
+	Creditor        isoParty     `xml:"Cdtr"`
+	CreditorAccount isoAccount   `xml:"CdtrAcct"`
+	CreditorAgent   isoAgent     `xml:"CdtrAgt"`
+	Purpose         string       `xml:"Purp>Cd"`
+	Remittance      []string     `xml:"RmtInf>Ustrd"`
+}
+
+type isoDocument struct {
+	Initiation *struct {
+		Header       isoGroupHeader `xml:"GrpHdr"`
+		Instructions []struct {
+			ID            string              `xml:"PmtInfId"`
+			ExecutionDate isoDate             `xml:"ReqdExctnDt"`
+			Debtor        isoParty            `xml:"Dbtr"`
+			DebtorAccount isoAccount          `xml:"DbtrAcct"`
+			DebtorAgent   isoAgent            `xml:"DbtrAgt"`
+			Transfers     []isoCreditTransfer `xml:"CdtTrfTxInf"`
+		} `xml:"PmtInf"`
+	} `xml:"CstmrCdtTrfInitn"`
+	Transfer *struct {
+		Header    isoGroupHeader      `xml:"GrpHdr"`
+		Transfers []isoCreditTransfer `xml:"CdtTrfTxInf"`
+	} `xml:"FIToFICstmrCdtTrf"`
+	StatusReport *struct {
+		Header        isoGroupHeader `xml:"GrpHdr"`
+		OriginalGroup struct {
+			MessageID string `xml:"OrgnlMsgId"`
+			Status    string `xml:"GrpSts"`
+			Reason    string `xml:"StsRsnInf>Rsn>Cd"`
+		} `xml:"OrgnlGrpInfAndSts"`
+		Transactions []struct {
+			InstructionID string `xml:"OrgnlInstrId"`
+			EndToEndID    string `xml:"OrgnlEndToEndId"`
+			TxID          string `xml:"OrgnlTxId"`
+			UETR          string `xml:"OrgnlUETR"`
+			Status        string `xml:"TxSts"`
+			Reason        string `xml:"StsRsnInf>Rsn>Cd"`
+			Info          string `xml:"StsRsnInf>AddtlInf"`
+		} `xml:"TxInfAndSts"`
+	} `xml:"FIToFIPmtStsRpt"`
+}
+
+// DetectISO20022Message returns the format of an ISO 20022 message, or an
+// empty string when it is not one the ingestor accepts
+func DetectISO20022Message(data []byte) string {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	text := string(head)
+	switch {
+	case strings.Contains(text, "CstmrCdtTrfInitn"):
+		return FormatPain001
+	case strings.Contains(text, "FIToFICstmrCdtTrf"):
+		return FormatPacs008
+	case strings.Contains(text, "FIToFIPmtStsRpt"):
+		return FormatPacs002
+	}
+	return ""
+}
+
+func decodeISO20022(data []byte) (*isoDocument, error) {
+	var doc isoDocument
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid ISO 20022 message: %w", err)
+	}
+	return &doc, nil
+}
+
+// parsePain001 creates a held debit from the debtor account for each
+// credit transfer a customer initiates
+func parsePain001(data []byte) ([]*event, error) {
+	doc, err := decodeISO20022(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Initiation == nil {
+		return nil, fmt.Errorf("missing CstmrCdtTrfInitn")
+	}
+	header := doc.Initiation.Header
+	created, err := parseISODateTime(header.Created)
+	if err != nil {
+		return nil, fmt.Errorf("message %s: creation time: %w", header.MessageID, err)
+	}
+
+	var events []*event
+	for _, pi := range doc.Initiation.Instructions {
+		executed, err := parseISODate(pi.ExecutionDate)
+		if err != nil {
+			return nil, fmt.Errorf("payment %s: execution date: %w", pi.ID, err)
+		}
+		if executed.IsZero() {
+			executed = created
+		}
+		for i, ct := range pi.Transfers {
+			// The debtor is given once for the whole payment
+			ct.Debtor = pi.Debtor
+			ct.DebtorAccount = pi.DebtorAccount
+			ct.DebtorAgent = pi.DebtorAgent
+			e, err := creditTransferEvent(FormatPain001, header.MessageID, ct, ct.InstructedAmt, executed)
+			if err != nil {
+				return nil, fmt.Errorf("payment %s: transaction %d: %w", pi.ID, i+1, err)
+			}
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// parsePacs008 creates a held debit for each interbank credit transfer.
+// A transfer whose references match an initiation already ingested
+// updates it instead.
+func parsePacs008(data []byte) ([]*event, error) {
+	doc, err := decodeISO20022(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Transfer == nil {
+		return nil, fmt.Errorf("missing FIToFICstmrCdtTrf")
+	}
+	header := doc.Transfer.Header
+	created, err := parseISODateTime(header.Created)
+	if err != nil {
+		return nil, fmt.Errorf("message %s: creation time: %w", header.MessageID, err)
+	}
+
+	var events []*event
+	for i, ct := range doc.Transfer.Transfers {
+		at := created
+		if ct.SettlementDate != "" {
+			if at, err = time.Parse("2006-01-02", ct.SettlementDate); err != nil {
+				return nil, fmt.Errorf("transaction %d: settlement date: %w", i+1, err)
+			}
+		}
+		amount := ct.SettlementAmt
+		if amount == nil {
+			amount = ct.InstructedAmt
+		}
+		e, err := creditTransferEvent(FormatPacs008, header.MessageID, ct, amount, at)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i+1, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func creditTransferEvent(format, messageID string, ct isoCreditTransfer, amt *isoAmount, at time.Time) (*event, error) {
+	if amt == nil {
+		return nil, fmt.Errorf("missing amount")
+	}
+	amount, err := decimal.NewFromString(strings.TrimSpace(amt.Value))
+	if err != nil || !amount.IsPositive() {
+		return nil, fmt.Errorf("invalid amount %q", amt.Value)
+	}
+	debtorAccount := ct.DebtorAccount.id()
+	if debtorAccount == "" {
+		return nil, fmt.Errorf("missing debtor account")
+	}
+
+	id := ct.PaymentID
+	endToEnd := strings.TrimSpace(id.EndToEndID)
+	if endToEnd == "NOTPROVIDED" {
+		endToEnd = ""
+	}
+	txn := &models.Transaction{
+		ExternalID:    firstNonEmpty(id.UETR, id.TxID, endToEnd, id.InstructionID),
+		Type:          models.TransactionTypeDebit,
+		Status:        models.TransactionStatusHeld,
+		Amount:        amount,
+		Currency:      amt.Currency,
+		SourceAccount: debtorAccount,
+		DestAccount:   ct.CreditorAccount.id(),
+		Description:   strings.TrimSpace(strings.Join(ct.Remittance, " ")),
+		Metadata:      make(map[string]string),
+		CreatedAt:     at,
+	}
+	if ct.Creditor.Name != "" || ct.Creditor.Country != "" {
+		// The payee plays the merchant's part for fraud scoring
+		txn.Merchant = &models.Merchant{
+			ID:      txn.DestAccount,
+			Name:    strings.TrimSpace(ct.Creditor.Name),
+			Country: strings.TrimSpace(ct.Creditor.Country),
+		}
+	}
+	if txn.Description == "" {
+		txn.Description = "Credit transfer to " + strings.TrimSpace(ct.Creditor.Name)
+	}
+	setMetadata(txn.Metadata,
+		MetaMessageFormat, format,
+		MetaMessageID, messageID,
+		MetaReferenceID, endToEnd,
+		MetaEndToEndID, endToEnd,
+		MetaUETR, id.UETR,
+		MetaDebtorAgent, ct.DebtorAgent.bic(),
+		MetaCreditorAgent, ct.CreditorAgent.bic(),
+		MetaCounterparty, strings.TrimSpace(ct.Creditor.Name),
+		MetaCounterpartyAccount, txn.DestAccount,
+		MetaPurpose, ct.Purpose,
+	)
+
+	return &event{
+		kind:     format,
+		txn:      txn,
+		keys:     paymentKeys(messageID, id.UETR, id.TxID, endToEnd, id.InstructionID),
+		refs:     paymentKeys("", id.UETR, id.TxID, endToEnd, ""),
+		status:   models.TransactionStatusHeld,
+		metadata: txn.Metadata,
+	}, nil
+}
+
+// paymentKeys returns the references a payment is known by, most specific
+// first
+func paymentKeys(messageID, uetr, txID, endToEnd, instructionID string) []string {
+	var keys []string
+	if uetr != "" {
+		keys = append(keys, "uetr:"+strings.ToLower(uetr))
+	}
+	if txID != "" {
+		keys = append(keys, "txid:"+txID)
+	}
+	if endToEnd != "" && endToEnd != "NOTPROVIDED" {
+		keys = append(keys, "e2e:"+endToEnd)
+	}
+	if instructionID != "" {
+		keys = append(keys, "instr:"+instructionID)
+	}
+	if messageID != "" {
+		keys = append(keys, "msg:"+messageID)
+	}
+	return keys
+}
+
+// parsePacs002 moves the transactions of an earlier message through their
+// status: settled payments complete and rejected ones fail. Other
+// statuses, such as accepted or pending, keep the payment held. A group
+// status applies to every transaction of the original message when no
+// transaction statuses are given.
+func parsePacs002(data []byte) ([]*event, error) {
+	doc, err := decodeISO20022(data)
+	if err != nil {
+		return nil, err
+	}
+	report := doc.StatusReport
+	if report == nil {
+		return nil, fmt.Errorf("missing FIToFIPmtStsRpt")
+	}
+	reported, err := parseISODateTime(report.Header.Created)
+	if err != nil {
+		return nil, fmt.Errorf("message %s: creation time: %w", report.Header.MessageID, err)
+	}
+
+	var events []*event
+	for _, tx := range report.Transactions {
+		refs := paymentKeys("", tx.UETR, tx.TxID, tx.EndToEndID, tx.InstructionID)
+		if len(refs) == 0 {
+			return nil, fmt.Errorf("status %s without an original reference", tx.Status)
+		}
+		events = append(events, statusEvent(tx.Status, firstNonEmpty(tx.Reason, tx.Info), refs, reported))
+	}
+	if len(report.Transactions) == 0 {
+		group := report.OriginalGroup
+		if group.MessageID == "" || group.Status == "" {
+			return nil, fmt.Errorf("status report without transaction or group status")
+		}
+		events = append(events, statusEvent(group.Status, group.Reason, []string{"msg:" + group.MessageID}, reported))
+	}
+	return events, nil
+}
+
+func statusEvent(status, reason string, refs []string, at time.Time) *event {
+	e := &event{
+		kind:     FormatPacs002 + " " + status,
+		refs:     refs,
+		metadata: map[string]string{MetaPaymentStatus: status},
+	}
+	if reason != "" {
+		e.metadata[MetaStatusReason] = reason
+	}
+	switch status {
+	case "ACSC", "ACCC":
+		e.status = models.TransactionStatusCompleted
+		e.settledAt = &at
+	case "RJCT", "CANC":
+		e.status = models.TransactionStatusFailed
+	}
+	return e
+}
+
+func parseISODateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Now(), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date time %q", s)
+}
+
+func parseISODate(d isoDate) (time.Time, error) {
+	switch {
+	case strings.TrimSpace(d.DateTime) != "":
+		return parseISODateTime(d.DateTime)
+	case strings.TrimSpace(d.Date) != "":
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	case strings.TrimSpace(d.Value) != "":
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Value))
+	}
+	return time.Time{}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
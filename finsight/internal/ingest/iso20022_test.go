@@ -0,0 +1,132 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+const pain001 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.09">
+  <CstmrCdtTrfInitn>
+    <GrpHdr><MsgId>PAIN-1</MsgId><CreDtTm>2024-03-01T09:00:00Z</CreDtTm><NbOfTxs>2</NbOfTxs></GrpHdr>
+    <PmtInf>
+      <PmtInfId>PMT-1</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <ReqdExctnDt><Dt>2024-03-04</Dt></ReqdExctnDt>
+      <Dbtr><Nm>Acme GmbH</Nm><PstlAdr><Ctry>DE</Ctry></PstlAdr></Dbtr>
+      <DbtrAcct><Id><IBAN>DE89370400440532013000</IBAN></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><BICFI>COBADEFFXXX</BICFI></FinInstnId></DbtrAgt>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-1</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="EUR">1500.00</InstdAmt></Amt>
+        <CdtrAgt><FinInstnId><BICFI>BNPAFRPPXXX</BICFI></FinInstnId></CdtrAgt>
+        <Cdtr><Nm>Fournisseur SA</Nm><PstlAdr><Ctry>FR</Ctry></PstlAdr></Cdtr>
+        <CdtrAcct><Id><IBAN>FR1420041010050500013M02606</IBAN></Id></CdtrAcct>
+        <Purp><Cd>SUPP</Cd></Purp>
+        <RmtInf><Ustrd>Invoice 4711</Ustrd></RmtInf>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-2</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="EUR">20.00</InstdAmt></Amt>
+        <Cdtr><Nm>Other</Nm></Cdtr>
+        <CdtrAcct><Id><IBAN>NL91ABNA0417164300</IBAN></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>`
+
+const pacs008 = `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08">
+  <FIToFICstmrCdtTrf>
+    <GrpHdr><MsgId>PACS8-1</MsgId><CreDtTm>2024-03-04T08:00:00Z</CreDtTm></GrpHdr>
+    <CdtTrfTxInf>
+      <PmtId><InstrId>I-1</InstrId><EndToEndId>E2E-1</EndToEndId><TxId>TX-1</TxId><UETR>8A562C67-CA16-48BA-B074-65581BE6F001</UETR></PmtId>
+      <IntrBkSttlmAmt Ccy="EUR">1500.00</IntrBkSttlmAmt>
+      <IntrBkSttlmDt>2024-03-04</IntrBkSttlmDt>
+      <Dbtr><Nm>Acme GmbH</Nm></Dbtr>
+      <DbtrAcct><Id><IBAN>DE89370400440532013000</IBAN></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><BICFI>COBADEFFXXX</BICFI></FinInstnId></DbtrAgt>
+      <CdtrAgt><FinInstnId><BICFI>BNPAFRPPXXX</BICFI></FinInstnId></CdtrAgt>
+      <Cdtr><Nm>Fournisseur SA</Nm><PstlAdr><Ctry>FR</Ctry></PstlAdr></Cdtr>
+      <CdtrAcct><Id><IBAN>FR1420041010050500013M02606</IBAN></Id></CdtrAcct>
+    </CdtTrfTxInf>
+  </FIToFICstmrCdtTrf>
+</Document>`
+
+func TestIngestISO20022_PaymentLifecycle(t *testing.T) {
+	s := store{}
+	g := NewIngestor(s.lookup)
+
+	result, err := g.IngestISO20022([]byte(pain001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Format != FormatPain001 || len(result.Created) != 2 {
+		t.Fatalf("expected two initiated payments, got %+v", result)
+	}
+	s.process(result)
+	payment := result.Created[0]
+	if payment.Status != models.TransactionStatusHeld || payment.SourceAccount != "DE89370400440532013000" || payment.Amount.String() != "1500" || payment.Currency != "EUR" {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if payment.Merchant == nil || payment.Merchant.Country != "FR" || payment.Metadata[MetaPurpose] != "SUPP" || payment.Description != "Invoice 4711" {
+		t.Errorf("unexpected payee details %+v %v", payment.Merchant, payment.Metadata)
+	}
+
+	// The interbank transfer carries the same end-to-end ID
+	result, err = g.IngestISO20022([]byte(pacs008))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 0 || len(result.Updated) != 1 || result.Updated[0].ID != payment.ID {
+		t.Fatalf("expected the transfer to update the initiation, got %+v", result)
+	}
+	s.process(result)
+
+	// Settled by UETR, which only the interbank transfer carried
+	result, err = g.IngestISO20022([]byte(`<Document><FIToFIPmtStsRpt>
+		<GrpHdr><MsgId>STS-1</MsgId><CreDtTm>2024-03-04T10:00:00Z</CreDtTm></GrpHdr>
+		<OrgnlGrpInfAndSts><OrgnlMsgId>PACS8-1</OrgnlMsgId><OrgnlMsgNmId>pacs.008.001.08</OrgnlMsgNmId></OrgnlGrpInfAndSts>
+		<TxInfAndSts><OrgnlUETR>8a562c67-ca16-48ba-b074-65581be6f001</OrgnlUETR><TxSts>ACSC</TxSts></TxInfAndSts>
+	</FIToFIPmtStsRpt></Document>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 || result.Updated[0].Status != models.TransactionStatusCompleted || result.Updated[0].SettledAt == nil {
+		t.Fatalf("expected the payment to settle, got %+v", result)
+	}
+	s.process(result)
+
+	// A late rejection cannot undo a settled payment, and the group
+	// status of the initiation rejects the other one
+	result, err = g.IngestISO20022([]byte(`<Document><FIToFIPmtStsRpt>
+		<GrpHdr><MsgId>STS-2</MsgId><CreDtTm>2024-03-04T11:00:00Z</CreDtTm></GrpHdr>
+		<OrgnlGrpInfAndSts><OrgnlMsgId>PAIN-1</OrgnlMsgId><GrpSts>RJCT</GrpSts><StsRsnInf><Rsn><Cd>AM04</Cd></Rsn></StsRsnInf></OrgnlGrpInfAndSts>
+	</FIToFIPmtStsRpt></Document>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Ignored) != 1 || len(result.Updated) != 1 {
+		t.Fatalf("expected one rejection and one ignored status, got %+v", result)
+	}
+	if txn := result.Updated[0]; txn.ID == payment.ID || txn.Status != models.TransactionStatusFailed || txn.Metadata[MetaStatusReason] != "AM04" {
+		t.Errorf("expected the other payment to be rejected, got %+v", txn)
+	}
+}
+
+func TestIngestISO20022_Errors(t *testing.T) {
+	g := NewIngestor(store{}.lookup)
+
+	if _, err := g.IngestISO20022([]byte(`<Document><BkToCstmrStmt/></Document>`)); err == nil {
+		t.Error("expected an error for an unsupported message")
+	}
+	result, err := g.IngestISO20022([]byte(`<Document><FIToFIPmtStsRpt>
+		<TxInfAndSts><OrgnlEndToEndId>UNKNOWN</OrgnlEndToEndId><TxSts>ACSC</TxSts></TxInfAndSts>
+	</FIToFIPmtStsRpt></Document>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unmatched) != 1 {
+		t.Errorf("expected an unmatched status, got %+v", result)
+	}
+}
@@ -0,0 +1,282 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ISO 8583:1987 card messages with an ASCII message type indicator and
+// ASCII numeric and text fields. The bitmaps and binary fields are either
+// raw bytes or hex characters, depending on the network.
+
+// ISO8583Encoding is how bitmaps and binary fields are encoded
+type ISO8583Encoding string
+
+const (
+	ISO8583Binary ISO8583Encoding = "binary"
+	ISO8583Hex    ISO8583Encoding = "hex"
+)
+
+// ISO8583Message is a parsed message. Binary fields are held as upper-case
+// hex whatever the wire encoding.
+type ISO8583Message struct {
+	MTI    string         `json:"mti"`
+	Fields map[int]string `json:"fields"`
+}
+
+type lengthType int
+
+const (
+	fixedLength lengthType = iota
+	llVar
+	lllVar
+)
+
+type fieldSpec struct {
+	length lengthType
+	max    int // length for fixed fields, maximum for variable ones
+	binary bool
+}
+
+func fixed(n int) fieldSpec { return fieldSpec{length: fixedLength, max: n} }
+func ll(n int) fieldSpec    { return fieldSpec{length: llVar, max: n} }
+func lll(n int) fieldSpec   { return fieldSpec{length: lllVar, max: n} }
+func binaryField(bytes int) fieldSpec {
+	return fieldSpec{length: fixedLength, max: bytes, binary: true}
+}
+
+// iso8583Fields are the ISO 8583:1987 data elements. Binary lengths are in
+// bytes, the others in characters.
+var iso8583Fields = func() map[int]fieldSpec {
+	f := map[int]fieldSpec{
+		2: ll(19), 3: fixed(6), 4: fixed(12), 5: fixed(12), 6: fixed(12),
+		7: fixed(10), 8: fixed(8), 9: fixed(8), 10: fixed(8), 11: fixed(6),
+		12: fixed(6), 13: fixed(4), 14: fixed(4), 15: fixed(4), 16: fixed(4),
+		17: fixed(4), 18: fixed(4), 19: fixed(3), 20: fixed(3), 21: fixed(3),
+		22: fixed(3), 23: fixed(3), 24: fixed(3), 25: fixed(2), 26: fixed(2),
+		27: fixed(1), 28: fixed(9), 29: fixed(9), 30: fixed(9), 31: fixed(9),
+		32: ll(11), 33: ll(11), 34: ll(28), 35: ll(37), 36: lll(104),
+		37: fixed(12), 38: fixed(6), 39: fixed(2), 40: fixed(3), 41: fixed(8),
+		42: fixed(15), 43: fixed(40), 44: ll(25), 45: ll(76), 46: lll(999),
+		47: lll(999), 48: lll(999), 49: fixed(3), 50: fixed(3), 51: fixed(3),
+		52: binaryField(8), 53: fixed(16), 54: lll(120),
+		64: binaryField(8), 65: binaryField(1), 66: fixed(1), 67: fixed(2),
+		68: fixed(3), 69: fixed(3), 70: fixed(3), 71: fixed(4), 72: fixed(4),
+		73: fixed(6), 90: fixed(42), 91: fixed(1), 92: fixed(2), 93: fixed(5),
+		94: fixed(7), 95: fixed(42), 96: binaryField(8), 97: fixed(17),
+		98: fixed(25), 99: ll(11), 100: ll(11), 101: ll(17), 102: ll(28),
+		103: ll(28), 104: lll(100), 128: binaryField(8),
+	}
+	for i := 55; i <= 63; i++ {
+		f[i] = lll(999)
+	}
+	for i := 74; i <= 81; i++ {
+		f[i] = fixed(10)
+	}
+	for i := 82; i <= 85; i++ {
+		f[i] = fixed(12)
+	}
+	for i := 86; i <= 89; i++ {
+		f[i] = fixed(16)
+	}
+	for i := 105; i <= 127; i++ {
+		f[i] = lll(999)
+	}
+	return f
+}()
+
+// ParseISO8583 parses a single message without any network length header
+func ParseISO8583(data []byte, enc ISO8583Encoding) (*ISO8583Message, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("message too short")
+	}
+	msg := &ISO8583Message{MTI: string(data[:4]), Fields: make(map[int]string)}
+	if _, err := strconv.Atoi(msg.MTI); err != nil {
+		return nil, fmt.Errorf("invalid message type %q", msg.MTI)
+	}
+	r := &fieldReader{data: data, pos: 4, enc: enc}
+
+	bitmap, err := r.binary(8)
+	if err != nil {
+		return nil, fmt.Errorf("primary bitmap: %w", err)
+	}
+	if bitmap[0]&0x80 != 0 {
+		secondary, err := r.binary(8)
+		if err != nil {
+			return nil, fmt.Errorf("secondary bitmap: %w", err)
+		}
+		bitmap = append(bitmap, secondary...)
+	}
+
+	for field := 2; field <= len(bitmap)*8; field++ {
+		if bitmap[(field-1)/8]&(0x80>>((field-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := iso8583Fields[field]
+		if !ok {
+			return nil, fmt.Errorf("field %d: not supported", field)
+		}
+		value, err := r.field(spec)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+		msg.Fields[field] = value
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes after field data", len(data)-r.pos)
+	}
+	return msg, nil
+}
+
+type fieldReader struct {
+	data []byte
+	pos  int
+	enc  ISO8583Encoding
+}
+
+func (r *fieldReader) take(n int) ([]byte, error) {
+	if r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("message truncated")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *fieldReader) binary(n int) ([]byte, error) {
+	if r.enc != ISO8583Hex {
+		return r.take(n)
+	}
+	b, err := r.take(n * 2)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := hex.DecodeString(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	return decoded, nil
+}
+
+func (r *fieldReader) field(spec fieldSpec) (string, error) {
+	if spec.binary {
+		b, err := r.binary(spec.max)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToUpper(hex.EncodeToString(b)), nil
+	}
+
+	n := spec.max
+	if spec.length != fixedLength {
+		digits := 2
+		if spec.length == lllVar {
+			digits = 3
+		}
+		prefix, err := r.take(digits)
+		if err != nil {
+			return "", err
+		}
+		n, err = strconv.Atoi(string(prefix))
+		if err != nil {
+			return "", fmt.Errorf("invalid length %q", prefix)
+		}
+		if n > spec.max {
+			return "", fmt.Errorf("length %d exceeds %d", n, spec.max)
+		}
+	}
+	b, err := r.take(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Pack encodes the message, setting the bitmaps from the fields present
+func (m *ISO8583Message) Pack(enc ISO8583Encoding) ([]byte, error) {
+	if len(m.MTI) != 4 {
+		return nil, fmt.Errorf("invalid message type %q", m.MTI)
+	}
+	fields := make([]int, 0, len(m.Fields))
+	for field := range m.Fields {
+		if _, ok := iso8583Fields[field]; !ok {
+			return nil, fmt.Errorf("field %d: not supported", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	bitmap := make([]byte, 8)
+	if len(fields) > 0 && fields[len(fields)-1] > 64 {
+		bitmap = make([]byte, 16)
+		bitmap[0] |= 0x80
+	}
+	var body []byte
+	for _, field := range fields {
+		bitmap[(field-1)/8] |= 0x80 >> ((field - 1) % 8)
+		value := m.Fields[field]
+		spec := iso8583Fields[field]
+		switch {
+		case spec.binary:
+			b, err := hex.DecodeString(value)
+			if err != nil || len(b) != spec.max {
+				return nil, fmt.Errorf("field %d: expected %d bytes of hex", field, spec.max)
+			}
+			body = append(body, encodeBinary(b, enc)...)
+		case spec.length == fixedLength:
+			if len(value) != spec.max {
+				return nil, fmt.Errorf("field %d: expected %d characters, got %d", field, spec.max, len(value))
+			}
+			body = append(body, value...)
+		default:
+			if len(value) > spec.max {
+				return nil, fmt.Errorf("field %d: length %d exceeds %d", field, len(value), spec.max)
+			}
+			format := "%02d"
+			if spec.length == lllVar {
+				format = "%03d"
+			}
+			body = append(body, fmt.Sprintf(format, len(value))...)
+			body = append(body, value...)
+		}
+	}
+
+	out := append([]byte(m.MTI), encodeBinary(bitmap, enc)...)
+	return append(out, body...), nil
+}
+
+func encodeBinary(b []byte, enc ISO8583Encoding) []byte {
+	if enc == ISO8583Hex {
+		return []byte(strings.ToUpper(hex.EncodeToString(b)))
+	}
+	return b
+}
+
+// SplitISO8583Frames splits a capture of messages that each carry the
+// usual two-byte big-endian length header
+func SplitISO8583Frames(data []byte) ([][]byte, error) {
+	var frames [][]byte
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated length header after %d messages", len(frames))
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			return nil, fmt.Errorf("message %d truncated: %d of %d bytes", len(frames)+1, len(data)-2, n)
+		}
+		frames = append(frames, data[2:2+n])
+		data = data[2+n:]
+	}
+	return frames, nil
+}
+
+// FrameISO8583 prefixes a message with its two-byte length header
+func FrameISO8583(msg []byte) []byte {
+	out := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(out, uint16(len(msg)))
+	return append(out, msg...)
+}
@@ -0,0 +1,194 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// store stands in for the transaction engine, keeping what the ingestor
+// creates and updates
+type store map[string]*models.Transaction
+
+func (s store) lookup(id string) (*models.Transaction, bool) {
+	txn, ok := s[id]
+	return txn, ok
+}
+
+func (s store) process(r *Result) {
+	for _, txn := range append(r.Created, r.Updated...) {
+		s[txn.ID] = txn
+	}
+}
+
+func cardMessage(mti string, fields map[int]string) *ISO8583Message {
+	base := map[int]string{
+		2:  "4111111111111111",
+		3:  "000000",
+		4:  "000000012550",
+		7:  "0301120000",
+		11: "000123",
+		18: "5812",
+		19: "840",
+		37: "406112345678",
+		41: "TERM0001",
+		42: "MERCHANT0000001",
+		43: "BLUE BOTTLE CAFE         OAKLAND      US",
+		49: "840",
+	}
+	for k, v := range fields {
+		if v == "" {
+			delete(base, k)
+		} else {
+			base[k] = v
+		}
+	}
+	return &ISO8583Message{MTI: mti, Fields: base}
+}
+
+// originalData builds field 90 from the original message type, STAN and
+// transmission time, with acquirer and forwarder IDs
+func originalData(mti, stan, transmitted string) string {
+	return mti + stan + transmitted + "00000012345" + "00000000000"
+}
+
+func frames(t *testing.T, enc ISO8583Encoding, msgs ...*ISO8583Message) []byte {
+	t.Helper()
+	var out []byte
+	for _, msg := range msgs {
+		packed, err := msg.Pack(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, FrameISO8583(packed)...)
+	}
+	return out
+}
+
+func TestISO8583_PackParse(t *testing.T) {
+	msg := cardMessage("0420", map[int]string{
+		52:  "0123456789ABCDEF",
+		90:  originalData("0100", "000123", "0301120000"),
+		102: "ACC-42",
+	})
+
+	for _, enc := range []ISO8583Encoding{ISO8583Binary, ISO8583Hex} {
+		packed, err := msg.Pack(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseISO8583(packed, enc)
+		if err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		if parsed.MTI != "0420" || len(parsed.Fields) != len(msg.Fields) {
+			t.Fatalf("%s: unexpected message %+v", enc, parsed)
+		}
+		for field, value := range msg.Fields {
+			if parsed.Fields[field] != value {
+				t.Errorf("%s: field %d: expected %q, got %q", enc, field, value, parsed.Fields[field])
+			}
+		}
+	}
+
+	packed, _ := msg.Pack(ISO8583Binary)
+	if _, err := ParseISO8583(packed[:len(packed)-3], ISO8583Binary); err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
+
+func TestIngestISO8583_AuthorizationAndClearing(t *testing.T) {
+	s := store{}
+	g := NewIngestor(s.lookup)
+
+	// The authorization request and its approved response
+	result, err := g.IngestISO8583(frames(t, ISO8583Binary,
+		cardMessage("0100", nil),
+		cardMessage("0110", map[int]string{38: "A1B2C3", 39: "00"}),
+	), ISO8583Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 1 || len(result.Updated) != 0 {
+		t.Fatalf("expected one authorization, got %+v", result)
+	}
+	s.process(result)
+	auth := result.Created[0]
+	if auth.Status != models.TransactionStatusHeld || auth.Amount.String() != "125.5" || auth.Currency != "USD" {
+		t.Errorf("unexpected authorization %+v", auth)
+	}
+	if auth.Merchant == nil || auth.Merchant.MCC != "5812" || auth.Merchant.Country != "US" || auth.Merchant.Name != "BLUE BOTTLE CAFE" {
+		t.Errorf("unexpected merchant %+v", auth.Merchant)
+	}
+	if auth.Metadata[MetaCardPAN] != "411111******1111" || auth.Metadata[MetaApprovalCode] != "A1B2C3" {
+		t.Errorf("unexpected metadata %v", auth.Metadata)
+	}
+	if strings.Contains(auth.SourceAccount, "4111111111111111") {
+		t.Error("the full card number must not be stored")
+	}
+
+	// The clearing days later carries the RRN and approval code but a new
+	// STAN, and a tip
+	result, err = g.IngestISO8583(frames(t, ISO8583Binary,
+		cardMessage("0220", map[int]string{4: "000000014000", 7: "0303020000", 11: "000987", 38: "A1B2C3"}),
+	), ISO8583Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 0 || len(result.Updated) != 1 || result.Updated[0].ID != auth.ID {
+		t.Fatalf("expected the clearing to update the authorization, got %+v", result)
+	}
+	cleared := result.Updated[0]
+	if cleared.Status != models.TransactionStatusCompleted || cleared.SettledAt == nil || cleared.Metadata[MetaClearingAmount] != "140" {
+		t.Errorf("unexpected cleared transaction %+v", cleared)
+	}
+	if cleared.Amount.String() != "125.5" {
+		t.Errorf("expected the authorized amount to be kept, got %s", cleared.Amount)
+	}
+}
+
+func TestIngestISO8583_DeclineAndReversal(t *testing.T) {
+	s := store{}
+	g := NewIngestor(s.lookup)
+
+	result, err := g.IngestISO8583(frames(t, ISO8583Hex,
+		cardMessage("0110", map[int]string{39: "05", 11: "000200", 37: "406100000200"}),
+		cardMessage("0100", map[int]string{11: "000300", 37: "406100000300"}),
+	), ISO8583Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.process(result)
+	if len(result.Created) != 2 || result.Created[0].Status != models.TransactionStatusFailed {
+		t.Fatalf("expected a declined and a held authorization, got %+v", result.Created)
+	}
+	held := result.Created[1]
+
+	// The reversal names the original in field 90
+	result, err = g.IngestISO8583(frames(t, ISO8583Hex,
+		cardMessage("0420", map[int]string{11: "000301", 7: "0301120500", 37: "", 90: originalData("0100", "000300", "0301120000")}),
+		cardMessage("0420", map[int]string{11: "000999", 37: "999999999999"}),
+	), ISO8583Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 || result.Updated[0].ID != held.ID || result.Updated[0].Status != models.TransactionStatusReversed {
+		t.Fatalf("expected the authorization to be reversed, got %+v", result)
+	}
+	if len(result.Unmatched) != 1 {
+		t.Errorf("expected the reversal without an original to be unmatched, got %v", result.Unmatched)
+	}
+}
+
+func TestCardTime(t *testing.T) {
+	now := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	got, err := cardTime(map[int]string{7: "1231235900"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("expected the previous year, got %s", got)
+	}
+}
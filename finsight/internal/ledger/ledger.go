@@ -0,0 +1,556 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// System accounts used as the contra side of customer postings
+const (
+	AccountClearing        = "system:clearing"
+	AccountFeeIncome       = "system:fee_income"
+	AccountInterestExpense = "system:interest_expense"
+	AccountOpeningEquity   = "system:opening_equity"
+)
+
+// Direction is the side of an entry
+type Direction string
+
+const (
+	DirectionDebit  Direction = "debit"
+	DirectionCredit Direction = "credit"
+)
+
+// EntryStatus represents the lifecycle of a ledger entry
+type EntryStatus string
+
+const (
+	EntryStatusPending  EntryStatus = "pending"
+	EntryStatusPosted   EntryStatus = "posted"
+	EntryStatusReleased EntryStatus = "released"
+)
+
+// JournalStatus represents the lifecycle of a journal
+type JournalStatus string
+
+const (
+	JournalStatusPending  JournalStatus = "pending"
+	JournalStatusPosted   JournalStatus = "posted"
+	JournalStatusReleased JournalStatus = "released"
+	JournalStatusReversed JournalStatus = "reversed"
+)
+
+// Entry is a single debit or credit line in a journal
+type Entry struct {
+	ID            string          `json:"id"`
+	JournalID     string          `json:"journal_id"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+	AccountID     string          `json:"account_id"`
+	Direction     Direction       `json:"direction"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency,omitempty"`
+	Status        EntryStatus     `json:"status"`
+	Description   string          `json:"description,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	PostedAt      *time.Time      `json:"posted_at,omitempty"`
+	ReleasedAt    *time.Time      `json:"released_at,omitempty"`
+}
+
+// Journal groups balanced entries produced by a single business event
+type Journal struct {
+	ID            string        `json:"id"`
+	TransactionID string        `json:"transaction_id,omitempty"`
+	Description   string        `json:"description"`
+	Status        JournalStatus `json:"status"`
+	Entries       []*Entry      `json:"entries"`
+	ReversalOf    string        `json:"reversal_of,omitempty"`
+	ReversedBy    string        `json:"reversed_by,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// Ledger is an append-only double-entry ledger
+type Ledger struct {
+	journals      map[string]*Journal
+	byTransaction map[string][]string
+	byAccount     map[string][]*Entry
+	seq           int64
+	mu            sync.RWMutex
+}
+
+// NewLedger creates a new ledger
+func NewLedger() *Ledger {
+	return &Ledger{
+		journals:      make(map[string]*Journal),
+		byTransaction: make(map[string][]string),
+		byAccount:     make(map[string][]*Entry),
+	}
+}
+
+// PostingLine describes one side of a journal before it is recorded
+type PostingLine struct {
+	AccountID string
+	Direction Direction
+	Amount    decimal.Decimal
+}
+
+// Post records a balanced journal with immediately posted entries
+func (l *Ledger) Post(txnID, currency, description string, at time.Time, lines []PostingLine) (*Journal, error) {
+	return l.record(txnID, currency, description, at, lines, EntryStatusPosted)
+}
+
+// Hold records a balanced journal with pending entries that must later be
+// settled or released
+func (l *Ledger) Hold(txnID, currency, description string, at time.Time, lines []PostingLine) (*Journal, error) {
+	return l.record(txnID, currency, description, at, lines, EntryStatusPending)
+}
+
+func (l *Ledger) record(txnID, currency, description string, at time.Time, lines []PostingLine, status EntryStatus) (*Journal, error) {
+	if err := validateLines(lines); err != nil {
+		return nil, err
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recordLocked(txnID, currency, description, at, lines, status), nil
+}
+
+func (l *Ledger) recordLocked(txnID, currency, description string, at time.Time, lines []PostingLine, status EntryStatus) *Journal {
+	journal := &Journal{
+		ID:            l.nextID("jrn"),
+		TransactionID: txnID,
+		Description:   description,
+		Status:        JournalStatusPosted,
+		CreatedAt:     at,
+	}
+	if status == EntryStatusPending {
+		journal.Status = JournalStatusPending
+	}
+
+	for _, line := range lines {
+		entry := &Entry{
+			ID:            l.nextID("ent"),
+			JournalID:     journal.ID,
+			TransactionID: txnID,
+			AccountID:     line.AccountID,
+			Direction:     line.Direction,
+			Amount:        line.Amount,
+			Currency:      currency,
+			Status:        status,
+			Description:   description,
+			CreatedAt:     at,
+		}
+		if status == EntryStatusPosted {
+			postedAt := at
+			entry.PostedAt = &postedAt
+		}
+		journal.Entries = append(journal.Entries, entry)
+		l.byAccount[line.AccountID] = append(l.byAccount[line.AccountID], entry)
+	}
+
+	l.journals[journal.ID] = journal
+	if txnID != "" {
+		l.byTransaction[txnID] = append(l.byTransaction[txnID], journal.ID)
+	}
+
+	return journal
+}
+
+func validateLines(lines []PostingLine) error {
+	if len(lines) < 2 {
+		return ErrUnbalanced
+	}
+
+	var debits, credits decimal.Decimal
+	for _, line := range lines {
+		if line.AccountID == "" {
+			return ErrMissingAccount
+		}
+		if !line.Amount.IsPositive() {
+			return ErrInvalidAmount
+		}
+		switch line.Direction {
+		case DirectionDebit:
+			debits = debits.Add(line.Amount)
+		case DirectionCredit:
+			credits = credits.Add(line.Amount)
+		default:
+			return ErrInvalidDirection
+		}
+	}
+
+	if !debits.Equal(credits) {
+		return ErrUnbalanced
+	}
+	return nil
+}
+
+// Settle converts the pending entries of a held journal into posted entries
+func (l *Ledger) Settle(journalID string, at time.Time) error {
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	journal, ok := l.journals[journalID]
+	if !ok {
+		return ErrJournalNotFound
+	}
+	if journal.Status != JournalStatusPending {
+		return ErrNotPending
+	}
+
+	for _, entry := range journal.Entries {
+		postedAt := at
+		entry.Status = EntryStatusPosted
+		entry.PostedAt = &postedAt
+	}
+	journal.Status = JournalStatusPosted
+	return nil
+}
+
+// Release cancels the pending entries of a held journal
+func (l *Ledger) Release(journalID string, at time.Time) error {
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	journal, ok := l.journals[journalID]
+	if !ok {
+		return ErrJournalNotFound
+	}
+	if journal.Status != JournalStatusPending {
+		return ErrNotPending
+	}
+
+	for _, entry := range journal.Entries {
+		releasedAt := at
+		entry.Status = EntryStatusReleased
+		entry.ReleasedAt = &releasedAt
+	}
+	journal.Status = JournalStatusReleased
+	return nil
+}
+
+// Reverse posts a compensating journal that mirrors a posted journal
+func (l *Ledger) Reverse(journalID, reason string, at time.Time) (*Journal, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	original, ok := l.journals[journalID]
+	if !ok {
+		return nil, ErrJournalNotFound
+	}
+	if original.Status != JournalStatusPosted {
+		return nil, ErrNotPosted
+	}
+
+	lines := make([]PostingLine, 0, len(original.Entries))
+	currency := ""
+	for _, entry := range original.Entries {
+		lines = append(lines, PostingLine{
+			AccountID: entry.AccountID,
+			Direction: opposite(entry.Direction),
+			Amount:    entry.Amount,
+		})
+		currency = entry.Currency
+	}
+
+	description := "Reversal of " + journalID
+	if reason != "" {
+		description += ": " + reason
+	}
+
+	reversal := l.recordLocked(original.TransactionID, currency, description, at, lines, EntryStatusPosted)
+	reversal.ReversalOf = original.ID
+	original.ReversedBy = reversal.ID
+	original.Status = JournalStatusReversed
+
+	return reversal, nil
+}
+
+func opposite(d Direction) Direction {
+	if d == DirectionDebit {
+		return DirectionCredit
+	}
+	return DirectionDebit
+}
+
+// GetJournal retrieves a journal by ID
+func (l *Ledger) GetJournal(id string) (*Journal, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	journal, ok := l.journals[id]
+	return journal, ok
+}
+
+// GetTransactionJournals returns all journals recorded for a transaction in
+// posting order
+func (l *Ledger) GetTransactionJournals(txnID string) []*Journal {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var results []*Journal
+	for _, id := range l.byTransaction[txnID] {
+		results = append(results, l.journals[id])
+	}
+	return results
+}
+
+// AccountBalance is the derived balance of an account at a point in time
+type AccountBalance struct {
+	AccountID string          `json:"account_id"`
+	AsOf      time.Time       `json:"as_of"`
+	Debits    decimal.Decimal `json:"debits"`
+	Credits   decimal.Decimal `json:"credits"`
+	Balance   decimal.Decimal `json:"balance"`
+	Pending   decimal.Decimal `json:"pending"`
+	Available decimal.Decimal `json:"available"`
+}
+
+// Balance derives the balance of an account as of the given time. Balances
+// are expressed as credits minus debits, so a customer deposit account
+// carries a positive balance. Pending holds reduce the available balance
+// only when they would debit the account.
+func (l *Ledger) Balance(accountID string, asOf time.Time) *AccountBalance {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	bal := &AccountBalance{AccountID: accountID, AsOf: asOf}
+	for _, entry := range l.byAccount[accountID] {
+		switch {
+		case postedAsOf(entry, asOf):
+			if entry.Direction == DirectionDebit {
+				bal.Debits = bal.Debits.Add(entry.Amount)
+			} else {
+				bal.Credits = bal.Credits.Add(entry.Amount)
+			}
+		case pendingAsOf(entry, asOf):
+			if entry.Direction == DirectionDebit {
+				bal.Pending = bal.Pending.Add(entry.Amount)
+			}
+		}
+	}
+
+	bal.Balance = bal.Credits.Sub(bal.Debits)
+	bal.Available = bal.Balance.Sub(bal.Pending)
+	return bal
+}
+
+func postedAsOf(entry *Entry, asOf time.Time) bool {
+	return entry.PostedAt != nil && !entry.PostedAt.After(asOf)
+}
+
+func pendingAsOf(entry *Entry, asOf time.Time) bool {
+	if entry.CreatedAt.After(asOf) {
+		return false
+	}
+	if entry.ReleasedAt != nil && !entry.ReleasedAt.After(asOf) {
+		return false
+	}
+	return !postedAsOf(entry, asOf)
+}
+
+// Statement is an account statement for a period
+type Statement struct {
+	AccountID      string          `json:"account_id"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance decimal.Decimal `json:"opening_balance"`
+	ClosingBalance decimal.Decimal `json:"closing_balance"`
+	TotalDebits    decimal.Decimal `json:"total_debits"`
+	TotalCredits   decimal.Decimal `json:"total_credits"`
+	PendingAmount  decimal.Decimal `json:"pending_amount"`
+	Lines          []StatementLine `json:"lines"`
+}
+
+// StatementLine is a posted entry with the running balance after it
+type StatementLine struct {
+	EntryID        string          `json:"entry_id"`
+	JournalID      string          `json:"journal_id"`
+	TransactionID  string          `json:"transaction_id,omitempty"`
+	PostedAt       time.Time       `json:"posted_at"`
+	Description    string          `json:"description"`
+	Debit          decimal.Decimal `json:"debit"`
+	Credit         decimal.Decimal `json:"credit"`
+	RunningBalance decimal.Decimal `json:"running_balance"`
+}
+
+// Statement builds the statement of an account for the period [from, to]
+func (l *Ledger) Statement(accountID string, from, to time.Time) *Statement {
+	opening := l.Balance(accountID, from.Add(-time.Nanosecond))
+	closing := l.Balance(accountID, to)
+
+	l.mu.RLock()
+	var posted []*Entry
+	for _, entry := range l.byAccount[accountID] {
+		if entry.PostedAt != nil && !entry.PostedAt.Before(from) && !entry.PostedAt.After(to) {
+			posted = append(posted, entry)
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.SliceStable(posted, func(i, j int) bool {
+		return posted[i].PostedAt.Before(*posted[j].PostedAt)
+	})
+
+	stmt := &Statement{
+		AccountID:      accountID,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening.Balance,
+		ClosingBalance: closing.Balance,
+		PendingAmount:  closing.Pending,
+		Lines:          make([]StatementLine, 0, len(posted)),
+	}
+
+	running := opening.Balance
+	for _, entry := range posted {
+		line := StatementLine{
+			EntryID:       entry.ID,
+			JournalID:     entry.JournalID,
+			TransactionID: entry.TransactionID,
+			PostedAt:      *entry.PostedAt,
+			Description:   entry.Description,
+		}
+		if entry.Direction == DirectionDebit {
+			line.Debit = entry.Amount
+			running = running.Sub(entry.Amount)
+			stmt.TotalDebits = stmt.TotalDebits.Add(entry.Amount)
+		} else {
+			line.Credit = entry.Amount
+			running = running.Add(entry.Amount)
+			stmt.TotalCredits = stmt.TotalCredits.Add(entry.Amount)
+		}
+		line.RunningBalance = running
+		stmt.Lines = append(stmt.Lines, line)
+	}
+
+	return stmt
+}
+
+// TrialBalance lists posted debit and credit totals for every account
+type TrialBalance struct {
+	AsOf         time.Time          `json:"as_of"`
+	Lines        []TrialBalanceLine `json:"lines"`
+	TotalDebits  decimal.Decimal    `json:"total_debits"`
+	TotalCredits decimal.Decimal    `json:"total_credits"`
+	Balanced     bool               `json:"balanced"`
+}
+
+// TrialBalanceLine contains the totals of a single account
+type TrialBalanceLine struct {
+	AccountID string          `json:"account_id"`
+	Debits    decimal.Decimal `json:"debits"`
+	Credits   decimal.Decimal `json:"credits"`
+	Balance   decimal.Decimal `json:"balance"`
+}
+
+// TrialBalance builds the trial balance as of the given time
+func (l *Ledger) TrialBalance(asOf time.Time) *TrialBalance {
+	l.mu.RLock()
+	accountIDs := make([]string, 0, len(l.byAccount))
+	for id := range l.byAccount {
+		accountIDs = append(accountIDs, id)
+	}
+	l.mu.RUnlock()
+	sort.Strings(accountIDs)
+
+	tb := &TrialBalance{AsOf: asOf}
+	for _, id := range accountIDs {
+		bal := l.Balance(id, asOf)
+		if bal.Debits.IsZero() && bal.Credits.IsZero() {
+			continue
+		}
+		tb.Lines = append(tb.Lines, TrialBalanceLine{
+			AccountID: id,
+			Debits:    bal.Debits,
+			Credits:   bal.Credits,
+			Balance:   bal.Balance,
+		})
+		tb.TotalDebits = tb.TotalDebits.Add(bal.Debits)
+		tb.TotalCredits = tb.TotalCredits.Add(bal.Credits)
+	}
+	tb.Balanced = tb.TotalDebits.Equal(tb.TotalCredits)
+
+	return tb
+}
+
+// LinesForTransaction returns the balanced posting lines for a transaction.
+// Customer accounts are debited when money leaves them and credited when
+// money arrives; the other side is booked to the relevant system account.
+// A transaction not linked to any account returns ErrNoLedgerEffect.
+func LinesForTransaction(txn *models.Transaction) ([]PostingLine, error) {
+	if txn.SourceAccount == "" {
+		return nil, ErrNoLedgerEffect
+	}
+
+	amount := txn.Amount
+	switch txn.Type {
+	case models.TransactionTypeDebit:
+		return pair(txn.SourceAccount, AccountClearing, amount), nil
+	case models.TransactionTypeCredit, models.TransactionTypeRefund:
+		return pair(AccountClearing, txn.SourceAccount, amount), nil
+	case models.TransactionTypeFee:
+		return pair(txn.SourceAccount, AccountFeeIncome, amount), nil
+	case models.TransactionTypeInterest:
+		return pair(AccountInterestExpense, txn.SourceAccount, amount), nil
+	case models.TransactionTypeTransfer:
+		if txn.DestAccount == "" {
+			return nil, ErrMissingAccount
+		}
+		return pair(txn.SourceAccount, txn.DestAccount, amount), nil
+	default:
+		return nil, fmt.Errorf("unsupported transaction type for ledger posting: %s", txn.Type)
+	}
+}
+
+func pair(debitAccount, creditAccount string, amount decimal.Decimal) []PostingLine {
+	return []PostingLine{
+		{AccountID: debitAccount, Direction: DirectionDebit, Amount: amount},
+		{AccountID: creditAccount, Direction: DirectionCredit, Amount: amount},
+	}
+}
+
+func (l *Ledger) nextID(prefix string) string {
+	l.seq++
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), l.seq)
+}
+
+// Errors
+var (
+	ErrJournalNotFound  = &Error{Code: "JOURNAL_NOT_FOUND", Message: "Journal not found"}
+	ErrUnbalanced       = &Error{Code: "UNBALANCED_JOURNAL", Message: "Journal debits and credits do not balance"}
+	ErrInvalidAmount    = &Error{Code: "INVALID_AMOUNT", Message: "Entry amount must be positive"}
+	ErrInvalidDirection = &Error{Code: "INVALID_DIRECTION", Message: "Entry direction must be debit or credit"}
+	ErrMissingAccount   = &Error{Code: "MISSING_ACCOUNT", Message: "Entry account is required"}
+	ErrNotPending       = &Error{Code: "JOURNAL_NOT_PENDING", Message: "Journal is not pending"}
+	ErrNotPosted        = &Error{Code: "JOURNAL_NOT_POSTED", Message: "Journal is not posted"}
+	ErrNoLedgerEffect   = &Error{Code: "NO_LEDGER_EFFECT", Message: "Transaction is not linked to an account"}
+)
+
+// Error represents a ledger error
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
@@ -0,0 +1,232 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestLedger_Post_Balanced(t *testing.T) {
+	l := NewLedger()
+
+	journal, err := l.Post("TXN-001", "USD", "payment", time.Now(), pair("ACC-001", AccountClearing, decimal.NewFromInt(100)))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	if journal.Status != JournalStatusPosted {
+		t.Errorf("Status = %s, want posted", journal.Status)
+	}
+	if len(journal.Entries) != 2 {
+		t.Fatalf("Entries = %d, want 2", len(journal.Entries))
+	}
+
+	bal := l.Balance("ACC-001", time.Now())
+	if !bal.Balance.Equal(decimal.NewFromInt(-100)) {
+		t.Errorf("Balance = %s, want -100", bal.Balance)
+	}
+}
+
+func TestLedger_Post_Unbalanced(t *testing.T) {
+	l := NewLedger()
+
+	lines := []PostingLine{
+		{AccountID: "ACC-001", Direction: DirectionDebit, Amount: decimal.NewFromInt(100)},
+		{AccountID: AccountClearing, Direction: DirectionCredit, Amount: decimal.NewFromInt(90)},
+	}
+	if _, err := l.Post("TXN-001", "USD", "bad", time.Now(), lines); err != ErrUnbalanced {
+		t.Errorf("err = %v, want ErrUnbalanced", err)
+	}
+
+	if _, err := l.Post("TXN-001", "USD", "bad", time.Now(), lines[:1]); err != ErrUnbalanced {
+		t.Errorf("single line err = %v, want ErrUnbalanced", err)
+	}
+
+	lines[1].Amount = decimal.NewFromInt(-100)
+	if _, err := l.Post("TXN-001", "USD", "bad", time.Now(), lines); err != ErrInvalidAmount {
+		t.Errorf("negative amount err = %v, want ErrInvalidAmount", err)
+	}
+}
+
+func TestLedger_HoldSettle(t *testing.T) {
+	l := NewLedger()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l.Post("", "USD", "opening", start, pair(AccountOpeningEquity, "ACC-001", decimal.NewFromInt(500)))
+	journal, err := l.Hold("TXN-001", "USD", "card auth", start.Add(time.Hour), pair("ACC-001", AccountClearing, decimal.NewFromInt(200)))
+	if err != nil {
+		t.Fatalf("Hold failed: %v", err)
+	}
+
+	bal := l.Balance("ACC-001", start.Add(2*time.Hour))
+	if !bal.Balance.Equal(decimal.NewFromInt(500)) {
+		t.Errorf("Balance = %s, want 500", bal.Balance)
+	}
+	if !bal.Pending.Equal(decimal.NewFromInt(200)) {
+		t.Errorf("Pending = %s, want 200", bal.Pending)
+	}
+	if !bal.Available.Equal(decimal.NewFromInt(300)) {
+		t.Errorf("Available = %s, want 300", bal.Available)
+	}
+
+	if err := l.Settle(journal.ID, start.Add(24*time.Hour)); err != nil {
+		t.Fatalf("Settle failed: %v", err)
+	}
+	if err := l.Settle(journal.ID, start.Add(25*time.Hour)); err != ErrNotPending {
+		t.Errorf("second Settle err = %v, want ErrNotPending", err)
+	}
+
+	// Before settlement the hold is still pending
+	before := l.Balance("ACC-001", start.Add(12*time.Hour))
+	if !before.Balance.Equal(decimal.NewFromInt(500)) || !before.Pending.Equal(decimal.NewFromInt(200)) {
+		t.Errorf("before settle = %s/%s, want 500/200", before.Balance, before.Pending)
+	}
+
+	after := l.Balance("ACC-001", start.Add(48*time.Hour))
+	if !after.Balance.Equal(decimal.NewFromInt(300)) || !after.Pending.IsZero() {
+		t.Errorf("after settle = %s/%s, want 300/0", after.Balance, after.Pending)
+	}
+}
+
+func TestLedger_HoldRelease(t *testing.T) {
+	l := NewLedger()
+	now := time.Now()
+
+	journal, _ := l.Hold("TXN-001", "USD", "card auth", now, pair("ACC-001", AccountClearing, decimal.NewFromInt(50)))
+	if err := l.Release(journal.ID, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	bal := l.Balance("ACC-001", now.Add(time.Hour))
+	if !bal.Balance.IsZero() || !bal.Pending.IsZero() {
+		t.Errorf("balance = %s/%s, want 0/0", bal.Balance, bal.Pending)
+	}
+	if got, _ := l.GetJournal(journal.ID); got.Status != JournalStatusReleased {
+		t.Errorf("Status = %s, want released", got.Status)
+	}
+}
+
+func TestLedger_Reverse(t *testing.T) {
+	l := NewLedger()
+	now := time.Now()
+
+	journal, _ := l.Post("TXN-001", "USD", "transfer", now, pair("ACC-001", "ACC-002", decimal.NewFromInt(75)))
+	reversal, err := l.Reverse(journal.ID, "customer dispute", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Reverse failed: %v", err)
+	}
+	if reversal.ReversalOf != journal.ID {
+		t.Errorf("ReversalOf = %s, want %s", reversal.ReversalOf, journal.ID)
+	}
+	if journal.Status != JournalStatusReversed || journal.ReversedBy != reversal.ID {
+		t.Errorf("original not marked reversed: %s/%s", journal.Status, journal.ReversedBy)
+	}
+
+	for _, acc := range []string{"ACC-001", "ACC-002"} {
+		if bal := l.Balance(acc, now.Add(time.Hour)); !bal.Balance.IsZero() {
+			t.Errorf("%s balance = %s, want 0", acc, bal.Balance)
+		}
+	}
+
+	if _, err := l.Reverse(journal.ID, "", now); err != ErrNotPosted {
+		t.Errorf("double reverse err = %v, want ErrNotPosted", err)
+	}
+	if got := l.GetTransactionJournals("TXN-001"); len(got) != 2 {
+		t.Errorf("journals = %d, want 2", len(got))
+	}
+}
+
+func TestLedger_Statement(t *testing.T) {
+	l := NewLedger()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	l.Post("", "USD", "opening", day.AddDate(0, 0, -1), pair(AccountOpeningEquity, "ACC-001", decimal.NewFromInt(1000)))
+	l.Post("TXN-001", "USD", "salary", day.Add(time.Hour), pair(AccountClearing, "ACC-001", decimal.NewFromInt(200)))
+	l.Post("TXN-002", "USD", "rent", day.Add(2*time.Hour), pair("ACC-001", AccountClearing, decimal.NewFromInt(700)))
+	l.Post("TXN-003", "USD", "next day", day.AddDate(0, 0, 1), pair("ACC-001", AccountClearing, decimal.NewFromInt(5)))
+
+	stmt := l.Statement("ACC-001", day, day.Add(24*time.Hour-time.Nanosecond))
+
+	if !stmt.OpeningBalance.Equal(decimal.NewFromInt(1000)) {
+		t.Errorf("OpeningBalance = %s, want 1000", stmt.OpeningBalance)
+	}
+	if !stmt.ClosingBalance.Equal(decimal.NewFromInt(500)) {
+		t.Errorf("ClosingBalance = %s, want 500", stmt.ClosingBalance)
+	}
+	if len(stmt.Lines) != 2 {
+		t.Fatalf("Lines = %d, want 2", len(stmt.Lines))
+	}
+	if !stmt.Lines[0].RunningBalance.Equal(decimal.NewFromInt(1200)) {
+		t.Errorf("first running balance = %s, want 1200", stmt.Lines[0].RunningBalance)
+	}
+	if !stmt.Lines[1].RunningBalance.Equal(stmt.ClosingBalance) {
+		t.Errorf("last running balance = %s, want closing %s", stmt.Lines[1].RunningBalance, stmt.ClosingBalance)
+	}
+}
+
+func TestLedger_TrialBalance(t *testing.T) {
+	l := NewLedger()
+	now := time.Now()
+
+	l.Post("TXN-001", "USD", "", now, pair(AccountClearing, "ACC-001", decimal.NewFromInt(300)))
+	l.Post("TXN-002", "USD", "", now, pair("ACC-001", "ACC-002", decimal.NewFromInt(120)))
+	l.Post("TXN-003", "USD", "", now, pair("ACC-002", AccountFeeIncome, decimal.NewFromInt(3)))
+	l.Hold("TXN-004", "USD", "", now, pair("ACC-001", AccountClearing, decimal.NewFromInt(10)))
+
+	tb := l.TrialBalance(now.Add(time.Second))
+	if !tb.Balanced {
+		t.Errorf("trial balance not balanced: %s vs %s", tb.TotalDebits, tb.TotalCredits)
+	}
+	if !tb.TotalDebits.Equal(decimal.NewFromInt(423)) {
+		t.Errorf("TotalDebits = %s, want 423", tb.TotalDebits)
+	}
+	if len(tb.Lines) != 4 {
+		t.Errorf("Lines = %d, want 4", len(tb.Lines))
+	}
+}
+
+func TestLinesForTransaction(t *testing.T) {
+	tests := []struct {
+		txnType models.TransactionType
+		debit   string
+		credit  string
+	}{
+		{models.TransactionTypeDebit, "ACC-001", AccountClearing},
+		{models.TransactionTypeCredit, AccountClearing, "ACC-001"},
+		{models.TransactionTypeRefund, AccountClearing, "ACC-001"},
+		{models.TransactionTypeFee, "ACC-001", AccountFeeIncome},
+		{models.TransactionTypeInterest, AccountInterestExpense, "ACC-001"},
+		{models.TransactionTypeTransfer, "ACC-001", "ACC-002"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.txnType), func(t *testing.T) {
+			lines, err := LinesForTransaction(&models.Transaction{
+				Type:          tt.txnType,
+				SourceAccount: "ACC-001",
+				DestAccount:   "ACC-002",
+				Amount:        decimal.NewFromInt(10),
+			})
+			if err != nil {
+				t.Fatalf("LinesForTransaction failed: %v", err)
+			}
+			if lines[0].AccountID != tt.debit || lines[0].Direction != DirectionDebit {
+				t.Errorf("debit line = %+v, want %s", lines[0], tt.debit)
+			}
+			if lines[1].AccountID != tt.credit || lines[1].Direction != DirectionCredit {
+				t.Errorf("credit line = %+v, want %s", lines[1], tt.credit)
+			}
+		})
+	}
+
+	if _, err := LinesForTransaction(&models.Transaction{Type: models.TransactionTypeTransfer, SourceAccount: "ACC-001"}); err != ErrMissingAccount {
+		t.Errorf("transfer without destination err = %v, want ErrMissingAccount", err)
+	}
+	if _, err := LinesForTransaction(&models.Transaction{Type: models.TransactionTypeDebit}); err != ErrNoLedgerEffect {
+		t.Errorf("transaction without account err = %v, want ErrNoLedgerEffect", err)
+	}
+	if _, err := LinesForTransaction(&models.Transaction{Type: "chargeback", SourceAccount: "ACC-001"}); err == nil {
+		t.Error("unsupported type should fail")
+	}
+}
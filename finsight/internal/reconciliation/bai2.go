@@ -0,0 +1,255 @@
+package reconciliation
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// BAI2 cash management balance reporting files
+
+// bai2ZeroDecimalCurrencies are reported in whole units rather than cents
+var bai2ZeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true, "CLP": true, "ISK": true}
+
+// bai2Balance type codes for the opening and closing ledger balances
+const (
+	bai2OpeningLedger = "010"
+	bai2ClosingLedger = "015"
+)
+
+// ParseBAI2 parses a BAI2 file into one statement per account record. The
+// bank reference of each 16 record becomes the external ID, falling back
+// to the customer reference.
+func ParseBAI2(r io.Reader) ([]*Statement, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []*Statement
+	var current *Statement
+	var groupDate time.Time
+	var groupCurrency string
+
+	for n, rec := range bai2Records(string(data)) {
+		fields := rec.fields
+		switch rec.code {
+		case "02":
+			// 02,receiver,originator,status,as-of date,as-of time,currency,modifier
+			if len(fields) < 5 {
+				return nil, fmt.Errorf("record %d: short group header", n+1)
+			}
+			groupDate, err = time.Parse("060102", fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("record %d: invalid as-of date %q", n+1, fields[4])
+			}
+			groupCurrency = ""
+			if len(fields) > 6 {
+				groupCurrency = fields[6]
+			}
+		case "03":
+			// 03,account,currency,(type,amount,item count,funds type...)*
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("record %d: short account identifier", n+1)
+			}
+			current = &Statement{
+				Format:    FormatBAI2,
+				ID:        groupDate.Format("2006-01-02"),
+				Account:   fields[1],
+				Currency:  firstReference(fieldAt(fields, 2), groupCurrency),
+				StartDate: groupDate,
+				EndDate:   groupDate,
+			}
+			rest := fields[3:]
+			for len(rest) >= 2 {
+				code := rest[0]
+				amount, err := bai2Amount(rest[1], current.Currency)
+				if err == nil && rest[1] != "" {
+					switch code {
+					case bai2OpeningLedger:
+						current.OpeningBalance = &amount
+					case bai2ClosingLedger:
+						current.ClosingBalance = &amount
+					}
+				}
+				// Summary amounts are followed by an item count, then funds type
+				if len(rest) < 4 {
+					break
+				}
+				skip, err := bai2FundsFields(rest[3:])
+				if err != nil {
+					return nil, fmt.Errorf("record %d: %w", n+1, err)
+				}
+				rest = rest[min(len(rest), 4+skip):]
+			}
+			statements = append(statements, current)
+		case "16":
+			if current == nil {
+				return nil, fmt.Errorf("record %d: transaction detail outside an account", n+1)
+			}
+			txn, err := parseBAI2Detail(fields, rec.text, current.Currency, groupDate)
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", n+1, err)
+			}
+			current.add(txn)
+		case "49":
+			current = nil
+		}
+	}
+
+	for _, s := range statements {
+		s.finish()
+	}
+	return statements, nil
+}
+
+type bai2Record struct {
+	code   string
+	fields []string
+	text   string
+}
+
+// bai2Records splits a file into logical records, joining 88 continuation
+// records. The text of a 16 record runs to the end of the record and may
+// contain commas, so it is kept apart from the fields.
+func bai2Records(data string) []bai2Record {
+	var records []bai2Record
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		code, rest, _ := strings.Cut(line, ",")
+		if code == "88" && len(records) > 0 {
+			prev := &records[len(records)-1]
+			if prev.code == "16" {
+				prev.text = strings.TrimSpace(strings.TrimSpace(prev.text) + " " + strings.TrimSuffix(rest, "/"))
+			} else {
+				prev.fields = append(prev.fields, strings.Split(strings.TrimSuffix(rest, "/"), ",")...)
+			}
+			continue
+		}
+
+		rec := bai2Record{code: code}
+		if code == "16" {
+			// 16,type,amount,funds type,[funds fields],bank ref,customer ref,text
+			parts := strings.Split(rest, ",")
+			fixed := 3
+			if len(parts) >= 3 {
+				if skip, err := bai2FundsFields(parts[2:]); err == nil {
+					fixed += skip
+				}
+			}
+			fixed += 2
+			if len(parts) > fixed {
+				rec.fields = append([]string{code}, parts[:fixed]...)
+				rec.text = strings.TrimSuffix(strings.Join(parts[fixed:], ","), "/")
+			} else {
+				rec.fields = append([]string{code}, strings.Split(strings.TrimSuffix(rest, "/"), ",")...)
+			}
+		} else {
+			rec.fields = append([]string{code}, strings.Split(strings.TrimSuffix(rest, "/"), ",")...)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// bai2FundsFields returns how many fields follow a funds type code
+func bai2FundsFields(fields []string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	switch fields[0] {
+	case "", "0", "1", "2", "Z":
+		return 0, nil
+	case "S":
+		return 3, nil
+	case "V":
+		return 2, nil
+	case "D":
+		if len(fields) < 2 {
+			return 0, fmt.Errorf("distributed funds type without a count")
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid distribution count %q", fields[1])
+		}
+		return 1 + 2*n, nil
+	}
+	return 0, fmt.Errorf("unknown funds type %q", fields[0])
+}
+
+func parseBAI2Detail(fields []string, text, currency string, asOf time.Time) (*models.Transaction, error) {
+	// fields: 16,type,amount,funds type,[funds fields],bank ref,customer ref
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("short transaction detail")
+	}
+	code := fields[1]
+	typeCode, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid type code %q", code)
+	}
+	amount, err := bai2Amount(fields[2], currency)
+	if err != nil {
+		return nil, err
+	}
+
+	skip, err := bai2FundsFields(fields[3:])
+	if err != nil {
+		return nil, err
+	}
+	valueDate := asOf
+	if fields[3] == "V" && len(fields) > 4 {
+		if v, err := time.Parse("060102", fields[4]); err == nil {
+			valueDate = v
+		}
+	}
+	refs := fields[min(len(fields), 4+skip):]
+
+	// Detail codes 100-399 are credits and 400-699 debits
+	credit := typeCode < 400
+	txn := &models.Transaction{
+		Type:        direction(credit),
+		Amount:      amount,
+		Description: strings.TrimSpace(text),
+		CreatedAt:   asOf,
+		Metadata: map[string]string{
+			MetaBankTransactionCode: code,
+			MetaBankReference:       strings.TrimSpace(fieldAt(refs, 0)),
+			MetaCustomerReference:   strings.TrimSpace(fieldAt(refs, 1)),
+			MetaValueDate:           valueDate.Format("2006-01-02"),
+		},
+	}
+	txn.SettledAt = &valueDate
+	txn.ExternalID = firstReference(fieldAt(refs, 0), fieldAt(refs, 1))
+	return txn, nil
+}
+
+// bai2Amount parses an amount in the currency's minor units
+func bai2Amount(s, currency string) (decimal.Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	n, err := decimal.NewFromString(strings.TrimPrefix(s, "+"))
+	if err != nil || !n.Equal(n.Truncate(0)) {
+		return decimal.Zero, fmt.Errorf("invalid amount %q", s)
+	}
+	if bai2ZeroDecimalCurrencies[currency] {
+		return n, nil
+	}
+	return n.Shift(-2), nil
+}
+
+func fieldAt(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Benchmarks run against synthetic batches of up to a million records on
+// each side:
+//
+//	go test ./internal/reconciliation -run '^$' -bench . -benchtime 1x -timeout 1h
+var benchmarkSizes = []int{10_000, 100_000, 1_000_000}
+
+// benchmarkBatch builds n targets spread over 90 days with their sources:
+// 60% matched by external ID, 35% by an amount and time within tolerance,
+// and 5% with no counterpart
+func benchmarkBatch(n int) (sources, targets []*models.Transaction) {
+	rng := rand.New(rand.NewSource(int64(n)))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sources = make([]*models.Transaction, n)
+	targets = make([]*models.Transaction, n)
+	for i := 0; i < n; i++ {
+		at := base.Add(time.Duration(rng.Int63n(int64(90 * 24 * time.Hour))))
+		cents := 100 + rng.Int63n(500_000)
+		target := &models.Transaction{
+			ID:        fmt.Sprintf("tgt-%d", i),
+			Type:      models.TransactionTypeDebit,
+			Amount:    decimal.New(cents, -2),
+			Currency:  "USD",
+			CreatedAt: at,
+		}
+		source := *target
+		source.ID = fmt.Sprintf("src-%d", i)
+		switch r := rng.Intn(100); {
+		case r < 60:
+			target.ExternalID = fmt.Sprintf("EXT-%d", i)
+			source.ExternalID = target.ExternalID
+		case r < 95:
+			source.Amount = decimal.New(cents+rng.Int63n(cents/200+1), -2)
+			source.CreatedAt = at.Add(time.Duration(rng.Int63n(int64(6 * time.Hour))))
+		default:
+			source.CreatedAt = at.AddDate(1, 0, 0)
+		}
+		sources[i] = &source
+		targets[i] = target
+	}
+	rng.Shuffle(n, func(i, j int) { sources[i], sources[j] = sources[j], sources[i] })
+	return sources, targets
+}
+
+var benchmarkBatches = make(map[int][2][]*models.Transaction)
+
+// cachedBenchmarkBatch builds each size once, and only when a benchmark
+// selected with -bench needs it
+func cachedBenchmarkBatch(n int) (sources, targets []*models.Transaction) {
+	if batch, ok := benchmarkBatches[n]; ok {
+		return batch[0], batch[1]
+	}
+	sources, targets = benchmarkBatch(n)
+	benchmarkBatches[n] = [2][]*models.Transaction{sources, targets}
+	return sources, targets
+}
+
+func benchmarkConfig() *config.ReconciliationConfig {
+	return &config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour}
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			_, targets := cachedBenchmarkBatch(n)
+			engine := NewEngine(benchmarkConfig())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				engine.buildIndex(targets)
+			}
+		})
+	}
+}
+
+func BenchmarkFindCandidates(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			sources, targets := cachedBenchmarkBatch(n)
+			engine := NewEngine(benchmarkConfig())
+			index := engine.buildIndex(targets)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				engine.findCandidates(sources[i%n], index)
+			}
+		})
+	}
+}
+
+func BenchmarkReconcileStream(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		workerCounts := []int{1}
+		if procs := runtime.GOMAXPROCS(0); procs > 1 {
+			workerCounts = append(workerCounts, procs)
+		}
+		for _, workers := range workerCounts {
+			b.Run(fmt.Sprintf("%dx%d/workers=%d", n, n, workers), func(b *testing.B) {
+				sources, targets := cachedBenchmarkBatch(n)
+				b.ResetTimer()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					engine := NewEngine(benchmarkConfig())
+					batch := engine.CreateBatch("source", "target")
+					b.StartTimer()
+
+					err := engine.ReconcileStream(context.Background(), batch.ID, SliceStream(sources), targets, StreamOptions{Workers: workers})
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.ReportMetric(float64(batch.MatchedRecords)/float64(n), "match-rate")
+				}
+				b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "records/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package reconciliation
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// ISO 20022 camt.053 bank-to-customer statements and camt.052 account
+// reports. Element names are matched without namespaces so every message
+// version decodes with the same structures.
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Reports    []camtStatement `xml:"BkToCstmrAcctRpt>Rpt"`
+}
+
+type camtStatement struct {
+	ID      string `xml:"Id"`
+	Account struct {
+		IBAN     string `xml:"Id>IBAN"`
+		Other    string `xml:"Id>Othr>Id"`
+		Currency string `xml:"Ccy"`
+	} `xml:"Acct"`
+	Balances []struct {
+		Type   string     `xml:"Tp>CdOrPrtry>Cd"`
+		Amount camtAmount `xml:"Amt"`
+		Mark   string     `xml:"CdtDbtInd"`
+	} `xml:"Bal"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// camtStatus holds the entry status, a plain code before version 8 and a
+// Cd element after it
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+type camtEntry struct {
+	Reference   string                  `xml:"NtryRef"`
+	Amount      camtAmount              `xml:"Amt"`
+	Mark        string                  `xml:"CdtDbtInd"`
+	Reversal    bool                    `xml:"RvslInd"`
+	Status      camtStatus              `xml:"Sts"`
+	BookingDate camtDate                `xml:"BookgDt"`
+	ValueDate   camtDate                `xml:"ValDt"`
+	ServicerRef string                  `xml:"AcctSvcrRef"`
+	BankCode    camtBankTransactionCode `xml:"BkTxCd"`
+	Details     []camtTransactionDetail `xml:"NtryDtls>TxDtls"`
+	Info        string                  `xml:"AddtlNtryInf"`
+}
+
+type camtBankTransactionCode struct {
+	Domain      string `xml:"Domn>Cd"`
+	Family      string `xml:"Domn>Fmly>Cd"`
+	SubFamily   string `xml:"Domn>Fmly>SubFmlyCd"`
+	Proprietary string `xml:"Prtry>Cd"`
+}
+
+func (c camtBankTransactionCode) String() string {
+	if c.Domain != "" {
+		return strings.Trim(c.Domain+"/"+c.Family+"/"+c.SubFamily, "/")
+	}
+	return c.Proprietary
+}
+
+type camtTransactionDetail struct {
+	Refs struct {
+		ServicerRef string `xml:"AcctSvcrRef"`
+		EndToEndID  string `xml:"EndToEndId"`
+		TxID        string `xml:"TxId"`
+	} `xml:"Refs"`
+	Amount            *camtAmount `xml:"Amt"`
+	TransactionAmount *camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+	Mark              string      `xml:"CdtDbtInd"`
+	Debtor            string      `xml:"RltdPties>Dbtr>Nm"`
+	DebtorParty       string      `xml:"RltdPties>Dbtr>Pty>Nm"`
+	DebtorIBAN        string      `xml:"RltdPties>DbtrAcct>Id>IBAN"`
+	Creditor          string      `xml:"RltdPties>Cdtr>Nm"`
+	CreditorParty     string      `xml:"RltdPties>Cdtr>Pty>Nm"`
+	CreditorIBAN      string      `xml:"RltdPties>CdtrAcct>Id>IBAN"`
+	Unstructured      []string    `xml:"RmtInf>Ustrd"`
+	CreditorRef       string      `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	Info              string      `xml:"AddtlTxInf"`
+}
+
+func (d camtTransactionDetail) amount() *camtAmount {
+	if d.Amount != nil {
+		return d.Amount
+	}
+	return d.TransactionAmount
+}
+
+// ParseCAMT parses a camt.053 statement or camt.052 report. Batched entries
+// whose transaction details all carry amounts are split into one
+// transaction per detail. The servicer's reference becomes the external ID,
+// falling back to the end-to-end ID.
+func ParseCAMT(r io.Reader) ([]*Statement, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc camtDocument
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid camt document: %w", err)
+	}
+
+	var statements []*Statement
+	for _, group := range []struct {
+		format     string
+		statements []camtStatement
+	}{{FormatCAMT053, doc.Statements}, {FormatCAMT052, doc.Reports}} {
+		for _, cs := range group.statements {
+			s, err := parseCAMTStatement(group.format, cs)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, s)
+		}
+	}
+	return statements, nil
+}
+
+func parseCAMTStatement(format string, cs camtStatement) (*Statement, error) {
+	s := &Statement{
+		Format:   format,
+		ID:       strings.TrimSpace(cs.ID),
+		Account:  strings.TrimSpace(cs.Account.IBAN),
+		Currency: cs.Account.Currency,
+	}
+	if s.Account == "" {
+		s.Account = strings.TrimSpace(cs.Account.Other)
+	}
+	if s.Account == "" {
+		return nil, fmt.Errorf("statement %s: missing account", s.ID)
+	}
+
+	for _, b := range cs.Balances {
+		amount, err := parseStatementAmount(b.Amount.Value)
+		if err != nil {
+			return nil, fmt.Errorf("statement %s: balance %s: %w", s.ID, b.Type, err)
+		}
+		if s.Currency == "" {
+			s.Currency = b.Amount.Currency
+		}
+		switch b.Type {
+		case "OPBD", "PRCD", "ITBD":
+			if s.OpeningBalance == nil {
+				s.OpeningBalance = signed(amount, b.Mark == "CRDT")
+			}
+		case "CLBD":
+			s.ClosingBalance = signed(amount, b.Mark == "CRDT")
+		}
+	}
+
+	for i, e := range cs.Entries {
+		txns, err := parseCAMTEntry(e)
+		if err != nil {
+			return nil, fmt.Errorf("statement %s: entry %d: %w", s.ID, i+1, err)
+		}
+		for _, txn := range txns {
+			s.add(txn)
+		}
+	}
+	s.finish()
+	return s, nil
+}
+
+func parseCAMTEntry(e camtEntry) ([]*models.Transaction, error) {
+	amount, err := parseStatementAmount(e.Amount.Value)
+	if err != nil {
+		return nil, err
+	}
+	booked, err := parseCAMTDate(e.BookingDate)
+	if err != nil {
+		return nil, fmt.Errorf("booking date: %w", err)
+	}
+	valued, err := parseCAMTDate(e.ValueDate)
+	if err != nil {
+		return nil, fmt.Errorf("value date: %w", err)
+	}
+	if booked.IsZero() {
+		booked = valued
+	}
+
+	status := models.TransactionStatusCompleted
+	switch strings.TrimSpace(e.Status.Text) + e.Status.Code {
+	case "PDNG", "INFO":
+		status = models.TransactionStatusPending
+	}
+
+	base := func(amount decimal.Decimal, currency, mark string) *models.Transaction {
+		txn := &models.Transaction{
+			Type:        direction(mark == "CRDT"),
+			Status:      status,
+			Amount:      amount,
+			Currency:    currency,
+			Description: strings.TrimSpace(e.Info),
+			CreatedAt:   booked,
+			Metadata: map[string]string{
+				MetaBankTransactionCode: e.BankCode.String(),
+				MetaBankReference:       strings.TrimSpace(e.ServicerRef),
+			},
+		}
+		if !valued.IsZero() {
+			v := valued
+			txn.SettledAt = &v
+			txn.Metadata[MetaValueDate] = valued.Format("2006-01-02")
+		}
+		if e.Reversal {
+			txn.Metadata[MetaReversal] = "true"
+		}
+		return txn
+	}
+
+	// Split batches when every detail says how much it contributed
+	split := len(e.Details) > 1
+	for _, d := range e.Details {
+		split = split && d.amount() != nil
+	}
+	if !split {
+		txn := base(amount, e.Amount.Currency, e.Mark)
+		var detail camtTransactionDetail
+		if len(e.Details) == 1 {
+			detail = e.Details[0]
+		}
+		applyCAMTDetail(txn, detail, e.Mark)
+		txn.ExternalID = firstReference(e.ServicerRef, detail.Refs.ServicerRef, detail.Refs.EndToEndID, detail.Refs.TxID, e.Reference)
+		return []*models.Transaction{txn}, nil
+	}
+
+	txns := make([]*models.Transaction, 0, len(e.Details))
+	for _, d := range e.Details {
+		a := d.amount()
+		value, err := parseStatementAmount(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		mark := e.Mark
+		if d.Mark != "" {
+			mark = d.Mark
+		}
+		txn := base(value, a.Currency, mark)
+		applyCAMTDetail(txn, d, mark)
+		txn.ExternalID = firstReference(d.Refs.ServicerRef, d.Refs.EndToEndID, d.Refs.TxID)
+		if txn.ExternalID == "" {
+			txn.ExternalID = firstReference(e.ServicerRef)
+		}
+		txns = append(txns, txn)
+	}
+	return txns, nil
+}
+
+// applyCAMTDetail records remittance information and the counterparty,
+// which is the debtor of a credit and the creditor of a debit
+func applyCAMTDetail(txn *models.Transaction, d camtTransactionDetail, mark string) {
+	remittance := strings.TrimSpace(strings.Join(d.Unstructured, " "))
+	if remittance == "" {
+		remittance = strings.TrimSpace(d.CreditorRef)
+	}
+	if remittance == "" {
+		remittance = strings.TrimSpace(d.Info)
+	}
+	if remittance != "" {
+		txn.Description = remittance
+	}
+
+	name, account := firstReference(d.Creditor, d.CreditorParty), d.CreditorIBAN
+	if mark == "CRDT" {
+		name, account = firstReference(d.Debtor, d.DebtorParty), d.DebtorIBAN
+	}
+	txn.Metadata[MetaCounterparty] = name
+	txn.Metadata[MetaCounterpartyAccount] = strings.TrimSpace(account)
+	txn.Metadata[MetaCustomerReference] = firstReference(d.Refs.EndToEndID)
+	txn.DestAccount = strings.TrimSpace(account)
+}
+
+func parseCAMTDate(d camtDate) (time.Time, error) {
+	if v := strings.TrimSpace(d.DateTime); v != "" {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid date time %q", v)
+	}
+	if v := strings.TrimSpace(d.Date); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", v)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,106 @@
+package reconciliation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Checkpoint records the progress of a streaming reconciliation so a batch
+// can resume after a crash. It covers the first Processed source records
+// in stream order; records after them are matched again on resume.
+type Checkpoint struct {
+	BatchID    string                       `json:"batch_id"`
+	Source     string                       `json:"source"`
+	Target     string                       `json:"target"`
+	StartedAt  time.Time                    `json:"started_at"`
+	Processed  int                          `json:"processed"`
+	Matched    int                          `json:"matched"`
+	Claimed    []string                     `json:"claimed"`
+	Unmatched  []*models.Transaction        `json:"unmatched,omitempty"`
+	Exceptions []*models.ReconcileException `json:"exceptions,omitempty"`
+	UpdatedAt  time.Time                    `json:"updated_at"`
+}
+
+// CheckpointStore persists reconciliation checkpoints
+type CheckpointStore interface {
+	Save(cp *Checkpoint) error
+	Load(batchID string) (*Checkpoint, bool, error)
+	Delete(batchID string) error
+}
+
+// FileCheckpointStore keeps one JSON checkpoint file per batch in a
+// directory
+type FileCheckpointStore struct {
+	dir string
+}
+
+var checkpointNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// NewFileCheckpointStore creates a checkpoint store in dir, creating the
+// directory if needed
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create checkpoint directory: %w", err)
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(batchID string) string {
+	return filepath.Join(s.dir, checkpointNamePattern.ReplaceAllString(batchID, "_")+".json")
+}
+
+// Save writes a checkpoint, replacing the previous one atomically
+func (s *FileCheckpointStore) Save(cp *Checkpoint) error {
+	f, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(cp); err != nil {
+		f.Close()
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path(cp.BatchID)); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load reads the checkpoint of a batch, if there is one
+func (s *FileCheckpointStore) Load(batchID string) (*Checkpoint, bool, error) {
+	data, err := os.ReadFile(s.path(batchID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("load checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, false, fmt.Errorf("load checkpoint %s: %w", batchID, err)
+	}
+	return &cp, true, nil
+}
+
+// Delete removes the checkpoint of a batch
+func (s *FileCheckpointStore) Delete(batchID string) error {
+	if err := os.Remove(s.path(batchID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete checkpoint: %w", err)
+	}
+	return nil
+}
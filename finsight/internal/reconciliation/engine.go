@@ -2,10 +2,15 @@ package reconciliation
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/savegress/finsight/internal/approval"
 	"github.com/savegress/finsight/internal/config"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
@@ -13,13 +18,17 @@ import (
 
 // Engine handles transaction reconciliation
 type Engine struct {
-	config     *config.ReconciliationConfig
-	batches    map[string]*models.ReconciliationBatch
-	exceptions map[string]*models.ReconcileException
-	matchers   []Matcher
-	mu         sync.RWMutex
-	running    bool
-	stopCh     chan struct{}
+	config        *config.ReconciliationConfig
+	batches       map[string]*models.ReconciliationBatch
+	exceptions    map[string]*models.ReconcileException
+	matchers      []Matcher
+	groupMatchers []GroupMatcher
+	groups        map[string][]*CompositeMatch
+	checkpoints   CheckpointStore
+	approvals     *approval.Workflow
+	mu            sync.RWMutex
+	running       bool
+	stopCh        chan struct{}
 }
 
 // Matcher defines a matching strategy
@@ -50,9 +59,18 @@ func NewEngine(cfg *config.ReconciliationConfig) *Engine {
 		config:     cfg,
 		batches:    make(map[string]*models.ReconciliationBatch),
 		exceptions: make(map[string]*models.ReconcileException),
+		groups:     make(map[string][]*CompositeMatch),
 		stopCh:     make(chan struct{}),
 	}
 	e.initializeMatchers()
+	if cfg.CheckpointDir != "" {
+		store, err := NewFileCheckpointStore(cfg.CheckpointDir)
+		if err != nil {
+			log.Printf("reconciliation: checkpoints disabled: %v", err)
+		} else {
+			e.checkpoints = store
+		}
+	}
 	return e
 }
 
@@ -62,6 +80,9 @@ func (e *Engine) initializeMatchers() {
 		NewFuzzyMatcher(e.config.MatchTolerance, e.config.DateTolerance),
 		NewReferenceIDMatcher(),
 	}
+	if e.config.GroupMatching.Enabled {
+		e.groupMatchers = []GroupMatcher{NewSubsetSumMatcher(e.config.GroupMatching)}
+	}
 }
 
 // Start starts the reconciliation engine
@@ -106,157 +127,18 @@ func (e *Engine) CreateBatch(source, target string) *models.ReconciliationBatch
 
 // Reconcile performs reconciliation between two sets of transactions
 func (e *Engine) Reconcile(ctx context.Context, batchID string, sourceTransactions, targetTransactions []*models.Transaction) error {
-	e.mu.Lock()
-	batch, ok := e.batches[batchID]
-	if !ok {
-		e.mu.Unlock()
-		return ErrBatchNotFound
-	}
-	batch.Status = models.BatchStatusRunning
-	batch.TotalRecords = len(sourceTransactions)
-	e.mu.Unlock()
-
-	// Index target transactions for faster lookup
-	targetIndex := e.buildIndex(targetTransactions)
-
-	// Process source transactions
-	for _, sourceTxn := range sourceTransactions {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		matched := false
-		var bestMatch *models.Transaction
-		var bestResult *MatchResult
-
-		// Try to find a match using all matchers
-		candidates := e.findCandidates(sourceTxn, targetIndex)
-
-		for _, matcher := range e.matchers {
-			for _, candidate := range candidates {
-				result := matcher.Match(sourceTxn, candidate)
-				if result.Matched {
-					if bestResult == nil || result.Confidence > bestResult.Confidence {
-						bestResult = result
-						bestMatch = candidate
-					}
-				}
-			}
-		}
-
-		if bestResult != nil && bestResult.Matched {
-			matched = true
-			sourceTxn.ReconcileStatus = models.ReconcileStatusMatched
-
-			// Check for differences
-			if len(bestResult.Differences) > 0 {
-				for _, diff := range bestResult.Differences {
-					if diff.Severity == "error" {
-						e.createException(batchID, models.ExceptionTypeAmountDiff, sourceTxn, bestMatch, diff)
-					}
-				}
-			}
-
-			// Remove matched target from index
-			delete(targetIndex.byID, bestMatch.ID)
-			delete(targetIndex.byExternalID, bestMatch.ExternalID)
-		}
-
-		if !matched {
-			sourceTxn.ReconcileStatus = models.ReconcileStatusUnmatched
-			e.createException(batchID, models.ExceptionTypeMissing, sourceTxn, nil, Difference{
-				Field:    "record",
-				Source:   sourceTxn.ID,
-				Severity: "error",
-			})
-		}
-
-		e.updateBatchProgress(batchID, matched)
-	}
-
-	// Check for unmatched target transactions
-	for _, targetTxn := range targetIndex.byID {
-		e.createException(batchID, models.ExceptionTypeMissing, nil, targetTxn, Difference{
-			Field:    "record",
-			Target:   targetTxn.ID,
-			Severity: "error",
-		})
-	}
-
-	// Complete batch
-	e.completeBatch(batchID)
-
-	return nil
-}
-
-// TransactionIndex indexes transactions for efficient lookup
-type TransactionIndex struct {
-	byID         map[string]*models.Transaction
-	byExternalID map[string]*models.Transaction
-	byAmount     map[string][]*models.Transaction
-	byDate       map[string][]*models.Transaction
-}
-
-func (e *Engine) buildIndex(transactions []*models.Transaction) *TransactionIndex {
-	index := &TransactionIndex{
-		byID:         make(map[string]*models.Transaction),
-		byExternalID: make(map[string]*models.Transaction),
-		byAmount:     make(map[string][]*models.Transaction),
-		byDate:       make(map[string][]*models.Transaction),
-	}
-
-	for _, txn := range transactions {
-		index.byID[txn.ID] = txn
-		if txn.ExternalID != "" {
-			index.byExternalID[txn.ExternalID] = txn
-		}
-
-		amountKey := txn.Amount.String()
-		index.byAmount[amountKey] = append(index.byAmount[amountKey], txn)
-
-		dateKey := txn.CreatedAt.Format("2006-01-02")
-		index.byDate[dateKey] = append(index.byDate[dateKey], txn)
-	}
-
-	return index
-}
-
-func (e *Engine) findCandidates(source *models.Transaction, index *TransactionIndex) []*models.Transaction {
-	var candidates []*models.Transaction
-	seen := make(map[string]bool)
-
-	// First check by external ID
-	if source.ExternalID != "" {
-		if txn, ok := index.byExternalID[source.ExternalID]; ok {
-			candidates = append(candidates, txn)
-			seen[txn.ID] = true
-		}
-	}
-
-	// Then check by amount
-	amountKey := source.Amount.String()
-	for _, txn := range index.byAmount[amountKey] {
-		if !seen[txn.ID] {
-			candidates = append(candidates, txn)
-			seen[txn.ID] = true
-		}
-	}
-
-	// Then check by date
-	dateKey := source.CreatedAt.Format("2006-01-02")
-	for _, txn := range index.byDate[dateKey] {
-		if !seen[txn.ID] {
-			candidates = append(candidates, txn)
-			seen[txn.ID] = true
-		}
-	}
-
-	return candidates
+	workers := e.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return e.ReconcileStream(ctx, batchID, SliceStream(sourceTransactions), targetTransactions, StreamOptions{
+		Workers:         workers,
+		CheckpointEvery: e.config.CheckpointEvery,
+		Checkpoints:     e.checkpoints,
+	})
 }
 
-func (e *Engine) createException(batchID string, exType models.ExceptionType, source, target *models.Transaction, diff Difference) {
+func (e *Engine) createException(batchID string, exType models.ExceptionType, source, target *models.Transaction, diff Difference) *models.ReconcileException {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -286,6 +168,7 @@ func (e *Engine) createException(batchID string, exType models.ExceptionType, so
 	if batch, ok := e.batches[batchID]; ok {
 		batch.Exceptions++
 	}
+	return exception
 }
 
 func (e *Engine) updateBatchProgress(batchID string, matched bool) {
@@ -329,6 +212,12 @@ func (e *Engine) completeBatch(batchID string) {
 			if exc.TargetRecord != nil {
 				targetTotal = targetTotal.Add(exc.TargetRecord.Amount)
 			}
+			for _, txn := range exc.SourceRecords {
+				sourceTotal = sourceTotal.Add(txn.Amount)
+			}
+			for _, txn := range exc.TargetRecords {
+				targetTotal = targetTotal.Add(txn.Amount)
+			}
 		}
 	}
 
@@ -417,7 +306,29 @@ func (e *Engine) GetExceptions(batchID string) []*models.ReconcileException {
 	return results
 }
 
-// ResolveException resolves an exception
+// GetException retrieves an exception by ID
+func (e *Engine) GetException(id string) (*models.ReconcileException, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	exc, ok := e.exceptions[id]
+	return exc, ok
+}
+
+// ApprovalActionWriteOff is the approval action of exception write-offs
+const ApprovalActionWriteOff = "reconciliation_write_off"
+
+// SetApprovals routes write-offs through a maker-checker workflow: a
+// requested write-off is applied once its policy's approvers have approved
+// it, and the exception reopens when it is rejected
+func (e *Engine) SetApprovals(w *approval.Workflow) {
+	e.mu.Lock()
+	e.approvals = w
+	e.mu.Unlock()
+	w.Register(ApprovalActionWriteOff, e.applyWriteOffApproval, e.applyWriteOffRejection)
+}
+
+// ResolveException resolves an exception. With an approval workflow set,
+// write-offs must be requested with RequestWriteOff instead.
 func (e *Engine) ResolveException(id string, resolution string, writeOff bool) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -426,6 +337,12 @@ func (e *Engine) ResolveException(id string, resolution string, writeOff bool) e
 	if !ok {
 		return ErrExceptionNotFound
 	}
+	if exc.Status == models.ExceptionStatusPendingApproval {
+		return ErrApprovalPending
+	}
+	if writeOff && e.approvals != nil {
+		return ErrApprovalRequired
+	}
 
 	now := time.Now()
 	exc.Resolution = resolution
@@ -440,6 +357,122 @@ func (e *Engine) ResolveException(id string, resolution string, writeOff bool) e
 	return nil
 }
 
+// RequestWriteOff puts the write-off of an open exception up for approval
+// by someone other than the preparer
+func (e *Engine) RequestWriteOff(id, resolution, preparedBy string) error {
+	if preparedBy == "" {
+		return approval.ErrPreparerRequired
+	}
+
+	e.mu.Lock()
+	exc, ok := e.exceptions[id]
+	if !ok {
+		e.mu.Unlock()
+		return ErrExceptionNotFound
+	}
+	if exc.Status != models.ExceptionStatusOpen {
+		e.mu.Unlock()
+		return ErrExceptionNotOpen
+	}
+	exc.Status = models.ExceptionStatusPendingApproval
+	exc.Resolution = resolution
+	exc.PreparedBy = preparedBy
+	approvals := e.approvals
+	summary := fmt.Sprintf("Write off %s exception %s", exc.Type, exc.ID)
+	details := map[string]interface{}{
+		"batch_id":    exc.BatchID,
+		"type":        string(exc.Type),
+		"amount_diff": exc.AmountDiff.String(),
+		"resolution":  resolution,
+	}
+	e.mu.Unlock()
+
+	if approvals == nil {
+		return nil
+	}
+	// The workflow calls back into the engine, so it is opened unlocked
+	if _, err := approvals.Open(ApprovalActionWriteOff, id, preparedBy, summary, details); err != nil {
+		e.mu.Lock()
+		exc.Status = models.ExceptionStatusOpen
+		exc.Resolution = ""
+		exc.PreparedBy = ""
+		e.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ApproveWriteOff records an approver's approval of a requested write-off.
+// The preparer cannot approve their own write-off. With an approval
+// workflow set, the write-off is applied once its policy is satisfied.
+func (e *Engine) ApproveWriteOff(id, approver string) error {
+	e.mu.Lock()
+	exc, ok := e.exceptions[id]
+	if !ok {
+		e.mu.Unlock()
+		return ErrExceptionNotFound
+	}
+	if exc.Status != models.ExceptionStatusPendingApproval {
+		e.mu.Unlock()
+		return ErrNoWriteOffRequested
+	}
+	approvals := e.approvals
+	if approvals == nil {
+		defer e.mu.Unlock()
+		if approver == "" {
+			return approval.ErrApproverRequired
+		}
+		if approver == exc.PreparedBy {
+			return approval.ErrSelfApproval
+		}
+		writeOff(exc, approver)
+		return nil
+	}
+	e.mu.Unlock()
+
+	_, err := approvals.ApproveEntity(ApprovalActionWriteOff, id, approver, "")
+	return err
+}
+
+func (e *Engine) applyWriteOffApproval(req *approval.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exc, ok := e.exceptions[req.EntityID]
+	if !ok {
+		return ErrExceptionNotFound
+	}
+	if exc.Status != models.ExceptionStatusPendingApproval {
+		return ErrNoWriteOffRequested
+	}
+	writeOff(exc, strings.Join(req.Approvers(), ", "))
+	return nil
+}
+
+// applyWriteOffRejection reopens an exception whose write-off was rejected
+func (e *Engine) applyWriteOffRejection(req *approval.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exc, ok := e.exceptions[req.EntityID]
+	if !ok {
+		return ErrExceptionNotFound
+	}
+	if exc.Status == models.ExceptionStatusPendingApproval {
+		exc.Status = models.ExceptionStatusOpen
+		exc.Resolution = ""
+		exc.PreparedBy = ""
+	}
+	return nil
+}
+
+func writeOff(exc *models.ReconcileException, approvedBy string) {
+	now := time.Now()
+	exc.Status = models.ExceptionStatusWriteOff
+	exc.ApprovedBy = approvedBy
+	exc.ResolvedAt = &now
+}
+
 // GetStats returns reconciliation statistics
 func (e *Engine) GetStats() *ReconcileStats {
 	e.mu.RLock()
@@ -481,14 +514,21 @@ func generateBatchID() string {
 	return "batch-" + time.Now().Format("20060102150405")
 }
 
+// exceptionSeq keeps exception IDs created in the same millisecond apart
+var exceptionSeq atomic.Uint64
+
 func generateExceptionID() string {
-	return "exc-" + time.Now().Format("20060102150405.000")
+	return fmt.Sprintf("exc-%s-%d", time.Now().Format("20060102150405.000"), exceptionSeq.Add(1))
 }
 
 // Errors
 var (
-	ErrBatchNotFound     = &Error{Code: "BATCH_NOT_FOUND", Message: "Batch not found"}
-	ErrExceptionNotFound = &Error{Code: "EXCEPTION_NOT_FOUND", Message: "Exception not found"}
+	ErrBatchNotFound       = &Error{Code: "BATCH_NOT_FOUND", Message: "Batch not found"}
+	ErrExceptionNotFound   = &Error{Code: "EXCEPTION_NOT_FOUND", Message: "Exception not found"}
+	ErrExceptionNotOpen    = &Error{Code: "EXCEPTION_NOT_OPEN", Message: "Exception is not open"}
+	ErrApprovalRequired    = &Error{Code: "APPROVAL_REQUIRED", Message: "Write-offs must be requested for approval"}
+	ErrApprovalPending     = &Error{Code: "APPROVAL_PENDING", Message: "Exception has a write-off awaiting approval"}
+	ErrNoWriteOffRequested = &Error{Code: "NO_WRITE_OFF_REQUESTED", Message: "No write-off of the exception awaits approval"}
 )
 
 // Error represents a reconciliation error
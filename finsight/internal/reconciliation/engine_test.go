@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/savegress/finsight/internal/approval"
 	"github.com/savegress/finsight/internal/config"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
@@ -597,16 +598,17 @@ func TestBuildIndex(t *testing.T) {
 		t.Errorf("expected 2 entries in byExternalID, got %d", len(index.byExternalID))
 	}
 
-	// Check by amount (two with same amount)
-	amountKey := decimal.NewFromFloat(100).String()
-	if len(index.byAmount[amountKey]) != 2 {
-		t.Errorf("expected 2 entries with amount 100, got %d", len(index.byAmount[amountKey]))
+	// Check sorted by amount, then time
+	if len(index.entries) != 3 || index.entries[2].txn.ID != "txn-3" {
+		t.Errorf("expected entries sorted by amount, got %d entries", len(index.entries))
+	}
+	if index.entries[0].amount != 10000 {
+		t.Errorf("expected amount in minor units, got %d", index.entries[0].amount)
 	}
 
-	// Check by date
-	dateKey := now.Format("2006-01-02")
-	if len(index.byDate[dateKey]) != 2 {
-		t.Errorf("expected 2 entries for today's date, got %d", len(index.byDate[dateKey]))
+	// Check by time
+	if len(index.byTime) != 3 || index.byTime[2].txn.ID != "txn-3" {
+		t.Error("expected txn-3 last in time order")
 	}
 }
 
@@ -757,10 +759,8 @@ func TestDifference(t *testing.T) {
 
 func TestTransactionIndex(t *testing.T) {
 	index := &TransactionIndex{
-		byID:         make(map[string]*models.Transaction),
-		byExternalID: make(map[string]*models.Transaction),
-		byAmount:     make(map[string][]*models.Transaction),
-		byDate:       make(map[string][]*models.Transaction),
+		byID:         make(map[string]*indexEntry),
+		byExternalID: make(map[string]*indexEntry),
 	}
 
 	if index.byID == nil {
@@ -918,3 +918,54 @@ func TestEngine_CreateException_TargetOnly(t *testing.T) {
 		t.Errorf("expected amount diff 200, got %s", exc.AmountDiff)
 	}
 }
+
+func TestEngine_RequestWriteOff(t *testing.T) {
+	engine := NewEngine(&config.ReconciliationConfig{MatchTolerance: 0.01})
+	w := approval.NewWorkflow(&config.ApprovalConfig{
+		Policies: map[string]config.ApprovalPolicy{
+			ApprovalActionWriteOff: {Roles: []string{"finance_manager"}, Approvers: 1},
+		},
+		Roles: map[string][]string{"manager": {"finance_manager"}},
+	})
+	engine.SetApprovals(w)
+
+	exc := &models.ReconcileException{ID: "exc-1", BatchID: "batch-1", Status: models.ExceptionStatusOpen}
+	engine.exceptions[exc.ID] = exc
+
+	if err := engine.ResolveException("exc-1", "Timing", true); err != ErrApprovalRequired {
+		t.Errorf("expected ErrApprovalRequired, got %v", err)
+	}
+	if err := engine.RequestWriteOff("exc-1", "Timing", ""); err != approval.ErrPreparerRequired {
+		t.Errorf("expected ErrPreparerRequired, got %v", err)
+	}
+	if err := engine.RequestWriteOff("exc-1", "Timing", "clerk"); err != nil {
+		t.Fatalf("RequestWriteOff failed: %v", err)
+	}
+	if exc.Status != models.ExceptionStatusPendingApproval || exc.PreparedBy != "clerk" {
+		t.Errorf("unexpected exception: %+v", exc)
+	}
+	if err := engine.ResolveException("exc-1", "Fixed", false); err != ErrApprovalPending {
+		t.Errorf("expected ErrApprovalPending, got %v", err)
+	}
+	if err := engine.ApproveWriteOff("exc-1", "clerk"); err != approval.ErrSelfApproval {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+	if err := engine.ApproveWriteOff("exc-1", "manager"); err != nil {
+		t.Fatalf("ApproveWriteOff failed: %v", err)
+	}
+	if exc.Status != models.ExceptionStatusWriteOff || exc.ApprovedBy != "manager" || exc.ResolvedAt == nil {
+		t.Errorf("unexpected exception after approval: %+v", exc)
+	}
+
+	// A rejected write-off reopens the exception
+	other := &models.ReconcileException{ID: "exc-2", BatchID: "batch-1", Status: models.ExceptionStatusOpen}
+	engine.exceptions[other.ID] = other
+	engine.RequestWriteOff("exc-2", "Timing", "clerk")
+	req, _ := w.OpenRequest(ApprovalActionWriteOff, "exc-2")
+	if _, err := w.Reject(req.ID, "manager", "investigate first"); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if other.Status != models.ExceptionStatusOpen || other.Resolution != "" {
+		t.Errorf("unexpected exception after rejection: %+v", other)
+	}
+}
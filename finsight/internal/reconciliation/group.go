@@ -0,0 +1,470 @@
+package reconciliation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// GroupMatcher matches a single aggregated record against a group of
+// records on the other side, such as a bank deposit settling a day of card
+// transactions
+type GroupMatcher interface {
+	Name() string
+	MatchGroup(aggregate *models.Transaction, candidates []*models.Transaction) *GroupMatchResult
+}
+
+// GroupMatchResult contains the result of a group match attempt. Residual
+// is the part of the aggregate the group does not account for after fees;
+// it is negative when the group exceeds the aggregate.
+type GroupMatchResult struct {
+	Matched    bool
+	Partial    bool
+	Confidence float64
+	MatchType  string
+	Members    []*models.Transaction
+	Gross      decimal.Decimal
+	Fee        decimal.Decimal
+	Residual   decimal.Decimal
+}
+
+// MetaSettlementID is the metadata key of the payout or settlement a record
+// was paid out in, tying card transactions to the deposit that settled them
+const MetaSettlementID = "settlement_id"
+
+// maxGroupSearchNodes bounds the subset search for a single aggregate
+const maxGroupSearchNodes = 200000
+
+// SubsetSumMatcher finds the group of candidates whose sum, less an
+// allowed fee, equals the aggregate amount. Candidates sharing the
+// aggregate's settlement reference form the group outright, and are
+// reported as a partial match when their amounts do not add up.
+type SubsetSumMatcher struct {
+	dateWindow    time.Duration
+	maxGroupSize  int
+	maxCandidates int
+	feeRules      []config.FeeRule
+}
+
+// NewSubsetSumMatcher creates a new subset-sum group matcher
+func NewSubsetSumMatcher(cfg config.GroupMatchConfig) *SubsetSumMatcher {
+	m := &SubsetSumMatcher{
+		dateWindow:    cfg.DateWindow,
+		maxGroupSize:  cfg.MaxGroupSize,
+		maxCandidates: cfg.MaxCandidates,
+		feeRules:      cfg.FeeRules,
+	}
+	if m.dateWindow <= 0 {
+		m.dateWindow = 72 * time.Hour
+	}
+	if m.maxGroupSize <= 1 {
+		m.maxGroupSize = 20
+	}
+	if m.maxCandidates <= 0 {
+		m.maxCandidates = 40
+	}
+	return m
+}
+
+func (m *SubsetSumMatcher) Name() string { return "subset_sum" }
+
+// allowedFee returns the largest fee the fee rules allow for a group
+func (m *SubsetSumMatcher) allowedFee(currency string, gross decimal.Decimal, n int) decimal.Decimal {
+	for _, rule := range m.feeRules {
+		if rule.Currency != "" && !strings.EqualFold(rule.Currency, currency) {
+			continue
+		}
+		return gross.Mul(decimal.NewFromFloat(rule.Rate)).
+			Add(decimal.NewFromFloat(rule.PerItem).Mul(decimal.NewFromInt(int64(n)))).
+			Add(decimal.NewFromFloat(rule.Fixed))
+	}
+	return decimal.Zero
+}
+
+func (m *SubsetSumMatcher) MatchGroup(aggregate *models.Transaction, candidates []*models.Transaction) *GroupMatchResult {
+	result := &GroupMatchResult{MatchType: "subset_sum"}
+	net := aggregate.Amount
+	if !net.IsPositive() {
+		return result
+	}
+
+	var referenced, pool []*models.Transaction
+	for _, c := range candidates {
+		if c.ID == aggregate.ID || !c.Amount.IsPositive() || c.Type != aggregate.Type {
+			continue
+		}
+		if c.Currency != "" && aggregate.Currency != "" && c.Currency != aggregate.Currency {
+			continue
+		}
+		if sharesSettlement(aggregate, c) {
+			referenced = append(referenced, c)
+			continue
+		}
+		if absDuration(c.CreatedAt.Sub(aggregate.CreatedAt)) <= m.dateWindow {
+			pool = append(pool, c)
+		}
+	}
+
+	// Records carrying the aggregate's settlement reference are the group,
+	// whether or not their amounts add up
+	if len(referenced) >= 2 {
+		var gross decimal.Decimal
+		for _, c := range referenced {
+			gross = gross.Add(c.Amount)
+		}
+		c := m.evaluate(aggregate.Currency, net, gross, len(referenced))
+		result.MatchType = "settlement_reference"
+		result.Members = referenced
+		result.Gross = gross
+		result.Fee = c.fee
+		result.Residual = net.Sub(gross.Sub(c.fee))
+		if c.discrepancy.IsZero() {
+			result.Matched = true
+			result.Confidence = 0.95
+		} else {
+			result.Partial = true
+			result.Confidence = 0.5
+		}
+		return result
+	}
+
+	// Without a reference only an exact fit is trusted: close enough sums
+	// turn up by chance among enough candidates
+	var total decimal.Decimal
+	for _, c := range pool {
+		total = total.Add(c.Amount)
+	}
+	maxFee := m.allowedFee(aggregate.Currency, total, m.maxGroupSize)
+	ceiling := net.Add(maxFee)
+	kept := pool[:0]
+	for _, c := range pool {
+		if c.Amount.LessThanOrEqual(ceiling) {
+			kept = append(kept, c)
+		}
+	}
+	pool = kept
+	if len(pool) < 2 {
+		return result
+	}
+
+	// Keep the candidates closest in time, then search largest first
+	sort.SliceStable(pool, func(i, j int) bool {
+		return absDuration(pool[i].CreatedAt.Sub(aggregate.CreatedAt)) < absDuration(pool[j].CreatedAt.Sub(aggregate.CreatedAt))
+	})
+	if len(pool) > m.maxCandidates {
+		pool = pool[:m.maxCandidates]
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].Amount.GreaterThan(pool[j].Amount)
+	})
+	suffix := make([]decimal.Decimal, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		suffix[i] = suffix[i+1].Add(pool[i].Amount)
+	}
+
+	s := &subsetSearch{
+		matcher:  m,
+		currency: aggregate.Currency,
+		net:      net,
+		maxFee:   maxFee,
+		pool:     pool,
+		suffix:   suffix,
+	}
+	s.search(0, decimal.Zero)
+	if s.best == nil {
+		return result
+	}
+
+	result.Matched = true
+	result.Confidence = 0.85
+	if s.best.fee.IsPositive() {
+		result.Confidence = 0.8
+	}
+	for _, i := range s.best.members {
+		result.Members = append(result.Members, pool[i])
+	}
+	result.Gross = s.best.gross
+	result.Fee = s.best.fee
+	return result
+}
+
+// evaluate measures a group against the aggregate, taking as much of the
+// difference as the fee rules allow as the fee
+func (m *SubsetSumMatcher) evaluate(currency string, net, gross decimal.Decimal, n int) *subsetCandidate {
+	allowed := m.allowedFee(currency, gross, n)
+	fee := gross.Sub(net)
+	c := &subsetCandidate{gross: gross}
+	switch {
+	case fee.IsNegative():
+		c.discrepancy = fee.Neg()
+	case fee.GreaterThan(allowed):
+		c.fee = allowed
+		c.discrepancy = fee.Sub(allowed)
+	default:
+		c.fee = fee
+	}
+	return c
+}
+
+// sharesSettlement reports whether a record carries the settlement
+// reference of the aggregate, as its external ID, customer reference or
+// own settlement ID
+func sharesSettlement(aggregate, member *models.Transaction) bool {
+	ref := member.Metadata[MetaSettlementID]
+	if ref == "" {
+		return false
+	}
+	return ref == aggregate.ExternalID || ref == aggregate.Metadata[MetaSettlementID] || ref == aggregate.Metadata[MetaCustomerReference]
+}
+
+// subsetCandidate is a group measured against the aggregate. The
+// discrepancy is how far the group is from a match once the allowed fee is
+// taken into account.
+type subsetCandidate struct {
+	members     []int
+	gross       decimal.Decimal
+	fee         decimal.Decimal
+	discrepancy decimal.Decimal
+}
+
+func (c *subsetCandidate) better(o *subsetCandidate) bool {
+	if o == nil {
+		return true
+	}
+	if cmp := c.discrepancy.Cmp(o.discrepancy); cmp != 0 {
+		return cmp < 0
+	}
+	if cmp := c.fee.Cmp(o.fee); cmp != 0 {
+		return cmp < 0
+	}
+	return len(c.members) < len(o.members)
+}
+
+type subsetSearch struct {
+	matcher  *SubsetSumMatcher
+	currency string
+	net      decimal.Decimal
+	maxFee   decimal.Decimal
+	pool     []*models.Transaction
+	suffix   []decimal.Decimal
+	members  []int
+	best     *subsetCandidate
+	nodes    int
+}
+
+func (s *subsetSearch) done() bool {
+	if s.nodes >= maxGroupSearchNodes {
+		return true
+	}
+	// Nothing beats an exact sum without fees
+	return s.best != nil && s.best.fee.IsZero()
+}
+
+func (s *subsetSearch) search(next int, sum decimal.Decimal) {
+	for i := next; i < len(s.pool) && !s.done(); i++ {
+		s.nodes++
+		// The rest of the pool cannot reach the aggregate
+		if sum.Add(s.suffix[i]).LessThan(s.net) {
+			return
+		}
+		total := sum.Add(s.pool[i].Amount)
+		// Amounts are sorted, so later candidates overshoot less
+		if total.Sub(s.net).GreaterThan(s.maxFee) {
+			continue
+		}
+
+		s.members = append(s.members, i)
+		if len(s.members) >= 2 {
+			s.consider(total)
+		}
+		if len(s.members) < s.matcher.maxGroupSize && total.LessThan(s.net.Add(s.maxFee)) {
+			s.search(i+1, total)
+		}
+		s.members = s.members[:len(s.members)-1]
+	}
+}
+
+// consider keeps the group if it fits within the allowed fee and needs a
+// smaller fee, or fewer records, than the best so far
+func (s *subsetSearch) consider(gross decimal.Decimal) {
+	c := s.matcher.evaluate(s.currency, s.net, gross, len(s.members))
+	if c.discrepancy.IsZero() && c.better(s.best) {
+		c.members = append([]int(nil), s.members...)
+		s.best = c
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// matchGroups matches the records one-to-one matching left over in groups:
+// each unmatched source against a group of remaining targets, then each
+// remaining target against a group of unmatched sources. It returns the
+// sources that are still unmatched.
+func (e *Engine) matchGroups(batchID string, sources []*models.Transaction, targetIndex *TransactionIndex) []*models.Transaction {
+	if len(e.groupMatchers) == 0 || len(sources) == 0 {
+		return sources
+	}
+	window := e.config.GroupMatching.DateWindow
+	if window <= 0 {
+		window = 72 * time.Hour
+	}
+
+	var left []*models.Transaction
+	for _, source := range sources {
+		result := e.bestGroup(source, targetIndex.groupCandidates(source, window, groupCandidateLimit))
+		if result == nil {
+			left = append(left, source)
+			continue
+		}
+		for _, target := range result.Members {
+			targetIndex.remove(target)
+		}
+		e.recordGroup(batchID, []*models.Transaction{source}, result.Members, result)
+	}
+	if len(left) == 0 {
+		return nil
+	}
+
+	sourceIndex := e.buildIndex(left)
+	for _, target := range targetIndex.remaining() {
+		result := e.bestGroup(target, sourceIndex.groupCandidates(target, window, groupCandidateLimit))
+		if result == nil {
+			continue
+		}
+		targetIndex.remove(target)
+		for _, source := range result.Members {
+			sourceIndex.remove(source)
+		}
+		e.recordGroup(batchID, result.Members, []*models.Transaction{target}, result)
+	}
+
+	kept := left[:0]
+	for _, source := range left {
+		if !sourceIndex.claimed(source) {
+			kept = append(kept, source)
+		}
+	}
+	return kept
+}
+
+// bestGroup returns the most confident group match for an aggregate,
+// preferring full matches over partial ones
+func (e *Engine) bestGroup(aggregate *models.Transaction, candidates []*models.Transaction) *GroupMatchResult {
+	var best *GroupMatchResult
+	for _, matcher := range e.groupMatchers {
+		result := matcher.MatchGroup(aggregate, candidates)
+		if !result.Matched && !result.Partial {
+			continue
+		}
+		if best == nil || (result.Matched && !best.Matched) ||
+			(result.Matched == best.Matched && result.Confidence > best.Confidence) {
+			best = result
+		}
+	}
+	return best
+}
+
+// recordGroup records a group match, raising a partial match exception for
+// groups whose amounts do not reconcile
+func (e *Engine) recordGroup(batchID string, sources, targets []*models.Transaction, result *GroupMatchResult) {
+	var sourceTotal, targetTotal decimal.Decimal
+	for _, txn := range sources {
+		sourceTotal = sourceTotal.Add(txn.Amount)
+	}
+	for _, txn := range targets {
+		targetTotal = targetTotal.Add(txn.Amount)
+	}
+
+	match := &CompositeMatch{
+		BatchID:    batchID,
+		Matchers:   []string{result.MatchType},
+		Confidence: result.Confidence,
+		Gross:      result.Gross,
+		Fee:        result.Fee,
+		Residual:   result.Residual,
+		Partial:    result.Partial,
+	}
+	if len(sources) == 1 {
+		match.SourceID = sources[0].ID
+		match.TargetIDs = transactionIDs(targets)
+	} else {
+		match.SourceIDs = transactionIDs(sources)
+		match.TargetID = targets[0].ID
+	}
+	switch {
+	case result.Partial:
+		match.Differences = append(match.Differences, Difference{
+			Field:    "amount",
+			Source:   sourceTotal.String(),
+			Target:   targetTotal.String(),
+			Severity: "error",
+		})
+	case result.Fee.IsPositive():
+		match.Differences = append(match.Differences, Difference{
+			Field:    "fee",
+			Source:   sourceTotal.String(),
+			Target:   targetTotal.String(),
+			Severity: "warning",
+		})
+	}
+
+	status := models.ReconcileStatusMatched
+	if result.Partial {
+		status = models.ReconcileStatusException
+	}
+	for _, txn := range append(append([]*models.Transaction(nil), sources...), targets...) {
+		txn.ReconcileStatus = status
+	}
+
+	e.mu.Lock()
+	match.ID = fmt.Sprintf("%s-group-%d", batchID, len(e.groups[batchID])+1)
+	e.groups[batchID] = append(e.groups[batchID], match)
+	if result.Partial {
+		exception := &models.ReconcileException{
+			ID:            generateExceptionID(),
+			BatchID:       batchID,
+			Type:          models.ExceptionTypePartialMatch,
+			GroupID:       match.ID,
+			SourceRecords: sources,
+			TargetRecords: targets,
+			AmountDiff:    result.Residual.Abs(),
+			Description:   fmt.Sprintf("group of %d records leaves %s unreconciled", len(result.Members), result.Residual.String()),
+			Status:        models.ExceptionStatusOpen,
+			CreatedAt:     time.Now(),
+		}
+		e.exceptions[exception.ID] = exception
+		if batch, ok := e.batches[batchID]; ok {
+			batch.Exceptions++
+		}
+	}
+	e.mu.Unlock()
+
+	for range sources {
+		e.updateBatchProgress(batchID, !result.Partial)
+	}
+}
+
+// GetGroupMatches retrieves the group matches of a batch
+func (e *Engine) GetGroupMatches(batchID string) []*CompositeMatch {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]*CompositeMatch(nil), e.groups[batchID]...)
+}
+
+func transactionIDs(txns []*models.Transaction) []string {
+	ids := make([]string, len(txns))
+	for i, txn := range txns {
+		ids[i] = txn.ID
+	}
+	return ids
+}
@@ -0,0 +1,164 @@
+package reconciliation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func groupTxn(id, amount string, at time.Time) *models.Transaction {
+	return &models.Transaction{
+		ID:        id,
+		Type:      models.TransactionTypeCredit,
+		Status:    models.TransactionStatusCompleted,
+		Amount:    decimal.RequireFromString(amount),
+		Currency:  "EUR",
+		CreatedAt: at,
+		Metadata:  map[string]string{},
+	}
+}
+
+func groupConfig() *config.ReconciliationConfig {
+	return &config.ReconciliationConfig{
+		MatchTolerance: 0.01,
+		DateTolerance:  24 * time.Hour,
+		GroupMatching: config.GroupMatchConfig{
+			Enabled:    true,
+			DateWindow: 72 * time.Hour,
+			FeeRules:   []config.FeeRule{{Currency: "EUR", Rate: 0.03}},
+		},
+	}
+}
+
+func TestSubsetSumMatcher_MatchGroup(t *testing.T) {
+	matcher := NewSubsetSumMatcher(groupConfig().GroupMatching)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	deposit := groupTxn("deposit", "291.10", day.Add(48*time.Hour))
+	late := groupTxn("card-late", "75", day.Add(-96*time.Hour))
+	usd := groupTxn("card-usd", "20", day)
+	usd.Currency = "USD"
+	candidates := []*models.Transaction{
+		groupTxn("card-a", "100", day),
+		groupTxn("card-b", "50", day),
+		groupTxn("card-c", "150", day),
+		groupTxn("card-d", "30", day),
+		late,
+		usd,
+	}
+
+	result := matcher.MatchGroup(deposit, candidates)
+	if !result.Matched || result.Partial || len(result.Members) != 3 {
+		t.Fatalf("expected a three record match, got %+v", result)
+	}
+	if !result.Gross.Equal(decimal.NewFromInt(300)) || !result.Fee.Equal(decimal.RequireFromString("8.90")) || !result.Residual.IsZero() {
+		t.Errorf("unexpected amounts gross=%s fee=%s residual=%s", result.Gross, result.Fee, result.Residual)
+	}
+
+	// No group fits once the fee exceeds the rules
+	deposit.Amount = decimal.RequireFromString("290")
+	if result := matcher.MatchGroup(deposit, candidates); result.Matched || result.Partial {
+		t.Errorf("expected no match beyond the allowed fee, got %+v", result.Members)
+	}
+}
+
+func TestSubsetSumMatcher_SettlementReference(t *testing.T) {
+	matcher := NewSubsetSumMatcher(groupConfig().GroupMatching)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	payout := groupTxn("payout", "100", day)
+	payout.ExternalID = "PO-7"
+	first := groupTxn("sale-1", "60", day)
+	first.Metadata[MetaSettlementID] = "PO-7"
+	second := groupTxn("sale-2", "30", day.Add(-240*time.Hour))
+	second.Metadata[MetaSettlementID] = "PO-7"
+
+	result := matcher.MatchGroup(payout, []*models.Transaction{first, second, groupTxn("sale-3", "10", day)})
+	if result.Matched || !result.Partial || len(result.Members) != 2 || result.MatchType != "settlement_reference" {
+		t.Fatalf("expected a partial settlement match, got %+v", result)
+	}
+	if !result.Residual.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("expected residual 10, got %s", result.Residual)
+	}
+}
+
+func TestEngine_ReconcileGroups(t *testing.T) {
+	engine := NewEngine(groupConfig())
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A deposit settling three card sales less fees, and two bank entries
+	// booked as one ledger transfer
+	deposit := groupTxn("deposit", "291.10", day.Add(24*time.Hour))
+	partA := groupTxn("wire-a", "45", day)
+	partB := groupTxn("wire-b", "65", day)
+	sources := []*models.Transaction{deposit, partA, partB}
+	targets := []*models.Transaction{
+		groupTxn("card-a", "100", day),
+		groupTxn("card-b", "50", day),
+		groupTxn("card-c", "150", day),
+		groupTxn("transfer", "110", day),
+	}
+
+	batch := engine.CreateBatch("bank", "ledger")
+	if err := engine.Reconcile(context.Background(), batch.ID, sources, targets); err != nil {
+		t.Fatal(err)
+	}
+	if batch.MatchedRecords != 3 || batch.UnmatchedRecords != 0 || batch.Exceptions != 0 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	groups := engine.GetGroupMatches(batch.ID)
+	if len(groups) != 2 {
+		t.Fatalf("expected two groups, got %d", len(groups))
+	}
+	if groups[0].SourceID != "deposit" || len(groups[0].TargetIDs) != 3 || !groups[0].Fee.Equal(decimal.RequireFromString("8.90")) {
+		t.Errorf("unexpected deposit group %+v", groups[0])
+	}
+	if groups[1].TargetID != "transfer" || len(groups[1].SourceIDs) != 2 || groups[1].Partial {
+		t.Errorf("unexpected transfer group %+v", groups[1])
+	}
+	for _, txn := range append(sources, targets...) {
+		if txn.ReconcileStatus != models.ReconcileStatusMatched {
+			t.Errorf("%s: expected matched, got %s", txn.ID, txn.ReconcileStatus)
+		}
+	}
+}
+
+func TestEngine_ReconcilePartialGroup(t *testing.T) {
+	engine := NewEngine(groupConfig())
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	payout := groupTxn("payout", "100", day)
+	payout.ExternalID = "PO-7"
+	first := groupTxn("sale-1", "60", day)
+	first.Metadata[MetaSettlementID] = "PO-7"
+	second := groupTxn("sale-2", "30", day)
+	second.Metadata[MetaSettlementID] = "PO-7"
+
+	batch := engine.CreateBatch("bank", "ledger")
+	if err := engine.Reconcile(context.Background(), batch.ID, []*models.Transaction{payout}, []*models.Transaction{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if batch.MatchedRecords != 0 || batch.UnmatchedRecords != 1 || batch.Exceptions != 1 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	exceptions := engine.GetExceptions(batch.ID)
+	if len(exceptions) != 1 {
+		t.Fatalf("expected one exception, got %d", len(exceptions))
+	}
+	exc := exceptions[0]
+	if exc.Type != models.ExceptionTypePartialMatch || exc.GroupID == "" || len(exc.SourceRecords) != 1 || len(exc.TargetRecords) != 2 {
+		t.Errorf("unexpected exception %+v", exc)
+	}
+	if !exc.AmountDiff.Equal(decimal.NewFromInt(10)) || payout.ReconcileStatus != models.ReconcileStatusException {
+		t.Errorf("unexpected amount %s or status %s", exc.AmountDiff, payout.ReconcileStatus)
+	}
+	if groups := engine.GetGroupMatches(batch.ID); len(groups) != 1 || !groups[0].Partial || groups[0].ID != exc.GroupID {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+}
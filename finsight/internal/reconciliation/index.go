@@ -0,0 +1,310 @@
+package reconciliation
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// defaultMaxCandidates caps the candidates a range query returns when the
+// configuration does not
+const defaultMaxCandidates = 64
+
+// groupCandidateLimit caps the records handed to group matchers per
+// aggregate, nearest in time first
+const groupCandidateLimit = 256
+
+// TransactionIndex indexes transactions for efficient lookup. Entries are
+// grouped into time buckets the width of the date window and sorted by
+// amount within each bucket, so a tolerance range query searches three
+// buckets; they are also sorted by time for window queries. Matched entries are claimed rather than removed so
+// concurrent workers can share the index.
+type TransactionIndex struct {
+	byID         map[string]*indexEntry
+	byExternalID map[string]*indexEntry
+	byReference  map[string][]*indexEntry
+	bySettlement map[string][]*indexEntry
+	entries      []indexEntry
+	byTime       []*indexEntry
+	window       int64 // nanoseconds
+}
+
+type indexEntry struct {
+	txn     *models.Transaction
+	amount  int64 // minor units
+	at      int64 // unix nanoseconds
+	bucket  int64
+	claimed atomic.Bool
+}
+
+func (e *Engine) buildIndex(transactions []*models.Transaction) *TransactionIndex {
+	index := &TransactionIndex{
+		byID:         make(map[string]*indexEntry, len(transactions)),
+		byExternalID: make(map[string]*indexEntry),
+		byReference:  make(map[string][]*indexEntry),
+		bySettlement: make(map[string][]*indexEntry),
+		entries:      make([]indexEntry, len(transactions)),
+		byTime:       make([]*indexEntry, len(transactions)),
+		window:       int64(e.dateWindow()),
+	}
+
+	for i, txn := range transactions {
+		index.entries[i].txn = txn
+		index.entries[i].amount = amountKey(txn)
+		index.entries[i].at = txn.CreatedAt.UnixNano()
+		index.entries[i].bucket = floorDiv(index.entries[i].at, index.window)
+	}
+	sort.Slice(index.entries, func(i, j int) bool {
+		a, b := &index.entries[i], &index.entries[j]
+		if a.bucket != b.bucket {
+			return a.bucket < b.bucket
+		}
+		if a.amount != b.amount {
+			return a.amount < b.amount
+		}
+		return a.at < b.at
+	})
+
+	// Entries no longer move, so they can be referenced from here on
+	for i := range index.entries {
+		entry := &index.entries[i]
+		txn := entry.txn
+		index.byTime[i] = entry
+		index.byID[txn.ID] = entry
+		if txn.ExternalID != "" {
+			index.byExternalID[txn.ExternalID] = entry
+		}
+		if ref := txn.Metadata["reference_id"]; ref != "" {
+			index.byReference[ref] = append(index.byReference[ref], entry)
+		}
+		if ref := txn.Metadata[MetaSettlementID]; ref != "" {
+			index.bySettlement[ref] = append(index.bySettlement[ref], entry)
+		}
+	}
+	sort.SliceStable(index.byTime, func(i, j int) bool {
+		return index.byTime[i].at < index.byTime[j].at
+	})
+
+	return index
+}
+
+// dateWindow is how far apart in time a source and its candidates may be.
+// Exact matches need the same day whatever the date tolerance.
+func (e *Engine) dateWindow() time.Duration {
+	if e.config.DateTolerance < 24*time.Hour {
+		return 24 * time.Hour
+	}
+	return e.config.DateTolerance
+}
+
+// amountKey returns a transaction's amount in minor units. Finer amounts
+// are truncated; range queries widen by one unit to cover them.
+func amountKey(txn *models.Transaction) int64 {
+	return txn.Amount.Shift(2).IntPart()
+}
+
+// claim marks an entry matched, reporting false if it already was
+func (idx *TransactionIndex) claim(entry *indexEntry) bool {
+	return entry.claimed.CompareAndSwap(false, true)
+}
+
+// remove marks a transaction matched
+func (idx *TransactionIndex) remove(txn *models.Transaction) {
+	if entry, ok := idx.byID[txn.ID]; ok && entry.txn == txn {
+		entry.claimed.Store(true)
+	}
+}
+
+// claimed reports whether a transaction has been matched
+func (idx *TransactionIndex) claimed(txn *models.Transaction) bool {
+	entry, ok := idx.byID[txn.ID]
+	return ok && entry.txn == txn && entry.claimed.Load()
+}
+
+// remaining returns the unmatched transactions in time order
+func (idx *TransactionIndex) remaining() []*models.Transaction {
+	var txns []*models.Transaction
+	for _, entry := range idx.byTime {
+		if !entry.claimed.Load() {
+			txns = append(txns, entry.txn)
+		}
+	}
+	return txns
+}
+
+func (e *Engine) findCandidates(source *models.Transaction, index *TransactionIndex) []*models.Transaction {
+	entries := e.candidateEntries(source, index)
+	candidates := make([]*models.Transaction, len(entries))
+	for i, entry := range entries {
+		candidates[i] = entry.txn
+	}
+	return candidates
+}
+
+// candidateEntries returns the unmatched entries a source could match: its
+// referenced entries first, then those within the amount and date
+// tolerance, closest amounts first
+func (e *Engine) candidateEntries(source *models.Transaction, index *TransactionIndex) []*indexEntry {
+	candidates := e.referencedEntries(source, index)
+	referenced := len(candidates)
+
+	limit := e.config.MaxCandidates
+	if limit <= 0 {
+		limit = defaultMaxCandidates
+	}
+
+	key := amountKey(source)
+	delta := int64(math.Ceil(math.Abs(float64(key))*e.config.MatchTolerance)) + 1
+	found := 0
+hits:
+	for _, entry := range index.rangeQuery(key, delta, source.CreatedAt.UnixNano()) {
+		if found == limit {
+			break
+		}
+		for _, c := range candidates[:referenced] {
+			if c == entry {
+				continue hits
+			}
+		}
+		candidates = append(candidates, entry)
+		found++
+	}
+	return candidates
+}
+
+// referencedEntries returns the unmatched entries with the source's
+// external ID or sharing its reference
+func (e *Engine) referencedEntries(source *models.Transaction, index *TransactionIndex) []*indexEntry {
+	var candidates []*indexEntry
+	add := func(entry *indexEntry) {
+		if entry.claimed.Load() {
+			return
+		}
+		for _, c := range candidates {
+			if c == entry {
+				return
+			}
+		}
+		candidates = append(candidates, entry)
+	}
+
+	if source.ExternalID != "" {
+		if entry, ok := index.byExternalID[source.ExternalID]; ok {
+			add(entry)
+		}
+	}
+	if ref := source.Metadata["reference_id"]; ref != "" {
+		for _, entry := range index.byReference[ref] {
+			add(entry)
+		}
+	}
+	return candidates
+}
+
+// rangeQuery returns the unmatched entries with an amount within delta of
+// amount and a time within the index window of at, closest amounts first
+// and then closest in time. Only the buckets either side of at are
+// searched, and within each only the amount range.
+func (idx *TransactionIndex) rangeQuery(amount, delta, at int64) []*indexEntry {
+	type hit struct {
+		entry          *indexEntry
+		amountDiff, dt int64
+	}
+	var hits []hit
+	entries := idx.entries
+	bucket := floorDiv(at, idx.window)
+	for b := bucket - 1; b <= bucket+1; b++ {
+		lo := sort.Search(len(entries), func(i int) bool {
+			return entries[i].bucket > b || entries[i].bucket == b && entries[i].amount >= amount-delta
+		})
+		for i := lo; i < len(entries) && entries[i].bucket == b && entries[i].amount <= amount+delta; i++ {
+			entry := &entries[i]
+			dt := entry.at - at
+			if dt < 0 {
+				dt = -dt
+			}
+			if dt <= idx.window && !entry.claimed.Load() {
+				diff := entry.amount - amount
+				if diff < 0 {
+					diff = -diff
+				}
+				hits = append(hits, hit{entry: entry, amountDiff: diff, dt: dt})
+			}
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].amountDiff != hits[j].amountDiff {
+			return hits[i].amountDiff < hits[j].amountDiff
+		}
+		return hits[i].dt < hits[j].dt
+	})
+	result := make([]*indexEntry, len(hits))
+	for i, h := range hits {
+		result[i] = h.entry
+	}
+	return result
+}
+
+// floorDiv divides rounding towards negative infinity, so times before
+// the epoch fall into their own buckets
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// groupCandidates returns the unmatched records a group matcher should
+// consider for an aggregate: those carrying its settlement reference and
+// those nearest to it in time within the window
+func (idx *TransactionIndex) groupCandidates(aggregate *models.Transaction, window time.Duration, limit int) []*models.Transaction {
+	var candidates []*models.Transaction
+	seen := make(map[*indexEntry]bool)
+	for _, ref := range []string{aggregate.ExternalID, aggregate.Metadata[MetaSettlementID], aggregate.Metadata[MetaCustomerReference]} {
+		if ref == "" {
+			continue
+		}
+		for _, entry := range idx.bySettlement[ref] {
+			if !seen[entry] && !entry.claimed.Load() {
+				seen[entry] = true
+				candidates = append(candidates, entry.txn)
+			}
+		}
+	}
+
+	at := aggregate.CreatedAt.UnixNano()
+	byTime := idx.byTime
+	r := sort.Search(len(byTime), func(i int) bool { return byTime[i].at >= at })
+	l := r - 1
+	for found := 0; found < limit; {
+		useRight := r < len(byTime) && byTime[r].at-at <= int64(window)
+		useLeft := l >= 0 && at-byTime[l].at <= int64(window)
+		if useLeft && useRight {
+			useRight = byTime[r].at-at <= at-byTime[l].at
+			useLeft = !useRight
+		}
+
+		var entry *indexEntry
+		switch {
+		case useRight:
+			entry = byTime[r]
+			r++
+		case useLeft:
+			entry = byTime[l]
+			l--
+		default:
+			return candidates
+		}
+		if !seen[entry] && !entry.claimed.Load() {
+			seen[entry] = true
+			candidates = append(candidates, entry.txn)
+			found++
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,62 @@
+package reconciliation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestFindCandidates_AmountTolerance(t *testing.T) {
+	engine := NewEngine(&config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour})
+	day := time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)
+
+	index := engine.buildIndex([]*models.Transaction{
+		{ID: "near", Amount: decimal.RequireFromString("100.90"), CreatedAt: day.Add(20 * time.Hour)},
+		{ID: "too-large", Amount: decimal.RequireFromString("101.10"), CreatedAt: day},
+		{ID: "too-late", Amount: decimal.RequireFromString("100.00"), CreatedAt: day.Add(25 * time.Hour)},
+		{ID: "below", Amount: decimal.RequireFromString("99.50"), CreatedAt: day},
+	})
+
+	// Neither the same amount nor the same day, but within tolerance
+	candidates := engine.findCandidates(&models.Transaction{Amount: decimal.NewFromInt(100), CreatedAt: day}, index)
+	if len(candidates) != 2 || candidates[0].ID != "below" || candidates[1].ID != "near" {
+		t.Fatalf("expected the closest amounts within tolerance, got %v", transactionIDs(candidates))
+	}
+
+	index.remove(candidates[0])
+	candidates = engine.findCandidates(&models.Transaction{Amount: decimal.NewFromInt(100), CreatedAt: day}, index)
+	if len(candidates) != 1 || candidates[0].ID != "near" {
+		t.Errorf("expected matched entries to be skipped, got %v", transactionIDs(candidates))
+	}
+}
+
+func TestFindCandidates_Limit(t *testing.T) {
+	engine := NewEngine(&config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour, MaxCandidates: 5})
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A busy day of identical amounts
+	var targets []*models.Transaction
+	for i := 0; i < 200; i++ {
+		targets = append(targets, &models.Transaction{
+			ID:        fmt.Sprintf("t%03d", i),
+			Amount:    decimal.NewFromInt(25),
+			CreatedAt: day.Add(time.Duration(i-100) * time.Minute),
+		})
+	}
+	targets = append(targets, &models.Transaction{ID: "ref", ExternalID: "EXT-1", Amount: decimal.NewFromInt(999), CreatedAt: day})
+	index := engine.buildIndex(targets)
+
+	candidates := engine.findCandidates(&models.Transaction{ExternalID: "EXT-1", Amount: decimal.NewFromInt(25), CreatedAt: day}, index)
+	if len(candidates) != 6 || candidates[0].ID != "ref" {
+		t.Fatalf("expected the external ID match and five others, got %v", transactionIDs(candidates))
+	}
+	for _, c := range candidates[1:] {
+		if d := absDuration(c.CreatedAt.Sub(day)); d > 2*time.Minute {
+			t.Errorf("expected the candidates closest in time, got %s at %s", c.ID, d)
+		}
+	}
+}
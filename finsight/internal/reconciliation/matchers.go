@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
 )
 
 // ExactMatcher matches transactions exactly
@@ -226,13 +227,24 @@ func (m *ReferenceIDMatcher) findDifferences(source, target *models.Transaction)
 	return diffs
 }
 
-// CompositeMatch represents a match using multiple matchers
+// CompositeMatch represents a match using multiple matchers, or a group of
+// records on one side matched to a single record on the other. Group
+// matches list their records in SourceIDs or TargetIDs; Residual is the
+// amount a partially matched group leaves unexplained.
 type CompositeMatch struct {
-	SourceID   string
-	TargetID   string
-	Matchers   []string
-	Confidence float64
-	Differences []Difference
+	ID          string          `json:"id,omitempty"`
+	BatchID     string          `json:"batch_id,omitempty"`
+	SourceID    string          `json:"source_id,omitempty"`
+	TargetID    string          `json:"target_id,omitempty"`
+	SourceIDs   []string        `json:"source_ids,omitempty"`
+	TargetIDs   []string        `json:"target_ids,omitempty"`
+	Matchers    []string        `json:"matchers"`
+	Confidence  float64         `json:"confidence"`
+	Differences []Difference    `json:"differences,omitempty"`
+	Gross       decimal.Decimal `json:"gross"`
+	Fee         decimal.Decimal `json:"fee"`
+	Residual    decimal.Decimal `json:"residual"`
+	Partial     bool            `json:"partial"`
 }
 
 // MultiMatcher uses multiple matchers and combines their results
@@ -0,0 +1,261 @@
+package reconciliation
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// SWIFT MT940 customer statements and MT942 interim transaction reports
+
+var (
+	mtTagPattern = regexp.MustCompile(`^:(\d{2}[A-Z]?):`)
+	// :61: value date, entry date, mark, funds code, amount, type, references
+	mtLinePattern = regexp.MustCompile(`^(\d{6})(\d{4})?(R?[CD])([A-Z])?(\d+,\d*)([NSF][A-Z0-9]{3})(.*)$`)
+	// :60F:, :62F: and friends: mark, date, currency, amount
+	mtBalancePattern = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)$`)
+	// :86: with ?nn subfields, optionally after a three digit GVC code
+	mtStructuredPattern = regexp.MustCompile(`^(\d{3})?\?\d{2}`)
+)
+
+type mtField struct {
+	tag   string
+	value string
+}
+
+// ParseMT940 parses one or more MT940 or MT942 messages. The bank reference
+// after // in field 61 becomes the external ID, falling back to the
+// account owner's reference.
+func ParseMT940(r io.Reader) ([]*Statement, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []*Statement
+	for _, message := range splitMTMessages(string(data)) {
+		fields := mtFields(message)
+		if len(fields) == 0 {
+			continue
+		}
+		s, err := parseMTMessage(message, fields)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+// splitMTMessages strips the SWIFT envelope blocks and splits the text
+// into one block 4 per message
+func splitMTMessages(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var messages []string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			messages = append(messages, strings.Join(current, "\n"))
+			current = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if i := strings.Index(line, "{4:"); i >= 0 {
+			flush()
+			if i > 0 {
+				// Keep the message type from block 2 for format detection
+				current = append(current, line[:i])
+			}
+			line = line[i+3:]
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "-}" || strings.HasPrefix(trimmed, "-}"):
+			flush()
+			continue
+		case trimmed == "-" || trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, ":20:") && len(current) > 0 && containsTag(current, ":20:"):
+			// A new statement without an envelope
+			flush()
+		}
+		current = append(current, line)
+	}
+	flush()
+	return messages
+}
+
+func containsTag(lines []string, tag string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// mtFields splits a message into tagged fields, joining continuation lines
+func mtFields(message string) []mtField {
+	var fields []mtField
+	for _, line := range strings.Split(message, "\n") {
+		if m := mtTagPattern.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mtField{tag: m[1], value: line[len(m[0]):]})
+		} else if len(fields) > 0 {
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+	return fields
+}
+
+func parseMTMessage(message string, fields []mtField) (*Statement, error) {
+	s := &Statement{Format: FormatMT940}
+	if strings.Contains(message, "{2:I942") || strings.Contains(message, "{2:O942") {
+		s.Format = FormatMT942
+	}
+
+	var txn *models.Transaction
+	flush := func() {
+		if txn != nil {
+			s.add(txn)
+			txn = nil
+		}
+	}
+
+	for _, f := range fields {
+		switch f.tag {
+		case "20":
+			s.ID = strings.TrimSpace(f.value)
+		case "25", "25P":
+			s.Account = strings.TrimSpace(strings.SplitN(f.value, "\n", 2)[0])
+		case "13D", "34F":
+			s.Format = FormatMT942
+			if f.tag == "34F" && s.Currency == "" && len(f.value) >= 3 {
+				s.Currency = f.value[:3]
+			}
+		case "60F", "60M":
+			credit, amount, currency, err := parseMTBalance(f.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: field %s: %w", s.ID, f.tag, err)
+			}
+			s.Currency = currency
+			s.OpeningBalance = signed(amount, credit)
+		case "62F", "62M":
+			credit, amount, currency, err := parseMTBalance(f.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: field %s: %w", s.ID, f.tag, err)
+			}
+			if s.Currency == "" {
+				s.Currency = currency
+			}
+			s.ClosingBalance = signed(amount, credit)
+		case "61":
+			flush()
+			t, err := parseMTLine(f.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: entry %d: %w", s.ID, len(s.Transactions)+1, err)
+			}
+			txn = t
+		case "86":
+			if txn != nil {
+				txn.Description = mtNarrative(f.value)
+			}
+		}
+	}
+	flush()
+
+	if s.Account == "" {
+		return nil, fmt.Errorf("statement %s: missing account (field 25)", s.ID)
+	}
+	s.finish()
+	return s, nil
+}
+
+// mtNarrative joins the lines of field 86. Structured narratives with ?nn
+// subfields are wrapped mid-word, free text at word boundaries.
+func mtNarrative(v string) string {
+	sep := " "
+	if mtStructuredPattern.MatchString(strings.TrimSpace(v)) {
+		sep = ""
+	}
+	return strings.Join(strings.Fields(strings.Join(strings.Split(v, "\n"), sep)), " ")
+}
+
+func parseMTBalance(v string) (bool, decimal.Decimal, string, error) {
+	m := mtBalancePattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return false, decimal.Zero, "", fmt.Errorf("invalid balance %q", v)
+	}
+	amount, err := parseStatementAmount(m[4])
+	if err != nil {
+		return false, decimal.Zero, "", err
+	}
+	return m[1] == "C", amount, m[3], nil
+}
+
+// parseMTLine parses a statement line and its supplementary details
+func parseMTLine(v string) (*models.Transaction, error) {
+	lines := strings.SplitN(v, "\n", 2)
+	m := mtLinePattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if m == nil {
+		return nil, fmt.Errorf("invalid statement line %q", lines[0])
+	}
+
+	valueDate, err := time.Parse("060102", m[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid value date %q", m[1])
+	}
+	booked := valueDate
+	if m[2] != "" {
+		entry, err := time.Parse("0102", m[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry date %q", m[2])
+		}
+		year := valueDate.Year()
+		// The entry date has no year and may fall across a year end
+		switch {
+		case entry.Month() == time.December && valueDate.Month() == time.January:
+			year--
+		case entry.Month() == time.January && valueDate.Month() == time.December:
+			year++
+		}
+		booked = time.Date(year, entry.Month(), entry.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	amount, err := parseStatementAmount(m[5])
+	if err != nil {
+		return nil, err
+	}
+
+	// A reversal of a credit is a debit and vice versa
+	mark := m[3]
+	credit := mark == "C" || mark == "RD"
+	customerRef, bankRef, _ := strings.Cut(m[7], "//")
+
+	txn := &models.Transaction{
+		Type:      direction(credit),
+		Amount:    amount,
+		CreatedAt: booked,
+		Metadata: map[string]string{
+			MetaValueDate:           valueDate.Format("2006-01-02"),
+			MetaBankTransactionCode: m[6],
+			MetaCustomerReference:   strings.TrimSpace(customerRef),
+			MetaBankReference:       strings.TrimSpace(bankRef),
+		},
+	}
+	if strings.HasPrefix(mark, "R") {
+		txn.Metadata[MetaReversal] = "true"
+	}
+	txn.ExternalID = firstReference(bankRef, customerRef)
+	valueAt := valueDate
+	txn.SettledAt = &valueAt
+	if len(lines) > 1 {
+		txn.Description = strings.TrimSpace(lines[1])
+	}
+	return txn, nil
+}
@@ -0,0 +1,221 @@
+package reconciliation
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// OFX bank and credit card statements, both the SGML 1.x dialect, where
+// leaf elements have no end tags, and OFX 2.x XML
+
+var ofxTagPattern = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+var ofxUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ")
+
+// ParseOFX parses every bank or credit card statement response in an OFX
+// file. The financial institution's transaction ID becomes the external ID.
+func ParseOFX(r io.Reader) ([]*Statement, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	start := strings.Index(strings.ToUpper(text), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("invalid OFX file: no OFX element")
+	}
+
+	var statements []*Statement
+	var stmt *Statement
+	var txn *models.Transaction
+	var stack []string
+	within := func(name string) bool {
+		for _, s := range stack {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, m := range ofxTagPattern.FindAllStringSubmatch(text[start:], -1) {
+		closing, name, value := m[1] == "/", strings.ToUpper(m[2]), strings.TrimSpace(ofxUnescaper.Replace(m[3]))
+
+		if closing {
+			// Leaf end tags in OFX 2.x close nothing on the stack
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			switch name {
+			case "STMTTRN":
+				if stmt != nil && txn != nil {
+					stmt.add(txn)
+				}
+				txn = nil
+			case "STMTRS", "CCSTMTRS":
+				if stmt != nil {
+					stmt.finish()
+					statements = append(statements, stmt)
+				}
+				stmt = nil
+			}
+			continue
+		}
+
+		if value == "" {
+			// An aggregate
+			stack = append(stack, name)
+			switch name {
+			case "STMTRS", "CCSTMTRS":
+				stmt = &Statement{Format: FormatOFX}
+			case "STMTTRN":
+				txn = &models.Transaction{Metadata: map[string]string{}}
+			}
+			continue
+		}
+
+		if stmt == nil {
+			continue
+		}
+		if txn != nil {
+			if err := setOFXTransactionField(txn, name, value, within("BANKACCTTO") || within("CCACCTTO")); err != nil {
+				return nil, fmt.Errorf("statement %s: transaction %d: %w", stmt.Account, len(stmt.Transactions)+1, err)
+			}
+			continue
+		}
+		switch name {
+		case "CURDEF":
+			stmt.Currency = value
+		case "ACCTID":
+			if within("BANKACCTFROM") || within("CCACCTFROM") {
+				stmt.Account = value
+			}
+		case "DTSTART", "DTEND":
+			if !within("BANKTRANLIST") {
+				continue
+			}
+			t, err := parseOFXTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: %s: %w", stmt.Account, name, err)
+			}
+			if name == "DTSTART" {
+				stmt.ID = t.Format("2006-01-02")
+			}
+		case "BALAMT":
+			if within("LEDGERBAL") {
+				amount, err := parseStatementAmount(value)
+				if err != nil {
+					return nil, fmt.Errorf("statement %s: ledger balance: %w", stmt.Account, err)
+				}
+				stmt.ClosingBalance = &amount
+			}
+		}
+	}
+
+	// SGML files may end without closing the statement
+	if stmt != nil {
+		if txn != nil {
+			stmt.add(txn)
+		}
+		stmt.finish()
+		statements = append(statements, stmt)
+	}
+	for _, s := range statements {
+		if s.Account == "" {
+			return nil, fmt.Errorf("statement without an account (ACCTID)")
+		}
+	}
+	return statements, nil
+}
+
+func setOFXTransactionField(txn *models.Transaction, name, value string, counterparty bool) error {
+	switch name {
+	case "TRNTYPE":
+		txn.Metadata[MetaBankTransactionCode] = value
+	case "DTPOSTED":
+		t, err := parseOFXTime(value)
+		if err != nil {
+			return fmt.Errorf("DTPOSTED: %w", err)
+		}
+		txn.CreatedAt = t
+	case "DTAVAIL":
+		t, err := parseOFXTime(value)
+		if err != nil {
+			return fmt.Errorf("DTAVAIL: %w", err)
+		}
+		txn.SettledAt = &t
+		txn.Metadata[MetaValueDate] = t.Format("2006-01-02")
+	case "TRNAMT":
+		amount, err := parseStatementAmount(value)
+		if err != nil {
+			return err
+		}
+		txn.Type = direction(!amount.IsNegative())
+		txn.Amount = amount.Abs()
+	case "FITID":
+		txn.ExternalID = value
+		txn.Metadata[MetaBankReference] = value
+	case "REFNUM", "CHECKNUM":
+		if txn.Metadata[MetaCustomerReference] == "" {
+			txn.Metadata[MetaCustomerReference] = value
+		}
+	case "NAME", "PAYEEID":
+		txn.Metadata[MetaCounterparty] = value
+		if txn.Description == "" {
+			txn.Description = value
+		}
+	case "MEMO":
+		if txn.Description != "" && txn.Description != value {
+			txn.Description += " " + value
+		} else {
+			txn.Description = value
+		}
+	case "ACCTID":
+		if counterparty {
+			txn.DestAccount = value
+			txn.Metadata[MetaCounterpartyAccount] = value
+		}
+	case "CURSYM":
+		txn.Currency = value
+	}
+	return nil
+}
+
+// parseOFXTime parses YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]]
+func parseOFXTime(s string) (time.Time, error) {
+	value, zone, _ := strings.Cut(s, "[")
+	loc := time.UTC
+	if zone != "" {
+		offset, _, _ := strings.Cut(strings.TrimSuffix(zone, "]"), ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone in %q", s)
+		}
+		loc = time.FixedZone("", int(hours*3600))
+	}
+	if i := strings.Index(value, "."); i >= 0 {
+		value = value[:i]
+	}
+
+	layout := "20060102150405"
+	if len(value) < len(layout) {
+		if len(value) != 8 && len(value) != 12 {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+		layout = layout[:len(value)]
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t.UTC(), nil
+}
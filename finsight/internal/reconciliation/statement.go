@@ -0,0 +1,260 @@
+package reconciliation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Bank statement formats accepted by ParseStatements
+const (
+	FormatMT940   = "mt940"
+	FormatMT942   = "mt942"
+	FormatCAMT052 = "camt.052"
+	FormatCAMT053 = "camt.053"
+	FormatBAI2    = "bai2"
+	FormatOFX     = "ofx"
+)
+
+// Metadata keys set on statement transactions
+const (
+	MetaStatementFormat     = "statement_format"
+	MetaStatementID         = "statement_id"
+	MetaValueDate           = "value_date"
+	MetaBankTransactionCode = "bank_transaction_code"
+	MetaBankReference       = "bank_reference"
+	MetaCustomerReference   = "customer_reference"
+	MetaCounterparty        = "counterparty"
+	MetaCounterpartyAccount = "counterparty_account"
+	MetaReversal            = "reversal"
+)
+
+// Statement is a bank statement or intraday report normalized into
+// transactions. Amounts are positive with the direction in the transaction
+// type; balances are signed.
+type Statement struct {
+	Format         string                `json:"format"`
+	ID             string                `json:"id,omitempty"`
+	Account        string                `json:"account"`
+	Currency       string                `json:"currency,omitempty"`
+	OpeningBalance *decimal.Decimal      `json:"opening_balance,omitempty"`
+	ClosingBalance *decimal.Decimal      `json:"closing_balance,omitempty"`
+	StartDate      time.Time             `json:"start_date"`
+	EndDate        time.Time             `json:"end_date"`
+	Transactions   []*models.Transaction `json:"transactions"`
+}
+
+// ParseStatements parses every statement in a file of the given format
+func ParseStatements(format string, r io.Reader) ([]*Statement, error) {
+	var statements []*Statement
+	var err error
+	switch format {
+	case FormatMT940, FormatMT942:
+		statements, err = ParseMT940(r)
+	case FormatCAMT052, FormatCAMT053:
+		statements, err = ParseCAMT(r)
+	case FormatBAI2:
+		statements, err = ParseBAI2(r)
+	case FormatOFX:
+		statements, err = ParseOFX(r)
+	default:
+		return nil, fmt.Errorf("unsupported statement format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no statements found")
+	}
+	return statements, nil
+}
+
+// DetectStatementFormat guesses the format of a statement file, returning
+// an empty string when it is not recognized
+func DetectStatementFormat(data []byte) string {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(string(head), "\ufeff"))
+	switch {
+	case strings.Contains(text, "OFXHEADER") || strings.Contains(text, "<OFX>"):
+		return FormatOFX
+	case strings.Contains(text, "camt.053") || strings.Contains(text, "BkToCstmrStmt"):
+		return FormatCAMT053
+	case strings.Contains(text, "camt.052") || strings.Contains(text, "BkToCstmrAcctRpt"):
+		return FormatCAMT052
+	case strings.HasPrefix(text, "01,"):
+		return FormatBAI2
+	case strings.Contains(text, ":20:") && strings.Contains(text, ":25:"):
+		if strings.Contains(text, "{2:I942") || strings.Contains(text, "{2:O942") || strings.Contains(text, ":13D:") || strings.Contains(text, ":34F:") {
+			return FormatMT942
+		}
+		return FormatMT940
+	}
+	return ""
+}
+
+// Verify checks that the opening balance plus the transactions equals the
+// closing balance when the statement carries both
+func (s *Statement) Verify() error {
+	if s.OpeningBalance == nil || s.ClosingBalance == nil {
+		return nil
+	}
+	balance := *s.OpeningBalance
+	for _, txn := range s.Transactions {
+		if txn.Status == models.TransactionStatusPending {
+			continue
+		}
+		if txn.Type == models.TransactionTypeDebit {
+			balance = balance.Sub(txn.Amount)
+		} else {
+			balance = balance.Add(txn.Amount)
+		}
+	}
+	if !balance.Equal(*s.ClosingBalance) {
+		return fmt.Errorf("statement %s for %s: opening balance and entries give %s, closing balance is %s", s.ID, s.Account, balance, s.ClosingBalance)
+	}
+	return nil
+}
+
+// add appends a transaction and widens the statement period to include it
+func (s *Statement) add(txn *models.Transaction) {
+	if txn.Status == "" {
+		txn.Status = models.TransactionStatusCompleted
+	}
+	txn.ReconcileStatus = models.ReconcileStatusPending
+	if s.StartDate.IsZero() || txn.CreatedAt.Before(s.StartDate) {
+		s.StartDate = txn.CreatedAt
+	}
+	if txn.CreatedAt.After(s.EndDate) {
+		s.EndDate = txn.CreatedAt
+	}
+	s.Transactions = append(s.Transactions, txn)
+}
+
+// finish stamps every transaction with the statement's account, currency
+// and an ID unique within the file. Parsers call it once the statement
+// header is complete, which in some formats follows the entries.
+func (s *Statement) finish() {
+	for i, txn := range s.Transactions {
+		txn.ID = fmt.Sprintf("%s-%s-%s-%d", s.Format, s.Account, s.ID, i+1)
+		txn.SourceAccount = s.Account
+		if txn.Currency == "" {
+			txn.Currency = s.Currency
+		}
+		if txn.Metadata == nil {
+			txn.Metadata = make(map[string]string)
+		}
+		txn.Metadata[MetaStatementFormat] = s.Format
+		txn.Metadata[MetaStatementID] = s.ID
+		for k, v := range txn.Metadata {
+			if v == "" {
+				delete(txn.Metadata, k)
+			}
+		}
+	}
+}
+
+// direction maps a credit flag to a transaction type
+func direction(credit bool) models.TransactionType {
+	if credit {
+		return models.TransactionTypeCredit
+	}
+	return models.TransactionTypeDebit
+}
+
+// signed returns a balance with a debit sign applied
+func signed(amount decimal.Decimal, credit bool) *decimal.Decimal {
+	if !credit {
+		amount = amount.Neg()
+	}
+	return &amount
+}
+
+// firstReference returns the first usable bank reference
+func firstReference(refs ...string) string {
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref != "" && !strings.EqualFold(ref, "NONREF") && !strings.EqualFold(ref, "NOTPROVIDED") {
+			return ref
+		}
+	}
+	return ""
+}
+
+// TransactionLookup returns internal transactions for an account in a period
+type TransactionLookup func(account string, start, end time.Time) []*models.Transaction
+
+// ReconcileStatements creates a batch for bank statements and reconciles
+// their transactions against the internal transactions lookup returns for
+// each statement's account and period, widened by the date tolerance
+func (e *Engine) ReconcileStatements(ctx context.Context, statements []*Statement, target string, lookup TransactionLookup) (*models.ReconciliationBatch, error) {
+	var sources []string
+	var bank, internal []*models.Transaction
+	seen := make(map[string]bool)
+	for _, s := range statements {
+		source := s.Format + ":" + s.Account
+		if !containsString(sources, source) {
+			sources = append(sources, source)
+		}
+		bank = append(bank, s.Transactions...)
+		if len(s.Transactions) == 0 {
+			continue
+		}
+		start := s.StartDate.Add(-e.config.DateTolerance)
+		end := s.EndDate.Add(e.config.DateTolerance + 24*time.Hour)
+		for _, txn := range lookup(s.Account, start, end) {
+			if !seen[txn.ID] {
+				seen[txn.ID] = true
+				internal = append(internal, txn)
+			}
+		}
+	}
+
+	batch := e.CreateBatch(strings.Join(sources, ","), target)
+	if err := e.Reconcile(ctx, batch.ID, bank, internal); err != nil {
+		return batch, err
+	}
+	batch, _ = e.GetBatch(batch.ID)
+	return batch, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatementAmount parses an amount written with either a comma or a
+// point as the decimal separator
+func parseStatementAmount(s string) (decimal.Decimal, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") && !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	s = strings.TrimSuffix(s, ".")
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid amount %q", s)
+	}
+	return d, nil
+}
+
+// readAll reads a statement file, stripping a UTF-8 byte order mark
+func readAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(data, []byte("\ufeff")), nil
+}
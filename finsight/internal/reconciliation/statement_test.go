@@ -0,0 +1,289 @@
+package reconciliation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+const mt940Sample = `{1:F01BANKNL2AXXXX0000000000}{2:I940BANKNL2AXXXXN}{4:
+:20:STMT240301
+:25:NL91ABNA0417164300
+:28C:00059/1
+:60F:C240229EUR1000,00
+:61:2403010301D250,00NTRFINV-1001//BR240301A
+PAYMENT SUPPLIER
+:86:Invoice 1001 ACME
+Supplies BV
+:61:2403010301C1500,50NMSCNONREF//BR240301B
+:86:?20Salary?21March
+:62F:C240301EUR2250,50
+-}`
+
+const mt942Sample = `{1:F01BANKNL2AXXXX0000000000}{2:I942BANKNL2AXXXXN}{4:
+:20:INTRADAY1
+:25:NL91ABNA0417164300
+:28C:1/1
+:34F:EUR0,
+:13D:2403011200+0100
+:61:2403010301C75,25NTRFREF-9
+:86:Incoming transfer
+-}`
+
+const camt053Sample = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <GrpHdr><MsgId>MSG1</MsgId></GrpHdr>
+    <Stmt>
+      <Id>STMT-2024-03-01</Id>
+      <Acct><Id><IBAN>DE89370400440532013000</IBAN></Id><Ccy>EUR</Ccy></Acct>
+      <Bal><Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">500.00</Amt><CdtDbtInd>CRDT</CdtDbtInd></Bal>
+      <Bal><Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">620.00</Amt><CdtDbtInd>CRDT</CdtDbtInd></Bal>
+      <Ntry>
+        <Amt Ccy="EUR">30.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2024-03-01</Dt></BookgDt>
+        <ValDt><Dt>2024-03-02</Dt></ValDt>
+        <AcctSvcrRef>SVC-1</AcctSvcrRef>
+        <BkTxCd><Domn><Cd>PMNT</Cd><Fmly><Cd>ICDT</Cd><SubFmlyCd>ESCT</SubFmlyCd></Fmly></Domn></BkTxCd>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>E2E-1</EndToEndId></Refs>
+          <RltdPties><Cdtr><Nm>Utility Co</Nm></Cdtr><CdtrAcct><Id><IBAN>DE02120300000000202051</IBAN></Id></CdtrAcct></RltdPties>
+          <RmtInf><Ustrd>Bill March</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">150.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><DtTm>2024-03-01T10:30:00+01:00</DtTm></BookgDt>
+        <AcctSvcrRef>SVC-2</AcctSvcrRef>
+        <NtryDtls>
+          <TxDtls><Refs><EndToEndId>E2E-2A</EndToEndId></Refs><Amt Ccy="EUR">100.00</Amt><RltdPties><Dbtr><Nm>Alice</Nm></Dbtr></RltdPties></TxDtls>
+          <TxDtls><Refs><EndToEndId>E2E-2B</EndToEndId></Refs><Amt Ccy="EUR">50.00</Amt><RltdPties><Dbtr><Nm>Bob</Nm></Dbtr></RltdPties></TxDtls>
+        </NtryDtls>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+const camt052Sample = `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.052.001.08">
+  <BkToCstmrAcctRpt>
+    <Rpt>
+      <Id>RPT-1</Id>
+      <Acct><Id><Othr><Id>123456789</Id></Othr></Id><Ccy>USD</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="USD">12.34</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts><Cd>PDNG</Cd></Sts>
+        <BookgDt><Dt>2024-03-04</Dt></BookgDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId>E2E-9</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+    </Rpt>
+  </BkToCstmrAcctRpt>
+</Document>`
+
+const bai2Sample = `01,122099999,123456789,240301,0200,1,80,,2/
+02,123456789,122099999,1,240301,0200,USD,2/
+03,0975312468,USD,010,500000,,,015,619500,,/
+16,165,120000,0,BR-1,CR-1,ACH CREDIT FROM ACME, INC/
+88,INVOICE 77
+16,475,500,V,240302,1200,BR-2,1042,CHECK PAID/
+49,1239500,4/
+98,1239500,1,6/
+99,1239500,1,8/`
+
+const ofxSample = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20240305120000</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>1<STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>121000248<ACCTID>000111222<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20240301<DTEND>20240305
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20240302120000.000[-5:EST]<TRNAMT>-42.10<FITID>FIT-1<NAME>Coffee &amp; Co<MEMO>Card 1234</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20240303<TRNAMT>1000.00<FITID>FIT-2<NAME>Payroll</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>2957.90<DTASOF>20240305</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+
+func parseSample(t *testing.T, format, data string) []*Statement {
+	t.Helper()
+	if got := DetectStatementFormat([]byte(data)); got != format {
+		t.Fatalf("detected %q, want %q", got, format)
+	}
+	statements, err := ParseStatements(format, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+	return statements
+}
+
+func TestParseMT940(t *testing.T) {
+	statements := parseSample(t, FormatMT940, mt940Sample)
+	if len(statements) != 1 {
+		t.Fatalf("expected one statement, got %d", len(statements))
+	}
+	s := statements[0]
+	if s.ID != "STMT240301" || s.Account != "NL91ABNA0417164300" || s.Currency != "EUR" || len(s.Transactions) != 2 {
+		t.Fatalf("unexpected statement %+v", s)
+	}
+	if err := s.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	debit, credit := s.Transactions[0], s.Transactions[1]
+	if debit.Type != models.TransactionTypeDebit || !debit.Amount.Equal(decimal.NewFromInt(250)) || debit.ExternalID != "BR240301A" {
+		t.Errorf("unexpected debit %+v", debit)
+	}
+	if debit.Description != "Invoice 1001 ACME Supplies BV" || debit.Metadata[MetaCustomerReference] != "INV-1001" || debit.Metadata[MetaBankTransactionCode] != "NTRF" {
+		t.Errorf("unexpected debit details %q %v", debit.Description, debit.Metadata)
+	}
+	if !debit.CreatedAt.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || debit.SourceAccount != s.Account || debit.Currency != "EUR" {
+		t.Errorf("unexpected debit header fields %+v", debit)
+	}
+	if credit.Type != models.TransactionTypeCredit || !credit.Amount.Equal(decimal.RequireFromString("1500.50")) || credit.ExternalID != "BR240301B" || credit.Description != "?20Salary?21March" {
+		t.Errorf("unexpected credit %+v", credit)
+	}
+	if debit.ID == credit.ID {
+		t.Error("transaction IDs must be unique")
+	}
+
+	interim := parseSample(t, FormatMT942, mt942Sample)
+	if interim[0].Format != FormatMT942 || interim[0].Currency != "EUR" || interim[0].Transactions[0].ExternalID != "REF-9" {
+		t.Errorf("unexpected MT942 report %+v", interim[0])
+	}
+}
+
+func TestParseCAMT(t *testing.T) {
+	s := parseSample(t, FormatCAMT053, camt053Sample)[0]
+	if s.Format != FormatCAMT053 || s.Account != "DE89370400440532013000" || len(s.Transactions) != 3 {
+		t.Fatalf("unexpected statement %+v", s)
+	}
+	if err := s.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	bill := s.Transactions[0]
+	if bill.ExternalID != "SVC-1" || bill.Type != models.TransactionTypeDebit || bill.Description != "Bill March" || bill.DestAccount != "DE02120300000000202051" {
+		t.Errorf("unexpected entry %+v", bill)
+	}
+	if bill.Metadata[MetaCounterparty] != "Utility Co" || bill.Metadata[MetaBankTransactionCode] != "PMNT/ICDT/ESCT" || bill.Metadata[MetaValueDate] != "2024-03-02" {
+		t.Errorf("unexpected entry metadata %v", bill.Metadata)
+	}
+
+	// The batched credit is split into its two transactions
+	alice, bob := s.Transactions[1], s.Transactions[2]
+	if alice.ExternalID != "E2E-2A" || !alice.Amount.Equal(decimal.NewFromInt(100)) || alice.Metadata[MetaCounterparty] != "Alice" {
+		t.Errorf("unexpected split transaction %+v", alice)
+	}
+	if bob.ExternalID != "E2E-2B" || !bob.Amount.Equal(decimal.NewFromInt(50)) || !bob.CreatedAt.Equal(time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected split transaction %+v", bob)
+	}
+
+	report := parseSample(t, FormatCAMT052, camt052Sample)[0]
+	if report.Format != FormatCAMT052 || report.Account != "123456789" || report.Transactions[0].Status != models.TransactionStatusPending || report.Transactions[0].ExternalID != "E2E-9" {
+		t.Errorf("unexpected report %+v", report.Transactions[0])
+	}
+}
+
+func TestParseBAI2(t *testing.T) {
+	s := parseSample(t, FormatBAI2, bai2Sample)[0]
+	if s.Account != "0975312468" || s.Currency != "USD" || len(s.Transactions) != 2 {
+		t.Fatalf("unexpected statement %+v", s)
+	}
+	if !s.OpeningBalance.Equal(decimal.NewFromInt(5000)) || !s.ClosingBalance.Equal(decimal.NewFromInt(6195)) {
+		t.Errorf("unexpected balances %s %s", s.OpeningBalance, s.ClosingBalance)
+	}
+	if err := s.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	credit, check := s.Transactions[0], s.Transactions[1]
+	if credit.Type != models.TransactionTypeCredit || !credit.Amount.Equal(decimal.NewFromInt(1200)) || credit.ExternalID != "BR-1" {
+		t.Errorf("unexpected credit %+v", credit)
+	}
+	if credit.Description != "ACH CREDIT FROM ACME, INC INVOICE 77" {
+		t.Errorf("expected the continued text, got %q", credit.Description)
+	}
+	if check.Type != models.TransactionTypeDebit || !check.Amount.Equal(decimal.NewFromInt(5)) || check.ExternalID != "BR-2" || check.Metadata[MetaValueDate] != "2024-03-02" || check.Description != "CHECK PAID" || check.Metadata[MetaCustomerReference] != "1042" {
+		t.Errorf("unexpected check %+v", check)
+	}
+}
+
+func TestParseOFX(t *testing.T) {
+	s := parseSample(t, FormatOFX, ofxSample)[0]
+	if s.Account != "000111222" || s.Currency != "USD" || len(s.Transactions) != 2 || !s.ClosingBalance.Equal(decimal.RequireFromString("2957.90")) {
+		t.Fatalf("unexpected statement %+v", s)
+	}
+	coffee := s.Transactions[0]
+	if coffee.Type != models.TransactionTypeDebit || !coffee.Amount.Equal(decimal.RequireFromString("42.10")) || coffee.ExternalID != "FIT-1" {
+		t.Errorf("unexpected transaction %+v", coffee)
+	}
+	if coffee.Description != "Coffee & Co Card 1234" || !coffee.CreatedAt.Equal(time.Date(2024, 3, 2, 17, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected description or time %q %s", coffee.Description, coffee.CreatedAt)
+	}
+	if payroll := s.Transactions[1]; payroll.Type != models.TransactionTypeCredit || payroll.ExternalID != "FIT-2" {
+		t.Errorf("unexpected transaction %+v", payroll)
+	}
+}
+
+func TestStatementErrors(t *testing.T) {
+	if _, err := ParseStatements("csv", strings.NewReader("")); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if _, err := ParseStatements(FormatMT940, strings.NewReader(":20:X\n:61:bad\n")); err == nil {
+		t.Error("expected an error for a malformed statement line")
+	}
+	if DetectStatementFormat([]byte("hello")) != "" {
+		t.Error("expected no format for unrecognized data")
+	}
+
+	s := parseSample(t, FormatMT940, strings.Replace(mt940Sample, ":62F:C240301EUR2250,50", ":62F:C240301EUR2250,00", 1))[0]
+	if err := s.Verify(); err == nil {
+		t.Error("expected a balance mismatch")
+	}
+}
+
+func TestReconcileStatements(t *testing.T) {
+	engine := NewEngine(&config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour})
+	statements := parseSample(t, FormatMT940, mt940Sample)
+
+	var requested string
+	lookup := func(account string, start, end time.Time) []*models.Transaction {
+		requested = account
+		if !start.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected lookup window %s - %s", start, end)
+		}
+		return []*models.Transaction{{
+			ID:         "ledger-1",
+			ExternalID: "BR240301A",
+			Type:       models.TransactionTypeDebit,
+			Status:     models.TransactionStatusCompleted,
+			Amount:     decimal.NewFromInt(250),
+			Currency:   "EUR",
+			CreatedAt:  time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+		}}
+	}
+
+	batch, err := engine.ReconcileStatements(context.Background(), statements, "ledger", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested != "NL91ABNA0417164300" || batch.Source != "mt940:NL91ABNA0417164300" || batch.Target != "ledger" {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+	if batch.Status != models.BatchStatusCompleted || batch.TotalRecords != 2 || batch.MatchedRecords != 1 || batch.UnmatchedRecords != 1 {
+		t.Errorf("unexpected batch results %+v", batch)
+	}
+}
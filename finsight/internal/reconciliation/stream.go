@@ -0,0 +1,394 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// TransactionStream yields transactions one at a time. Next returns io.EOF
+// after the last one.
+type TransactionStream interface {
+	Next() (*models.Transaction, error)
+}
+
+type sliceStream struct {
+	txns []*models.Transaction
+	pos  int
+}
+
+// SliceStream streams the transactions of a slice
+func SliceStream(txns []*models.Transaction) TransactionStream {
+	return &sliceStream{txns: txns}
+}
+
+func (s *sliceStream) Next() (*models.Transaction, error) {
+	if s.pos >= len(s.txns) {
+		return nil, io.EOF
+	}
+	s.pos++
+	return s.txns[s.pos-1], nil
+}
+
+type jsonStream struct {
+	dec *json.Decoder
+}
+
+// NewJSONStream streams transactions from a sequence of JSON objects, such
+// as one transaction per line
+func NewJSONStream(r io.Reader) TransactionStream {
+	return &jsonStream{dec: json.NewDecoder(r)}
+}
+
+func (s *jsonStream) Next() (*models.Transaction, error) {
+	var txn models.Transaction
+	if err := s.dec.Decode(&txn); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+	return &txn, nil
+}
+
+// StreamOptions configures a streaming reconciliation
+type StreamOptions struct {
+	// Workers match source records in parallel; zero uses every CPU
+	Workers int
+	// CheckpointEvery saves progress after this many source records; zero
+	// saves only when the stream stops early
+	CheckpointEvery int
+	// Checkpoints persists progress; nil disables checkpointing
+	Checkpoints CheckpointStore
+}
+
+type streamJob struct {
+	seq    int
+	source *models.Transaction
+}
+
+type streamResult struct {
+	seq    int
+	source *models.Transaction
+	target *models.Transaction
+	result *MatchResult
+}
+
+// streamRun is the progress of a streaming reconciliation, applied in
+// stream order so it can be checkpointed as a prefix of the stream
+type streamRun struct {
+	batchID    string
+	processed  int
+	matched    int
+	claimed    []string
+	unmatched  []*models.Transaction
+	exceptions []*models.ReconcileException
+}
+
+// ReconcileStream reconciles a stream of source transactions against the
+// target transactions, which are indexed in memory. Workers match sources
+// in parallel, each claiming its target so no target matches twice;
+// results are applied in stream order. With a checkpoint store, progress
+// is saved every CheckpointEvery sources and when the stream stops early,
+// and a batch with a checkpoint resumes from it, skipping the sources
+// already processed. Records left unmatched are then group matched.
+func (e *Engine) ReconcileStream(ctx context.Context, batchID string, sources TransactionStream, targetTransactions []*models.Transaction, opts StreamOptions) error {
+	var cp *Checkpoint
+	if opts.Checkpoints != nil {
+		loaded, ok, err := opts.Checkpoints.Load(batchID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			cp = loaded
+		}
+	}
+
+	run := &streamRun{batchID: batchID}
+	if err := e.startStream(run, cp); err != nil {
+		return err
+	}
+
+	// Index target transactions for faster lookup
+	targetIndex := e.buildIndex(targetTransactions)
+
+	if cp != nil {
+		for _, id := range cp.Claimed {
+			if entry, ok := targetIndex.byID[id]; ok {
+				entry.claimed.Store(true)
+			}
+		}
+		for i := 0; i < cp.Processed; i++ {
+			if _, err := sources.Next(); err != nil {
+				if err == io.EOF {
+					err = fmt.Errorf("source stream ended after %d of %d checkpointed records", i, cp.Processed)
+				}
+				e.failBatch(batchID)
+				return err
+			}
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan streamJob, workers*64)
+	results := make(chan streamResult, workers*64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				target, result := e.matchSource(job.source, targetIndex)
+				results <- streamResult{seq: job.seq, source: job.source, target: target, result: result}
+			}
+		}()
+	}
+
+	// Read sources until the stream ends, fails or is cancelled
+	var readErr error
+	go func() {
+		defer close(jobs)
+		for seq := run.processed; ; seq++ {
+			if err := ctx.Err(); err != nil {
+				readErr = err
+				return
+			}
+			txn, err := sources.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			select {
+			case jobs <- streamJob{seq: seq, source: txn}:
+			case <-ctx.Done():
+				readErr = ctx.Err()
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Apply results in stream order
+	pending := make(map[int]streamResult)
+	for r := range results {
+		pending[r.seq] = r
+		for {
+			next, ok := pending[run.processed]
+			if !ok {
+				break
+			}
+			delete(pending, run.processed)
+			e.applyStreamResult(run, next)
+			if opts.Checkpoints != nil && opts.CheckpointEvery > 0 && run.processed%opts.CheckpointEvery == 0 {
+				if err := opts.Checkpoints.Save(e.checkpoint(run)); err != nil {
+					log.Printf("reconciliation: checkpoint of batch %s: %v", batchID, err)
+				}
+			}
+		}
+	}
+
+	if readErr != nil {
+		if opts.Checkpoints != nil {
+			if err := opts.Checkpoints.Save(e.checkpoint(run)); err != nil {
+				log.Printf("reconciliation: checkpoint of batch %s: %v", batchID, err)
+			}
+		}
+		if ctx.Err() == nil {
+			e.failBatch(batchID)
+		}
+		return readErr
+	}
+
+	// Match what is left against groups of records on the other side
+	unmatchedSources := e.matchGroups(batchID, run.unmatched, targetIndex)
+
+	for _, sourceTxn := range unmatchedSources {
+		sourceTxn.ReconcileStatus = models.ReconcileStatusUnmatched
+		e.createException(batchID, models.ExceptionTypeMissing, sourceTxn, nil, Difference{
+			Field:    "record",
+			Source:   sourceTxn.ID,
+			Severity: "error",
+		})
+		e.updateBatchProgress(batchID, false)
+	}
+
+	// Check for unmatched target transactions
+	for _, targetTxn := range targetIndex.remaining() {
+		e.createException(batchID, models.ExceptionTypeMissing, nil, targetTxn, Difference{
+			Field:    "record",
+			Target:   targetTxn.ID,
+			Severity: "error",
+		})
+	}
+
+	// Complete batch
+	e.completeBatch(batchID)
+
+	if opts.Checkpoints != nil {
+		if err := opts.Checkpoints.Delete(batchID); err != nil {
+			log.Printf("reconciliation: %v", err)
+		}
+	}
+	return nil
+}
+
+// startStream marks a batch running, restoring its progress from a
+// checkpoint. A checkpointed batch unknown to the engine, as after a
+// restart, is recreated.
+func (e *Engine) startStream(run *streamRun, cp *Checkpoint) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	batch, ok := e.batches[run.batchID]
+	if !ok {
+		if cp == nil {
+			return ErrBatchNotFound
+		}
+		batch = &models.ReconciliationBatch{
+			ID:        cp.BatchID,
+			Source:    cp.Source,
+			Target:    cp.Target,
+			StartedAt: cp.StartedAt,
+		}
+		e.batches[batch.ID] = batch
+	}
+	batch.Status = models.BatchStatusRunning
+	batch.TotalRecords = 0
+	if cp == nil {
+		return nil
+	}
+
+	// Progress after the checkpoint is redone, so drop it
+	for id, exc := range e.exceptions {
+		if exc.BatchID == batch.ID {
+			delete(e.exceptions, id)
+		}
+	}
+	for _, exc := range cp.Exceptions {
+		e.exceptions[exc.ID] = exc
+	}
+	delete(e.groups, batch.ID)
+	batch.TotalRecords = cp.Processed
+	batch.MatchedRecords = cp.Matched
+	batch.UnmatchedRecords = 0
+	batch.Exceptions = len(cp.Exceptions)
+
+	run.processed = cp.Processed
+	run.matched = cp.Matched
+	run.claimed = cp.Claimed
+	run.unmatched = cp.Unmatched
+	run.exceptions = cp.Exceptions
+	return nil
+}
+
+// matchSource finds the best unmatched target for a source and claims it.
+// Referenced targets are tried first: an exact match cannot be bettered,
+// so the range query is skipped. When another worker claims the target
+// first the search is repeated.
+func (e *Engine) matchSource(source *models.Transaction, index *TransactionIndex) (*models.Transaction, *MatchResult) {
+	for {
+		bestMatch, bestResult := e.bestCandidate(source, e.referencedEntries(source, index))
+		if bestResult == nil || bestResult.Confidence < 1 {
+			bestMatch, bestResult = e.bestCandidate(source, e.candidateEntries(source, index))
+		}
+
+		if bestMatch == nil {
+			return nil, nil
+		}
+		if index.claim(bestMatch) {
+			return bestMatch.txn, bestResult
+		}
+	}
+}
+
+// bestCandidate returns the candidate with the most confident match
+func (e *Engine) bestCandidate(source *models.Transaction, candidates []*indexEntry) (*indexEntry, *MatchResult) {
+	var bestMatch *indexEntry
+	var bestResult *MatchResult
+
+	// Try to find a match using all matchers
+	for _, matcher := range e.matchers {
+		for _, candidate := range candidates {
+			result := matcher.Match(source, candidate.txn)
+			if result.Matched {
+				if bestResult == nil || result.Confidence > bestResult.Confidence {
+					bestResult = result
+					bestMatch = candidate
+				}
+			}
+		}
+	}
+	return bestMatch, bestResult
+}
+
+func (e *Engine) applyStreamResult(run *streamRun, r streamResult) {
+	e.mu.Lock()
+	if batch, ok := e.batches[run.batchID]; ok {
+		batch.TotalRecords++
+	}
+	e.mu.Unlock()
+	run.processed++
+
+	if r.target == nil {
+		run.unmatched = append(run.unmatched, r.source)
+		return
+	}
+
+	r.source.ReconcileStatus = models.ReconcileStatusMatched
+
+	// Check for differences
+	for _, diff := range r.result.Differences {
+		if diff.Severity == "error" {
+			run.exceptions = append(run.exceptions, e.createException(run.batchID, models.ExceptionTypeAmountDiff, r.source, r.target, diff))
+		}
+	}
+
+	e.updateBatchProgress(run.batchID, true)
+	run.matched++
+	run.claimed = append(run.claimed, r.target.ID)
+}
+
+func (e *Engine) checkpoint(run *streamRun) *Checkpoint {
+	cp := &Checkpoint{
+		BatchID:    run.batchID,
+		Processed:  run.processed,
+		Matched:    run.matched,
+		Claimed:    run.claimed,
+		Unmatched:  run.unmatched,
+		Exceptions: run.exceptions,
+		UpdatedAt:  time.Now(),
+	}
+	e.mu.RLock()
+	if batch, ok := e.batches[run.batchID]; ok {
+		cp.Source = batch.Source
+		cp.Target = batch.Target
+		cp.StartedAt = batch.StartedAt
+	}
+	e.mu.RUnlock()
+	return cp
+}
+
+func (e *Engine) failBatch(batchID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if batch, ok := e.batches[batchID]; ok {
+		batch.Status = models.BatchStatusFailed
+	}
+}
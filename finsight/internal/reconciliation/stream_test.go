@@ -0,0 +1,140 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// streamFixture builds n targets a few days apart with their sources:
+// every third matched by external ID, the rest by a slightly different
+// amount and time, and every tenth source with no target at all
+func streamFixture(n int) (sources, targets []*models.Transaction) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		at := base.Add(time.Duration(i) * 72 * time.Hour)
+		amount := decimal.NewFromInt(int64(1000 + i*7)).Shift(-1)
+		target := &models.Transaction{ID: fmt.Sprintf("tgt-%d", i), Amount: amount, CreatedAt: at}
+		source := &models.Transaction{ID: fmt.Sprintf("src-%d", i), Amount: amount, CreatedAt: at}
+		switch {
+		case i%10 == 9:
+			source.CreatedAt = base.AddDate(-5, 0, 0)
+		case i%3 == 0:
+			target.ExternalID = fmt.Sprintf("EXT-%d", i)
+			source.ExternalID = target.ExternalID
+		default:
+			source.Amount = amount.Mul(decimal.RequireFromString("1.002"))
+			source.CreatedAt = at.Add(2 * time.Hour)
+		}
+		sources = append(sources, source)
+		targets = append(targets, target)
+	}
+	return sources, targets
+}
+
+func TestReconcileStream_Parallel(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			engine := NewEngine(&config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour})
+			sources, targets := streamFixture(500)
+			batch := engine.CreateBatch("source", "target")
+
+			if err := engine.ReconcileStream(context.Background(), batch.ID, SliceStream(sources), targets, StreamOptions{Workers: workers}); err != nil {
+				t.Fatal(err)
+			}
+			if batch.TotalRecords != 500 || batch.MatchedRecords != 450 || batch.UnmatchedRecords != 50 {
+				t.Errorf("unexpected batch %+v", batch)
+			}
+			// One exception for each unmatched source and target
+			if batch.Exceptions != 100 {
+				t.Errorf("expected 100 exceptions, got %d", batch.Exceptions)
+			}
+		})
+	}
+}
+
+// failingStream fails after a number of transactions, like a dropped
+// connection
+type failingStream struct {
+	TransactionStream
+	left int
+}
+
+func (s *failingStream) Next() (*models.Transaction, error) {
+	if s.left == 0 {
+		return nil, errors.New("connection reset")
+	}
+	s.left--
+	return s.TransactionStream.Next()
+}
+
+func TestReconcileStream_ResumeFromCheckpoint(t *testing.T) {
+	cfg := &config.ReconciliationConfig{MatchTolerance: 0.01, DateTolerance: 24 * time.Hour}
+	store, err := NewFileCheckpointStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := StreamOptions{Workers: 4, CheckpointEvery: 50, Checkpoints: store}
+
+	engine := NewEngine(cfg)
+	batch := engine.CreateBatch("source", "target")
+	sources, targets := streamFixture(300)
+	err = engine.ReconcileStream(context.Background(), batch.ID, &failingStream{TransactionStream: SliceStream(sources), left: 175}, targets, opts)
+	if err == nil || batch.Status != models.BatchStatusFailed {
+		t.Fatalf("expected the stream failure, got %v with status %s", err, batch.Status)
+	}
+	cp, ok, err := store.Load(batch.ID)
+	if err != nil || !ok || cp.Processed != 175 || len(cp.Claimed) != cp.Matched {
+		t.Fatalf("expected a checkpoint of 175 records, got %+v %v", cp, err)
+	}
+
+	// A new engine, as after a restart, picks the batch up from the
+	// checkpoint with the full stream
+	restarted := NewEngine(cfg)
+	sources, targets = streamFixture(300)
+	if err := restarted.ReconcileStream(context.Background(), batch.ID, SliceStream(sources), targets, opts); err != nil {
+		t.Fatal(err)
+	}
+	resumed, ok := restarted.GetBatch(batch.ID)
+	if !ok || resumed.Status != models.BatchStatusCompleted || resumed.Source != "source" {
+		t.Fatalf("unexpected resumed batch %+v", resumed)
+	}
+	if resumed.TotalRecords != 300 || resumed.MatchedRecords != 270 || resumed.UnmatchedRecords != 30 || resumed.Exceptions != 60 {
+		t.Errorf("unexpected totals %+v", resumed)
+	}
+	if _, ok, _ := store.Load(batch.ID); ok {
+		t.Error("expected the checkpoint to be removed once the batch completed")
+	}
+}
+
+func TestJSONStream(t *testing.T) {
+	stream := NewJSONStream(strings.NewReader(`{"id":"a","amount":"1.50"}
+{"id":"b","amount":"2"}
+`))
+	var ids []string
+	for {
+		txn, err := stream.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		ids = append(ids, txn.ID)
+	}
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("unexpected transactions %v", ids)
+	}
+
+	if _, err := NewJSONStream(strings.NewReader(`{"id":`)).Next(); err == nil {
+		t.Error("expected an error for a truncated transaction")
+	}
+}
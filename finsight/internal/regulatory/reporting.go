@@ -6,13 +6,14 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/savegress/finsight/internal/approval"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
 )
@@ -53,6 +54,7 @@ type RegulatoryReport struct {
 	Name            string                 `json:"name"`
 	Description     string                 `json:"description,omitempty"`
 	Period          *ReportPeriod          `json:"period"`
+	EntityID        string                 `json:"entity_id,omitempty"` // RSSD ID of the reporting institution
 	Status          ReportStatus           `json:"status"`
 	Data            map[string]interface{} `json:"data,omitempty"`
 	Sections        []ReportSection        `json:"sections,omitempty"`
@@ -130,6 +132,8 @@ type Engine struct {
 	validators  map[ReportType][]ValidationRule
 	mu          sync.RWMutex
 	outputDir   string
+	entityID    string
+	approvals   *approval.Workflow
 }
 
 // ReportTemplate defines a report structure template
@@ -139,6 +143,7 @@ type ReportTemplate struct {
 	Description string          `json:"description"`
 	Sections    []SectionTemplate `json:"sections"`
 	Validations []ValidationRule  `json:"validations"`
+	Taxonomy    *Taxonomy         `json:"taxonomy,omitempty"`
 }
 
 // SectionTemplate defines a section template
@@ -151,10 +156,11 @@ type SectionTemplate struct {
 
 // ItemTemplate defines an item template
 type ItemTemplate struct {
-	LineNumber  string `json:"line_number"`
-	Description string `json:"description"`
-	Formula     string `json:"formula,omitempty"`
-	DataSource  string `json:"data_source,omitempty"`
+	LineNumber  string          `json:"line_number"`
+	Description string          `json:"description"`
+	Formula     string          `json:"formula,omitempty"`
+	DataSource  string          `json:"data_source,omitempty"`
+	Concept     *ConceptMapping `json:"concept,omitempty"`
 }
 
 // ValidationRule defines a validation rule
@@ -184,16 +190,22 @@ func (e *Engine) initializeTemplates() {
 		Type:        ReportTypeCallReport,
 		Name:        "FFIEC Call Report",
 		Description: "Consolidated Reports of Condition and Income",
+		Taxonomy: &Taxonomy{
+			Name:         "FFIEC Call Report",
+			SchemaRef:    "http://www.ffiec.gov/xbrl/call/concepts/concepts.xsd",
+			Namespaces:   map[string]string{"cc": "http://www.ffiec.gov/xbrl/call/concepts"},
+			EntityScheme: "http://www.ffiec.gov/cdr",
+		},
 		Sections: []SectionTemplate{
 			{
 				ID:   "rc",
 				Name: "Consolidated Report of Condition",
 				Code: "RC",
 				Items: []ItemTemplate{
-					{LineNumber: "RCFD0010", Description: "Total Cash and Balances Due From Depository Institutions"},
-					{LineNumber: "RCFD0071", Description: "Interest-Bearing Balances"},
-					{LineNumber: "RCFD1754", Description: "Securities: Held-to-Maturity"},
-					{LineNumber: "RCFD1773", Description: "Securities: Available-for-Sale"},
+					{LineNumber: "RCFD0010", Description: "Total Cash and Balances Due From Depository Institutions", Concept: instantUSD("cc:RCFD0010", true)},
+					{LineNumber: "RCFD0071", Description: "Interest-Bearing Balances", Concept: instantUSD("cc:RCFD0071", false)},
+					{LineNumber: "RCFD1754", Description: "Securities: Held-to-Maturity", Concept: instantUSD("cc:RCFD1754", false)},
+					{LineNumber: "RCFD1773", Description: "Securities: Available-for-Sale", Concept: instantUSD("cc:RCFD1773", false)},
 				},
 			},
 			{
@@ -201,9 +213,50 @@ func (e *Engine) initializeTemplates() {
 				Name: "Consolidated Report of Income",
 				Code: "RI",
 				Items: []ItemTemplate{
-					{LineNumber: "RIAD4107", Description: "Total Interest Income"},
-					{LineNumber: "RIAD4073", Description: "Total Interest Expense"},
-					{LineNumber: "RIAD4074", Description: "Net Interest Income"},
+					{LineNumber: "RIAD4107", Description: "Total Interest Income", Concept: yearToDateUSD("cc:RIAD4107", true)},
+					{LineNumber: "RIAD4073", Description: "Total Interest Expense", Concept: yearToDateUSD("cc:RIAD4073", true)},
+					{LineNumber: "RIAD4074", Description: "Net Interest Income", Concept: yearToDateUSD("cc:RIAD4074", true)},
+				},
+			},
+		},
+	}
+
+	// Initialize FR Y-9C template
+	e.templates[ReportTypeFRY9C] = &ReportTemplate{
+		Type:        ReportTypeFRY9C,
+		Name:        "FR Y-9C",
+		Description: "Consolidated Financial Statements for Holding Companies",
+		Taxonomy: &Taxonomy{
+			Name:         "FR Y-9C",
+			SchemaRef:    "http://www.federalreserve.gov/xbrl/fry9c/concepts/concepts.xsd",
+			Namespaces:   map[string]string{"bhc": "http://www.federalreserve.gov/xbrl/fry9c/concepts"},
+			EntityScheme: "http://www.federalreserve.gov/rssd",
+		},
+		Sections: []SectionTemplate{
+			{
+				ID:   "hc",
+				Name: "Consolidated Balance Sheet",
+				Code: "HC",
+				Items: []ItemTemplate{
+					{LineNumber: "BHCK0081", Description: "Noninterest-Bearing Balances and Currency and Coin", Concept: instantUSD("bhc:BHCK0081", false)},
+					{LineNumber: "BHCK0395", Description: "Interest-Bearing Balances in U.S. Offices", Concept: instantUSD("bhc:BHCK0395", false)},
+					{LineNumber: "BHCK0397", Description: "Interest-Bearing Balances in Foreign Offices", Concept: instantUSD("bhc:BHCK0397", false)},
+					{LineNumber: "BHCK1754", Description: "Securities: Held-to-Maturity", Concept: instantUSD("bhc:BHCK1754", false)},
+					{LineNumber: "BHCK1773", Description: "Securities: Available-for-Sale", Concept: instantUSD("bhc:BHCK1773", false)},
+					{LineNumber: "BHCK2170", Description: "Total Assets", Concept: instantUSD("bhc:BHCK2170", true)},
+					{LineNumber: "BHCK2948", Description: "Total Liabilities", Concept: instantUSD("bhc:BHCK2948", true)},
+					{LineNumber: "BHCK3210", Description: "Total Holding Company Equity Capital", Concept: instantUSD("bhc:BHCK3210", true)},
+				},
+			},
+			{
+				ID:   "hi",
+				Name: "Consolidated Income Statement",
+				Code: "HI",
+				Items: []ItemTemplate{
+					{LineNumber: "BHCK4107", Description: "Total Interest Income", Concept: yearToDateUSD("bhc:BHCK4107", true)},
+					{LineNumber: "BHCK4073", Description: "Total Interest Expense", Concept: yearToDateUSD("bhc:BHCK4073", true)},
+					{LineNumber: "BHCK4074", Description: "Net Interest Income", Concept: yearToDateUSD("bhc:BHCK4074", true)},
+					{LineNumber: "BHCK4340", Description: "Net Income", Concept: yearToDateUSD("bhc:BHCK4340", true)},
 				},
 			},
 		},
@@ -277,6 +330,22 @@ func (e *Engine) initializeTemplates() {
 	}
 }
 
+// SetReportingEntity sets the RSSD ID recorded on new reports and used as
+// the XBRL entity identifier
+func (e *Engine) SetReportingEntity(entityID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.entityID = entityID
+}
+
+// GetTemplate returns the template for a report type
+func (e *Engine) GetTemplate(reportType ReportType) (*ReportTemplate, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	template, ok := e.templates[reportType]
+	return template, ok
+}
+
 // CreateReport creates a new regulatory report
 func (e *Engine) CreateReport(reportType ReportType, period *ReportPeriod, preparedBy string) (*RegulatoryReport, error) {
 	e.mu.Lock()
@@ -293,6 +362,7 @@ func (e *Engine) CreateReport(reportType ReportType, period *ReportPeriod, prepa
 		Name:        template.Name,
 		Description: template.Description,
 		Period:      period,
+		EntityID:    e.entityID,
 		Status:      ReportStatusDraft,
 		PreparedBy:  preparedBy,
 		CreatedAt:   time.Now(),
@@ -566,13 +636,27 @@ func (e *Engine) evaluateValidationRule(report *RegulatoryReport, rule Validatio
 	}
 }
 
-// SubmitForReview submits a report for review
-func (e *Engine) SubmitForReview(reportID string) error {
+// ApprovalActionReport is the approval action of reports submitted for
+// review
+const ApprovalActionReport = "regulatory_report_approval"
+
+// SetApprovals routes report approval through a maker-checker workflow: a
+// report in review is approved once its policy's approvers have approved
+// it, and returns to draft when rejected
+func (e *Engine) SetApprovals(w *approval.Workflow) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
+	e.approvals = w
+	e.mu.Unlock()
+	w.Register(ApprovalActionReport, e.applyReportApproval, e.applyReportRejection)
+}
 
+// SubmitForReview submits a report for review, opening its approval request
+// when an approval workflow is set
+func (e *Engine) SubmitForReview(reportID string) error {
+	e.mu.Lock()
 	report, ok := e.reports[reportID]
 	if !ok {
+		e.mu.Unlock()
 		return fmt.Errorf("report not found: %s", reportID)
 	}
 
@@ -586,34 +670,107 @@ func (e *Engine) SubmitForReview(reportID string) error {
 	}
 
 	if hasErrors {
+		e.mu.Unlock()
 		return fmt.Errorf("report has validation errors")
 	}
 
+	approvals := e.approvals
+	if approvals != nil && report.PreparedBy == "" {
+		e.mu.Unlock()
+		return fmt.Errorf("report must have a preparer to submit")
+	}
+	previous := report.Status
 	report.Status = ReportStatusReview
 	report.UpdatedAt = time.Now()
+	preparedBy := report.PreparedBy
+	summary := report.Name
+	details := map[string]interface{}{"type": string(report.Type)}
+	if report.Period != nil {
+		details["period"] = report.Period
+	}
+	e.mu.Unlock()
+
+	if approvals == nil {
+		return nil
+	}
+	// The workflow calls back into the engine, so it is opened unlocked
+	if _, err := approvals.Open(ApprovalActionReport, reportID, preparedBy, summary, details); err != nil {
+		e.mu.Lock()
+		report.Status = previous
+		e.mu.Unlock()
+		return err
+	}
 	return nil
 }
 
-// ApproveReport approves a report
+// ApproveReport records an approver's approval of a report in review. The
+// preparer cannot approve their own report. With an approval workflow set,
+// the report is approved once its policy is satisfied.
 func (e *Engine) ApproveReport(reportID, approver string) error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	report, ok := e.reports[reportID]
 	if !ok {
+		e.mu.Unlock()
 		return fmt.Errorf("report not found: %s", reportID)
 	}
-
 	if report.Status != ReportStatusReview {
+		e.mu.Unlock()
 		return fmt.Errorf("report must be in review status to approve")
 	}
+	approvals := e.approvals
+	if approvals == nil {
+		defer e.mu.Unlock()
+		if approver == "" {
+			return approval.ErrApproverRequired
+		}
+		if approver == report.PreparedBy {
+			return approval.ErrSelfApproval
+		}
+		report.Status = ReportStatusApproved
+		report.ApprovedBy = approver
+		report.UpdatedAt = time.Now()
+		return nil
+	}
+	e.mu.Unlock()
+
+	_, err := approvals.ApproveEntity(ApprovalActionReport, reportID, approver, "")
+	return err
+}
+
+func (e *Engine) applyReportApproval(req *approval.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
+	report, ok := e.reports[req.EntityID]
+	if !ok {
+		return fmt.Errorf("report not found: %s", req.EntityID)
+	}
+	if report.Status != ReportStatusReview {
+		return fmt.Errorf("report must be in review status to approve")
+	}
 	report.Status = ReportStatusApproved
-	report.ApprovedBy = approver
+	report.ApprovedBy = strings.Join(req.Approvers(), ", ")
 	report.UpdatedAt = time.Now()
 	return nil
 }
 
+// applyReportRejection returns a rejected report to its preparer as a draft
+func (e *Engine) applyReportRejection(req *approval.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report, ok := e.reports[req.EntityID]
+	if !ok {
+		return fmt.Errorf("report not found: %s", req.EntityID)
+	}
+	if report.Status == ReportStatusReview {
+		report.Status = ReportStatusDraft
+		report.ReviewedBy = req.Rejection.Actor
+		report.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
 // FileReport files a report with the regulatory body
 func (e *Engine) FileReport(ctx context.Context, reportID string) error {
 	e.mu.Lock()
@@ -648,8 +805,15 @@ func (e *Engine) ExportReport(ctx context.Context, reportID string, format Expor
 		return "", fmt.Errorf("report not found: %s", reportID)
 	}
 
-	filename := fmt.Sprintf("%s_%s_%d.%s", report.Type, report.ID, time.Now().Unix(), format)
+	ext := string(format)
+	if format == ExportFormatInlineXBRL {
+		ext = "xhtml"
+	}
+	filename := fmt.Sprintf("%s_%s_%d.%s", report.Type, report.ID, time.Now().Unix(), ext)
 	filepath := filepath.Join(e.outputDir, filename)
+	if err := os.MkdirAll(e.outputDir, 0o755); err != nil {
+		return "", fmt.Errorf("create output directory: %w", err)
+	}
 
 	var err error
 	switch format {
@@ -660,7 +824,9 @@ func (e *Engine) ExportReport(ctx context.Context, reportID string, format Expor
 	case ExportFormatXML:
 		err = e.exportXML(report, filepath)
 	case ExportFormatXBRL:
-		err = e.exportXBRL(report, filepath)
+		err = e.exportXBRL(report, filepath, BuildXBRLInstance)
+	case ExportFormatInlineXBRL:
+		err = e.exportXBRL(report, filepath, BuildInlineXBRL)
 	default:
 		return "", fmt.Errorf("unsupported export format: %s", format)
 	}
@@ -680,11 +846,12 @@ func (e *Engine) ExportReport(ctx context.Context, reportID string, format Expor
 type ExportFormat string
 
 const (
-	ExportFormatJSON ExportFormat = "json"
-	ExportFormatCSV  ExportFormat = "csv"
-	ExportFormatXML  ExportFormat = "xml"
-	ExportFormatXBRL ExportFormat = "xbrl"
-	ExportFormatPDF  ExportFormat = "pdf"
+	ExportFormatJSON       ExportFormat = "json"
+	ExportFormatCSV        ExportFormat = "csv"
+	ExportFormatXML        ExportFormat = "xml"
+	ExportFormatXBRL       ExportFormat = "xbrl"
+	ExportFormatInlineXBRL ExportFormat = "ixbrl"
+	ExportFormatPDF        ExportFormat = "pdf"
 )
 
 func (e *Engine) exportJSON(report *RegulatoryReport, path string) error {
@@ -740,33 +907,14 @@ func (e *Engine) exportXML(report *RegulatoryReport, path string) error {
 	return encoder.Encode(report)
 }
 
-func (e *Engine) exportXBRL(report *RegulatoryReport, path string) error {
-	// XBRL export would require proper taxonomy handling
-	// Simplified version using XML structure
-	file, err := os.Create(path)
+func (e *Engine) exportXBRL(report *RegulatoryReport, path string, build func(*RegulatoryReport, *ReportTemplate) ([]byte, error)) error {
+	e.mu.RLock()
+	data, err := build(report, e.templates[report.Type])
+	e.mu.RUnlock()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	// Write XBRL header
-	io.WriteString(file, `<?xml version="1.0" encoding="UTF-8"?>
-<xbrl xmlns="http://www.xbrl.org/2003/instance">
-`)
-
-	// Write facts
-	for _, section := range report.Sections {
-		for _, item := range section.Items {
-			fmt.Fprintf(file, `  <fact contextRef="context1" unitRef="USD" decimals="0">
-    <concept>%s</concept>
-    <value>%s</value>
-  </fact>
-`, item.LineNumber, item.Amount.String())
-		}
-	}
-
-	io.WriteString(file, "</xbrl>\n")
-	return nil
+	return os.WriteFile(path, data, 0644)
 }
 
 // GetStats returns reporting statistics
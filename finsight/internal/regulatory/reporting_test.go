@@ -0,0 +1,71 @@
+package regulatory
+
+import (
+	"testing"
+
+	"github.com/savegress/finsight/internal/approval"
+	"github.com/savegress/finsight/internal/config"
+)
+
+func TestEngine_ApproveReport_FourEyes(t *testing.T) {
+	e, report := newQuarterReport(t, ReportTypeCallReport)
+	if err := e.SubmitForReview(report.ID); err != nil {
+		t.Fatalf("SubmitForReview failed: %v", err)
+	}
+	if err := e.ApproveReport(report.ID, "preparer"); err != approval.ErrSelfApproval {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+	if err := e.ApproveReport(report.ID, "controller"); err != nil {
+		t.Fatalf("ApproveReport failed: %v", err)
+	}
+	if report.Status != ReportStatusApproved || report.ApprovedBy != "controller" {
+		t.Errorf("unexpected report: status %s, approved by %q", report.Status, report.ApprovedBy)
+	}
+}
+
+func TestEngine_ApproveReport_Workflow(t *testing.T) {
+	e, report := newQuarterReport(t, ReportTypeFRY9C)
+	w := approval.NewWorkflow(&config.ApprovalConfig{
+		Policies: map[string]config.ApprovalPolicy{
+			ApprovalActionReport: {Roles: []string{"controller", "cfo"}, Approvers: 2},
+		},
+		Roles: map[string][]string{"ctrl": {"controller"}, "cfo": {"cfo"}, "clerk": {"analyst"}},
+	})
+	e.SetApprovals(w)
+
+	if err := e.SubmitForReview(report.ID); err != nil {
+		t.Fatalf("SubmitForReview failed: %v", err)
+	}
+	if err := e.ApproveReport(report.ID, "clerk"); err != approval.ErrRoleRequired {
+		t.Errorf("expected ErrRoleRequired, got %v", err)
+	}
+	if err := e.ApproveReport(report.ID, "ctrl"); err != nil {
+		t.Fatalf("ApproveReport failed: %v", err)
+	}
+	if report.Status != ReportStatusReview {
+		t.Errorf("report approved before the second approval: %s", report.Status)
+	}
+	if err := e.ApproveReport(report.ID, "cfo"); err != nil {
+		t.Fatalf("ApproveReport failed: %v", err)
+	}
+	if report.Status != ReportStatusApproved || report.ApprovedBy != "ctrl, cfo" {
+		t.Errorf("unexpected report: status %s, approved by %q", report.Status, report.ApprovedBy)
+	}
+
+	// A rejected report returns to draft
+	_, other := newQuarterReport(t, ReportTypeFRY9C)
+	e.reports[other.ID] = other
+	if err := e.SubmitForReview(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	req, ok := w.OpenRequest(ApprovalActionReport, other.ID)
+	if !ok {
+		t.Fatal("expected an open approval request")
+	}
+	if _, err := w.Reject(req.ID, "cfo", "totals do not tie out"); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if other.Status != ReportStatusDraft || other.ReviewedBy != "cfo" {
+		t.Errorf("unexpected rejected report: status %s, reviewed by %q", other.Status, other.ReviewedBy)
+	}
+}
@@ -0,0 +1,477 @@
+package regulatory
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// XBRL namespaces
+const (
+	nsXBRLI   = "http://www.xbrl.org/2003/instance"
+	nsLink    = "http://www.xbrl.org/2003/linkbase"
+	nsXLink   = "http://www.w3.org/1999/xlink"
+	nsISO4217 = "http://www.xbrl.org/2003/iso4217"
+	nsXHTML   = "http://www.w3.org/1999/xhtml"
+	nsIX      = "http://www.xbrl.org/2013/inlineXBRL"
+	nsIXT     = "http://www.xbrl.org/inlineXBRL/transformation/2020-02-12"
+)
+
+// XBRL period types
+const (
+	XBRLPeriodInstant  = "instant"
+	XBRLPeriodDuration = "duration"
+)
+
+// Taxonomy describes the XBRL taxonomy a report template maps onto
+type Taxonomy struct {
+	Name         string            `json:"name"`
+	SchemaRef    string            `json:"schema_ref"`
+	Namespaces   map[string]string `json:"namespaces"`    // Concept prefix -> namespace URI
+	EntityScheme string            `json:"entity_scheme"` // Scheme of the entity identifier, e.g. RSSD ID
+}
+
+// ConceptMapping maps a template item to an XBRL concept
+type ConceptMapping struct {
+	Concept    string `json:"concept"`     // QName, e.g. cc:RCFD0010
+	PeriodType string `json:"period_type"` // instant or duration
+	YearToDate bool   `json:"year_to_date,omitempty"`
+	Unit       string `json:"unit"`     // ISO 4217 code or "pure"
+	Decimals   string `json:"decimals"` // e.g. -3, 0 or INF
+	Required   bool   `json:"required,omitempty"`
+}
+
+func instantUSD(concept string, required bool) *ConceptMapping {
+	return &ConceptMapping{Concept: concept, PeriodType: XBRLPeriodInstant, Unit: "USD", Decimals: "-3", Required: required}
+}
+
+func yearToDateUSD(concept string, required bool) *ConceptMapping {
+	return &ConceptMapping{Concept: concept, PeriodType: XBRLPeriodDuration, YearToDate: true, Unit: "USD", Decimals: "-3", Required: required}
+}
+
+// xbrlContext is a distinct entity and period combination
+type xbrlContext struct {
+	ID    string
+	Start time.Time // zero for instants
+	End   time.Time
+}
+
+// xbrlFact is a report item resolved against its concept mapping
+type xbrlFact struct {
+	Section     string
+	LineNumber  string
+	Description string
+	Concept     string
+	ContextID   string
+	Unit        string
+	Decimals    string
+	Value       decimal.Decimal
+	Mapped      bool
+}
+
+type xbrlInstance struct {
+	Taxonomy *Taxonomy
+	EntityID string
+	Contexts []xbrlContext
+	Units    []string
+	Facts    []xbrlFact
+}
+
+// ValidateXBRL checks that a report can be rendered against its template's
+// taxonomy: every mapping is well formed and every required concept has a
+// fact
+func ValidateXBRL(report *RegulatoryReport, template *ReportTemplate) []ValidationResult {
+	if template == nil || template.Taxonomy == nil {
+		return []ValidationResult{{
+			RuleID:   "xbrl_taxonomy",
+			RuleName: "XBRL Taxonomy Mapping",
+			Passed:   false,
+			Severity: "error",
+			Message:  fmt.Sprintf("Report type %s has no XBRL taxonomy mapping", report.Type),
+		}}
+	}
+
+	var results []ValidationResult
+	fail := func(ruleID, ruleName, severity, message string, items ...string) {
+		results = append(results, ValidationResult{
+			RuleID:        ruleID,
+			RuleName:      ruleName,
+			Passed:        false,
+			Severity:      severity,
+			Message:       message,
+			AffectedItems: items,
+		})
+	}
+
+	if report.EntityID == "" {
+		fail("xbrl_entity", "XBRL Entity Identifier", "error", "Report has no entity identifier")
+	}
+	if report.Period == nil || report.Period.EndDate.IsZero() {
+		fail("xbrl_period", "XBRL Reporting Period", "error", "Report has no period end date")
+	}
+
+	items := reportItemIndex(report)
+	for _, section := range template.Sections {
+		for _, itemTpl := range section.Items {
+			m := itemTpl.Concept
+			if m == nil {
+				continue
+			}
+			line := itemTpl.LineNumber
+			if msg := checkConceptMapping(m, template.Taxonomy); msg != "" {
+				fail("xbrl_mapping", "XBRL Concept Mapping", "error", fmt.Sprintf("Line item %s: %s", line, msg), line)
+			}
+			if !m.Required {
+				continue
+			}
+			item, ok := items[section.ID+"/"+line]
+			switch {
+			case !ok:
+				fail("xbrl_required", "XBRL Required Concept", "error",
+					fmt.Sprintf("Required concept %s (line item %s) is missing", m.Concept, line), line)
+			case item.Amount.IsZero() && item.Notes == "":
+				fail("xbrl_required", "XBRL Required Concept", "warning",
+					fmt.Sprintf("Required concept %s (line item %s) is reported as zero", m.Concept, line), line)
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, ValidationResult{
+			RuleID:   "xbrl_required",
+			RuleName: "XBRL Required Concept",
+			Passed:   true,
+			Severity: "info",
+			Message:  "All required XBRL concepts are reported",
+		})
+	}
+	return results
+}
+
+func checkConceptMapping(m *ConceptMapping, tax *Taxonomy) string {
+	prefix, local, ok := strings.Cut(m.Concept, ":")
+	if !ok || local == "" {
+		return fmt.Sprintf("concept %q is not a QName", m.Concept)
+	}
+	if _, ok := tax.Namespaces[prefix]; !ok {
+		return fmt.Sprintf("concept prefix %q is not declared by the taxonomy", prefix)
+	}
+	if m.PeriodType != XBRLPeriodInstant && m.PeriodType != XBRLPeriodDuration {
+		return fmt.Sprintf("period type %q must be instant or duration", m.PeriodType)
+	}
+	if m.Unit == "" {
+		return "unit is required"
+	}
+	if m.Decimals != "INF" {
+		if _, err := decimal.NewFromString(m.Decimals); err != nil || strings.Contains(m.Decimals, ".") {
+			return fmt.Sprintf("decimals %q must be an integer or INF", m.Decimals)
+		}
+	}
+	return ""
+}
+
+func reportItemIndex(report *RegulatoryReport) map[string]ReportItem {
+	index := make(map[string]ReportItem)
+	for _, section := range report.Sections {
+		for _, item := range section.Items {
+			index[section.ID+"/"+item.LineNumber] = item
+		}
+	}
+	return index
+}
+
+// resolveXBRL pairs the report's items with the template's concept mappings
+// and derives the contexts and units the facts reference
+func resolveXBRL(report *RegulatoryReport, template *ReportTemplate) (*xbrlInstance, error) {
+	for _, r := range ValidateXBRL(report, template) {
+		if !r.Passed && r.Severity == "error" {
+			return nil, fmt.Errorf("XBRL validation failed: %s", r.Message)
+		}
+	}
+
+	inst := &xbrlInstance{Taxonomy: template.Taxonomy, EntityID: report.EntityID}
+	mappings := make(map[string]*ConceptMapping)
+	for _, section := range template.Sections {
+		for _, itemTpl := range section.Items {
+			if itemTpl.Concept != nil {
+				mappings[section.ID+"/"+itemTpl.LineNumber] = itemTpl.Concept
+			}
+		}
+	}
+
+	contexts := make(map[string]bool)
+	units := make(map[string]bool)
+	seen := make(map[string]string)
+	for _, section := range report.Sections {
+		for _, item := range section.Items {
+			fact := xbrlFact{
+				Section:     section.Name,
+				LineNumber:  item.LineNumber,
+				Description: item.Description,
+				Value:       item.Amount,
+			}
+			m := mappings[section.ID+"/"+item.LineNumber]
+			if m != nil {
+				ctx := periodContext(report.Period, m)
+				key := m.Concept + "@" + ctx.ID
+				if prev, dup := seen[key]; dup && prev != item.Amount.String() {
+					return nil, fmt.Errorf("inconsistent values for %s in context %s", m.Concept, ctx.ID)
+				}
+				seen[key] = item.Amount.String()
+
+				fact.Mapped = true
+				fact.Concept = m.Concept
+				fact.ContextID = ctx.ID
+				fact.Unit = m.Unit
+				fact.Decimals = m.Decimals
+				if !contexts[ctx.ID] {
+					contexts[ctx.ID] = true
+					inst.Contexts = append(inst.Contexts, ctx)
+				}
+				if !units[m.Unit] {
+					units[m.Unit] = true
+					inst.Units = append(inst.Units, m.Unit)
+				}
+			}
+			inst.Facts = append(inst.Facts, fact)
+		}
+	}
+	sort.Slice(inst.Contexts, func(i, j int) bool { return inst.Contexts[i].ID < inst.Contexts[j].ID })
+	sort.Strings(inst.Units)
+	return inst, nil
+}
+
+func periodContext(period *ReportPeriod, m *ConceptMapping) xbrlContext {
+	end := period.EndDate
+	if m.PeriodType == XBRLPeriodInstant {
+		return xbrlContext{ID: "I" + end.Format("20060102"), End: end}
+	}
+	start := period.StartDate
+	if m.YearToDate {
+		start = time.Date(end.Year(), 1, 1, 0, 0, 0, 0, end.Location())
+	}
+	return xbrlContext{ID: "D" + start.Format("20060102") + "_" + end.Format("20060102"), Start: start, End: end}
+}
+
+// BuildXBRLInstance renders a report as an XBRL 2.1 instance document
+func BuildXBRLInstance(report *RegulatoryReport, template *ReportTemplate) ([]byte, error) {
+	inst, err := resolveXBRL(report, template)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newXMLWriter()
+	w.start("xbrli:xbrl", inst.rootAttrs()...)
+	w.empty("link:schemaRef", "xlink:type", "simple", "xlink:href", inst.Taxonomy.SchemaRef)
+	inst.writeResources(w)
+	for _, f := range inst.Facts {
+		if !f.Mapped {
+			continue
+		}
+		w.text(f.Concept, f.Value.String(), "contextRef", f.ContextID, "unitRef", f.Unit, "decimals", f.Decimals)
+	}
+	w.end("xbrli:xbrl")
+	return w.bytes()
+}
+
+// BuildInlineXBRL renders a report as an Inline XBRL 1.1 XHTML document.
+// Mapped items are tagged as ix:nonFraction facts; the rest are shown as
+// plain text.
+func BuildInlineXBRL(report *RegulatoryReport, template *ReportTemplate) ([]byte, error) {
+	inst, err := resolveXBRL(report, template)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := append([]string{"xmlns", nsXHTML, "xmlns:ix", nsIX, "xmlns:ixt", nsIXT}, inst.rootAttrs()...)
+	w := newXMLWriter()
+	w.start("html", attrs...)
+	w.start("head")
+	w.text("meta", "", "http-equiv", "Content-Type", "content", "text/html; charset=UTF-8")
+	w.text("title", report.Name)
+	w.end("head")
+
+	w.start("body")
+	w.start("div", "style", "display:none")
+	w.start("ix:header")
+	w.start("ix:references")
+	w.empty("link:schemaRef", "xlink:type", "simple", "xlink:href", inst.Taxonomy.SchemaRef)
+	w.end("ix:references")
+	w.start("ix:resources")
+	inst.writeResources(w)
+	w.end("ix:resources")
+	w.end("ix:header")
+	w.end("div")
+
+	w.text("h1", report.Name)
+	if report.Period != nil {
+		w.text("p", fmt.Sprintf("Entity %s, period ended %s", report.EntityID, report.Period.EndDate.Format("2006-01-02")))
+	}
+
+	section := ""
+	for _, f := range inst.Facts {
+		if f.Section != section {
+			if section != "" {
+				w.end("table")
+			}
+			section = f.Section
+			w.text("h2", section)
+			w.start("table")
+			w.start("tr")
+			w.text("th", "Line")
+			w.text("th", "Description")
+			w.text("th", "Amount")
+			w.end("tr")
+		}
+		w.start("tr")
+		w.text("td", f.LineNumber)
+		w.text("td", f.Description)
+		if f.Mapped {
+			w.start("td")
+			attrs := []string{"name", f.Concept, "contextRef", f.ContextID, "unitRef", f.Unit, "decimals", f.Decimals, "format", "ixt:num-dot-decimal"}
+			if f.Value.IsNegative() {
+				attrs = append(attrs, "sign", "-")
+			}
+			w.text("ix:nonFraction", groupThousands(f.Value.Abs()), attrs...)
+			w.end("td")
+		} else {
+			w.text("td", groupThousands(f.Value))
+		}
+		w.end("tr")
+	}
+	if section != "" {
+		w.end("table")
+	}
+	w.end("body")
+	w.end("html")
+	return w.bytes()
+}
+
+func (inst *xbrlInstance) rootAttrs() []string {
+	attrs := []string{
+		"xmlns:xbrli", nsXBRLI,
+		"xmlns:link", nsLink,
+		"xmlns:xlink", nsXLink,
+		"xmlns:iso4217", nsISO4217,
+	}
+	prefixes := make([]string, 0, len(inst.Taxonomy.Namespaces))
+	for prefix := range inst.Taxonomy.Namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		attrs = append(attrs, "xmlns:"+prefix, inst.Taxonomy.Namespaces[prefix])
+	}
+	return attrs
+}
+
+func (inst *xbrlInstance) writeResources(w *xmlWriter) {
+	for _, ctx := range inst.Contexts {
+		w.start("xbrli:context", "id", ctx.ID)
+		w.start("xbrli:entity")
+		w.text("xbrli:identifier", inst.EntityID, "scheme", inst.Taxonomy.EntityScheme)
+		w.end("xbrli:entity")
+		w.start("xbrli:period")
+		if ctx.Start.IsZero() {
+			w.text("xbrli:instant", ctx.End.Format("2006-01-02"))
+		} else {
+			w.text("xbrli:startDate", ctx.Start.Format("2006-01-02"))
+			w.text("xbrli:endDate", ctx.End.Format("2006-01-02"))
+		}
+		w.end("xbrli:period")
+		w.end("xbrli:context")
+	}
+	for _, unit := range inst.Units {
+		measure := "iso4217:" + unit
+		if unit == "pure" {
+			measure = "xbrli:pure"
+		}
+		w.start("xbrli:unit", "id", unit)
+		w.text("xbrli:measure", measure)
+		w.end("xbrli:unit")
+	}
+}
+
+// groupThousands formats an amount with comma thousands separators
+func groupThousands(d decimal.Decimal) string {
+	s := d.String()
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if hasFrac {
+		return sign + b.String() + "." + frac
+	}
+	return sign + b.String()
+}
+
+// xmlWriter emits prefixed element names verbatim, which encoding/xml's
+// namespace handling cannot do for dynamically named concepts
+type xmlWriter struct {
+	buf bytes.Buffer
+	enc *xml.Encoder
+	err error
+}
+
+func newXMLWriter() *xmlWriter {
+	w := &xmlWriter{}
+	w.buf.WriteString(xml.Header)
+	w.enc = xml.NewEncoder(&w.buf)
+	w.enc.Indent("", "  ")
+	return w
+}
+
+func (w *xmlWriter) token(t xml.Token) {
+	if w.err == nil {
+		w.err = w.enc.EncodeToken(t)
+	}
+}
+
+func (w *xmlWriter) start(name string, attrs ...string) {
+	el := xml.StartElement{Name: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		el.Attr = append(el.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	w.token(el)
+}
+
+func (w *xmlWriter) end(name string) {
+	w.token(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+func (w *xmlWriter) text(name, value string, attrs ...string) {
+	w.start(name, attrs...)
+	if value != "" {
+		w.token(xml.CharData(value))
+	}
+	w.end(name)
+}
+
+func (w *xmlWriter) empty(name string, attrs ...string) {
+	w.start(name, attrs...)
+	w.end(name)
+}
+
+func (w *xmlWriter) bytes() ([]byte, error) {
+	if w.err == nil {
+		w.err = w.enc.Flush()
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.buf.WriteByte('\n')
+	return w.buf.Bytes(), nil
+}
@@ -0,0 +1,188 @@
+package regulatory
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func newQuarterReport(t *testing.T, reportType ReportType) (*Engine, *RegulatoryReport) {
+	t.Helper()
+	e := NewEngine(t.TempDir())
+	e.SetReportingEntity("480228")
+	report, err := e.CreateReport(reportType, &ReportPeriod{
+		Year:      2024,
+		Quarter:   2,
+		StartDate: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC),
+		Type:      PeriodTypeQuarterly,
+	}, "preparer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, report
+}
+
+func setAmounts(t *testing.T, e *Engine, report *RegulatoryReport, amounts map[string]int64) {
+	t.Helper()
+	for _, section := range report.Sections {
+		for _, item := range section.Items {
+			if v, ok := amounts[item.LineNumber]; ok {
+				if err := e.UpdateReportItem(report.ID, section.ID, item.ID, decimal.NewFromInt(v), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildXBRLInstance(t *testing.T) {
+	e, report := newQuarterReport(t, ReportTypeCallReport)
+	setAmounts(t, e, report, map[string]int64{
+		"RCFD0010": 1250000, "RCFD0071": 400000, "RIAD4107": 90000, "RIAD4073": 30000, "RIAD4074": 60000,
+	})
+	template, _ := e.GetTemplate(ReportTypeCallReport)
+
+	data, err := BuildXBRLInstance(report, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		XMLName   xml.Name `xml:"http://www.xbrl.org/2003/instance xbrl"`
+		SchemaRef struct {
+			Href string `xml:"http://www.w3.org/1999/xlink href,attr"`
+		} `xml:"http://www.xbrl.org/2003/linkbase schemaRef"`
+		Contexts []struct {
+			ID         string `xml:"id,attr"`
+			Identifier string `xml:"entity>identifier"`
+			Instant    string `xml:"period>instant"`
+			StartDate  string `xml:"period>startDate"`
+		} `xml:"context"`
+		Cash struct {
+			Value    string `xml:",chardata"`
+			Context  string `xml:"contextRef,attr"`
+			Decimals string `xml:"decimals,attr"`
+		} `xml:"http://www.ffiec.gov/xbrl/call/concepts RCFD0010"`
+		Income struct {
+			Context string `xml:"contextRef,attr"`
+		} `xml:"http://www.ffiec.gov/xbrl/call/concepts RIAD4107"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("instance is not well formed: %v\n%s", err, data)
+	}
+	if doc.SchemaRef.Href == "" || len(doc.Contexts) != 2 {
+		t.Fatalf("expected a schemaRef and two contexts:\n%s", data)
+	}
+	if doc.Cash.Value != "1250000" || doc.Cash.Context != "I20240630" || doc.Cash.Decimals != "-3" {
+		t.Errorf("unexpected instant fact %+v", doc.Cash)
+	}
+	if doc.Income.Context != "D20240101_20240630" {
+		t.Errorf("income should use a year-to-date context, got %s", doc.Income.Context)
+	}
+	for _, c := range doc.Contexts {
+		if c.Identifier != "480228" {
+			t.Errorf("context %s has identifier %q", c.ID, c.Identifier)
+		}
+		if c.ID == "D20240101_20240630" && c.StartDate != "2024-01-01" {
+			t.Errorf("unexpected start date %s", c.StartDate)
+		}
+	}
+}
+
+func TestBuildInlineXBRL(t *testing.T) {
+	e, report := newQuarterReport(t, ReportTypeFRY9C)
+	setAmounts(t, e, report, map[string]int64{
+		"BHCK2170": 5000000, "BHCK2948": 4500000, "BHCK3210": 500000,
+		"BHCK4107": 200000, "BHCK4073": 80000, "BHCK4074": 120000, "BHCK4340": -15000,
+	})
+	template, _ := e.GetTemplate(ReportTypeFRY9C)
+
+	data, err := BuildInlineXBRL(report, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, new(struct{})); err != nil {
+		t.Fatalf("inline document is not well formed XHTML: %v", err)
+	}
+	html := string(data)
+	for _, want := range []string{
+		`<ix:nonFraction name="bhc:BHCK2170" contextRef="I20240630" unitRef="USD" decimals="-3" format="ixt:num-dot-decimal">5,000,000</ix:nonFraction>`,
+		`name="bhc:BHCK4340" contextRef="D20240101_20240630" unitRef="USD" decimals="-3" format="ixt:num-dot-decimal" sign="-">15,000<`,
+		`<xbrli:measure>iso4217:USD</xbrli:measure>`,
+		`xmlns:ix="http://www.xbrl.org/2013/inlineXBRL"`,
+		`xmlns:bhc="http://www.federalreserve.gov/xbrl/fry9c/concepts"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("inline XBRL is missing %s", want)
+		}
+	}
+}
+
+func TestValidateXBRLRequiredConcepts(t *testing.T) {
+	e, report := newQuarterReport(t, ReportTypeCallReport)
+	setAmounts(t, e, report, map[string]int64{"RCFD0010": 1, "RIAD4107": 1, "RIAD4073": 1})
+	template, _ := e.GetTemplate(ReportTypeCallReport)
+
+	// Drop a required item entirely
+	report.Sections[1].Items = report.Sections[1].Items[:2]
+
+	var errs []string
+	for _, r := range ValidateXBRL(report, template) {
+		if !r.Passed && r.Severity == "error" {
+			errs = append(errs, r.Message)
+		}
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "cc:RIAD4074") {
+		t.Errorf("expected a missing RIAD4074 error, got %v", errs)
+	}
+	if _, err := BuildXBRLInstance(report, template); err == nil {
+		t.Error("expected instance generation to fail validation")
+	}
+
+	report.EntityID = ""
+	if results := ValidateXBRL(report, template); results[0].RuleID != "xbrl_entity" {
+		t.Errorf("expected an entity identifier error first, got %+v", results[0])
+	}
+
+	form1099, _ := e.GetTemplate(ReportType1099)
+	if results := ValidateXBRL(report, form1099); results[0].Passed {
+		t.Error("templates without a taxonomy cannot be rendered as XBRL")
+	}
+}
+
+func TestValidateReportWithoutEntity(t *testing.T) {
+	e := NewEngine(t.TempDir())
+	report, err := e.CreateReport(ReportTypeFRY9C, &ReportPeriod{
+		Year:      2024,
+		Quarter:   2,
+		StartDate: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC),
+		Type:      PeriodTypeQuarterly,
+	}, "preparer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := e.ValidateReport(report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if strings.HasPrefix(r.RuleID, "xbrl_") {
+			t.Errorf("XBRL checks belong to export, got %+v", r)
+		}
+	}
+}
+
+func TestGroupThousands(t *testing.T) {
+	tests := map[string]string{"0": "0", "999": "999", "1000": "1,000", "-1234567.5": "-1,234,567.5"}
+	for in, want := range tests {
+		if got := groupThousands(decimal.RequireFromString(in)); got != want {
+			t.Errorf("groupThousands(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package reporting
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// CSVExporter renders a report as CSV. Each section is written as a title
+// row, a header row and its data rows, separated by a blank line.
+type CSVExporter struct{}
+
+func (e *CSVExporter) Format() Format      { return FormatCSV }
+func (e *CSVExporter) ContentType() string { return "text/csv; charset=utf-8" }
+
+// Export writes the report to w
+func (e *CSVExporter) Export(w io.Writer, report *models.FinancialReport) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{reportTitle(report)}); err != nil {
+		return err
+	}
+
+	for _, s := range buildSections(report) {
+		if err := cw.Write(nil); err != nil {
+			return err
+		}
+		if err := cw.Write([]string{s.Title}); err != nil {
+			return err
+		}
+		if err := cw.Write(s.Headers); err != nil {
+			return err
+		}
+		for _, row := range s.Rows {
+			record := make([]string, len(row))
+			for i, c := range row {
+				record[i] = c.spreadsheetText()
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,275 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// Delivery is an exported report ready to be sent
+type Delivery struct {
+	Schedule    string
+	Report      *models.FinancialReport
+	FileName    string
+	ContentType string
+	Content     []byte
+}
+
+// Deliverer sends an exported report to a destination
+type Deliverer interface {
+	Method() string
+	Target() string
+	Deliver(ctx context.Context, d *Delivery) error
+}
+
+// EmailDeliverer sends the report as an attachment over SMTP
+type EmailDeliverer struct {
+	config     config.SMTPConfig
+	recipients []string
+	send       func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailDeliverer creates an SMTP deliverer
+func NewEmailDeliverer(cfg config.SMTPConfig, recipients []string) *EmailDeliverer {
+	return &EmailDeliverer{config: cfg, recipients: recipients, send: smtp.SendMail}
+}
+
+func (e *EmailDeliverer) Method() string { return "email" }
+func (e *EmailDeliverer) Target() string { return strings.Join(e.recipients, ",") }
+
+// Deliver sends the message
+func (e *EmailDeliverer) Deliver(ctx context.Context, d *Delivery) error {
+	msg, err := e.message(d)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		auth = smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)
+	}
+	return e.send(addr, auth, e.config.From, e.recipients, msg)
+}
+
+func (e *EmailDeliverer) message(d *Delivery) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	subject := d.Schedule
+	if subject == "" {
+		subject = reportTitle(d.Report)
+	}
+	subject = fmt.Sprintf("%s: %s to %s", subject, d.Report.StartDate.Format("2006-01-02"), d.Report.EndDate.Format("2006-01-02"))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(text, "%s is attached.\r\n\r\nReport ID: %s\r\nPeriod: %s to %s\r\n",
+		reportTitle(d.Report), d.Report.ID, d.Report.StartDate.Format(time.RFC3339), d.Report.EndDate.Format(time.RFC3339))
+
+	attachment, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {d.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": d.FileName})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(d.Content)
+	for len(encoded) > 76 {
+		attachment.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	attachment.Write([]byte(encoded + "\r\n"))
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// WebhookDeliverer POSTs the exported file to a URL
+type WebhookDeliverer struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookDeliverer creates a webhook deliverer
+func NewWebhookDeliverer(url string) *WebhookDeliverer {
+	return &WebhookDeliverer{url: url, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+func (w *WebhookDeliverer) Method() string { return "webhook" }
+func (w *WebhookDeliverer) Target() string { return w.url }
+
+// Deliver posts the file with the report metadata in headers
+func (w *WebhookDeliverer) Deliver(ctx context.Context, d *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(d.Content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", d.ContentType)
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.FileName}))
+	req.Header.Set("X-FinSight-Report-ID", d.Report.ID)
+	req.Header.Set("X-FinSight-Report-Type", string(d.Report.Type))
+	req.Header.Set("X-FinSight-Schedule", d.Schedule)
+	req.Header.Set("X-FinSight-Period-Start", d.Report.StartDate.Format(time.RFC3339))
+	req.Header.Set("X-FinSight-Period-End", d.Report.EndDate.Format(time.RFC3339))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// S3Deliverer uploads the exported file to an S3-compatible bucket using
+// path-style requests signed with AWS Signature Version 4
+type S3Deliverer struct {
+	config config.S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Deliverer creates an S3 deliverer
+func NewS3Deliverer(cfg config.S3Config) *S3Deliverer {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return &S3Deliverer{config: cfg, client: &http.Client{Timeout: 5 * time.Minute}, now: time.Now}
+}
+
+func (s *S3Deliverer) Method() string { return "s3" }
+func (s *S3Deliverer) Target() string { return "s3://" + s.config.Bucket + "/" + s.config.Prefix }
+
+// ObjectKey returns the key a delivery is stored under
+func (s *S3Deliverer) ObjectKey(d *Delivery) string {
+	return s.config.Prefix + d.Report.EndDate.Format("2006/01/02") + "/" + d.Report.ID + "_" + d.FileName
+}
+
+// Deliver uploads the file
+func (s *S3Deliverer) Deliver(ctx context.Context, d *Delivery) error {
+	endpoint, err := url.Parse(strings.TrimRight(s.config.Endpoint, "/"))
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	endpoint.Path += "/" + s.config.Bucket + "/" + s.ObjectKey(d)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(d.Content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(d.Content))
+	req.Header.Set("Content-Type", d.ContentType)
+	req.Header.Set("x-amz-meta-report-id", d.Report.ID)
+	s.sign(req, d.Content)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("S3 upload returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign adds the SigV4 Authorization header
+func (s *S3Deliverer) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("Host", req.URL.Host)
+
+	var names []string
+	headers := make(map[string]string)
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), day)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
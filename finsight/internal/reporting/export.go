@@ -0,0 +1,283 @@
+package reporting
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+// Format is a report export format
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatXLSX Format = "xlsx"
+	FormatPDF  Format = "pdf"
+)
+
+// Exporter renders a generated report into a file format
+type Exporter interface {
+	Format() Format
+	ContentType() string
+	Export(w io.Writer, report *models.FinancialReport) error
+}
+
+// Exporters returns the built-in exporters keyed by format
+func Exporters() map[Format]Exporter {
+	return map[Format]Exporter{
+		FormatCSV:  &CSVExporter{},
+		FormatXLSX: &XLSXExporter{},
+		FormatPDF:  &PDFExporter{},
+	}
+}
+
+// Export renders a completed report in the requested format
+func (g *Generator) Export(w io.Writer, id string, format Format) error {
+	exporter, err := g.Exporter(format)
+	if err != nil {
+		return err
+	}
+
+	g.mu.RLock()
+	report, ok := g.reports[id]
+	g.mu.RUnlock()
+	if !ok {
+		return ErrReportNotFound
+	}
+	if report.Status != models.ReportStatusCompleted || report.Data == nil {
+		return ErrReportNotReady
+	}
+
+	return exporter.Export(w, report)
+}
+
+// Exporter returns the exporter for a format
+func (g *Generator) Exporter(format Format) (Exporter, error) {
+	exporter, ok := g.exporters[Format(strings.ToLower(string(format)))]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	return exporter, nil
+}
+
+// DefaultFormat returns the first configured default export format
+func (g *Generator) DefaultFormat() Format {
+	for _, f := range g.config.DefaultFormats {
+		if _, ok := g.exporters[Format(strings.ToLower(f))]; ok {
+			return Format(strings.ToLower(f))
+		}
+	}
+	return FormatPDF
+}
+
+// FileName returns the download file name for a report
+func FileName(report *models.FinancialReport, format Format) string {
+	return fmt.Sprintf("%s_%s_%s.%s", report.Type, report.StartDate.Format("20060102"), report.EndDate.Format("20060102"), format)
+}
+
+// section is a titled table shared by all exporters
+type section struct {
+	Title   string
+	Headers []string
+	Rows    [][]cell
+	Chart   bool // render a bar chart of the last numeric column in PDF
+}
+
+// cell is a table value; numeric cells are written as numbers in XLSX and
+// right-aligned in PDF
+type cell struct {
+	Text   string
+	Number float64
+	Kind   cellKind
+}
+
+type cellKind int
+
+const (
+	cellText cellKind = iota
+	cellInt
+	cellAmount
+	cellRatio
+)
+
+func (c cell) numeric() bool {
+	return c.Kind != cellText
+}
+
+func textCell(s string) cell {
+	return cell{Text: s}
+}
+
+// spreadsheetText returns the cell's text for a spreadsheet. Text that a
+// spreadsheet would read as a formula, such as a merchant named
+// "=HYPERLINK(...)", is prefixed with a quote so it stays text.
+func (c cell) spreadsheetText() string {
+	if c.numeric() || c.Text == "" {
+		return c.Text
+	}
+	switch c.Text[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + c.Text
+	}
+	return c.Text
+}
+
+func intCell(n int) cell {
+	return cell{Text: strconv.Itoa(n), Number: float64(n), Kind: cellInt}
+}
+
+func amountCell(d decimal.Decimal) cell {
+	return cell{Text: d.StringFixed(2), Number: d.InexactFloat64(), Kind: cellAmount}
+}
+
+func ratioCell(f float64) cell {
+	return cell{Text: strconv.FormatFloat(f*100, 'f', 2, 64) + "%", Number: f, Kind: cellRatio}
+}
+
+// reportTitle returns a human readable title for a report
+func reportTitle(report *models.FinancialReport) string {
+	var title string
+	switch report.Type {
+	case models.ReportTypeTransaction:
+		title = "Transaction Report"
+	case models.ReportTypeCashFlow:
+		title = "Cash Flow Report"
+	case models.ReportTypeFraud:
+		title = "Fraud Report"
+	default:
+		words := strings.Split(string(report.Type), "_")
+		for i, w := range words {
+			if w != "" {
+				words[i] = strings.ToUpper(w[:1]) + w[1:]
+			}
+		}
+		title = strings.Join(words, " ") + " Report"
+	}
+	return title
+}
+
+// buildSections lays a report out as tables, in the order they are rendered
+func buildSections(report *models.FinancialReport) []section {
+	data := report.Data
+	currency := data.Currency
+	amountHeader := "Amount"
+	if currency != "" {
+		amountHeader = "Amount (" + currency + ")"
+	}
+
+	summary := section{
+		Title:   "Summary",
+		Headers: []string{"Metric", "Value"},
+		Rows: [][]cell{
+			{textCell("Report ID"), textCell(report.ID)},
+			{textCell("Report type"), textCell(string(report.Type))},
+			{textCell("Period"), textCell(string(report.Period))},
+			{textCell("Start date"), textCell(report.StartDate.Format("2006-01-02"))},
+			{textCell("End date"), textCell(report.EndDate.Format("2006-01-02"))},
+		},
+	}
+	if report.GeneratedAt != nil {
+		summary.Rows = append(summary.Rows, []cell{textCell("Generated at"), textCell(report.GeneratedAt.UTC().Format(time.RFC3339))})
+	}
+	if currency != "" {
+		summary.Rows = append(summary.Rows, []cell{textCell("Currency"), textCell(currency)})
+	}
+	if data.UnconvertedTransactions > 0 {
+		summary.Rows = append(summary.Rows, []cell{textCell("Unconverted transactions (excluded)"), intCell(data.UnconvertedTransactions)})
+	}
+
+	if report.Type == models.ReportTypeFraud {
+		summary.Rows = append(summary.Rows, []cell{textCell("Alerts"), intCell(data.TotalTransactions)})
+	} else {
+		summary.Rows = append(summary.Rows,
+			[]cell{textCell("Transactions"), intCell(data.TotalTransactions)},
+			[]cell{textCell("Total volume"), amountCell(data.TotalVolume)},
+			[]cell{textCell("Net flow"), amountCell(data.NetFlow)},
+		)
+	}
+
+	sections := []section{summary}
+
+	if m := data.FraudMetrics; m != nil {
+		sections = append(sections, section{
+			Title:   "Fraud Metrics",
+			Headers: []string{"Metric", "Value"},
+			Rows: [][]cell{
+				{textCell("Total alerts"), intCell(m.TotalAlerts)},
+				{textCell("Open alerts"), intCell(m.OpenAlerts)},
+				{textCell("Resolved alerts"), intCell(m.ResolvedAlerts)},
+				{textCell("False positives"), intCell(m.FalsePositives)},
+				{textCell("Blocked amount"), amountCell(m.BlockedAmount)},
+				{textCell("Detection rate"), ratioCell(m.DetectionRate)},
+			},
+		})
+	}
+
+	if len(data.ByType) > 0 {
+		s := section{Title: "By Type", Headers: []string{"Type", "Count", "Average", amountHeader}, Chart: true}
+		for _, name := range sortedKeys(data.ByType) {
+			ts := data.ByType[name]
+			s.Rows = append(s.Rows, []cell{textCell(name), intCell(ts.Count), amountCell(ts.Average), amountCell(ts.Volume)})
+		}
+		sections = append(sections, s)
+	}
+
+	if len(data.ByCategory) > 0 {
+		s := section{Title: "By Category", Headers: []string{"Category", amountHeader}, Chart: true}
+		for _, name := range sortedKeys(data.ByCategory) {
+			s.Rows = append(s.Rows, []cell{textCell(name), amountCell(data.ByCategory[name])})
+		}
+		sections = append(sections, s)
+	}
+
+	if len(data.ByStatus) > 0 {
+		// Fraud reports count alerts by alert type in ByStatus
+		s := section{Title: "By Status", Headers: []string{"Status", "Count"}, Chart: true}
+		if report.Type == models.ReportTypeFraud {
+			s = section{Title: "By Alert Type", Headers: []string{"Alert type", "Count"}, Chart: true}
+		}
+		for _, name := range sortedKeys(data.ByStatus) {
+			s.Rows = append(s.Rows, []cell{textCell(name), intCell(data.ByStatus[name])})
+		}
+		sections = append(sections, s)
+	}
+
+	if len(data.DailyBreakdown) > 0 {
+		s := section{Title: "Daily Breakdown", Headers: []string{"Date", "Transactions", "Credits", "Debits", "Volume"}, Chart: true}
+		for _, day := range data.DailyBreakdown {
+			s.Rows = append(s.Rows, []cell{
+				textCell(day.Date.Format("2006-01-02")),
+				intCell(day.Transactions),
+				amountCell(day.Credits),
+				amountCell(day.Debits),
+				amountCell(day.Volume),
+			})
+		}
+		sections = append(sections, s)
+	}
+
+	if len(data.TopMerchants) > 0 {
+		s := section{Title: "Top Merchants", Headers: []string{"Merchant ID", "Merchant", "Transactions", amountHeader}}
+		for _, m := range data.TopMerchants {
+			s.Rows = append(s.Rows, []cell{textCell(m.MerchantID), textCell(m.MerchantName), intCell(m.Transactions), amountCell(m.Volume)})
+		}
+		sections = append(sections, s)
+	}
+
+	return sections
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
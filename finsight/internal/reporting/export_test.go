@@ -0,0 +1,268 @@
+package reporting
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func generatedReport(t *testing.T, days int) (*Generator, *models.FinancialReport) {
+	t.Helper()
+
+	g := NewGenerator(&config.ReportingConfig{DefaultFormats: []string{"csv"}})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := g.CreateReport(models.ReportTypeTransaction, models.ReportPeriodDaily, start, start.AddDate(0, 0, days))
+
+	var txns []*models.Transaction
+	for i := 0; i < days; i++ {
+		txnType := models.TransactionTypeDebit
+		if i%3 == 0 {
+			txnType = models.TransactionTypeCredit
+		}
+		txns = append(txns, &models.Transaction{
+			ID:        fmt.Sprintf("TXN-%03d", i),
+			Type:      txnType,
+			Status:    models.TransactionStatusCompleted,
+			Amount:    decimal.NewFromInt(int64(100 + i)),
+			Category:  "groceries",
+			Merchant:  &models.Merchant{ID: "M-1", Name: "Corner (Shop)"},
+			CreatedAt: start.AddDate(0, 0, i).Add(time.Hour),
+		})
+	}
+
+	if err := g.GenerateTransactionReport(context.Background(), report.ID, txns); err != nil {
+		t.Fatalf("GenerateTransactionReport failed: %v", err)
+	}
+	return g, report
+}
+
+func TestGenerateTransactionReport_Unconverted(t *testing.T) {
+	g := NewGenerator(&config.ReportingConfig{})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := g.CreateReport(models.ReportTypeTransaction, models.ReportPeriodDaily, start, start.AddDate(0, 0, 1))
+
+	at := start.Add(time.Hour)
+	txns := []*models.Transaction{
+		{ID: "TXN-001", Type: models.TransactionTypeDebit, Amount: decimal.NewFromInt(100), Currency: "EUR", BaseAmount: decimal.NewFromInt(110), BaseCurrency: "USD", CreatedAt: at},
+		{ID: "TXN-002", Type: models.TransactionTypeDebit, Amount: decimal.NewFromInt(50), Currency: "USD", BaseAmount: decimal.NewFromInt(50), BaseCurrency: "USD", CreatedAt: at},
+		// No rate: must not be added to USD totals as 10000 JPY
+		{ID: "TXN-003", Type: models.TransactionTypeDebit, Amount: decimal.NewFromInt(10000), Currency: "JPY", CreatedAt: at},
+	}
+	if err := g.GenerateTransactionReport(context.Background(), report.ID, txns); err != nil {
+		t.Fatalf("GenerateTransactionReport failed: %v", err)
+	}
+
+	data := report.Data
+	if data.Currency != "USD" {
+		t.Errorf("Currency = %q, want USD", data.Currency)
+	}
+	if !data.TotalVolume.Equal(decimal.NewFromInt(160)) {
+		t.Errorf("TotalVolume = %s, want 160", data.TotalVolume)
+	}
+	if data.TotalTransactions != 2 || data.UnconvertedTransactions != 1 {
+		t.Errorf("transactions = %d, unconverted = %d, want 2 and 1", data.TotalTransactions, data.UnconvertedTransactions)
+	}
+}
+
+func TestExport_CSV(t *testing.T) {
+	g, report := generatedReport(t, 3)
+
+	var buf bytes.Buffer
+	if err := g.Export(&buf, report.ID, FormatCSV); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	if records[0][0] != "Transaction Report" {
+		t.Errorf("title = %q", records[0][0])
+	}
+
+	found := false
+	for _, rec := range records {
+		if len(rec) == 2 && rec[0] == "Total volume" {
+			found = true
+			if rec[1] != "303.00" {
+				t.Errorf("Total volume = %s, want 303.00", rec[1])
+			}
+		}
+	}
+	if !found {
+		t.Error("summary row missing")
+	}
+}
+
+func TestExport_FormulaText(t *testing.T) {
+	g := NewGenerator(&config.ReportingConfig{})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := g.CreateReport(models.ReportTypeTransaction, models.ReportPeriodDaily, start, start.AddDate(0, 0, 1))
+	txns := []*models.Transaction{{
+		ID:        "TXN-001",
+		Type:      models.TransactionTypeDebit,
+		Amount:    decimal.NewFromInt(10),
+		Category:  "@SUM(A1:A9)",
+		Merchant:  &models.Merchant{ID: "M-1", Name: `=HYPERLINK("http://evil.example","click")`},
+		CreatedAt: start.Add(time.Hour),
+	}}
+	if err := g.GenerateTransactionReport(context.Background(), report.ID, txns); err != nil {
+		t.Fatalf("GenerateTransactionReport failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.Export(&buf, report.ID, FormatCSV); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	var merchant, category string
+	for _, rec := range records {
+		if len(rec) == 4 && rec[0] == "M-1" {
+			merchant = rec[1]
+		}
+		if len(rec) == 2 && strings.Contains(rec[0], "SUM") {
+			category = rec[0]
+		}
+	}
+	if merchant != `'=HYPERLINK("http://evil.example","click")` {
+		t.Errorf("merchant = %q, want quoted", merchant)
+	}
+	if category != "'@SUM(A1:A9)" {
+		t.Errorf("category = %q, want quoted", category)
+	}
+
+	buf.Reset()
+	if err := g.Export(&buf, report.ID, FormatXLSX); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("output is not a zip archive: %v", err)
+	}
+	var sheets strings.Builder
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "xl/worksheets/") {
+			rc, _ := f.Open()
+			io.Copy(&sheets, rc)
+			rc.Close()
+		}
+	}
+	if !strings.Contains(sheets.String(), "<t>&#39;=HYPERLINK(") {
+		t.Error("XLSX merchant name not quoted")
+	}
+	if strings.Contains(sheets.String(), "<t>=HYPERLINK(") {
+		t.Error("XLSX merchant name written as a formula")
+	}
+
+	// Negative amounts stay numbers
+	if c := amountCell(decimal.NewFromInt(-5)); c.spreadsheetText() != "-5.00" {
+		t.Errorf("amount = %q, want -5.00", c.spreadsheetText())
+	}
+}
+
+func TestExport_XLSX(t *testing.T) {
+	g, report := generatedReport(t, 3)
+
+	var buf bytes.Buffer
+	if err := g.Export(&buf, report.ID, FormatXLSX); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("output is not a zip archive: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	sections := buildSections(report)
+	for i := range sections {
+		if _, ok := files[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)]; !ok {
+			t.Errorf("sheet%d.xml missing", i+1)
+		}
+	}
+	if !strings.Contains(files["xl/workbook.xml"], `name="Daily Breakdown"`) {
+		t.Error("workbook missing Daily Breakdown sheet")
+	}
+	if !strings.Contains(files["xl/worksheets/sheet1.xml"], "<v>303</v>") {
+		t.Error("summary sheet missing numeric total volume")
+	}
+}
+
+func TestExport_PDF(t *testing.T) {
+	g, report := generatedReport(t, 90)
+
+	var buf bytes.Buffer
+	if err := g.Export(&buf, report.ID, FormatPDF); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "%PDF-1.4") || !strings.HasSuffix(out, "%%EOF\n") {
+		t.Fatal("output is not a PDF document")
+	}
+
+	// 90 daily rows plus a chart do not fit on one page
+	if strings.Contains(out, "/Count 1 ") {
+		t.Error("expected more than one page")
+	}
+
+	// startxref must point at the xref table
+	idx := strings.LastIndex(out, "startxref\n")
+	var offset int
+	fmt.Sscanf(out[idx+len("startxref\n"):], "%d", &offset)
+	if !strings.HasPrefix(out[offset:], "xref\n") {
+		t.Errorf("startxref %d does not point at the xref table", offset)
+	}
+}
+
+func TestExport_Errors(t *testing.T) {
+	g, report := generatedReport(t, 1)
+
+	if err := g.Export(io.Discard, report.ID, "docx"); err != ErrUnsupportedFormat {
+		t.Errorf("err = %v, want ErrUnsupportedFormat", err)
+	}
+	if err := g.Export(io.Discard, "missing", FormatCSV); err != ErrReportNotFound {
+		t.Errorf("err = %v, want ErrReportNotFound", err)
+	}
+
+	pending := g.CreateReport(models.ReportTypeCashFlow, models.ReportPeriodDaily, time.Now(), time.Now())
+	if err := g.Export(io.Discard, pending.ID, FormatCSV); err != ErrReportNotReady {
+		t.Errorf("err = %v, want ErrReportNotReady", err)
+	}
+	if g.DefaultFormat() != FormatCSV {
+		t.Errorf("DefaultFormat = %s, want csv", g.DefaultFormat())
+	}
+}
+
+func TestPDFEscape(t *testing.T) {
+	if got := pdfEscape(`a(b)\c`); got != `a\(b\)\\c` {
+		t.Errorf("pdfEscape = %q", got)
+	}
+	if got := pdfEscape("café €"); got != "caf\xe9 ?" {
+		t.Errorf("pdfEscape latin-1 = %q", got)
+	}
+}
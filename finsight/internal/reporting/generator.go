@@ -2,6 +2,7 @@ package reporting
 
 import (
 	"context"
+	"log"
 	"sort"
 	"sync"
 	"time"
@@ -13,16 +14,18 @@ import (
 
 // Generator generates financial reports
 type Generator struct {
-	config  *config.ReportingConfig
-	reports map[string]*models.FinancialReport
-	mu      sync.RWMutex
+	config    *config.ReportingConfig
+	reports   map[string]*models.FinancialReport
+	exporters map[Format]Exporter
+	mu        sync.RWMutex
 }
 
 // NewGenerator creates a new report generator
 func NewGenerator(cfg *config.ReportingConfig) *Generator {
 	return &Generator{
-		config:  cfg,
-		reports: make(map[string]*models.FinancialReport),
+		config:    cfg,
+		reports:   make(map[string]*models.FinancialReport),
+		exporters: Exporters(),
 	}
 }
 
@@ -68,6 +71,7 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 			filtered = append(filtered, txn)
 		}
 	}
+	filtered, data.Currency, data.UnconvertedTransactions = reportable(filtered)
 
 	// Calculate totals
 	var credits, debits decimal.Decimal
@@ -75,8 +79,9 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 	merchantData := make(map[string]*merchantAccumulator)
 
 	for _, txn := range filtered {
+		amount := txn.ReportingAmount()
 		data.TotalTransactions++
-		data.TotalVolume = data.TotalVolume.Add(txn.Amount)
+		data.TotalVolume = data.TotalVolume.Add(amount)
 
 		// By type
 		typeName := string(txn.Type)
@@ -85,12 +90,12 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 		}
 		ts := data.ByType[typeName]
 		ts.Count++
-		ts.Volume = ts.Volume.Add(txn.Amount)
+		ts.Volume = ts.Volume.Add(amount)
 		data.ByType[typeName] = ts
 
 		// By category
 		if txn.Category != "" {
-			data.ByCategory[txn.Category] = data.ByCategory[txn.Category].Add(txn.Amount)
+			data.ByCategory[txn.Category] = data.ByCategory[txn.Category].Add(amount)
 		}
 
 		// By status
@@ -99,9 +104,9 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 		// Credits vs Debits
 		switch txn.Type {
 		case models.TransactionTypeCredit, models.TransactionTypeRefund, models.TransactionTypeInterest:
-			credits = credits.Add(txn.Amount)
+			credits = credits.Add(amount)
 		case models.TransactionTypeDebit, models.TransactionTypeFee:
-			debits = debits.Add(txn.Amount)
+			debits = debits.Add(amount)
 		}
 
 		// Daily breakdown
@@ -112,12 +117,12 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 			}
 		}
 		dailyData[day].transactions++
-		dailyData[day].volume = dailyData[day].volume.Add(txn.Amount)
+		dailyData[day].volume = dailyData[day].volume.Add(amount)
 		switch txn.Type {
 		case models.TransactionTypeCredit:
-			dailyData[day].credits = dailyData[day].credits.Add(txn.Amount)
+			dailyData[day].credits = dailyData[day].credits.Add(amount)
 		case models.TransactionTypeDebit:
-			dailyData[day].debits = dailyData[day].debits.Add(txn.Amount)
+			dailyData[day].debits = dailyData[day].debits.Add(amount)
 		}
 
 		// Top merchants
@@ -129,7 +134,7 @@ func (g *Generator) GenerateTransactionReport(ctx context.Context, reportID stri
 				}
 			}
 			merchantData[txn.Merchant.ID].transactions++
-			merchantData[txn.Merchant.ID].volume = merchantData[txn.Merchant.ID].volume.Add(txn.Amount)
+			merchantData[txn.Merchant.ID].volume = merchantData[txn.Merchant.ID].volume.Add(amount)
 		}
 	}
 
@@ -233,6 +238,7 @@ func (g *Generator) GenerateFraudReport(ctx context.Context, reportID string, al
 	// Calculate fraud metrics
 	metrics := &models.FraudMetrics{}
 	var blockedAmount decimal.Decimal
+	transactions, data.Currency, _ = reportable(transactions)
 
 	for _, alert := range filteredAlerts {
 		metrics.TotalAlerts++
@@ -250,7 +256,7 @@ func (g *Generator) GenerateFraudReport(ctx context.Context, reportID string, al
 		for _, txn := range transactions {
 			if txn.ID == alert.TransactionID {
 				if alert.Status == models.AlertStatusResolved {
-					blockedAmount = blockedAmount.Add(txn.Amount)
+					blockedAmount = blockedAmount.Add(txn.ReportingAmount())
 				}
 				break
 			}
@@ -304,13 +310,15 @@ func (g *Generator) GenerateCashFlowReport(ctx context.Context, reportID string,
 			filtered = append(filtered, txn)
 		}
 	}
+	filtered, data.Currency, data.UnconvertedTransactions = reportable(filtered)
 
 	var inflow, outflow decimal.Decimal
 	dailyFlow := make(map[string]*cashFlowAccumulator)
 
 	for _, txn := range filtered {
+		amount := txn.ReportingAmount()
 		data.TotalTransactions++
-		data.TotalVolume = data.TotalVolume.Add(txn.Amount)
+		data.TotalVolume = data.TotalVolume.Add(amount)
 
 		day := txn.CreatedAt.Format("2006-01-02")
 		if dailyFlow[day] == nil {
@@ -321,11 +329,11 @@ func (g *Generator) GenerateCashFlowReport(ctx context.Context, reportID string,
 
 		switch txn.Type {
 		case models.TransactionTypeCredit, models.TransactionTypeRefund, models.TransactionTypeInterest:
-			inflow = inflow.Add(txn.Amount)
-			dailyFlow[day].inflow = dailyFlow[day].inflow.Add(txn.Amount)
+			inflow = inflow.Add(amount)
+			dailyFlow[day].inflow = dailyFlow[day].inflow.Add(amount)
 		case models.TransactionTypeDebit, models.TransactionTypeFee, models.TransactionTypeTransfer:
-			outflow = outflow.Add(txn.Amount)
-			dailyFlow[day].outflow = dailyFlow[day].outflow.Add(txn.Amount)
+			outflow = outflow.Add(amount)
+			dailyFlow[day].outflow = dailyFlow[day].outflow.Add(amount)
 		}
 	}
 
@@ -361,6 +369,41 @@ func (g *Generator) GenerateCashFlowReport(ctx context.Context, reportID string,
 	return nil
 }
 
+// reportable returns the transactions whose amounts can be added up in one
+// currency, that currency, and how many were left out. Amounts are reported
+// in the base currency once any transaction has been converted; without
+// conversion they are reported in the currency of the first transaction
+// that names one. A transaction without a rate into that currency is never
+// added in its own currency.
+func reportable(transactions []*models.Transaction) ([]*models.Transaction, string, int) {
+	var currency string
+	for _, txn := range transactions {
+		if txn.BaseCurrency != "" {
+			currency = txn.BaseCurrency
+			break
+		}
+		if currency == "" {
+			currency = txn.Currency
+		}
+	}
+
+	var included []*models.Transaction
+	skipped := 0
+	for _, txn := range transactions {
+		switch {
+		case txn.BaseCurrency != "" && txn.BaseCurrency == currency,
+			txn.BaseCurrency == "" && (txn.Currency == "" || txn.Currency == currency):
+			included = append(included, txn)
+		default:
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		log.Printf("reporting: %d transactions without a rate into %s left out of totals", skipped, currency)
+	}
+	return included, currency, skipped
+}
+
 type cashFlowAccumulator struct {
 	date    time.Time
 	inflow  decimal.Decimal
@@ -443,7 +486,10 @@ func generateReportID() string {
 
 // Errors
 var (
-	ErrReportNotFound = &Error{Code: "REPORT_NOT_FOUND", Message: "Report not found"}
+	ErrReportNotFound    = &Error{Code: "REPORT_NOT_FOUND", Message: "Report not found"}
+	ErrReportNotReady    = &Error{Code: "REPORT_NOT_READY", Message: "Report has not been generated"}
+	ErrUnsupportedFormat = &Error{Code: "UNSUPPORTED_FORMAT", Message: "Unsupported export format"}
+	ErrJobNotFound       = &Error{Code: "JOB_NOT_FOUND", Message: "Scheduled report not found"}
 )
 
 // Error represents a reporting error
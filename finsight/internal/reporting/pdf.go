@@ -0,0 +1,362 @@
+package reporting
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// PDFExporter renders a report as a paginated A4 PDF with a table per
+// section and bar charts for breakdowns
+type PDFExporter struct{}
+
+func (e *PDFExporter) Format() Format      { return FormatPDF }
+func (e *PDFExporter) ContentType() string { return "application/pdf" }
+
+// Page geometry in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 40.0
+	pdfRowHeight  = 14.0
+	pdfFontSize   = 9.0
+	pdfMaxBars    = 40
+)
+
+// Export writes the PDF document to w
+func (e *PDFExporter) Export(w io.Writer, report *models.FinancialReport) error {
+	title := reportTitle(report)
+	doc := newPDFDocument(title)
+
+	doc.text(pdfMargin, doc.y, 18, true, title)
+	doc.y -= 18
+	subtitle := fmt.Sprintf("%s to %s", report.StartDate.Format("2006-01-02"), report.EndDate.Format("2006-01-02"))
+	if report.Period != "" {
+		subtitle = string(report.Period) + " report, " + subtitle
+	}
+	doc.text(pdfMargin, doc.y, 10, false, subtitle)
+	doc.y -= 24
+
+	for _, s := range buildSections(report) {
+		doc.table(s)
+		if s.Chart {
+			doc.barChart(s)
+		}
+		doc.y -= 10
+	}
+
+	return doc.write(w)
+}
+
+// pdfDocument lays out content streams page by page
+type pdfDocument struct {
+	title string
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+func newPDFDocument(title string) *pdfDocument {
+	d := &pdfDocument{title: title}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+	if len(d.pages) > 1 {
+		d.text(pdfMargin, d.y, 8, false, d.title)
+		d.line(pdfMargin, d.y-4, pdfPageWidth-pdfMargin, d.y-4)
+		d.y -= 20
+	}
+}
+
+// ensure starts a new page unless height points fit above the bottom margin
+func (d *pdfDocument) ensure(height float64) {
+	if d.y-height < pdfMargin+20 {
+		d.newPage()
+	}
+}
+
+func (d *pdfDocument) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, pdfNum(size), pdfNum(x), pdfNum(y), pdfEscape(s))
+}
+
+func (d *pdfDocument) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page, "0.6 G 0.5 w %s %s m %s %s l S 0 G\n", pdfNum(x1), pdfNum(y1), pdfNum(x2), pdfNum(y2))
+}
+
+func (d *pdfDocument) rect(x, y, w, h float64, gray float64) {
+	fmt.Fprintf(d.page, "%s g %s %s %s %s re f 0 g\n", pdfNum(gray), pdfNum(x), pdfNum(y), pdfNum(w), pdfNum(h))
+}
+
+func (d *pdfDocument) colorRect(x, y, w, h float64, r, g, b float64) {
+	fmt.Fprintf(d.page, "%s %s %s rg %s %s %s %s re f 0 g\n", pdfNum(r), pdfNum(g), pdfNum(b), pdfNum(x), pdfNum(y), pdfNum(w), pdfNum(h))
+}
+
+// table draws a section as a table, repeating the header row on every page
+func (d *pdfDocument) table(s section) {
+	widths := d.columnWidths(s)
+
+	d.ensure(16 + 2*pdfRowHeight)
+	d.text(pdfMargin, d.y, 12, true, s.Title)
+	d.y -= 18
+
+	header := func() {
+		d.rect(pdfMargin, d.y-4, pdfPageWidth-2*pdfMargin, pdfRowHeight, 0.88)
+		x := pdfMargin
+		for i, h := range s.Headers {
+			d.cellText(x, widths[i], h, true, false)
+			x += widths[i]
+		}
+		d.y -= pdfRowHeight
+	}
+	header()
+
+	for r, row := range s.Rows {
+		if d.y-pdfRowHeight < pdfMargin+20 {
+			d.newPage()
+			header()
+		}
+		if r%2 == 1 {
+			d.rect(pdfMargin, d.y-4, pdfPageWidth-2*pdfMargin, pdfRowHeight, 0.96)
+		}
+		x := pdfMargin
+		for i, c := range row {
+			if i >= len(widths) {
+				break
+			}
+			d.cellText(x, widths[i], c.Text, false, c.numeric())
+			x += widths[i]
+		}
+		d.y -= pdfRowHeight
+	}
+	d.line(pdfMargin, d.y+pdfRowHeight-4, pdfPageWidth-pdfMargin, d.y+pdfRowHeight-4)
+	d.y -= 6
+}
+
+func (d *pdfDocument) cellText(x, width float64, s string, bold, right bool) {
+	const padding = 4.0
+	s = pdfFit(s, width-2*padding, pdfFontSize, bold)
+	tx := x + padding
+	if right {
+		tx = x + width - padding - pdfTextWidth(s, pdfFontSize, bold)
+	}
+	d.text(tx, d.y, pdfFontSize, bold, s)
+}
+
+// columnWidths distributes the printable width in proportion to the widest
+// value in each column
+func (d *pdfDocument) columnWidths(s section) []float64 {
+	available := pdfPageWidth - 2*pdfMargin
+	natural := make([]float64, len(s.Headers))
+	var total float64
+	for i, h := range s.Headers {
+		w := pdfTextWidth(h, pdfFontSize, true)
+		for _, row := range s.Rows {
+			if i < len(row) {
+				w = math.Max(w, pdfTextWidth(row[i].Text, pdfFontSize, false))
+			}
+		}
+		natural[i] = math.Min(w+8, available/2)
+		total += natural[i]
+	}
+
+	widths := make([]float64, len(natural))
+	for i, w := range natural {
+		widths[i] = w / total * available
+	}
+	return widths
+}
+
+// barChart draws a horizontal bar chart of the last numeric column
+func (d *pdfDocument) barChart(s section) {
+	col := -1
+	for i := len(s.Headers) - 1; i >= 0 && len(s.Rows) > 0; i-- {
+		if i < len(s.Rows[0]) && s.Rows[0][i].numeric() {
+			col = i
+			break
+		}
+	}
+	if col < 0 || len(s.Rows) < 2 {
+		return
+	}
+
+	rows := s.Rows
+	if len(rows) > pdfMaxBars {
+		rows = rows[:pdfMaxBars]
+	}
+
+	var maxValue float64
+	for _, row := range rows {
+		maxValue = math.Max(maxValue, math.Abs(row[col].Number))
+	}
+	if maxValue == 0 {
+		return
+	}
+
+	const (
+		labelWidth = 120.0
+		valueWidth = 70.0
+		barHeight  = 9.0
+	)
+	barArea := pdfPageWidth - 2*pdfMargin - labelWidth - valueWidth
+
+	d.ensure(18 + float64(len(rows))*pdfRowHeight)
+	d.text(pdfMargin, d.y, 10, true, s.Title+" - "+s.Headers[col])
+	d.y -= 16
+
+	for _, row := range rows {
+		value := row[col].Number
+		width := math.Abs(value) / maxValue * barArea
+		label := pdfFit(row[0].Text, labelWidth-8, 8, false)
+		d.text(pdfMargin, d.y, 8, false, label)
+		if value < 0 {
+			d.colorRect(pdfMargin+labelWidth, d.y-1, width, barHeight, 0.80, 0.25, 0.25)
+		} else {
+			d.colorRect(pdfMargin+labelWidth, d.y-1, width, barHeight, 0.20, 0.45, 0.75)
+		}
+		d.text(pdfMargin+labelWidth+width+4, d.y, 8, false, row[col].Text)
+		d.y -= pdfRowHeight
+	}
+	d.y -= 6
+}
+
+// write serialises the document, adding page footers now that the page
+// count is known
+func (d *pdfDocument) write(w io.Writer) error {
+	for i, page := range d.pages {
+		d.page = page
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		d.text(pdfPageWidth-pdfMargin-pdfTextWidth(footer, 8, false), pdfMargin-15, 8, false, footer)
+		d.text(pdfMargin, pdfMargin-15, 8, false, "Generated by FinSight")
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5: catalog, page tree, fonts, info. Pages start at object 6
+	// with each page followed by its content stream.
+	kids := make([]byte, 0, len(d.pages)*8)
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R ", 6+2*i)...)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", bytes.TrimSpace(kids), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (FinSight) /CreationDate (D:%s) >>", pdfEscape(d.title), time.Now().UTC().Format("20060102150405Z")))
+
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfNum(pdfPageWidth), pdfNum(pdfPageHeight), 7+2*i))
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func pdfNum(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// pdfEscape encodes a string for a PDF literal in WinAnsiEncoding. Characters
+// outside Latin-1 are replaced with '?'.
+func pdfEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 32 || r > 255 || (r >= 127 && r < 160):
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// pdfFit truncates s with an ellipsis so it fits within width points
+func pdfFit(s string, width, size float64, bold bool) string {
+	if pdfTextWidth(s, size, bold) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if candidate := string(runes) + "..."; pdfTextWidth(candidate, size, bold) <= width {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// pdfTextWidth measures a string in points using the Helvetica metrics
+func pdfTextWidth(s string, size float64, bold bool) float64 {
+	var units int
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	width := float64(units) * size / 1000
+	if bold {
+		width *= 1.06
+	}
+	return width
+}
+
+// helveticaWidths are the Helvetica glyph widths for ASCII 32-126 in
+// WinAnsiEncoding, in 1/1000 em
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space - /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0 - 9
+	278, 278, 584, 584, 584, 556, 1015, // : - @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A - M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N - Z
+	278, 278, 278, 469, 556, 333, // [ - `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a - m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n - z
+	334, 260, 334, 584, // { - ~
+}
@@ -0,0 +1,590 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+)
+
+// DataSource supplies the records a scheduled report is generated from
+type DataSource interface {
+	Transactions(start, end time.Time) []*models.Transaction
+	FraudAlerts(start, end time.Time) []*models.FraudAlert
+}
+
+// Scheduler runs the configured scheduled reports and delivers the exports
+type Scheduler struct {
+	config    *config.ReportingConfig
+	generator *Generator
+	source    DataSource
+	jobs      map[string]*Job
+	runs      []*Run
+	runIndex  map[string]*Run
+	mu        sync.RWMutex
+	running   bool
+	stopCh    chan struct{}
+	runCtx    context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+
+	// deliverers builds the destinations for a job; replaced in tests
+	deliverers func(job *Job) []Deliverer
+}
+
+// Job is a scheduled report and its next activation
+type Job struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Config     config.ScheduledReport `json:"config"`
+	Location   *time.Location         `json:"-"`
+	Timezone   string                 `json:"timezone"`
+	NextRun    time.Time              `json:"next_run"`
+	LastRun    *time.Time             `json:"last_run,omitempty"`
+	LastStatus RunStatus              `json:"last_status,omitempty"`
+	schedule   *Schedule
+}
+
+// Run is one execution of a scheduled report
+type Run struct {
+	ID          string           `json:"id"`
+	JobID       string           `json:"job_id"`
+	Trigger     string           `json:"trigger"` // schedule, manual
+	ReportID    string           `json:"report_id,omitempty"`
+	Format      Format           `json:"format"`
+	PeriodStart time.Time        `json:"period_start"`
+	PeriodEnd   time.Time        `json:"period_end"`
+	Status      RunStatus        `json:"status"`
+	Attempts    int              `json:"attempts"`
+	Deliveries  []DeliveryResult `json:"deliveries,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	StartedAt   time.Time        `json:"started_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+}
+
+// DeliveryResult records the outcome of delivering to one destination
+type DeliveryResult struct {
+	Method      string     `json:"method"`
+	Target      string     `json:"target"`
+	Status      RunStatus  `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// RunStatus represents the status of a run or delivery
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusPartial   RunStatus = "partial"
+	RunStatusFailed    RunStatus = "failed"
+)
+
+// NewScheduler creates a scheduler for the configured scheduled reports.
+// Schedules that fail to parse are logged and skipped.
+func NewScheduler(cfg *config.ReportingConfig, generator *Generator, source DataSource) *Scheduler {
+	s := &Scheduler{
+		config:    cfg,
+		generator: generator,
+		source:    source,
+		jobs:      make(map[string]*Job),
+		runIndex:  make(map[string]*Run),
+		stopCh:    make(chan struct{}),
+		now:       time.Now,
+		sleep:     sleepContext,
+	}
+	s.runCtx, s.cancel = context.WithCancel(context.Background())
+	s.deliverers = s.defaultDeliverers
+
+	for _, sr := range cfg.ScheduledReports {
+		if _, err := s.AddJob(sr); err != nil {
+			log.Printf("reporting: skipping scheduled report %q: %v", sr.Name, err)
+		}
+	}
+	return s
+}
+
+// AddJob registers a scheduled report
+func (s *Scheduler) AddJob(sr config.ScheduledReport) (*Job, error) {
+	schedule, err := ParseSchedule(sr.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	tz := sr.Timezone
+	if tz == "" {
+		tz = s.config.Timezone
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", tz, err)
+	}
+
+	if _, err := s.generator.Exporter(s.jobFormat(sr)); err != nil {
+		return nil, fmt.Errorf("format %q: %w", sr.Format, err)
+	}
+
+	job := &Job{
+		ID:       slugify(sr.Name),
+		Name:     sr.Name,
+		Config:   sr,
+		Location: loc,
+		Timezone: tz,
+		schedule: schedule,
+	}
+	job.NextRun = schedule.Next(s.now().In(loc))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[job.ID]; exists {
+		return nil, fmt.Errorf("duplicate scheduled report %q", sr.Name)
+	}
+	s.jobs[job.ID] = job
+	return job, nil
+}
+
+// Start starts the scheduling loop
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	go s.loop(ctx)
+	return nil
+}
+
+// Stop stops the scheduling loop, cancels pending retries and waits for
+// in-flight runs
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.running {
+		close(s.stopCh)
+		s.running = false
+	}
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.runDue()
+		}
+	}
+}
+
+// runDue starts every job whose activation time has passed
+func (s *Scheduler) runDue() {
+	now := s.now()
+
+	s.mu.Lock()
+	var due []*Job
+	for _, job := range s.jobs {
+		if !job.NextRun.IsZero() && !job.NextRun.After(now) {
+			due = append(due, job)
+			job.NextRun = job.schedule.Next(now.In(job.Location))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, job := range due {
+		run := s.newRun(job, "schedule", now)
+		s.wg.Add(1)
+		go func(job *Job, run *Run) {
+			defer s.wg.Done()
+			s.execute(s.runCtx, job, run)
+		}(job, run)
+	}
+}
+
+// RunNow starts a job immediately for the period ending at the current time
+// and returns the run record without waiting for completion
+func (s *Scheduler) RunNow(id string) (*Run, error) {
+	job, ok := s.job(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	run := s.newRun(job, "manual", s.now())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(s.runCtx, job, run)
+	}()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return run.copy(), nil
+}
+
+func (s *Scheduler) newRun(job *Job, trigger string, at time.Time) *Run {
+	start, end := ReportPeriod(models.ReportPeriod(job.Config.Period), at.In(job.Location))
+	run := &Run{
+		ID:          fmt.Sprintf("run-%s-%d", job.ID, at.UnixNano()),
+		JobID:       job.ID,
+		Trigger:     trigger,
+		Format:      s.jobFormat(job.Config),
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Status:      RunStatusRunning,
+		StartedAt:   at,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, run)
+	s.runIndex[run.ID] = run
+	limit := s.config.HistorySize
+	if limit <= 0 {
+		limit = 500
+	}
+	for len(s.runs) > limit {
+		delete(s.runIndex, s.runs[0].ID)
+		s.runs = s.runs[1:]
+	}
+	return run
+}
+
+// execute generates, exports and delivers a report, retrying failures
+func (s *Scheduler) execute(ctx context.Context, job *Job, run *Run) {
+	delivery, err := s.generate(ctx, job, run)
+	if err != nil {
+		s.finish(job, run, RunStatusFailed, err.Error())
+		return
+	}
+
+	deliverers := s.deliverers(job)
+	results := make([]DeliveryResult, len(deliverers))
+	failed := 0
+	for i, d := range deliverers {
+		results[i] = s.deliver(ctx, d, delivery)
+		if results[i].Status != RunStatusSucceeded {
+			failed++
+		}
+	}
+
+	status := RunStatusSucceeded
+	var message string
+	switch {
+	case failed > 0 && failed == len(deliverers):
+		status = RunStatusFailed
+		message = "all deliveries failed"
+	case failed > 0:
+		status = RunStatusPartial
+		message = fmt.Sprintf("%d of %d deliveries failed", failed, len(deliverers))
+	}
+
+	s.mu.Lock()
+	run.Deliveries = results
+	s.mu.Unlock()
+	s.finish(job, run, status, message)
+}
+
+// generate builds and exports the report, retrying transient failures
+func (s *Scheduler) generate(ctx context.Context, job *Job, run *Run) (*Delivery, error) {
+	var lastErr error
+	for attempt := 1; attempt <= s.maxAttempts(); attempt++ {
+		s.mu.Lock()
+		run.Attempts = attempt
+		s.mu.Unlock()
+
+		delivery, err := s.generateOnce(ctx, job, run)
+		if err == nil {
+			return delivery, nil
+		}
+		lastErr = err
+		log.Printf("reporting: %s attempt %d failed: %v", job.ID, attempt, err)
+
+		if attempt < s.maxAttempts() {
+			if err := s.sleep(ctx, s.retryDelay(attempt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+func (s *Scheduler) generateOnce(ctx context.Context, job *Job, run *Run) (*Delivery, error) {
+	reportType := models.ReportType(job.Config.Type)
+	report := s.generator.CreateReport(reportType, models.ReportPeriod(job.Config.Period), run.PeriodStart, run.PeriodEnd)
+	// The delivery carries the rendered report, so it is not kept in the
+	// generator, where every run would otherwise accumulate
+	defer s.generator.DeleteReport(report.ID)
+
+	s.mu.Lock()
+	run.ReportID = report.ID
+	s.mu.Unlock()
+
+	txns := s.source.Transactions(run.PeriodStart, run.PeriodEnd)
+
+	var err error
+	switch reportType {
+	case models.ReportTypeCashFlow:
+		err = s.generator.GenerateCashFlowReport(ctx, report.ID, txns)
+	case models.ReportTypeFraud:
+		alerts := s.source.FraudAlerts(run.PeriodStart, run.PeriodEnd)
+		err = s.generator.GenerateFraudReport(ctx, report.ID, alerts, txns)
+	default:
+		err = s.generator.GenerateTransactionReport(ctx, report.ID, txns)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := s.generator.Exporter(run.Format)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := s.generator.Export(&buf, report.ID, run.Format); err != nil {
+		return nil, err
+	}
+
+	return &Delivery{
+		Schedule:    job.Name,
+		Report:      report,
+		FileName:    FileName(report, run.Format),
+		ContentType: exporter.ContentType(),
+		Content:     buf.Bytes(),
+	}, nil
+}
+
+// deliver sends to one destination with retries and exponential backoff
+func (s *Scheduler) deliver(ctx context.Context, d Deliverer, delivery *Delivery) DeliveryResult {
+	result := DeliveryResult{Method: d.Method(), Target: d.Target()}
+
+	for attempt := 1; attempt <= s.maxAttempts(); attempt++ {
+		result.Attempts = attempt
+		err := d.Deliver(ctx, delivery)
+		if err == nil {
+			now := s.now()
+			result.Status = RunStatusSucceeded
+			result.Error = ""
+			result.DeliveredAt = &now
+			return result
+		}
+		result.Error = err.Error()
+		log.Printf("reporting: %s delivery to %s attempt %d failed: %v", d.Method(), d.Target(), attempt, err)
+
+		if attempt < s.maxAttempts() {
+			if err := s.sleep(ctx, s.retryDelay(attempt)); err != nil {
+				break
+			}
+		}
+	}
+
+	result.Status = RunStatusFailed
+	return result
+}
+
+func (s *Scheduler) finish(job *Job, run *Run, status RunStatus, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	run.Status = status
+	run.Error = message
+	run.FinishedAt = &now
+	job.LastRun = &run.StartedAt
+	job.LastStatus = status
+}
+
+// defaultDeliverers returns the configured destinations for a job
+func (s *Scheduler) defaultDeliverers(job *Job) []Deliverer {
+	var deliverers []Deliverer
+	if len(job.Config.Recipients) > 0 {
+		if s.config.SMTP.Host == "" {
+			log.Printf("reporting: %s has recipients but no SMTP host is configured", job.ID)
+		} else {
+			deliverers = append(deliverers, NewEmailDeliverer(s.config.SMTP, job.Config.Recipients))
+		}
+	}
+	if job.Config.WebhookURL != "" {
+		deliverers = append(deliverers, NewWebhookDeliverer(job.Config.WebhookURL))
+	}
+	if job.Config.S3Upload {
+		if s.config.S3.Bucket == "" {
+			log.Printf("reporting: %s requests S3 upload but no bucket is configured", job.ID)
+		} else {
+			deliverers = append(deliverers, NewS3Deliverer(s.config.S3))
+		}
+	}
+	return deliverers
+}
+
+func (s *Scheduler) jobFormat(sr config.ScheduledReport) Format {
+	if sr.Format != "" {
+		return Format(strings.ToLower(sr.Format))
+	}
+	return s.generator.DefaultFormat()
+}
+
+func (s *Scheduler) maxAttempts() int {
+	if s.config.MaxRetries < 0 {
+		return 1
+	}
+	return s.config.MaxRetries + 1
+}
+
+func (s *Scheduler) retryDelay(attempt int) time.Duration {
+	delay := s.config.RetryDelay
+	if delay <= 0 {
+		delay = 30 * time.Second
+	}
+	return delay * time.Duration(1<<uint(attempt-1))
+}
+
+// GetJob retrieves a job by ID or name
+func (s *Scheduler) GetJob(id string) (*Job, bool) {
+	job, ok := s.job(id)
+	if !ok {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	copied := *job
+	return &copied, true
+}
+
+func (s *Scheduler) job(id string) (*Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if job, ok := s.jobs[id]; ok {
+		return job, true
+	}
+	job, ok := s.jobs[slugify(id)]
+	return job, ok
+}
+
+// GetJobs returns all jobs ordered by next run
+func (s *Scheduler) GetJobs() []*Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].NextRun.Before(jobs[j].NextRun)
+	})
+	return jobs
+}
+
+// GetRun retrieves a run by ID
+func (s *Scheduler) GetRun(id string) (*Run, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run, ok := s.runIndex[id]
+	if !ok {
+		return nil, false
+	}
+	return run.copy(), true
+}
+
+// GetRuns returns the run history, newest first, optionally for one job
+func (s *Scheduler) GetRuns(jobID string, limit int) []*Run {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*Run
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		run := s.runs[i]
+		if jobID != "" && run.JobID != jobID {
+			continue
+		}
+		results = append(results, run.copy())
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+	return results
+}
+
+func (r *Run) copy() *Run {
+	copied := *r
+	copied.Deliveries = append([]DeliveryResult(nil), r.Deliveries...)
+	return &copied
+}
+
+// ReportPeriod returns the rolling period that a run at t reports on, in t's
+// location. Daily covers the previous day, weekly the previous seven days,
+// monthly, quarterly and yearly the previous calendar month, quarter or year.
+// The end is inclusive.
+func ReportPeriod(period models.ReportPeriod, t time.Time) (time.Time, time.Time) {
+	loc := t.Location()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+	var start, end time.Time
+	switch period {
+	case models.ReportPeriodWeekly:
+		start, end = today.AddDate(0, 0, -7), today
+	case models.ReportPeriodMonthly:
+		end = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		start = end.AddDate(0, -1, 0)
+	case models.ReportPeriodQuarterly:
+		q := (int(t.Month()) - 1) / 3
+		end = time.Date(t.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, loc)
+		start = end.AddDate(0, -3, 0)
+	case models.ReportPeriodYearly:
+		end = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
+		start = end.AddDate(-1, 0, 0)
+	default:
+		start, end = today.AddDate(0, 0, -1), today
+	}
+	return start, end.Add(-time.Nanosecond)
+}
+
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,296 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/pkg/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseSchedule_Next(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC) // Wednesday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 6 * * *", time.Date(2024, 2, 1, 6, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 29 2 *", time.Date(2024, 2, 29, 8, 30, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 2, 4, 12, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)}, // day-of-month OR day-of-week
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: ParseSchedule failed: %v", tt.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: Next = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "0 0 * foo *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestSchedule_NextTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	s, _ := ParseSchedule("0 6 * * *")
+
+	// 2024-03-10 is the US spring-forward date
+	got := s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, loc))
+	want := time.Date(2024, 3, 10, 6, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+	if got.UTC().Hour() != 10 {
+		t.Errorf("UTC hour = %d, want 10 (EDT)", got.UTC().Hour())
+	}
+}
+
+func TestReportPeriod(t *testing.T) {
+	at := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		period models.ReportPeriod
+		start  time.Time
+		end    time.Time
+	}{
+		{models.ReportPeriodDaily, time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{models.ReportPeriodWeekly, time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{models.ReportPeriodMonthly, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{models.ReportPeriodQuarterly, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		start, end := ReportPeriod(tt.period, at)
+		if !start.Equal(tt.start) || !end.Equal(tt.end.Add(-time.Nanosecond)) {
+			t.Errorf("%s: period = %s - %s, want %s - %s", tt.period, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+type staticSource struct {
+	txns []*models.Transaction
+}
+
+func (s *staticSource) Transactions(start, end time.Time) []*models.Transaction { return s.txns }
+func (s *staticSource) FraudAlerts(start, end time.Time) []*models.FraudAlert   { return nil }
+
+type flakyDeliverer struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	last     *Delivery
+}
+
+func (f *flakyDeliverer) Method() string { return "test" }
+func (f *flakyDeliverer) Target() string { return "memory" }
+func (f *flakyDeliverer) Deliver(ctx context.Context, d *Delivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("temporarily unavailable")
+	}
+	f.last = d
+	return nil
+}
+
+func newTestScheduler(t *testing.T, sr config.ScheduledReport, maxRetries int) *Scheduler {
+	t.Helper()
+
+	cfg := &config.ReportingConfig{MaxRetries: maxRetries, Timezone: "UTC"}
+	now := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC)
+	source := &staticSource{txns: []*models.Transaction{{
+		ID:        "TXN-001",
+		Type:      models.TransactionTypeCredit,
+		Amount:    decimal.NewFromInt(250),
+		CreatedAt: time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC),
+	}}}
+
+	s := NewScheduler(cfg, NewGenerator(cfg), source)
+	s.now = func() time.Time { return now }
+	s.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	if _, err := s.AddJob(sr); err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	return s
+}
+
+func waitForRun(t *testing.T, s *Scheduler, id string) *Run {
+	t.Helper()
+	s.wg.Wait()
+	run, ok := s.GetRun(id)
+	if !ok {
+		t.Fatalf("run %s not found", id)
+	}
+	return run
+}
+
+func TestScheduler_RunNowWithRetry(t *testing.T) {
+	s := newTestScheduler(t, config.ScheduledReport{
+		Name: "Daily Summary", Type: "transaction", Period: "daily", Schedule: "0 6 * * *", Format: "csv",
+	}, 2)
+	deliverer := &flakyDeliverer{failures: 2}
+	s.deliverers = func(job *Job) []Deliverer { return []Deliverer{deliverer} }
+
+	run, err := s.RunNow("Daily Summary")
+	if err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+	run = waitForRun(t, s, run.ID)
+
+	if run.Status != RunStatusSucceeded {
+		t.Fatalf("Status = %s (%s), want succeeded", run.Status, run.Error)
+	}
+	if len(run.Deliveries) != 1 || run.Deliveries[0].Attempts != 3 {
+		t.Errorf("Deliveries = %+v, want one delivery after 3 attempts", run.Deliveries)
+	}
+	if !strings.Contains(string(deliverer.last.Content), "250.00") {
+		t.Errorf("export does not contain the transaction volume:\n%s", deliverer.last.Content)
+	}
+	if deliverer.last.FileName != "transaction_20240514_20240514.csv" {
+		t.Errorf("FileName = %s", deliverer.last.FileName)
+	}
+	if reports := s.generator.GetReports(ReportFilter{}); len(reports) != 0 {
+		t.Errorf("generator kept %d scheduled reports, want 0", len(reports))
+	}
+
+	job, _ := s.GetJob("daily-summary")
+	if job.LastStatus != RunStatusSucceeded || !job.NextRun.Equal(time.Date(2024, 5, 16, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("job = %s next %s", job.LastStatus, job.NextRun)
+	}
+}
+
+func TestScheduler_DeliveryFailure(t *testing.T) {
+	s := newTestScheduler(t, config.ScheduledReport{
+		Name: "Weekly", Type: "cash_flow", Period: "weekly", Schedule: "@weekly", Format: "pdf",
+	}, 1)
+	ok := &flakyDeliverer{}
+	broken := &flakyDeliverer{failures: 10}
+	s.deliverers = func(job *Job) []Deliverer { return []Deliverer{ok, broken} }
+
+	run, _ := s.RunNow("weekly")
+	run = waitForRun(t, s, run.ID)
+
+	if run.Status != RunStatusPartial {
+		t.Errorf("Status = %s, want partial", run.Status)
+	}
+	if broken.calls != 2 {
+		t.Errorf("broken deliverer calls = %d, want 2", broken.calls)
+	}
+	if runs := s.GetRuns("weekly", 0); len(runs) != 1 {
+		t.Errorf("history = %d runs, want 1", len(runs))
+	}
+}
+
+func TestScheduler_RunDue(t *testing.T) {
+	s := newTestScheduler(t, config.ScheduledReport{
+		Name: "Daily", Type: "transaction", Period: "daily", Schedule: "0 6 * * *", Format: "csv",
+	}, 0)
+	deliverer := &flakyDeliverer{}
+	s.deliverers = func(job *Job) []Deliverer { return []Deliverer{deliverer} }
+
+	s.runDue()
+	if deliverer.calls != 0 {
+		t.Fatal("job ran before it was due")
+	}
+
+	next := time.Date(2024, 5, 16, 6, 0, 30, 0, time.UTC)
+	s.now = func() time.Time { return next }
+	s.runDue()
+	s.wg.Wait()
+
+	if deliverer.calls != 1 {
+		t.Errorf("deliveries = %d, want 1", deliverer.calls)
+	}
+	runs := s.GetRuns("", 0)
+	if len(runs) != 1 || runs[0].Trigger != "schedule" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	if !runs[0].PeriodStart.Equal(time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("PeriodStart = %s", runs[0].PeriodStart)
+	}
+}
+
+func TestWebhookAndS3Deliverers(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	report := &models.FinancialReport{ID: "rpt-1", Type: models.ReportTypeTransaction, EndDate: time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC)}
+	delivery := &Delivery{Schedule: "daily", Report: report, FileName: "r.csv", ContentType: "text/csv", Content: []byte("a,b\n")}
+
+	if err := NewWebhookDeliverer(server.URL+"/hook").Deliver(context.Background(), delivery); err != nil {
+		t.Fatalf("webhook Deliver failed: %v", err)
+	}
+	if requests[0].Header.Get("X-FinSight-Report-ID") != "rpt-1" || bodies[0] != "a,b\n" {
+		t.Errorf("webhook request = %v %q", requests[0].Header, bodies[0])
+	}
+
+	s3 := NewS3Deliverer(config.S3Config{Endpoint: server.URL, Bucket: "reports", Prefix: "finsight/", AccessKey: "AK", SecretKey: "SK"})
+	if err := s3.Deliver(context.Background(), delivery); err != nil {
+		t.Fatalf("S3 Deliver failed: %v", err)
+	}
+	req := requests[1]
+	if req.Method != http.MethodPut || req.URL.Path != "/reports/finsight/2024/05/14/rpt-1_r.csv" {
+		t.Errorf("S3 request = %s %s", req.Method, req.URL.Path)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AK/") {
+		t.Errorf("Authorization = %s", auth)
+	}
+}
+
+func TestEmailDeliverer(t *testing.T) {
+	e := NewEmailDeliverer(config.SMTPConfig{Host: "smtp.example.com", Port: 587, From: "reports@example.com"}, []string{"a@example.com", "b@example.com"})
+
+	var addr string
+	var rcpt []string
+	var msg []byte
+	e.send = func(a string, auth smtp.Auth, from string, to []string, m []byte) error {
+		addr, rcpt, msg = a, to, m
+		return nil
+	}
+
+	report := &models.FinancialReport{ID: "rpt-1", Type: models.ReportTypeFraud}
+	delivery := &Delivery{Schedule: "Fraud digest", Report: report, FileName: "fraud.pdf", ContentType: "application/pdf", Content: []byte("%PDF-1.4")}
+	if err := e.Deliver(context.Background(), delivery); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+
+	if addr != "smtp.example.com:587" || len(rcpt) != 2 {
+		t.Errorf("sent to %s %v", addr, rcpt)
+	}
+	for _, want := range []string{"Subject: Fraud digest", `filename=fraud.pdf`, "JVBERi0xLjQ="} {
+		if !strings.Contains(string(msg), want) {
+			t.Errorf("message missing %q", want)
+		}
+	}
+}
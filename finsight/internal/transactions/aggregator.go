@@ -45,9 +45,11 @@ func (a *Aggregator) Add(txn *models.Transaction) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	amount := txn.ReportingAmount()
+
 	// Update totals
 	a.totalCount++
-	a.totalVolume = a.totalVolume.Add(txn.Amount)
+	a.totalVolume = a.totalVolume.Add(amount)
 
 	// By type
 	typeName := string(txn.Type)
@@ -55,7 +57,7 @@ func (a *Aggregator) Add(txn *models.Transaction) {
 		a.byType[typeName] = &TypeStats{}
 	}
 	a.byType[typeName].Count++
-	a.byType[typeName].Volume = a.byType[typeName].Volume.Add(txn.Amount)
+	a.byType[typeName].Volume = a.byType[typeName].Volume.Add(amount)
 	if a.byType[typeName].Count > 0 {
 		a.byType[typeName].Average = a.byType[typeName].Volume.Div(decimal.NewFromInt(int64(a.byType[typeName].Count)))
 	}
@@ -66,7 +68,7 @@ func (a *Aggregator) Add(txn *models.Transaction) {
 			a.byCategory[txn.Category] = &CategoryStats{}
 		}
 		a.byCategory[txn.Category].Count++
-		a.byCategory[txn.Category].Volume = a.byCategory[txn.Category].Volume.Add(txn.Amount)
+		a.byCategory[txn.Category].Volume = a.byCategory[txn.Category].Volume.Add(amount)
 	}
 
 	// By status
@@ -81,7 +83,7 @@ func (a *Aggregator) Add(txn *models.Transaction) {
 	if _, ok := a.dailyVolume[day]; !ok {
 		a.dailyVolume[day] = decimal.Zero
 	}
-	a.dailyVolume[day] = a.dailyVolume[day].Add(txn.Amount)
+	a.dailyVolume[day] = a.dailyVolume[day].Add(amount)
 
 	// Hourly rolling window
 	currentHour := time.Now().Hour()
@@ -91,7 +93,7 @@ func (a *Aggregator) Add(txn *models.Transaction) {
 		a.hourlyVolume[currentHour] = decimal.Zero
 		a.hourlyCount[currentHour] = 0
 	}
-	a.hourlyVolume[currentHour] = a.hourlyVolume[currentHour].Add(txn.Amount)
+	a.hourlyVolume[currentHour] = a.hourlyVolume[currentHour].Add(amount)
 	a.hourlyCount[currentHour]++
 }
 
@@ -100,15 +102,17 @@ func (a *Aggregator) Remove(txn *models.Transaction) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	amount := txn.ReportingAmount()
+
 	// Update totals
 	a.totalCount--
-	a.totalVolume = a.totalVolume.Sub(txn.Amount)
+	a.totalVolume = a.totalVolume.Sub(amount)
 
 	// By type
 	typeName := string(txn.Type)
 	if stats, ok := a.byType[typeName]; ok {
 		stats.Count--
-		stats.Volume = stats.Volume.Sub(txn.Amount)
+		stats.Volume = stats.Volume.Sub(amount)
 		if stats.Count > 0 {
 			stats.Average = stats.Volume.Div(decimal.NewFromInt(int64(stats.Count)))
 		} else {
@@ -120,7 +124,7 @@ func (a *Aggregator) Remove(txn *models.Transaction) {
 	if txn.Category != "" {
 		if stats, ok := a.byCategory[txn.Category]; ok {
 			stats.Count--
-			stats.Volume = stats.Volume.Sub(txn.Amount)
+			stats.Volume = stats.Volume.Sub(amount)
 		}
 	}
 
@@ -138,7 +142,7 @@ func (a *Aggregator) Remove(txn *models.Transaction) {
 	// Daily volume
 	day := txn.CreatedAt.Format("2006-01-02")
 	if vol, ok := a.dailyVolume[day]; ok {
-		a.dailyVolume[day] = vol.Sub(txn.Amount)
+		a.dailyVolume[day] = vol.Sub(amount)
 	}
 }
 
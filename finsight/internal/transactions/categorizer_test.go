@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/internal/fx"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
 )
@@ -1049,6 +1050,178 @@ func TestEngine_UpdateAccountBalances_Transfer(t *testing.T) {
 	}
 }
 
+func TestEngine_UpdateAccountBalances_HoldSettle(t *testing.T) {
+	cfg := &config.TransactionsConfig{}
+	e := NewEngine(cfg)
+	ctx := context.Background()
+
+	e.CreateAccount(&models.Account{ID: "ACC-001", Balance: decimal.NewFromFloat(1000)})
+
+	txn := &models.Transaction{
+		ID:            "TXN-001",
+		Type:          models.TransactionTypeDebit,
+		Status:        models.TransactionStatusHeld,
+		SourceAccount: "ACC-001",
+		Amount:        decimal.NewFromFloat(250),
+		CreatedAt:     time.Now(),
+	}
+	e.ProcessTransaction(ctx, txn)
+
+	got, _ := e.GetAccount("ACC-001")
+	if !got.Balance.Equal(decimal.NewFromFloat(1000)) {
+		t.Errorf("Balance = %s, want 1000", got.Balance)
+	}
+	if !got.HoldAmount.Equal(decimal.NewFromFloat(250)) {
+		t.Errorf("HoldAmount = %s, want 250", got.HoldAmount)
+	}
+	if !got.AvailableBal.Equal(decimal.NewFromFloat(750)) {
+		t.Errorf("AvailableBal = %s, want 750", got.AvailableBal)
+	}
+
+	txn.Status = models.TransactionStatusCompleted
+	e.ProcessTransaction(ctx, txn)
+
+	got, _ = e.GetAccount("ACC-001")
+	if !got.Balance.Equal(decimal.NewFromFloat(750)) {
+		t.Errorf("Balance after settle = %s, want 750", got.Balance)
+	}
+	if !got.HoldAmount.IsZero() {
+		t.Errorf("HoldAmount after settle = %s, want 0", got.HoldAmount)
+	}
+}
+
+func TestEngine_UpdateAccountBalances_Reversal(t *testing.T) {
+	cfg := &config.TransactionsConfig{}
+	e := NewEngine(cfg)
+	ctx := context.Background()
+
+	e.CreateAccount(&models.Account{ID: "ACC-001", Balance: decimal.NewFromFloat(1000)})
+
+	txn := &models.Transaction{
+		ID:            "TXN-001",
+		Type:          models.TransactionTypeDebit,
+		SourceAccount: "ACC-001",
+		Amount:        decimal.NewFromFloat(100),
+		CreatedAt:     time.Now(),
+	}
+	e.ProcessTransaction(ctx, txn)
+
+	// Reprocessing an already posted transaction must not post it twice
+	e.ProcessTransaction(ctx, txn)
+	got, _ := e.GetAccount("ACC-001")
+	if !got.Balance.Equal(decimal.NewFromFloat(900)) {
+		t.Errorf("Balance = %s, want 900", got.Balance)
+	}
+
+	txn.Status = models.TransactionStatusReversed
+	e.ProcessTransaction(ctx, txn)
+
+	got, _ = e.GetAccount("ACC-001")
+	if !got.Balance.Equal(decimal.NewFromFloat(1000)) {
+		t.Errorf("Balance after reversal = %s, want 1000", got.Balance)
+	}
+	if journals := e.GetTransactionJournals("TXN-001"); len(journals) != 2 {
+		t.Errorf("journals = %d, want 2", len(journals))
+	}
+	if tb := e.GetTrialBalance(time.Now()); !tb.Balanced {
+		t.Error("trial balance should be balanced")
+	}
+}
+
+func TestEngine_UpdateAccountBalances_RepeatedReversal(t *testing.T) {
+	cfg := &config.TransactionsConfig{}
+	e := NewEngine(cfg)
+	ctx := context.Background()
+
+	e.CreateAccount(&models.Account{ID: "ACC-001", Balance: decimal.NewFromFloat(1000)})
+
+	txn := &models.Transaction{
+		ID:            "TXN-001",
+		Type:          models.TransactionTypeDebit,
+		SourceAccount: "ACC-001",
+		Amount:        decimal.NewFromFloat(100),
+		CreatedAt:     time.Now(),
+	}
+	e.ProcessTransaction(ctx, txn)
+
+	// Reprocessing a reversed transaction must not reverse the reversal
+	txn.Status = models.TransactionStatusReversed
+	for i := 0; i < 3; i++ {
+		e.ProcessTransaction(ctx, txn)
+
+		got, _ := e.GetAccount("ACC-001")
+		if !got.Balance.Equal(decimal.NewFromFloat(1000)) {
+			t.Errorf("Balance after reversal %d = %s, want 1000", i+1, got.Balance)
+		}
+	}
+	if journals := e.GetTransactionJournals("TXN-001"); len(journals) != 2 {
+		t.Errorf("journals = %d, want 2", len(journals))
+	}
+}
+
+func TestEngine_UpdateAccountBalances_Unpostable(t *testing.T) {
+	e := NewEngine(&config.TransactionsConfig{})
+	ctx := context.Background()
+
+	e.CreateAccount(&models.Account{ID: "ACC-001", Balance: decimal.NewFromFloat(1000)})
+
+	// A transaction not linked to an account has no ledger effect
+	if err := e.ProcessTransaction(ctx, &models.Transaction{
+		ID:        "TXN-001",
+		Type:      models.TransactionTypeDebit,
+		Amount:    decimal.NewFromFloat(100),
+		CreatedAt: time.Now(),
+	}); err != nil {
+		t.Errorf("unlinked transaction failed: %v", err)
+	}
+
+	// One that cannot be posted is reported, not silently left off the ledger
+	if err := e.ProcessTransaction(ctx, &models.Transaction{
+		ID:            "TXN-002",
+		Type:          models.TransactionTypeTransfer,
+		SourceAccount: "ACC-001",
+		Amount:        decimal.NewFromFloat(100),
+		CreatedAt:     time.Now(),
+	}); err == nil {
+		t.Error("transfer without destination should fail")
+	}
+}
+
+func TestEngine_ProcessTransaction_Conversion(t *testing.T) {
+	e := NewEngine(&config.TransactionsConfig{})
+	conv := fx.NewConverter(&config.FXConfig{BaseCurrency: "USD"})
+	conv.AddRates([]fx.Rate{{Base: "EUR", Quote: "USD", Date: time.Now().AddDate(0, 0, -1), Rate: decimal.RequireFromString("1.10")}})
+	e.SetConverter(conv)
+	ctx := context.Background()
+
+	eur := &models.Transaction{
+		ID:           "TXN-001",
+		Amount:       decimal.NewFromInt(20000),
+		Currency:     "EUR",
+		BaseAmount:   decimal.NewFromInt(1),
+		BaseCurrency: "USD",
+		CreatedAt:    time.Now(),
+	}
+	if err := e.ProcessTransaction(ctx, eur); err != nil {
+		t.Fatalf("ProcessTransaction failed: %v", err)
+	}
+	if !eur.BaseAmount.Equal(decimal.NewFromInt(22000)) {
+		t.Errorf("BaseAmount = %s, want 22000", eur.BaseAmount)
+	}
+
+	// A currency without a rate is accepted and left unconverted
+	jpy := &models.Transaction{ID: "TXN-002", Amount: decimal.NewFromInt(500), Currency: "JPY", CreatedAt: time.Now()}
+	if err := e.ProcessTransaction(ctx, jpy); err != nil {
+		t.Fatalf("ProcessTransaction without a rate failed: %v", err)
+	}
+	if jpy.BaseCurrency != "" {
+		t.Errorf("BaseCurrency = %q, want unconverted", jpy.BaseCurrency)
+	}
+	if _, ok := e.GetTransaction("TXN-002"); !ok {
+		t.Error("transaction without a rate should be stored")
+	}
+}
+
 func TestEngine_GetAccountTransactions(t *testing.T) {
 	cfg := &config.TransactionsConfig{}
 	e := NewEngine(cfg)
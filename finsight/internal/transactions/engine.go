@@ -2,10 +2,15 @@ package transactions
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/savegress/finsight/internal/config"
+	"github.com/savegress/finsight/internal/fx"
+	"github.com/savegress/finsight/internal/ledger"
 	"github.com/savegress/finsight/pkg/models"
 	"github.com/shopspring/decimal"
 )
@@ -17,6 +22,8 @@ type Engine struct {
 	accounts     map[string]*models.Account
 	categorizer  *Categorizer
 	aggregator   *Aggregator
+	ledger       *ledger.Ledger
+	converter    *fx.Converter
 	mu           sync.RWMutex
 	running      bool
 	stopCh       chan struct{}
@@ -30,10 +37,19 @@ func NewEngine(cfg *config.TransactionsConfig) *Engine {
 		accounts:     make(map[string]*models.Account),
 		categorizer:  NewCategorizer(),
 		aggregator:   NewAggregator(),
+		ledger:       ledger.NewLedger(),
 		stopCh:       make(chan struct{}),
 	}
 }
 
+// SetConverter sets the FX converter used to record base-currency amounts
+// on incoming transactions
+func (e *Engine) SetConverter(c *fx.Converter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.converter = c
+}
+
 // Start starts the transaction engine
 func (e *Engine) Start(ctx context.Context) error {
 	e.mu.Lock()
@@ -96,6 +112,17 @@ func (e *Engine) ProcessTransaction(ctx context.Context, txn *models.Transaction
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// Record the base-currency amount and the rate used. A transaction in a
+	// currency without a rate is still processed, unconverted.
+	if e.converter != nil {
+		if err := e.converter.Apply(txn); err != nil {
+			if !errors.Is(err, fx.ErrRateNotFound) {
+				return err
+			}
+			log.Printf("transactions: %s left unconverted: %v", txn.ID, err)
+		}
+	}
+
 	// Auto-categorize
 	if e.config.CategorizationEnabled && txn.Category == "" {
 		txn.Category = e.categorizer.Categorize(txn)
@@ -183,35 +210,88 @@ func (e *Engine) matchesFilter(txn *models.Transaction, filter TransactionFilter
 	return true
 }
 
+// updateAccountBalances posts the transaction to the ledger and re-derives
+// the balances of the accounts it touches. A transaction that was already
+// posted is moved through its hold/settle/release/reverse lifecycle instead
+// of being posted twice.
 func (e *Engine) updateAccountBalances(txn *models.Transaction) error {
-	switch txn.Type {
-	case models.TransactionTypeDebit:
-		if acc, ok := e.accounts[txn.SourceAccount]; ok {
-			acc.Balance = acc.Balance.Sub(txn.Amount)
-			acc.AvailableBal = acc.AvailableBal.Sub(txn.Amount)
-			acc.UpdatedAt = time.Now()
-		}
-	case models.TransactionTypeCredit:
-		if acc, ok := e.accounts[txn.SourceAccount]; ok {
-			acc.Balance = acc.Balance.Add(txn.Amount)
-			acc.AvailableBal = acc.AvailableBal.Add(txn.Amount)
-			acc.UpdatedAt = time.Now()
-		}
-	case models.TransactionTypeTransfer:
-		if src, ok := e.accounts[txn.SourceAccount]; ok {
-			src.Balance = src.Balance.Sub(txn.Amount)
-			src.AvailableBal = src.AvailableBal.Sub(txn.Amount)
-			src.UpdatedAt = time.Now()
+	var err error
+	journals := e.ledger.GetTransactionJournals(txn.ID)
+	if len(journals) == 0 {
+		err = e.postTransaction(txn)
+	} else {
+		err = e.transitionTransaction(txn, journals[len(journals)-1])
+	}
+	if err != nil {
+		return err
+	}
+
+	e.syncAccountBalances(txn.SourceAccount, txn.DestAccount)
+	return nil
+}
+
+func (e *Engine) postTransaction(txn *models.Transaction) error {
+	if !txn.Amount.IsPositive() {
+		return nil
+	}
+	lines, err := ledger.LinesForTransaction(txn)
+	if errors.Is(err, ledger.ErrNoLedgerEffect) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("post transaction %s: %w", txn.ID, err)
+	}
+
+	switch txn.Status {
+	case models.TransactionStatusHeld:
+		_, err = e.ledger.Hold(txn.ID, txn.Currency, txn.Description, txn.CreatedAt, lines)
+	case models.TransactionStatusFailed, models.TransactionStatusReversed:
+		return nil
+	default:
+		_, err = e.ledger.Post(txn.ID, txn.Currency, txn.Description, txn.CreatedAt, lines)
+	}
+	return err
+}
+
+func (e *Engine) transitionTransaction(txn *models.Transaction, last *ledger.Journal) error {
+	switch last.Status {
+	case ledger.JournalStatusPending:
+		switch txn.Status {
+		case models.TransactionStatusCompleted:
+			settledAt := time.Now()
+			if txn.SettledAt != nil {
+				settledAt = *txn.SettledAt
+			}
+			return e.ledger.Settle(last.ID, settledAt)
+		case models.TransactionStatusFailed, models.TransactionStatusReversed:
+			return e.ledger.Release(last.ID, time.Now())
 		}
-		if dst, ok := e.accounts[txn.DestAccount]; ok {
-			dst.Balance = dst.Balance.Add(txn.Amount)
-			dst.AvailableBal = dst.AvailableBal.Add(txn.Amount)
-			dst.UpdatedAt = time.Now()
+	case ledger.JournalStatusPosted:
+		// The last journal is the reversal itself once the transaction has
+		// been reversed; reversing it again would re-apply the transaction
+		if txn.Status == models.TransactionStatusReversed && last.ReversalOf == "" {
+			_, err := e.ledger.Reverse(last.ID, "transaction reversed", time.Now())
+			return err
 		}
 	}
 	return nil
 }
 
+func (e *Engine) syncAccountBalances(accountIDs ...string) {
+	now := time.Now()
+	for _, id := range accountIDs {
+		acc, ok := e.accounts[id]
+		if !ok {
+			continue
+		}
+		bal := e.ledger.Balance(id, now)
+		acc.Balance = bal.Balance
+		acc.AvailableBal = bal.Available
+		acc.HoldAmount = bal.Pending
+		acc.UpdatedAt = now
+	}
+}
+
 // GetAccount retrieves an account by ID
 func (e *Engine) GetAccount(id string) (*models.Account, bool) {
 	e.mu.RLock()
@@ -226,7 +306,23 @@ func (e *Engine) CreateAccount(acc *models.Account) error {
 	defer e.mu.Unlock()
 	acc.CreatedAt = time.Now()
 	acc.UpdatedAt = time.Now()
+
+	// Record the opening balance so the account balance stays derivable
+	if !acc.Balance.IsZero() {
+		lines := []ledger.PostingLine{
+			{AccountID: ledger.AccountOpeningEquity, Direction: ledger.DirectionDebit, Amount: acc.Balance.Abs()},
+			{AccountID: acc.ID, Direction: ledger.DirectionCredit, Amount: acc.Balance.Abs()},
+		}
+		if acc.Balance.IsNegative() {
+			lines[0].Direction, lines[1].Direction = ledger.DirectionCredit, ledger.DirectionDebit
+		}
+		if _, err := e.ledger.Post("", acc.Currency, "Opening balance", acc.CreatedAt, lines); err != nil {
+			return err
+		}
+	}
+
 	e.accounts[acc.ID] = acc
+	e.syncAccountBalances(acc.ID)
 	return nil
 }
 
@@ -238,6 +334,26 @@ func (e *Engine) GetAccountTransactions(accountID string, limit int) []*models.T
 	})
 }
 
+// GetAccountBalanceAt derives an account balance from the ledger as of a point in time
+func (e *Engine) GetAccountBalanceAt(accountID string, asOf time.Time) *ledger.AccountBalance {
+	return e.ledger.Balance(accountID, asOf)
+}
+
+// GetAccountStatement returns the ledger statement of an account for a period
+func (e *Engine) GetAccountStatement(accountID string, from, to time.Time) *ledger.Statement {
+	return e.ledger.Statement(accountID, from, to)
+}
+
+// GetTrialBalance returns the ledger trial balance as of a point in time
+func (e *Engine) GetTrialBalance(asOf time.Time) *ledger.TrialBalance {
+	return e.ledger.TrialBalance(asOf)
+}
+
+// GetTransactionJournals returns the ledger journals posted for a transaction
+func (e *Engine) GetTransactionJournals(txnID string) []*ledger.Journal {
+	return e.ledger.GetTransactionJournals(txnID)
+}
+
 // GetStats returns transaction statistics
 func (e *Engine) GetStats() *TransactionStats {
 	e.mu.RLock()
@@ -37,6 +37,10 @@ type Transaction struct {
 	Status          TransactionStatus `json:"status"`
 	Amount          decimal.Decimal   `json:"amount"`
 	Currency        string            `json:"currency"`
+	BaseAmount      decimal.Decimal   `json:"base_amount"`
+	BaseCurrency    string            `json:"base_currency,omitempty"`
+	FXRate          decimal.Decimal   `json:"fx_rate"`
+	FXRateDate      *time.Time        `json:"fx_rate_date,omitempty"`
 	SourceAccount   string            `json:"source_account"`
 	DestAccount     string            `json:"dest_account,omitempty"`
 	Description     string            `json:"description"`
@@ -51,6 +55,15 @@ type Transaction struct {
 	SettledAt       *time.Time        `json:"settled_at,omitempty"`
 }
 
+// ReportingAmount returns the amount converted to the base currency when a
+// conversion has been recorded, and the original amount otherwise
+func (t *Transaction) ReportingAmount() decimal.Decimal {
+	if t.BaseCurrency != "" {
+		return t.BaseAmount
+	}
+	return t.Amount
+}
+
 // Merchant represents a merchant in a transaction
 type Merchant struct {
 	ID       string `json:"id"`
@@ -135,6 +148,8 @@ const (
 	FraudAlertTypeDevice       FraudAlertType = "device"
 	FraudAlertTypeIdentity     FraudAlertType = "identity"
 	FraudAlertTypeMerchant     FraudAlertType = "merchant"
+	FraudAlertTypeModel        FraudAlertType = "ml_model"
+	FraudAlertTypeRule         FraudAlertType = "rule"
 )
 
 // AlertSeverity represents the severity of an alert
@@ -207,10 +222,15 @@ type ReconcileException struct {
 	Type            ExceptionType   `json:"type"`
 	SourceRecord    *Transaction    `json:"source_record,omitempty"`
 	TargetRecord    *Transaction    `json:"target_record,omitempty"`
+	GroupID         string          `json:"group_id,omitempty"`
+	SourceRecords   []*Transaction  `json:"source_records,omitempty"`
+	TargetRecords   []*Transaction  `json:"target_records,omitempty"`
 	AmountDiff      decimal.Decimal `json:"amount_diff"`
 	Description     string          `json:"description"`
 	Status          ExceptionStatus `json:"status"`
 	Resolution      string          `json:"resolution,omitempty"`
+	PreparedBy      string          `json:"prepared_by,omitempty"`
+	ApprovedBy      string          `json:"approved_by,omitempty"`
 	CreatedAt       time.Time       `json:"created_at"`
 	ResolvedAt      *time.Time      `json:"resolved_at,omitempty"`
 }
@@ -223,6 +243,7 @@ const (
 	ExceptionTypeDuplicate  ExceptionType = "duplicate"
 	ExceptionTypeAmountDiff ExceptionType = "amount_diff"
 	ExceptionTypeDateDiff   ExceptionType = "date_diff"
+	ExceptionTypePartialMatch ExceptionType = "partial_match"
 	ExceptionTypeOther      ExceptionType = "other"
 )
 
@@ -233,6 +254,7 @@ const (
 	ExceptionStatusOpen     ExceptionStatus = "open"
 	ExceptionStatusResolved ExceptionStatus = "resolved"
 	ExceptionStatusWriteOff ExceptionStatus = "write_off"
+	ExceptionStatusPendingApproval ExceptionStatus = "pending_approval" // write-off awaiting approval
 )
 
 // FinancialReport represents a financial report
@@ -286,6 +308,8 @@ const (
 // ReportData contains the actual report data
 type ReportData struct {
 	TotalTransactions   int                     `json:"total_transactions"`
+	Currency            string                  `json:"currency,omitempty"`
+	UnconvertedTransactions int                 `json:"unconverted_transactions,omitempty"`
 	TotalVolume         decimal.Decimal         `json:"total_volume"`
 	NetFlow             decimal.Decimal         `json:"net_flow"`
 	ByType              map[string]TypeSummary  `json:"by_type"`
@@ -330,18 +354,24 @@ type FraudMetrics struct {
 	DetectionRate    float64         `json:"detection_rate"`
 }
 
-// AuditLog represents an audit log entry
+// AuditLog represents an audit log entry. Entries are hash-chained: Hash
+// covers the entry including PrevHash, the hash of the entry before it.
 type AuditLog struct {
 	ID          string                 `json:"id"`
+	Sequence    int64                  `json:"sequence"`
 	EntityType  string                 `json:"entity_type"`
 	EntityID    string                 `json:"entity_id"`
 	Action      string                 `json:"action"`
 	ActorID     string                 `json:"actor_id"`
 	ActorType   string                 `json:"actor_type"`
+	Reason      string                 `json:"reason,omitempty"`
 	Changes     map[string]interface{} `json:"changes,omitempty"`
 	IPAddress   string                 `json:"ip_address,omitempty"`
 	UserAgent   string                 `json:"user_agent,omitempty"`
+	RequestID   string                 `json:"request_id,omitempty"`
 	Timestamp   time.Time              `json:"timestamp"`
+	PrevHash    string                 `json:"prev_hash"`
+	Hash        string                 `json:"hash"`
 }
 
 // ComplianceRule represents a compliance rule
@@ -354,13 +384,23 @@ type ComplianceRule struct {
 	Actions     []RuleAction    `json:"actions"`
 	Enabled     bool     `json:"enabled"`
 	Priority    int      `json:"priority"`
+	DryRun      bool     `json:"dry_run"`
+	Version     int      `json:"version"`
+	UpdatedBy   string   `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-// RuleCondition represents a condition in a compliance rule
+// RuleCondition represents a condition in a compliance rule. With Aggregate
+// set the condition compares an aggregate of the account's transactions over
+// Window instead of a field of the current transaction; with Any set it
+// matches when any of the nested conditions does.
 type RuleCondition struct {
-	Field    string      `json:"field"`
-	Operator string      `json:"operator"`
-	Value    interface{} `json:"value"`
+	Field     string          `json:"field"`
+	Operator  string          `json:"operator"`
+	Value     interface{}     `json:"value"`
+	Aggregate string          `json:"aggregate,omitempty"`
+	Window    string          `json:"window,omitempty"`
+	Any       []RuleCondition `json:"any,omitempty"`
 }
 
 // RuleAction represents an action in a compliance rule
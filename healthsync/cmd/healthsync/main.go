@@ -16,6 +16,11 @@ import (
 	"github.com/savegress/healthsync/internal/compliance"
 	"github.com/savegress/healthsync/internal/config"
 	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/deidentification"
+	"github.com/savegress/healthsync/internal/export"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/inbound"
+	"github.com/savegress/healthsync/internal/mpi"
 )
 
 func main() {
@@ -42,6 +47,29 @@ func main() {
 	// Initialize consent manager
 	consentManager := consent.NewManager(&cfg.Consent)
 
+	// Initialize FHIR resource store shared by the API and HL7 channel;
+	// resources are kept in memory unless a database is configured
+	var store fhirstore.Store = fhirstore.NewMemoryStore()
+	if cfg.Database.URL != "" {
+		pgStore, err := fhirstore.NewPostgresStore(context.Background(), &cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to open FHIR store: %v", err)
+		}
+		defer pgStore.Close()
+		store = pgStore
+	}
+
+	// Initialize master patient index
+	mpiService := mpi.NewService(&cfg.MPI, store)
+
+	// Initialize Bulk Data export manager; exports can be de-identified
+	deidentConfig := deidentification.DefaultConfig()
+	deidentConfig.SaltKey = cfg.Server.JWTSecret
+	exportManager := export.NewManager(&cfg.Export, store, deidentification.NewEngine(deidentConfig), consentManager)
+
+	// Initialize HL7 v2 inbound channel
+	hl7Channel := inbound.NewChannel(&cfg.HL7, store, auditLogger, mpiService)
+
 	// Start engines
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -54,8 +82,23 @@ func main() {
 		log.Fatalf("Failed to start audit logger: %v", err)
 	}
 
+	if err := mpiService.Load(ctx); err != nil {
+		log.Fatalf("Failed to load master patient index: %v", err)
+	}
+
+	if err := exportManager.Start(ctx); err != nil {
+		log.Fatalf("Failed to start export manager: %v", err)
+	}
+
+	if cfg.HL7.Enabled {
+		if err := hl7Channel.Start(ctx); err != nil {
+			log.Fatalf("Failed to start HL7 channel: %v", err)
+		}
+		log.Printf("HL7 MLLP listener on %s", hl7Channel.Addr())
+	}
+
 	// Create API server
-	server := api.NewServer(cfg, complianceEngine, auditLogger, anonEngine, consentManager)
+	server := api.NewServer(cfg, complianceEngine, auditLogger, anonEngine, consentManager, store, mpiService, exportManager)
 
 	// Start HTTP server
 	httpServer := &http.Server{
@@ -89,6 +132,8 @@ func main() {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
 
+	hl7Channel.Stop()
+	exportManager.Stop()
 	complianceEngine.Stop()
 	auditLogger.Stop()
 
@@ -0,0 +1,465 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+// bundleRequest is a transaction or batch bundle posted to the base URL
+type bundleRequest struct {
+	ResourceType string               `json:"resourceType"`
+	Type         string               `json:"type"`
+	Entry        []bundleRequestEntry `json:"entry"`
+}
+
+type bundleRequestEntry struct {
+	FullURL  string                     `json:"fullUrl"`
+	Resource json.RawMessage            `json:"resource"`
+	Request  *models.BundleEntryRequest `json:"request"`
+}
+
+// transactionEntry is a transaction entry resolved to the resource it
+// writes or reads
+type transactionEntry struct {
+	method       string
+	resourceType string
+	id           string
+	fields       map[string]json.RawMessage
+	existing     *fhirstore.Resource // matched by If-None-Exist; nothing is written
+	write        int                 // index into the transaction's writes, or -1
+}
+
+// Headers copied from the bundle request to each entry dispatched through
+// the router
+var entryHeaders = []string{"X-User-ID", "X-User-Name", "X-Purpose", "X-Forwarded-Proto", "Prefer", "Authorization"}
+
+// ProcessBundle processes a transaction or batch bundle
+func (h *Handlers) ProcessBundle(w http.ResponseWriter, r *http.Request) {
+	if h.fhir.MaxBundleSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(h.fhir.MaxBundleSize))
+	}
+	var bundle bundleRequest
+	err := json.NewDecoder(r.Body).Decode(&bundle)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondOutcome(w, http.StatusRequestEntityTooLarge, "too-costly", fmt.Sprintf("bundle exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil || bundle.ResourceType != string(models.ResourceTypeBundle) {
+		respondOutcome(w, http.StatusBadRequest, "invalid", "request body must be a Bundle")
+		return
+	}
+	for i, entry := range bundle.Entry {
+		if entry.Request == nil || entry.Request.Method == "" || entry.Request.URL == "" {
+			respondOutcome(w, http.StatusBadRequest, "invalid", fmt.Sprintf("entry %d has no request method and url", i))
+			return
+		}
+	}
+
+	switch bundle.Type {
+	case "transaction":
+		h.processTransaction(w, r, bundle.Entry)
+	case "batch":
+		h.processBatch(w, r, bundle.Entry)
+	default:
+		respondOutcome(w, http.StatusBadRequest, "not-supported", fmt.Sprintf("bundle type %q cannot be processed; use transaction or batch", bundle.Type))
+	}
+}
+
+// processBatch performs each entry as a separate interaction; a failed
+// entry does not affect the others
+func (h *Handlers) processBatch(w http.ResponseWriter, r *http.Request, entries []bundleRequestEntry) {
+	base := h.fhirBase(r)
+	response := &models.Bundle{ResourceType: models.ResourceTypeBundle, Type: "batch-response"}
+	for _, entry := range entries {
+		target, err := entryURL(base, entry.Request.URL)
+		if err != nil {
+			response.Entry = append(response.Entry, errorEntry(newFHIRError(http.StatusBadRequest, "invalid", err.Error())))
+			continue
+		}
+		response.Entry = append(response.Entry, h.dispatchEntry(r, entry, target))
+	}
+	respondFHIR(w, http.StatusOK, response)
+}
+
+// processTransaction performs every entry or none. Writes are resolved
+// first: new resources get their ids, conditional interactions are matched
+// and references to other entries' fullUrls are rewritten. The writes are
+// then committed together, after which patients are indexed with the MPI
+// and read entries are performed.
+func (h *Handlers) processTransaction(w http.ResponseWriter, r *http.Request, entries []bundleRequestEntry) {
+	ctx := r.Context()
+	base := h.fhirBase(r)
+
+	resolved := make([]*transactionEntry, len(entries))
+	fullURLs := make(map[string]string) // fullUrl -> Type/id
+	for i, entry := range entries {
+		te, ferr := h.resolveEntry(r, base, entry)
+		if ferr != nil {
+			respondEntryError(w, i, ferr)
+			return
+		}
+		if te.existing != nil {
+			// A matched If-None-Exist returns the existing resource, which
+			// is a read the patient's consent must permit
+			if result := h.checkAccess(r, te.existing); result != nil && !result.Allowed {
+				h.logFHIRAccess(r, "R", te.existing.ResourceType, te.existing.ID, te.existing.PatientID(), "8") // Serious failure
+				respondEntryError(w, i, newFHIRError(http.StatusForbidden, "forbidden", "Access denied: "+result.Reason))
+				return
+			}
+		}
+		if entry.FullURL != "" && te.id != "" && te.method != http.MethodGet {
+			fullURLs[entry.FullURL] = te.resourceType + "/" + te.id
+		}
+		resolved[i] = te
+	}
+
+	// DELETE, POST and PUT entries are written in that order, as the
+	// specification processes them
+	var writes []fhirstore.Write
+	for _, method := range []string{http.MethodDelete, http.MethodPost, http.MethodPut} {
+		for i, te := range resolved {
+			if te.method != method || te.existing != nil || (method == http.MethodDelete && te.id == "") {
+				continue
+			}
+			write := fhirstore.Write{ResourceType: te.resourceType, ID: te.id}
+			if method != http.MethodDelete {
+				if err := rewriteReferences(te.fields, fullURLs); err != nil {
+					respondEntryError(w, i, newFHIRError(http.StatusBadRequest, "invalid", err.Error()))
+					return
+				}
+				var ferr *fhirError
+				if write, ferr = h.prepareWrite(ctx, te.resourceType, te.id, entries[i].Request.IfMatch, te.fields); ferr != nil {
+					respondEntryError(w, i, ferr)
+					return
+				}
+			}
+			te.write = len(writes)
+			writes = append(writes, write)
+		}
+	}
+
+	results, err := h.store.Transact(ctx, writes)
+	switch {
+	case errors.Is(err, fhirstore.ErrVersionConflict):
+		respondOutcome(w, http.StatusPreconditionFailed, "conflict", err.Error())
+		return
+	case errors.Is(err, fhirstore.ErrNotFound):
+		respondOutcome(w, http.StatusNotFound, "not-found", err.Error())
+		return
+	case err != nil:
+		respondOutcome(w, http.StatusBadRequest, "processing", err.Error())
+		return
+	}
+
+	response := &models.Bundle{ResourceType: models.ResourceTypeBundle, Type: "transaction-response"}
+	for i, te := range resolved {
+		switch {
+		case te.method == http.MethodGet:
+			target, _ := entryURL(base, entries[i].Request.URL)
+			response.Entry = append(response.Entry, h.dispatchEntry(r, entries[i], target))
+		case te.existing != nil:
+			h.logFHIRAccess(r, "R", te.existing.ResourceType, te.existing.ID, te.existing.PatientID(), "0")
+			response.Entry = append(response.Entry, resourceEntry(base, te.existing, http.StatusOK))
+		case te.write < 0:
+			// A conditional delete that matched nothing
+			response.Entry = append(response.Entry, models.BundleEntry{Response: &models.BundleEntryResponse{Status: strconv.Itoa(http.StatusNoContent)}})
+		case te.method == http.MethodDelete:
+			res := results[te.write].Resource
+			h.logFHIRAccess(r, "D", res.ResourceType, res.ID, "", "0")
+			response.Entry = append(response.Entry, models.BundleEntry{Response: &models.BundleEntryResponse{Status: strconv.Itoa(http.StatusNoContent)}})
+		default:
+			// The transaction is committed: a patient the MPI fails to
+			// index is still returned as stored, and the entries after it
+			// are still indexed and logged
+			result := results[te.write]
+			res, _, err := h.indexPatient(ctx, result.Resource, result.Created)
+			if err != nil {
+				log.Printf("MPI indexing of committed %s/%s failed: %v", result.Resource.ResourceType, result.Resource.ID, err)
+				res = result.Resource
+			}
+			action, status := "U", http.StatusOK
+			if result.Created {
+				action, status = "C", http.StatusCreated
+			}
+			h.logFHIRAccess(r, action, res.ResourceType, res.ID, res.PatientID(), "0")
+			response.Entry = append(response.Entry, resourceEntry(base, res, status))
+		}
+	}
+	respondFHIR(w, http.StatusOK, response)
+}
+
+// resolveEntry works out which resource a transaction entry writes,
+// matching conditional interactions against the current resources
+func (h *Handlers) resolveEntry(r *http.Request, base string, entry bundleRequestEntry) (*transactionEntry, *fhirError) {
+	target, err := entryURL(base, entry.Request.URL)
+	if err != nil {
+		return nil, newFHIRError(http.StatusBadRequest, "invalid", err.Error())
+	}
+	te := &transactionEntry{method: strings.ToUpper(entry.Request.Method), write: -1}
+	if te.method == http.MethodGet {
+		return te, nil
+	}
+
+	path, query, _ := strings.Cut(target, "?")
+	segments := strings.Split(path, "/")
+	te.resourceType = segments[0]
+	if !containsString(h.fhir.SupportedResources, te.resourceType) {
+		return nil, newFHIRError(http.StatusNotFound, "not-supported", "resource type "+te.resourceType+" is not supported")
+	}
+	if len(segments) > 2 {
+		return nil, newFHIRError(http.StatusBadRequest, "not-supported", entry.Request.Method+" "+entry.Request.URL+" is not supported in a transaction")
+	}
+	if len(segments) == 2 {
+		te.id = segments[1]
+	}
+
+	if te.method != http.MethodDelete {
+		if err := json.Unmarshal(entry.Resource, &te.fields); err != nil || te.fields == nil {
+			return nil, newFHIRError(http.StatusBadRequest, "invalid", entry.Request.Method+" "+entry.Request.URL+" has no resource")
+		}
+		if rt := stringField(te.fields, "resourceType"); rt != te.resourceType {
+			return nil, newFHIRError(http.StatusBadRequest, "invalid", fmt.Sprintf("resourceType %q does not match %s", rt, te.resourceType))
+		}
+	}
+
+	var matches []*fhirstore.Resource
+	var ferr *fhirError
+	switch {
+	case te.method == http.MethodPost:
+		if te.id != "" {
+			return nil, newFHIRError(http.StatusBadRequest, "invalid", "POST "+entry.Request.URL+" must not include an id")
+		}
+		if entry.Request.IfNoneExist != "" {
+			if matches, ferr = h.conditionalMatches(r.Context(), te.resourceType, entry.Request.IfNoneExist); ferr != nil {
+				return nil, ferr
+			}
+			if len(matches) == 1 {
+				te.existing, te.id = matches[0], matches[0].ID
+				return te, nil
+			}
+		}
+		delete(te.fields, "id") // the server assigns ids on create
+		te.id = uuid.New().String()
+	case te.method == http.MethodPut || te.method == http.MethodDelete:
+		if te.id == "" && query == "" {
+			return nil, newFHIRError(http.StatusBadRequest, "invalid", entry.Request.Method+" "+entry.Request.URL+" needs an id or search parameters")
+		}
+		if te.id == "" {
+			if matches, ferr = h.conditionalMatches(r.Context(), te.resourceType, query); ferr != nil {
+				return nil, ferr
+			}
+			if len(matches) == 1 {
+				te.id = matches[0].ID
+			}
+		}
+		if te.method == http.MethodDelete {
+			if te.id != "" && len(matches) == 0 {
+				// Deleting a missing resource is an error; deleting a
+				// deleted one succeeds
+				if _, err := h.store.Read(r.Context(), te.resourceType, te.id); errors.Is(err, fhirstore.ErrNotFound) {
+					return nil, newFHIRError(http.StatusNotFound, "not-found", te.resourceType+"/"+te.id+" not found")
+				}
+			}
+			return te, nil
+		}
+		bodyID := stringField(te.fields, "id")
+		switch {
+		case te.id == "" && bodyID != "":
+			te.id = bodyID
+		case te.id == "":
+			te.id = uuid.New().String()
+		case bodyID != "" && bodyID != te.id:
+			return nil, newFHIRError(http.StatusBadRequest, "invalid", "resource id "+bodyID+" does not match "+te.resourceType+"/"+te.id)
+		}
+	default:
+		return nil, newFHIRError(http.StatusMethodNotAllowed, "not-supported", "method "+entry.Request.Method+" is not supported")
+	}
+	return te, nil
+}
+
+// dispatchEntry performs a batch or transaction read entry as a request
+// through the router, so it is handled exactly like a standalone request
+func (h *Handlers) dispatchEntry(r *http.Request, entry bundleRequestEntry, target string) models.BundleEntry {
+	// The entry is routed afresh, so the bundle's chi route context is dropped
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, nil)
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(entry.Request.Method), fhirPrefix+"/"+target, bytes.NewReader(entry.Resource))
+	if err != nil {
+		return errorEntry(newFHIRError(http.StatusBadRequest, "invalid", err.Error()))
+	}
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	req.TLS = r.TLS
+	for _, name := range entryHeaders {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	req.Header.Set("Content-Type", fhirContentType)
+	for name, v := range map[string]string{
+		"If-Match":      entry.Request.IfMatch,
+		"If-None-Match": entry.Request.IfNoneMatch,
+		"If-None-Exist": entry.Request.IfNoneExist,
+	} {
+		if v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+
+	rec := newEntryResponse()
+	h.router.ServeHTTP(rec, req)
+
+	result := models.BundleEntry{Response: &models.BundleEntryResponse{
+		Status:   strconv.Itoa(rec.status),
+		Location: rec.header.Get("Location"),
+		Etag:     rec.header.Get("ETag"),
+	}}
+	if modified, err := http.ParseTime(rec.header.Get("Last-Modified")); err == nil {
+		result.Response.LastModified = &modified
+	}
+	if body := bytes.TrimSpace(rec.body.Bytes()); len(body) > 0 && json.Valid(body) {
+		if rec.status >= http.StatusBadRequest {
+			result.Response.Outcome = json.RawMessage(body)
+		} else {
+			result.Resource = json.RawMessage(body)
+		}
+	}
+	if location, ok := strings.CutPrefix(result.Response.Location, h.fhirBase(r)+"/"); ok {
+		result.FullURL = h.fhirBase(r) + "/" + strings.Split(location, "/_history/")[0]
+	}
+	return result
+}
+
+// entryResponse collects the response to an entry dispatched through the
+// router
+type entryResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newEntryResponse() *entryResponse {
+	return &entryResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (e *entryResponse) Header() http.Header {
+	return e.header
+}
+
+func (e *entryResponse) WriteHeader(status int) {
+	if !e.wroteHeader {
+		e.status, e.wroteHeader = status, true
+	}
+}
+
+func (e *entryResponse) Write(b []byte) (int, error) {
+	e.WriteHeader(http.StatusOK)
+	return e.body.Write(b)
+}
+
+// entryURL returns an entry's request URL relative to the FHIR base. It
+// must start with a resource type, so a bundle cannot post another bundle.
+func entryURL(base, raw string) (string, error) {
+	target := strings.TrimPrefix(strings.TrimPrefix(raw, base), "/")
+	if u, err := url.Parse(target); err != nil || u.IsAbs() {
+		return "", fmt.Errorf("request url %q is not relative to %s", raw, base)
+	}
+	if target == "" || strings.HasPrefix(target, "?") || strings.HasPrefix(target, "$") {
+		return "", fmt.Errorf("request url %q does not name a resource type", raw)
+	}
+	return target, nil
+}
+
+// rewriteReferences replaces references to the fullUrls of other entries,
+// such as urn:uuid: placeholders, with the Type/id they resolved to.
+// Unresolved urn: references are an error.
+func rewriteReferences(fields map[string]json.RawMessage, fullURLs map[string]string) error {
+	for name, raw := range fields {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		changed, err := rewriteValue(value, fullURLs)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if fields[name], err = json.Marshal(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func rewriteValue(value interface{}, fullURLs map[string]string) (bool, error) {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["reference"].(string); ok {
+			if target, ok := fullURLs[ref]; ok {
+				v["reference"] = target
+				changed = true
+			} else if strings.HasPrefix(ref, "urn:uuid:") || strings.HasPrefix(ref, "urn:oid:") {
+				return false, fmt.Errorf("reference %s does not match the fullUrl of an entry", ref)
+			}
+		}
+		for _, child := range v {
+			c, err := rewriteValue(child, fullURLs)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	case []interface{}:
+		for _, child := range v {
+			c, err := rewriteValue(child, fullURLs)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	}
+	return changed, nil
+}
+
+// resourceEntry is the response entry for a resource written or matched
+// by a transaction
+func resourceEntry(base string, res *fhirstore.Resource, status int) models.BundleEntry {
+	return models.BundleEntry{
+		FullURL:  base + "/" + res.ResourceType + "/" + res.ID,
+		Resource: res.Data,
+		Response: &models.BundleEntryResponse{
+			Status:       strconv.Itoa(status),
+			Location:     base + "/" + res.ResourceType + "/" + res.ID + "/_history/" + res.VersionID,
+			Etag:         etag(res),
+			LastModified: &res.LastUpdated,
+		},
+	}
+}
+
+func errorEntry(err *fhirError) models.BundleEntry {
+	return models.BundleEntry{Response: &models.BundleEntryResponse{Status: strconv.Itoa(err.status), Outcome: err.outcome}}
+}
+
+// respondEntryError fails a transaction because of one of its entries
+func respondEntryError(w http.ResponseWriter, index int, err *fhirError) {
+	for i := range err.outcome.Issue {
+		err.outcome.Issue[i].Diagnostics = fmt.Sprintf("entry %d: %s", index, err.outcome.Issue[i].Diagnostics)
+	}
+	respondFHIRError(w, err)
+}
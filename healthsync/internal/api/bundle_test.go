@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProcessBundle_TooLarge(t *testing.T) {
+	a := newTestAPI(t)
+	note := strings.Repeat("x", 1<<20)
+	body := `{"resourceType":"Bundle","type":"batch","entry":[{"resource":{"resourceType":"Patient","text":{"div":"` + note + `"}},"request":{"method":"POST","url":"Patient"}}]}`
+
+	rec := a.do(request(http.MethodPost, "/", body))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %.200s", rec.Code, rec.Body)
+	}
+	if n, err := a.store.Count(context.Background(), "Patient"); err != nil || n != 0 {
+		t.Errorf("patients stored = %d (%v), want none", n, err)
+	}
+}
+
+func TestProcessBundle_BatchRead(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+
+	rec := a.do(request(http.MethodPost, "/", `{"resourceType":"Bundle","type":"batch","entry":[
+		{"request":{"method":"GET","url":"Patient/p1"}},
+		{"request":{"method":"GET","url":"Patient/missing"}}]}`))
+	bundle := decodeBundle(t, rec)
+	if len(bundle.Entry) != 2 {
+		t.Fatalf("entries = %d, want 2: %s", len(bundle.Entry), rec.Body)
+	}
+	if read := bundle.Entry[0]; read.Response.Status != "200" || resourceID(read.Resource) != "p1" || read.Response.Etag == "" {
+		t.Errorf("read entry = %+v, want p1 with its ETag", read.Response)
+	}
+	if missing := bundle.Entry[1]; missing.Response.Status != "404" || missing.Response.Outcome == nil || missing.Resource != nil {
+		t.Errorf("missing entry = %+v, want 404 with an outcome", missing.Response)
+	}
+}
+
+// resourceID returns the id of a decoded bundle entry resource
+func resourceID(resource interface{}) string {
+	m, _ := resource.(map[string]interface{})
+	id, _ := m["id"].(string)
+	return id
+}
+
+func TestProcessTransaction_IfNoneExistConsent(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}],"identifier":[{"value":"MRN1"}]}`)
+	a.put("Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}],"identifier":[{"value":"MRN2"}]}`)
+	a.deny("p1")
+
+	transaction := func(mrn string) string {
+		return `{"resourceType":"Bundle","type":"transaction","entry":[
+			{"fullUrl":"urn:uuid:patient","resource":{"resourceType":"Patient","identifier":[{"value":"` + mrn + `"}]},
+			 "request":{"method":"POST","url":"Patient","ifNoneExist":"identifier=` + mrn + `"}},
+			{"resource":{"resourceType":"Observation","status":"final","subject":{"reference":"urn:uuid:patient"}},
+			 "request":{"method":"POST","url":"Observation"}}]}`
+	}
+
+	rec := a.do(request(http.MethodPost, "/", transaction("MRN1")))
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "Smith") {
+		t.Fatalf("status = %d, want 403 without the patient: %s", rec.Code, rec.Body)
+	}
+	if n, _ := a.store.Count(context.Background(), "Observation"); n != 0 {
+		t.Errorf("observations stored = %d, want none", n)
+	}
+	if n := a.logged("R", "p1", "8"); n != 1 {
+		t.Errorf("denied reads logged = %d, want 1", n)
+	}
+
+	rec = a.do(request(http.MethodPost, "/", transaction("MRN2")))
+	bundle := decodeBundle(t, rec)
+	if rec.Code != http.StatusOK || len(bundle.Entry) != 2 || resourceID(bundle.Entry[0].Resource) != "p2" || bundle.Entry[0].Response.Status != "200" {
+		t.Fatalf("response = %s, want p2 matched and the observation created", rec.Body)
+	}
+	if n := a.logged("R", "p2", "0"); n != 1 {
+		t.Errorf("matched reads logged = %d, want 1", n)
+	}
+	if n := a.logged("C", "p2", "0"); n != 1 {
+		t.Errorf("creates logged for p2 = %d, want 1", n)
+	}
+}
+
+func TestProcessTransaction_Rollback(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+
+	// The update is written after the create and fails its version check
+	rec := a.do(request(http.MethodPost, "/", `{"resourceType":"Bundle","type":"transaction","entry":[
+		{"resource":{"resourceType":"Patient","name":[{"family":"Jones"}]},"request":{"method":"POST","url":"Patient"}},
+		{"resource":{"resourceType":"Patient","id":"p1","name":[{"family":"Smythe"}]},"request":{"method":"PUT","url":"Patient/p1","ifMatch":"W/\"5\""}}]}`))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status = %d, want 412: %s", rec.Code, rec.Body)
+	}
+
+	if n, _ := a.store.Count(context.Background(), "Patient"); n != 1 {
+		t.Errorf("patients stored = %d, want only p1", n)
+	}
+	res, err := a.store.Read(context.Background(), "Patient", "p1")
+	if err != nil || res.VersionID != "1" || strings.Contains(string(res.Data), "Smythe") {
+		t.Errorf("p1 = %v (%v), want version 1 unchanged", res, err)
+	}
+	for _, event := range a.events() {
+		if event.Action == "C" || event.Action == "U" {
+			t.Errorf("write logged for a rolled back transaction: %+v", event.Entity)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/savegress/healthsync/internal/export"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+const ndjsonContentType = "application/fhir+ndjson"
+
+// exportParams are the kick-off parameters the server supports; others are
+// rejected unless the client asks for lenient handling
+var exportParams = []string{"_outputFormat", "_since", "_type", "deidentify"}
+
+// exportManifest is the Bulk Data manifest returned for a completed export
+type exportManifest struct {
+	TransactionTime     time.Time            `json:"transactionTime"`
+	Request             string               `json:"request"`
+	RequiresAccessToken bool                 `json:"requiresAccessToken"`
+	Output              []exportManifestFile `json:"output"`
+	Error               []exportManifestFile `json:"error"`
+}
+
+type exportManifestFile struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Count int    `json:"count,omitempty"`
+}
+
+// Bulk Data export handlers
+
+// ExportSystem starts an export of every supported resource type
+func (h *Handlers) ExportSystem(w http.ResponseWriter, r *http.Request) {
+	h.kickoffExport(w, r, export.LevelSystem)
+}
+
+// ExportPatients starts an export of the resources in patient compartments
+func (h *Handlers) ExportPatients(w http.ResponseWriter, r *http.Request) {
+	if chi.URLParam(r, "type") != string(models.ResourceTypePatient) {
+		respondOutcome(w, http.StatusNotFound, "not-supported", "$export is supported on the system and Patient")
+		return
+	}
+	h.kickoffExport(w, r, export.LevelPatient)
+}
+
+// kickoffExport starts an export in the background and returns where to
+// poll for its status
+func (h *Handlers) kickoffExport(w http.ResponseWriter, r *http.Request, level export.Level) {
+	if !strings.Contains(r.Header.Get("Prefer"), "respond-async") {
+		respondOutcome(w, http.StatusBadRequest, "invalid", "$export requires Prefer: respond-async")
+		return
+	}
+	if r.Header.Get("X-User-ID") == "" {
+		respondOutcome(w, http.StatusUnauthorized, "login", "$export requires X-User-ID; only the requester may retrieve the export")
+		return
+	}
+	query := r.URL.Query()
+	if !strings.Contains(r.Header.Get("Prefer"), "handling=lenient") {
+		for name := range query {
+			if !containsString(exportParams, name) {
+				respondOutcome(w, http.StatusBadRequest, "not-supported", "$export parameter "+name+" is not supported")
+				return
+			}
+		}
+	}
+	switch query.Get("_outputFormat") {
+	case "", ndjsonContentType, "application/ndjson", "ndjson":
+	default:
+		respondOutcome(w, http.StatusBadRequest, "not-supported", "output format "+query.Get("_outputFormat")+" is not supported")
+		return
+	}
+
+	types, since, ferr := h.compartmentFilter(query)
+	if ferr != nil {
+		respondFHIRError(w, ferr)
+		return
+	}
+	deidentify := false
+	if v := query.Get("deidentify"); v != "" {
+		var err error
+		if deidentify, err = strconv.ParseBool(v); err != nil {
+			respondOutcome(w, http.StatusBadRequest, "invalid", "deidentify must be true or false")
+			return
+		}
+	}
+
+	requestURL := h.fhirBase(r) + strings.TrimPrefix(r.URL.Path, fhirPrefix)
+	if r.URL.RawQuery != "" {
+		requestURL += "?" + r.URL.RawQuery
+	}
+	job, err := h.exports.Kickoff(export.Request{
+		Level:       level,
+		Types:       types,
+		Since:       since,
+		Deidentify:  deidentify,
+		RequestURL:  requestURL,
+		RequestedBy: r.Header.Get("X-User-ID"),
+		Purpose:     r.Header.Get("X-Purpose"),
+	})
+	if err != nil {
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+
+	resourceType := ""
+	if level == export.LevelPatient {
+		resourceType = string(models.ResourceTypePatient)
+	}
+	h.logFHIRAccess(r, "E", resourceType, "", "", "0")
+	w.Header().Set("Content-Location", h.fhirBase(r)+"/$export-status/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ExportStatus reports the progress of an export, or its manifest once it
+// has completed
+func (h *Handlers) ExportStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.exportJob(w, r)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case export.StatusInProgress:
+		w.Header().Set("X-Progress", job.Progress)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusAccepted)
+	case export.StatusFailed:
+		respondOutcome(w, http.StatusInternalServerError, "exception", job.Error)
+	default:
+		base := h.fhirBase(r)
+		manifest := &exportManifest{
+			TransactionTime: job.TransactionTime,
+			Request:         job.Request.RequestURL,
+			Output:          []exportManifestFile{},
+			Error:           []exportManifestFile{},
+		}
+		for _, f := range job.Output {
+			manifest.Output = append(manifest.Output, exportManifestFile{
+				Type:  f.Type,
+				URL:   base + "/$export-file/" + job.ID + "/" + f.Name,
+				Count: f.Count,
+			})
+		}
+		w.Header().Set("Expires", job.ExpiresAt.UTC().Format(http.TimeFormat))
+		respond(w, http.StatusOK, manifest)
+	}
+}
+
+// CancelExport cancels an export, or removes the files of a finished one
+func (h *Handlers) CancelExport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.exportJob(w, r); !ok {
+		return
+	}
+	if err := h.exports.Delete(chi.URLParam(r, "id")); err != nil {
+		if errors.Is(err, export.ErrJobNotFound) {
+			respondOutcome(w, http.StatusNotFound, "not-found", err.Error())
+			return
+		}
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ExportFile downloads one NDJSON file of a completed export to the user
+// who requested it, logging the download for each patient in the file
+func (h *Handlers) ExportFile(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.exportJob(w, r)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "file")
+	f, err := h.exports.OpenFile(chi.URLParam(r, "id"), name)
+	if err != nil {
+		if errors.Is(err, export.ErrJobNotFound) || errors.Is(err, export.ErrFileNotFound) {
+			respondOutcome(w, http.StatusNotFound, "not-found", err.Error())
+			return
+		}
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+	defer f.Close()
+
+	var patients []string
+	for _, file := range job.Output {
+		if file.Name == name {
+			patients = file.Patients
+		}
+	}
+	h.logPatientAccess(r, "R", strings.TrimSuffix(name, ".ndjson"), "", patients)
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
+}
+
+// exportJob returns the {id} export, writing a 404 when there is none and
+// a 403, logged, when the caller is not the user who requested it
+func (h *Handlers) exportJob(w http.ResponseWriter, r *http.Request) (*export.Job, bool) {
+	job, err := h.exports.GetJob(chi.URLParam(r, "id"))
+	if err != nil {
+		respondOutcome(w, http.StatusNotFound, "not-found", err.Error())
+		return nil, false
+	}
+	if user := r.Header.Get("X-User-ID"); user == "" || user != job.Request.RequestedBy {
+		h.logFHIRAccess(r, "R", "", "", "", "8") // Serious failure
+		respondOutcome(w, http.StatusForbidden, "forbidden", "export "+job.ID+" belongs to another user")
+		return nil, false
+	}
+	return job, true
+}
+
+// PatientEverything returns a patient and every resource in their
+// compartment ($everything)
+func (h *Handlers) PatientEverything(w http.ResponseWriter, r *http.Request) {
+	if chi.URLParam(r, "type") != string(models.ResourceTypePatient) {
+		respondOutcome(w, http.StatusNotFound, "not-supported", "$everything is supported on Patient")
+		return
+	}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+
+	patient, err := h.store.Read(ctx, string(models.ResourceTypePatient), id)
+	if !h.checkStoreError(w, string(models.ResourceTypePatient), id, err) || !h.checkConsent(w, r, patient) {
+		return
+	}
+	types, since, ferr := h.compartmentFilter(r.URL.Query())
+	if ferr != nil {
+		respondFHIRError(w, ferr)
+		return
+	}
+
+	base := h.fhirBase(r)
+	bundle := &models.Bundle{
+		ResourceType: models.ResourceTypeBundle,
+		Type:         "searchset",
+		Link:         []models.BundleLink{{Relation: "self", URL: base + "/Patient/" + id + "/$everything"}},
+	}
+	for _, resourceType := range types {
+		var matches []*fhirstore.Resource
+		if resourceType == string(models.ResourceTypePatient) {
+			if since == nil || patient.LastUpdated.After(*since) {
+				matches = []*fhirstore.Resource{patient}
+			}
+		} else if _, ok := fhirstore.LookupSearchParam(resourceType, "patient"); ok {
+			values := url.Values{"patient": {"Patient/" + id}}
+			if since != nil {
+				values.Set("_lastUpdated", "gt"+since.UTC().Format(time.RFC3339Nano))
+			}
+			q, err := fhirstore.ParseQuery(resourceType, values)
+			if err != nil {
+				respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+				return
+			}
+			result, err := h.store.Search(ctx, q)
+			if err != nil {
+				respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+				return
+			}
+			matches = result.Matches
+		}
+		// The patient's consent may permit some resource types and not
+		// others, so each compartment resource is checked on its own
+		for _, res := range matches {
+			if res != patient && !h.consentFilter(r, "E", res) {
+				continue
+			}
+			bundle.Entry = append(bundle.Entry, searchEntry(base, res, "match"))
+		}
+	}
+	total := len(bundle.Entry)
+	bundle.Total = &total
+
+	h.logFHIRAccess(r, "E", string(models.ResourceTypePatient), id, id, "0")
+	respondFHIR(w, http.StatusOK, bundle)
+}
+
+// compartmentFilter parses the _type and _since parameters shared by
+// $export and $everything. Without _type every supported type is included.
+func (h *Handlers) compartmentFilter(query url.Values) ([]string, *time.Time, *fhirError) {
+	types := h.fhir.SupportedResources
+	if v := query.Get("_type"); v != "" {
+		types = nil
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if !containsString(h.fhir.SupportedResources, t) {
+				return nil, nil, newFHIRError(http.StatusBadRequest, "not-supported", "resource type "+t+" is not supported")
+			}
+			if !containsString(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+
+	var since *time.Time
+	if v := query.Get("_since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, nil, newFHIRError(http.StatusBadRequest, "invalid", "_since must be an instant such as 2026-01-01T00:00:00Z")
+		}
+		since = &t
+	}
+	return types, since, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatientEverything_Consent(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+	a.put("Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}]}`)
+	a.put("Observation", "o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"}}`)
+	a.put("Encounter", "e1", `{"resourceType":"Encounter","status":"finished","subject":{"reference":"Patient/p1"}}`)
+	a.deny("p1", "Patient", "Encounter")
+	a.deny("p2")
+
+	rec := a.do(request(http.MethodGet, "/Patient/p1/$everything", ""))
+	bundle := decodeBundle(t, rec)
+	var ids []string
+	for _, entry := range bundle.Entry {
+		ids = append(ids, resourceID(entry.Resource))
+	}
+	if strings.Join(ids, ",") != "p1,e1" || *bundle.Total != 2 {
+		t.Fatalf("$everything returned %v, want p1 and e1 without the denied observation", ids)
+	}
+	if n := a.logged("E", "p1", "4"); n != 1 {
+		t.Errorf("withheld resources logged = %d, want 1", n)
+	}
+	if n := a.logged("E", "p1", "0"); n != 1 {
+		t.Errorf("$everything logged = %d, want 1", n)
+	}
+
+	if rec := a.do(request(http.MethodGet, "/Patient/p2/$everything", "")); rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 for a denied patient: %s", rec.Code, rec.Body)
+	}
+	if n := a.logged("R", "p2", "8"); n != 1 {
+		t.Errorf("denied reads logged = %d, want 1", n)
+	}
+}
+
+func TestExport_Ownership(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+	a.put("Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}]}`)
+	a.deny("p1")
+
+	req := request(http.MethodGet, "/$export?_type=Patient", "")
+	req.Header.Set("Prefer", "respond-async")
+	rec := a.do(req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("kick-off status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	_, statusPath, _ := strings.Cut(rec.Header().Get("Content-Location"), fhirPrefix)
+
+	manifest := a.pollExport(statusPath)
+	if len(manifest.Output) != 1 || manifest.Output[0].Count != 1 {
+		t.Fatalf("manifest output = %+v, want one patient", manifest.Output)
+	}
+	filePath := strings.TrimPrefix(manifest.Output[0].URL, "http://example.com"+fhirPrefix)
+
+	// Another user can neither poll, download nor delete the export
+	for _, r := range []*http.Request{
+		request(http.MethodGet, statusPath, ""),
+		request(http.MethodGet, filePath, ""),
+		request(http.MethodDelete, statusPath, ""),
+	} {
+		r.Header.Set("X-User-ID", "intruder")
+		if rec := a.do(r); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s by another user = %d, want 403", r.Method, r.URL.Path, rec.Code)
+		}
+	}
+	if n := a.logged("R", "", "8"); n != 3 {
+		t.Errorf("refused export requests logged = %d, want 3", n)
+	}
+
+	// The requester's download holds only the consented patient and is
+	// logged for that patient
+	rec = a.do(request(http.MethodGet, filePath, ""))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Smith") || !strings.Contains(rec.Body.String(), "Jones") {
+		t.Fatalf("download = %d %s, want only p2", rec.Code, rec.Body)
+	}
+	if n := a.logged("R", "p2", "0"); n != 1 {
+		t.Errorf("downloads logged for p2 = %d, want 1", n)
+	}
+	if n := a.logged("R", "p1", "0"); n != 0 {
+		t.Errorf("downloads logged for p1 = %d, want 0", n)
+	}
+}
+
+// pollExport waits for an export requested by "clinician" to complete and
+// returns its manifest
+func (a *testAPI) pollExport(statusPath string) *exportManifest {
+	a.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := a.do(request(http.MethodGet, statusPath, ""))
+		if rec.Code == http.StatusAccepted {
+			continue
+		}
+		var manifest exportManifest
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &manifest) != nil {
+			a.t.Fatalf("export status = %d: %s", rec.Code, rec.Body)
+		}
+		return &manifest
+	}
+	a.t.Fatal("export did not complete")
+	return nil
+}
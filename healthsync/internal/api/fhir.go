@@ -0,0 +1,832 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/savegress/healthsync/internal/audit"
+	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/mpi"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+const (
+	fhirContentType = "application/fhir+json"
+	fhirPrefix      = "/api/v1/healthsync/fhir" // where the router mounts the FHIR API
+)
+
+// fhirError is a failed interaction, reported as an OperationOutcome with
+// an HTTP status
+type fhirError struct {
+	status  int
+	outcome *models.OperationOutcome
+}
+
+func newFHIRError(status int, code, diagnostics string) *fhirError {
+	return &fhirError{
+		status: status,
+		outcome: &models.OperationOutcome{
+			ResourceType: models.ResourceTypeOperationOutcome,
+			Issue:        []models.OperationOutcomeIssue{{Severity: "error", Code: code, Diagnostics: diagnostics}},
+		},
+	}
+}
+
+func (e *fhirError) Error() string {
+	var messages []string
+	for _, issue := range e.outcome.Issue {
+		messages = append(messages, issue.Diagnostics)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// FHIR RESTful API handlers
+
+// Capabilities returns the server's CapabilityStatement
+func (h *Handlers) Capabilities(w http.ResponseWriter, r *http.Request) {
+	rest := models.CapabilityRest{
+		Mode:        "server",
+		Interaction: []models.CapabilityInteraction{{Code: "transaction"}, {Code: "batch"}},
+		Operation:   []models.CapabilityOperation{{Name: "export", Definition: "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/export"}},
+	}
+	for _, resourceType := range h.fhir.SupportedResources {
+		resource := models.CapabilityResource{
+			Type:              resourceType,
+			Versioning:        "versioned",
+			ReadHistory:       true,
+			UpdateCreate:      true,
+			ConditionalCreate: true,
+			ConditionalUpdate: true,
+		}
+		for _, code := range []string{"read", "vread", "update", "delete", "history-instance", "create", "search-type"} {
+			resource.Interaction = append(resource.Interaction, models.CapabilityInteraction{Code: code})
+		}
+		for _, param := range fhirstore.SearchParams(resourceType) {
+			resource.SearchParam = append(resource.SearchParam, models.CapabilitySearchParam{Name: param.Name, Type: string(param.Type)})
+			if param.Type == fhirstore.ParamReference {
+				resource.SearchInclude = append(resource.SearchInclude, resourceType+":"+param.Name)
+			}
+		}
+		for _, source := range h.fhir.SupportedResources {
+			for _, param := range fhirstore.SearchParams(source) {
+				if param.Type == fhirstore.ParamReference && containsString(param.Targets, resourceType) {
+					resource.SearchRevInclude = append(resource.SearchRevInclude, source+":"+param.Name)
+				}
+			}
+		}
+		if resourceType == string(models.ResourceTypePatient) {
+			resource.Operation = []models.CapabilityOperation{
+				{Name: "export", Definition: "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/patient-export"},
+				{Name: "everything", Definition: "http://hl7.org/fhir/OperationDefinition/Patient-everything"},
+			}
+		}
+		rest.Resource = append(rest.Resource, resource)
+	}
+
+	respondFHIR(w, http.StatusOK, &models.CapabilityStatement{
+		ResourceType: models.ResourceTypeCapabilityStatement,
+		Status:       "active",
+		Date:         time.Now().UTC(),
+		Kind:         "instance",
+		Software:     &models.CapabilitySoftware{Name: "HealthSync"},
+		Implementation: &models.CapabilityImplementation{
+			Description: "HealthSync FHIR R4 server",
+			URL:         h.fhirBase(r),
+		},
+		FHIRVersion: "4.0.1",
+		Format:      []string{fhirContentType, "json"},
+		Rest:        []models.CapabilityRest{rest},
+	})
+}
+
+// SearchResources searches a resource type with GET or POST _search
+func (h *Handlers) SearchResources(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondOutcome(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	q, err := fhirstore.ParseQuery(resourceType, r.Form)
+	if err != nil {
+		respondOutcome(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if len(q.Unknown) > 0 && strings.Contains(r.Header.Get("Prefer"), "handling=strict") {
+		respondOutcome(w, http.StatusBadRequest, "not-supported", "unknown search parameters: "+strings.Join(q.Unknown, ", "))
+		return
+	}
+	if q.Count < 0 {
+		q.Count = h.fhir.DefaultPageSize
+	}
+	if h.fhir.MaxPageSize > 0 && q.Count > h.fhir.MaxPageSize {
+		q.Count = h.fhir.MaxPageSize
+	}
+
+	page, err := h.permittedPage(r, q, r.Form.Get("_total") == "accurate")
+	if err != nil {
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+	included, err := fhirstore.Includes(r.Context(), h.store, q, page.matches)
+	if err != nil {
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+
+	base := h.fhirBase(r)
+	var entries []models.BundleEntry
+	patients := newPatientSet()
+	for _, res := range page.matches {
+		entries = append(entries, searchEntry(base, res, "match"))
+		patients.add(res.PatientID())
+	}
+	for _, res := range included {
+		if !h.consentFilter(r, "E", res) {
+			continue
+		}
+		entries = append(entries, searchEntry(base, res, "include"))
+		patients.add(res.PatientID())
+	}
+
+	bundle := &models.Bundle{
+		ResourceType: models.ResourceTypeBundle,
+		Type:         "searchset",
+		Total:        page.total,
+		Link:         pageLinks(base+"/"+resourceType, r.Form, q, page.total, page.more),
+		Entry:        entries,
+	}
+
+	h.logPatientAccess(r, "E", resourceType, "", patients.ids)
+	respondFHIR(w, http.StatusOK, bundle)
+}
+
+// searchScanBatch is how many matches permittedPage reads from the store at
+// a time
+const searchScanBatch = 100
+
+// searchPage is a page of the search matches a requestor may read
+type searchPage struct {
+	matches []*fhirstore.Resource
+	total   *int // nil unless every match was checked
+	more    bool // readable matches follow the page
+}
+
+// permittedPage reads q's matches in batches, leaving out those the
+// requestor may not read before paging, so offsets, totals and links only
+// count what they can see. The scan stops once the page is filled and a
+// readable match follows it, leaving the total unknown, unless accurate
+// is set or the page is empty by request (_count=0).
+func (h *Handlers) permittedPage(r *http.Request, q *fhirstore.Query, accurate bool) (*searchPage, error) {
+	accurate = accurate || q.Count == 0
+	scan := *q
+	scan.Include, scan.RevInclude = nil, nil
+	scan.Offset = 0
+	if q.Count >= 0 {
+		scan.Count = searchScanBatch
+	}
+
+	page := &searchPage{}
+	permitted := 0
+	for {
+		result, err := h.store.Search(r.Context(), &scan)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range result.Matches {
+			if permitted >= q.Offset && (q.Count < 0 || len(page.matches) < q.Count) {
+				if !h.consentFilter(r, "E", res) {
+					continue
+				}
+				page.matches = append(page.matches, res)
+			} else {
+				if access := h.checkAccess(r, res); access != nil && !access.Allowed {
+					continue
+				}
+				if permitted >= q.Offset {
+					page.more = true
+					if !accurate {
+						return page, nil
+					}
+				}
+			}
+			permitted++
+		}
+
+		scan.Offset += len(result.Matches)
+		if scan.Count < 0 || len(result.Matches) == 0 || scan.Offset >= result.Total {
+			page.total = &permitted
+			return page, nil
+		}
+	}
+}
+
+// CreateResource creates a resource, or returns the existing one when an
+// If-None-Exist search matches it
+func (h *Handlers) CreateResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	fields, ok := decodeFHIRBody(w, r, resourceType)
+	if !ok {
+		return
+	}
+	delete(fields, "id") // the server assigns ids on create
+
+	if condition := r.Header.Get("If-None-Exist"); condition != "" {
+		matches, ferr := h.conditionalMatches(r.Context(), resourceType, condition)
+		if ferr != nil {
+			respondFHIRError(w, ferr)
+			return
+		}
+		if len(matches) == 1 {
+			// Returning the match reads it
+			existing := matches[0]
+			if !h.checkConsent(w, r, existing) {
+				return
+			}
+			h.logFHIRAccess(r, "R", resourceType, existing.ID, existing.PatientID(), "0")
+			setVersionHeaders(w, existing)
+			respondFHIR(w, http.StatusOK, existing.Data)
+			return
+		}
+	}
+
+	h.saveResource(w, r, resourceType, "", fields)
+}
+
+// UpdateResource stores a new version of a resource, creating it when it
+// does not exist
+func (h *Handlers) UpdateResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	fields, ok := decodeFHIRBody(w, r, resourceType)
+	if !ok {
+		return
+	}
+	if bodyID := stringField(fields, "id"); bodyID != "" && bodyID != id {
+		respondOutcome(w, http.StatusBadRequest, "invalid", "resource id "+bodyID+" does not match URL id "+id)
+		return
+	}
+
+	h.saveResource(w, r, resourceType, id, fields)
+}
+
+// ConditionalUpdateResource updates the single resource matching the
+// request's search parameters, creating one when none match
+func (h *Handlers) ConditionalUpdateResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.RawQuery == "" {
+		respondOutcome(w, http.StatusBadRequest, "invalid", "conditional update requires search parameters")
+		return
+	}
+	fields, ok := decodeFHIRBody(w, r, resourceType)
+	if !ok {
+		return
+	}
+	matches, ferr := h.conditionalMatches(r.Context(), resourceType, r.URL.RawQuery)
+	if ferr != nil {
+		respondFHIRError(w, ferr)
+		return
+	}
+
+	id := stringField(fields, "id")
+	if len(matches) == 1 {
+		if id != "" && id != matches[0].ID {
+			respondOutcome(w, http.StatusBadRequest, "invalid", "resource id "+id+" does not match "+resourceType+"/"+matches[0].ID)
+			return
+		}
+		id = matches[0].ID
+	}
+	h.saveResource(w, r, resourceType, id, fields)
+}
+
+// ReadResource returns the current version of a resource
+func (h *Handlers) ReadResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+
+	res, err := h.store.Read(r.Context(), resourceType, id)
+	if !h.checkStoreError(w, resourceType, id, err) || !h.checkConsent(w, r, res) {
+		return
+	}
+
+	h.logFHIRAccess(r, "R", resourceType, id, res.PatientID(), "0")
+	setVersionHeaders(w, res)
+	respondFHIR(w, http.StatusOK, res.Data)
+}
+
+// VReadResource returns a specific version of a resource
+func (h *Handlers) VReadResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	id, vid := chi.URLParam(r, "id"), chi.URLParam(r, "vid")
+
+	res, err := h.store.VRead(r.Context(), resourceType, id, vid)
+	if !h.checkStoreError(w, resourceType, id+"/_history/"+vid, err) || !h.checkConsent(w, r, res) {
+		return
+	}
+
+	h.logFHIRAccess(r, "R", resourceType, id, res.PatientID(), "0")
+	setVersionHeaders(w, res)
+	respondFHIR(w, http.StatusOK, res.Data)
+}
+
+// ResourceHistory returns every version of a resource as a history bundle
+func (h *Handlers) ResourceHistory(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+
+	history, err := h.store.History(r.Context(), resourceType, id)
+	if !h.checkStoreError(w, resourceType, id, err) {
+		return
+	}
+	// Access is refused when the most recent version with content may not
+	// be read; older versions, which may have been in another patient's
+	// compartment, are checked one by one and left out when denied
+	for _, res := range history {
+		if !res.Deleted {
+			if !h.checkConsent(w, r, res) {
+				return
+			}
+			break
+		}
+	}
+
+	base := h.fhirBase(r)
+	bundle := &models.Bundle{
+		ResourceType: models.ResourceTypeBundle,
+		Type:         "history",
+		Link:         []models.BundleLink{{Relation: "self", URL: base + "/" + resourceType + "/" + id + "/_history"}},
+	}
+	patients := newPatientSet()
+	for i, res := range history {
+		if !res.Deleted {
+			if !h.consentFilter(r, "R", res) {
+				continue
+			}
+			patients.add(res.PatientID())
+		}
+		entry := models.BundleEntry{
+			FullURL:  base + "/" + resourceType + "/" + id,
+			Request:  &models.BundleEntryRequest{Method: http.MethodPut, URL: resourceType + "/" + id},
+			Response: &models.BundleEntryResponse{Status: "200", Etag: etag(res), LastModified: &res.LastUpdated},
+		}
+		switch {
+		case res.Deleted:
+			entry.Request.Method = http.MethodDelete
+			entry.Response.Status = "204"
+		case i == len(history)-1:
+			entry.Request.Method = http.MethodPost
+			entry.Request.URL = resourceType
+			entry.Response.Status = "201"
+		}
+		if !res.Deleted {
+			entry.Resource = res.Data
+		}
+		bundle.Entry = append(bundle.Entry, entry)
+	}
+	total := len(bundle.Entry)
+	bundle.Total = &total
+
+	h.logPatientAccess(r, "R", resourceType, id, patients.ids)
+	respondFHIR(w, http.StatusOK, bundle)
+}
+
+// DeleteResource deletes a resource; its history is kept
+func (h *Handlers) DeleteResource(w http.ResponseWriter, r *http.Request) {
+	resourceType, ok := h.fhirType(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+
+	res, err := h.store.Read(r.Context(), resourceType, id)
+	if errors.Is(err, fhirstore.ErrDeleted) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !h.checkStoreError(w, resourceType, id, err) {
+		return
+	}
+	if err := h.store.Delete(r.Context(), resourceType, id); err != nil {
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+
+	h.logFHIRAccess(r, "D", resourceType, id, res.PatientID(), "0")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveResource validates and stores a resource, creating it when id is
+// empty, and writes the response. Patients are registered with the MPI.
+func (h *Handlers) saveResource(w http.ResponseWriter, r *http.Request, resourceType, id string, fields map[string]json.RawMessage) {
+	ctx := r.Context()
+
+	write, ferr := h.prepareWrite(ctx, resourceType, id, r.Header.Get("If-Match"), fields)
+	if ferr != nil {
+		respondFHIRError(w, ferr)
+		return
+	}
+
+	var res *fhirstore.Resource
+	var err error
+	created := true
+	if id == "" {
+		res, err = h.store.Create(ctx, resourceType, write.Data)
+	} else {
+		res, created, err = h.store.Update(ctx, resourceType, id, write.Data)
+	}
+	if err != nil {
+		respondOutcome(w, http.StatusBadRequest, "processing", err.Error())
+		return
+	}
+
+	res, decision, err := h.indexPatient(ctx, res, created)
+	if err != nil {
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+		return
+	}
+	if decision != nil {
+		w.Header().Set("X-MPI-Outcome", string(decision.Outcome))
+	}
+
+	action, status := "U", http.StatusOK
+	if created {
+		action, status = "C", http.StatusCreated
+		w.Header().Set("Location", h.fhirBase(r)+"/"+resourceType+"/"+res.ID+"/_history/"+res.VersionID)
+	}
+	h.logFHIRAccess(r, action, resourceType, res.ID, res.PatientID(), "0")
+	setVersionHeaders(w, res)
+	respondFHIR(w, status, res.Data)
+}
+
+// prepareWrite checks a resource about to be stored as resourceType/id
+// against ifMatch and the compliance rules, carrying MPI links over from
+// the current version. An empty id creates the resource. The write expects
+// the version ifMatch names.
+func (h *Handlers) prepareWrite(ctx context.Context, resourceType, id, ifMatch string, fields map[string]json.RawMessage) (fhirstore.Write, *fhirError) {
+	write := fhirstore.Write{ResourceType: resourceType, ID: id}
+
+	var existing *fhirstore.Resource
+	if id != "" {
+		res, err := h.store.Read(ctx, resourceType, id)
+		if err != nil && !errors.Is(err, fhirstore.ErrNotFound) && !errors.Is(err, fhirstore.ErrDeleted) {
+			return write, newFHIRError(http.StatusInternalServerError, "exception", err.Error())
+		}
+		existing = res
+	}
+
+	if ifMatch != "" {
+		if existing == nil || ifMatch != etag(existing) {
+			return write, newFHIRError(http.StatusPreconditionFailed, "conflict", "version "+ifMatch+" is not the current version of "+resourceType+"/"+id)
+		}
+		write.IfMatch = existing.VersionID
+	}
+	if resourceType == string(models.ResourceTypePatient) && existing != nil {
+		if _, ok := fields["link"]; !ok {
+			// Links are maintained by the MPI and survive demographic updates
+			var current map[string]json.RawMessage
+			if err := json.Unmarshal(existing.Data, &current); err == nil && current["link"] != nil {
+				fields["link"] = current["link"]
+			}
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return write, newFHIRError(http.StatusBadRequest, "invalid", err.Error())
+	}
+	if result := h.compliance.ValidateResource(json.RawMessage(data), models.ResourceType(resourceType)); !result.Valid {
+		ferr := &fhirError{status: http.StatusUnprocessableEntity, outcome: &models.OperationOutcome{ResourceType: models.ResourceTypeOperationOutcome}}
+		for _, v := range result.Violations {
+			ferr.outcome.Issue = append(ferr.outcome.Issue, models.OperationOutcomeIssue{
+				Severity:    "error",
+				Code:        "business-rule",
+				Diagnostics: "Compliance validation failed: " + v.Description,
+				Expression:  []string{resourceType + "." + v.Field},
+			})
+		}
+		return write, ferr
+	}
+
+	write.Data = data
+	return write, nil
+}
+
+// indexPatient registers a newly stored patient with the MPI or refreshes
+// an updated one, returning the stored version, which linking may have
+// changed. Other resources are returned unchanged.
+func (h *Handlers) indexPatient(ctx context.Context, res *fhirstore.Resource, created bool) (*fhirstore.Resource, *mpi.Decision, error) {
+	if res.ResourceType != string(models.ResourceTypePatient) || res.Deleted {
+		return res, nil, nil
+	}
+	var patient models.Patient
+	if err := res.Unmarshal(&patient); err != nil {
+		return nil, nil, err
+	}
+	if !created {
+		h.mpi.Update(&patient)
+		return res, nil, nil
+	}
+
+	decision, err := h.mpi.Register(ctx, &patient)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := h.store.Read(ctx, res.ResourceType, res.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return current, decision, nil
+}
+
+// conditionalMatches runs the search in a conditional interaction, failing
+// with a 412 when it matches more than one resource
+func (h *Handlers) conditionalMatches(ctx context.Context, resourceType, condition string) ([]*fhirstore.Resource, *fhirError) {
+	values, err := url.ParseQuery(strings.TrimPrefix(condition, resourceType+"?"))
+	if err != nil {
+		return nil, newFHIRError(http.StatusBadRequest, "invalid", err.Error())
+	}
+	q, err := fhirstore.ParseQuery(resourceType, values)
+	if err != nil {
+		return nil, newFHIRError(http.StatusBadRequest, "invalid", err.Error())
+	}
+	if len(q.Unknown) > 0 {
+		// Ignoring a condition could update the wrong resource
+		return nil, newFHIRError(http.StatusBadRequest, "not-supported", "unknown search parameters: "+strings.Join(q.Unknown, ", "))
+	}
+	q.Count, q.Offset = 2, 0
+
+	result, err := h.store.Search(ctx, q)
+	if err != nil {
+		return nil, newFHIRError(http.StatusInternalServerError, "exception", err.Error())
+	}
+	if result.Total > 1 {
+		return nil, newFHIRError(http.StatusPreconditionFailed, "multiple-matches", fmt.Sprintf("%d %s resources match %s", result.Total, resourceType, condition))
+	}
+	return result.Matches, nil
+}
+
+// checkConsent verifies the requestor may read a resource in a patient's
+// compartment, logging and writing a 403 when they may not
+func (h *Handlers) checkConsent(w http.ResponseWriter, r *http.Request, res *fhirstore.Resource) bool {
+	result := h.checkAccess(r, res)
+	if result == nil || result.Allowed {
+		return true
+	}
+
+	h.logFHIRAccess(r, "R", res.ResourceType, res.ID, res.PatientID(), "8") // Serious failure
+	respondOutcome(w, http.StatusForbidden, "forbidden", "Access denied: "+result.Reason)
+	return false
+}
+
+// consentFilter reports whether a resource in a patient's compartment may
+// be returned among others, logging the resource withheld when it may not
+func (h *Handlers) consentFilter(r *http.Request, action string, res *fhirstore.Resource) bool {
+	result := h.checkAccess(r, res)
+	if result == nil || result.Allowed {
+		return true
+	}
+	h.logFHIRAccess(r, action, res.ResourceType, res.ID, res.PatientID(), "4") // Minor failure
+	return false
+}
+
+// checkAccess asks the consent manager whether the requestor may read a
+// resource, or returns nil for resources outside any patient compartment
+func (h *Handlers) checkAccess(r *http.Request, res *fhirstore.Resource) *consent.AccessCheckResult {
+	patientID := res.PatientID()
+	if patientID == "" {
+		return nil
+	}
+	return h.consent.CheckAccess(&consent.AccessCheckRequest{
+		PatientID:    patientID,
+		ResourceType: res.ResourceType,
+		ResourceID:   res.ID,
+		RequestorID:  r.Header.Get("X-User-ID"),
+		Purpose:      r.Header.Get("X-Purpose"),
+		Action:       "read",
+	})
+}
+
+// patientSet collects the distinct patients whose resources a response
+// returns, in the order first seen
+type patientSet struct {
+	ids  []string
+	seen map[string]bool
+}
+
+func newPatientSet() *patientSet {
+	return &patientSet{seen: make(map[string]bool)}
+}
+
+func (p *patientSet) add(id string) {
+	if id != "" && !p.seen[id] {
+		p.seen[id] = true
+		p.ids = append(p.ids, id)
+	}
+}
+
+// logPatientAccess logs an interaction once for each patient whose
+// resources it returned, or once without a patient when there were none
+func (h *Handlers) logPatientAccess(r *http.Request, action, resourceType, id string, patients []string) {
+	if len(patients) == 0 {
+		h.logFHIRAccess(r, action, resourceType, id, "", "0")
+		return
+	}
+	for _, patientID := range patients {
+		h.logFHIRAccess(r, action, resourceType, id, patientID, "0")
+	}
+}
+
+// checkStoreError writes a 404 for missing resources, a 410 for deleted
+// ones and a 500 for anything else, returning whether err was nil
+func (h *Handlers) checkStoreError(w http.ResponseWriter, resourceType, id string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, fhirstore.ErrNotFound):
+		respondOutcome(w, http.StatusNotFound, "not-found", resourceType+"/"+id+" not found")
+	case errors.Is(err, fhirstore.ErrDeleted):
+		respondOutcome(w, http.StatusGone, "deleted", resourceType+"/"+id+" has been deleted")
+	default:
+		respondOutcome(w, http.StatusInternalServerError, "exception", err.Error())
+	}
+	return false
+}
+
+// fhirType returns the {type} URL parameter, writing a 404 when the type is
+// not supported
+func (h *Handlers) fhirType(w http.ResponseWriter, r *http.Request) (string, bool) {
+	resourceType := chi.URLParam(r, "type")
+	if !containsString(h.fhir.SupportedResources, resourceType) {
+		respondOutcome(w, http.StatusNotFound, "not-supported", "resource type "+resourceType+" is not supported")
+		return "", false
+	}
+	return resourceType, true
+}
+
+// fhirBase returns the absolute base URL of the FHIR API
+func (h *Handlers) fhirBase(r *http.Request) string {
+	if h.fhir.BaseURL != "" {
+		return strings.TrimSuffix(h.fhir.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + fhirPrefix
+}
+
+func (h *Handlers) logFHIRAccess(r *http.Request, action, resourceType, id, patientID, outcome string) {
+	query := r.URL.RawQuery
+	if r.Form != nil {
+		query = r.Form.Encode() // includes POST _search parameters
+	}
+	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
+		UserID:       r.Header.Get("X-User-ID"),
+		UserName:     r.Header.Get("X-User-Name"),
+		IPAddress:    r.RemoteAddr,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   id,
+		PatientID:    patientID,
+		Purpose:      r.Header.Get("X-Purpose"),
+		Outcome:      outcome,
+		Query:        query,
+	})
+}
+
+// decodeFHIRBody reads a resource from the request body, checking its
+// resourceType against the URL
+func decodeFHIRBody(w http.ResponseWriter, r *http.Request, resourceType string) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || fields == nil {
+		respondOutcome(w, http.StatusBadRequest, "invalid", "Invalid request body")
+		return nil, false
+	}
+	if rt := stringField(fields, "resourceType"); rt != resourceType {
+		respondOutcome(w, http.StatusBadRequest, "invalid", fmt.Sprintf("resourceType %q does not match %s", rt, resourceType))
+		return nil, false
+	}
+	return fields, true
+}
+
+func stringField(fields map[string]json.RawMessage, name string) string {
+	var s string
+	json.Unmarshal(fields[name], &s)
+	return s
+}
+
+// pageLinks builds the self and paging links of a search bundle. more
+// reports whether matches follow the page; the last page is only linked
+// when the total is known.
+func pageLinks(typeURL string, values url.Values, q *fhirstore.Query, total *int, more bool) []models.BundleLink {
+	link := func(relation string, offset int) models.BundleLink {
+		params := url.Values{}
+		for key, v := range values {
+			if !containsString(q.Unknown, key) {
+				params[key] = v
+			}
+		}
+		params.Set("_count", strconv.Itoa(q.Count))
+		params.Set("_offset", strconv.Itoa(offset))
+		return models.BundleLink{Relation: relation, URL: typeURL + "?" + params.Encode()}
+	}
+
+	links := []models.BundleLink{link("self", q.Offset)}
+	if q.Count == 0 {
+		return links
+	}
+	links = append(links, link("first", 0))
+	if q.Offset > 0 {
+		prev := q.Offset - q.Count
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("previous", prev))
+	}
+	if more {
+		links = append(links, link("next", q.Offset+q.Count))
+	}
+	if total == nil {
+		return links
+	}
+	last := 0
+	if *total > 0 {
+		last = (*total - 1) / q.Count * q.Count
+	}
+	return append(links, link("last", last))
+}
+
+func searchEntry(base string, res *fhirstore.Resource, mode string) models.BundleEntry {
+	return models.BundleEntry{
+		FullURL:  base + "/" + res.ResourceType + "/" + res.ID,
+		Resource: res.Data,
+		Search:   &models.BundleEntrySearch{Mode: mode},
+	}
+}
+
+func etag(res *fhirstore.Resource) string {
+	return `W/"` + res.VersionID + `"`
+}
+
+func setVersionHeaders(w http.ResponseWriter, res *fhirstore.Resource) {
+	w.Header().Set("ETag", etag(res))
+	w.Header().Set("Last-Modified", res.LastUpdated.UTC().Format(http.TimeFormat))
+}
+
+func respondFHIR(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", fhirContentType)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep & in paging links readable
+	enc.Encode(data)
+}
+
+func respondOutcome(w http.ResponseWriter, status int, code, diagnostics string) {
+	respondFHIRError(w, newFHIRError(status, code, diagnostics))
+}
+
+func respondFHIRError(w http.ResponseWriter, err *fhirError) {
+	respondFHIR(w, err.status, err.outcome)
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/healthsync/internal/anonymization"
+	"github.com/savegress/healthsync/internal/audit"
+	"github.com/savegress/healthsync/internal/compliance"
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/deidentification"
+	"github.com/savegress/healthsync/internal/export"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/mpi"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+// testAPI serves the FHIR API over an in-memory store. Consent is required
+// but permitted by default, so tests deny access patient by patient.
+type testAPI struct {
+	t       *testing.T
+	server  *Server
+	store   *fhirstore.MemoryStore
+	audit   *audit.Logger
+	consent *consent.Manager
+	exports *export.Manager
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := &config.Config{
+		FHIR: config.FHIRConfig{
+			SupportedResources: []string{"Patient", "Observation", "Encounter", "Condition"},
+			DefaultPageSize:    20,
+			MaxPageSize:        100,
+			MaxBundleSize:      1 << 20,
+		},
+		MPI:     config.MPIConfig{AutoLinkThreshold: 0.95, ReviewThreshold: 0.70},
+		Export:  config.ExportConfig{OutputDir: t.TempDir(), Retention: time.Hour},
+		Audit:   config.AuditConfig{Enabled: true},
+		Consent: config.ConsentConfig{Required: true, DefaultPolicy: "permit"},
+	}
+
+	a := &testAPI{
+		t:       t,
+		store:   fhirstore.NewMemoryStore(),
+		audit:   audit.NewLogger(&cfg.Audit),
+		consent: consent.NewManager(&cfg.Consent),
+	}
+	if err := a.audit.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	a.exports = export.NewManager(&cfg.Export, a.store, deidentification.NewEngine(nil), a.consent)
+	if err := a.exports.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.exports.Stop)
+
+	a.server = NewServer(cfg, compliance.NewEngine(&cfg.Compliance), a.audit, anonymization.NewEngine(&anonymization.Config{}),
+		a.consent, a.store, mpi.NewService(&cfg.MPI, a.store), a.exports)
+	return a
+}
+
+// put stores a resource directly, bypassing the API
+func (a *testAPI) put(resourceType, id, data string) {
+	a.t.Helper()
+	if _, _, err := a.store.Update(context.Background(), resourceType, id, json.RawMessage(data)); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// deny records a consent under which the patient's resources may not be
+// read, except those of the given types
+func (a *testAPI) deny(patientID string, permitted ...string) {
+	a.t.Helper()
+	provision := &models.ConsentProvision{Type: "deny"}
+	if len(permitted) > 0 {
+		provision = &models.ConsentProvision{Type: "permit"}
+		for _, resourceType := range permitted {
+			provision.Class = append(provision.Class, models.Coding{Code: resourceType})
+		}
+	}
+	if err := a.consent.CreateConsent(&models.Consent{
+		Status:    "active",
+		Patient:   &models.Reference{Reference: "Patient/" + patientID},
+		Provision: provision,
+	}); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// request builds a FHIR API request made by user "clinician"
+func request(method, path, body string) *http.Request {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, fhirPrefix+path, nil)
+	} else {
+		req = httptest.NewRequest(method, fhirPrefix+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", fhirContentType)
+	}
+	req.Header.Set("X-User-ID", "clinician")
+	return req
+}
+
+func (a *testAPI) do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+// events returns the access events logged so far. A marker event is logged
+// and waited for first, so every event logged before it has been recorded.
+func (a *testAPI) events() []*models.AuditEvent {
+	a.t.Helper()
+	marker := a.audit.LogAccess(context.Background(), &audit.AccessLogRequest{UserID: "test-marker", Action: "R"})
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, ok := a.audit.GetEvent(marker.ID); ok {
+			var events []*models.AuditEvent
+			for _, event := range a.audit.GetEvents(audit.EventFilter{}) {
+				if event.ID != marker.ID && event.Agent[0].Who.Reference != "test-marker" {
+					events = append(events, event)
+				}
+			}
+			return events
+		}
+	}
+	a.t.Fatal("audit events were not recorded")
+	return nil
+}
+
+// logged counts the access events with an action and outcome for a patient
+func (a *testAPI) logged(action, patientID, outcome string) int {
+	a.t.Helper()
+	n := 0
+	for _, event := range a.events() {
+		if event.Action == action && event.Outcome == outcome && event.Entity[0].Name == patientID {
+			n++
+		}
+	}
+	return n
+}
+
+func decodeBundle(t *testing.T, rec *httptest.ResponseRecorder) *models.Bundle {
+	t.Helper()
+	var bundle models.Bundle
+	if err := json.Unmarshal(rec.Body.Bytes(), &bundle); err != nil {
+		t.Fatalf("response is not a bundle: %v\n%s", err, rec.Body)
+	}
+	return &bundle
+}
+
+func TestCreateResource_IfNoneExistConsent(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}],"identifier":[{"value":"MRN1"}]}`)
+	a.put("Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}],"identifier":[{"value":"MRN2"}]}`)
+	a.deny("p1")
+
+	req := request(http.MethodPost, "/Patient", `{"resourceType":"Patient","identifier":[{"value":"MRN1"}]}`)
+	req.Header.Set("If-None-Exist", "identifier=MRN1")
+	rec := a.do(req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "Smith") {
+		t.Error("denied patient returned")
+	}
+	if n := a.logged("R", "p1", "8"); n != 1 {
+		t.Errorf("denied reads logged = %d, want 1", n)
+	}
+
+	req = request(http.MethodPost, "/Patient", `{"resourceType":"Patient","identifier":[{"value":"MRN2"}]}`)
+	req.Header.Set("If-None-Exist", "identifier=MRN2")
+	rec = a.do(req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Jones") {
+		t.Fatalf("status = %d, want 200 with the existing patient: %s", rec.Code, rec.Body)
+	}
+	if n := a.logged("R", "p2", "0"); n != 1 {
+		t.Errorf("reads logged = %d, want 1", n)
+	}
+}
+
+func TestSearchResources_ConsentBeforePaging(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+	a.put("Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}]}`)
+	for i, patient := range []string{"p1", "p2", "p1", "p1", "p2", "p1", "p2"} {
+		a.put("Observation", "o"+strconv.Itoa(i), `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/`+patient+`"}}`)
+	}
+	a.deny("p1")
+
+	rec := a.do(request(http.MethodGet, "/Observation?_count=2", ""))
+	bundle := decodeBundle(t, rec)
+	if len(bundle.Entry) != 2 || strings.Contains(rec.Body.String(), "Patient/p1") {
+		t.Fatalf("first page = %s, want two of p2's observations", rec.Body)
+	}
+	if bundle.Total != nil {
+		t.Errorf("total = %d, want none before every match is checked", *bundle.Total)
+	}
+	if link(bundle, "next") == "" || link(bundle, "last") != "" {
+		t.Errorf("links = %+v, want next and no last", bundle.Link)
+	}
+
+	rec = a.do(request(http.MethodGet, "/Observation?_count=2&_offset=2", ""))
+	bundle = decodeBundle(t, rec)
+	if len(bundle.Entry) != 1 || bundle.Total == nil || *bundle.Total != 3 {
+		t.Fatalf("last page = %s, want one entry and a total of 3", rec.Body)
+	}
+	if link(bundle, "next") != "" || !strings.Contains(link(bundle, "last"), "_offset=2") {
+		t.Errorf("links = %+v, want no next and last at offset 2", bundle.Link)
+	}
+
+	rec = a.do(request(http.MethodGet, "/Observation?_count=2&_total=accurate", ""))
+	if bundle = decodeBundle(t, rec); bundle.Total == nil || *bundle.Total != 3 {
+		t.Errorf("accurate total = %v, want 3", bundle.Total)
+	}
+
+	if n := a.logged("E", "p2", "0"); n != 3 {
+		t.Errorf("searches logged for p2 = %d, want 3", n)
+	}
+	if n := a.logged("E", "p1", "0"); n != 0 {
+		t.Errorf("searches logged for p1 = %d, want 0", n)
+	}
+	if n := a.logged("E", "p1", "4"); n == 0 {
+		t.Error("withheld observations not logged")
+	}
+}
+
+func link(bundle *models.Bundle, relation string) string {
+	for _, l := range bundle.Link {
+		if l.Relation == relation {
+			return l.URL
+		}
+	}
+	return ""
+}
+
+func TestReadResource_Consent(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}]}`)
+	a.put("Observation", "o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"}}`)
+	a.deny("p1", "Patient")
+
+	if rec := a.do(request(http.MethodGet, "/Patient/p1", "")); rec.Code != http.StatusOK {
+		t.Errorf("permitted read status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	for _, path := range []string{"/Observation/o1", "/Observation/o1/_history/1"} {
+		rec := a.do(request(http.MethodGet, path, ""))
+		if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "final") {
+			t.Errorf("GET %s = %d, want 403 without the resource: %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	if n := a.logged("R", "p1", "0"); n != 1 {
+		t.Errorf("reads logged = %d, want 1", n)
+	}
+	if n := a.logged("R", "p1", "8"); n != 2 {
+		t.Errorf("denied reads logged = %d, want 2", n)
+	}
+}
+
+func TestResourceHistory_Consent(t *testing.T) {
+	a := newTestAPI(t)
+	a.put("Observation", "o1", `{"resourceType":"Observation","status":"preliminary","subject":{"reference":"Patient/p1"}}`)
+	a.put("Observation", "o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p2"}}`)
+	a.put("Observation", "o2", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"}}`)
+	a.deny("p1")
+
+	// The version in the denied patient's compartment is left out
+	rec := a.do(request(http.MethodGet, "/Observation/o1/_history", ""))
+	bundle := decodeBundle(t, rec)
+	if len(bundle.Entry) != 1 || bundle.Total == nil || *bundle.Total != 1 || strings.Contains(rec.Body.String(), "preliminary") {
+		t.Fatalf("history = %s, want only the version for p2", rec.Body)
+	}
+	if n := a.logged("R", "p2", "0"); n != 1 {
+		t.Errorf("history reads logged for p2 = %d, want 1", n)
+	}
+	if n := a.logged("R", "p1", "4"); n != 1 {
+		t.Errorf("withheld versions logged = %d, want 1", n)
+	}
+
+	// A resource whose current version is denied is refused outright
+	rec = a.do(request(http.MethodGet, "/Observation/o2/_history", ""))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if n := a.logged("R", "p1", "8"); n != 1 {
+		t.Errorf("denied history reads logged = %d, want 1", n)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -9,7 +10,11 @@ import (
 	"github.com/savegress/healthsync/internal/anonymization"
 	"github.com/savegress/healthsync/internal/audit"
 	"github.com/savegress/healthsync/internal/compliance"
+	"github.com/savegress/healthsync/internal/config"
 	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/export"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/mpi"
 	"github.com/savegress/healthsync/pkg/models"
 )
 
@@ -19,22 +24,24 @@ type Handlers struct {
 	audit         *audit.Logger
 	anonymization *anonymization.Engine
 	consent       *consent.Manager
-	// In-memory storage for demo (would use database in production)
-	patients     map[string]*models.Patient
-	observations map[string]*models.Observation
-	encounters   map[string]*models.Encounter
+	store         fhirstore.Store // shared with the HL7 inbound channel
+	mpi           *mpi.Service
+	exports       *export.Manager
+	fhir          *config.FHIRConfig
+	router        http.Handler // performs batch and transaction entries
 }
 
 // NewHandlers creates new handlers
-func NewHandlers(comp *compliance.Engine, auditLog *audit.Logger, anon *anonymization.Engine, consentMgr *consent.Manager) *Handlers {
+func NewHandlers(comp *compliance.Engine, auditLog *audit.Logger, anon *anonymization.Engine, consentMgr *consent.Manager, store fhirstore.Store, mpiService *mpi.Service, exportManager *export.Manager, fhirConfig *config.FHIRConfig) *Handlers {
 	return &Handlers{
 		compliance:    comp,
 		audit:         auditLog,
 		anonymization: anon,
 		consent:       consentMgr,
-		patients:      make(map[string]*models.Patient),
-		observations:  make(map[string]*models.Observation),
-		encounters:    make(map[string]*models.Encounter),
+		store:         store,
+		mpi:           mpiService,
+		exports:       exportManager,
+		fhir:          fhirConfig,
 	}
 }
 
@@ -47,263 +54,6 @@ func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// FHIR Patient handlers
-
-// SearchPatients searches patients
-func (h *Handlers) SearchPatients(w http.ResponseWriter, r *http.Request) {
-	var results []*models.Patient
-	for _, p := range h.patients {
-		results = append(results, p)
-	}
-	respond(w, http.StatusOK, results)
-}
-
-// CreatePatient creates a patient
-func (h *Handlers) CreatePatient(w http.ResponseWriter, r *http.Request) {
-	var patient models.Patient
-	if err := json.NewDecoder(r.Body).Decode(&patient); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if patient.ID == "" {
-		patient.ID = generateID("patient")
-	}
-	patient.ResourceType = models.ResourceTypePatient
-
-	// Validate compliance
-	result := h.compliance.ValidateResource(&patient, models.ResourceTypePatient)
-	if !result.Valid {
-		respond(w, http.StatusBadRequest, map[string]interface{}{
-			"error":      "Compliance validation failed",
-			"violations": result.Violations,
-		})
-		return
-	}
-
-	h.patients[patient.ID] = &patient
-
-	// Log access
-	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
-		UserID:       r.Header.Get("X-User-ID"),
-		UserName:     r.Header.Get("X-User-Name"),
-		IPAddress:    r.RemoteAddr,
-		Action:       "C",
-		ResourceType: "Patient",
-		ResourceID:   patient.ID,
-		PatientID:    patient.ID,
-		Purpose:      r.Header.Get("X-Purpose"),
-		Outcome:      "0",
-	})
-
-	respond(w, http.StatusCreated, patient)
-}
-
-// GetPatient gets a patient by ID
-func (h *Handlers) GetPatient(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	patient, ok := h.patients[id]
-	if !ok {
-		respondError(w, http.StatusNotFound, "Patient not found")
-		return
-	}
-
-	// Check consent
-	accessResult := h.consent.CheckAccess(&consent.AccessCheckRequest{
-		PatientID:    id,
-		ResourceType: "Patient",
-		ResourceID:   id,
-		RequestorID:  r.Header.Get("X-User-ID"),
-		Purpose:      r.Header.Get("X-Purpose"),
-		Action:       "read",
-	})
-
-	if !accessResult.Allowed {
-		h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
-			UserID:       r.Header.Get("X-User-ID"),
-			IPAddress:    r.RemoteAddr,
-			Action:       "R",
-			ResourceType: "Patient",
-			ResourceID:   id,
-			PatientID:    id,
-			Purpose:      r.Header.Get("X-Purpose"),
-			Outcome:      "8", // Serious failure
-		})
-
-		respondError(w, http.StatusForbidden, "Access denied: "+accessResult.Reason)
-		return
-	}
-
-	// Log successful access
-	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
-		UserID:       r.Header.Get("X-User-ID"),
-		UserName:     r.Header.Get("X-User-Name"),
-		IPAddress:    r.RemoteAddr,
-		Action:       "R",
-		ResourceType: "Patient",
-		ResourceID:   id,
-		PatientID:    id,
-		Purpose:      r.Header.Get("X-Purpose"),
-		Outcome:      "0",
-	})
-
-	respond(w, http.StatusOK, patient)
-}
-
-// UpdatePatient updates a patient
-func (h *Handlers) UpdatePatient(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	if _, ok := h.patients[id]; !ok {
-		respondError(w, http.StatusNotFound, "Patient not found")
-		return
-	}
-
-	var patient models.Patient
-	if err := json.NewDecoder(r.Body).Decode(&patient); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	patient.ID = id
-	patient.ResourceType = models.ResourceTypePatient
-
-	// Validate compliance
-	result := h.compliance.ValidateResource(&patient, models.ResourceTypePatient)
-	if !result.Valid {
-		respond(w, http.StatusBadRequest, map[string]interface{}{
-			"error":      "Compliance validation failed",
-			"violations": result.Violations,
-		})
-		return
-	}
-
-	h.patients[id] = &patient
-
-	// Log access
-	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
-		UserID:       r.Header.Get("X-User-ID"),
-		IPAddress:    r.RemoteAddr,
-		Action:       "U",
-		ResourceType: "Patient",
-		ResourceID:   id,
-		PatientID:    id,
-		Purpose:      r.Header.Get("X-Purpose"),
-		Outcome:      "0",
-	})
-
-	respond(w, http.StatusOK, patient)
-}
-
-// DeletePatient deletes a patient
-func (h *Handlers) DeletePatient(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	if _, ok := h.patients[id]; !ok {
-		respondError(w, http.StatusNotFound, "Patient not found")
-		return
-	}
-
-	delete(h.patients, id)
-
-	// Log access
-	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
-		UserID:       r.Header.Get("X-User-ID"),
-		IPAddress:    r.RemoteAddr,
-		Action:       "D",
-		ResourceType: "Patient",
-		ResourceID:   id,
-		PatientID:    id,
-		Purpose:      r.Header.Get("X-Purpose"),
-		Outcome:      "0",
-	})
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// Observation handlers
-
-// SearchObservations searches observations
-func (h *Handlers) SearchObservations(w http.ResponseWriter, r *http.Request) {
-	var results []*models.Observation
-	for _, o := range h.observations {
-		results = append(results, o)
-	}
-	respond(w, http.StatusOK, results)
-}
-
-// CreateObservation creates an observation
-func (h *Handlers) CreateObservation(w http.ResponseWriter, r *http.Request) {
-	var obs models.Observation
-	if err := json.NewDecoder(r.Body).Decode(&obs); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if obs.ID == "" {
-		obs.ID = generateID("obs")
-	}
-	obs.ResourceType = models.ResourceTypeObservation
-
-	h.observations[obs.ID] = &obs
-	respond(w, http.StatusCreated, obs)
-}
-
-// GetObservation gets an observation by ID
-func (h *Handlers) GetObservation(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	obs, ok := h.observations[id]
-	if !ok {
-		respondError(w, http.StatusNotFound, "Observation not found")
-		return
-	}
-
-	respond(w, http.StatusOK, obs)
-}
-
-// Encounter handlers
-
-// SearchEncounters searches encounters
-func (h *Handlers) SearchEncounters(w http.ResponseWriter, r *http.Request) {
-	var results []*models.Encounter
-	for _, e := range h.encounters {
-		results = append(results, e)
-	}
-	respond(w, http.StatusOK, results)
-}
-
-// CreateEncounter creates an encounter
-func (h *Handlers) CreateEncounter(w http.ResponseWriter, r *http.Request) {
-	var enc models.Encounter
-	if err := json.NewDecoder(r.Body).Decode(&enc); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if enc.ID == "" {
-		enc.ID = generateID("enc")
-	}
-	enc.ResourceType = models.ResourceTypeEncounter
-
-	h.encounters[enc.ID] = &enc
-	respond(w, http.StatusCreated, enc)
-}
-
-// GetEncounter gets an encounter by ID
-func (h *Handlers) GetEncounter(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	enc, ok := h.encounters[id]
-	if !ok {
-		respondError(w, http.StatusNotFound, "Encounter not found")
-		return
-	}
-
-	respond(w, http.StatusOK, enc)
-}
-
 // Compliance handlers
 
 // ListViolations lists compliance violations
@@ -667,20 +417,202 @@ func (h *Handlers) GetOverallStats(w http.ResponseWriter, r *http.Request) {
 	auditStats := h.audit.GetStats()
 	consentStats := h.consent.GetStats()
 
+	resources := make(map[string]int)
+	for key, resourceType := range map[string]models.ResourceType{
+		"patients":     models.ResourceTypePatient,
+		"observations": models.ResourceTypeObservation,
+		"encounters":   models.ResourceTypeEncounter,
+	} {
+		count, err := h.store.Count(r.Context(), string(resourceType))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resources[key] = count
+	}
+
 	respond(w, http.StatusOK, map[string]interface{}{
 		"compliance": complianceStats,
 		"audit":      auditStats,
 		"consent":    consentStats,
-		"resources": map[string]int{
-			"patients":     len(h.patients),
-			"observations": len(h.observations),
-			"encounters":   len(h.encounters),
-		},
+		"mpi":        h.mpi.GetStats(),
+		"resources":  resources,
+	})
+}
+
+// MPI handlers
+
+// ListMatchReviews lists possible duplicates awaiting a data steward
+func (h *Handlers) ListMatchReviews(w http.ResponseWriter, r *http.Request) {
+	reviews := h.mpi.ListReviews(mpi.ReviewStatus(r.URL.Query().Get("status")))
+	respond(w, http.StatusOK, reviews)
+}
+
+// GetMatchReview gets a match review by ID
+func (h *Handlers) GetMatchReview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	review, ok := h.mpi.GetReview(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Review not found")
+		return
+	}
+
+	respond(w, http.StatusOK, review)
+}
+
+// ResolveMatchReview links, merges or separates a reviewed patient
+func (h *Handlers) ResolveMatchReview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req mpi.Resolution
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.ResolvedBy == "" {
+		req.ResolvedBy = r.Header.Get("X-User-ID")
+	}
+
+	review, err := h.mpi.ResolveReview(r.Context(), id, &req)
+	if err != nil {
+		respondMPIError(w, err)
+		return
+	}
+
+	h.logMPIChange(r, review.PatientID, "review "+review.ID+" "+string(review.Status))
+	respond(w, http.StatusOK, review)
+}
+
+// MatchPatient scores a patient against the index without storing it
+func (h *Handlers) MatchPatient(w http.ResponseWriter, r *http.Request) {
+	var patient models.Patient
+	if err := json.NewDecoder(r.Body).Decode(&patient); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.mpi.FindMatches(r.Context(), &patient)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, result)
+}
+
+// MergePatients retires a duplicate record in favour of a survivor
+func (h *Handlers) MergePatients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SurvivorID  string `json:"survivor_id"`
+		DuplicateID string `json:"duplicate_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.mpi.Merge(r.Context(), req.SurvivorID, req.DuplicateID); err != nil {
+		respondMPIError(w, err)
+		return
+	}
+
+	h.logMPIChange(r, req.DuplicateID, "merge into "+req.SurvivorID)
+	respond(w, http.StatusOK, map[string]string{"status": "merged"})
+}
+
+// LinkPatients records that two records belong to the same person
+func (h *Handlers) LinkPatients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PatientID string `json:"patient_id"`
+		OtherID   string `json:"other_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.mpi.Link(r.Context(), req.PatientID, req.OtherID); err != nil {
+		respondMPIError(w, err)
+		return
+	}
+
+	h.logMPIChange(r, req.PatientID, "link to "+req.OtherID)
+	respond(w, http.StatusOK, map[string]string{"status": "linked"})
+}
+
+// UnlinkPatients removes a link between two records
+func (h *Handlers) UnlinkPatients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PatientID string `json:"patient_id"`
+		OtherID   string `json:"other_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.mpi.Unlink(r.Context(), req.PatientID, req.OtherID); err != nil {
+		respondMPIError(w, err)
+		return
+	}
+
+	h.logMPIChange(r, req.PatientID, "unlink from "+req.OtherID)
+	respond(w, http.StatusOK, map[string]string{"status": "unlinked"})
+}
+
+// GetPatientLinks gets a patient's links and the record it survives as
+func (h *Handlers) GetPatientLinks(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var patient models.Patient
+	if !h.readResource(w, r, models.ResourceTypePatient, id, &patient) {
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"patient_id":           id,
+		"surviving_patient_id": h.mpi.SurvivingPatientID(id),
+		"link":                 patient.Link,
+	})
+}
+
+// logMPIChange audits a data steward's change to patient identity
+func (h *Handlers) logMPIChange(r *http.Request, patientID, change string) {
+	h.audit.LogAccess(r.Context(), &audit.AccessLogRequest{
+		UserID:       r.Header.Get("X-User-ID"),
+		UserName:     r.Header.Get("X-User-Name"),
+		IPAddress:    r.RemoteAddr,
+		Action:       "U",
+		ResourceType: "Patient",
+		ResourceID:   patientID,
+		PatientID:    patientID,
+		Purpose:      r.Header.Get("X-Purpose"),
+		Outcome:      "0",
+		Query:        "mpi " + change,
 	})
 }
 
 // Helper functions
 
+// readResource loads a stored resource into v, writing a 404 or 500 and
+// returning false when it cannot
+func (h *Handlers) readResource(w http.ResponseWriter, r *http.Request, resourceType models.ResourceType, id string, v interface{}) bool {
+	res, err := h.store.Read(r.Context(), string(resourceType), id)
+	if errors.Is(err, fhirstore.ErrNotFound) || errors.Is(err, fhirstore.ErrDeleted) {
+		respondError(w, http.StatusNotFound, string(resourceType)+" not found")
+		return false
+	}
+	if err == nil {
+		err = res.Unmarshal(v)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return true
+}
+
 func respond(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -691,6 +623,14 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respond(w, status, map[string]string{"error": message})
 }
 
+func respondMPIError(w http.ResponseWriter, err error) {
+	if errors.Is(err, mpi.ErrPatientNotFound) || errors.Is(err, mpi.ErrReviewNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondError(w, http.StatusBadRequest, err.Error())
+}
+
 func generateID(prefix string) string {
 	return prefix + "-" + time.Now().Format("20060102150405")
 }
@@ -11,6 +11,9 @@ import (
 	"github.com/savegress/healthsync/internal/compliance"
 	"github.com/savegress/healthsync/internal/config"
 	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/export"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/mpi"
 )
 
 // Server represents the API server
@@ -21,15 +24,16 @@ type Server struct {
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, comp *compliance.Engine, auditLog *audit.Logger, anon *anonymization.Engine, consent *consent.Manager) *Server {
+func NewServer(cfg *config.Config, comp *compliance.Engine, auditLog *audit.Logger, anon *anonymization.Engine, consent *consent.Manager, store fhirstore.Store, mpiService *mpi.Service, exportManager *export.Manager) *Server {
 	s := &Server{
 		config:   cfg,
 		router:   chi.NewRouter(),
-		handlers: NewHandlers(comp, auditLog, anon, consent),
+		handlers: NewHandlers(comp, auditLog, anon, consent, store, mpiService, exportManager, &cfg.FHIR),
 	}
 
 	s.setupMiddleware()
 	s.setupRoutes()
+	s.handlers.router = s.router
 
 	return s
 }
@@ -55,29 +59,29 @@ func (s *Server) setupRoutes() {
 	s.router.Get("/health", s.handlers.HealthCheck)
 
 	s.router.Route("/api/v1/healthsync", func(r chi.Router) {
-		// FHIR Resources
+		// FHIR R4 RESTful API
 		r.Route("/fhir", func(r chi.Router) {
-			// Patient
-			r.Route("/Patient", func(r chi.Router) {
-				r.Get("/", s.handlers.SearchPatients)
-				r.Post("/", s.handlers.CreatePatient)
-				r.Get("/{id}", s.handlers.GetPatient)
-				r.Put("/{id}", s.handlers.UpdatePatient)
-				r.Delete("/{id}", s.handlers.DeletePatient)
-			})
-
-			// Observation
-			r.Route("/Observation", func(r chi.Router) {
-				r.Get("/", s.handlers.SearchObservations)
-				r.Post("/", s.handlers.CreateObservation)
-				r.Get("/{id}", s.handlers.GetObservation)
-			})
-
-			// Encounter
-			r.Route("/Encounter", func(r chi.Router) {
-				r.Get("/", s.handlers.SearchEncounters)
-				r.Post("/", s.handlers.CreateEncounter)
-				r.Get("/{id}", s.handlers.GetEncounter)
+			r.Get("/metadata", s.handlers.Capabilities)
+			r.Post("/", s.handlers.ProcessBundle)
+
+			// Bulk Data export
+			r.Get("/$export", s.handlers.ExportSystem)
+			r.Get("/$export-status/{id}", s.handlers.ExportStatus)
+			r.Delete("/$export-status/{id}", s.handlers.CancelExport)
+			r.Get("/$export-file/{id}/{file}", s.handlers.ExportFile)
+
+			r.Route("/{type}", func(r chi.Router) {
+				r.Get("/", s.handlers.SearchResources)
+				r.Post("/_search", s.handlers.SearchResources)
+				r.Post("/", s.handlers.CreateResource)
+				r.Put("/", s.handlers.ConditionalUpdateResource)
+				r.Get("/$export", s.handlers.ExportPatients)
+				r.Get("/{id}", s.handlers.ReadResource)
+				r.Put("/{id}", s.handlers.UpdateResource)
+				r.Delete("/{id}", s.handlers.DeleteResource)
+				r.Get("/{id}/_history", s.handlers.ResourceHistory)
+				r.Get("/{id}/_history/{vid}", s.handlers.VReadResource)
+				r.Get("/{id}/$everything", s.handlers.PatientEverything)
 			})
 		})
 
@@ -118,6 +122,18 @@ func (s *Server) setupRoutes() {
 			r.Post("/{id}/deny", s.handlers.DenyAccessRequest)
 		})
 
+		// Master patient index
+		r.Route("/mpi", func(r chi.Router) {
+			r.Get("/reviews", s.handlers.ListMatchReviews)
+			r.Get("/reviews/{id}", s.handlers.GetMatchReview)
+			r.Post("/reviews/{id}/resolve", s.handlers.ResolveMatchReview)
+			r.Post("/match", s.handlers.MatchPatient)
+			r.Post("/merge", s.handlers.MergePatients)
+			r.Post("/link", s.handlers.LinkPatients)
+			r.Post("/unlink", s.handlers.UnlinkPatients)
+			r.Get("/patients/{id}/links", s.handlers.GetPatientLinks)
+		})
+
 		// Anonymization
 		r.Route("/anonymize", func(r chi.Router) {
 			r.Post("/patient", s.handlers.AnonymizePatient)
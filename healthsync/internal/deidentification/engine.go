@@ -392,13 +392,26 @@ func (e *Engine) DeidentifyBundle(bundle json.RawMessage) (json.RawMessage, erro
 	return json.Marshal(data)
 }
 
+// DeidentifyResource de-identifies a single FHIR resource
+func (e *Engine) DeidentifyResource(resource json.RawMessage) (json.RawMessage, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(resource, &data); err != nil {
+		return nil, err
+	}
+
+	e.deidentifyResource(data)
+
+	return json.Marshal(data)
+}
+
 func (e *Engine) deidentifyResource(resource map[string]interface{}) {
 	resourceType, _ := resource["resourceType"].(string)
 
 	switch resourceType {
 	case "Patient":
 		e.deidentifyPatientMap(resource)
-	case "Observation", "Condition", "Procedure", "DiagnosticReport":
+	case "Observation", "Condition", "Procedure", "DiagnosticReport",
+		"Encounter", "MedicationRequest", "ServiceRequest", "Immunization", "AllergyIntolerance":
 		e.deidentifyClinicalResource(resource)
 	case "Practitioner", "Organization":
 		e.deidentifyProviderResource(resource)
@@ -435,16 +448,19 @@ func (e *Engine) deidentifyPatientMap(resource map[string]interface{}) {
 }
 
 func (e *Engine) deidentifyClinicalResource(resource map[string]interface{}) {
-	// Remove subject reference or pseudonymize
-	if subject, ok := resource["subject"].(map[string]interface{}); ok {
-		if ref, ok := subject["reference"].(string); ok {
-			// Pseudonymize patient reference
-			parts := strings.Split(ref, "/")
-			if len(parts) == 2 && parts[0] == "Patient" {
-				subject["reference"] = "Patient/" + e.generateDeidentID(parts[1])
+	// Remove subject reference or pseudonymize; Immunization and
+	// AllergyIntolerance reference the patient as patient
+	for _, field := range []string{"subject", "patient"} {
+		if subject, ok := resource[field].(map[string]interface{}); ok {
+			if ref, ok := subject["reference"].(string); ok {
+				// Pseudonymize patient reference
+				parts := strings.Split(ref, "/")
+				if len(parts) == 2 && parts[0] == "Patient" {
+					subject["reference"] = "Patient/" + e.generateDeidentID(parts[1])
+				}
 			}
+			delete(subject, "display")
 		}
-		delete(subject, "display")
 	}
 
 	// Remove performer references
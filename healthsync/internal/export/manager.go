@@ -0,0 +1,427 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/deidentification"
+	"github.com/savegress/healthsync/internal/fhirstore"
+)
+
+var (
+	// ErrJobNotFound is returned when an export does not exist or has expired
+	ErrJobNotFound = errors.New("export not found")
+	// ErrFileNotFound is returned for a file that is not part of a
+	// completed export
+	ErrFileNotFound = errors.New("export file not found")
+)
+
+// exportPageSize is how many resources are read from the store at a time
+const exportPageSize = 1000
+
+// Status represents the state of an export
+type Status string
+
+const (
+	StatusInProgress Status = "in-progress"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// Level is the scope of an export
+type Level string
+
+const (
+	LevelSystem  Level = "system"  // every resource of the requested types
+	LevelPatient Level = "patient" // resources in a patient compartment
+)
+
+// Request describes what an export contains
+type Request struct {
+	Level       Level      `json:"level"`
+	Types       []string   `json:"types"`
+	Since       *time.Time `json:"since,omitempty"` // only resources updated after this time
+	Deidentify  bool       `json:"deidentify"`
+	RequestURL  string     `json:"request_url"` // kick-off URL, reported in the manifest
+	RequestedBy string     `json:"requested_by,omitempty"`
+	Purpose     string     `json:"purpose,omitempty"` // purpose of use the patients' consent is checked for
+}
+
+// Job is an export and its progress
+type Job struct {
+	ID              string     `json:"id"`
+	Request         Request    `json:"request"`
+	Status          Status     `json:"status"`
+	Progress        string     `json:"progress,omitempty"`
+	TransactionTime time.Time  `json:"transaction_time"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"` // when the files are removed
+	Output          []File     `json:"output"`
+	Error           string     `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// File is the NDJSON output for one resource type. Types without matching
+// resources have no file.
+type File struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Count    int      `json:"count"`
+	Patients []string `json:"patients,omitempty"` // patients whose resources the file holds
+}
+
+// Manager runs FHIR Bulk Data exports in the background, writing one NDJSON
+// file per resource type under the configured output directory
+type Manager struct {
+	config  *config.ExportConfig
+	store   fhirstore.Store
+	deident *deidentification.Engine
+	consent *consent.Manager
+	jobs    map[string]*Job
+	mu      sync.RWMutex
+	running bool
+	stopCh  chan struct{}
+}
+
+// NewManager creates a new export manager. Without a de-identification
+// engine, de-identified exports are rejected. Resources in a patient
+// compartment are only exported when the patient's consent lets the
+// requester read them.
+func NewManager(cfg *config.ExportConfig, store fhirstore.Store, deident *deidentification.Engine, consentManager *consent.Manager) *Manager {
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = filepath.Join(os.TempDir(), "healthsync-export")
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+
+	return &Manager{
+		config:  cfg,
+		store:   store,
+		deident: deident,
+		consent: consentManager,
+		jobs:    make(map[string]*Job),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start creates the output directory, removes exports left by a previous
+// run and starts expiring finished exports
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	if m.running {
+		m.mu.Unlock()
+		return nil
+	}
+	m.running = true
+	m.mu.Unlock()
+
+	if err := os.MkdirAll(m.config.OutputDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	entries, err := os.ReadDir(m.config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("failed to read export directory: %w", err)
+	}
+	for _, e := range entries {
+		// Only export directories are removed; the directory may be shared
+		if _, err := uuid.Parse(e.Name()); err == nil && e.IsDir() {
+			os.RemoveAll(filepath.Join(m.config.OutputDir, e.Name()))
+		}
+	}
+
+	go m.expireJobs(ctx)
+	return nil
+}
+
+// Stop cancels running exports and stops expiring finished ones
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		close(m.stopCh)
+		m.running = false
+		for _, job := range m.jobs {
+			job.cancel()
+		}
+	}
+}
+
+// Kickoff starts an export and returns it while it runs
+func (m *Manager) Kickoff(req Request) (*Job, error) {
+	if len(req.Types) == 0 {
+		return nil, fmt.Errorf("export has no resource types")
+	}
+	if req.Deidentify && m.deident == nil {
+		return nil, fmt.Errorf("de-identified exports are not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:              uuid.New().String(),
+		Request:         req,
+		Status:          StatusInProgress,
+		TransactionTime: time.Now().UTC(),
+		Output:          []File{},
+		cancel:          cancel,
+	}
+	if err := os.MkdirAll(m.jobDir(job.ID), 0o700); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+
+	go m.run(ctx, job)
+	return snapshot, nil
+}
+
+// GetJob returns the current state of an export
+func (m *Manager) GetJob(id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// Delete cancels an export if it is running and removes its files
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	delete(m.jobs, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrJobNotFound
+	}
+	job.cancel()
+	return os.RemoveAll(m.jobDir(id))
+}
+
+// OpenFile opens one of the output files of a completed export
+func (m *Manager) OpenFile(id, name string) (*os.File, error) {
+	job, err := m.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != StatusCompleted {
+		return nil, ErrFileNotFound
+	}
+	for _, f := range job.Output {
+		if f.Name == name {
+			return os.Open(filepath.Join(m.jobDir(id), name))
+		}
+	}
+	return nil, ErrFileNotFound
+}
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	err := m.export(ctx, job)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	expires := now.Add(m.config.Retention)
+	job.CompletedAt = &now
+	job.ExpiresAt = &expires
+	job.Progress = ""
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		job.Output = []File{}
+		if ctx.Err() == nil {
+			log.Printf("Export %s failed: %v", job.ID, err)
+		}
+		os.RemoveAll(m.jobDir(job.ID))
+		return
+	}
+	job.Status = StatusCompleted
+}
+
+func (m *Manager) export(ctx context.Context, job *Job) error {
+	types := job.Request.Types
+	for i, resourceType := range types {
+		m.mu.Lock()
+		job.Progress = fmt.Sprintf("exporting %s (%d of %d types)", resourceType, i+1, len(types))
+		m.mu.Unlock()
+
+		file, err := m.exportType(ctx, job, resourceType)
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", resourceType, err)
+		}
+		if file != nil {
+			m.mu.Lock()
+			job.Output = append(job.Output, *file)
+			m.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// exportType writes the resources of one type to an NDJSON file, returning
+// nil when there are none. The resources are read page by page in last
+// update order, up to the transaction time: a resource updated after the
+// export started is left for the next export with _since.
+func (m *Manager) exportType(ctx context.Context, job *Job, resourceType string) (*File, error) {
+	q, err := fhirstore.ParseQuery(resourceType, nil)
+	if err != nil {
+		return nil, err
+	}
+	q.Count = exportPageSize
+	if job.Request.Since != nil {
+		q.After = &fhirstore.Cursor{LastUpdated: *job.Request.Since}
+	}
+
+	file := &File{Type: resourceType, Name: resourceType + ".ndjson"}
+	path := filepath.Join(m.jobDir(job.ID), file.Name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	var line bytes.Buffer
+	patients := make(map[string]bool)
+	withheld := 0
+scan:
+	for {
+		result, err := m.store.Search(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range result.Matches {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if res.LastUpdated.After(job.TransactionTime) {
+				break scan
+			}
+			patientID := res.PatientID()
+			if job.Request.Level == LevelPatient && patientID == "" {
+				continue
+			}
+			if patientID != "" && !m.permitted(job, res) {
+				withheld++
+				continue
+			}
+			data := res.Data
+			if job.Request.Deidentify {
+				if data, err = m.deident.DeidentifyResource(data); err != nil {
+					return nil, fmt.Errorf("failed to de-identify %s/%s: %w", resourceType, res.ID, err)
+				}
+			}
+
+			line.Reset()
+			if err := json.Compact(&line, data); err != nil {
+				return nil, err
+			}
+			line.WriteByte('\n')
+			if _, err := w.Write(line.Bytes()); err != nil {
+				return nil, err
+			}
+			file.Count++
+			if patientID != "" && !patients[patientID] {
+				patients[patientID] = true
+				file.Patients = append(file.Patients, patientID)
+			}
+		}
+		if len(result.Matches) < q.Count {
+			break
+		}
+		last := result.Matches[len(result.Matches)-1]
+		q.After = &fhirstore.Cursor{LastUpdated: last.LastUpdated, ID: last.ID}
+	}
+	if withheld > 0 {
+		log.Printf("Export %s withheld %d %s resources without the patients' consent", job.ID, withheld, resourceType)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if file.Count == 0 {
+		f.Close()
+		return nil, os.Remove(path)
+	}
+	return file, f.Close()
+}
+
+// permitted reports whether the requester of an export may read a resource
+// in a patient compartment
+func (m *Manager) permitted(job *Job, res *fhirstore.Resource) bool {
+	if m.consent == nil {
+		return true
+	}
+	return m.consent.CheckAccess(&consent.AccessCheckRequest{
+		PatientID:    res.PatientID(),
+		ResourceType: res.ResourceType,
+		ResourceID:   res.ID,
+		RequestorID:  job.Request.RequestedBy,
+		Purpose:      job.Request.Purpose,
+		Action:       "read",
+	}).Allowed
+}
+
+func (m *Manager) expireJobs(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stopCh:
+			return
+		case now := <-ticker.C:
+			m.expire(now)
+		}
+	}
+}
+
+// expire removes exports whose retention period ended before now
+func (m *Manager) expire(now time.Time) {
+	m.mu.Lock()
+	var expired []string
+	for id, job := range m.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			expired = append(expired, id)
+			delete(m.jobs, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		os.RemoveAll(m.jobDir(id))
+	}
+}
+
+func (m *Manager) jobDir(id string) string {
+	return filepath.Join(m.config.OutputDir, id)
+}
+
+// snapshot returns a copy of the job; the caller must hold the lock
+func (j *Job) snapshot() *Job {
+	c := *j
+	c.Output = append([]File{}, j.Output...)
+	return &c
+}
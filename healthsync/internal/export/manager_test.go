@@ -0,0 +1,247 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/consent"
+	"github.com/savegress/healthsync/internal/deidentification"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+func newTestManager(t *testing.T) (*Manager, *fhirstore.MemoryStore) {
+	m, store, _ := newConsentTestManager(t)
+	return m, store
+}
+
+// newConsentTestManager also returns the consent manager exports are
+// checked against; consent is required and permitted by default
+func newConsentTestManager(t *testing.T) (*Manager, *fhirstore.MemoryStore, *consent.Manager) {
+	t.Helper()
+	store := fhirstore.NewMemoryStore()
+	resources := []struct {
+		resourceType, id, data string
+	}{
+		{"Patient", "p1", `{"resourceType":"Patient","name":[{"family":"Smith"}],"identifier":[{"value":"MRN1"}]}`},
+		{"Patient", "p2", `{"resourceType":"Patient","name":[{"family":"Jones"}]}`},
+		{"Observation", "o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"}}`},
+		{"Organization", "org1", `{"resourceType":"Organization","name":"General Hospital"}`},
+	}
+	for _, r := range resources {
+		if _, _, err := store.Update(context.Background(), r.resourceType, r.id, json.RawMessage(r.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consents := consent.NewManager(&config.ConsentConfig{Required: true, DefaultPolicy: "permit"})
+	m := NewManager(&config.ExportConfig{OutputDir: t.TempDir(), Retention: time.Hour}, store, deidentification.NewEngine(nil), consents)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m, store, consents
+}
+
+// waitForJob polls an export until it finishes
+func waitForJob(t *testing.T, m *Manager, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != StatusInProgress {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("export %s did not finish", id)
+	return nil
+}
+
+// readFile returns the resources in an export's NDJSON file
+func readFile(t *testing.T, m *Manager, id, name string) []map[string]interface{} {
+	t.Helper()
+	f, err := m.OpenFile(id, name)
+	if err != nil {
+		t.Fatalf("OpenFile(%s): %v", name, err)
+	}
+	defer f.Close()
+
+	var resources []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var resource map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &resource); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+func TestManager_SystemExport(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	job, err := m.Kickoff(Request{Level: LevelSystem, Types: []string{"Patient", "Observation", "Encounter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID)
+	if job.Status != StatusCompleted || job.ExpiresAt == nil {
+		t.Fatalf("expected a completed export, got %+v", job)
+	}
+
+	want := []File{
+		{Type: "Patient", Name: "Patient.ndjson", Count: 2, Patients: []string{"p1", "p2"}},
+		{Type: "Observation", Name: "Observation.ndjson", Count: 1, Patients: []string{"p1"}},
+	}
+	if !reflect.DeepEqual(job.Output, want) {
+		t.Fatalf("expected files %+v, got %+v", want, job.Output)
+	}
+	if patients := readFile(t, m, job.ID, "Patient.ndjson"); len(patients) != 2 || patients[0]["resourceType"] != "Patient" {
+		t.Errorf("unexpected patients %v", patients)
+	}
+	if _, err := m.OpenFile(job.ID, "Encounter.ndjson"); err != ErrFileNotFound {
+		t.Errorf("types without resources should have no file, got %v", err)
+	}
+	if _, err := m.OpenFile(job.ID, "../Patient.ndjson"); err != ErrFileNotFound {
+		t.Errorf("expected ErrFileNotFound for a path outside the export, got %v", err)
+	}
+}
+
+func TestManager_PatientExportSince(t *testing.T) {
+	m, store := newTestManager(t)
+	ctx := context.Background()
+
+	since := time.Now()
+	time.Sleep(time.Millisecond)
+	if _, _, err := store.Update(ctx, "Patient", "p2", json.RawMessage(`{"resourceType":"Patient","gender":"female"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Update(ctx, "Organization", "org1", json.RawMessage(`{"resourceType":"Organization","name":"Renamed"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := m.Kickoff(Request{Level: LevelPatient, Types: []string{"Patient", "Observation", "Organization"}, Since: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID)
+	if len(job.Output) != 1 || job.Output[0].Type != "Patient" || job.Output[0].Count != 1 {
+		t.Fatalf("expected only p2, got %+v", job.Output)
+	}
+	if patients := readFile(t, m, job.ID, "Patient.ndjson"); patients[0]["id"] != "p2" {
+		t.Errorf("expected p2, got %v", patients[0]["id"])
+	}
+}
+
+func TestManager_ExportConsent(t *testing.T) {
+	m, store, consents := newConsentTestManager(t)
+	for i := 0; i < exportPageSize+1; i++ {
+		data := `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p2"}}`
+		if _, _, err := store.Update(context.Background(), "Observation", fmt.Sprintf("p2-%d", i), json.RawMessage(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consents.CreateConsent(&models.Consent{
+		Status:    "active",
+		Patient:   &models.Reference{Reference: "Patient/p1"},
+		Provision: &models.ConsentProvision{Type: "deny"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := m.Kickoff(Request{Level: LevelSystem, Types: []string{"Patient", "Observation", "Organization"}, RequestedBy: "clinician"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID)
+
+	want := []File{
+		{Type: "Patient", Name: "Patient.ndjson", Count: 1, Patients: []string{"p2"}},
+		{Type: "Observation", Name: "Observation.ndjson", Count: exportPageSize + 1, Patients: []string{"p2"}},
+		{Type: "Organization", Name: "Organization.ndjson", Count: 1},
+	}
+	if !reflect.DeepEqual(job.Output, want) {
+		t.Fatalf("expected files %+v, got %+v", want, job.Output)
+	}
+	if patients := readFile(t, m, job.ID, "Patient.ndjson"); patients[0]["id"] != "p2" {
+		t.Errorf("expected only p2, got %v", patients)
+	}
+}
+
+func TestManager_Deidentify(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	job, err := m.Kickoff(Request{Level: LevelSystem, Types: []string{"Patient", "Observation"}, Deidentify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForJob(t, m, job.ID)
+
+	patients := readFile(t, m, job.ID, "Patient.ndjson")
+	for _, p := range patients {
+		if p["name"] != nil || p["identifier"] != nil || p["id"] == "p1" {
+			t.Errorf("patient was not de-identified: %v", p)
+		}
+	}
+	obs := readFile(t, m, job.ID, "Observation.ndjson")
+	ref := obs[0]["subject"].(map[string]interface{})["reference"]
+	found := false
+	for _, p := range patients {
+		if ref == "Patient/"+p["id"].(string) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("observation subject %v should reference a de-identified patient", ref)
+	}
+}
+
+func TestManager_DeleteAndExpire(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	job, err := m.Kickoff(Request{Level: LevelSystem, Types: []string{"Patient"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, m, job.ID)
+	if err := m.Delete(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetJob(job.ID); err != ErrJobNotFound {
+		t.Errorf("expected ErrJobNotFound after delete, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.config.OutputDir, job.ID)); !os.IsNotExist(err) {
+		t.Errorf("export files should be removed, got %v", err)
+	}
+
+	job, _ = m.Kickoff(Request{Level: LevelSystem, Types: []string{"Patient"}})
+	waitForJob(t, m, job.ID)
+	m.expire(time.Now().Add(30 * time.Minute))
+	if _, err := m.GetJob(job.ID); err != nil {
+		t.Errorf("export should be kept within the retention period, got %v", err)
+	}
+	m.expire(time.Now().Add(2 * time.Hour))
+	if _, err := m.GetJob(job.ID); err != ErrJobNotFound {
+		t.Errorf("expected the export to expire, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.config.OutputDir, job.ID)); !os.IsNotExist(err) {
+		t.Errorf("expired export files should be removed, got %v", err)
+	}
+
+	if _, err := m.Kickoff(Request{Level: LevelSystem}); err == nil {
+		t.Error("expected an export without types to be rejected")
+	}
+}
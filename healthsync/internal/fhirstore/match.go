@@ -0,0 +1,414 @@
+package fhirstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// matches reports whether a decoded resource satisfies every criterion
+func (q *Query) matches(doc map[string]interface{}) bool {
+	for _, c := range q.Criteria {
+		if !c.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Criterion) matches(doc map[string]interface{}) bool {
+	elems := collect(doc, c.Param.Paths)
+
+	switch c.Modifier {
+	case "missing":
+		return (len(elems) == 0) == (c.Values[0] == "true")
+	case "not":
+		return !c.matchesAny(elems)
+	}
+	return c.matchesAny(elems)
+}
+
+func (c *Criterion) matchesAny(elems []interface{}) bool {
+	for _, v := range c.parsed {
+		for _, elem := range elems {
+			if c.matchValue(v, elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Criterion) matchValue(v searchValue, elem interface{}) bool {
+	switch c.Param.Type {
+	case ParamString:
+		s, ok := elem.(string)
+		if !ok {
+			return false
+		}
+		switch c.Modifier {
+		case "exact":
+			return s == v.raw
+		case "contains":
+			return strings.Contains(strings.ToLower(s), strings.ToLower(v.raw))
+		}
+		return strings.HasPrefix(strings.ToLower(s), strings.ToLower(v.raw))
+	case ParamToken:
+		if c.Modifier == "text" {
+			return matchTokenText(v, elem)
+		}
+		return matchToken(v, elem)
+	case ParamReference:
+		return c.matchReference(v, elem)
+	case ParamDate:
+		low, high, ok := elementRange(elem)
+		return ok && compareRange(v.prefix, low, high, v.low, v.high)
+	case ParamNumber:
+		n, ok := elem.(float64)
+		return ok && compareNumber(v, n)
+	case ParamQuantity:
+		return matchQuantity(v, elem)
+	}
+	return false
+}
+
+// matchToken matches code, system|code, |code and system| against a
+// code, Coding, CodeableConcept, Identifier or ContactPoint
+func matchToken(v searchValue, elem interface{}) bool {
+	for _, t := range tokens(elem) {
+		if v.hasSystem && t.system != v.system {
+			continue
+		}
+		if (v.hasSystem && v.code == "") || t.code == v.code {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTokenText matches the start of a CodeableConcept's text or a
+// coding's display, ignoring case
+func matchTokenText(v searchValue, elem interface{}) bool {
+	m, ok := elem.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	texts := []interface{}{m["text"], m["display"]}
+	for _, coding := range asSlice(m["coding"]) {
+		if cm, ok := coding.(map[string]interface{}); ok {
+			texts = append(texts, cm["display"])
+		}
+	}
+	for _, t := range texts {
+		if s, ok := t.(string); ok && strings.HasPrefix(strings.ToLower(s), strings.ToLower(v.raw)) {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	system, code string
+}
+
+func tokens(elem interface{}) []token {
+	switch e := elem.(type) {
+	case string:
+		return []token{{code: e}}
+	case bool:
+		return []token{{code: fmt.Sprint(e)}}
+	case map[string]interface{}:
+		if codings, ok := e["coding"]; ok {
+			var out []token
+			for _, coding := range asSlice(codings) {
+				out = append(out, tokens(coding)...)
+			}
+			return out
+		}
+		system, _ := e["system"].(string)
+		if code, ok := e["code"].(string); ok {
+			return []token{{system: system, code: code}}
+		}
+		if value, ok := e["value"].(string); ok {
+			return []token{{system: system, code: value}}
+		}
+	}
+	return nil
+}
+
+// matchReference matches an id, Type/id or absolute URL against a
+// Reference, honoring a :Type modifier
+func (c *Criterion) matchReference(v searchValue, elem interface{}) bool {
+	resourceType, id := referenceTarget(elem)
+	if id == "" {
+		return false
+	}
+	if c.Modifier != "" && resourceType != c.Modifier {
+		return false
+	}
+	if !strings.Contains(v.raw, "/") {
+		return id == v.raw && (len(c.Param.Targets) == 0 || contains(c.Param.Targets, resourceType))
+	}
+	wantType, wantID := splitReference(v.raw)
+	return resourceType == wantType && id == wantID
+}
+
+// referenceTarget returns the type and id a Reference points to
+func referenceTarget(elem interface{}) (string, string) {
+	var ref string
+	switch e := elem.(type) {
+	case map[string]interface{}:
+		ref, _ = e["reference"].(string)
+	case string:
+		ref = e
+	}
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", ""
+	}
+	return splitReference(ref)
+}
+
+// splitReference returns the type and id of a relative or absolute
+// reference, dropping any _history suffix
+func splitReference(ref string) (string, string) {
+	if i := strings.Index(ref, "/_history/"); i >= 0 {
+		ref = ref[:i]
+	}
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// endOfTime is the upper bound of a Period without an end
+var endOfTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// elementRange returns the range covered by a date, dateTime, instant or
+// Period
+func elementRange(elem interface{}) (time.Time, time.Time, bool) {
+	switch e := elem.(type) {
+	case string:
+		low, high, err := parseDateRange(e)
+		return low, high, err == nil
+	case map[string]interface{}:
+		low, high := time.Time{}, endOfTime
+		if start, ok := e["start"].(string); ok {
+			t, _, err := parseDateRange(start)
+			if err != nil {
+				return low, high, false
+			}
+			low = t
+		}
+		if end, ok := e["end"].(string); ok {
+			_, t, err := parseDateRange(end)
+			if err != nil {
+				return low, high, false
+			}
+			high = t
+		}
+		return low, high, true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// compareRange applies a date prefix to the target range [tl, th) and the
+// search range [sl, sh)
+func compareRange(prefix string, tl, th, sl, sh time.Time) bool {
+	eq := !tl.Before(sl) && !th.After(sh)
+	switch prefix {
+	case "ne":
+		return !eq
+	case "gt":
+		return th.After(sh)
+	case "lt":
+		return tl.Before(sl)
+	case "ge":
+		return eq || th.After(sh)
+	case "le":
+		return eq || tl.Before(sl)
+	case "sa":
+		return !tl.Before(sh)
+	case "eb":
+		return !th.After(sl)
+	case "ap":
+		return tl.Before(sh) && th.After(sl)
+	}
+	return eq
+}
+
+func compareNumber(v searchValue, n float64) bool {
+	eq := n >= v.number-v.delta && n < v.number+v.delta
+	switch v.prefix {
+	case "ne":
+		return !eq
+	case "gt", "sa":
+		return n > v.number
+	case "lt", "eb":
+		return n < v.number
+	case "ge":
+		return n >= v.number
+	case "le":
+		return n <= v.number
+	case "ap":
+		return math.Abs(n-v.number) <= math.Max(v.delta, math.Abs(v.number)*0.1)
+	}
+	return eq
+}
+
+// matchQuantity compares the value of a Quantity, requiring the unit code
+// (or unit text) and system when the search gives them
+func matchQuantity(v searchValue, elem interface{}) bool {
+	m, ok := elem.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	n, ok := m["value"].(float64)
+	if !ok || !compareNumber(v, n) {
+		return false
+	}
+	if v.system != "" && m["system"] != v.system {
+		return false
+	}
+	return v.code == "" || m["code"] == v.code || m["unit"] == v.code
+}
+
+// collect returns the values found at any of the paths, flattening arrays
+func collect(doc map[string]interface{}, paths []string) []interface{} {
+	var out []interface{}
+	for _, path := range paths {
+		values := []interface{}{doc}
+		for _, step := range strings.Split(path, ".") {
+			var next []interface{}
+			for _, v := range values {
+				if m, ok := v.(map[string]interface{}); ok {
+					next = append(next, asSlice(m[step])...)
+				}
+			}
+			values = next
+		}
+		out = append(out, values...)
+	}
+	return out
+}
+
+func asSlice(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return t
+	}
+	return []interface{}{v}
+}
+
+func decodeDocument(res *Resource) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(res.Data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode %s/%s: %w", res.ResourceType, res.ID, err)
+	}
+	return doc, nil
+}
+
+// sortMatches orders matches by the _sort fields, falling back to last
+// update and id so pages are stable. Resources without a sort value come
+// last.
+func sortMatches(matches []*Resource, docs map[*Resource]map[string]interface{}, fields []SortField) {
+	keys := make(map[*Resource][]interface{}, len(matches))
+	for _, res := range matches {
+		for _, f := range fields {
+			keys[res] = append(keys[res], sortKey(docs[res], f.Param))
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		for k, f := range fields {
+			cmp := compareKeys(keys[a][k], keys[b][k])
+			if cmp == 0 {
+				continue
+			}
+			if keys[a][k] == nil || keys[b][k] == nil {
+				return keys[b][k] == nil
+			}
+			if f.Descending {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		if !a.LastUpdated.Equal(b.LastUpdated) {
+			return a.LastUpdated.Before(b.LastUpdated)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// sortKey returns the smallest value of a parameter in a resource, or nil
+func sortKey(doc map[string]interface{}, param *SearchParam) interface{} {
+	var best interface{}
+	for _, elem := range collect(doc, param.Paths) {
+		var key interface{}
+		switch param.Type {
+		case ParamDate:
+			if low, _, ok := elementRange(elem); ok {
+				key = low
+			}
+		case ParamNumber:
+			if n, ok := elem.(float64); ok {
+				key = n
+			}
+		case ParamQuantity:
+			if m, ok := elem.(map[string]interface{}); ok {
+				if n, ok := m["value"].(float64); ok {
+					key = n
+				}
+			}
+		case ParamReference:
+			if resourceType, id := referenceTarget(elem); id != "" {
+				key = resourceType + "/" + id
+			}
+		case ParamToken:
+			if t := tokens(elem); len(t) > 0 {
+				key = t[0].code
+			}
+		default:
+			if s, ok := elem.(string); ok {
+				key = strings.ToLower(s)
+			}
+		}
+		if key != nil && (best == nil || compareKeys(key, best) < 0) {
+			best = key
+		}
+	}
+	return best
+}
+
+func compareKeys(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	switch x := a.(type) {
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	return 0
+}
@@ -0,0 +1,307 @@
+package fhirstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Store that keeps every version of a resource
+type MemoryStore struct {
+	mu        sync.RWMutex
+	resources map[string]map[string]*entry
+}
+
+// entry holds the version history of one resource, oldest first
+type entry struct {
+	versions    []*Resource
+	identifiers []identifier
+}
+
+func (e *entry) current() *Resource {
+	return e.versions[len(e.versions)-1]
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		resources: make(map[string]map[string]*entry),
+	}
+}
+
+// Create stores a new resource, assigning an id when it has none
+func (s *MemoryStore) Create(ctx context.Context, resourceType string, data json.RawMessage) (*Resource, error) {
+	var head resourceHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	id := head.ID
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.put(resourceType, id, data)
+	if err != nil {
+		return nil, err
+	}
+	return copyResource(res), nil
+}
+
+// Read returns the current version of a resource
+func (s *MemoryStore) Read(ctx context.Context, resourceType, id string) (*Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.resources[resourceType][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.current().Deleted {
+		return nil, ErrDeleted
+	}
+	return copyResource(e.current()), nil
+}
+
+// VRead returns a specific version of a resource
+func (s *MemoryStore) VRead(ctx context.Context, resourceType, id, versionID string) (*Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.resources[resourceType][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	n, err := strconv.Atoi(versionID)
+	if err != nil || n < 1 || n > len(e.versions) {
+		return nil, ErrNotFound
+	}
+	if e.versions[n-1].Deleted {
+		return nil, ErrDeleted
+	}
+	return copyResource(e.versions[n-1]), nil
+}
+
+// History returns every version of a resource, newest first
+func (s *MemoryStore) History(ctx context.Context, resourceType, id string) ([]*Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.resources[resourceType][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	history := make([]*Resource, 0, len(e.versions))
+	for i := len(e.versions) - 1; i >= 0; i-- {
+		history = append(history, copyResource(e.versions[i]))
+	}
+	return history, nil
+}
+
+// Update stores a new version of a resource, creating it if needed
+func (s *MemoryStore) Update(ctx context.Context, resourceType, id string, data json.RawMessage) (*Resource, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.resources[resourceType][id]
+	created := !exists || e.current().Deleted
+
+	res, err := s.put(resourceType, id, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return copyResource(res), created, nil
+}
+
+// Delete marks a resource as deleted by adding an empty version
+func (s *MemoryStore) Delete(ctx context.Context, resourceType, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.resources[resourceType][id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !e.current().Deleted {
+		s.markDeleted(e, resourceType, id)
+	}
+	return nil
+}
+
+// FindByIdentifier returns the current resource carrying the identifier
+func (s *MemoryStore) FindByIdentifier(ctx context.Context, resourceType, system, value string) (*Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.resources[resourceType] {
+		for _, ident := range e.identifiers {
+			if ident.Value == value && (system == "" || ident.System == system) {
+				return copyResource(e.current()), nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List returns the current, non-deleted resources of a type by last update
+func (s *MemoryStore) List(ctx context.Context, resourceType string) ([]*Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*Resource
+	for _, e := range s.resources[resourceType] {
+		if !e.current().Deleted {
+			results = append(results, copyResource(e.current()))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastUpdated.Before(results[j].LastUpdated)
+	})
+	return results, nil
+}
+
+// Count returns the number of current, non-deleted resources of a type
+func (s *MemoryStore) Count(ctx context.Context, resourceType string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, e := range s.resources[resourceType] {
+		if !e.current().Deleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Search matches q against the current resources of its type
+func (s *MemoryStore) Search(ctx context.Context, q *Query) (*SearchResult, error) {
+	q, err := resolveChains(ctx, s, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is released before execute, which reads includes back
+	// through the store
+	s.mu.RLock()
+	var candidates []*Resource
+	for _, e := range s.resources[q.ResourceType] {
+		if cur := e.current(); !cur.Deleted && (q.After == nil || q.After.before(cur)) {
+			candidates = append(candidates, copyResource(cur))
+		}
+	}
+	s.mu.RUnlock()
+
+	return execute(ctx, s, q, candidates)
+}
+
+// Transact applies the writes under a single lock. Every write is checked
+// before any is applied, so a failed transaction leaves the store unchanged.
+func (s *MemoryStore) Transact(ctx context.Context, writes []Write) ([]*WriteResult, error) {
+	if err := checkWrites(writes); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range writes {
+		e, exists := s.resources[w.ResourceType][w.ID]
+		live := exists && !e.current().Deleted
+		if w.IfMatch != "" && (!live || e.current().VersionID != w.IfMatch) {
+			return nil, fmt.Errorf("%s/%s: %w", w.ResourceType, w.ID, ErrVersionConflict)
+		}
+		if w.Data == nil {
+			if !exists {
+				return nil, fmt.Errorf("%s/%s: %w", w.ResourceType, w.ID, ErrNotFound)
+			}
+			continue
+		}
+		if _, err := stamp(w.Data, w.ResourceType, w.ID, "0", time.Now()); err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", w.ResourceType, w.ID, err)
+		}
+	}
+
+	results := make([]*WriteResult, len(writes))
+	for i, w := range writes {
+		e, exists := s.resources[w.ResourceType][w.ID]
+		created := !exists || e.current().Deleted
+		if w.Data == nil {
+			if !e.current().Deleted {
+				s.markDeleted(e, w.ResourceType, w.ID)
+			}
+			results[i] = &WriteResult{Resource: copyResource(e.current())}
+			continue
+		}
+		res, err := s.put(w.ResourceType, w.ID, w.Data)
+		if err != nil {
+			return nil, err // not reached: the data was stamped above
+		}
+		results[i] = &WriteResult{Resource: copyResource(res), Created: created}
+	}
+	return results, nil
+}
+
+// markDeleted appends a deletion version; the caller must hold the write
+// lock
+func (s *MemoryStore) markDeleted(e *entry, resourceType, id string) {
+	e.versions = append(e.versions, &Resource{
+		ResourceType: resourceType,
+		ID:           id,
+		VersionID:    strconv.Itoa(len(e.versions) + 1),
+		LastUpdated:  time.Now(),
+		Deleted:      true,
+	})
+	e.identifiers = nil
+}
+
+// put appends a new version; the caller must hold the write lock
+func (s *MemoryStore) put(resourceType, id string, data json.RawMessage) (*Resource, error) {
+	byID, ok := s.resources[resourceType]
+	if !ok {
+		byID = make(map[string]*entry)
+		s.resources[resourceType] = byID
+	}
+	e, ok := byID[id]
+	if !ok {
+		e = &entry{}
+	}
+
+	now := time.Now()
+	versionID := strconv.Itoa(len(e.versions) + 1)
+	stamped, err := stamp(data, resourceType, id, versionID, now)
+	if err != nil {
+		return nil, err
+	}
+	var head resourceHead
+	if err := json.Unmarshal(stamped, &head); err != nil {
+		return nil, err
+	}
+
+	res := &Resource{
+		ResourceType: resourceType,
+		ID:           id,
+		VersionID:    versionID,
+		LastUpdated:  now,
+		Data:         stamped,
+	}
+	e.versions = append(e.versions, res)
+	e.identifiers = head.Identifier
+	byID[id] = e
+	return res, nil
+}
+
+// copyResource returns a copy so callers cannot modify stored data
+func copyResource(r *Resource) *Resource {
+	c := *r
+	c.Data = append(json.RawMessage(nil), r.Data...)
+	return &c
+}
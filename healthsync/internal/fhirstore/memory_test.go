@@ -0,0 +1,209 @@
+package fhirstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+func TestMemoryStore_Versions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	res, err := store.Create(ctx, "Patient", json.RawMessage(`{"resourceType":"Patient","meta":{"source":"lab"}}`))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if res.ID == "" || res.VersionID != "1" {
+		t.Fatalf("unexpected resource %+v", res)
+	}
+	var patient models.Patient
+	if err := res.Unmarshal(&patient); err != nil {
+		t.Fatal(err)
+	}
+	if patient.ID != res.ID || patient.Meta == nil || patient.Meta.VersionID != "1" || patient.Meta.Source != "lab" {
+		t.Errorf("id and meta should be stamped into the data: %+v", patient.Meta)
+	}
+
+	updated, created, err := store.Update(ctx, "Patient", res.ID, json.RawMessage(`{"resourceType":"Patient","gender":"female"}`))
+	if err != nil || created || updated.VersionID != "2" {
+		t.Fatalf("Update returned %+v, %v, %v", updated, created, err)
+	}
+	if _, _, err := store.Update(ctx, "Patient", res.ID, json.RawMessage(`{"resourceType":"Observation"}`)); err == nil {
+		t.Error("expected a resourceType mismatch to be rejected")
+	}
+
+	if err := store.Delete(ctx, "Patient", res.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(ctx, "Patient", res.ID); err != ErrDeleted {
+		t.Errorf("expected ErrDeleted, got %v", err)
+	}
+	if _, err := store.Read(ctx, "Patient", "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if n, _ := store.Count(ctx, "Patient"); n != 0 {
+		t.Errorf("deleted resources should not be counted, got %d", n)
+	}
+
+	_, created, _ = store.Update(ctx, "Patient", res.ID, json.RawMessage(`{"resourceType":"Patient"}`))
+	if !created {
+		t.Error("updating a deleted resource should recreate it")
+	}
+}
+
+func TestSave_FindByIdentifier(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	patient := &models.Patient{
+		FHIRResource: models.FHIRResource{
+			ResourceType: models.ResourceTypePatient,
+			Identifier:   []models.Identifier{{System: "HOSP", Value: "MRN-1"}},
+		},
+		Gender: "male",
+	}
+	if _, err := Save(ctx, store, patient); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if patient.ID == "" || patient.Meta == nil || patient.Meta.VersionID != "1" {
+		t.Fatalf("Save should copy id and meta back: %+v", patient.FHIRResource)
+	}
+
+	found, err := store.FindByIdentifier(ctx, "Patient", "HOSP", "MRN-1")
+	if err != nil || found.ID != patient.ID {
+		t.Fatalf("FindByIdentifier returned %+v, %v", found, err)
+	}
+	if _, err := store.FindByIdentifier(ctx, "Patient", "", "MRN-1"); err != nil {
+		t.Errorf("an empty system should match any system: %v", err)
+	}
+	if _, err := store.FindByIdentifier(ctx, "Patient", "OTHER", "MRN-1"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for another system, got %v", err)
+	}
+
+	patient.Gender = "female"
+	res, err := Save(ctx, store, patient)
+	if err != nil || res.VersionID != "2" || patient.Meta.VersionID != "2" {
+		t.Fatalf("second Save should update: %+v, %v", res, err)
+	}
+	list, _ := store.List(ctx, "Patient")
+	if len(list) != 1 {
+		t.Errorf("expected 1 patient, got %d", len(list))
+	}
+}
+
+func TestMemoryStore_History(t *testing.T) {
+	testStoreHistory(t, NewMemoryStore())
+}
+
+// testStoreHistory checks vread and history against a store
+func testStoreHistory(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if _, _, err := store.Update(ctx, "Patient", "h1", json.RawMessage(`{"resourceType":"Patient","gender":"male"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Update(ctx, "Patient", "h1", json.RawMessage(`{"resourceType":"Patient","gender":"female"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "Patient", "h1"); err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := store.VRead(ctx, "Patient", "h1", "1")
+	if err != nil {
+		t.Fatalf("VRead failed: %v", err)
+	}
+	var patient models.Patient
+	if err := v1.Unmarshal(&patient); err != nil || patient.Gender != "male" || patient.Meta.VersionID != "1" {
+		t.Errorf("unexpected version 1: %+v, %v", patient, err)
+	}
+	if _, err := store.VRead(ctx, "Patient", "h1", "3"); err != ErrDeleted {
+		t.Errorf("expected ErrDeleted for the deletion version, got %v", err)
+	}
+	if _, err := store.VRead(ctx, "Patient", "h1", "9"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing version, got %v", err)
+	}
+
+	history, err := store.History(ctx, "Patient", "h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].VersionID != "3" || !history[0].Deleted || history[2].VersionID != "1" {
+		t.Errorf("expected three versions newest first, got %+v", history)
+	}
+	if _, err := store.History(ctx, "Patient", "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStore_Transact(t *testing.T) {
+	testStoreTransact(t, NewMemoryStore())
+}
+
+// testStoreTransact checks that a store applies every write of a
+// transaction or none of them
+func testStoreTransact(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if _, _, err := store.Update(ctx, "Patient", "t1", json.RawMessage(`{"resourceType":"Patient","gender":"male"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Update(ctx, "Patient", "t2", json.RawMessage(`{"resourceType":"Patient"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.Transact(ctx, []Write{
+		{ResourceType: "Patient", ID: "t1", Data: json.RawMessage(`{"resourceType":"Patient","gender":"female"}`), IfMatch: "1"},
+		{ResourceType: "Observation", ID: "t3", Data: json.RawMessage(`{"resourceType":"Observation","subject":{"reference":"Patient/t1"}}`)},
+		{ResourceType: "Patient", ID: "t2"},
+	})
+	if err != nil {
+		t.Fatalf("Transact failed: %v", err)
+	}
+	if r := results[0]; r.Created || r.Resource.VersionID != "2" {
+		t.Errorf("expected t1 to be updated to version 2, got %+v", r.Resource)
+	}
+	if r := results[1]; !r.Created || r.Resource.PatientID() != "t1" {
+		t.Errorf("expected t3 to be created in t1's compartment, got %+v", r.Resource)
+	}
+	if r := results[2]; !r.Resource.Deleted || r.Resource.VersionID != "2" {
+		t.Errorf("expected t2 to be deleted, got %+v", r.Resource)
+	}
+
+	failing := [][]Write{
+		{
+			{ResourceType: "Observation", ID: "t4", Data: json.RawMessage(`{"resourceType":"Observation"}`)},
+			{ResourceType: "Patient", ID: "t1", Data: json.RawMessage(`{"resourceType":"Patient"}`), IfMatch: "1"},
+		},
+		{
+			{ResourceType: "Observation", ID: "t4", Data: json.RawMessage(`{"resourceType":"Observation"}`)},
+			{ResourceType: "Patient", ID: "missing"},
+		},
+		{
+			{ResourceType: "Observation", ID: "t4", Data: json.RawMessage(`{"resourceType":"Observation"}`)},
+			{ResourceType: "Observation", ID: "t5", Data: json.RawMessage(`{"resourceType":"Patient"}`)},
+		},
+		{
+			{ResourceType: "Observation", ID: "t4", Data: json.RawMessage(`{"resourceType":"Observation"}`)},
+			{ResourceType: "Observation", ID: "t4"},
+		},
+	}
+	for i, writes := range failing {
+		if _, err := store.Transact(ctx, writes); err == nil {
+			t.Errorf("transaction %d should fail", i)
+		}
+		if _, err := store.Read(ctx, "Observation", "t4"); err != ErrNotFound {
+			t.Errorf("transaction %d should not write t4, got %v", i, err)
+		}
+	}
+	if _, err := store.Transact(ctx, failing[0]); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+	if res, _ := store.Read(ctx, "Patient", "t1"); res.VersionID != "2" {
+		t.Errorf("failed transactions should not write t1, got version %s", res.VersionID)
+	}
+}
@@ -0,0 +1,549 @@
+package fhirstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/savegress/healthsync/internal/config"
+)
+
+// schema creates the resource tables. fhir_resources holds the current
+// version of every resource; fhir_resource_history holds every version.
+const schema = `
+CREATE TABLE IF NOT EXISTS fhir_resources (
+	resource_type TEXT        NOT NULL,
+	id            TEXT        NOT NULL,
+	version_id    INTEGER     NOT NULL,
+	last_updated  TIMESTAMPTZ NOT NULL,
+	deleted       BOOLEAN     NOT NULL DEFAULT FALSE,
+	data          JSONB,
+	PRIMARY KEY (resource_type, id)
+);
+CREATE INDEX IF NOT EXISTS fhir_resources_updated_idx ON fhir_resources (resource_type, last_updated);
+CREATE INDEX IF NOT EXISTS fhir_resources_data_idx ON fhir_resources USING GIN (data jsonb_path_ops);
+
+CREATE TABLE IF NOT EXISTS fhir_resource_history (
+	resource_type TEXT        NOT NULL,
+	id            TEXT        NOT NULL,
+	version_id    INTEGER     NOT NULL,
+	last_updated  TIMESTAMPTZ NOT NULL,
+	deleted       BOOLEAN     NOT NULL DEFAULT FALSE,
+	data          JSONB,
+	PRIMARY KEY (resource_type, id, version_id)
+);`
+
+const resourceColumns = `resource_type, id, version_id, last_updated, deleted, data`
+
+// PostgresStore is a Store that keeps resources as JSONB in PostgreSQL
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresStore connects to the database and creates the schema if it
+// does not exist
+func NewPostgresStore(ctx context.Context, cfg *config.DatabaseConfig) (*PostgresStore, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConns)
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = int32(cfg.MinConns)
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	if _, err := pool.Exec(ctx, schema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return &PostgresStore{pool: pool}, nil
+}
+
+// Close closes the connection pool
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+// Create stores a new resource, assigning an id when it has none
+func (s *PostgresStore) Create(ctx context.Context, resourceType string, data json.RawMessage) (*Resource, error) {
+	var head resourceHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	id := head.ID
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	res, _, err := s.write(ctx, resourceType, id, data)
+	return res, err
+}
+
+// Read returns the current version of a resource
+func (s *PostgresStore) Read(ctx context.Context, resourceType, id string) (*Resource, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+resourceColumns+` FROM fhir_resources
+		WHERE resource_type = $1 AND id = $2`, resourceType, id)
+	res, err := scanResource(row)
+	if err != nil {
+		return nil, err
+	}
+	if res.Deleted {
+		return nil, ErrDeleted
+	}
+	return res, nil
+}
+
+// VRead returns a specific version of a resource
+func (s *PostgresStore) VRead(ctx context.Context, resourceType, id, versionID string) (*Resource, error) {
+	version, err := strconv.Atoi(versionID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	row := s.pool.QueryRow(ctx, `SELECT `+resourceColumns+` FROM fhir_resource_history
+		WHERE resource_type = $1 AND id = $2 AND version_id = $3`, resourceType, id, version)
+	res, err := scanResource(row)
+	if err != nil {
+		return nil, err
+	}
+	if res.Deleted {
+		return nil, ErrDeleted
+	}
+	return res, nil
+}
+
+// History returns every version of a resource, newest first
+func (s *PostgresStore) History(ctx context.Context, resourceType, id string) ([]*Resource, error) {
+	history, err := s.query(ctx, `SELECT `+resourceColumns+` FROM fhir_resource_history
+		WHERE resource_type = $1 AND id = $2 ORDER BY version_id DESC`, resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, ErrNotFound
+	}
+	return history, nil
+}
+
+// Update stores a new version of a resource, creating it if needed
+func (s *PostgresStore) Update(ctx context.Context, resourceType, id string, data json.RawMessage) (*Resource, bool, error) {
+	return s.write(ctx, resourceType, id, data)
+}
+
+// Delete marks a resource as deleted by adding an empty version
+func (s *PostgresStore) Delete(ctx context.Context, resourceType, id string) error {
+	_, _, err := s.write(ctx, resourceType, id, nil)
+	return err
+}
+
+// FindByIdentifier returns the current resource carrying the identifier
+func (s *PostgresStore) FindByIdentifier(ctx context.Context, resourceType, system, value string) (*Resource, error) {
+	filter := "@.value == " + jsonPathString(value)
+	if system != "" {
+		filter += " && @.system == " + jsonPathString(system)
+	}
+	row := s.pool.QueryRow(ctx, `SELECT `+resourceColumns+` FROM fhir_resources
+		WHERE resource_type = $1 AND NOT deleted AND data @? $2::jsonpath
+		ORDER BY last_updated LIMIT 1`, resourceType, "$.identifier ? ("+filter+")")
+	return scanResource(row)
+}
+
+// List returns the current, non-deleted resources of a type by last update
+func (s *PostgresStore) List(ctx context.Context, resourceType string) ([]*Resource, error) {
+	return s.query(ctx, `SELECT `+resourceColumns+` FROM fhir_resources
+		WHERE resource_type = $1 AND NOT deleted ORDER BY last_updated, id`, resourceType)
+}
+
+// Count returns the number of current, non-deleted resources of a type
+func (s *PostgresStore) Count(ctx context.Context, resourceType string) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM fhir_resources
+		WHERE resource_type = $1 AND NOT deleted`, resourceType).Scan(&count)
+	return count, err
+}
+
+// Search narrows the candidates in SQL using the criteria that translate
+// to JSONB path queries, then applies the full matcher to them. When every
+// criterion translates exactly and SQL can express the sort, the database
+// sorts, pages and counts the matches itself.
+func (s *PostgresStore) Search(ctx context.Context, q *Query) (*SearchResult, error) {
+	q, err := resolveChains(ctx, s, q)
+	if err != nil {
+		return nil, err
+	}
+
+	where := `resource_type = $1 AND NOT deleted`
+	args := []interface{}{q.ResourceType}
+	exact := true
+	for _, c := range q.Criteria {
+		clause, clauseArgs := pushdown(c, len(args)+1)
+		if clause == "" {
+			exact = false
+			continue
+		}
+		where += " AND " + clause
+		args = append(args, clauseArgs...)
+		exact = exact && exactPushdown(c)
+	}
+	if q.After != nil {
+		where += fmt.Sprintf(" AND (last_updated, id) > ($%d, $%d)", len(args)+1, len(args)+2)
+		args = append(args, q.After.LastUpdated, q.After.ID)
+	}
+
+	if order, ok := sqlOrder(q.Sort); exact && ok {
+		return s.searchPage(ctx, q, where, order, args)
+	}
+
+	candidates, err := s.query(ctx, `SELECT `+resourceColumns+` FROM fhir_resources WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	return execute(ctx, s, q, candidates)
+}
+
+// searchPage counts the rows matching where and reads q's page of them
+func (s *PostgresStore) searchPage(ctx context.Context, q *Query, where, order string, args []interface{}) (*SearchResult, error) {
+	// A cursor scan is read page by page, and counting what remains on
+	// every page would rescan it
+	result := &SearchResult{}
+	if q.After == nil {
+		if err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM fhir_resources WHERE `+where, args...).Scan(&result.Total); err != nil {
+			return nil, err
+		}
+	}
+
+	sql := `SELECT ` + resourceColumns + ` FROM fhir_resources WHERE ` + where + ` ORDER BY ` + order
+	if q.Count >= 0 {
+		sql += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, q.Count)
+	}
+	sql += fmt.Sprintf(" OFFSET $%d", len(args)+1)
+	args = append(args, q.Offset)
+
+	matches, err := s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	result.Matches = matches
+	if result.Included, err = Includes(ctx, s, q, matches); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Transact applies the writes in one database transaction, locking the
+// resources in a fixed order so concurrent transactions cannot deadlock
+func (s *PostgresStore) Transact(ctx context.Context, writes []Write) ([]*WriteResult, error) {
+	if err := checkWrites(writes); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	keys := make([]string, len(writes))
+	for i, w := range writes {
+		keys[i] = w.ResourceType + "/" + w.ID
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]*WriteResult, len(writes))
+	for i, w := range writes {
+		res, created, err := writeVersion(ctx, tx, w)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", w.ResourceType, w.ID, err)
+		}
+		results[i] = &WriteResult{Resource: res, Created: created}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// write adds a version of a resource in a transaction; nil data records a
+// deletion. Writers to the same resource are serialized with an advisory
+// lock.
+func (s *PostgresStore) write(ctx context.Context, resourceType, id string, data json.RawMessage) (*Resource, bool, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, resourceType+"/"+id); err != nil {
+		return nil, false, err
+	}
+	res, created, err := writeVersion(ctx, tx, Write{ResourceType: resourceType, ID: id, Data: data})
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return res, created, nil
+}
+
+// writeVersion adds the next version of a resource within tx, which must
+// hold the resource's advisory lock. Deleting a deleted resource returns
+// its current version without writing.
+func writeVersion(ctx context.Context, tx pgx.Tx, w Write) (*Resource, bool, error) {
+	row := tx.QueryRow(ctx, `SELECT `+resourceColumns+` FROM fhir_resources
+		WHERE resource_type = $1 AND id = $2`, w.ResourceType, w.ID)
+	current, err := scanResource(row)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+	if w.IfMatch != "" && (!exists || current.Deleted || current.VersionID != w.IfMatch) {
+		return nil, false, ErrVersionConflict
+	}
+	if w.Data == nil {
+		if !exists {
+			return nil, false, ErrNotFound
+		}
+		if current.Deleted {
+			return current, false, nil
+		}
+	}
+
+	version := 0
+	if exists {
+		version, _ = strconv.Atoi(current.VersionID)
+	}
+	res := &Resource{
+		ResourceType: w.ResourceType,
+		ID:           w.ID,
+		VersionID:    strconv.Itoa(version + 1),
+		LastUpdated:  time.Now().UTC().Truncate(time.Microsecond),
+		Deleted:      w.Data == nil,
+	}
+	if w.Data != nil {
+		if res.Data, err = stamp(w.Data, w.ResourceType, w.ID, res.VersionID, res.LastUpdated); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO fhir_resources (`+resourceColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (resource_type, id) DO UPDATE SET
+			version_id = EXCLUDED.version_id,
+			last_updated = EXCLUDED.last_updated,
+			deleted = EXCLUDED.deleted,
+			data = EXCLUDED.data`,
+		w.ResourceType, w.ID, version+1, res.LastUpdated, res.Deleted, []byte(res.Data)); err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO fhir_resource_history (`+resourceColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		w.ResourceType, w.ID, version+1, res.LastUpdated, res.Deleted, []byte(res.Data)); err != nil {
+		return nil, false, err
+	}
+	return res, !exists || current.Deleted, nil
+}
+
+func (s *PostgresStore) query(ctx context.Context, sql string, args ...interface{}) ([]*Resource, error) {
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*Resource
+	for rows.Next() {
+		res, err := scanResource(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+func scanResource(row pgx.Row) (*Resource, error) {
+	var res Resource
+	var version int
+	var data []byte
+	err := row.Scan(&res.ResourceType, &res.ID, &version, &res.LastUpdated, &res.Deleted, &data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.VersionID = strconv.Itoa(version)
+	res.Data = data
+	return &res, nil
+}
+
+// pushdown translates a criterion into a SQL condition whose matches are a
+// superset of the criterion's; argument placeholders start at $n. It
+// returns an empty clause for criteria that only the matcher evaluates.
+func pushdown(c *Criterion, n int) (string, []interface{}) {
+	if c.Modifier == "missing" || c.Modifier == "not" || c.Modifier == "text" {
+		return "", nil
+	}
+	if len(c.parsed) == 0 {
+		return "FALSE", nil
+	}
+
+	switch c.Param.Name {
+	case "_id":
+		ids := make([]string, len(c.parsed))
+		for i, v := range c.parsed {
+			ids[i] = v.raw
+		}
+		return fmt.Sprintf("id = ANY($%d)", n), []interface{}{ids}
+	case "_lastUpdated":
+		if len(c.parsed) != 1 {
+			return "", nil
+		}
+		v := c.parsed[0]
+		switch v.prefix {
+		case "eq":
+			return fmt.Sprintf("last_updated >= $%d AND last_updated < $%d", n, n+1), []interface{}{v.low, v.high}
+		case "gt", "ge", "sa":
+			return fmt.Sprintf("last_updated >= $%d", n), []interface{}{v.low}
+		case "lt", "le", "eb":
+			return fmt.Sprintf("last_updated < $%d", n), []interface{}{v.high}
+		}
+		return "", nil
+	}
+
+	var conditions []string
+	for _, v := range c.parsed {
+		cond := pathCondition(c, v)
+		if cond == "" {
+			return "", nil
+		}
+		conditions = append(conditions, cond)
+	}
+	filter := "(" + strings.Join(conditions, " || ") + ")"
+
+	var clauses []string
+	var args []interface{}
+	for _, path := range c.Param.Paths {
+		clauses = append(clauses, fmt.Sprintf("data @? $%d::jsonpath", n+len(args)))
+		args = append(args, jsonPathOf(path)+" ? "+filter)
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// exactPushdown reports whether the condition pushdown builds for c holds
+// for exactly the resources the matcher accepts, rather than a superset
+func exactPushdown(c *Criterion) bool {
+	if c.Modifier == "missing" || c.Modifier == "not" || c.Modifier == "text" {
+		return false
+	}
+	for _, v := range c.parsed {
+		switch {
+		case c.Param.Name == "_id":
+			if c.Modifier != "" || v.hasSystem {
+				return false
+			}
+		case c.Param.Type == ParamString:
+			// Prefix and contains matches fold case in Go, not in jsonpath
+			if c.Modifier != "exact" {
+				return false
+			}
+		case c.Param.Type == ParamReference:
+			// A bare id also has to check the target type, and an absolute
+			// URL is compared by its last two segments only
+			if c.Modifier != "" || strings.Count(v.raw, "/") != 1 || strings.Contains(v.raw, "_history") {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sqlOrder returns the ORDER BY clause that orders rows as sortMatches
+// does, for no sort or a sort by _lastUpdated alone
+func sqlOrder(fields []SortField) (string, bool) {
+	switch {
+	case len(fields) == 0:
+		return "last_updated, id", true
+	case len(fields) == 1 && fields[0].Param.Name == "_lastUpdated":
+		if fields[0].Descending {
+			return "last_updated DESC, id", true
+		}
+		return "last_updated, id", true
+	}
+	return "", false
+}
+
+// pathCondition returns a jsonpath filter that holds for at least every
+// element the matcher accepts for v, or "" when there is none
+func pathCondition(c *Criterion, v searchValue) string {
+	switch c.Param.Type {
+	case ParamString:
+		switch c.Modifier {
+		case "exact":
+			return "@ == " + jsonPathString(v.raw)
+		case "contains":
+			return "@ like_regex " + jsonPathString(regexp.QuoteMeta(v.raw)) + ` flag "i"`
+		}
+		return "@ like_regex " + jsonPathString("^"+regexp.QuoteMeta(v.raw)) + ` flag "i"`
+	case ParamToken:
+		if v.code == "" {
+			return ""
+		}
+		code := jsonPathString(v.code)
+		cond := "@ == " + code + " || @.code == " + code + " || @.value == " + code + " || @.coding.code == " + code
+		if v.code == "true" || v.code == "false" {
+			cond += " || @ == " + v.code
+		}
+		return cond
+	case ParamReference:
+		pattern := jsonPathString("(^|/)" + regexp.QuoteMeta(v.raw) + "(/_history/.*)?$")
+		return "@.reference like_regex " + pattern + " || @ like_regex " + pattern
+	}
+	return ""
+}
+
+// jsonPathOf converts a dotted element path to a lax jsonpath, which
+// unwraps arrays at every step
+func jsonPathOf(path string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range strings.Split(path, ".") {
+		b.WriteString(".")
+		b.WriteString(jsonPathString(step))
+	}
+	return b.String()
+}
+
+// jsonPathString quotes s as a jsonpath string literal
+func jsonPathString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
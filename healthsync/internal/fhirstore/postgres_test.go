@@ -0,0 +1,109 @@
+package fhirstore
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/savegress/healthsync/internal/config"
+)
+
+// newTestPostgresStore connects to HEALTHSYNC_TEST_DATABASE_URL and empties
+// the resource tables
+func newTestPostgresStore(t *testing.T) *PostgresStore {
+	t.Helper()
+	url := os.Getenv("HEALTHSYNC_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("requires database connection (set HEALTHSYNC_TEST_DATABASE_URL)")
+	}
+
+	ctx := context.Background()
+	store, err := NewPostgresStore(ctx, &config.DatabaseConfig{URL: url, MaxConns: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	if _, err := store.pool.Exec(ctx, `TRUNCATE fhir_resources, fhir_resource_history`); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestPostgresStore_History(t *testing.T) {
+	testStoreHistory(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStore_Search(t *testing.T) {
+	testStoreSearch(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStore_Transact(t *testing.T) {
+	testStoreTransact(t, newTestPostgresStore(t))
+}
+
+func TestPushdown(t *testing.T) {
+	tests := []struct {
+		resourceType, key, value string
+		clause                   string
+		paths                    []string
+	}{
+		{"Patient", "_id", "p1,p2", "id = ANY($2)", nil},
+		{"Patient", "_lastUpdated", "ge2026-01-01", "last_updated >= $2", nil},
+		{"Patient", "gender", "female", "(data @? $2::jsonpath)", []string{`$."gender" ? (@ == "female" || @.code == "female" || @.value == "female" || @.coding.code == "female")`}},
+		{"Patient", "family:exact", `O"Brien`, "(data @? $2::jsonpath)", []string{`$."name"."family" ? (@ == "O\"Brien")`}},
+		{"Observation", "subject", "Patient/p.1", "(data @? $2::jsonpath)", []string{`$."subject" ? (@.reference like_regex "(^|/)Patient/p\\.1(/_history/.*)?$" || @ like_regex "(^|/)Patient/p\\.1(/_history/.*)?$")`}},
+		{"Patient", "birthdate", "1980", "", nil},
+		{"Patient", "gender:missing", "true", "", nil},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCriterion(tt.resourceType, tt.key, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause, args := pushdown(c, 2)
+		if clause != tt.clause {
+			t.Errorf("%s=%s: expected clause %q, got %q", tt.key, tt.value, tt.clause, clause)
+		}
+		for i, want := range tt.paths {
+			if i >= len(args) || args[i] != want {
+				t.Errorf("%s=%s: expected path %s, got %v", tt.key, tt.value, want, args)
+			}
+		}
+	}
+
+	// The name parameter spans several elements, each with its own path
+	c, _ := parseCriterion("Patient", "name", "smi")
+	clause, args := pushdown(c, 3)
+	if strings.Count(clause, " OR ") != len(c.Param.Paths)-1 || len(args) != len(c.Param.Paths) || !strings.Contains(clause, "$7::jsonpath") {
+		t.Errorf("unexpected multi-path clause %q with %d args", clause, len(args))
+	}
+}
+
+func TestExactPushdown(t *testing.T) {
+	tests := []struct {
+		resourceType, key, value string
+		exact                    bool
+	}{
+		{"Patient", "_id", "p1,p2", true},
+		{"Patient", "family:exact", "Smith", true},
+		{"Observation", "subject", "Patient/p1", true},
+		{"Observation", "subject", "p1", false},
+		{"Observation", "subject", "http://example.org/fhir/Patient/p1", false},
+		{"Patient", "family", "smi", false},
+		{"Patient", "gender", "female", false},
+		{"Patient", "_lastUpdated", "ge2026-01-01", false},
+		{"Patient", "gender:missing", "true", false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCriterion(tt.resourceType, tt.key, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exactPushdown(c); got != tt.exact {
+			t.Errorf("%s=%s: exact = %v, want %v", tt.key, tt.value, got, tt.exact)
+		}
+	}
+}
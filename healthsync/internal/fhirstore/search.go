@@ -0,0 +1,580 @@
+package fhirstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSearch is returned for search parameters that cannot be parsed
+var ErrInvalidSearch = errors.New("invalid search")
+
+// Query is a parsed FHIR search over one resource type. Criteria are
+// AND-ed; the values of each criterion are OR-ed.
+type Query struct {
+	ResourceType string
+	Criteria     []*Criterion
+	Sort         []SortField
+	Count        int // page size; negative returns every match
+	Offset       int
+	Include      []Include
+	RevInclude   []Include
+	Unknown      []string // parameters the server does not support and ignored
+
+	// After resumes a scan in last update order past a position, so a
+	// caller can page through every match without offsets that shift as
+	// resources change. It is only meaningful without Sort, and the total
+	// of such a scan may be left uncounted.
+	After *Cursor
+}
+
+// Cursor is a position in last update order
+type Cursor struct {
+	LastUpdated time.Time
+	ID          string
+}
+
+// before reports whether res comes after the cursor
+func (c *Cursor) before(res *Resource) bool {
+	if !res.LastUpdated.Equal(c.LastUpdated) {
+		return res.LastUpdated.After(c.LastUpdated)
+	}
+	return res.ID > c.ID
+}
+
+// Criterion is one search parameter with its modifier and values
+type Criterion struct {
+	Param    *SearchParam
+	Modifier string   // exact, contains, missing, not, text, or a resource type
+	Values   []string // raw values, before escapes are removed
+	Chain    []*Query // chained searches whose matches this reference must point to
+
+	parsed []searchValue
+}
+
+// SortField is one _sort key
+type SortField struct {
+	Param      *SearchParam
+	Descending bool
+}
+
+// Include is an _include or _revinclude directive
+type Include struct {
+	SourceType string
+	Param      *SearchParam
+	TargetType string // optional restriction on the referenced type
+}
+
+// SearchResult is a page of matches plus the resources pulled in by
+// _include and _revinclude
+type SearchResult struct {
+	Matches  []*Resource
+	Included []*Resource
+	Total    int
+}
+
+// searchValue is one search value parsed according to its parameter type
+type searchValue struct {
+	raw       string
+	prefix    string // eq, ne, gt, lt, ge, le, sa, eb, ap
+	system    string
+	code      string
+	hasSystem bool
+	number    float64
+	delta     float64 // half the precision of number
+	low, high time.Time
+}
+
+var prefixes = []string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb", "ap"}
+
+// ignoredParams are result parameters that do not affect matching
+var ignoredParams = map[string]bool{"_format": true, "_pretty": true, "_total": true}
+
+// ParseQuery parses URL search parameters for a resource type. Parameters
+// the server does not know are listed in Query.Unknown rather than
+// rejected; malformed values return an error wrapping ErrInvalidSearch.
+func ParseQuery(resourceType string, values url.Values) (*Query, error) {
+	q := &Query{ResourceType: resourceType, Count: -1}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range values[key] {
+			if err := q.parseParam(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return q, nil
+}
+
+func (q *Query) parseParam(key, value string) error {
+	switch key {
+	case "_count":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: _count must be a non-negative integer", ErrInvalidSearch)
+		}
+		q.Count = n
+		return nil
+	case "_offset":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: _offset must be a non-negative integer", ErrInvalidSearch)
+		}
+		q.Offset = n
+		return nil
+	case "_summary":
+		if value != "count" {
+			q.Unknown = append(q.Unknown, key)
+			return nil
+		}
+		q.Count = 0
+		return nil
+	case "_sort":
+		return q.parseSort(value)
+	case "_include":
+		inc, err := parseInclude(value)
+		if err != nil {
+			return err
+		}
+		if inc.SourceType != q.ResourceType {
+			return fmt.Errorf("%w: _include %s does not start from %s", ErrInvalidSearch, value, q.ResourceType)
+		}
+		q.Include = append(q.Include, *inc)
+		return nil
+	case "_revinclude":
+		inc, err := parseInclude(value)
+		if err != nil {
+			return err
+		}
+		q.RevInclude = append(q.RevInclude, *inc)
+		return nil
+	}
+	if ignoredParams[key] {
+		return nil
+	}
+
+	c, err := parseCriterion(q.ResourceType, key, value)
+	if errors.Is(err, errUnknownParam) {
+		q.Unknown = append(q.Unknown, key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	q.Criteria = append(q.Criteria, c)
+	return nil
+}
+
+func (q *Query) parseSort(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		field := SortField{}
+		if strings.HasPrefix(name, "-") {
+			field.Descending = true
+			name = name[1:]
+		}
+		param, ok := LookupSearchParam(q.ResourceType, name)
+		if !ok {
+			return fmt.Errorf("%w: cannot sort by %s", ErrInvalidSearch, name)
+		}
+		field.Param = param
+		q.Sort = append(q.Sort, field)
+	}
+	return nil
+}
+
+// parseInclude parses Type:param[:targetType]
+func parseInclude(value string) (*Include, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("%w: include %q must be Type:param[:target]", ErrInvalidSearch, value)
+	}
+	param, ok := LookupSearchParam(parts[0], parts[1])
+	if !ok || param.Type != ParamReference {
+		return nil, fmt.Errorf("%w: %s:%s is not a reference parameter", ErrInvalidSearch, parts[0], parts[1])
+	}
+	inc := &Include{SourceType: parts[0], Param: param}
+	if len(parts) == 3 {
+		inc.TargetType = parts[2]
+	}
+	return inc, nil
+}
+
+var errUnknownParam = errors.New("unknown search parameter")
+
+// parseCriterion parses name[:modifier][.chain]=value for a resource type
+func parseCriterion(resourceType, key, value string) (*Criterion, error) {
+	name, chain, chained := strings.Cut(key, ".")
+	name, modifier, _ := strings.Cut(name, ":")
+
+	param, ok := LookupSearchParam(resourceType, name)
+	if !ok {
+		return nil, errUnknownParam
+	}
+	c := &Criterion{Param: param, Modifier: modifier, Values: splitEscaped(value, ',')}
+
+	if chained {
+		return c, c.parseChain(chain, value)
+	}
+	if err := c.checkModifier(); err != nil {
+		return nil, err
+	}
+	if modifier == "missing" {
+		if value != "true" && value != "false" {
+			return nil, fmt.Errorf("%w: %s:missing must be true or false", ErrInvalidSearch, name)
+		}
+		return c, nil
+	}
+
+	for _, raw := range c.Values {
+		v, err := parseSearchValue(param.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s=%s: %v", ErrInvalidSearch, key, raw, err)
+		}
+		c.parsed = append(c.parsed, v)
+	}
+	return c, nil
+}
+
+// parseChain resolves the chained parameter against every resource type
+// the reference can point to that defines it
+func (c *Criterion) parseChain(chain, value string) error {
+	if c.Param.Type != ParamReference {
+		return fmt.Errorf("%w: %s is not a reference parameter and cannot be chained", ErrInvalidSearch, c.Param.Name)
+	}
+	targets := c.Param.Targets
+	if c.Modifier != "" {
+		if len(targets) > 0 && !contains(targets, c.Modifier) {
+			return fmt.Errorf("%w: %s cannot reference %s", ErrInvalidSearch, c.Param.Name, c.Modifier)
+		}
+		targets = []string{c.Modifier}
+	}
+
+	for _, target := range targets {
+		inner, err := parseCriterion(target, chain, value)
+		if errors.Is(err, errUnknownParam) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Chain = append(c.Chain, &Query{ResourceType: target, Criteria: []*Criterion{inner}, Count: -1})
+	}
+	if len(c.Chain) == 0 {
+		return fmt.Errorf("%w: no target of %s supports chained parameter %s", ErrInvalidSearch, c.Param.Name, chain)
+	}
+	return nil
+}
+
+func (c *Criterion) checkModifier() error {
+	switch c.Modifier {
+	case "", "missing":
+		return nil
+	case "exact", "contains":
+		if c.Param.Type == ParamString {
+			return nil
+		}
+	case "not", "text":
+		if c.Param.Type == ParamToken {
+			return nil
+		}
+	default:
+		if c.Param.Type == ParamReference && (len(c.Param.Targets) == 0 || contains(c.Param.Targets, c.Modifier)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: modifier :%s is not supported for %s", ErrInvalidSearch, c.Modifier, c.Param.Name)
+}
+
+func parseSearchValue(paramType ParamType, raw string) (searchValue, error) {
+	v := searchValue{raw: unescape(raw)}
+
+	switch paramType {
+	case ParamToken:
+		parts := splitEscaped(raw, '|')
+		if len(parts) > 1 {
+			v.hasSystem = true
+			v.system = unescape(parts[0])
+			v.code = unescape(strings.Join(parts[1:], "|"))
+		} else {
+			v.code = v.raw
+		}
+	case ParamDate:
+		v.prefix, raw = splitPrefix(raw)
+		low, high, err := parseDateRange(raw)
+		if err != nil {
+			return v, err
+		}
+		v.low, v.high = low, high
+	case ParamNumber, ParamQuantity:
+		v.prefix, raw = splitPrefix(raw)
+		parts := splitEscaped(raw, '|')
+		number, delta, err := parseNumber(parts[0])
+		if err != nil {
+			return v, err
+		}
+		v.number, v.delta = number, delta
+		if paramType == ParamQuantity && len(parts) == 3 {
+			v.system, v.code = unescape(parts[1]), unescape(parts[2])
+		} else if len(parts) != 1 {
+			return v, fmt.Errorf("expected number|system|code")
+		}
+	}
+	return v, nil
+}
+
+func splitPrefix(raw string) (string, string) {
+	for _, p := range prefixes {
+		if strings.HasPrefix(raw, p) {
+			return p, raw[len(p):]
+		}
+	}
+	return "eq", raw
+}
+
+// parseNumber returns the value and half of the precision it was given in,
+// so "100" matches [99.5, 100.5)
+func parseNumber(s string) (float64, float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number %q", s)
+	}
+	mantissa, exponent := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa = s[:i]
+		exponent, _ = strconv.Atoi(s[i+1:])
+	}
+	decimals := 0
+	if _, frac, ok := strings.Cut(mantissa, "."); ok {
+		decimals = len(frac)
+	}
+	return n, 0.5 * math.Pow10(exponent-decimals), nil
+}
+
+// dateLayouts are the FHIR date, dateTime and instant forms, most precise
+// last
+var dateLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01-02T15:04Z07:00", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04:05Z07:00", nil},
+	{"2006-01-02T15:04:05", nil},
+}
+
+// parseDateRange returns the half-open range [low, high) a date covers at
+// the precision it was written in. Times without a zone are taken as UTC.
+func parseDateRange(s string) (time.Time, time.Time, error) {
+	for _, l := range dateLayouts {
+		t, err := time.Parse(l.layout, s)
+		if err != nil {
+			continue
+		}
+		if l.next != nil {
+			return t, l.next(t), nil
+		}
+		precision := time.Second
+		if _, frac, ok := strings.Cut(s, "."); ok {
+			digits := len(frac) - len(strings.TrimLeft(frac, "0123456789"))
+			precision = time.Duration(math.Pow10(9 - digits))
+		}
+		return t, t.Add(precision), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// splitEscaped splits s on sep, ignoring separators escaped with a
+// backslash; escapes are kept in the parts
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape removes the backslash from \, \| \$ and \\
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`,|$\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveChains returns a copy of q in which every chained criterion is
+// replaced by a reference criterion listing the resources the chain
+// matched
+func resolveChains(ctx context.Context, s Store, q *Query) (*Query, error) {
+	resolved := *q
+	resolved.Criteria = make([]*Criterion, 0, len(q.Criteria))
+
+	for _, c := range q.Criteria {
+		if len(c.Chain) == 0 {
+			resolved.Criteria = append(resolved.Criteria, c)
+			continue
+		}
+		refs := &Criterion{Param: c.Param}
+		for _, chain := range c.Chain {
+			result, err := s.Search(ctx, chain)
+			if err != nil {
+				return nil, err
+			}
+			for _, res := range result.Matches {
+				ref := res.ResourceType + "/" + res.ID
+				refs.Values = append(refs.Values, ref)
+				refs.parsed = append(refs.parsed, searchValue{raw: ref})
+			}
+		}
+		resolved.Criteria = append(resolved.Criteria, refs)
+	}
+	return &resolved, nil
+}
+
+// execute filters candidates against q, sorts and pages them and resolves
+// includes; both stores finish their searches here
+func execute(ctx context.Context, s Store, q *Query, candidates []*Resource) (*SearchResult, error) {
+	var matches []*Resource
+	docs := make(map[*Resource]map[string]interface{}, len(candidates))
+	for _, res := range candidates {
+		doc, err := decodeDocument(res)
+		if err != nil {
+			return nil, err
+		}
+		if (q.After == nil || q.After.before(res)) && q.matches(doc) {
+			matches = append(matches, res)
+			docs[res] = doc
+		}
+	}
+	sortMatches(matches, docs, q.Sort)
+
+	result := &SearchResult{Total: len(matches)}
+	start := q.Offset
+	if start > len(matches) {
+		start = len(matches)
+	}
+	end := len(matches)
+	if q.Count >= 0 && start+q.Count < end {
+		end = start + q.Count
+	}
+	result.Matches = matches[start:end]
+
+	included, err := resolveIncludes(ctx, s, q, result.Matches, docs)
+	if err != nil {
+		return nil, err
+	}
+	result.Included = included
+	return result, nil
+}
+
+// Includes returns the resources q's _include and _revinclude directives
+// pull in for a page of its matches, for callers that page the matches
+// themselves
+func Includes(ctx context.Context, s Store, q *Query, matches []*Resource) ([]*Resource, error) {
+	docs := make(map[*Resource]map[string]interface{}, len(matches))
+	for _, res := range matches {
+		doc, err := decodeDocument(res)
+		if err != nil {
+			return nil, err
+		}
+		docs[res] = doc
+	}
+	return resolveIncludes(ctx, s, q, matches, docs)
+}
+
+// resolveIncludes reads the resources a page of matches references through
+// _include and the resources referencing them through _revinclude
+func resolveIncludes(ctx context.Context, s Store, q *Query, matches []*Resource, docs map[*Resource]map[string]interface{}) ([]*Resource, error) {
+	if len(matches) == 0 || (len(q.Include) == 0 && len(q.RevInclude) == 0) {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, res := range matches {
+		seen[res.ResourceType+"/"+res.ID] = true
+	}
+	var included []*Resource
+	add := func(res *Resource) {
+		key := res.ResourceType + "/" + res.ID
+		if !seen[key] {
+			seen[key] = true
+			included = append(included, res)
+		}
+	}
+
+	for _, inc := range q.Include {
+		for _, res := range matches {
+			for _, elem := range collect(docs[res], inc.Param.Paths) {
+				resourceType, id := referenceTarget(elem)
+				if id == "" || (inc.TargetType != "" && resourceType != inc.TargetType) || seen[resourceType+"/"+id] {
+					continue
+				}
+				target, err := s.Read(ctx, resourceType, id)
+				if errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeleted) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				add(target)
+			}
+		}
+	}
+
+	for _, inc := range q.RevInclude {
+		if inc.TargetType != "" && inc.TargetType != q.ResourceType {
+			continue
+		}
+		refs := &Criterion{Param: inc.Param}
+		for _, res := range matches {
+			ref := res.ResourceType + "/" + res.ID
+			refs.Values = append(refs.Values, ref)
+			refs.parsed = append(refs.parsed, searchValue{raw: ref})
+		}
+		result, err := s.Search(ctx, &Query{ResourceType: inc.SourceType, Criteria: []*Criterion{refs}, Count: -1})
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range result.Matches {
+			add(res)
+		}
+	}
+	return included, nil
+}
@@ -0,0 +1,254 @@
+package fhirstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var searchFixtures = []struct {
+	resourceType, id, data string
+}{
+	{"Organization", "org1", `{"resourceType":"Organization","name":"General Hospital"}`},
+	{"Patient", "p1", `{"resourceType":"Patient","identifier":[{"system":"HOSP","value":"MRN1"}],"active":true,
+		"name":[{"family":"Smith","given":["John"]}],"gender":"male","birthDate":"1980-05-12",
+		"address":[{"city":"Boston"}],"managingOrganization":{"reference":"Organization/org1"}}`},
+	{"Patient", "p2", `{"resourceType":"Patient","identifier":[{"system":"HOSP","value":"MRN2"}],"active":false,
+		"name":[{"family":"Smithers","given":["Jane"]}],"gender":"female","birthDate":"1992-11-30"}`},
+	{"Patient", "p3", `{"resourceType":"Patient","identifier":[{"system":"OTHER","value":"MRN3"}],
+		"name":[{"family":"Jones"}],"gender":"female","birthDate":"1992"}`},
+	{"Encounter", "e1", `{"resourceType":"Encounter","status":"finished","class":{"system":"http://terminology.hl7.org/CodeSystem/v3-ActCode","code":"AMB"},
+		"subject":{"reference":"Patient/p1"},"period":{"start":"2026-03-01T09:00:00Z","end":"2026-03-01T11:00:00Z"}}`},
+	{"Observation", "o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"},"encounter":{"reference":"Encounter/e1"},
+		"code":{"coding":[{"system":"http://loinc.org","code":"8867-4"}],"text":"Heart rate"},
+		"effectiveDateTime":"2026-03-01T10:00:00Z","valueQuantity":{"value":72,"unit":"/min"}}`},
+	{"Observation", "o2", `{"resourceType":"Observation","status":"final","subject":{"reference":"http://example.org/fhir/Patient/p2"},
+		"code":{"coding":[{"system":"http://loinc.org","code":"8867-4"}],"text":"Heart rate"},
+		"effectiveDateTime":"2026-03-02T10:00:00Z","valueQuantity":{"value":110,"unit":"/min"}}`},
+	{"Observation", "o3", `{"resourceType":"Observation","status":"preliminary","subject":{"reference":"Patient/p1"},
+		"code":{"coding":[{"system":"http://loinc.org","code":"2339-0"}],"text":"Glucose"},
+		"effectivePeriod":{"start":"2026-02-01","end":"2026-02-03"},
+		"valueQuantity":{"value":5.4,"unit":"mmol/L","system":"http://unitsofmeasure.org","code":"mmol/L"}}`},
+}
+
+// testStoreSearch runs the search conformance cases against a store
+func testStoreSearch(t *testing.T, store Store) {
+	ctx := context.Background()
+	for _, f := range searchFixtures {
+		if _, _, err := store.Update(ctx, f.resourceType, f.id, json.RawMessage(f.data)); err != nil {
+			t.Fatalf("failed to store %s/%s: %v", f.resourceType, f.id, err)
+		}
+	}
+
+	tests := []struct {
+		resourceType string
+		query        string
+		want         []string
+		ordered      bool
+	}{
+		{"Patient", "name=smi", []string{"p1", "p2"}, false},
+		{"Patient", "family:exact=Smith", []string{"p1"}, false},
+		{"Patient", "name:contains=ither", []string{"p2"}, false},
+		{"Patient", "name=jones,smithers", []string{"p2", "p3"}, false},
+		{"Patient", "identifier=HOSP|MRN2", []string{"p2"}, false},
+		{"Patient", "identifier=MRN3", []string{"p3"}, false},
+		{"Patient", "identifier=HOSP|", []string{"p1", "p2"}, false},
+		{"Patient", "gender=female&birthdate=1992", []string{"p2", "p3"}, false},
+		{"Patient", "birthdate=ge1990-01-01", []string{"p2", "p3"}, false},
+		{"Patient", "birthdate=lt1990", []string{"p1"}, false},
+		{"Patient", "active=false", []string{"p2"}, false},
+		{"Patient", "gender:not=female", []string{"p1"}, false},
+		{"Patient", "address-city:missing=true", []string{"p2", "p3"}, false},
+		{"Patient", "_id=p1,p3", []string{"p1", "p3"}, false},
+		{"Patient", "unknown-param=x", []string{"p1", "p2", "p3"}, false},
+		{"Patient", "_sort=-birthdate", []string{"p2", "p3", "p1"}, true},
+		{"Patient", "_sort=family", []string{"p3", "p1", "p2"}, true},
+		{"Observation", "code=http://loinc.org|8867-4", []string{"o1", "o2"}, false},
+		{"Observation", "code:text=heart", []string{"o1", "o2"}, false},
+		{"Observation", "subject=Patient/p1", []string{"o1", "o3"}, false},
+		{"Observation", "patient=p2", []string{"o2"}, false},
+		{"Observation", "subject:Patient=p1&status=preliminary", []string{"o3"}, false},
+		{"Observation", "date=2026-03", []string{"o1", "o2"}, false},
+		{"Observation", "date=2026-02-02", nil, false},
+		{"Observation", "date=ap2026-02-02", []string{"o3"}, false},
+		{"Observation", "date=lt2026-02-15", []string{"o3"}, false},
+		{"Observation", "value-quantity=gt100", []string{"o2"}, false},
+		{"Observation", "value-quantity=72", []string{"o1"}, false},
+		{"Observation", "value-quantity=5.4|http://unitsofmeasure.org|mmol/L", []string{"o3"}, false},
+		{"Observation", "subject.name=smithers", []string{"o2"}, false},
+		{"Observation", "subject:Patient.organization.name=general", []string{"o1", "o3"}, false},
+		{"Observation", "subject.name=nobody", nil, false},
+		{"Encounter", "class=AMB", []string{"e1"}, false},
+		{"Encounter", "date=2026-03-01", []string{"e1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resourceType+"?"+tt.query, func(t *testing.T) {
+			result := search(t, store, tt.resourceType, tt.query)
+			got := resultIDs(result.Matches)
+			if !tt.ordered {
+				sort.Strings(got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if result.Total != len(tt.want) {
+				t.Errorf("expected total %d, got %d", len(tt.want), result.Total)
+			}
+		})
+	}
+
+	t.Run("includes", func(t *testing.T) {
+		result := search(t, store, "Observation", "_id=o1&_include=Observation:subject&_include=Observation:encounter")
+		if got := resultIDs(result.Included); !reflect.DeepEqual(got, []string{"p1", "e1"}) {
+			t.Errorf("expected p1 and e1 to be included, got %v", got)
+		}
+
+		result = search(t, store, "Patient", "_id=p1&_revinclude=Observation:subject")
+		got := resultIDs(result.Included)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"o1", "o3"}) {
+			t.Errorf("expected o1 and o3 to be reverse included, got %v", got)
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		result := search(t, store, "Patient", "_sort=family&_count=2&_offset=1")
+		if got := resultIDs(result.Matches); result.Total != 3 || !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+			t.Errorf("expected page [p1 p2] of 3, got %v of %d", got, result.Total)
+		}
+		result = search(t, store, "Patient", "_summary=count")
+		if result.Total != 3 || len(result.Matches) != 0 {
+			t.Errorf("_summary=count should only return the total, got %d matches of %d", len(result.Matches), result.Total)
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		q, err := ParseQuery("Patient", url.Values{"_count": {"2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for page := 0; page < 3; page++ {
+			result, err := store.Search(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, resultIDs(result.Matches)...)
+			if len(result.Matches) < q.Count {
+				break
+			}
+			last := result.Matches[len(result.Matches)-1]
+			q.After = &Cursor{LastUpdated: last.LastUpdated, ID: last.ID}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"p1", "p2", "p3"}) {
+			t.Errorf("expected a cursor scan to return every patient once, got %v", got)
+		}
+	})
+
+	t.Run("deleted resources", func(t *testing.T) {
+		if err := store.Delete(ctx, "Patient", "p3"); err != nil {
+			t.Fatal(err)
+		}
+		if got := resultIDs(search(t, store, "Patient", "gender=female").Matches); !reflect.DeepEqual(got, []string{"p2"}) {
+			t.Errorf("deleted resources should not match, got %v", got)
+		}
+	})
+}
+
+func TestMemoryStore_Search(t *testing.T) {
+	testStoreSearch(t, NewMemoryStore())
+}
+
+func TestParseQuery(t *testing.T) {
+	invalid := []string{
+		"birthdate=notadate",
+		"name:not=x",
+		"gender:contains=f",
+		"_count=-1",
+		"_offset=x",
+		"active:missing=maybe",
+		"_sort=nope",
+		"_include=Patient:name",
+		"_include=Observation:subject",
+		"general-practitioner.nope=x",
+		"organization:Patient.name=x",
+	}
+	for _, raw := range invalid {
+		values, _ := url.ParseQuery(raw)
+		if _, err := ParseQuery("Patient", values); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("ParseQuery(%q): expected ErrInvalidSearch, got %v", raw, err)
+		}
+	}
+
+	values, _ := url.ParseQuery("foo=bar&_format=json&name=a\\,b,c")
+	q, err := ParseQuery("Patient", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q.Unknown, []string{"foo"}) {
+		t.Errorf("expected foo to be reported as unknown, got %v", q.Unknown)
+	}
+	if q.Count != -1 {
+		t.Errorf("an absent _count should return every match, got %d", q.Count)
+	}
+	if len(q.Criteria) != 1 || len(q.Criteria[0].parsed) != 2 || q.Criteria[0].parsed[0].raw != "a,b" {
+		t.Errorf("escaped commas should not split values: %+v", q.Criteria)
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		in        string
+		low, high string
+	}{
+		{"2026", "2026-01-01T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"2026-02", "2026-02-01T00:00:00Z", "2026-03-01T00:00:00Z"},
+		{"2026-02-28", "2026-02-28T00:00:00Z", "2026-03-01T00:00:00Z"},
+		{"2026-02-28T10:30+02:00", "2026-02-28T08:30:00Z", "2026-02-28T08:31:00Z"},
+		{"2026-02-28T10:30:15.250Z", "2026-02-28T10:30:15.25Z", "2026-02-28T10:30:15.251Z"},
+	}
+	for _, tt := range tests {
+		low, high, err := parseDateRange(tt.in)
+		if err != nil {
+			t.Errorf("parseDateRange(%q): %v", tt.in, err)
+			continue
+		}
+		if got := low.UTC().Format("2006-01-02T15:04:05.999Z07:00"); got != tt.low {
+			t.Errorf("parseDateRange(%q) low = %s, want %s", tt.in, got, tt.low)
+		}
+		if got := high.UTC().Format("2006-01-02T15:04:05.999Z07:00"); got != tt.high {
+			t.Errorf("parseDateRange(%q) high = %s, want %s", tt.in, got, tt.high)
+		}
+	}
+}
+
+func search(t *testing.T, store Store, resourceType, raw string) *SearchResult {
+	t.Helper()
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParseQuery(resourceType, values)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", raw, err)
+	}
+	result, err := store.Search(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", raw, err)
+	}
+	return result
+}
+
+func resultIDs(resources []*Resource) []string {
+	var ids []string
+	for _, res := range resources {
+		ids = append(ids, res.ID)
+	}
+	return ids
+}
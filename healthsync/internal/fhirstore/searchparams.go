@@ -0,0 +1,204 @@
+package fhirstore
+
+import "sort"
+
+// ParamType is the FHIR type of a search parameter
+type ParamType string
+
+const (
+	ParamString    ParamType = "string"
+	ParamToken     ParamType = "token"
+	ParamReference ParamType = "reference"
+	ParamDate      ParamType = "date"
+	ParamNumber    ParamType = "number"
+	ParamQuantity  ParamType = "quantity"
+)
+
+// SearchParam is a search parameter evaluated over one or more element
+// paths. Paths are dotted element names; arrays are traversed implicitly.
+type SearchParam struct {
+	Name    string
+	Type    ParamType
+	Paths   []string
+	Targets []string // resource types a reference parameter can point to
+}
+
+// commonParams apply to every resource type
+var commonParams = []*SearchParam{
+	{Name: "_id", Type: ParamToken, Paths: []string{"id"}},
+	{Name: "_lastUpdated", Type: ParamDate, Paths: []string{"meta.lastUpdated"}},
+	{Name: "_tag", Type: ParamToken, Paths: []string{"meta.tag"}},
+	{Name: "_profile", Type: ParamToken, Paths: []string{"meta.profile"}},
+}
+
+var (
+	patientTarget     = []string{"Patient"}
+	encounterTarget   = []string{"Encounter"}
+	subjectTargets    = []string{"Patient", "Group", "Device", "Location"}
+	performerTargets  = []string{"Practitioner", "PractitionerRole", "Organization", "Patient", "RelatedPerson"}
+	practitionerOrOrg = []string{"Practitioner", "PractitionerRole", "Organization"}
+)
+
+// nameParams are shared by resources with a HumanName
+func nameParams() []*SearchParam {
+	return []*SearchParam{
+		{Name: "name", Type: ParamString, Paths: []string{"name.text", "name.family", "name.given", "name.prefix", "name.suffix"}},
+		{Name: "family", Type: ParamString, Paths: []string{"name.family"}},
+		{Name: "given", Type: ParamString, Paths: []string{"name.given"}},
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "telecom", Type: ParamToken, Paths: []string{"telecom"}},
+		{Name: "gender", Type: ParamToken, Paths: []string{"gender"}},
+		{Name: "address", Type: ParamString, Paths: []string{"address.text", "address.line", "address.city", "address.district", "address.state", "address.postalCode", "address.country"}},
+		{Name: "address-city", Type: ParamString, Paths: []string{"address.city"}},
+		{Name: "address-state", Type: ParamString, Paths: []string{"address.state"}},
+		{Name: "address-postalcode", Type: ParamString, Paths: []string{"address.postalCode"}},
+		{Name: "active", Type: ParamToken, Paths: []string{"active"}},
+	}
+}
+
+// clinicalParams are shared by resources recorded against a patient
+func clinicalParams(subjectPath string) []*SearchParam {
+	return []*SearchParam{
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "subject", Type: ParamReference, Paths: []string{subjectPath}, Targets: subjectTargets},
+		{Name: "patient", Type: ParamReference, Paths: []string{subjectPath}, Targets: patientTarget},
+		{Name: "encounter", Type: ParamReference, Paths: []string{"encounter"}, Targets: encounterTarget},
+		{Name: "status", Type: ParamToken, Paths: []string{"status"}},
+		{Name: "code", Type: ParamToken, Paths: []string{"code"}},
+		{Name: "category", Type: ParamToken, Paths: []string{"category"}},
+	}
+}
+
+// searchParams holds the parameters supported for each resource type in
+// addition to commonParams
+var searchParams = map[string][]*SearchParam{
+	"Patient": append(nameParams(),
+		&SearchParam{Name: "birthdate", Type: ParamDate, Paths: []string{"birthDate"}},
+		&SearchParam{Name: "death-date", Type: ParamDate, Paths: []string{"deceasedDateTime"}},
+		&SearchParam{Name: "phone", Type: ParamToken, Paths: []string{"telecom"}},
+		&SearchParam{Name: "email", Type: ParamToken, Paths: []string{"telecom"}},
+		&SearchParam{Name: "general-practitioner", Type: ParamReference, Paths: []string{"generalPractitioner"}, Targets: practitionerOrOrg},
+		&SearchParam{Name: "organization", Type: ParamReference, Paths: []string{"managingOrganization"}, Targets: []string{"Organization"}},
+		&SearchParam{Name: "link", Type: ParamReference, Paths: []string{"link.other"}, Targets: []string{"Patient", "RelatedPerson"}},
+	),
+	"Practitioner": nameParams(),
+	"Organization": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "name", Type: ParamString, Paths: []string{"name", "alias"}},
+		{Name: "type", Type: ParamToken, Paths: []string{"type"}},
+		{Name: "active", Type: ParamToken, Paths: []string{"active"}},
+		{Name: "partof", Type: ParamReference, Paths: []string{"partOf"}, Targets: []string{"Organization"}},
+		{Name: "address-city", Type: ParamString, Paths: []string{"address.city"}},
+	},
+	"Observation": append(clinicalParams("subject"),
+		&SearchParam{Name: "date", Type: ParamDate, Paths: []string{"effectiveDateTime", "effectivePeriod", "effectiveInstant"}},
+		&SearchParam{Name: "issued", Type: ParamDate, Paths: []string{"issued"}},
+		&SearchParam{Name: "value-quantity", Type: ParamQuantity, Paths: []string{"valueQuantity", "component.valueQuantity"}},
+		&SearchParam{Name: "value-string", Type: ParamString, Paths: []string{"valueString"}},
+		&SearchParam{Name: "value-concept", Type: ParamToken, Paths: []string{"valueCodeableConcept"}},
+		&SearchParam{Name: "component-code", Type: ParamToken, Paths: []string{"component.code"}},
+		&SearchParam{Name: "performer", Type: ParamReference, Paths: []string{"performer"}, Targets: performerTargets},
+		&SearchParam{Name: "based-on", Type: ParamReference, Paths: []string{"basedOn"}, Targets: []string{"ServiceRequest", "MedicationRequest", "CarePlan"}},
+		&SearchParam{Name: "device", Type: ParamReference, Paths: []string{"device"}, Targets: []string{"Device", "DeviceMetric"}},
+	),
+	"Encounter": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "status", Type: ParamToken, Paths: []string{"status"}},
+		{Name: "class", Type: ParamToken, Paths: []string{"class"}},
+		{Name: "type", Type: ParamToken, Paths: []string{"type"}},
+		{Name: "subject", Type: ParamReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+		{Name: "patient", Type: ParamReference, Paths: []string{"subject"}, Targets: patientTarget},
+		{Name: "date", Type: ParamDate, Paths: []string{"period"}},
+		{Name: "participant", Type: ParamReference, Paths: []string{"participant.individual"}, Targets: []string{"Practitioner", "PractitionerRole", "RelatedPerson"}},
+		{Name: "location", Type: ParamReference, Paths: []string{"location.location"}, Targets: []string{"Location"}},
+		{Name: "service-provider", Type: ParamReference, Paths: []string{"serviceProvider"}, Targets: []string{"Organization"}},
+		{Name: "reason-code", Type: ParamToken, Paths: []string{"reasonCode"}},
+		{Name: "diagnosis", Type: ParamReference, Paths: []string{"diagnosis.condition"}, Targets: []string{"Condition", "Procedure"}},
+	},
+	"Condition": append(clinicalParams("subject"),
+		&SearchParam{Name: "clinical-status", Type: ParamToken, Paths: []string{"clinicalStatus"}},
+		&SearchParam{Name: "verification-status", Type: ParamToken, Paths: []string{"verificationStatus"}},
+		&SearchParam{Name: "severity", Type: ParamToken, Paths: []string{"severity"}},
+		&SearchParam{Name: "onset-date", Type: ParamDate, Paths: []string{"onsetDateTime", "onsetPeriod"}},
+		&SearchParam{Name: "recorded-date", Type: ParamDate, Paths: []string{"recordedDate"}},
+	),
+	"AllergyIntolerance": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "patient", Type: ParamReference, Paths: []string{"patient"}, Targets: patientTarget},
+		{Name: "code", Type: ParamToken, Paths: []string{"code", "reaction.substance"}},
+		{Name: "clinical-status", Type: ParamToken, Paths: []string{"clinicalStatus"}},
+		{Name: "verification-status", Type: ParamToken, Paths: []string{"verificationStatus"}},
+		{Name: "criticality", Type: ParamToken, Paths: []string{"criticality"}},
+		{Name: "category", Type: ParamToken, Paths: []string{"category"}},
+		{Name: "date", Type: ParamDate, Paths: []string{"recordedDate"}},
+	},
+	"Immunization": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "patient", Type: ParamReference, Paths: []string{"patient"}, Targets: patientTarget},
+		{Name: "vaccine-code", Type: ParamToken, Paths: []string{"vaccineCode"}},
+		{Name: "status", Type: ParamToken, Paths: []string{"status"}},
+		{Name: "date", Type: ParamDate, Paths: []string{"occurrenceDateTime"}},
+		{Name: "lot-number", Type: ParamString, Paths: []string{"lotNumber"}},
+		{Name: "performer", Type: ParamReference, Paths: []string{"performer.actor"}, Targets: practitionerOrOrg},
+	},
+	"DiagnosticReport": append(clinicalParams("subject"),
+		&SearchParam{Name: "date", Type: ParamDate, Paths: []string{"effectiveDateTime", "effectivePeriod"}},
+		&SearchParam{Name: "issued", Type: ParamDate, Paths: []string{"issued"}},
+		&SearchParam{Name: "result", Type: ParamReference, Paths: []string{"result"}, Targets: []string{"Observation"}},
+		&SearchParam{Name: "based-on", Type: ParamReference, Paths: []string{"basedOn"}, Targets: []string{"ServiceRequest", "MedicationRequest", "CarePlan"}},
+		&SearchParam{Name: "performer", Type: ParamReference, Paths: []string{"performer"}, Targets: performerTargets},
+	),
+	"ServiceRequest": append(clinicalParams("subject"),
+		&SearchParam{Name: "intent", Type: ParamToken, Paths: []string{"intent"}},
+		&SearchParam{Name: "priority", Type: ParamToken, Paths: []string{"priority"}},
+		&SearchParam{Name: "authored", Type: ParamDate, Paths: []string{"authoredOn"}},
+		&SearchParam{Name: "requester", Type: ParamReference, Paths: []string{"requester"}, Targets: performerTargets},
+		&SearchParam{Name: "performer", Type: ParamReference, Paths: []string{"performer"}, Targets: performerTargets},
+	),
+	"MedicationRequest": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "subject", Type: ParamReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+		{Name: "patient", Type: ParamReference, Paths: []string{"subject"}, Targets: patientTarget},
+		{Name: "encounter", Type: ParamReference, Paths: []string{"encounter"}, Targets: encounterTarget},
+		{Name: "status", Type: ParamToken, Paths: []string{"status"}},
+		{Name: "intent", Type: ParamToken, Paths: []string{"intent"}},
+		{Name: "code", Type: ParamToken, Paths: []string{"medicationCodeableConcept"}},
+		{Name: "medication", Type: ParamReference, Paths: []string{"medicationReference"}, Targets: []string{"Medication"}},
+		{Name: "authoredon", Type: ParamDate, Paths: []string{"authoredOn"}},
+		{Name: "requester", Type: ParamReference, Paths: []string{"requester"}, Targets: performerTargets},
+	},
+	"Medication": {
+		{Name: "identifier", Type: ParamToken, Paths: []string{"identifier"}},
+		{Name: "code", Type: ParamToken, Paths: []string{"code"}},
+		{Name: "status", Type: ParamToken, Paths: []string{"status"}},
+		{Name: "form", Type: ParamToken, Paths: []string{"form"}},
+	},
+	"Procedure": append(clinicalParams("subject"),
+		&SearchParam{Name: "date", Type: ParamDate, Paths: []string{"performedDateTime", "performedPeriod"}},
+		&SearchParam{Name: "performer", Type: ParamReference, Paths: []string{"performer.actor"}, Targets: performerTargets},
+	),
+}
+
+// LookupSearchParam returns the named search parameter of a resource type
+func LookupSearchParam(resourceType, name string) (*SearchParam, bool) {
+	for _, p := range searchParams[resourceType] {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	for _, p := range commonParams {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// SearchParams returns every search parameter supported for a resource
+// type, sorted by name
+func SearchParams(resourceType string) []*SearchParam {
+	params := append([]*SearchParam(nil), commonParams...)
+	params = append(params, searchParams[resourceType]...)
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params
+}
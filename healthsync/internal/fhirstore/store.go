@@ -0,0 +1,205 @@
+package fhirstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a resource does not exist
+	ErrNotFound = errors.New("resource not found")
+	// ErrDeleted is returned when a resource existed but has been deleted
+	ErrDeleted = errors.New("resource deleted")
+	// ErrVersionConflict is returned when a write expects a version that
+	// is not the current version of the resource
+	ErrVersionConflict = errors.New("resource version conflict")
+)
+
+// Resource is a stored FHIR resource with its version metadata. Data holds
+// the resource JSON with id and meta.versionId/lastUpdated filled in.
+type Resource struct {
+	ResourceType string          `json:"resourceType"`
+	ID           string          `json:"id"`
+	VersionID    string          `json:"versionId"`
+	LastUpdated  time.Time       `json:"lastUpdated"`
+	Deleted      bool            `json:"deleted,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+}
+
+// Unmarshal decodes the resource data into v
+func (r *Resource) Unmarshal(v interface{}) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// PatientID returns the id of the patient whose compartment the resource
+// is in: the patient itself, or the one its subject or patient references
+func (r *Resource) PatientID() string {
+	if r.ResourceType == "Patient" {
+		return r.ID
+	}
+	var refs struct {
+		Subject reference `json:"subject"`
+		Patient reference `json:"patient"`
+	}
+	if err := r.Unmarshal(&refs); err != nil {
+		return ""
+	}
+	for _, ref := range []reference{refs.Subject, refs.Patient} {
+		if strings.HasPrefix(ref.Reference, "Patient/") {
+			return strings.TrimPrefix(ref.Reference, "Patient/")
+		}
+	}
+	return ""
+}
+
+type reference struct {
+	Reference string `json:"reference"`
+}
+
+// Write is one change in a Transact call
+type Write struct {
+	ResourceType string
+	ID           string
+	Data         json.RawMessage // nil deletes the resource
+	IfMatch      string          // version the resource must currently be at; empty for any
+}
+
+// WriteResult is the outcome of a Write. Deleting a resource that is
+// already deleted returns its current deleted version.
+type WriteResult struct {
+	Resource *Resource
+	Created  bool
+}
+
+// Store persists FHIR resources by type and logical id
+type Store interface {
+	// Create stores a new resource, assigning an id when it has none
+	Create(ctx context.Context, resourceType string, data json.RawMessage) (*Resource, error)
+	// Read returns the current version of a resource
+	Read(ctx context.Context, resourceType, id string) (*Resource, error)
+	// VRead returns a specific version of a resource; a version recording
+	// a deletion returns ErrDeleted
+	VRead(ctx context.Context, resourceType, id, versionID string) (*Resource, error)
+	// History returns every version of a resource, newest first, including
+	// deletions
+	History(ctx context.Context, resourceType, id string) ([]*Resource, error)
+	// Update stores a new version of a resource, creating it if it does
+	// not exist; created reports which happened
+	Update(ctx context.Context, resourceType, id string, data json.RawMessage) (res *Resource, created bool, err error)
+	// Delete marks a resource as deleted
+	Delete(ctx context.Context, resourceType, id string) error
+	// FindByIdentifier returns the current resource carrying the given
+	// identifier; an empty system matches any system
+	FindByIdentifier(ctx context.Context, resourceType, system, value string) (*Resource, error)
+	// List returns the current, non-deleted resources of a type
+	List(ctx context.Context, resourceType string) ([]*Resource, error)
+	// Count returns the number of current, non-deleted resources of a type
+	Count(ctx context.Context, resourceType string) (int, error)
+	// Search returns a page of the current resources matching q together
+	// with the resources it includes
+	Search(ctx context.Context, q *Query) (*SearchResult, error)
+	// Transact applies every write or none of them. Each resource may be
+	// written once; a write whose IfMatch is not the current version fails
+	// with ErrVersionConflict.
+	Transact(ctx context.Context, writes []Write) ([]*WriteResult, error)
+}
+
+// Save stores a typed resource such as *models.Patient, creating it when it
+// has no id and updating it otherwise. The assigned id and meta are copied
+// back into resource.
+func Save(ctx context.Context, s Store, resource interface{}) (*Resource, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resource: %w", err)
+	}
+
+	var head resourceHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("failed to decode resource: %w", err)
+	}
+	if head.ResourceType == "" {
+		return nil, fmt.Errorf("resource has no resourceType")
+	}
+
+	var res *Resource
+	if head.ID == "" {
+		res, err = s.Create(ctx, head.ResourceType, data)
+	} else {
+		res, _, err = s.Update(ctx, head.ResourceType, head.ID, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := res.Unmarshal(resource); err != nil {
+		return nil, fmt.Errorf("failed to decode stored resource: %w", err)
+	}
+	return res, nil
+}
+
+// checkWrites rejects writes without an id and resources written twice
+func checkWrites(writes []Write) error {
+	seen := make(map[string]bool, len(writes))
+	for _, w := range writes {
+		if w.ResourceType == "" || w.ID == "" {
+			return fmt.Errorf("write has no resource type or id")
+		}
+		key := w.ResourceType + "/" + w.ID
+		if seen[key] {
+			return fmt.Errorf("%s is written more than once", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// resourceHead holds the fields the store needs from any resource
+type resourceHead struct {
+	ResourceType string       `json:"resourceType"`
+	ID           string       `json:"id"`
+	Identifier   []identifier `json:"identifier"`
+}
+
+type identifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// stamp returns data with id and meta.versionId/lastUpdated set
+func stamp(data json.RawMessage, resourceType, id, versionID string, lastUpdated time.Time) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid resource JSON: %w", err)
+	}
+	if rt, ok := fields["resourceType"]; ok {
+		var got string
+		if err := json.Unmarshal(rt, &got); err != nil || got != resourceType {
+			return nil, fmt.Errorf("resourceType %s does not match %s", rt, resourceType)
+		}
+	}
+
+	meta := make(map[string]interface{})
+	if raw, ok := fields["meta"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("invalid resource meta: %w", err)
+		}
+	}
+	meta["versionId"] = versionID
+	meta["lastUpdated"] = lastUpdated.UTC().Format(time.RFC3339Nano)
+
+	var err error
+	if fields["resourceType"], err = json.Marshal(resourceType); err != nil {
+		return nil, err
+	}
+	if fields["id"], err = json.Marshal(id); err != nil {
+		return nil, err
+	}
+	if fields["meta"], err = json.Marshal(meta); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
@@ -3,9 +3,11 @@ package hl7v2
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -399,6 +401,11 @@ func (c *Client) CreateACK(originalMsg *Message, ackCode string, errorMessage st
 	now := time.Now()
 	msgID := c.NextMessageID()
 
+	messageType := string(MessageTypeACK)
+	if originalMsg.TriggerEvent != "" {
+		messageType = fmt.Sprintf("ACK%s%s%sACK", DefaultComponentSeparator, originalMsg.TriggerEvent, DefaultComponentSeparator)
+	}
+
 	msh := &MSH{
 		FieldSeparator:       DefaultFieldSeparator,
 		EncodingCharacters:   "^~\\&",
@@ -407,7 +414,7 @@ func (c *Client) CreateACK(originalMsg *Message, ackCode string, errorMessage st
 		ReceivingApplication: originalMsg.SendingApp,
 		ReceivingFacility:    originalMsg.SendingFac,
 		DateTime:             now,
-		MessageType:          "ACK",
+		MessageType:          messageType,
 		MessageControlID:     msgID,
 		ProcessingID:         "P",
 		VersionID:            "2.5.1",
@@ -443,7 +450,7 @@ type MSA struct {
 func (m *MSA) ID() string { return "MSA" }
 
 func (m *MSA) Encode() (string, error) {
-	return fmt.Sprintf("MSA|%s|%s|%s", m.AcknowledgmentCode, m.MessageControlID, m.TextMessage), nil
+	return fmt.Sprintf("MSA|%s|%s|%s", m.AcknowledgmentCode, m.MessageControlID, Escape(m.TextMessage)), nil
 }
 
 func (m *MSA) Decode(data string) error {
@@ -461,11 +468,35 @@ type Server struct {
 	running  bool
 	parser   *Parser
 	handler  MessageHandler
+	acker    *Client
+	conns    map[net.Conn]struct{}
 }
 
 // MessageHandler handles incoming HL7 messages
 type MessageHandler func(ctx context.Context, msg *Message) (*Message, error)
 
+type contextKey int
+
+const remoteAddrKey contextKey = iota
+
+// RemoteAddr returns the address of the peer that sent the message being
+// handled, or "" outside a server connection
+func RemoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(remoteAddrKey).(string)
+	return addr
+}
+
+// AckError is returned by a MessageHandler to choose the acknowledgment
+// code for a message it did not accept. Any other error is sent as AE.
+type AckError struct {
+	Code string // AE = application error, AR = application reject
+	Err  error
+}
+
+func (e *AckError) Error() string { return e.Err.Error() }
+
+func (e *AckError) Unwrap() error { return e.Err }
+
 // ServerConfig holds server configuration
 type ServerConfig struct {
 	Host      string
@@ -473,6 +504,7 @@ type ServerConfig struct {
 	UseTLS    bool
 	TLSConfig *tls.Config
 	Handler   MessageHandler
+	Parser    *ParserConfig
 }
 
 // NewServer creates a new HL7 v2.x server
@@ -482,8 +514,10 @@ func NewServer(config *ServerConfig) *Server {
 		port:      config.Port,
 		useTLS:    config.UseTLS,
 		tlsConfig: config.TLSConfig,
-		parser:    NewParser(nil),
+		parser:    NewParser(config.Parser),
 		handler:   config.Handler,
+		acker:     NewClient(&ClientConfig{}),
+		conns:     make(map[net.Conn]struct{}),
 	}
 }
 
@@ -516,7 +550,7 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
-// Stop stops the HL7 server
+// Stop stops the HL7 server and closes open connections
 func (s *Server) Stop() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -526,6 +560,9 @@ func (s *Server) Stop() error {
 	}
 
 	s.running = false
+	for conn := range s.conns {
+		conn.Close()
+	}
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -533,6 +570,16 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
 // acceptConnections accepts incoming connections
 func (s *Server) acceptConnections(ctx context.Context) {
 	for {
@@ -550,13 +597,28 @@ func (s *Server) acceptConnections(ctx context.Context) {
 			continue
 		}
 
+		s.mu.Lock()
+		if !s.running {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
 		go s.handleConnection(ctx, conn)
 	}
 }
 
 // handleConnection handles a client connection
 func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
-	defer conn.Close()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	ctx = context.WithValue(ctx, remoteAddrKey, conn.RemoteAddr().String())
 
 	buf := make([]byte, 65536)
 	var message []byte
@@ -587,7 +649,9 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 				continue
 			}
 
-			if inMessage && b != MLLPCarriageR {
+			// Carriage returns inside the block are segment terminators;
+			// the one trailing the end block falls outside it
+			if inMessage {
 				message = append(message, b)
 			}
 		}
@@ -596,34 +660,57 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 
 // processMessage processes an incoming message
 func (s *Server) processMessage(ctx context.Context, conn net.Conn, data []byte) {
-	msg, err := s.parser.Parse(data)
-	if err != nil {
-		// Send NAK
-		return
-	}
+	// The read buffer is reused for the next message
+	data = append([]byte(nil), data...)
 
 	var response *Message
-	if s.handler != nil {
+	msg, err := s.parser.Parse(data)
+	if err != nil {
+		// Reject with whatever header fields can be recovered
+		response = s.acker.CreateACK(rejectedMessage(data), "AR", err.Error())
+	} else if s.handler != nil {
 		response, err = s.handler(ctx, msg)
 		if err != nil {
-			// Create error ACK
-			client := &Client{}
-			response = client.CreateACK(msg, "AE", err.Error())
+			code := "AE"
+			var ackErr *AckError
+			if errors.As(err, &ackErr) && ackErr.Code != "" {
+				code = ackErr.Code
+			}
+			response = s.acker.CreateACK(msg, code, err.Error())
 		}
 	} else {
 		// Create default ACK
-		client := &Client{}
-		response = client.CreateACK(msg, "AA", "")
+		response = s.acker.CreateACK(msg, "AA", "")
 	}
 
 	if response != nil {
-		client := &Client{}
-		encoded, err := client.encodeMessage(response)
+		encoded, err := s.acker.encodeMessage(response)
 		if err != nil {
 			return
 		}
 
-		frame := client.wrapMLLP(encoded)
+		frame := s.acker.wrapMLLP(encoded)
 		conn.Write(frame)
 	}
 }
+
+// rejectedMessage recovers the sender and control ID from a message that
+// failed to parse so the reject can be correlated
+func rejectedMessage(data []byte) *Message {
+	msg := &Message{}
+	header := strings.SplitN(strings.ReplaceAll(string(data), "\n", "\r"), SegmentTerminator, 2)[0]
+	if len(header) < 4 || !strings.HasPrefix(header, "MSH") {
+		return msg
+	}
+	fields := strings.Split(header, string(header[3]))
+	if len(fields) > 2 {
+		msg.SendingApp = fields[2]
+	}
+	if len(fields) > 3 {
+		msg.SendingFac = fields[3]
+	}
+	if len(fields) > 9 {
+		msg.ControlID = fields[9]
+	}
+	return msg
+}
@@ -18,7 +18,7 @@ func NewConverter() *Converter {
 // HL7ToFHIRPatient converts HL7 PID segment to FHIR Patient
 func (c *Converter) HL7ToFHIRPatient(pid *PID) *models.Patient {
 	patient := &models.Patient{
-		ResourceType: models.ResourceTypePatient,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypePatient},
 	}
 
 	// Set identifiers
@@ -134,7 +134,7 @@ func (c *Converter) HL7ToFHIRPatient(pid *PID) *models.Patient {
 		deceased := true
 		patient.DeceasedBoolean = &deceased
 		if pid.PatientDeathDateTime != nil {
-			patient.DeceasedDateTime = pid.PatientDeathDateTime.Format(time.RFC3339)
+			patient.DeceasedDateTime = pid.PatientDeathDateTime
 		}
 	}
 
@@ -241,7 +241,7 @@ func (c *Converter) FHIRToHL7Patient(patient *models.Patient) *PID {
 // HL7ToFHIREncounter converts HL7 PV1 segment to FHIR Encounter
 func (c *Converter) HL7ToFHIREncounter(pv1 *PV1, patientRef string) *models.Encounter {
 	encounter := &models.Encounter{
-		ResourceType: models.ResourceTypeEncounter,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeEncounter},
 	}
 
 	// Set status based on patient class and discharge
@@ -252,7 +252,7 @@ func (c *Converter) HL7ToFHIREncounter(pv1 *PV1, patientRef string) *models.Enco
 	}
 
 	// Set class
-	encounter.Class = models.Coding{
+	encounter.Class = &models.Coding{
 		System: "http://terminology.hl7.org/CodeSystem/v3-ActCode",
 		Code:   c.mapPatientClass(pv1.PatientClass),
 	}
@@ -265,18 +265,17 @@ func (c *Converter) HL7ToFHIREncounter(pv1 *PV1, patientRef string) *models.Enco
 	// Set period
 	encounter.Period = &models.Period{}
 	if !pv1.AdmitDateTime.IsZero() {
-		start := pv1.AdmitDateTime.Format(time.RFC3339)
-		encounter.Period.Start = start
+		start := pv1.AdmitDateTime
+		encounter.Period.Start = &start
 	}
 	if pv1.DischargeDateTime != nil {
-		end := pv1.DischargeDateTime.Format(time.RFC3339)
-		encounter.Period.End = end
+		encounter.Period.End = pv1.DischargeDateTime
 	}
 
 	// Set location
 	if pv1.AssignedPatientLoc.PointOfCare != "" {
 		encounter.Location = append(encounter.Location, models.EncounterLocation{
-			Location: models.Reference{
+			Location: &models.Reference{
 				Display: fmt.Sprintf("%s-%s-%s",
 					pv1.AssignedPatientLoc.PointOfCare,
 					pv1.AssignedPatientLoc.Room,
@@ -332,7 +331,7 @@ func (c *Converter) HL7ToFHIREncounter(pv1 *PV1, patientRef string) *models.Enco
 // HL7ToFHIRObservation converts HL7 OBX segment to FHIR Observation
 func (c *Converter) HL7ToFHIRObservation(obx *OBX, patientRef, encounterRef string) *models.Observation {
 	obs := &models.Observation{
-		ResourceType: models.ResourceTypeObservation,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeObservation},
 	}
 
 	// Set status
@@ -350,7 +349,7 @@ func (c *Converter) HL7ToFHIRObservation(obx *OBX, patientRef, encounterRef stri
 	}
 
 	// Set code
-	obs.Code = models.CodeableConcept{
+	obs.Code = &models.CodeableConcept{
 		Coding: []models.Coding{
 			{
 				Code:    obx.ObservationIdentifier.Identifier,
@@ -374,7 +373,8 @@ func (c *Converter) HL7ToFHIRObservation(obx *OBX, patientRef, encounterRef stri
 
 	// Set effective date
 	if !obx.DateTimeOfObservation.IsZero() {
-		obs.EffectiveDateTime = obx.DateTimeOfObservation.Format(time.RFC3339)
+		effective := obx.DateTimeOfObservation
+		obs.EffectiveDateTime = &effective
 	}
 
 	// Set value based on type
@@ -422,9 +422,9 @@ func (c *Converter) setObservationValue(obs *models.Observation, valueType, valu
 			},
 		}
 	case "DT": // Date
-		obs.ValueDateTime = value
+		obs.ValueDateTime = c.formatDate(value)
 	case "TM": // Time
-		obs.ValueTime = value
+		obs.ValueTime = c.formatTime(value)
 	default:
 		obs.ValueString = value
 	}
@@ -476,6 +476,7 @@ func (c *Converter) mapCodingSystem(hl7System string) string {
 		"HCPCS": "http://www.cms.gov/Medicare/Coding/HCPCSReleaseCodeSets",
 		"NDC":  "http://hl7.org/fhir/sid/ndc",
 		"RxNorm": "http://www.nlm.nih.gov/research/umls/rxnorm",
+		"CVX":  "http://hl7.org/fhir/sid/cvx",
 	}
 	if mapped, ok := mapping[hl7System]; ok {
 		return mapped
@@ -505,6 +506,30 @@ func (c *Converter) mapAbnormalFlag(flag string) string {
 	return flag
 }
 
+// formatDate converts an HL7 DT (YYYY[MM[DD]]) to a FHIR date
+func (c *Converter) formatDate(value string) string {
+	switch {
+	case len(value) >= 8:
+		return value[:4] + "-" + value[4:6] + "-" + value[6:8]
+	case len(value) >= 6:
+		return value[:4] + "-" + value[4:6]
+	default:
+		return value
+	}
+}
+
+// formatTime converts an HL7 TM (HH[MM[SS]]) to a FHIR time
+func (c *Converter) formatTime(value string) string {
+	if len(value) < 4 {
+		return value
+	}
+	seconds := "00"
+	if len(value) >= 6 {
+		seconds = value[4:6]
+	}
+	return value[:2] + ":" + value[2:4] + ":" + seconds
+}
+
 func (c *Converter) parseFloat(s string) float64 {
 	var f float64
 	fmt.Sscanf(s, "%f", &f)
@@ -514,7 +539,7 @@ func (c *Converter) parseFloat(s string) float64 {
 // HL7ToFHIRDiagnosticReport converts HL7 OBR+OBXs to FHIR DiagnosticReport
 func (c *Converter) HL7ToFHIRDiagnosticReport(obr *OBR, observations []*OBX, patientRef string) *models.DiagnosticReport {
 	report := &models.DiagnosticReport{
-		ResourceType: models.ResourceTypeDiagnosticReport,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeDiagnosticReport},
 	}
 
 	// Set status
@@ -532,7 +557,7 @@ func (c *Converter) HL7ToFHIRDiagnosticReport(obr *OBR, observations []*OBX, pat
 	}
 
 	// Set code
-	report.Code = models.CodeableConcept{
+	report.Code = &models.CodeableConcept{
 		Coding: []models.Coding{
 			{
 				Code:    obr.UniversalServiceID.Identifier,
@@ -549,12 +574,16 @@ func (c *Converter) HL7ToFHIRDiagnosticReport(obr *OBR, observations []*OBX, pat
 
 	// Set effective date
 	if !obr.ObservationDateTime.IsZero() {
-		report.EffectiveDateTime = obr.ObservationDateTime.Format(time.RFC3339)
+		effective := obr.ObservationDateTime
+		report.EffectiveDateTime = &effective
 	}
 
 	// Set issued date
-	if !obr.ObservationDateTime.IsZero() {
-		report.Issued = obr.ObservationDateTime.Format(time.RFC3339)
+	if obr.ResultsRptStatusChg != nil {
+		report.Issued = obr.ResultsRptStatusChg
+	} else if !obr.ObservationDateTime.IsZero() {
+		issued := obr.ObservationDateTime
+		report.Issued = &issued
 	}
 
 	// Set identifiers
@@ -585,7 +614,7 @@ func (c *Converter) HL7ToFHIRDiagnosticReport(obr *OBR, observations []*OBX, pat
 // HL7ToFHIRServiceRequest converts HL7 ORC+OBR to FHIR ServiceRequest
 func (c *Converter) HL7ToFHIRServiceRequest(orc *ORC, obr *OBR, patientRef string) *models.ServiceRequest {
 	sr := &models.ServiceRequest{
-		ResourceType: models.ResourceTypeServiceRequest,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeServiceRequest},
 	}
 
 	// Set status
@@ -603,6 +632,9 @@ func (c *Converter) HL7ToFHIRServiceRequest(orc *ORC, obr *OBR, patientRef strin
 	default:
 		sr.Status = "active"
 	}
+	if orc.OrderControl == "CA" || orc.OrderControl == "OC" {
+		sr.Status = "revoked"
+	}
 
 	// Set intent based on order control
 	switch orc.OrderControl {
@@ -628,7 +660,7 @@ func (c *Converter) HL7ToFHIRServiceRequest(orc *ORC, obr *OBR, patientRef strin
 	}
 
 	// Set subject
-	sr.Subject = models.Reference{
+	sr.Subject = &models.Reference{
 		Reference: patientRef,
 	}
 
@@ -641,7 +673,8 @@ func (c *Converter) HL7ToFHIRServiceRequest(orc *ORC, obr *OBR, patientRef strin
 
 	// Set authored on
 	if !orc.DateTimeOfTransaction.IsZero() {
-		sr.AuthoredOn = orc.DateTimeOfTransaction.Format(time.RFC3339)
+		authored := orc.DateTimeOfTransaction
+		sr.AuthoredOn = &authored
 	}
 
 	// Set priority
@@ -684,7 +717,7 @@ func (c *Converter) HL7ToFHIRServiceRequest(orc *ORC, obr *OBR, patientRef strin
 // HL7ToFHIRCondition converts HL7 DG1 segment to FHIR Condition
 func (c *Converter) HL7ToFHIRCondition(dg1 *DG1, patientRef, encounterRef string) *models.Condition {
 	cond := &models.Condition{
-		ResourceType: models.ResourceTypeCondition,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeCondition},
 	}
 
 	// Set clinical status
@@ -743,7 +776,7 @@ func (c *Converter) HL7ToFHIRCondition(dg1 *DG1, patientRef, encounterRef string
 	}
 
 	// Set subject
-	cond.Subject = models.Reference{
+	cond.Subject = &models.Reference{
 		Reference: patientRef,
 	}
 
@@ -756,7 +789,8 @@ func (c *Converter) HL7ToFHIRCondition(dg1 *DG1, patientRef, encounterRef string
 
 	// Set recorded date
 	if !dg1.DiagnosisDateTime.IsZero() {
-		cond.RecordedDate = dg1.DiagnosisDateTime.Format(time.RFC3339)
+		recorded := dg1.DiagnosisDateTime
+		cond.RecordedDate = &recorded
 	}
 
 	// Set asserter
@@ -772,7 +806,7 @@ func (c *Converter) HL7ToFHIRCondition(dg1 *DG1, patientRef, encounterRef string
 // HL7ToFHIRAllergyIntolerance converts HL7 AL1 segment to FHIR AllergyIntolerance
 func (c *Converter) HL7ToFHIRAllergyIntolerance(al1 *AL1, patientRef string) *models.AllergyIntolerance {
 	allergy := &models.AllergyIntolerance{
-		ResourceType: models.ResourceTypeAllergyIntolerance,
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeAllergyIntolerance},
 	}
 
 	// Set clinical status
@@ -832,13 +866,13 @@ func (c *Converter) HL7ToFHIRAllergyIntolerance(al1 *AL1, patientRef string) *mo
 	}
 
 	// Set patient
-	allergy.Patient = models.Reference{
+	allergy.Patient = &models.Reference{
 		Reference: patientRef,
 	}
 
 	// Set recorded date
 	if al1.IdentificationDate != nil {
-		allergy.RecordedDate = al1.IdentificationDate.Format(time.RFC3339)
+		allergy.RecordedDate = al1.IdentificationDate
 	}
 
 	// Set reaction
@@ -852,3 +886,93 @@ func (c *Converter) HL7ToFHIRAllergyIntolerance(al1 *AL1, patientRef string) *mo
 
 	return allergy
 }
+
+// HL7ToFHIRImmunization converts HL7 RXA segment to FHIR Immunization
+func (c *Converter) HL7ToFHIRImmunization(rxa *RXA, patientRef string) *models.Immunization {
+	imm := &models.Immunization{
+		FHIRResource: models.FHIRResource{ResourceType: models.ResourceTypeImmunization},
+	}
+
+	// Set status from completion status and action code
+	switch rxa.CompletionStatus {
+	case "RE", "NA":
+		imm.Status = "not-done"
+	default:
+		imm.Status = "completed"
+	}
+	if rxa.ActionCode == "D" {
+		imm.Status = "entered-in-error"
+	}
+
+	// Set status reason
+	if imm.Status == "not-done" && rxa.SubstanceRefusalReason != "" {
+		imm.StatusReason = &models.CodeableConcept{
+			Text: rxa.SubstanceRefusalReason,
+		}
+	}
+
+	// Set vaccine code
+	imm.VaccineCode = &models.CodeableConcept{
+		Coding: []models.Coding{
+			{
+				Code:    rxa.AdminCode.Identifier,
+				Display: rxa.AdminCode.Text,
+				System:  c.mapCodingSystem(rxa.AdminCode.NameOfCodingSys),
+			},
+		},
+	}
+
+	// Set patient
+	imm.Patient = &models.Reference{
+		Reference: patientRef,
+	}
+
+	// Set occurrence
+	if !rxa.DateTimeStartOfAdmin.IsZero() {
+		occurrence := rxa.DateTimeStartOfAdmin
+		imm.OccurrenceDateTime = &occurrence
+	}
+	if !rxa.SystemEntryDateTime.IsZero() {
+		recorded := rxa.SystemEntryDateTime
+		imm.Recorded = &recorded
+	}
+
+	// Set dose; 999 means the amount is unknown
+	if rxa.AdminAmount > 0 && rxa.AdminAmount != 999 {
+		imm.DoseQuantity = &models.Quantity{
+			Value: rxa.AdminAmount,
+			Unit:  rxa.AdminUnits.Text,
+			Code:  rxa.AdminUnits.Identifier,
+		}
+	}
+
+	// Set lot and manufacturer
+	imm.LotNumber = rxa.SubstanceLotNumber
+	if rxa.SubstanceExpiration != nil {
+		imm.ExpirationDate = rxa.SubstanceExpiration.Format("2006-01-02")
+	}
+	if rxa.SubstanceManufacturer != "" {
+		imm.Manufacturer = &models.Reference{
+			Display: rxa.SubstanceManufacturer,
+		}
+	}
+
+	// Set performer
+	if rxa.AdminProvider.ID != "" {
+		imm.Performer = append(imm.Performer, models.ImmunizationPerformer{
+			Function: &models.CodeableConcept{
+				Coding: []models.Coding{
+					{
+						System: "http://terminology.hl7.org/CodeSystem/v2-0443",
+						Code:   "AP",
+					},
+				},
+			},
+			Actor: &models.Reference{
+				Display: fmt.Sprintf("%s %s", rxa.AdminProvider.GivenName, rxa.AdminProvider.FamilyName),
+			},
+		})
+	}
+
+	return imm
+}
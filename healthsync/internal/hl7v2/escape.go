@@ -0,0 +1,113 @@
+package hl7v2
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// defaultParser carries the default delimiters for Escape and Unescape
+var defaultParser = NewParser(nil)
+
+// Escape encodes delimiters in a value using the default encoding characters
+func Escape(value string) string {
+	return defaultParser.Escape(value)
+}
+
+// Unescape decodes escape sequences in a value using the default encoding
+// characters
+func Unescape(value string) string {
+	return defaultParser.Unescape(value)
+}
+
+// Escape encodes the message's delimiters in a value (HL7 v2.5.1 section
+// 2.7). Carriage returns and line feeds, which would end the segment, are
+// written as hexadecimal escapes.
+func (p *Parser) Escape(value string) string {
+	esc := p.escapeChr
+	if esc == "" {
+		return value
+	}
+	return strings.NewReplacer(
+		esc, esc+"E"+esc,
+		p.fieldSep, esc+"F"+esc,
+		p.componentSep, esc+"S"+esc,
+		p.subcompSep, esc+"T"+esc,
+		p.repetitionSep, esc+"R"+esc,
+		"\r", esc+"X0D"+esc,
+		"\n", esc+"X0A"+esc,
+	).Replace(value)
+}
+
+// Unescape decodes escape sequences in a single field, component or
+// subcomponent value. Values must be split on delimiters before they are
+// unescaped, since \F\, \S\, \T\ and \R\ decode to the delimiters
+// themselves. Sequences the parser does not understand are kept verbatim.
+func (p *Parser) Unescape(value string) string {
+	esc := p.escapeChr
+	if esc == "" || !strings.Contains(value, esc) {
+		return value
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(value, esc)
+		if start < 0 {
+			b.WriteString(value)
+			break
+		}
+		b.WriteString(value[:start])
+		rest := value[start+len(esc):]
+
+		end := strings.Index(rest, esc)
+		if end < 0 {
+			// An unterminated sequence is literal text
+			b.WriteString(value[start:])
+			break
+		}
+		seq := rest[:end]
+		if decoded, ok := p.decodeEscape(seq); ok {
+			b.WriteString(decoded)
+		} else {
+			b.WriteString(esc + seq + esc)
+		}
+		value = rest[end+len(esc):]
+	}
+	return b.String()
+}
+
+// decodeEscape decodes the text between a pair of escape characters
+func (p *Parser) decodeEscape(seq string) (string, bool) {
+	switch seq {
+	case "F":
+		return p.fieldSep, true
+	case "S":
+		return p.componentSep, true
+	case "T":
+		return p.subcompSep, true
+	case "R":
+		return p.repetitionSep, true
+	case "E":
+		return p.escapeChr, true
+	case "H", "N":
+		// Highlighting has no plain-text representation
+		return "", true
+	case ".br":
+		return "\n", true
+	}
+
+	if len(seq) > 1 && seq[0] == 'X' {
+		decoded, err := hex.DecodeString(seq[1:])
+		if err != nil {
+			return "", false
+		}
+		return string(decoded), true
+	}
+	return "", false
+}
+
+// unescapeAll decodes escape sequences in each of a split value's parts
+func (p *Parser) unescapeAll(parts []string) {
+	for i := range parts {
+		parts[i] = p.Unescape(parts[i])
+	}
+}
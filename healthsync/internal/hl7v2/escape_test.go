@@ -0,0 +1,77 @@
+package hl7v2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`plain`, `plain`},
+		{`A\F\B\S\C\T\D\R\E\E\F`, `A|B^C&D~E\F`},
+		{`line one\X0D0A\line two`, "line one\r\nline two"},
+		{`caf\XC3A9\`, "café"},
+		{`\H\bold\N\ text`, `bold text`},
+		{`first\.br\second`, "first\nsecond"},
+		{`unknown \Zabc\ kept`, `unknown \Zabc\ kept`},
+		{`bad hex \XZZ\`, `bad hex \XZZ\`},
+		{`unterminated \F`, `unterminated \F`},
+	}
+
+	for _, tt := range tests {
+		if got := Unescape(tt.in); got != tt.want {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscape_RoundTrip(t *testing.T) {
+	values := []string{"a|b^c&d~e\\f", "two\r\nlines", "no delimiters"}
+	for _, v := range values {
+		escaped := Escape(v)
+		for _, delim := range []string{"|", "^", "&", "~", "\r", "\n"} {
+			if strings.Contains(escaped, delim) {
+				t.Errorf("Escape(%q) = %q still contains %q", v, escaped, delim)
+			}
+		}
+		if got := Unescape(escaped); got != v {
+			t.Errorf("round trip of %q gave %q", v, got)
+		}
+	}
+}
+
+func TestParser_DecodesEscapesWithMessageDelimiters(t *testing.T) {
+	// This sender uses # as its component separator
+	raw := "MSH|#~\\&|LAB|HOSP|||20260301||ORU#R01|MSG1|P|2.5.1\r" +
+		"PID|1||MRN1###HOSP||O\\S\\Brien#Mary\r" +
+		"OBR|1|PL1\r" +
+		"OBX|1|ST|8251-1#Comment#LN||Result\\F\\pending \\T\\ see note\r" +
+		"OBX|2|CE|8251-1#Comment#LN||POS#Positive\\S\\high#L\r"
+
+	msg, err := NewParser(nil).Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid := msg.Segments[1].(*PID)
+	if pid.PatientName[0].FamilyName != "O#Brien" {
+		t.Errorf("expected \\S\\ to decode to the message's component separator, got %q", pid.PatientName[0].FamilyName)
+	}
+	if obx := msg.Segments[3].(*OBX); obx.ObservationValue[0] != "Result|pending & see note" {
+		t.Errorf("unexpected text value %q", obx.ObservationValue[0])
+	}
+	if obx := msg.Segments[4].(*OBX); obx.ObservationValue[0] != "POS#Positive\\S\\high#L" {
+		t.Errorf("coded values should keep their components for the converter, got %q", obx.ObservationValue[0])
+	}
+}
+
+func TestMSA_EncodeEscapesText(t *testing.T) {
+	msa := &MSA{AcknowledgmentCode: "AE", MessageControlID: "1", TextMessage: "PID|3 missing ^"}
+	encoded, _ := msa.Encode()
+	if encoded != `MSA|AE|1|PID\F\3 missing \S\` {
+		t.Errorf("unexpected MSA %q", encoded)
+	}
+}
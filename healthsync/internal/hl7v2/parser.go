@@ -25,18 +25,22 @@ type Parser struct {
 	escapeChr    string
 	subcompSep   string
 	strictMode   bool
+	segments     *SegmentRegistry
 }
 
 // ParserConfig holds parser configuration
 type ParserConfig struct {
 	StrictMode bool
+	Segments   *SegmentRegistry // decoders for site-specific Z-segments
 }
 
 // NewParser creates a new HL7 v2.x parser
 func NewParser(config *ParserConfig) *Parser {
 	strictMode := false
+	var segments *SegmentRegistry
 	if config != nil {
 		strictMode = config.StrictMode
+		segments = config.Segments
 	}
 	return &Parser{
 		fieldSep:      DefaultFieldSeparator,
@@ -45,11 +49,17 @@ func NewParser(config *ParserConfig) *Parser {
 		escapeChr:     DefaultEscapeCharacter,
 		subcompSep:    DefaultSubcomponentSep,
 		strictMode:    strictMode,
+		segments:      segments,
 	}
 }
 
 // Parse parses an HL7 v2.x message from raw data
 func (p *Parser) Parse(data []byte) (*Message, error) {
+	// Delimiters are per message, so work on a copy to keep a shared
+	// parser safe for concurrent connections
+	local := *p
+	p = &local
+
 	content := string(data)
 
 	// Normalize line endings
@@ -104,6 +114,9 @@ func (p *Parser) Parse(data []byte) (*Message, error) {
 	if len(msgTypeParts) >= 2 {
 		msg.TriggerEvent = TriggerEvent(msgTypeParts[1])
 	}
+	if len(msgTypeParts) >= 3 {
+		msg.Structure = msgTypeParts[2]
+	}
 
 	// Parse remaining segments
 	for i := 1; i < len(segmentStrings); i++ {
@@ -218,8 +231,19 @@ func (p *Parser) parseSegment(data string) (Segment, error) {
 		return p.parseDG1(data)
 	case "AL1":
 		return p.parseAL1(data)
+	case "RXA":
+		return p.parseRXA(data)
+	case "MSA":
+		return p.parseMSA(data)
+	case "MRG":
+		return p.parseMRG(data)
 	default:
-		// Return a generic segment for unknown types
+		seg, ok, err := p.parseRegisteredSegment(segmentID, data)
+		if ok && (err == nil || p.strictMode) {
+			return seg, err
+		}
+		// Return a generic segment for unknown types, keeping Z-segments
+		// that fail to decode when not in strict mode
 		return &GenericSegment{SegmentID: segmentID, RawData: data}, nil
 	}
 }
@@ -287,6 +311,13 @@ func (p *Parser) parsePID(data string) (*PID, error) {
 	if len(fields) > 19 {
 		pid.SSNNumber = fields[19]
 	}
+	if len(fields) > 29 && fields[29] != "" {
+		dt := p.parseDateTime(fields[29])
+		pid.PatientDeathDateTime = &dt
+	}
+	if len(fields) > 30 {
+		pid.PatientDeathIndicator = fields[30]
+	}
 
 	return pid, nil
 }
@@ -401,6 +432,10 @@ func (p *Parser) parseOBX(data string) (*OBX, error) {
 	}
 	if len(fields) > 5 {
 		obx.ObservationValue = p.parseRepetitions(fields[5])
+		if !compositeValueTypes[obx.ValueType] {
+			// Composite values keep their components for the converter
+			p.unescapeAll(obx.ObservationValue)
+		}
 	}
 	if len(fields) > 6 {
 		obx.Units = p.parseCodedElement(fields[6])
@@ -544,7 +579,7 @@ func (p *Parser) parseDG1(data string) (*DG1, error) {
 		dg1.DiagnosisCode = p.parseCodedElement(fields[3])
 	}
 	if len(fields) > 4 {
-		dg1.DiagnosisDescr = fields[4]
+		dg1.DiagnosisDescr = p.Unescape(fields[4])
 	}
 	if len(fields) > 5 {
 		dg1.DiagnosisDateTime = p.parseDateTime(fields[5])
@@ -581,7 +616,7 @@ func (p *Parser) parseAL1(data string) (*AL1, error) {
 		al1.AllergySeverity = fields[4]
 	}
 	if len(fields) > 5 {
-		al1.AllergyReaction = fields[5]
+		al1.AllergyReaction = p.Unescape(fields[5])
 	}
 	if len(fields) > 6 && fields[6] != "" {
 		dt := p.parseDateTime(fields[6])
@@ -591,6 +626,129 @@ func (p *Parser) parseAL1(data string) (*AL1, error) {
 	return al1, nil
 }
 
+// parseRXA parses an RXA segment
+func (p *Parser) parseRXA(data string) (*RXA, error) {
+	fields := strings.Split(data, p.fieldSep)
+
+	rxa := &RXA{}
+
+	if len(fields) > 1 {
+		rxa.GiveSubIDCounter, _ = strconv.Atoi(fields[1])
+	}
+	if len(fields) > 2 {
+		rxa.AdminSubIDCounter, _ = strconv.Atoi(fields[2])
+	}
+	if len(fields) > 3 {
+		rxa.DateTimeStartOfAdmin = p.parseDateTime(fields[3])
+	}
+	if len(fields) > 4 && fields[4] != "" {
+		dt := p.parseDateTime(fields[4])
+		rxa.DateTimeEndOfAdmin = &dt
+	}
+	if len(fields) > 5 {
+		rxa.AdminCode = p.parseCodedElement(fields[5])
+	}
+	if len(fields) > 6 {
+		rxa.AdminAmount, _ = strconv.ParseFloat(fields[6], 64)
+	}
+	if len(fields) > 7 {
+		rxa.AdminUnits = p.parseCodedElement(fields[7])
+	}
+	if len(fields) > 9 {
+		rxa.AdminNotes = fields[9]
+	}
+	if len(fields) > 10 {
+		rxa.AdminProvider = p.parseProvider(fields[10])
+	}
+	if len(fields) > 11 {
+		rxa.AdminLocation = p.parseLocation(fields[11])
+	}
+	if len(fields) > 15 {
+		rxa.SubstanceLotNumber = fields[15]
+	}
+	if len(fields) > 16 && fields[16] != "" {
+		dt := p.parseDateTime(fields[16])
+		rxa.SubstanceExpiration = &dt
+	}
+	if len(fields) > 17 {
+		mfr := p.parseCodedElement(fields[17])
+		rxa.SubstanceManufacturer = mfr.Text
+		if mfr.Text == "" {
+			rxa.SubstanceManufacturer = mfr.Identifier
+		}
+	}
+	if len(fields) > 18 {
+		rxa.SubstanceRefusalReason = fields[18]
+	}
+	if len(fields) > 20 {
+		rxa.CompletionStatus = fields[20]
+	}
+	if len(fields) > 21 {
+		rxa.ActionCode = fields[21]
+	}
+	if len(fields) > 22 {
+		rxa.SystemEntryDateTime = p.parseDateTime(fields[22])
+	}
+
+	return rxa, nil
+}
+
+// parseMRG parses an MRG segment
+func (p *Parser) parseMRG(data string) (*MRG, error) {
+	fields := strings.Split(data, p.fieldSep)
+
+	mrg := &MRG{}
+
+	if len(fields) > 1 {
+		mrg.PriorPatientIdentifierList = p.parsePatientIdentifiers(fields[1])
+	}
+	if len(fields) > 2 {
+		mrg.PriorAlternatePatientID = fields[2]
+	}
+	if len(fields) > 3 {
+		mrg.PriorPatientAccountNumber = fields[3]
+	}
+	if len(fields) > 4 {
+		mrg.PriorPatientID = fields[4]
+	}
+	if len(fields) > 5 {
+		mrg.PriorVisitNumber = fields[5]
+	}
+	if len(fields) > 6 {
+		mrg.PriorAlternateVisitID = fields[6]
+	}
+	if len(fields) > 7 {
+		mrg.PriorPatientName = p.parsePersonNames(fields[7])
+	}
+
+	return mrg, nil
+}
+
+// parseMSA parses an MSA segment
+func (p *Parser) parseMSA(data string) (*MSA, error) {
+	fields := strings.Split(data, p.fieldSep)
+
+	msa := &MSA{}
+
+	if len(fields) > 1 {
+		msa.AcknowledgmentCode = fields[1]
+	}
+	if len(fields) > 2 {
+		msa.MessageControlID = fields[2]
+	}
+	if len(fields) > 3 {
+		msa.TextMessage = p.Unescape(fields[3])
+	}
+	if len(fields) > 4 {
+		msa.ExpectedSequenceNum = fields[4]
+	}
+	if len(fields) > 5 {
+		msa.DelayedAckType = fields[5]
+	}
+
+	return msa, nil
+}
+
 // Helper parsing functions
 
 func (p *Parser) parseDateTime(data string) time.Time {
@@ -627,6 +785,7 @@ func (p *Parser) parsePatientIdentifiers(data string) []PatientIdentifier {
 
 	for _, rep := range reps {
 		comps := strings.Split(rep, p.componentSep)
+		p.unescapeAll(comps)
 		id := PatientIdentifier{}
 
 		if len(comps) > 0 {
@@ -664,6 +823,7 @@ func (p *Parser) parsePersonNames(data string) []PersonName {
 
 	for _, rep := range reps {
 		comps := strings.Split(rep, p.componentSep)
+		p.unescapeAll(comps)
 		name := PersonName{}
 
 		if len(comps) > 0 {
@@ -704,6 +864,7 @@ func (p *Parser) parseAddresses(data string) []Address {
 
 	for _, rep := range reps {
 		comps := strings.Split(rep, p.componentSep)
+		p.unescapeAll(comps)
 		addr := Address{}
 
 		if len(comps) > 0 {
@@ -741,6 +902,7 @@ func (p *Parser) parseLocation(data string) Location {
 	}
 
 	comps := strings.Split(data, p.componentSep)
+	p.unescapeAll(comps)
 
 	if len(comps) > 0 {
 		loc.PointOfCare = comps[0]
@@ -774,6 +936,7 @@ func (p *Parser) parseProvider(data string) Provider {
 	}
 
 	comps := strings.Split(data, p.componentSep)
+	p.unescapeAll(comps)
 
 	if len(comps) > 0 {
 		prov.ID = comps[0]
@@ -831,6 +994,7 @@ func (p *Parser) parseCodedElement(data string) CodedElement {
 	}
 
 	comps := strings.Split(data, p.componentSep)
+	p.unescapeAll(comps)
 
 	if len(comps) > 0 {
 		ce.Identifier = comps[0]
@@ -854,6 +1018,13 @@ func (p *Parser) parseCodedElement(data string) CodedElement {
 	return ce
 }
 
+// compositeValueTypes are OBX-2 value types whose OBX-5 has components
+var compositeValueTypes = map[string]bool{
+	"CE": true, "CF": true, "CNE": true, "CWE": true, "CP": true, "CQ": true,
+	"CX": true, "ED": true, "MO": true, "RP": true, "SN": true, "XAD": true,
+	"XCN": true, "XON": true, "XPN": true, "XTN": true,
+}
+
 func (p *Parser) parseRepetitions(data string) []string {
 	if data == "" {
 		return nil
@@ -901,7 +1072,7 @@ func (m *MSH) Encode() (string, error) {
 		m.CountryCode,
 		m.CharacterSet,
 	}
-	return m.FieldSeparator + strings.Join(fields[1:], m.FieldSeparator), nil
+	return "MSH" + m.FieldSeparator + strings.Join(fields[1:], m.FieldSeparator), nil
 }
 
 func (m *MSH) Decode(data string) error {
@@ -1004,3 +1175,11 @@ func (r *RXA) Encode() (string, error) {
 func (r *RXA) Decode(data string) error {
 	return nil
 }
+
+func (m *MRG) Encode() (string, error) {
+	return "", nil
+}
+
+func (m *MRG) Decode(data string) error {
+	return nil
+}
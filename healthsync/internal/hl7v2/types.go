@@ -22,6 +22,8 @@ const (
 	MessageTypeSIU MessageType = "SIU"
 	// RDE - Pharmacy/Treatment Encoded Order
 	MessageTypeRDE MessageType = "RDE"
+	// VXU - Unsolicited Vaccination Record Update
+	MessageTypeVXU MessageType = "VXU"
 )
 
 // TriggerEvent represents HL7 v2.x trigger events
@@ -40,8 +42,10 @@ const (
 	TriggerA11 TriggerEvent = "A11" // Cancel Admit
 	TriggerA12 TriggerEvent = "A12" // Cancel Transfer
 	TriggerA13 TriggerEvent = "A13" // Cancel Discharge
+	TriggerA24 TriggerEvent = "A24" // Link Patient Information
 	TriggerA28 TriggerEvent = "A28" // Add Person Information
 	TriggerA31 TriggerEvent = "A31" // Update Person Information
+	TriggerA37 TriggerEvent = "A37" // Unlink Patient Information
 	TriggerA40 TriggerEvent = "A40" // Merge Patient
 )
 
@@ -59,6 +63,11 @@ const (
 	TriggerR31 TriggerEvent = "R31" // Unsolicited New Point-of-Care Observation
 )
 
+// VXU Trigger Events
+const (
+	TriggerV04 TriggerEvent = "V04" // Unsolicited Vaccination Record Update
+)
+
 // Segment represents an HL7 segment
 type Segment interface {
 	ID() string
@@ -70,6 +79,7 @@ type Segment interface {
 type Message struct {
 	Type         MessageType
 	TriggerEvent TriggerEvent
+	Structure    string // MSH-9.3 message structure, e.g. ADT_A01
 	Version      string
 	ControlID    string
 	Timestamp    time.Time
@@ -513,6 +523,19 @@ type EVN struct {
 
 func (e *EVN) ID() string { return "EVN" }
 
+// MRG - Merge Patient Information
+type MRG struct {
+	PriorPatientIdentifierList []PatientIdentifier
+	PriorAlternatePatientID    string
+	PriorPatientAccountNumber  string
+	PriorPatientID             string
+	PriorVisitNumber           string
+	PriorAlternateVisitID      string
+	PriorPatientName           []PersonName
+}
+
+func (m *MRG) ID() string { return "MRG" }
+
 // AL1 - Patient Allergy Information
 type AL1 struct {
 	SetID              string
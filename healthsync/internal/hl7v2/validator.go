@@ -0,0 +1,503 @@
+package hl7v2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Unbounded is the maximum cardinality of a repeating segment or group
+const Unbounded = -1
+
+// ErrUnknownStructure is returned when no structure is registered for a message
+var ErrUnknownStructure = errors.New("unknown message structure")
+
+// StructureNode is a segment, group or choice in a message structure
+type StructureNode struct {
+	Segment  string // segment ID; empty for groups and choices
+	Group    string // group name, e.g. ORDER_OBSERVATION
+	Choice   bool   // exactly one child occurs, as in <OBR|RQD>
+	Min      int
+	Max      int // Unbounded for no limit
+	Children []StructureNode
+}
+
+// Seg describes a segment: Seg("PID", 1, 1) is PID, Seg("NTE", 0, Unbounded) is [{NTE}]
+func Seg(id string, min, max int) StructureNode {
+	return StructureNode{Segment: id, Min: min, Max: max}
+}
+
+// Group describes a named segment group
+func Group(name string, min, max int, children ...StructureNode) StructureNode {
+	return StructureNode{Group: name, Min: min, Max: max, Children: children}
+}
+
+// Choice describes a required choice between segments or groups
+func Choice(children ...StructureNode) StructureNode {
+	return StructureNode{Choice: true, Min: 1, Max: 1, Children: children}
+}
+
+// MessageStructure is the abstract message syntax of a message structure
+// such as ORU_R01
+type MessageStructure struct {
+	ID      string
+	Version string
+	Nodes   []StructureNode
+}
+
+// StructureIssue is one way a message does not conform to its structure
+type StructureIssue struct {
+	Segment  string // segment involved
+	Position int    // 1-based position in the message; 0 at end of message
+	Path     string // enclosing groups, e.g. PATIENT_RESULT/ORDER_OBSERVATION
+	Message  string
+}
+
+// StructureError lists the issues found validating a message
+type StructureError struct {
+	Structure string
+	Issues    []StructureIssue
+}
+
+func (e *StructureError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue.Message
+	}
+	return fmt.Sprintf("message does not conform to %s: %s", e.Structure, strings.Join(msgs, "; "))
+}
+
+// Validator checks messages against the abstract message syntax of their
+// structure: segment order, required segments, cardinality and groups.
+// Z-segments the structure does not name may appear anywhere.
+type Validator struct {
+	mu         sync.RWMutex
+	structures map[string]*MessageStructure
+	events     map[string]string // MESSAGE^EVENT -> structure ID
+}
+
+// NewValidator creates a validator loaded with the HL7 v2.5.1 structures
+// of the messages HealthSync accepts
+func NewValidator() *Validator {
+	v := &Validator{
+		structures: make(map[string]*MessageStructure),
+		events:     make(map[string]string),
+	}
+	v.Register(adtA01Structure, "ADT^A01", "ADT^A04", "ADT^A08", "ADT^A13")
+	v.Register(adtA24Structure, "ADT^A24")
+	v.Register(adtA37Structure, "ADT^A37")
+	v.Register(adtA39Structure, "ADT^A39", "ADT^A40", "ADT^A41", "ADT^A42")
+	v.Register(oruR01Structure, "ORU^R01")
+	v.Register(ormO01Structure, "ORM^O01")
+	v.Register(vxuV04Structure, "VXU^V04")
+	v.Register(ackStructure, "ACK")
+	return v
+}
+
+// Register adds or replaces a structure, such as a site conformance
+// profile, and maps the given MESSAGE^EVENT codes to it
+func (v *Validator) Register(structure *MessageStructure, events ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.structures[structure.ID] = structure
+	for _, event := range events {
+		v.events[event] = structure.ID
+	}
+}
+
+// StructureFor returns the structure named in MSH-9.3, falling back to
+// the structure registered for the message type and trigger event
+func (v *Validator) StructureFor(msg *Message) (*MessageStructure, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if s, ok := v.structures[msg.Structure]; ok {
+		return s, true
+	}
+	id, ok := v.events[string(msg.Type)+"^"+string(msg.TriggerEvent)]
+	if !ok {
+		id, ok = v.events[string(msg.Type)]
+	}
+	if !ok {
+		return nil, false
+	}
+	s, ok := v.structures[id]
+	return s, ok
+}
+
+// Validate checks a message's segments against its structure. It returns
+// ErrUnknownStructure when none is registered and a *StructureError when
+// the message does not conform.
+func (v *Validator) Validate(msg *Message) error {
+	structure, ok := v.StructureFor(msg)
+	if !ok {
+		return fmt.Errorf("%w: %s^%s", ErrUnknownStructure, msg.Type, msg.TriggerEvent)
+	}
+
+	named := make(map[string]bool)
+	collectSegments(structure.Nodes, named)
+
+	m := &structureMatcher{}
+	for i, seg := range msg.Segments {
+		id := seg.ID()
+		if strings.HasPrefix(id, "Z") && !named[id] {
+			continue
+		}
+		m.ids = append(m.ids, id)
+		m.positions = append(m.positions, i+1)
+	}
+
+	m.matchNodes(structure.Nodes, "")
+	if m.next < len(m.ids) {
+		m.issue(m.ids[m.next], "", fmt.Sprintf("segment %s at position %d is not allowed here", m.ids[m.next], m.position()))
+	}
+
+	if len(m.issues) > 0 {
+		return &StructureError{Structure: structure.ID, Issues: m.issues}
+	}
+	return nil
+}
+
+// structureMatcher walks a message's segments through a structure,
+// consuming each node greedily
+type structureMatcher struct {
+	ids       []string
+	positions []int
+	next      int
+	issues    []StructureIssue
+}
+
+func (m *structureMatcher) matchNodes(nodes []StructureNode, path string) {
+	for _, node := range nodes {
+		m.matchNode(node, path)
+	}
+}
+
+func (m *structureMatcher) matchNode(node StructureNode, path string) {
+	count := 0
+	for node.Max == Unbounded || count < node.Max {
+		if m.next >= len(m.ids) || !firstSegments(node)[m.ids[m.next]] {
+			break
+		}
+		m.consume(node, path)
+		count++
+	}
+	if count >= node.Min {
+		return
+	}
+
+	at := "at end of message"
+	if m.next < len(m.ids) {
+		at = fmt.Sprintf("before %s at position %d", m.ids[m.next], m.position())
+	}
+	switch {
+	case node.Segment != "":
+		m.issue(node.Segment, path, fmt.Sprintf("required segment %s missing %s", node.Segment, at))
+	case node.Choice:
+		m.issue("", path, fmt.Sprintf("one of %s required %s", choiceNames(node), at))
+	default:
+		m.issue("", path, fmt.Sprintf("required group %s missing %s", node.Group, at))
+	}
+}
+
+func (m *structureMatcher) consume(node StructureNode, path string) {
+	switch {
+	case node.Segment != "":
+		m.next++
+	case node.Choice:
+		for _, child := range node.Children {
+			if firstSegments(child)[m.ids[m.next]] {
+				m.consume(child, path)
+				return
+			}
+		}
+	default:
+		if path != "" {
+			path += "/"
+		}
+		m.matchNodes(node.Children, path+node.Group)
+	}
+}
+
+func (m *structureMatcher) position() int {
+	if m.next < len(m.positions) {
+		return m.positions[m.next]
+	}
+	return 0
+}
+
+func (m *structureMatcher) issue(segment, path, message string) {
+	m.issues = append(m.issues, StructureIssue{
+		Segment:  segment,
+		Position: m.position(),
+		Path:     path,
+		Message:  message,
+	})
+}
+
+// firstSegments returns the segments that can begin a node
+func firstSegments(node StructureNode) map[string]bool {
+	first := make(map[string]bool)
+	if node.Segment != "" {
+		first[node.Segment] = true
+		return first
+	}
+	for _, child := range node.Children {
+		for id := range firstSegments(child) {
+			first[id] = true
+		}
+		if !node.Choice && child.Min > 0 {
+			break
+		}
+	}
+	return first
+}
+
+// collectSegments records every segment ID a structure names
+func collectSegments(nodes []StructureNode, named map[string]bool) {
+	for _, node := range nodes {
+		if node.Segment != "" {
+			named[node.Segment] = true
+		}
+		collectSegments(node.Children, named)
+	}
+}
+
+func choiceNames(node StructureNode) string {
+	names := make([]string, 0, len(node.Children))
+	for _, child := range node.Children {
+		if child.Segment != "" {
+			names = append(names, child.Segment)
+		} else {
+			names = append(names, child.Group)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// HL7 v2.5.1 abstract message syntax (chapters 2, 3, 4 and 7)
+
+var adtA01Structure = &MessageStructure{
+	ID:      "ADT_A01",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("EVN", 1, 1),
+		Seg("PID", 1, 1),
+		Seg("PD1", 0, 1),
+		Seg("ROL", 0, Unbounded),
+		Seg("NK1", 0, Unbounded),
+		Seg("PV1", 1, 1),
+		Seg("PV2", 0, 1),
+		Seg("ROL", 0, Unbounded),
+		Seg("DB1", 0, Unbounded),
+		Seg("OBX", 0, Unbounded),
+		Seg("AL1", 0, Unbounded),
+		Seg("DG1", 0, Unbounded),
+		Seg("DRG", 0, 1),
+		Group("PROCEDURE", 0, Unbounded,
+			Seg("PR1", 1, 1),
+			Seg("ROL", 0, Unbounded),
+		),
+		Seg("GT1", 0, Unbounded),
+		insuranceGroup,
+		Seg("ACC", 0, 1),
+		Seg("UB1", 0, 1),
+		Seg("UB2", 0, 1),
+		Seg("PDA", 0, 1),
+	},
+}
+
+var insuranceGroup = Group("INSURANCE", 0, Unbounded,
+	Seg("IN1", 1, 1),
+	Seg("IN2", 0, 1),
+	Seg("IN3", 0, Unbounded),
+	Seg("ROL", 0, Unbounded),
+)
+
+var adtA24Structure = &MessageStructure{
+	ID:      "ADT_A24",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("EVN", 1, 1),
+		Seg("PID", 1, 1),
+		Seg("PD1", 0, 1),
+		Seg("PV1", 0, 1),
+		Seg("DB1", 0, Unbounded),
+		Seg("PID", 1, 1),
+		Seg("PD1", 0, 1),
+		Seg("PV1", 0, 1),
+		Seg("DB1", 0, Unbounded),
+	},
+}
+
+var adtA37Structure = &MessageStructure{
+	ID:      "ADT_A37",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("EVN", 1, 1),
+		Seg("PID", 1, 1),
+		Seg("PV1", 0, 1),
+		Seg("DB1", 0, Unbounded),
+		Seg("PID", 1, 1),
+		Seg("PV1", 0, 1),
+		Seg("DB1", 0, Unbounded),
+	},
+}
+
+var adtA39Structure = &MessageStructure{
+	ID:      "ADT_A39",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("EVN", 1, 1),
+		Group("PATIENT", 1, Unbounded,
+			Seg("PID", 1, 1),
+			Seg("PD1", 0, 1),
+			Seg("MRG", 1, 1),
+			Seg("PV1", 0, 1),
+		),
+	},
+}
+
+var oruR01Structure = &MessageStructure{
+	ID:      "ORU_R01",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Group("PATIENT_RESULT", 1, Unbounded,
+			Group("PATIENT", 0, 1,
+				Seg("PID", 1, 1),
+				Seg("PD1", 0, 1),
+				Seg("NTE", 0, Unbounded),
+				Seg("NK1", 0, Unbounded),
+				Group("VISIT", 0, 1,
+					Seg("PV1", 1, 1),
+					Seg("PV2", 0, 1),
+				),
+			),
+			Group("ORDER_OBSERVATION", 1, Unbounded,
+				Seg("ORC", 0, 1),
+				Seg("OBR", 1, 1),
+				Seg("NTE", 0, Unbounded),
+				timingGroup("TIMING_QTY"),
+				Seg("CTD", 0, 1),
+				observationGroup,
+				Seg("FT1", 0, Unbounded),
+				Seg("CTI", 0, Unbounded),
+				Group("SPECIMEN", 0, Unbounded,
+					Seg("SPM", 1, 1),
+					Seg("OBX", 0, Unbounded),
+				),
+			),
+		),
+		Seg("DSC", 0, 1),
+	},
+}
+
+var observationGroup = Group("OBSERVATION", 0, Unbounded,
+	Seg("OBX", 1, 1),
+	Seg("NTE", 0, Unbounded),
+)
+
+func timingGroup(name string) StructureNode {
+	return Group(name, 0, Unbounded,
+		Seg("TQ1", 1, 1),
+		Seg("TQ2", 0, Unbounded),
+	)
+}
+
+var ormO01Structure = &MessageStructure{
+	ID:      "ORM_O01",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("NTE", 0, Unbounded),
+		Group("PATIENT", 0, 1,
+			Seg("PID", 1, 1),
+			Seg("PD1", 0, 1),
+			Seg("NTE", 0, Unbounded),
+			Group("PATIENT_VISIT", 0, 1,
+				Seg("PV1", 1, 1),
+				Seg("PV2", 0, 1),
+			),
+			Group("INSURANCE", 0, Unbounded,
+				Seg("IN1", 1, 1),
+				Seg("IN2", 0, 1),
+				Seg("IN3", 0, 1),
+			),
+			Seg("GT1", 0, 1),
+			Seg("AL1", 0, Unbounded),
+		),
+		Group("ORDER", 1, Unbounded,
+			Seg("ORC", 1, 1),
+			Group("ORDER_DETAIL", 0, 1,
+				Choice(
+					Seg("OBR", 1, 1),
+					Seg("RQD", 1, 1),
+					Seg("RQ1", 1, 1),
+					Seg("RXO", 1, 1),
+					Seg("ODS", 1, 1),
+					Seg("ODT", 1, 1),
+				),
+				Seg("NTE", 0, Unbounded),
+				Seg("CTD", 0, 1),
+				Seg("DG1", 0, Unbounded),
+				observationGroup,
+			),
+			Seg("FT1", 0, Unbounded),
+			Seg("CTI", 0, Unbounded),
+			Seg("BLG", 0, 1),
+		),
+	},
+}
+
+var vxuV04Structure = &MessageStructure{
+	ID:      "VXU_V04",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("PID", 1, 1),
+		Seg("PD1", 0, 1),
+		Seg("NK1", 0, Unbounded),
+		Group("PATIENT", 0, 1,
+			Seg("PV1", 1, 1),
+			Seg("PV2", 0, 1),
+		),
+		Seg("GT1", 0, Unbounded),
+		Group("INSURANCE", 0, Unbounded,
+			Seg("IN1", 1, 1),
+			Seg("IN2", 0, 1),
+			Seg("IN3", 0, 1),
+		),
+		Group("ORDER", 0, Unbounded,
+			Seg("ORC", 1, 1),
+			timingGroup("TIMING"),
+			Seg("RXA", 1, 1),
+			Seg("RXR", 0, 1),
+			observationGroup,
+		),
+	},
+}
+
+var ackStructure = &MessageStructure{
+	ID:      "ACK",
+	Version: "2.5.1",
+	Nodes: []StructureNode{
+		Seg("MSH", 1, 1),
+		Seg("SFT", 0, Unbounded),
+		Seg("MSA", 1, 1),
+		Seg("ERR", 0, Unbounded),
+	},
+}
@@ -0,0 +1,149 @@
+package hl7v2
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func parseMessage(t *testing.T, segments ...string) *Message {
+	t.Helper()
+	msg, err := NewParser(nil).Parse([]byte(strings.Join(segments, "\r")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestValidator_Validate(t *testing.T) {
+	v := NewValidator()
+
+	tests := []struct {
+		name     string
+		segments []string
+		issues   []string // substrings of each expected issue, in order
+	}{
+		{
+			name: "ADT_A01 conforms",
+			segments: []string{
+				"MSH|^~\\&|ADT|HOSP|||20260301||ADT^A04^ADT_A01|1|P|2.5.1",
+				"EVN|A04", "PID|1||MRN1", "NK1|1", "PV1|1|O", "AL1|1", "DG1|1",
+				"IN1|1", "IN2|1", "IN1|2",
+			},
+		},
+		{
+			name: "ADT_A01 missing EVN and PV1",
+			segments: []string{
+				"MSH|^~\\&|ADT|HOSP|||20260301||ADT^A08|1|P|2.5.1",
+				"PID|1||MRN1",
+			},
+			issues: []string{"required segment EVN missing before PID at position 2", "required segment PV1 missing at end of message"},
+		},
+		{
+			name: "segment out of order",
+			segments: []string{
+				"MSH|^~\\&|ADT|HOSP|||20260301||ADT^A01|1|P|2.5.1",
+				"EVN|A01", "PID|1||MRN1", "PV1|1|I", "NK1|1",
+			},
+			issues: []string{"segment NK1 at position 5 is not allowed here"},
+		},
+		{
+			name: "ORU_R01 with repeated patient results",
+			segments: []string{
+				"MSH|^~\\&|LAB|HOSP|||20260301||ORU^R01^ORU_R01|1|P|2.5.1",
+				"PID|1||MRN1", "PV1|1|I", "ORC|RE", "OBR|1", "NTE|1", "OBX|1", "NTE|1", "OBX|2",
+				"OBR|2", "SPM|1", "OBX|1",
+				"PID|1||MRN2", "OBR|1", "OBX|1",
+			},
+		},
+		{
+			name: "ORU_R01 results without an order",
+			segments: []string{
+				"MSH|^~\\&|LAB|HOSP|||20260301||ORU^R01|1|P|2.5.1",
+				"PID|1||MRN1", "OBX|1",
+			},
+			issues: []string{"required group ORDER_OBSERVATION missing before OBX at position 3", "segment OBX at position 3 is not allowed here"},
+		},
+		{
+			name: "ORM_O01 order detail choice",
+			segments: []string{
+				"MSH|^~\\&|CPOE|HOSP|||20260301||ORM^O01|1|P|2.5.1",
+				"PID|1||MRN1", "ORC|NW", "RXO|1", "NTE|1", "ORC|NW", "OBR|1", "ORC|CA",
+			},
+		},
+		{
+			name: "ADT_A39 merge group requires MRG",
+			segments: []string{
+				"MSH|^~\\&|ADT|HOSP|||20260301||ADT^A40^ADT_A39|1|P|2.5.1",
+				"EVN|A40", "PID|1||MRN1", "MRG|MRN2", "PID|1||MRN3", "PV1|1",
+			},
+			issues: []string{"required segment MRG missing before PV1 at position 6"},
+		},
+		{
+			name: "Z-segments are allowed anywhere",
+			segments: []string{
+				"MSH|^~\\&|IIS|HOSP|||20260301||VXU^V04|1|P|2.5.1",
+				"ZPI|custom", "PID|1||MRN1", "ORC|RE", "ZRX|site", "RXA|0|1", "OBX|1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Validate(parseMessage(t, tt.segments...))
+			if len(tt.issues) == 0 {
+				if err != nil {
+					t.Fatalf("expected message to conform: %v", err)
+				}
+				return
+			}
+
+			var structErr *StructureError
+			if !errors.As(err, &structErr) {
+				t.Fatalf("expected a StructureError, got %v", err)
+			}
+			if len(structErr.Issues) != len(tt.issues) {
+				t.Fatalf("expected %d issues, got %+v", len(tt.issues), structErr.Issues)
+			}
+			for i, want := range tt.issues {
+				if !strings.Contains(structErr.Issues[i].Message, want) {
+					t.Errorf("issue %d: expected %q, got %q", i, want, structErr.Issues[i].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidator_StructureLookup(t *testing.T) {
+	v := NewValidator()
+
+	if err := v.Validate(parseMessage(t, "MSH|^~\\&|X|Y|||20260301||SIU^S12|1|P|2.5.1")); !errors.Is(err, ErrUnknownStructure) {
+		t.Errorf("expected ErrUnknownStructure, got %v", err)
+	}
+
+	// MSH-9.3 takes precedence over the trigger event
+	msg := parseMessage(t, "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A40^ADT_A39|1|P|2.5.1")
+	if s, _ := v.StructureFor(msg); s.ID != "ADT_A39" {
+		t.Errorf("expected ADT_A39, got %s", s.ID)
+	}
+
+	// A site profile can tighten a standard structure
+	v.Register(&MessageStructure{
+		ID: "ZADT_A01",
+		Nodes: []StructureNode{
+			Seg("MSH", 1, 1),
+			Seg("EVN", 1, 1),
+			Seg("PID", 1, 1),
+			Seg("ZPI", 1, 1),
+			Seg("PV1", 1, 1),
+		},
+	}, "ADT^A01")
+	msg = parseMessage(t, "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A01|1|P|2.5.1", "EVN|A01", "PID|1", "ZPI|1", "PV1|1")
+	if err := v.Validate(msg); err != nil {
+		t.Errorf("expected message to conform to the profile: %v", err)
+	}
+	msg = parseMessage(t, "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A01|1|P|2.5.1", "EVN|A01", "PID|1", "PV1|1")
+	if err := v.Validate(msg); err == nil || !strings.Contains(err.Error(), "ZADT_A01: required segment ZPI missing") {
+		t.Errorf("a profile should be able to require its Z-segments, got %v", err)
+	}
+}
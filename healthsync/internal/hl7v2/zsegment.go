@@ -0,0 +1,145 @@
+package hl7v2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SegmentDecoder decodes a site-specific segment into a typed Segment
+type SegmentDecoder func(fields *Fields) (Segment, error)
+
+// SegmentRegistry holds decoders for site-specific Z-segments. Segments
+// without a decoder are parsed as GenericSegment.
+type SegmentRegistry struct {
+	mu       sync.RWMutex
+	decoders map[string]SegmentDecoder
+}
+
+// NewSegmentRegistry creates an empty Z-segment registry
+func NewSegmentRegistry() *SegmentRegistry {
+	return &SegmentRegistry{
+		decoders: make(map[string]SegmentDecoder),
+	}
+}
+
+// Register adds a decoder for a Z-segment. Standard segments cannot be
+// overridden.
+func (r *SegmentRegistry) Register(id string, decoder SegmentDecoder) error {
+	if len(id) != 3 || id[0] != 'Z' {
+		return fmt.Errorf("segment ID %q is not a Z-segment", id)
+	}
+	if decoder == nil {
+		return fmt.Errorf("decoder for %s is nil", id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decoders[id] = decoder
+	return nil
+}
+
+// Lookup returns the decoder registered for a segment ID
+func (r *SegmentRegistry) Lookup(id string) (SegmentDecoder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	decoder, ok := r.decoders[id]
+	return decoder, ok
+}
+
+// Fields gives a segment decoder access to a segment's fields using the
+// message's delimiters. Fields are numbered from 1 as in the HL7 standard;
+// field 0 is the segment ID. Accessors return escape-decoded values.
+type Fields struct {
+	parser *Parser
+	values []string
+}
+
+// SegmentID returns the segment ID
+func (f *Fields) SegmentID() string {
+	return f.values[0]
+}
+
+// Len returns the number of fields present, excluding the segment ID
+func (f *Fields) Len() int {
+	return len(f.values) - 1
+}
+
+// Raw returns a field without splitting or decoding it
+func (f *Fields) Raw(i int) string {
+	if i < 1 || i >= len(f.values) {
+		return ""
+	}
+	return f.values[i]
+}
+
+// String returns a field's first repetition as text, decoding escapes
+func (f *Fields) String(i int) string {
+	return f.Component(i, 1)
+}
+
+// Component returns a component of a field's first repetition
+func (f *Fields) Component(i, component int) string {
+	comps := f.Components(i)
+	if component < 1 || component > len(comps) {
+		return ""
+	}
+	return comps[component-1]
+}
+
+// Components returns the components of a field's first repetition
+func (f *Fields) Components(i int) []string {
+	reps := strings.Split(f.Raw(i), f.parser.repetitionSep)
+	comps := strings.Split(reps[0], f.parser.componentSep)
+	f.parser.unescapeAll(comps)
+	return comps
+}
+
+// Repetitions returns every repetition of a field, decoding escapes
+func (f *Fields) Repetitions(i int) []string {
+	reps := f.parser.parseRepetitions(f.Raw(i))
+	f.parser.unescapeAll(reps)
+	return reps
+}
+
+// Int returns a numeric field, or zero when it is empty
+func (f *Fields) Int(i int) (int, error) {
+	value := f.String(i)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s-%d: %w", f.SegmentID(), i, err)
+	}
+	return n, nil
+}
+
+// Time returns a DTM field
+func (f *Fields) Time(i int) time.Time {
+	return f.parser.parseDateTime(f.Component(i, 1))
+}
+
+// CodedElement returns a CE/CWE field
+func (f *Fields) CodedElement(i int) CodedElement {
+	return f.parser.parseCodedElement(strings.Split(f.Raw(i), f.parser.repetitionSep)[0])
+}
+
+// parseRegisteredSegment decodes a Z-segment with its registered decoder
+func (p *Parser) parseRegisteredSegment(segmentID, data string) (Segment, bool, error) {
+	if p.segments == nil {
+		return nil, false, nil
+	}
+	decoder, ok := p.segments.Lookup(segmentID)
+	if !ok {
+		return nil, false, nil
+	}
+
+	seg, err := decoder(&Fields{parser: p, values: strings.Split(data, p.fieldSep)})
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decode %s: %w", segmentID, err)
+	}
+	return seg, true, nil
+}
@@ -0,0 +1,100 @@
+package hl7v2
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ZPI is a site-specific patient extension used by the tests
+type ZPI struct {
+	SetID       int
+	Preferred   string
+	Interpreter CodedElement
+	Languages   []string
+	VerifiedAt  time.Time
+}
+
+func (z *ZPI) ID() string               { return "ZPI" }
+func (z *ZPI) Encode() (string, error)  { return "", nil }
+func (z *ZPI) Decode(data string) error { return nil }
+
+func decodeZPI(f *Fields) (Segment, error) {
+	setID, err := f.Int(1)
+	if err != nil {
+		return nil, err
+	}
+	return &ZPI{
+		SetID:       setID,
+		Preferred:   f.String(2),
+		Interpreter: f.CodedElement(3),
+		Languages:   f.Repetitions(4),
+		VerifiedAt:  f.Time(5),
+	}, nil
+}
+
+func TestSegmentRegistry_Register(t *testing.T) {
+	registry := NewSegmentRegistry()
+
+	if err := registry.Register("PID", decodeZPI); err == nil {
+		t.Error("standard segments should not be overridable")
+	}
+	if err := registry.Register("ZPIX", decodeZPI); err == nil {
+		t.Error("segment IDs must be three characters")
+	}
+	if err := registry.Register("ZPI", nil); err == nil {
+		t.Error("expected an error for a nil decoder")
+	}
+	if err := registry.Register("ZPI", decodeZPI); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, ok := registry.Lookup("ZPI"); !ok {
+		t.Error("registered decoder not found")
+	}
+}
+
+func TestParser_DecodesRegisteredZSegments(t *testing.T) {
+	registry := NewSegmentRegistry()
+	registry.Register("ZPI", decodeZPI)
+
+	raw := "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A08|1|P|2.5.1\r" +
+		"PID|1||MRN1\r" +
+		"ZPI|1|Bobby \\T\\ Rob|Y^Yes^HL70136|en~es|20260301120000\r" +
+		"ZXX|unregistered\r"
+
+	msg, err := NewParser(&ParserConfig{Segments: registry}).Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zpi, ok := msg.Segments[2].(*ZPI)
+	if !ok {
+		t.Fatalf("expected a typed ZPI segment, got %T", msg.Segments[2])
+	}
+	if zpi.SetID != 1 || zpi.Preferred != "Bobby & Rob" || zpi.Interpreter.Text != "Yes" ||
+		len(zpi.Languages) != 2 || zpi.Languages[1] != "es" || zpi.VerifiedAt.Hour() != 12 {
+		t.Errorf("unexpected ZPI %+v", zpi)
+	}
+	if generic, ok := msg.Segments[3].(*GenericSegment); !ok || generic.SegmentID != "ZXX" {
+		t.Errorf("unregistered Z-segments should stay generic, got %T", msg.Segments[3])
+	}
+}
+
+func TestParser_ZSegmentDecodeErrors(t *testing.T) {
+	registry := NewSegmentRegistry()
+	registry.Register("ZPI", decodeZPI)
+	raw := []byte("MSH|^~\\&|ADT|HOSP|||20260301||ADT^A08|1|P|2.5.1\rZPI|one\r")
+
+	msg, err := NewParser(&ParserConfig{Segments: registry}).Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.Segments[1].(*GenericSegment); !ok {
+		t.Errorf("a segment that fails to decode should be kept as generic, got %T", msg.Segments[1])
+	}
+
+	_, err = NewParser(&ParserConfig{StrictMode: true, Segments: registry}).Parse(raw)
+	if err == nil || !strings.Contains(err.Error(), "ZPI-1") {
+		t.Errorf("strict mode should fail on a decode error, got %v", err)
+	}
+}
@@ -0,0 +1,648 @@
+package inbound
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/savegress/healthsync/internal/audit"
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/hl7v2"
+	"github.com/savegress/healthsync/internal/mpi"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+// Channel receives HL7 v2 messages over MLLP, converts them to FHIR
+// resources and upserts them into the resource store
+type Channel struct {
+	config    *config.HL7Config
+	store     fhirstore.Store
+	audit     *audit.Logger
+	mpi       *mpi.Service
+	server    *hl7v2.Server
+	converter *hl7v2.Converter
+	acker     *hl7v2.Client
+	validator *hl7v2.Validator
+	segments  *hl7v2.SegmentRegistry
+	mu        sync.Mutex // serializes upserts so concurrent messages cannot duplicate a patient
+}
+
+// NewChannel creates a new inbound channel. Without an MPI service new
+// patients are not matched and merge/link events are rejected.
+func NewChannel(cfg *config.HL7Config, store fhirstore.Store, auditLogger *audit.Logger, mpiService *mpi.Service) *Channel {
+	return &Channel{
+		config:    cfg,
+		store:     store,
+		audit:     auditLogger,
+		mpi:       mpiService,
+		converter: hl7v2.NewConverter(),
+		acker:     hl7v2.NewClient(&hl7v2.ClientConfig{}),
+		validator: hl7v2.NewValidator(),
+		segments:  hl7v2.NewSegmentRegistry(),
+	}
+}
+
+// RegisterSegment adds a decoder for a site-specific Z-segment. Register
+// segments before Start.
+func (c *Channel) RegisterSegment(id string, decoder hl7v2.SegmentDecoder) error {
+	return c.segments.Register(id, decoder)
+}
+
+// RegisterStructure adds a message structure, such as a site conformance
+// profile, used when structure validation is enabled
+func (c *Channel) RegisterStructure(structure *hl7v2.MessageStructure, events ...string) {
+	c.validator.Register(structure, events...)
+}
+
+// Start starts the MLLP listener
+func (c *Channel) Start(ctx context.Context) error {
+	serverCfg := &hl7v2.ServerConfig{
+		Host:    c.config.Host,
+		Port:    c.config.Port,
+		Handler: c.Handle,
+		Parser:  &hl7v2.ParserConfig{Segments: c.segments},
+	}
+	if c.config.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.config.TLSCertFile, c.config.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load HL7 TLS certificate: %w", err)
+		}
+		serverCfg.UseTLS = true
+		serverCfg.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	c.server = hl7v2.NewServer(serverCfg)
+	return c.server.Start(ctx)
+}
+
+// Stop stops the MLLP listener
+func (c *Channel) Stop() {
+	if c.server != nil {
+		c.server.Stop()
+	}
+}
+
+// Addr returns the address the listener is bound to
+func (c *Channel) Addr() net.Addr {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Addr()
+}
+
+// Handle processes one message and returns its ACK. It is the channel's
+// hl7v2.MessageHandler: errors are turned into AE or AR by the server.
+func (c *Channel) Handle(ctx context.Context, msg *hl7v2.Message) (*hl7v2.Message, error) {
+	c.mu.Lock()
+	result, err := c.process(ctx, msg)
+	c.mu.Unlock()
+
+	c.auditMessage(ctx, msg, result, err)
+	if err != nil {
+		return nil, err
+	}
+	return c.acker.CreateACK(msg, "AA", ""), nil
+}
+
+// result records what a message did to the store
+type result struct {
+	patientID string
+	created   int
+	updated   int
+}
+
+func (r *result) record(res *fhirstore.Resource) {
+	if res.VersionID == "1" {
+		r.created++
+	} else {
+		r.updated++
+	}
+}
+
+// process routes a message by type and trigger event
+func (c *Channel) process(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	if c.config.ValidateStructure {
+		// Unknown structures fall through to be rejected as unsupported
+		if err := c.validator.Validate(msg); err != nil && !errors.Is(err, hl7v2.ErrUnknownStructure) {
+			return nil, err
+		}
+	}
+
+	switch {
+	case msg.Type == hl7v2.MessageTypeADT && (msg.TriggerEvent == hl7v2.TriggerA01 ||
+		msg.TriggerEvent == hl7v2.TriggerA04 || msg.TriggerEvent == hl7v2.TriggerA08):
+		return c.processADT(ctx, msg)
+	case msg.Type == hl7v2.MessageTypeADT && c.mpi != nil && msg.TriggerEvent == hl7v2.TriggerA40:
+		return c.processMerge(ctx, msg)
+	case msg.Type == hl7v2.MessageTypeADT && c.mpi != nil && (msg.TriggerEvent == hl7v2.TriggerA24 ||
+		msg.TriggerEvent == hl7v2.TriggerA37):
+		return c.processLink(ctx, msg)
+	case msg.Type == hl7v2.MessageTypeORU && msg.TriggerEvent == hl7v2.TriggerR01:
+		return c.processORU(ctx, msg)
+	case msg.Type == hl7v2.MessageTypeORM && msg.TriggerEvent == hl7v2.TriggerO01:
+		return c.processORM(ctx, msg)
+	case msg.Type == hl7v2.MessageTypeVXU && msg.TriggerEvent == hl7v2.TriggerV04:
+		return c.processVXU(ctx, msg)
+	default:
+		return nil, &hl7v2.AckError{
+			Code: "AR",
+			Err:  fmt.Errorf("unsupported message type %s^%s", msg.Type, msg.TriggerEvent),
+		}
+	}
+}
+
+// processADT upserts the patient and, when PV1 is present, the encounter
+func (c *Channel) processADT(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+	patientID, err := c.upsertPatient(ctx, msg, r)
+	if err != nil {
+		return r, err
+	}
+
+	for _, seg := range msg.Segments {
+		pv1, ok := seg.(*hl7v2.PV1)
+		if !ok {
+			continue
+		}
+		encounter := c.converter.HL7ToFHIREncounter(pv1, "Patient/"+patientID)
+		if err := c.upsert(ctx, &encounter.FHIRResource, encounter, r); err != nil {
+			return r, err
+		}
+		break
+	}
+	return r, nil
+}
+
+// processORU stores each OBR group as a DiagnosticReport with its
+// Observations as results
+func (c *Channel) processORU(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+
+	type orderGroup struct {
+		obr  *hl7v2.OBR
+		obxs []*hl7v2.OBX
+	}
+	var groups []*orderGroup
+	var encounterRef string
+	for _, seg := range msg.Segments {
+		switch s := seg.(type) {
+		case *hl7v2.PV1:
+			encounterRef = c.findEncounter(ctx, s)
+		case *hl7v2.OBR:
+			groups = append(groups, &orderGroup{obr: s})
+		case *hl7v2.OBX:
+			if len(groups) == 0 {
+				return r, fmt.Errorf("OBX segment %s precedes any OBR segment", s.SetID)
+			}
+			g := groups[len(groups)-1]
+			g.obxs = append(g.obxs, s)
+		}
+	}
+	if len(groups) == 0 {
+		return r, fmt.Errorf("ORU^R01 requires an OBR segment")
+	}
+
+	patientID, err := c.upsertPatient(ctx, msg, r)
+	if err != nil {
+		return r, err
+	}
+	patientRef := "Patient/" + patientID
+
+	for _, g := range groups {
+		orderNumber := g.obr.FillerOrderNumber
+		if orderNumber == "" {
+			orderNumber = g.obr.PlacerOrderNumber
+		}
+
+		var results []models.Reference
+		for i, obx := range g.obxs {
+			obs := c.converter.HL7ToFHIRObservation(obx, patientRef, encounterRef)
+			if orderNumber != "" {
+				// Key results by order and set ID so corrections update in place
+				setID := obx.SetID
+				if setID == "" {
+					setID = fmt.Sprint(i + 1)
+				}
+				value, system := c.entityIdentifier(orderNumber)
+				obs.Identifier = append(obs.Identifier, models.Identifier{
+					System: system,
+					Value:  value + "-" + setID,
+				})
+			}
+			if err := c.upsert(ctx, &obs.FHIRResource, obs, r); err != nil {
+				return r, err
+			}
+			results = append(results, models.Reference{Reference: "Observation/" + obs.ID})
+		}
+
+		report := c.converter.HL7ToFHIRDiagnosticReport(g.obr, g.obxs, patientRef)
+		if encounterRef != "" {
+			report.Encounter = &models.Reference{Reference: encounterRef}
+		}
+		report.Result = results
+		if err := c.upsert(ctx, &report.FHIRResource, report, r); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// processORM stores each ORC/OBR pair as a ServiceRequest
+func (c *Channel) processORM(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+
+	type order struct {
+		orc *hl7v2.ORC
+		obr *hl7v2.OBR
+	}
+	var orders []order
+	var orc *hl7v2.ORC
+	for _, seg := range msg.Segments {
+		switch s := seg.(type) {
+		case *hl7v2.ORC:
+			orc = s
+		case *hl7v2.OBR:
+			if orc == nil {
+				return r, fmt.Errorf("OBR segment %s has no preceding ORC segment", s.SetID)
+			}
+			orders = append(orders, order{orc: orc, obr: s})
+		}
+	}
+	if len(orders) == 0 {
+		return r, fmt.Errorf("ORM^O01 requires ORC and OBR segments")
+	}
+
+	patientID, err := c.upsertPatient(ctx, msg, r)
+	if err != nil {
+		return r, err
+	}
+	for _, o := range orders {
+		sr := c.converter.HL7ToFHIRServiceRequest(o.orc, o.obr, "Patient/"+patientID)
+		if err := c.upsert(ctx, &sr.FHIRResource, sr, r); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// processVXU stores each RXA as an Immunization
+func (c *Channel) processVXU(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+
+	type administration struct {
+		orc *hl7v2.ORC
+		rxa *hl7v2.RXA
+	}
+	var administrations []administration
+	var orc *hl7v2.ORC
+	for _, seg := range msg.Segments {
+		switch s := seg.(type) {
+		case *hl7v2.ORC:
+			orc = s
+		case *hl7v2.RXA:
+			administrations = append(administrations, administration{orc: orc, rxa: s})
+			orc = nil
+		}
+	}
+	if len(administrations) == 0 {
+		return r, fmt.Errorf("VXU^V04 requires an RXA segment")
+	}
+
+	patientID, err := c.upsertPatient(ctx, msg, r)
+	if err != nil {
+		return r, err
+	}
+	for _, a := range administrations {
+		imm := c.converter.HL7ToFHIRImmunization(a.rxa, "Patient/"+patientID)
+		if a.orc != nil && a.orc.FillerOrderNumber != "" {
+			value, system := c.entityIdentifier(a.orc.FillerOrderNumber)
+			imm.Identifier = append(imm.Identifier, models.Identifier{System: system, Value: value})
+		} else {
+			// Without an order number, the patient, vaccine and date
+			// identify a resent administration
+			imm.Identifier = append(imm.Identifier, models.Identifier{
+				System: c.config.IdentifierSystem,
+				Value: fmt.Sprintf("%s-%s-%s", patientID, a.rxa.AdminCode.Identifier,
+					a.rxa.DateTimeStartOfAdmin.Format("20060102")),
+			})
+		}
+		if err := c.upsert(ctx, &imm.FHIRResource, imm, r); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// processMerge handles ADT^A40: each PID/MRG pair merges the record
+// named by MRG-1 into the surviving record named by PID-3
+func (c *Channel) processMerge(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+
+	type merge struct {
+		pid *hl7v2.PID
+		mrg *hl7v2.MRG
+	}
+	var merges []merge
+	var pid *hl7v2.PID
+	for _, seg := range msg.Segments {
+		switch s := seg.(type) {
+		case *hl7v2.PID:
+			pid = s
+		case *hl7v2.MRG:
+			if pid == nil {
+				return r, fmt.Errorf("MRG segment has no preceding PID segment")
+			}
+			merges = append(merges, merge{pid: pid, mrg: s})
+			pid = nil
+		}
+	}
+	if len(merges) == 0 {
+		return r, fmt.Errorf("ADT^A40 requires PID and MRG segments")
+	}
+
+	for _, m := range merges {
+		var identifiers []models.Identifier
+		for _, id := range m.mrg.PriorPatientIdentifierList {
+			identifiers = append(identifiers, models.Identifier{System: id.AssigningAuth, Value: id.ID})
+		}
+		if len(identifiers) == 0 {
+			return r, fmt.Errorf("MRG-1 prior patient identifier list is empty")
+		}
+		duplicate, err := c.findPatient(ctx, identifiers)
+		if err != nil {
+			return r, err
+		}
+		if duplicate == nil {
+			return r, fmt.Errorf("MRG-1 prior patient %s is not known", identifiers[0].Value)
+		}
+
+		survivorID, err := c.savePatient(ctx, m.pid, r)
+		if err != nil {
+			return r, err
+		}
+		if survivorID == duplicate.ID {
+			return r, fmt.Errorf("PID-3 and MRG-1 identify the same patient %s", survivorID)
+		}
+		if err := c.mpi.Merge(ctx, survivorID, duplicate.ID); err != nil {
+			return r, fmt.Errorf("failed to merge patient %s into %s: %w", duplicate.ID, survivorID, err)
+		}
+		r.updated++
+	}
+	return r, nil
+}
+
+// processLink handles ADT^A24 and ADT^A37, which link and unlink the
+// two patients named by the message's PID segments
+func (c *Channel) processLink(ctx context.Context, msg *hl7v2.Message) (*result, error) {
+	r := &result{}
+
+	var pids []*hl7v2.PID
+	for _, seg := range msg.Segments {
+		if p, ok := seg.(*hl7v2.PID); ok {
+			pids = append(pids, p)
+		}
+	}
+	if len(pids) != 2 {
+		return r, fmt.Errorf("ADT^%s requires two PID segments, got %d", msg.TriggerEvent, len(pids))
+	}
+
+	if msg.TriggerEvent == hl7v2.TriggerA24 {
+		var ids [2]string
+		for i, pid := range pids {
+			id, err := c.savePatient(ctx, pid, r)
+			if err != nil {
+				return r, err
+			}
+			ids[i] = id
+		}
+		r.patientID = ids[0]
+		if err := c.mpi.Link(ctx, ids[0], ids[1]); err != nil {
+			return r, fmt.Errorf("failed to link patients: %w", err)
+		}
+		return r, nil
+	}
+
+	var ids [2]string
+	for i, pid := range pids {
+		identifiers := c.converter.HL7ToFHIRPatient(pid).Identifier
+		if len(identifiers) == 0 {
+			return r, fmt.Errorf("PID-3 patient identifier list is empty")
+		}
+		patient, err := c.findPatient(ctx, identifiers)
+		if err != nil {
+			return r, err
+		}
+		if patient == nil {
+			return r, fmt.Errorf("PID-3 patient %s is not known", identifiers[0].Value)
+		}
+		ids[i] = patient.ID
+	}
+	r.patientID = ids[0]
+	if err := c.mpi.Unlink(ctx, ids[0], ids[1]); err != nil {
+		return r, fmt.Errorf("failed to unlink patients: %w", err)
+	}
+	r.updated += 2
+	return r, nil
+}
+
+// upsertPatient saves the message's first PID segment and returns the ID
+// resources should reference: the surviving record if it was merged
+func (c *Channel) upsertPatient(ctx context.Context, msg *hl7v2.Message, r *result) (string, error) {
+	var pid *hl7v2.PID
+	for _, seg := range msg.Segments {
+		if p, ok := seg.(*hl7v2.PID); ok {
+			pid = p
+			break
+		}
+	}
+	if pid == nil {
+		return "", fmt.Errorf("required PID segment is missing")
+	}
+
+	patientID, err := c.savePatient(ctx, pid, r)
+	if err != nil {
+		return "", err
+	}
+	if c.mpi != nil {
+		patientID = c.mpi.SurvivingPatientID(patientID)
+	}
+	r.patientID = patientID
+	return patientID, nil
+}
+
+// savePatient converts a PID segment and upserts it by identifier. Links
+// recorded by the MPI are carried over, and new patients are matched.
+func (c *Channel) savePatient(ctx context.Context, pid *hl7v2.PID, r *result) (string, error) {
+	patient := c.converter.HL7ToFHIRPatient(pid)
+	if len(patient.Identifier) == 0 {
+		return "", fmt.Errorf("PID-3 patient identifier list is empty")
+	}
+	patient.Active = true
+
+	existing, err := c.findPatient(ctx, patient.Identifier)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		patient.Link = existing.Link
+		for _, link := range existing.Link {
+			if link.Type == "replaced-by" {
+				patient.Active = false
+			}
+		}
+	}
+	if err := c.upsert(ctx, &patient.FHIRResource, patient, r); err != nil {
+		return "", err
+	}
+	if r.patientID == "" {
+		r.patientID = patient.ID
+	}
+
+	if c.mpi != nil {
+		if existing == nil {
+			if _, err := c.mpi.Register(ctx, patient); err != nil {
+				return "", fmt.Errorf("failed to match patient: %w", err)
+			}
+		} else {
+			c.mpi.Update(patient)
+		}
+	}
+	return patient.ID, nil
+}
+
+// findPatient returns the stored patient sharing one of the identifiers,
+// or nil if there is none
+func (c *Channel) findPatient(ctx context.Context, identifiers []models.Identifier) (*models.Patient, error) {
+	c.normalizeIdentifiers(identifiers)
+	for _, ident := range identifiers {
+		res, err := c.store.FindByIdentifier(ctx, string(models.ResourceTypePatient), ident.System, ident.Value)
+		if errors.Is(err, fhirstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up Patient: %w", err)
+		}
+		var patient models.Patient
+		if err := res.Unmarshal(&patient); err != nil {
+			return nil, err
+		}
+		return &patient, nil
+	}
+	return nil, nil
+}
+
+// upsert reuses the id of a stored resource sharing one of base's
+// identifiers, then saves resource
+func (c *Channel) upsert(ctx context.Context, base *models.FHIRResource, resource interface{}, r *result) error {
+	c.normalizeIdentifiers(base.Identifier)
+	for _, ident := range base.Identifier {
+		existing, err := c.store.FindByIdentifier(ctx, string(base.ResourceType), ident.System, ident.Value)
+		if errors.Is(err, fhirstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up %s: %w", base.ResourceType, err)
+		}
+		base.ID = existing.ID
+		break
+	}
+
+	res, err := fhirstore.Save(ctx, c.store, resource)
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %w", base.ResourceType, err)
+	}
+	r.record(res)
+	return nil
+}
+
+// findEncounter returns a reference to the stored encounter for a visit
+func (c *Channel) findEncounter(ctx context.Context, pv1 *hl7v2.PV1) string {
+	if pv1.VisitNumber == "" {
+		return ""
+	}
+	value, system := c.entityIdentifier(pv1.VisitNumber)
+	res, err := c.store.FindByIdentifier(ctx, string(models.ResourceTypeEncounter), system, value)
+	if err != nil {
+		return ""
+	}
+	return "Encounter/" + res.ID
+}
+
+// normalizeIdentifiers splits HL7 composite values and fills in a system
+func (c *Channel) normalizeIdentifiers(identifiers []models.Identifier) {
+	for i := range identifiers {
+		value, namespace := c.entityIdentifier(identifiers[i].Value)
+		identifiers[i].Value = value
+		if identifiers[i].System == "" {
+			identifiers[i].System = namespace
+		}
+	}
+}
+
+// entityIdentifier splits an HL7 EI/CX value into its ID and namespace,
+// using the configured system when the namespace is empty
+func (c *Channel) entityIdentifier(raw string) (value, system string) {
+	parts := strings.Split(raw, hl7v2.DefaultComponentSeparator)
+	value = parts[0]
+	system = c.config.IdentifierSystem
+	for _, ns := range parts[1:] {
+		if ns != "" {
+			system = ns
+			break
+		}
+	}
+	return value, system
+}
+
+// auditMessage records one access event per message
+func (c *Channel) auditMessage(ctx context.Context, msg *hl7v2.Message, r *result, err error) {
+	if c.audit == nil {
+		return
+	}
+
+	action := "C"
+	outcome := "0"
+	var ackErr *hl7v2.AckError
+	switch {
+	case errors.As(err, &ackErr) && ackErr.Code == "AR":
+		outcome = "4"
+	case err != nil:
+		outcome = "8"
+	case r.created == 0:
+		action = "U"
+	}
+
+	patientID := ""
+	if r != nil {
+		patientID = r.patientID
+	}
+	query := fmt.Sprintf("%s^%s control_id=%s", msg.Type, msg.TriggerEvent, msg.ControlID)
+	if err != nil {
+		query += " error=" + err.Error()
+	}
+
+	c.audit.LogAccess(ctx, &audit.AccessLogRequest{
+		UserID:       "hl7:" + msg.SendingApp + "@" + msg.SendingFac,
+		UserName:     msg.SendingApp,
+		IPAddress:    hl7v2.RemoteAddr(ctx),
+		Action:       action,
+		ResourceType: string(models.ResourceTypePatient),
+		ResourceID:   patientID,
+		PatientID:    patientID,
+		Purpose:      "TREAT",
+		Outcome:      outcome,
+		Query:        query,
+	})
+	if err != nil {
+		log.Printf("HL7 %s^%s %s from %s not accepted: %v", msg.Type, msg.TriggerEvent, msg.ControlID, msg.SendingApp, err)
+	}
+}
@@ -0,0 +1,364 @@
+package inbound
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savegress/healthsync/internal/audit"
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/hl7v2"
+	"github.com/savegress/healthsync/internal/mpi"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+const (
+	adtA01 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260301120000||ADT^A01^ADT_A01|MSG001|P|2.5.1\r" +
+		"EVN|A01|20260301120000\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane^Q||19800115|F|||1 Main St^^Springfield^IL^62701||555-1234\r" +
+		"PV1|1|I|ICU^101^A|||||||MED|||||||||V100^^^HOSP|||||||||||||||||||||||||20260301115500\r"
+	adtA08 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260302090000||ADT^A08^ADT_A01|MSG002|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane^Q||19800115|F|||2 Oak Ave^^Springfield^IL^62701\r" +
+		"PV1|1|I|ICU^102^B|||||||MED|||||||||V100^^^HOSP\r"
+	oruR01 = "MSH|^~\\&|LAB|HOSP|HEALTHSYNC|HS|20260301130000||ORU^R01^ORU_R01|MSG003|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane\r" +
+		"PV1|1|I|ICU^101^A|||||||MED|||||||||V100^^^HOSP\r" +
+		"OBR|1|PL1|FL1^LAB|2345-7^Glucose^LN|||20260301125000||||||||||||||||||F\r" +
+		"OBX|1|NM|2345-7^Glucose^LN||95|mg/dL|70-99|N|||F|||20260301125000\r" +
+		"OBX|2|ST|8251-1^Comment^LN||Fasting||||||F\r"
+	ormO01 = "MSH|^~\\&|CPOE|HOSP|HEALTHSYNC|HS|20260301140000||ORM^O01^ORM_O01|MSG004|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane\r" +
+		"ORC|NW|ORD1^CPOE||||||||||1234^Smith^John\r" +
+		"OBR|1|ORD1^CPOE||24323-8^Metabolic panel^LN|S\r"
+	vxuV04 = "MSH|^~\\&|IIS|HOSP|HEALTHSYNC|HS|20260301150000||VXU^V04^VXU_V04|MSG005|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane\r" +
+		"ORC|RE||IMM1^IIS\r" +
+		"RXA|0|1|20260301|20260301|141^Influenza^CVX|0.5|mL^mL^UCUM||||||||LOT42|20270101|PMC^Sanofi^MVX|||CP|A\r"
+	adtA04 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260302100000||ADT^A04^ADT_A01|MSG020|P|2.5.1\r" +
+		"PID|1||MRN999^^^HOSP^MR||Doe^Janet||19800511|F\r"
+	adtA40 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260303100000||ADT^A40^ADT_A39|MSG021|P|2.5.1\r" +
+		"EVN|A40|20260303100000\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane^Q||19800115|F\r" +
+		"MRG|MRN999^^^HOSP^MR\r"
+	adtA24 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260304100000||ADT^A24^ADT_A24|MSG022|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR||Doe^Jane^Q||19800115|F\r" +
+		"PID|2||MRN777^^^HOSP^MR||Smith^John||19551203|M\r"
+	adtA37 = "MSH|^~\\&|ADT|HOSP|HEALTHSYNC|HS|20260305100000||ADT^A37^ADT_A37|MSG023|P|2.5.1\r" +
+		"PID|1||MRN123^^^HOSP^MR\r" +
+		"PID|2||MRN777^^^HOSP^MR\r"
+)
+
+func startChannel(t *testing.T) (*Channel, fhirstore.Store, *audit.Logger) {
+	t.Helper()
+	return startChannelWith(t, &config.HL7Config{Host: "127.0.0.1", IdentifierSystem: "urn:test"})
+}
+
+func startChannelWith(t *testing.T, cfg *config.HL7Config) (*Channel, fhirstore.Store, *audit.Logger) {
+	t.Helper()
+	store := fhirstore.NewMemoryStore()
+	auditLogger := audit.NewLogger(&config.AuditConfig{Enabled: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	auditLogger.Start(ctx)
+
+	ch := NewChannel(cfg, store, auditLogger, mpi.NewService(&config.MPIConfig{}, store))
+	if err := ch.Start(ctx); err != nil {
+		t.Fatalf("failed to start channel: %v", err)
+	}
+	t.Cleanup(ch.Stop)
+	return ch, store, auditLogger
+}
+
+// send delivers a message over MLLP and returns the MSA of its ACK
+func send(t *testing.T, ch *Channel, message string) *hl7v2.MSA {
+	t.Helper()
+	addr := ch.Addr().(*net.TCPAddr)
+	client := hl7v2.NewClient(&hl7v2.ClientConfig{Host: "127.0.0.1", Port: addr.Port, ReadTimeout: 5 * time.Second})
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	raw, err := client.SendRaw(context.Background(), []byte(message))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	ack, err := hl7v2.NewParser(nil).Parse(raw)
+	if err != nil {
+		t.Fatalf("invalid ACK %q: %v", raw, err)
+	}
+	for _, seg := range ack.Segments {
+		if msa, ok := seg.(*hl7v2.MSA); ok {
+			return msa
+		}
+	}
+	t.Fatalf("ACK has no MSA: %q", raw)
+	return nil
+}
+
+func TestChannel_EndToEnd(t *testing.T) {
+	ch, store, auditLogger := startChannel(t)
+	ctx := context.Background()
+
+	msa := send(t, ch, adtA01)
+	if msa.AcknowledgmentCode != "AA" || msa.MessageControlID != "MSG001" {
+		t.Fatalf("unexpected ACK for A01: %+v", msa)
+	}
+	res, err := store.FindByIdentifier(ctx, "Patient", "HOSP", "MRN123")
+	if err != nil {
+		t.Fatalf("patient not stored: %v", err)
+	}
+	patientID := res.ID
+	enc, err := store.FindByIdentifier(ctx, "Encounter", "HOSP", "V100")
+	if err != nil {
+		t.Fatalf("encounter not stored: %v", err)
+	}
+	var encounter models.Encounter
+	enc.Unmarshal(&encounter)
+	if encounter.Subject == nil || encounter.Subject.Reference != "Patient/"+patientID || encounter.Class.Code != "IMP" {
+		t.Errorf("unexpected encounter: %+v", encounter)
+	}
+
+	// A08 updates the same patient and encounter rather than adding new ones
+	if msa := send(t, ch, adtA08); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for A08: %+v", msa)
+	}
+	res, _ = store.Read(ctx, "Patient", patientID)
+	var patient models.Patient
+	res.Unmarshal(&patient)
+	if res.VersionID != "2" || patient.Address[0].Line[0] != "2 Oak Ave" {
+		t.Errorf("A08 should update the patient: version %s, %+v", res.VersionID, patient.Address)
+	}
+	if n, _ := store.Count(ctx, "Encounter"); n != 1 {
+		t.Errorf("expected 1 encounter, got %d", n)
+	}
+
+	if msa := send(t, ch, oruR01); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for R01: %+v", msa)
+	}
+	reports, _ := store.List(ctx, "DiagnosticReport")
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	var report models.DiagnosticReport
+	reports[0].Unmarshal(&report)
+	if len(report.Result) != 2 || report.Encounter == nil || report.Encounter.Reference != "Encounter/"+enc.ID {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	obs, err := store.FindByIdentifier(ctx, "Observation", "LAB", "FL1-1")
+	if err != nil {
+		t.Fatalf("observation not keyed by order: %v", err)
+	}
+	var glucose models.Observation
+	obs.Unmarshal(&glucose)
+	if glucose.ValueQuantity == nil || glucose.ValueQuantity.Value != 95 || glucose.Subject.Reference != "Patient/"+patientID {
+		t.Errorf("unexpected observation: %+v", glucose)
+	}
+	// A resent result updates in place
+	send(t, ch, oruR01)
+	if n, _ := store.Count(ctx, "Observation"); n != 2 {
+		t.Errorf("expected 2 observations after resend, got %d", n)
+	}
+
+	if msa := send(t, ch, ormO01); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for O01: %+v", msa)
+	}
+	if _, err := store.FindByIdentifier(ctx, "ServiceRequest", "CPOE", "ORD1"); err != nil {
+		t.Errorf("service request not stored: %v", err)
+	}
+
+	if msa := send(t, ch, vxuV04); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for V04: %+v", msa)
+	}
+	imm, err := store.FindByIdentifier(ctx, "Immunization", "IIS", "IMM1")
+	if err != nil {
+		t.Fatalf("immunization not stored: %v", err)
+	}
+	var immunization models.Immunization
+	imm.Unmarshal(&immunization)
+	if immunization.VaccineCode.Coding[0].System != "http://hl7.org/fhir/sid/cvx" || immunization.LotNumber != "LOT42" || immunization.Status != "completed" {
+		t.Errorf("unexpected immunization: %+v", immunization)
+	}
+
+	if n, _ := store.Count(ctx, "Patient"); n != 1 {
+		t.Errorf("every message should resolve to the same patient, got %d", n)
+	}
+
+	// Every message is audited against the patient
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events := auditLogger.GetEvents(audit.EventFilter{UserID: "hl7:ADT@HOSP"})
+		if len(events) == 2 {
+			if events[0].Entity[0].What.Reference != "Patient/"+patientID {
+				t.Errorf("unexpected audit entity: %+v", events[0].Entity[0].What)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 ADT audit events, got %d", len(events))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChannel_MergeAndLink(t *testing.T) {
+	ch, store, _ := startChannel(t)
+	ctx := context.Background()
+
+	readPatient := func(mrn string) *models.Patient {
+		t.Helper()
+		res, err := store.FindByIdentifier(ctx, "Patient", "HOSP", mrn)
+		if err != nil {
+			t.Fatalf("patient %s not stored: %v", mrn, err)
+		}
+		var patient models.Patient
+		res.Unmarshal(&patient)
+		return &patient
+	}
+	hasLink := func(p *models.Patient, linkType, otherID string) bool {
+		for _, link := range p.Link {
+			if link.Type == linkType && link.Other.Reference == "Patient/"+otherID {
+				return true
+			}
+		}
+		return false
+	}
+
+	send(t, ch, adtA01)
+	send(t, ch, adtA04)
+	survivor, duplicate := readPatient("MRN123"), readPatient("MRN999")
+
+	if msa := send(t, ch, adtA40); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for A40: %+v", msa)
+	}
+	merged := readPatient("MRN999")
+	if merged.Active || !hasLink(merged, "replaced-by", survivor.ID) {
+		t.Errorf("duplicate should be inactive and replaced by the survivor: %+v", merged.Link)
+	}
+	if !hasLink(readPatient("MRN123"), "replaces", duplicate.ID) {
+		t.Error("survivor should record the record it replaces")
+	}
+
+	// Results for the retired identifier land on the survivor, and an
+	// update does not reactivate the merged record
+	send(t, ch, strings.ReplaceAll(oruR01, "MRN123", "MRN999"))
+	obs, _ := store.FindByIdentifier(ctx, "Observation", "LAB", "FL1-1")
+	var glucose models.Observation
+	obs.Unmarshal(&glucose)
+	if glucose.Subject.Reference != "Patient/"+survivor.ID {
+		t.Errorf("expected results on the survivor, got %s", glucose.Subject.Reference)
+	}
+	if merged := readPatient("MRN999"); merged.Active || len(merged.Link) != 1 {
+		t.Errorf("merged record should stay retired: active=%v links=%+v", merged.Active, merged.Link)
+	}
+
+	if msa := send(t, ch, adtA24); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for A24: %+v", msa)
+	}
+	other := readPatient("MRN777")
+	if !other.Active || !hasLink(other, "seealso", survivor.ID) || !hasLink(readPatient("MRN123"), "seealso", other.ID) {
+		t.Errorf("A24 should link both records: %+v", other.Link)
+	}
+
+	if msa := send(t, ch, adtA37); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("unexpected ACK for A37: %+v", msa)
+	}
+	if hasLink(readPatient("MRN777"), "seealso", survivor.ID) || hasLink(readPatient("MRN123"), "seealso", other.ID) {
+		t.Error("A37 should remove the link from both records")
+	}
+	if msa := send(t, ch, adtA37); msa.AcknowledgmentCode != "AE" || !strings.Contains(msa.TextMessage, "not linked") {
+		t.Errorf("unlinking unlinked patients should be an application error: %+v", msa)
+	}
+
+	unknown := strings.ReplaceAll(adtA40, "MRG|MRN999", "MRG|MRN000")
+	if msa := send(t, ch, unknown); msa.AcknowledgmentCode != "AE" || !strings.Contains(msa.TextMessage, "MRN000") {
+		t.Errorf("merging an unknown patient should be an application error: %+v", msa)
+	}
+}
+
+func TestChannel_Acknowledgments(t *testing.T) {
+	ch, store, auditLogger := startChannel(t)
+
+	tests := []struct {
+		name    string
+		message string
+		code    string
+		text    string
+	}{
+		{
+			name:    "unsupported event is rejected",
+			message: "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A17|MSG010|P|2.5.1\rPID|1||MRN1\r",
+			code:    "AR",
+			text:    "unsupported message type",
+		},
+		{
+			name:    "unparseable message is rejected",
+			message: "PID|1||MRN1\r",
+			code:    "AR",
+		},
+		{
+			name:    "missing PID is an application error",
+			message: "MSH|^~\\&|ADT|HOSP|||20260301||ADT^A04|MSG011|P|2.5.1\rPV1|1|O\r",
+			code:    "AE",
+			text:    "PID",
+		},
+		{
+			name:    "result without an order is an application error",
+			message: "MSH|^~\\&|LAB|HOSP|||20260301||ORU^R01|MSG012|P|2.5.1\rPID|1||MRN1^^^HOSP\rOBX|1|NM|2345-7^Glucose^LN||95\r",
+			code:    "AE",
+			text:    "OBR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msa := send(t, ch, tt.message)
+			if msa.AcknowledgmentCode != tt.code {
+				t.Errorf("expected %s, got %+v", tt.code, msa)
+			}
+			if !strings.Contains(msa.TextMessage, tt.text) {
+				t.Errorf("expected ACK text to mention %q, got %q", tt.text, msa.TextMessage)
+			}
+		})
+	}
+
+	if n, _ := store.Count(context.Background(), "Patient"); n != 0 {
+		t.Errorf("failed messages should not store patients, got %d", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(auditLogger.GetEvents(audit.EventFilter{Outcome: "8"})) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("application errors should be audited as serious failures")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChannel_StructureValidation(t *testing.T) {
+	ch, store, _ := startChannelWith(t, &config.HL7Config{
+		Host:              "127.0.0.1",
+		IdentifierSystem:  "urn:test",
+		ValidateStructure: true,
+	})
+
+	if msa := send(t, ch, adtA01); msa.AcknowledgmentCode != "AA" {
+		t.Fatalf("conforming message should be accepted: %+v", msa)
+	}
+
+	// A04 without EVN and PV1 does not conform to ADT_A01
+	msa := send(t, ch, adtA04)
+	if msa.AcknowledgmentCode != "AE" || !strings.Contains(msa.TextMessage, "required segment EVN missing") {
+		t.Errorf("expected a structure error, got %+v", msa)
+	}
+	if _, err := store.FindByIdentifier(context.Background(), "Patient", "HOSP", "MRN999"); err == nil {
+		t.Error("a non-conforming message should not be stored")
+	}
+
+	// Site Z-segments are allowed anywhere
+	if msa := send(t, ch, adtA01+"ZPI|1|site data\r"); msa.AcknowledgmentCode != "AA" {
+		t.Errorf("Z-segments should not fail validation: %+v", msa)
+	}
+}
@@ -259,8 +259,8 @@ func (m *PatientMatcher) FindMatches(ctx context.Context, patient *models.Patien
 		}
 
 		candidate := m.patients[id]
-		if candidate == nil {
-			continue
+		if candidate == nil || replacedBy(candidate) != "" {
+			continue // Merged records are represented by their survivor
 		}
 
 		score, breakdown := m.calculateScore(patient, candidate)
@@ -738,9 +738,123 @@ func (m *PatientMatcher) LinkPatients(masterID, duplicateID string) (*PatientLin
 	// Mark duplicate as inactive
 	duplicate.Active = false
 
+	// Record the merge on both resources, replacing any earlier association
+	removePatientLink(duplicate, masterID, "seealso")
+	removePatientLink(m.patients[masterID], duplicateID, "seealso")
+	addPatientLink(duplicate, masterID, "replaced-by")
+	addPatientLink(m.patients[masterID], duplicateID, "replaces")
+
 	return link, nil
 }
 
+// AssociatePatients records that two active records belong to the same
+// person without retiring either of them
+func (m *PatientMatcher) AssociatePatients(patientID, otherID string) (*PatientLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patient, ok := m.patients[patientID]
+	if !ok {
+		return nil, fmt.Errorf("patient not found: %s", patientID)
+	}
+	other, ok := m.patients[otherID]
+	if !ok {
+		return nil, fmt.Errorf("patient not found: %s", otherID)
+	}
+	if patientID == otherID {
+		return nil, fmt.Errorf("cannot link patient %s to itself", patientID)
+	}
+
+	addPatientLink(patient, otherID, "seealso")
+	addPatientLink(other, patientID, "seealso")
+
+	return &PatientLink{
+		ID:          fmt.Sprintf("link_%d", time.Now().UnixNano()),
+		MasterID:    patientID,
+		DuplicateID: otherID,
+		LinkType:    "seealso",
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// UnlinkPatients removes the seealso links between two records
+func (m *PatientMatcher) UnlinkPatients(patientID, otherID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patient, ok := m.patients[patientID]
+	if !ok {
+		return fmt.Errorf("patient not found: %s", patientID)
+	}
+	other, ok := m.patients[otherID]
+	if !ok {
+		return fmt.Errorf("patient not found: %s", otherID)
+	}
+
+	removed := removePatientLink(patient, otherID, "seealso")
+	removed = removePatientLink(other, patientID, "seealso") || removed
+	if !removed {
+		return fmt.Errorf("patients %s and %s are not linked", patientID, otherID)
+	}
+	return nil
+}
+
+// SurvivingPatientID follows replaced-by links to the record that
+// represents a merged patient
+func (m *PatientMatcher) SurvivingPatientID(patientID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := map[string]bool{}
+	for !seen[patientID] {
+		seen[patientID] = true
+		patient, ok := m.patients[patientID]
+		if !ok {
+			break
+		}
+		next := replacedBy(patient)
+		if next == "" {
+			break
+		}
+		patientID = next
+	}
+	return patientID
+}
+
+func addPatientLink(patient *models.Patient, otherID, linkType string) {
+	ref := "Patient/" + otherID
+	for _, link := range patient.Link {
+		if link.Type == linkType && link.Other != nil && link.Other.Reference == ref {
+			return
+		}
+	}
+	patient.Link = append(patient.Link, models.PatientLink{
+		Other: &models.Reference{Reference: ref},
+		Type:  linkType,
+	})
+}
+
+func removePatientLink(patient *models.Patient, otherID, linkType string) bool {
+	ref := "Patient/" + otherID
+	for i, link := range patient.Link {
+		if link.Type == linkType && link.Other != nil && link.Other.Reference == ref {
+			patient.Link = append(patient.Link[:i], patient.Link[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// replacedBy returns the ID of the record a merged patient was replaced by
+func replacedBy(patient *models.Patient) string {
+	for _, link := range patient.Link {
+		if link.Type == "replaced-by" && link.Other != nil {
+			return strings.TrimPrefix(link.Other.Reference, "Patient/")
+		}
+	}
+	return ""
+}
+
 // PatientLink represents a link between patient records
 type PatientLink struct {
 	ID          string    `json:"id"`
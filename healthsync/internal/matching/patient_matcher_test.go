@@ -1017,3 +1017,100 @@ func TestPatientMatcher_CompareHumanNames_EmptyFields(t *testing.T) {
 		t.Errorf("expected 0 for empty names, got %f", score3)
 	}
 }
+
+func TestPatientMatcher_LinkPatients_RecordsLinks(t *testing.T) {
+	cfg := DefaultMatchConfig()
+	cfg.MinimumThreshold = 0.3
+	matcher := NewPatientMatcher(cfg)
+
+	newPatient := func(id string) *models.Patient {
+		return &models.Patient{
+			FHIRResource: models.FHIRResource{ID: id},
+			BirthDate:    "1990-05-15",
+			Name:         []models.HumanName{{Family: "Johnson", Given: []string{"Robert"}}},
+			Active:       true,
+		}
+	}
+	master, duplicate, third := newPatient("master-1"), newPatient("duplicate-1"), newPatient("third-1")
+	matcher.IndexPatient(master)
+	matcher.IndexPatient(duplicate)
+	matcher.IndexPatient(third)
+
+	if _, err := matcher.LinkPatients(master.ID, duplicate.ID); err != nil {
+		t.Fatalf("LinkPatients failed: %v", err)
+	}
+	if len(duplicate.Link) != 1 || duplicate.Link[0].Type != "replaced-by" || duplicate.Link[0].Other.Reference != "Patient/master-1" {
+		t.Errorf("unexpected duplicate links: %+v", duplicate.Link)
+	}
+	if len(master.Link) != 1 || master.Link[0].Type != "replaces" {
+		t.Errorf("unexpected master links: %+v", master.Link)
+	}
+
+	// Linking again is idempotent
+	matcher.LinkPatients(master.ID, duplicate.ID)
+	if len(duplicate.Link) != 1 || len(master.Link) != 1 {
+		t.Error("links should not be duplicated")
+	}
+
+	if got := matcher.SurvivingPatientID(duplicate.ID); got != master.ID {
+		t.Errorf("expected survivor %s, got %s", master.ID, got)
+	}
+	if got := matcher.SurvivingPatientID(master.ID); got != master.ID {
+		t.Errorf("a surviving record should resolve to itself, got %s", got)
+	}
+
+	// Merged records are no longer offered as candidates
+	result, err := matcher.FindMatches(context.Background(), third)
+	if err != nil {
+		t.Fatalf("FindMatches failed: %v", err)
+	}
+	for _, c := range result.Candidates {
+		if c.Patient.ID == duplicate.ID {
+			t.Error("merged record should not be a candidate")
+		}
+	}
+	if len(result.Candidates) != 1 {
+		t.Errorf("expected only the master as a candidate, got %d", len(result.Candidates))
+	}
+}
+
+func TestPatientMatcher_AssociateAndUnlinkPatients(t *testing.T) {
+	matcher := NewPatientMatcher(nil)
+
+	patient := &models.Patient{FHIRResource: models.FHIRResource{ID: "patient-1"}, Active: true}
+	other := &models.Patient{FHIRResource: models.FHIRResource{ID: "patient-2"}, Active: true}
+	matcher.IndexPatient(patient)
+	matcher.IndexPatient(other)
+
+	link, err := matcher.AssociatePatients(patient.ID, other.ID)
+	if err != nil {
+		t.Fatalf("AssociatePatients failed: %v", err)
+	}
+	if link.LinkType != "seealso" {
+		t.Errorf("expected link type 'seealso', got %s", link.LinkType)
+	}
+	if !patient.Active || !other.Active {
+		t.Error("associated patients should stay active")
+	}
+	if len(patient.Link) != 1 || patient.Link[0].Other.Reference != "Patient/patient-2" ||
+		len(other.Link) != 1 || other.Link[0].Other.Reference != "Patient/patient-1" {
+		t.Errorf("expected seealso links on both records: %+v, %+v", patient.Link, other.Link)
+	}
+
+	if _, err := matcher.AssociatePatients(patient.ID, patient.ID); err == nil {
+		t.Error("expected error linking a patient to itself")
+	}
+	if _, err := matcher.AssociatePatients(patient.ID, "nonexistent"); err == nil {
+		t.Error("expected error for missing patient")
+	}
+
+	if err := matcher.UnlinkPatients(other.ID, patient.ID); err != nil {
+		t.Fatalf("UnlinkPatients failed: %v", err)
+	}
+	if len(patient.Link) != 0 || len(other.Link) != 0 {
+		t.Errorf("expected links to be removed: %+v, %+v", patient.Link, other.Link)
+	}
+	if err := matcher.UnlinkPatients(patient.ID, other.ID); err == nil {
+		t.Error("expected error unlinking patients that are not linked")
+	}
+}
@@ -0,0 +1,436 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/internal/matching"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+var (
+	// ErrPatientNotFound is returned when a patient is not in the store
+	ErrPatientNotFound = errors.New("patient not found")
+	// ErrReviewNotFound is returned when a review does not exist
+	ErrReviewNotFound = errors.New("review not found")
+)
+
+// Outcome is what registering a new patient did
+type Outcome string
+
+const (
+	OutcomeLinked    Outcome = "linked"     // matched above the auto-link threshold
+	OutcomeReview    Outcome = "review"     // queued for a data steward
+	OutcomeNewRecord Outcome = "new_record" // no likely match
+)
+
+// Decision records the result of registering a patient
+type Decision struct {
+	PatientID string  `json:"patient_id"`
+	Outcome   Outcome `json:"outcome"`
+	LinkedTo  string  `json:"linked_to,omitempty"`
+	ReviewID  string  `json:"review_id,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+}
+
+// ReviewStatus represents the state of a match review
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewLinked   ReviewStatus = "linked"
+	ReviewMerged   ReviewStatus = "merged"
+	ReviewDistinct ReviewStatus = "distinct"
+)
+
+// Review is a possible duplicate waiting for a data steward
+type Review struct {
+	ID          string            `json:"id"`
+	PatientID   string            `json:"patient_id"`
+	Candidates  []ReviewCandidate `json:"candidates"`
+	Status      ReviewStatus      `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	ResolvedBy  string            `json:"resolved_by,omitempty"`
+	CandidateID string            `json:"candidate_id,omitempty"`
+	Note        string            `json:"note,omitempty"`
+}
+
+// ReviewCandidate is an existing record the new patient may duplicate
+type ReviewCandidate struct {
+	PatientID      string             `json:"patient_id"`
+	Score          float64            `json:"score"`
+	ScoreBreakdown map[string]float64 `json:"score_breakdown"`
+	MatchedFields  []string           `json:"matched_fields"`
+}
+
+// Resolution is a data steward's decision on a review
+type Resolution struct {
+	Status      ReviewStatus `json:"status"`       // linked, merged or distinct
+	CandidateID string       `json:"candidate_id"` // defaults to the best candidate
+	ResolvedBy  string       `json:"resolved_by"`
+	Note        string       `json:"note"`
+}
+
+// Stats holds MPI statistics
+type Stats struct {
+	PendingReviews  int `json:"pending_reviews"`
+	ResolvedReviews int `json:"resolved_reviews"`
+	AutoLinked      int `json:"auto_linked"`
+	Merged          int `json:"merged"`
+	Linked          int `json:"linked"`
+	Unlinked        int `json:"unlinked"`
+}
+
+// Service is the master patient index: it matches incoming patients
+// against the store and keeps Patient.link in step with merges and links
+type Service struct {
+	config      *config.MPIConfig
+	matchConfig *matching.MatchConfig
+	store       fhirstore.Store
+	matcher     *matching.PatientMatcher
+	reviews     map[string]*Review
+	stats       Stats
+	mu          sync.Mutex
+}
+
+// NewService creates a new MPI service
+func NewService(cfg *config.MPIConfig, store fhirstore.Store) *Service {
+	matchCfg := matching.DefaultMatchConfig()
+	if cfg.AutoLinkThreshold > 0 {
+		matchCfg.AutoLinkThreshold = cfg.AutoLinkThreshold
+	}
+	if cfg.ReviewThreshold > 0 {
+		matchCfg.ReviewThreshold = cfg.ReviewThreshold
+	}
+
+	return &Service{
+		config:      cfg,
+		matchConfig: matchCfg,
+		store:       store,
+		matcher:     matching.NewPatientMatcher(matchCfg),
+		reviews:     make(map[string]*Review),
+	}
+}
+
+// Load indexes every patient already in the store
+func (s *Service) Load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resources, err := s.store.List(ctx, string(models.ResourceTypePatient))
+	if err != nil {
+		return fmt.Errorf("failed to list patients: %w", err)
+	}
+	for _, res := range resources {
+		var patient models.Patient
+		if err := res.Unmarshal(&patient); err != nil {
+			return fmt.Errorf("failed to decode patient %s: %w", res.ID, err)
+		}
+		s.reindex(&patient)
+	}
+	return nil
+}
+
+// Register matches a newly stored patient against the index, linking it,
+// queuing it for review or accepting it as a new record
+func (s *Service) Register(ctx context.Context, patient *models.Patient) (*Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.matcher.FindMatches(ctx, patient)
+	if err != nil {
+		return nil, err
+	}
+	s.reindex(patient)
+
+	decision := &Decision{PatientID: patient.ID, Outcome: OutcomeNewRecord}
+	if result.BestMatch == nil {
+		return decision, nil
+	}
+	decision.Score = result.BestMatch.Score
+
+	switch result.Recommendation {
+	case matching.RecommendationAutoLink:
+		if err := s.link(ctx, patient.ID, result.BestMatch.Patient.ID); err != nil {
+			return nil, err
+		}
+		s.stats.AutoLinked++
+		decision.Outcome = OutcomeLinked
+		decision.LinkedTo = result.BestMatch.Patient.ID
+	case matching.RecommendationManualReview:
+		review := &Review{
+			ID:        uuid.New().String(),
+			PatientID: patient.ID,
+			Status:    ReviewPending,
+			CreatedAt: time.Now(),
+		}
+		for _, c := range result.Candidates {
+			if c.Score < s.matchConfig.ReviewThreshold {
+				break
+			}
+			review.Candidates = append(review.Candidates, ReviewCandidate{
+				PatientID:      c.Patient.ID,
+				Score:          c.Score,
+				ScoreBreakdown: c.ScoreBreakdown,
+				MatchedFields:  c.MatchedFields,
+			})
+		}
+		s.reviews[review.ID] = review
+		decision.Outcome = OutcomeReview
+		decision.ReviewID = review.ID
+	}
+	return decision, nil
+}
+
+// Update refreshes the index after a patient's demographics change
+func (s *Service) Update(patient *models.Patient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reindex(patient)
+}
+
+// FindMatches scores a patient against the index without changing it
+func (s *Service) FindMatches(ctx context.Context, patient *models.Patient) (*matching.MatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.matcher.FindMatches(ctx, patient)
+}
+
+// Merge retires the duplicate record in favour of the survivor
+func (s *Service) Merge(ctx context.Context, survivorID, duplicateID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.merge(ctx, survivorID, duplicateID); err != nil {
+		return err
+	}
+	s.closeReviews(duplicateID, ReviewMerged, "merged into Patient/"+survivorID)
+	return nil
+}
+
+// Link records that two active records belong to the same person
+func (s *Service) Link(ctx context.Context, patientID, otherID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.link(ctx, patientID, otherID); err != nil {
+		return err
+	}
+	s.stats.Linked++
+	return nil
+}
+
+// Unlink removes a link between two records
+func (s *Service) Unlink(ctx context.Context, patientID, otherID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	patient, other, err := s.loadPair(ctx, patientID, otherID)
+	if err != nil {
+		return err
+	}
+	if err := s.matcher.UnlinkPatients(patientID, otherID); err != nil {
+		return err
+	}
+	if err := s.save(ctx, patient, other); err != nil {
+		return err
+	}
+	s.stats.Unlinked++
+	return nil
+}
+
+// SurvivingPatientID returns the record a merged patient now lives on
+func (s *Service) SurvivingPatientID(patientID string) string {
+	return s.matcher.SurvivingPatientID(patientID)
+}
+
+// ListReviews lists reviews, optionally filtered by status, oldest first
+func (s *Service) ListReviews(status ReviewStatus) []*Review {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []*Review
+	for _, review := range s.reviews {
+		if status == "" || review.Status == status {
+			results = append(results, review)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.Before(results[j].CreatedAt)
+	})
+	return results
+}
+
+// GetReview retrieves a review by ID
+func (s *Service) GetReview(id string) (*Review, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	review, ok := s.reviews[id]
+	return review, ok
+}
+
+// ResolveReview applies a data steward's decision. Linking keeps both
+// records active; merging retires the newly registered record in favour
+// of the existing candidate.
+func (s *Service) ResolveReview(ctx context.Context, id string, res *Resolution) (*Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.reviews[id]
+	if !ok {
+		return nil, ErrReviewNotFound
+	}
+	if review.Status != ReviewPending {
+		return nil, fmt.Errorf("review %s is already %s", id, review.Status)
+	}
+
+	candidateID := res.CandidateID
+	if candidateID == "" && len(review.Candidates) > 0 {
+		candidateID = review.Candidates[0].PatientID
+	}
+	if res.Status != ReviewDistinct {
+		found := false
+		for _, c := range review.Candidates {
+			if c.PatientID == candidateID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("patient %s is not a candidate of review %s", candidateID, id)
+		}
+	}
+
+	switch res.Status {
+	case ReviewLinked:
+		if err := s.link(ctx, review.PatientID, candidateID); err != nil {
+			return nil, err
+		}
+		s.stats.Linked++
+	case ReviewMerged:
+		if err := s.merge(ctx, candidateID, review.PatientID); err != nil {
+			return nil, err
+		}
+	case ReviewDistinct:
+		candidateID = ""
+	default:
+		return nil, fmt.Errorf("invalid resolution %q", res.Status)
+	}
+
+	now := time.Now()
+	review.Status = res.Status
+	review.CandidateID = candidateID
+	review.ResolvedAt = &now
+	review.ResolvedBy = res.ResolvedBy
+	review.Note = res.Note
+	return review, nil
+}
+
+// GetStats returns MPI statistics
+func (s *Service) GetStats() *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	for _, review := range s.reviews {
+		if review.Status == ReviewPending {
+			stats.PendingReviews++
+		} else {
+			stats.ResolvedReviews++
+		}
+	}
+	return &stats
+}
+
+func (s *Service) merge(ctx context.Context, survivorID, duplicateID string) error {
+	survivor, duplicate, err := s.loadPair(ctx, survivorID, duplicateID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.matcher.LinkPatients(survivorID, duplicateID); err != nil {
+		return err
+	}
+	if err := s.save(ctx, duplicate, survivor); err != nil {
+		return err
+	}
+	s.stats.Merged++
+	return nil
+}
+
+func (s *Service) link(ctx context.Context, patientID, otherID string) error {
+	patient, other, err := s.loadPair(ctx, patientID, otherID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.matcher.AssociatePatients(patientID, otherID); err != nil {
+		return err
+	}
+	return s.save(ctx, patient, other)
+}
+
+// loadPair reads two patients from the store and indexes those copies so
+// matcher operations modify what is saved back
+func (s *Service) loadPair(ctx context.Context, id1, id2 string) (*models.Patient, *models.Patient, error) {
+	if id1 == id2 {
+		return nil, nil, fmt.Errorf("patient %s cannot be related to itself", id1)
+	}
+	p1, err := s.load(ctx, id1)
+	if err != nil {
+		return nil, nil, err
+	}
+	p2, err := s.load(ctx, id2)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.reindex(p1)
+	s.reindex(p2)
+	return p1, p2, nil
+}
+
+func (s *Service) load(ctx context.Context, id string) (*models.Patient, error) {
+	res, err := s.store.Read(ctx, string(models.ResourceTypePatient), id)
+	if errors.Is(err, fhirstore.ErrNotFound) || errors.Is(err, fhirstore.ErrDeleted) {
+		return nil, fmt.Errorf("%w: %s", ErrPatientNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patient models.Patient
+	if err := res.Unmarshal(&patient); err != nil {
+		return nil, err
+	}
+	return &patient, nil
+}
+
+func (s *Service) save(ctx context.Context, patients ...*models.Patient) error {
+	for _, patient := range patients {
+		if _, err := fhirstore.Save(ctx, s.store, patient); err != nil {
+			return fmt.Errorf("failed to save patient %s: %w", patient.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) reindex(patient *models.Patient) {
+	s.matcher.RemovePatient(patient.ID)
+	s.matcher.IndexPatient(patient)
+}
+
+// closeReviews resolves pending reviews of a patient that was merged away
+func (s *Service) closeReviews(patientID string, status ReviewStatus, note string) {
+	now := time.Now()
+	for _, review := range s.reviews {
+		if review.PatientID == patientID && review.Status == ReviewPending {
+			review.Status = status
+			review.ResolvedAt = &now
+			review.ResolvedBy = "system"
+			review.Note = note
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/savegress/healthsync/internal/config"
+	"github.com/savegress/healthsync/internal/fhirstore"
+	"github.com/savegress/healthsync/pkg/models"
+)
+
+// newPatient returns a patient scoring 0.75 against another with the same
+// name; adding the SSN and email makes it a full match
+func newPatient(mrn string, ssn bool) *models.Patient {
+	patient := &models.Patient{
+		FHIRResource: models.FHIRResource{
+			ResourceType: models.ResourceTypePatient,
+			Identifier:   []models.Identifier{{System: "urn:hosp", Value: mrn}},
+		},
+		Active:    true,
+		Name:      []models.HumanName{{Family: "Johnson", Given: []string{"Robert"}}},
+		BirthDate: "1990-05-15",
+		Gender:    "male",
+		Address:   []models.Address{{Line: []string{"1 Main Street"}, City: "Springfield", PostalCode: "62701"}},
+		Telecom:   []models.ContactPoint{{System: "phone", Value: "555-1234"}},
+	}
+	if ssn {
+		patient.Identifier = append(patient.Identifier, models.Identifier{System: "http://hl7.org/fhir/sid/us-ssn", Value: "123-45-6789"})
+		patient.Telecom = append(patient.Telecom, models.ContactPoint{System: "email", Value: "rj@example.org"})
+	}
+	return patient
+}
+
+func register(t *testing.T, s *Service, store fhirstore.Store, patient *models.Patient) *Decision {
+	t.Helper()
+	if _, err := fhirstore.Save(context.Background(), store, patient); err != nil {
+		t.Fatal(err)
+	}
+	decision, err := s.Register(context.Background(), patient)
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	return decision
+}
+
+func stored(t *testing.T, store fhirstore.Store, id string) *models.Patient {
+	t.Helper()
+	res, err := store.Read(context.Background(), "Patient", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patient models.Patient
+	res.Unmarshal(&patient)
+	return &patient
+}
+
+func TestService_Register(t *testing.T) {
+	store := fhirstore.NewMemoryStore()
+	s := NewService(&config.MPIConfig{}, store)
+
+	first := newPatient("MRN-1", true)
+	if d := register(t, s, store, first); d.Outcome != OutcomeNewRecord {
+		t.Errorf("expected a new record, got %+v", d)
+	}
+
+	// A full match is linked automatically
+	second := newPatient("MRN-2", true)
+	d := register(t, s, store, second)
+	if d.Outcome != OutcomeLinked || d.LinkedTo != first.ID {
+		t.Fatalf("expected an auto-link to %s, got %+v", first.ID, d)
+	}
+	if link := stored(t, store, first.ID).Link; len(link) != 1 || link[0].Type != "seealso" || link[0].Other.Reference != "Patient/"+second.ID {
+		t.Errorf("auto-link should be saved on the existing record: %+v", link)
+	}
+	if !stored(t, store, second.ID).Active {
+		t.Error("auto-linked records should stay active")
+	}
+
+	// A partial match waits for review
+	third := newPatient("MRN-3", false)
+	d = register(t, s, store, third)
+	if d.Outcome != OutcomeReview || d.ReviewID == "" {
+		t.Fatalf("expected a review, got %+v", d)
+	}
+	review, ok := s.GetReview(d.ReviewID)
+	if !ok || review.PatientID != third.ID || len(review.Candidates) != 2 || review.Status != ReviewPending {
+		t.Fatalf("unexpected review %+v", review)
+	}
+
+	// A higher threshold turns the auto-link into a review
+	strict := NewService(&config.MPIConfig{AutoLinkThreshold: 1.1}, store)
+	if err := strict.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := register(t, strict, store, newPatient("MRN-4", true)); d.Outcome != OutcomeReview {
+		t.Errorf("expected a review above the configured threshold, got %+v", d)
+	}
+
+	stats := s.GetStats()
+	if stats.AutoLinked != 1 || stats.PendingReviews != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestService_ResolveReview(t *testing.T) {
+	ctx := context.Background()
+	store := fhirstore.NewMemoryStore()
+	s := NewService(&config.MPIConfig{}, store)
+
+	existing := newPatient("MRN-1", false)
+	register(t, s, store, existing)
+	incoming := newPatient("MRN-2", false)
+	d := register(t, s, store, incoming)
+	if d.Outcome != OutcomeReview {
+		t.Fatalf("expected a review, got %+v", d)
+	}
+
+	if _, err := s.ResolveReview(ctx, d.ReviewID, &Resolution{Status: ReviewMerged, CandidateID: "other"}); err == nil {
+		t.Error("expected an error for a patient that is not a candidate")
+	}
+	if _, err := s.ResolveReview(ctx, "missing", &Resolution{Status: ReviewDistinct}); !errors.Is(err, ErrReviewNotFound) {
+		t.Errorf("expected ErrReviewNotFound, got %v", err)
+	}
+
+	review, err := s.ResolveReview(ctx, d.ReviewID, &Resolution{Status: ReviewMerged, ResolvedBy: "steward"})
+	if err != nil {
+		t.Fatalf("ResolveReview failed: %v", err)
+	}
+	if review.Status != ReviewMerged || review.CandidateID != existing.ID || review.ResolvedAt == nil {
+		t.Errorf("unexpected review %+v", review)
+	}
+	merged := stored(t, store, incoming.ID)
+	if merged.Active || len(merged.Link) != 1 || merged.Link[0].Type != "replaced-by" {
+		t.Errorf("the reviewed record should be merged into the candidate: %+v", merged)
+	}
+	if got := s.SurvivingPatientID(incoming.ID); got != existing.ID {
+		t.Errorf("expected survivor %s, got %s", existing.ID, got)
+	}
+
+	if _, err := s.ResolveReview(ctx, d.ReviewID, &Resolution{Status: ReviewDistinct}); err == nil {
+		t.Error("expected an error resolving a review twice")
+	}
+	if pending := s.ListReviews(ReviewPending); len(pending) != 0 {
+		t.Errorf("expected no pending reviews, got %d", len(pending))
+	}
+}
+
+func TestService_MergeClosesReviews(t *testing.T) {
+	ctx := context.Background()
+	store := fhirstore.NewMemoryStore()
+	s := NewService(&config.MPIConfig{}, store)
+
+	existing := newPatient("MRN-1", false)
+	register(t, s, store, existing)
+	incoming := newPatient("MRN-2", false)
+	d := register(t, s, store, incoming)
+
+	if err := s.Merge(ctx, existing.ID, incoming.ID); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if review, _ := s.GetReview(d.ReviewID); review.Status != ReviewMerged || review.ResolvedBy != "system" {
+		t.Errorf("merging the patient should close its review: %+v", review)
+	}
+	if err := s.Merge(ctx, existing.ID, "missing"); !errors.Is(err, ErrPatientNotFound) {
+		t.Errorf("expected ErrPatientNotFound, got %v", err)
+	}
+}
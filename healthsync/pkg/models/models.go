@@ -21,6 +21,10 @@ const (
 	ResourceTypeImmunization     ResourceType = "Immunization"
 	ResourceTypeAllergyIntolerance ResourceType = "AllergyIntolerance"
 	ResourceTypeDocumentReference ResourceType = "DocumentReference"
+	ResourceTypeServiceRequest   ResourceType = "ServiceRequest"
+	ResourceTypeBundle           ResourceType = "Bundle"
+	ResourceTypeOperationOutcome ResourceType = "OperationOutcome"
+	ResourceTypeCapabilityStatement ResourceType = "CapabilityStatement"
 )
 
 // FHIRResource represents a base FHIR resource
@@ -144,6 +148,13 @@ type Patient struct {
 	Communication    []PatientCommunication `json:"communication,omitempty"`
 	GeneralPractitioner []Reference  `json:"generalPractitioner,omitempty"`
 	ManagingOrganization *Reference  `json:"managingOrganization,omitempty"`
+	Link             []PatientLink   `json:"link,omitempty"`
+}
+
+// PatientLink links a patient to another record of the same person
+type PatientLink struct {
+	Other *Reference `json:"other"`
+	Type  string     `json:"type"` // replaced-by, replaces, refer, seealso
 }
 
 // PatientContact represents a contact person for a patient
@@ -178,6 +189,8 @@ type Observation struct {
 	ValueString     string           `json:"valueString,omitempty"`
 	ValueBoolean    *bool            `json:"valueBoolean,omitempty"`
 	ValueCodeableConcept *CodeableConcept `json:"valueCodeableConcept,omitempty"`
+	ValueDateTime   string           `json:"valueDateTime,omitempty"`
+	ValueTime       string           `json:"valueTime,omitempty"`
 	Interpretation  []CodeableConcept `json:"interpretation,omitempty"`
 	Note            []Annotation     `json:"note,omitempty"`
 	ReferenceRange  []ObservationReferenceRange `json:"referenceRange,omitempty"`
@@ -229,6 +242,7 @@ type Encounter struct {
 	Period           *Period           `json:"period,omitempty"`
 	ReasonCode       []CodeableConcept `json:"reasonCode,omitempty"`
 	Diagnosis        []EncounterDiagnosis `json:"diagnosis,omitempty"`
+	Location         []EncounterLocation `json:"location,omitempty"`
 	ServiceProvider  *Reference        `json:"serviceProvider,omitempty"`
 }
 
@@ -246,6 +260,107 @@ type EncounterDiagnosis struct {
 	Rank      int              `json:"rank,omitempty"`
 }
 
+// EncounterLocation represents a location the patient was at during an encounter
+type EncounterLocation struct {
+	Location *Reference `json:"location"`
+	Status   string     `json:"status,omitempty"`
+	Period   *Period    `json:"period,omitempty"`
+}
+
+// DiagnosticReport represents a FHIR DiagnosticReport resource
+type DiagnosticReport struct {
+	FHIRResource
+	BasedOn           []Reference       `json:"basedOn,omitempty"`
+	Status            string            `json:"status"`
+	Category          []CodeableConcept `json:"category,omitempty"`
+	Code              *CodeableConcept  `json:"code"`
+	Subject           *Reference        `json:"subject,omitempty"`
+	Encounter         *Reference        `json:"encounter,omitempty"`
+	EffectiveDateTime *time.Time        `json:"effectiveDateTime,omitempty"`
+	Issued            *time.Time        `json:"issued,omitempty"`
+	Performer         []Reference       `json:"performer,omitempty"`
+	Result            []Reference       `json:"result,omitempty"`
+	Conclusion        string            `json:"conclusion,omitempty"`
+}
+
+// ServiceRequest represents a FHIR ServiceRequest resource
+type ServiceRequest struct {
+	FHIRResource
+	Status     string            `json:"status"`
+	Intent     string            `json:"intent"`
+	Category   []CodeableConcept `json:"category,omitempty"`
+	Priority   string            `json:"priority,omitempty"`
+	Code       *CodeableConcept  `json:"code,omitempty"`
+	Subject    *Reference        `json:"subject"`
+	Encounter  *Reference        `json:"encounter,omitempty"`
+	AuthoredOn *time.Time        `json:"authoredOn,omitempty"`
+	Requester  *Reference        `json:"requester,omitempty"`
+	ReasonCode []CodeableConcept `json:"reasonCode,omitempty"`
+	Note       []Annotation      `json:"note,omitempty"`
+}
+
+// Condition represents a FHIR Condition resource
+type Condition struct {
+	FHIRResource
+	ClinicalStatus     *CodeableConcept  `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept  `json:"verificationStatus,omitempty"`
+	Category           []CodeableConcept `json:"category,omitempty"`
+	Severity           *CodeableConcept  `json:"severity,omitempty"`
+	Code               *CodeableConcept  `json:"code,omitempty"`
+	Subject            *Reference        `json:"subject"`
+	Encounter          *Reference        `json:"encounter,omitempty"`
+	OnsetDateTime      *time.Time        `json:"onsetDateTime,omitempty"`
+	RecordedDate       *time.Time        `json:"recordedDate,omitempty"`
+	Asserter           *Reference        `json:"asserter,omitempty"`
+	Note               []Annotation      `json:"note,omitempty"`
+}
+
+// AllergyIntolerance represents a FHIR AllergyIntolerance resource
+type AllergyIntolerance struct {
+	FHIRResource
+	ClinicalStatus     *CodeableConcept  `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept  `json:"verificationStatus,omitempty"`
+	Type               string            `json:"type,omitempty"`
+	Category           []string          `json:"category,omitempty"`
+	Criticality        string            `json:"criticality,omitempty"`
+	Code               *CodeableConcept  `json:"code,omitempty"`
+	Patient            *Reference        `json:"patient"`
+	RecordedDate       *time.Time        `json:"recordedDate,omitempty"`
+	Reaction           []AllergyReaction `json:"reaction,omitempty"`
+}
+
+// AllergyReaction represents an adverse reaction to an allergen
+type AllergyReaction struct {
+	Substance     *CodeableConcept  `json:"substance,omitempty"`
+	Manifestation []CodeableConcept `json:"manifestation"`
+	Severity      string            `json:"severity,omitempty"`
+}
+
+// Immunization represents a FHIR Immunization resource
+type Immunization struct {
+	FHIRResource
+	Status             string                  `json:"status"`
+	StatusReason       *CodeableConcept        `json:"statusReason,omitempty"`
+	VaccineCode        *CodeableConcept        `json:"vaccineCode"`
+	Patient            *Reference              `json:"patient"`
+	Encounter          *Reference              `json:"encounter,omitempty"`
+	OccurrenceDateTime *time.Time              `json:"occurrenceDateTime,omitempty"`
+	Recorded           *time.Time              `json:"recorded,omitempty"`
+	PrimarySource      *bool                   `json:"primarySource,omitempty"`
+	Manufacturer       *Reference              `json:"manufacturer,omitempty"`
+	LotNumber          string                  `json:"lotNumber,omitempty"`
+	ExpirationDate     string                  `json:"expirationDate,omitempty"`
+	DoseQuantity       *Quantity               `json:"doseQuantity,omitempty"`
+	Performer          []ImmunizationPerformer `json:"performer,omitempty"`
+	Note               []Annotation            `json:"note,omitempty"`
+}
+
+// ImmunizationPerformer represents who administered a vaccine
+type ImmunizationPerformer struct {
+	Function *CodeableConcept `json:"function,omitempty"`
+	Actor    *Reference       `json:"actor"`
+}
+
 // Consent represents patient consent for data sharing
 type Consent struct {
 	FHIRResource
@@ -350,6 +465,135 @@ type AuditEventDetail struct {
 	ValueBase64Binary string `json:"valueBase64Binary,omitempty"`
 }
 
+// Bundle represents a FHIR Bundle such as a search result or history
+type Bundle struct {
+	ResourceType ResourceType  `json:"resourceType"`
+	ID           string        `json:"id,omitempty"`
+	Meta         *ResourceMeta `json:"meta,omitempty"`
+	Type         string        `json:"type"`
+	Timestamp    *time.Time    `json:"timestamp,omitempty"`
+	Total        *int          `json:"total,omitempty"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+// BundleLink represents a paging or self link
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+// BundleEntry represents one resource in a bundle
+type BundleEntry struct {
+	FullURL  string               `json:"fullUrl,omitempty"`
+	Resource interface{}          `json:"resource,omitempty"`
+	Search   *BundleEntrySearch   `json:"search,omitempty"`
+	Request  *BundleEntryRequest  `json:"request,omitempty"`
+	Response *BundleEntryResponse `json:"response,omitempty"`
+}
+
+// BundleEntrySearch records why an entry is in a search result
+type BundleEntrySearch struct {
+	Mode string `json:"mode,omitempty"` // match or include
+}
+
+// BundleEntryRequest represents the interaction an entry records
+type BundleEntryRequest struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	IfNoneMatch string `json:"ifNoneMatch,omitempty"`
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneExist string `json:"ifNoneExist,omitempty"`
+}
+
+// BundleEntryResponse represents the outcome of an entry's interaction
+type BundleEntryResponse struct {
+	Status       string      `json:"status"`
+	Location     string      `json:"location,omitempty"`
+	Etag         string      `json:"etag,omitempty"`
+	LastModified *time.Time  `json:"lastModified,omitempty"`
+	Outcome      interface{} `json:"outcome,omitempty"`
+}
+
+// OperationOutcome reports errors and warnings from a FHIR interaction
+type OperationOutcome struct {
+	ResourceType ResourceType            `json:"resourceType"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+// OperationOutcomeIssue represents a single error or warning
+type OperationOutcomeIssue struct {
+	Severity    string   `json:"severity"` // fatal, error, warning, information
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+// CapabilityStatement describes what a FHIR server supports
+type CapabilityStatement struct {
+	ResourceType   ResourceType              `json:"resourceType"`
+	Status         string                    `json:"status"`
+	Date           time.Time                 `json:"date"`
+	Kind           string                    `json:"kind"`
+	Software       *CapabilitySoftware       `json:"software,omitempty"`
+	Implementation *CapabilityImplementation `json:"implementation,omitempty"`
+	FHIRVersion    string                    `json:"fhirVersion"`
+	Format         []string                  `json:"format"`
+	Rest           []CapabilityRest          `json:"rest,omitempty"`
+}
+
+// CapabilitySoftware identifies the server software
+type CapabilitySoftware struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// CapabilityImplementation identifies the server instance
+type CapabilityImplementation struct {
+	Description string `json:"description"`
+	URL         string `json:"url,omitempty"`
+}
+
+// CapabilityRest describes the RESTful interface
+type CapabilityRest struct {
+	Mode        string                  `json:"mode"`
+	Resource    []CapabilityResource    `json:"resource"`
+	Interaction []CapabilityInteraction `json:"interaction,omitempty"`
+	Operation   []CapabilityOperation   `json:"operation,omitempty"`
+}
+
+// CapabilityResource describes what is supported for one resource type
+type CapabilityResource struct {
+	Type              string                  `json:"type"`
+	Interaction       []CapabilityInteraction `json:"interaction"`
+	Versioning        string                  `json:"versioning,omitempty"`
+	ReadHistory       bool                    `json:"readHistory"`
+	UpdateCreate      bool                    `json:"updateCreate"`
+	ConditionalCreate bool                    `json:"conditionalCreate"`
+	ConditionalUpdate bool                    `json:"conditionalUpdate"`
+	SearchInclude     []string                `json:"searchInclude,omitempty"`
+	SearchRevInclude  []string                `json:"searchRevInclude,omitempty"`
+	SearchParam       []CapabilitySearchParam `json:"searchParam,omitempty"`
+	Operation         []CapabilityOperation   `json:"operation,omitempty"`
+}
+
+// CapabilityInteraction names a supported interaction
+type CapabilityInteraction struct {
+	Code string `json:"code"`
+}
+
+// CapabilitySearchParam describes a supported search parameter
+type CapabilitySearchParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// CapabilityOperation names a supported operation and its definition
+type CapabilityOperation struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
 // PHIField represents a field containing Protected Health Information
 type PHIField struct {
 	FieldName   string `json:"field_name"`